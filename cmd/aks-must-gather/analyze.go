@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/spf13/cobra"
+	"kubectl-must-gather/pkg/mustgather"
+)
+
+var (
+	analyzeQuery    string
+	analyzeProvider string
+)
+
+var analyzeCmd = &cobra.Command{
+	Use:   "analyze <bundle.tar.gz>",
+	Short: "Ask AI questions about an existing must-gather bundle",
+	Long: `analyze answers a natural language question from the logs, events and table rows
+already in a must-gather bundle, without contacting Azure. A bundle gathered once
+can be investigated later, or by someone without access to the workspace.`,
+	Example: `  aks-must-gather analyze must-gather-20240101-120000.tar.gz --ai-mode "why did pod web-7d9f crash"`,
+	Args:    cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		question := strings.TrimSpace(analyzeQuery)
+		if question == "" {
+			return fmt.Errorf("must provide a question with --ai-mode")
+		}
+
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		analysis, err := mustgather.Analyze(ctx, args[0], question, analyzeProvider, logOutput())
+		if err != nil {
+			return err
+		}
+
+		fmt.Println(strings.Repeat("=", 80))
+		fmt.Println("AI ANALYSIS")
+		fmt.Println(strings.Repeat("=", 80))
+		fmt.Println(analysis)
+		fmt.Println(strings.Repeat("=", 80))
+		return nil
+	},
+}
+
+func init() {
+	analyzeCmd.Flags().StringVar(&analyzeQuery, "ai-mode", "", "Natural language question to answer from the bundle (e.g., --ai-mode \"why did pod X crash\")")
+	analyzeCmd.Flags().StringVar(&analyzeProvider, "ai-provider", "", "LLM backend: claude-cli, anthropic, openai or azure-openai (default: detected from API key env vars, else claude-cli)")
+	rootCmd.AddCommand(analyzeCmd)
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+	"github.com/spf13/cobra"
+	"kubectl-must-gather/pkg/mustgather"
+)
+
+var (
+	controllerOpts        mustgather.ControllerOptions
+	controllerMetricsAddr string
+)
+
+var controllerCmd = &cobra.Command{
+	Use:   "controller",
+	Short: "Run gathers requested by MustGather resources in the cluster",
+	Long: `controller runs in a pod of an AKS cluster and serves MustGather custom
+resources (deploy/mustgather-crd.yaml): for each new one it gathers the
+workspace, timespan, profiles and tables of its spec with the workload identity
+of the pod's service account, writes the archive under --output-dir and
+uploads it to the blob container of spec.destination. Progress and outcome are
+reported in the resource's status and conditions and as Events on it. Gathers
+run one at a time until the controller is stopped.`,
+	Example: `  aks-must-gather controller --output-dir /data
+  aks-must-gather controller --namespace diagnostics --output-dir /data`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cred, err := azidentity.NewWorkloadIdentityCredential(nil)
+		if err != nil {
+			return fmt.Errorf("the controller needs workload identity on the pod's service account: %w", err)
+		}
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		opts := controllerOpts
+		opts.Config = &mustgather.Config{Flags: map[string]string{}}
+		opts.Environment = &mustgather.Environment{Credential: cred}
+		if controllerMetricsAddr != "" {
+			opts.Config.Metrics = mustgather.NewMetrics()
+			if err := serveMetrics(ctx, controllerMetricsAddr, opts.Config.Metrics); err != nil {
+				return err
+			}
+		}
+		return mustgather.RunController(ctx, opts)
+	},
+}
+
+func init() {
+	f := controllerCmd.Flags()
+	f.StringVarP(&controllerOpts.Namespace, "namespace", "n", "", "Serve only the MustGather resources of this namespace (default: all namespaces)")
+	f.StringVar(&controllerOpts.OutputDir, "output-dir", ".", "Directory the archives are written to, in a directory per namespace")
+	f.StringVar(&controllerMetricsAddr, "metrics-addr", "", "Serve Prometheus metrics of the gathers at http://<addr>/metrics, e.g. :9090")
+	rootCmd.AddCommand(controllerCmd)
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"kubectl-must-gather/pkg/mustgather"
+)
+
+var convertOpts mustgather.ConvertOptions
+
+var convertCmd = &cobra.Command{
+	Use:   "convert <bundle>",
+	Short: "Rewrite the table data of a bundle as CSV, Parquet or SQLite",
+	Long: `convert rewrites the NDJSON table data of a bundle (archive or extracted
+directory) for analysis tools, without querying the workspace again: one CSV
+or Parquet file per table, or one SQLite database with a table per exported
+table. Columns keep the order and types of the query result; datetimes stay
+RFC 3339 in UTC and dynamic values JSON text. Parquet needs duckdb and SQLite
+needs sqlite3 on PATH.`,
+	Example: `  aks-must-gather convert must-gather-20240101-120000.tar.gz --to parquet
+  aks-must-gather convert must-gather-20240101-120000.tar.gz --to sqlite --out incident
+  sqlite3 incident/tables.sqlite 'select Reason, count(*) from KubeEvents group by 1'`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		opts := convertOpts
+		if opts.OutDir == "" {
+			opts.OutDir = strings.TrimSuffix(strings.TrimSuffix(args[0], ".gz"), ".tar") + "-" + opts.Format
+		}
+		stats, err := mustgather.Convert(args[0], opts)
+		if err != nil {
+			return err
+		}
+		where := opts.OutDir
+		if len(stats.Files) == 1 {
+			where = filepath.Join(opts.OutDir, stats.Files[0])
+		}
+		fmt.Fprintf(os.Stderr, "Converted %d table(s), %d row(s), to %s\n", stats.Tables, stats.Rows, where)
+		return nil
+	},
+}
+
+func init() {
+	f := convertCmd.Flags()
+	f.StringVar(&convertOpts.Format, "to", mustgather.ConvertCSV, "Format to convert to: csv, parquet or sqlite")
+	f.StringVar(&convertOpts.OutDir, "out", "", "Directory to write to (default: <bundle>-<format>)")
+	rootCmd.AddCommand(convertCmd)
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"kubectl-must-gather/pkg/mustgather"
+)
+
+var diffFormat string
+
+var diffCmd = &cobra.Command{
+	Use:   "diff <before> <after>",
+	Short: "Compare the inventory, restarts, events and images of two bundles",
+	Long: `diff compares two bundles (archives or extracted directories), e.g. gathered
+before and after an upgrade, and reports what changed: pods, nodes and services
+added or removed and their status, node kubelet versions, containers that
+restarted more, the number of events per type and reason, and the images of
+each workload's containers. Inventory is compared as of the end of each
+gather's window.`,
+	Example: `  aks-must-gather diff before-upgrade.tar.gz after-upgrade.tar.gz
+  aks-must-gather diff monday.tar.gz tuesday.tar.gz --format json | jq .images`,
+	Args: cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if diffFormat != "text" && diffFormat != "json" {
+			return fmt.Errorf("invalid --format %q: expected text or json", diffFormat)
+		}
+		d, err := mustgather.DiffBundles(args[0], args[1])
+		if err != nil {
+			return err
+		}
+		if diffFormat == "json" {
+			b, _ := json.MarshalIndent(d, "", "  ")
+			fmt.Println(string(b))
+			return nil
+		}
+		return d.Render(os.Stdout)
+	},
+}
+
+func init() {
+	diffCmd.Flags().StringVar(&diffFormat, "format", "text", "Output format: text or json")
+	rootCmd.AddCommand(diffCmd)
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+// envPrefix prefixes the environment variables that set flags: --workspace-id
+// is AKS_MG_WORKSPACE_ID, --timespan AKS_MG_TIMESPAN. A flag given on the
+// command line wins over its variable.
+const envPrefix = "AKS_MG_"
+
+// envName returns the environment variable of the flag named name.
+func envName(name string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+// bindEnv makes every flag of cmd and its subcommands settable from its
+// environment variable, and names the variable in the flag's usage.
+func bindEnv(cmd *cobra.Command) {
+	cmd.PersistentPreRunE = func(cmd *cobra.Command, args []string) error {
+		if err := applyEnv(cmd.Flags()); err != nil {
+			return err
+		}
+		return applyConfigDir(cmd.Flags())
+	}
+	var annotate func(c *cobra.Command)
+	annotate = func(c *cobra.Command) {
+		for _, fs := range []*pflag.FlagSet{c.LocalNonPersistentFlags(), c.PersistentFlags()} {
+			fs.VisitAll(func(f *pflag.Flag) {
+				if bindsEnv(f) && !strings.HasSuffix(f.Usage, "]") {
+					f.Usage += " [$" + envName(f.Name) + "]"
+				}
+			})
+		}
+		for _, sub := range c.Commands() {
+			annotate(sub)
+		}
+	}
+	annotate(cmd)
+}
+
+func bindsEnv(f *pflag.Flag) bool {
+	return f.Name != "help" && f.Name != "version"
+}
+
+// applyEnv sets the flags of fs not given on the command line from their
+// environment variables.
+func applyEnv(fs *pflag.FlagSet) error {
+	var errs []error
+	fs.VisitAll(func(f *pflag.Flag) {
+		if f.Changed || !bindsEnv(f) {
+			return
+		}
+		v, ok := os.LookupEnv(envName(f.Name))
+		if !ok {
+			return
+		}
+		if err := fs.Set(f.Name, v); err != nil {
+			errs = append(errs, fmt.Errorf("invalid %s=%q for --%s: %w", envName(f.Name), v, f.Name, err))
+		}
+	})
+	return errors.Join(errs...)
+}
+
+// applyConfigDir sets the flags of fs given neither on the command line nor
+// in the environment from the files of --config-dir, e.g. a mounted
+// ConfigMap: the file timespan sets --timespan. Each line of a file sets a
+// repeatable flag such as --encrypt-to once.
+func applyConfigDir(fs *pflag.FlagSet) error {
+	f := fs.Lookup("config-dir")
+	if f == nil || f.Value.String() == "" {
+		return nil
+	}
+	dir := f.Value.String()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return fmt.Errorf("read --config-dir: %w", err)
+	}
+	var errs []error
+	for _, e := range entries {
+		// ConfigMap volumes keep their data in ..data and dated directories
+		if e.IsDir() || strings.HasPrefix(e.Name(), ".") {
+			continue
+		}
+		flag := fs.Lookup(e.Name())
+		if flag == nil || !bindsEnv(flag) || flag.Name == "config-dir" {
+			errs = append(errs, fmt.Errorf("--config-dir: %s does not name a flag", filepath.Join(dir, e.Name())))
+			continue
+		}
+		if flag.Changed {
+			continue
+		}
+		b, err := os.ReadFile(filepath.Join(dir, e.Name()))
+		if err != nil {
+			errs = append(errs, fmt.Errorf("--config-dir: %w", err))
+			continue
+		}
+		values := []string{strings.TrimSpace(string(b))}
+		if strings.HasSuffix(flag.Value.Type(), "Array") {
+			values = nil
+			for _, line := range strings.Split(string(b), "\n") {
+				if line = strings.TrimSpace(line); line != "" {
+					values = append(values, line)
+				}
+			}
+		}
+		for _, v := range values {
+			if err := fs.Set(flag.Name, v); err != nil {
+				errs = append(errs, fmt.Errorf("invalid %s=%q for --%s: %w", filepath.Join(dir, e.Name()), v, flag.Name, err))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
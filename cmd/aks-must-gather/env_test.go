@@ -0,0 +1,124 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/spf13/cobra"
+)
+
+func TestBindEnv(t *testing.T) {
+	var workspace, timespan string
+	var stitch bool
+	var tables []string
+	newCmd := func() *cobra.Command {
+		workspace, timespan, stitch, tables = "", "", false, nil
+		root := &cobra.Command{Use: "root", RunE: func(*cobra.Command, []string) error { return nil }}
+		root.Flags().StringVar(&workspace, "workspace-id", "", "Workspace")
+		root.Flags().StringVar(&timespan, "timespan", "PT2H", "Timespan")
+		root.Flags().BoolVar(&stitch, "stitch-logs", false, "Stitch")
+		sub := &cobra.Command{Use: "sub", RunE: func(*cobra.Command, []string) error { return nil }}
+		sub.Flags().StringSliceVar(&tables, "tables", nil, "Tables")
+		root.AddCommand(sub)
+		bindEnv(root)
+		return root
+	}
+
+	t.Setenv("AKS_MG_WORKSPACE_ID", "/subscriptions/s/ws")
+	t.Setenv("AKS_MG_TIMESPAN", "PT6H")
+	t.Setenv("AKS_MG_STITCH_LOGS", "true")
+	t.Setenv("AKS_MG_TABLES", "Heartbeat,KubeEvents")
+
+	cmd := newCmd()
+	cmd.SetArgs([]string{"--timespan", "PT1H"})
+	if err := cmd.Execute(); err != nil {
+		t.Fatalf("Execute failed: %v", err)
+	}
+	// The command line wins over the environment
+	if workspace != "/subscriptions/s/ws" || timespan != "PT1H" || !stitch {
+		t.Errorf("got workspace %q timespan %q stitch %v", workspace, timespan, stitch)
+	}
+	if usage := cmd.Flags().Lookup("workspace-id").Usage; !strings.HasSuffix(usage, "[$AKS_MG_WORKSPACE_ID]") {
+		t.Errorf("usage does not name the variable: %q", usage)
+	}
+
+	cmd = newCmd()
+	cmd.SetArgs([]string{"sub"})
+	if err := cmd.Execute(); err != nil {
+		t.Fatalf("Execute sub failed: %v", err)
+	}
+	if strings.Join(tables, ",") != "Heartbeat,KubeEvents" {
+		t.Errorf("subcommand flag not set from the environment: %v", tables)
+	}
+
+	t.Setenv("AKS_MG_STITCH_LOGS", "maybe")
+	cmd = newCmd()
+	cmd.SetArgs([]string{})
+	cmd.SilenceUsage, cmd.SilenceErrors = true, true
+	if err := cmd.Execute(); err == nil || !strings.Contains(err.Error(), "AKS_MG_STITCH_LOGS") {
+		t.Errorf("expected an invalid variable error, got %v", err)
+	}
+}
+
+func TestApplyConfigDir(t *testing.T) {
+	var configDir, timespan, tables string
+	var recipients []string
+	var watch bool
+	newCmd := func() *cobra.Command {
+		configDir, timespan, tables, recipients, watch = "", "", "", nil, false
+		root := &cobra.Command{Use: "root", RunE: func(*cobra.Command, []string) error { return nil }}
+		root.Flags().StringVar(&configDir, "config-dir", "", "Config")
+		root.Flags().StringVar(&timespan, "timespan", "PT2H", "Timespan")
+		root.Flags().StringVar(&tables, "tables", "", "Tables")
+		root.Flags().StringArrayVar(&recipients, "encrypt-to", nil, "Recipients")
+		root.Flags().BoolVar(&watch, "watch", false, "Watch")
+		root.SilenceUsage, root.SilenceErrors = true, true
+		bindEnv(root)
+		return root
+	}
+
+	// Laid out like a mounted ConfigMap
+	dir := t.TempDir()
+	files := map[string]string{
+		"timespan":   "PT6H\n",
+		"tables":     "Heartbeat,KubeEvents",
+		"encrypt-to": "age1abc\nssh-ed25519 AAAAC3Nz user@host\n",
+		"watch":      "true",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Mkdir(filepath.Join(dir, "..2026_01_02_03_04_05.123"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "..data"), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("AKS_MG_TABLES", "Perf")
+	cmd := newCmd()
+	cmd.SetArgs([]string{"--config-dir", dir, "--watch=false"})
+	if err := cmd.Execute(); err != nil {
+		t.Fatalf("Execute failed: %v", err)
+	}
+	// The command line and the environment win over the files
+	if timespan != "PT6H" || tables != "Perf" || watch {
+		t.Errorf("got timespan %q tables %q watch %v", timespan, tables, watch)
+	}
+	if strings.Join(recipients, "|") != "age1abc|ssh-ed25519 AAAAC3Nz user@host" {
+		t.Errorf("recipients = %q", recipients)
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "timepsan"), []byte("PT1H"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cmd = newCmd()
+	cmd.SetArgs([]string{"--config-dir", dir})
+	if err := cmd.Execute(); err == nil || !strings.Contains(err.Error(), "timepsan does not name a flag") {
+		t.Errorf("expected an unknown flag error, got %v", err)
+	}
+}
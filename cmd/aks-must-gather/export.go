@@ -0,0 +1,34 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+var exportCmd = &cobra.Command{
+	Use:   "export",
+	Short: "Send the logs of a must-gather bundle to a log backend",
+	Long: `export replays the logs of a bundle into a log backend, so a gather can be
+explored with the backend's own tools during an incident review.`,
+}
+
+// parseKeyValues parses key=value pairs, as given to --header and --label.
+func parseKeyValues(flag string, pairs []string, into map[string]string) error {
+	for _, kv := range pairs {
+		if strings.TrimSpace(kv) == "" {
+			continue
+		}
+		k, v, ok := strings.Cut(kv, "=")
+		if !ok || strings.TrimSpace(k) == "" {
+			return fmt.Errorf("invalid --%s %q: expected key=value", flag, kv)
+		}
+		into[strings.TrimSpace(k)] = strings.TrimSpace(v)
+	}
+	return nil
+}
+
+func init() {
+	rootCmd.AddCommand(exportCmd)
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"kubectl-must-gather/pkg/mustgather"
+	"kubectl-must-gather/pkg/utils"
+)
+
+var extractOpts mustgather.ExtractOptions
+
+var extractCmd = &cobra.Command{
+	Use:   "extract <bundle>",
+	Short: "Extract only the files of a bundle matching paths and a time window",
+	Long: `extract copies the files of a bundle (archive or extracted directory) that
+match --path to a directory, reading the archive as a stream instead of
+unpacking all of it. --path is a glob where ** matches any number of
+directories, and may be repeated. With --since and --until, stitched logs are
+cut to the lines within the window and table parts outside it are left out.
+With --where, a KQL-like expression over the columns of table rows (see
+aks-must-gather grep --help), table parts are cut to the rows matching it.`,
+	Example: `  aks-must-gather extract must-gather-20240101-120000.tar.gz --path 'namespaces/payments/**'
+  aks-must-gather extract must-gather-20240101-120000.tar.gz --path 'namespaces/*/pods/cart-*' --path analysis \
+    --since 2024-01-01T11:50 --until 2024-01-01T12:05 --out incident
+  aks-must-gather extract must-gather-20240101-120000.tar.gz --path tables/ContainerLogV2 \
+    --where 'PodNamespace == "payments" and LogLevel =~ "error"'`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		opts := extractOpts
+		if opts.OutDir == "" {
+			opts.OutDir = strings.TrimSuffix(strings.TrimSuffix(args[0], ".gz"), ".tar") + "-extract"
+		}
+		stats, err := mustgather.Extract(args[0], opts)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "Extracted %d file(s), %s, to %s", stats.Files, utils.FormatByteSize(stats.Bytes), opts.OutDir)
+		if stats.Trimmed > 0 || stats.Skipped > 0 {
+			fmt.Fprintf(os.Stderr, "; %d cut to the window or --where, %d with nothing left out", stats.Trimmed, stats.Skipped)
+		}
+		fmt.Fprintln(os.Stderr)
+		return nil
+	},
+}
+
+func init() {
+	f := extractCmd.Flags()
+	f.StringArrayVar(&extractOpts.Paths, "path", nil, "Glob of the files to extract, e.g. 'namespaces/payments/**'; a directory extracts all of it; repeat for several (default: all files)")
+	f.StringVar(&extractOpts.Since, "since", "", "Cut stitched logs to lines from this time, and leave out table parts ending before it (RFC 3339, or UTC like 2024-01-01T15:04)")
+	f.StringVar(&extractOpts.Until, "until", "", "Cut stitched logs to lines before this time, and leave out table parts starting after it (RFC 3339, or UTC like 2024-01-01T15:04)")
+	f.StringVar(&extractOpts.Where, "where", "", `Keep only the table rows matching this expression, e.g. 'PodNamespace == "payments" and LogLevel =~ "error"'`)
+	f.StringVar(&extractOpts.OutDir, "out", "", "Directory to extract to (default: <bundle>-extract)")
+	rootCmd.AddCommand(extractCmd)
+}
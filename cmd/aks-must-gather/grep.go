@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"kubectl-must-gather/pkg/mustgather"
+)
+
+var grepOpts mustgather.GrepOptions
+
+var grepCmd = &cobra.Command{
+	Use:   "grep <bundle> <expression>",
+	Short: "Print the table rows of a bundle matching a column expression",
+	Long: `grep prints the NDJSON rows of the exported tables of a bundle (archive or
+extracted directory) that match a KQL-like expression over their columns,
+unchanged, so they can be piped to jq or another tool. The archive is read as
+a stream.
+
+An expression compares columns with values, combined with and, or, not and
+parentheses:
+  ==, !=          equal, not equal (numbers as numbers, times as times)
+  =~, !~          equal, not equal ignoring case
+  <, <=, >, >=    order of numbers, times or text
+  contains, startswith, endswith, and !contains, !startswith, !endswith
+                  text, ignoring case
+  matches regex   a regular expression
+  in, !in         one of a list like ("a", "b")
+Values are quoted strings, numbers, true, false, null or datetime(...). A
+column a row does not have is null. Rows of different tables have different
+columns; --table limits the search to tables matching a glob.`,
+	Example: `  aks-must-gather grep must-gather-20240101-120000.tar.gz 'PodNamespace == "payments" and LogLevel =~ "error"' --table ContainerLogV2
+  aks-must-gather grep ./must-gather 'Reason in ("BackOff", "OOMKilling") and TimeGenerated >= datetime(2024-01-01T11:50)' --table KubeEvents
+  aks-must-gather grep ./must-gather 'LogMessage matches regex "timeout|refused"' -m 20 | jq -r .LogMessage`,
+	Args: cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		opts := grepOpts
+		opts.Where = args[1]
+		stats, err := mustgather.Grep(args[0], opts, os.Stdout)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "%d of %d row(s) in %d table(s) match\n", stats.Matched, stats.Rows, stats.Tables)
+		return nil
+	},
+}
+
+func init() {
+	f := grepCmd.Flags()
+	f.StringArrayVar(&grepOpts.Tables, "table", nil, "Glob of the tables to search, e.g. 'Kube*'; repeat for several (default: all tables)")
+	f.IntVarP(&grepOpts.MaxCount, "max-count", "m", 0, "Stop after this many matching rows (default: no limit)")
+	rootCmd.AddCommand(grepCmd)
+}
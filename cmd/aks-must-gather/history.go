@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	"kubectl-must-gather/pkg/mustgather"
+)
+
+var (
+	historyDir      string
+	historyFormat   string
+	historyTimespan string
+	historyProvider string
+	historyYes      bool
+)
+
+var historyCmd = &cobra.Command{
+	Use:   "history",
+	Short: "List, export or re-run earlier AI mode questions",
+	Long: `history shows the questions asked in AI mode, with the KQL that answered them,
+how validation went and when they were last used. Repeating a question reuses its
+validated KQL without calling the model, and reuses the analysis when the results
+have not changed.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		h, err := openHistory()
+		if err != nil {
+			return err
+		}
+		entries, err := h.List()
+		if err != nil {
+			return err
+		}
+		if len(entries) == 0 {
+			fmt.Fprintln(os.Stderr, "No AI history yet.")
+			return nil
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "ID\tLAST USED\tUSES\tVALIDATION\tROWS\tQUESTION")
+		for _, e := range entries {
+			q := e.Question
+			if e.FollowUp {
+				q += " (follow-up)"
+			}
+			fmt.Fprintf(w, "%s\t%s\t%d\t%s\t%d\t%s\n", e.ID[:12], e.LastUsedAt.Local().Format(time.DateTime), e.Uses, e.Validation, e.Rows, q)
+		}
+		return w.Flush()
+	},
+}
+
+var historyShowCmd = &cobra.Command{
+	Use:   "show <id>",
+	Short: "Show a history entry with its KQL and analysis",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		e, err := findHistory(args[0])
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Question:   %s\nWorkspace:  %s\nTimespan:   %s\nValidation: %s\n", e.Question, e.WorkspaceID, e.Timespan, e.Validation)
+		if e.ValidationErr != "" {
+			fmt.Printf("Error:      %s\n", e.ValidationErr)
+		}
+		fmt.Printf("Rows:       %d\nAsked:      %s (%d uses, last %s)\n\n%s\n", e.Rows, e.CreatedAt.Local().Format(time.DateTime), e.Uses, e.LastUsedAt.Local().Format(time.DateTime), e.KQL)
+		if e.Analysis != "" {
+			fmt.Println("\n" + e.Analysis)
+		}
+		return nil
+	},
+}
+
+var historyExportCmd = &cobra.Command{
+	Use:   "export <id>",
+	Short: "Print a history entry's KQL (or the whole entry as JSON)",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		e, err := findHistory(args[0])
+		if err != nil {
+			return err
+		}
+		switch historyFormat {
+		case "kql":
+			fmt.Printf("// %s\n// workspace: %s\n// timespan: %s\n%s\n", e.Question, e.WorkspaceID, e.Timespan, e.KQL)
+		case "json":
+			b, _ := json.MarshalIndent(e, "", "  ")
+			fmt.Println(string(b))
+		default:
+			return fmt.Errorf("unknown --format %q (want kql or json)", historyFormat)
+		}
+		return nil
+	},
+}
+
+var historyRerunCmd = &cobra.Command{
+	Use:   "rerun <id>",
+	Short: "Run a history entry's KQL again against its workspace",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		e, err := findHistory(args[0])
+		if err != nil {
+			return err
+		}
+		if e.KQL == "" {
+			return fmt.Errorf("history entry %s has no KQL to run", e.ID[:12])
+		}
+		timespan := historyTimespan
+		if timespan == "" {
+			timespan = e.Timespan
+		}
+		config := &mustgather.Config{
+			WorkspaceID:  e.WorkspaceID,
+			Timespan:     timespan,
+			AIMode:       true,
+			AIQuery:      e.Question,
+			AIKQL:        e.KQL,
+			AIProvider:   historyProvider,
+			AIHistoryDir: historyDir,
+			AIYes:        historyYes,
+		}
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		gatherer, err := mustgather.NewGatherer(ctx, config)
+		if err != nil {
+			return err
+		}
+		_, err = gatherer.Run()
+		return err
+	},
+}
+
+func openHistory() (*mustgather.AIHistory, error) {
+	if historyDir == "" {
+		return nil, fmt.Errorf("AI history is disabled (empty --ai-history-dir)")
+	}
+	return mustgather.OpenAIHistory(historyDir)
+}
+
+func findHistory(id string) (*mustgather.AIHistoryEntry, error) {
+	h, err := openHistory()
+	if err != nil {
+		return nil, err
+	}
+	return h.Find(strings.ToLower(id))
+}
+
+func init() {
+	historyCmd.PersistentFlags().StringVar(&historyDir, "ai-history-dir", mustgather.DefaultAIHistoryDir(), "Directory of the AI mode history")
+	historyExportCmd.Flags().StringVar(&historyFormat, "format", "kql", "Output format: kql or json")
+	historyRerunCmd.Flags().StringVar(&historyTimespan, "timespan", "", "Timespan to query (default: the entry's timespan)")
+	historyRerunCmd.Flags().StringVar(&historyProvider, "ai-provider", "", "LLM backend used to analyze the results (see aks-must-gather --help)")
+	historyRerunCmd.Flags().BoolVarP(&historyYes, "yes", "y", false, "Run without asking when the estimated result is large")
+	historyCmd.AddCommand(historyShowCmd, historyExportCmd, historyRerunCmd)
+	rootCmd.AddCommand(historyCmd)
+}
@@ -1,13 +1,36 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"os"
+
+	// Bundled zone data, so --timezone works where the system has none
+	_ "time/tzdata"
+
+	"kubectl-must-gather/pkg/mustgather"
 )
 
 func main() {
 	if err := Execute(); err != nil {
 		fmt.Fprintln(os.Stderr, err)
-		os.Exit(1)
+		os.Exit(exitCode(err))
+	}
+}
+
+// exitCode tells the classes of failure apart for scripts: 2 when signing
+// in failed, 3 for a workspace not found, 4 for queries still throttled, 5
+// for an incomplete bundle and 1 otherwise.
+func exitCode(err error) int {
+	switch {
+	case errors.Is(err, mustgather.ErrAuth):
+		return 2
+	case errors.Is(err, mustgather.ErrWorkspaceNotFound):
+		return 3
+	case errors.Is(err, mustgather.ErrThrottled):
+		return 4
+	case errors.Is(err, mustgather.ErrPartialData):
+		return 5
 	}
+	return 1
 }
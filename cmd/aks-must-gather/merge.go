@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"kubectl-must-gather/pkg/mustgather"
+)
+
+var mergeOut string
+
+var mergeCmd = &cobra.Command{
+	Use:   "merge <bundle> <bundle>...",
+	Short: "Combine several bundles into one archive",
+	Long: `merge unions bundles (archives or extracted directories), e.g. several
+incremental gathers or gathers of different workspaces, into one archive. The
+table parts of all bundles are written in time order; where chunks of
+different bundles overlap, rows both hold are written once. Stitched logs and
+the other files derived from table rows are regenerated from the merged rows,
+and index.json lists the tables of all bundles.
+
+Other files keep the first bundle's copy; a later bundle's differing copy is
+written under merged/<bundle>/ and listed in metadata/merge.json.`,
+	Example: `  aks-must-gather merge must-gather-20240101-120000.tar.gz must-gather-20240101-130000.tar.gz --out day.tar.gz`,
+	Args:    cobra.MinimumNArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		out := mergeOut
+		if out == "" {
+			out = strings.TrimSuffix(strings.TrimSuffix(args[0], ".gz"), ".tar") + "-merged.tar.gz"
+		}
+		stats, err := mustgather.Merge(args, out)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "Merged %d bundles into %s: %d table(s), %d row(s)", len(args), out, stats.Tables, stats.Rows)
+		if stats.Duplicates > 0 {
+			fmt.Fprintf(os.Stderr, ", %d duplicate row(s) of overlapping chunks dropped", stats.Duplicates)
+		}
+		fmt.Fprintln(os.Stderr)
+		if len(stats.Conflicts) > 0 {
+			fmt.Fprintf(os.Stderr, "%d file(s) differ between bundles; the first bundle's copies are kept and the others listed in metadata/merge.json\n", len(stats.Conflicts))
+		}
+		return nil
+	},
+}
+
+func init() {
+	mergeCmd.Flags().StringVar(&mergeOut, "out", "", "Output tar.gz path (default: <first bundle>-merged.tar.gz)")
+	rootCmd.AddCommand(mergeCmd)
+}
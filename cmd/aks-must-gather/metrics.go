@@ -0,0 +1,32 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"kubectl-must-gather/pkg/mustgather"
+)
+
+// serveMetrics serves m at http://<addr>/metrics until ctx is cancelled, for
+// the long-running modes.
+func serveMetrics(ctx context.Context, addr string, m *mustgather.Metrics) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("metrics: listen: %w", err)
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", m.Handler())
+	srv := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		<-ctx.Done()
+		shutdown, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = srv.Shutdown(shutdown)
+	}()
+	go func() { _ = srv.Serve(ln) }()
+	fmt.Fprintf(logOutput(), "Serving metrics at http://%s/metrics\n", ln.Addr())
+	return nil
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"kubectl-must-gather/pkg/bundle"
+	"kubectl-must-gather/pkg/mustgather"
+)
+
+var migrateOut string
+
+var migrateCmd = &cobra.Command{
+	Use:   "migrate <bundle.tar.gz>",
+	Short: "Upgrade a must-gather bundle to the current bundle format",
+	Long: fmt.Sprintf(`migrate rewrites a bundle produced by an older aks-must-gather into the current
+layout (bundle format version %d). Exported table data is kept as is; stitched
+logs and other derived files are regenerated from it.`, bundle.FormatVersion),
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		src := args[0]
+		out := migrateOut
+		if out == "" {
+			out = strings.TrimSuffix(strings.TrimSuffix(src, ".gz"), ".tar") + fmt.Sprintf("-v%d.tar.gz", bundle.FormatVersion)
+		}
+		if err := mustgather.Migrate(src, out); err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "Wrote %s\n", out)
+		return nil
+	},
+}
+
+func init() {
+	migrateCmd.Flags().StringVar(&migrateOut, "out", "", "Output tar.gz path (default: <bundle>-v<version>.tar.gz)")
+	rootCmd.AddCommand(migrateCmd)
+}
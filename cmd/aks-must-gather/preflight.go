@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+	"kubectl-must-gather/pkg/mustgather"
+)
+
+var (
+	preflightClusterID string
+	preflightFormat    string
+)
+
+var preflightCmd = &cobra.Command{
+	Use:     "preflight",
+	Aliases: []string{"validate"},
+	Short:   "Check Azure access to the workspace and cluster before a gather",
+	Long: `preflight checks, without gathering anything, that the Azure credential can
+sign in, read the workspace through the management plane, list its tables (for
+--all-tables), run queries through the data plane and read the AKS cluster.
+A denied check names the built-in role to assign and the scope to assign it at.
+
+The workspace and cluster are found as for a gather: --workspace-id, or the
+AKS cluster of the kubeconfig context. The command exits non-zero when a
+check a gather needs fails.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if preflightFormat != "text" && preflightFormat != "json" {
+			return fmt.Errorf("invalid --format %q: expected text or json", preflightFormat)
+		}
+		clusterID := preflightClusterID
+		if workspaceID == "" {
+			ws, cluster, err := inferWorkspace()
+			if err != nil {
+				return fmt.Errorf("must provide --workspace-id (workspace ARM resource ID), or a kubeconfig context of an AKS cluster with Container Insights: %w", err)
+			}
+			workspaceID = ws
+			if clusterID == "" {
+				clusterID = cluster
+			}
+		}
+
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		r := mustgather.Preflight(ctx, mustgather.Environment{}, workspaceID, clusterID)
+		if preflightFormat == "json" {
+			b, _ := json.MarshalIndent(r, "", "  ")
+			fmt.Println(string(b))
+		} else {
+			w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+			for _, c := range r.Checks {
+				name := c.Name
+				if !c.Required {
+					name += " (optional)"
+				}
+				fmt.Fprintf(w, "%s\t%s\t%s\n", strings.ToUpper(c.Status), name, c.Detail)
+			}
+			if err := w.Flush(); err != nil {
+				return err
+			}
+		}
+		if !r.OK() {
+			return errors.New("preflight failed: a gather would not have the access it needs")
+		}
+		return nil
+	},
+}
+
+func init() {
+	preflightCmd.Flags().StringVar(&workspaceID, "workspace-id", "", "Log Analytics workspace ARM resource ID (default: the Container Insights workspace of the AKS cluster of the kubeconfig context)")
+	preflightCmd.Flags().StringVar(&kubeconfigPath, "kubeconfig", "", "Path to the kubeconfig file used to infer the cluster when --workspace-id is not set")
+	preflightCmd.Flags().StringVar(&kubeContext, "context", "", "Kubeconfig context whose AKS cluster to check when --workspace-id is not set (default: the current context)")
+	preflightCmd.Flags().StringVar(&preflightClusterID, "cluster-id", "", "AKS cluster ARM resource ID to check read access to (default: the cluster of the kubeconfig context, if inferred)")
+	preflightCmd.Flags().StringVar(&preflightFormat, "format", "text", "Output format: text or json")
+	rootCmd.AddCommand(preflightCmd)
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/spf13/cobra"
+	"kubectl-must-gather/pkg/mustgather"
+)
+
+var (
+	queryOpts        mustgather.QueryOptions
+	queryKQLFile     string
+	queryTimespan    string
+	queryFormat      string
+	queryMaxRetries  int
+	queryRateLimit   float64
+	queryParallelism int
+)
+
+var queryCmd = &cobra.Command{
+	Use:   "query",
+	Short: "Run a KQL query against the workspace and print the result",
+	Long: `query runs a KQL query against the Log Analytics workspace over --timespan
+ending now, with the credential, throttling retries and query rate of a gather,
+and prints the result as a table, JSON or CSV.
+
+The workspace is found as for a gather: --workspace-id, or the Container
+Insights workspace of the AKS cluster of the kubeconfig context. With --chunk
+the window is queried in the time chunks of a gather, splitting those whose
+result the service truncates, and the rows are concatenated; use it for
+queries returning many rows, not for aggregations, which would be computed per
+chunk.`,
+	Example: `  aks-must-gather query --kql 'KubePodInventory | summarize count() by PodStatus' --timespan 6h
+  aks-must-gather query --kql-file errors.kql --chunk --format csv > errors.csv`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if queryFormat != "table" && queryFormat != "json" && queryFormat != "csv" {
+			return fmt.Errorf("invalid --format %q: expected table, json or csv", queryFormat)
+		}
+		opts := queryOpts
+		switch {
+		case opts.KQL != "" && queryKQLFile != "":
+			return errors.New("--kql and --kql-file are mutually exclusive")
+		case queryKQLFile == "-":
+			b, err := io.ReadAll(os.Stdin)
+			if err != nil {
+				return fmt.Errorf("read query: %w", err)
+			}
+			opts.KQL = string(b)
+		case queryKQLFile != "":
+			b, err := os.ReadFile(queryKQLFile)
+			if err != nil {
+				return fmt.Errorf("read query: %w", err)
+			}
+			opts.KQL = string(b)
+		}
+		if strings.TrimSpace(opts.KQL) == "" {
+			return errors.New("must provide --kql or --kql-file")
+		}
+		if workspaceID == "" {
+			ws, _, err := inferWorkspace()
+			if err != nil {
+				return fmt.Errorf("must provide --workspace-id (workspace ARM resource ID), or a kubeconfig context of an AKS cluster with Container Insights: %w", err)
+			}
+			workspaceID = ws
+		}
+
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		cfg := &mustgather.Config{
+			WorkspaceID: workspaceID,
+			Timespan:    queryTimespan,
+			MaxRetries:  queryMaxRetries,
+			QueryRate:   queryRateLimit,
+			Parallelism: queryParallelism,
+			Quiet:       quiet,
+		}
+		r, err := mustgather.Query(ctx, cfg, mustgather.Environment{}, opts)
+		if err != nil {
+			return err
+		}
+		switch queryFormat {
+		case "json":
+			b, _ := json.MarshalIndent(r, "", "  ")
+			fmt.Println(string(b))
+		case "csv":
+			if err := r.RenderCSV(os.Stdout); err != nil {
+				return err
+			}
+		default:
+			if err := r.Render(os.Stdout); err != nil {
+				return err
+			}
+		}
+		fmt.Fprintf(logOutput(), "%d row(s) from %s to %s in %d query request(s)\n", len(r.Rows), r.Start, r.End, r.Queries)
+		if len(r.Truncated) > 0 {
+			fmt.Fprintf(os.Stderr, "warning: the service truncated the result for %s; narrow the query or use --chunk\n", strings.Join(r.Truncated, ", "))
+		}
+		return nil
+	},
+}
+
+func init() {
+	f := queryCmd.Flags()
+	f.StringVar(&queryOpts.KQL, "kql", "", "KQL query to run")
+	f.StringVar(&queryKQLFile, "kql-file", "", "File to read the KQL query from ('-' for stdin)")
+	f.StringVar(&queryTimespan, "timespan", "PT2H", "Timespan to query, ending now (ISO-8601 like PT6H, or Go duration like 6h)")
+	f.BoolVar(&queryOpts.Chunk, "chunk", false, "Query the window in time chunks and concatenate the rows, splitting chunks the service truncates; not for aggregations")
+	f.StringVar(&queryFormat, "format", "table", "Output format: table, json or csv")
+	f.StringVar(&workspaceID, "workspace-id", "", "Log Analytics workspace ARM resource ID (default: the Container Insights workspace of the AKS cluster of the kubeconfig context)")
+	f.StringVar(&kubeconfigPath, "kubeconfig", "", "Path to the kubeconfig file used to infer the workspace when --workspace-id is not set")
+	f.StringVar(&kubeContext, "context", "", "Kubeconfig context whose AKS cluster's workspace to query when --workspace-id is not set (default: the current context)")
+	f.IntVar(&queryMaxRetries, "max-retries", 5, "Retries per query when Log Analytics throttles (HTTP 429/503), with exponential backoff honoring Retry-After")
+	f.Float64Var(&queryRateLimit, "query-rate", 5, "Maximum queries per second (0 for unlimited)")
+	f.IntVar(&queryParallelism, "parallel", 4, "Time chunks queried concurrently with --chunk")
+	f.BoolVarP(&quiet, "quiet", "q", false, "Print nothing to stderr but errors")
+	rootCmd.AddCommand(queryCmd)
+}
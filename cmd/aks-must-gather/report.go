@@ -0,0 +1,41 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"kubectl-must-gather/pkg/mustgather"
+)
+
+var reportOut string
+
+var reportCmd = &cobra.Command{
+	Use:   "report <bundle>",
+	Short: "Render the HTML report of an existing must-gather bundle",
+	Long: `report writes the same HTML overview --report html adds to new gathers for a
+bundle gathered without it: pods per namespace with status and restart counts,
+event summaries and links to the stitched logs. Links work when the bundle is an
+extracted directory; for a .tar.gz they are shown as paths inside the archive.`,
+	Example: `  aks-must-gather report ./must-gather-20240101-120000 --out report.html`,
+	Args:    cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		src := args[0]
+		out := reportOut
+		if out == "" {
+			out = strings.TrimSuffix(strings.TrimSuffix(filepath.Clean(src), ".gz"), ".tar") + "-report.html"
+		}
+		if err := mustgather.WriteReport(src, out); err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "Wrote %s\n", out)
+		return nil
+	},
+}
+
+func init() {
+	reportCmd.Flags().StringVar(&reportOut, "out", "", "Output HTML path (default: <bundle>-report.html)")
+	rootCmd.AddCommand(reportCmd)
+}
@@ -3,23 +3,94 @@ package main
 import (
 	"context"
 	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"slices"
 	"strings"
+	"syscall"
 	"time"
 
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"kubectl-must-gather/pkg/kubeconfig"
 	"kubectl-must-gather/pkg/mustgather"
+	"kubectl-must-gather/pkg/utils"
 )
 
 var (
 	workspaceID         string
+	kubeconfigPath      string
+	kubeContext         string
 	timespanStr         string
 	outTar              string
+	outFormat           string
 	tableFilterCSV      string
 	profilesCSV         string
 	allTables           bool
 	stitchLogs          bool
 	stitchIncludeEvents bool
 	aiQuery             string
+	aiProvider          string
+	aiInteractive       bool
+	aiArchive           bool
+	aiHistoryDir        string
+	aiYes               bool
+	aiSteps             int
+	aiSummary           bool
+	aiMaxPromptTokens   int
+	aiSampleRows        int
+	aiMaxTokens         int64
+	aiMaxCost           float64
+	aiTokenPrices       string
+	reportFormat        string
+	eventObjects        string
+	cacheDir            string
+	freshnessCheck      bool
+	clampToRetention    bool
+	timezone            string
+	strict              bool
+	redactionRules      string
+	encryptTo           []string
+	restrictedOut       string
+	restrictedEncryptTo []string
+	signKey             string
+	anonymize           bool
+	anonymizeKey        string
+	watch               bool
+	watchInterval       time.Duration
+	triggerRules        string
+	keep                int
+	metricsAddr         string
+	keepDays            int
+	notifyWebhook       string
+	fleetFile           string
+	fleetParallel       int
+	fleetSeparate       bool
+	sinceLastRun        bool
+	stateFile           string
+	inCluster           bool
+	uploadTo            string
+	configDir           string
+	namespacesCSV       string
+	scopeToRBAC         bool
+	logVolume           bool
+	snippetsCSV         string
+	maxRetries          int
+	parallelism         int
+	timeout             time.Duration
+	queryRate           float64
+	batchQueries        bool
+	maxTotalRows        int64
+	maxTotalBytes       string
+	maxMemory           string
+	packageForSupport   bool
+	progressFormat      string
+	supportCase         string
+	quiet               bool
+	outputJSON          bool
 )
 
 var rootCmd = &cobra.Command{
@@ -29,11 +100,34 @@ var rootCmd = &cobra.Command{
 and packages it into a tar.gz file for analysis. It supports various profiles and can export
 specific tables or all tables from the workspace.
 
+Without --workspace-id, the workspace is the Container Insights workspace of the AKS
+cluster behind the kubeconfig context (--kubeconfig, --context). Installed on the PATH
+as kubectl-must_gather, the tool also runs as 'kubectl must-gather aks'.
+
 With --ai-mode, you can use natural language queries to generate KQL queries and get targeted 
-results without creating tar files. Requires 'claude' command to be available in PATH.`,
+results without creating tar files; --ai-interactive keeps the session open for follow-up
+questions. The model is reached through --ai-provider: the local
+'claude' CLI, the Anthropic API, the OpenAI API, or an Azure OpenAI deployment.
+
+Every flag can also be set with an environment variable, AKS_MG_ followed by
+the flag name in upper case with dashes as underscores (AKS_MG_WORKSPACE_ID for
+--workspace-id); flags on the command line win.`,
 	RunE: func(cmd *cobra.Command, args []string) error {
-		if workspaceID == "" {
-			return fmt.Errorf("must provide --workspace-id (workspace ARM resource ID)")
+		var clusterID string
+		if fleetFile != "" && (workspaceID != "" || kubeContext != "") {
+			return fmt.Errorf("--fleet names the workspace or context of each cluster: drop --workspace-id and --context")
+		}
+		if fleetFile == "" && (cmd.Flags().Changed("fleet-parallel") || fleetSeparate) {
+			return fmt.Errorf("--fleet-parallel and --fleet-separate need --fleet")
+		}
+		if workspaceID == "" && inCluster {
+			return fmt.Errorf("--in-cluster needs --workspace-id: there is no kubeconfig context to infer the workspace from")
+		}
+		if workspaceID == "" && fleetFile == "" {
+			var err error
+			if workspaceID, clusterID, err = inferWorkspace(); err != nil {
+				return fmt.Errorf("must provide --workspace-id (workspace ARM resource ID), or a kubeconfig context of an AKS cluster with Container Insights: %w", err)
+			}
 		}
 
 		// Handle AI mode
@@ -44,43 +138,454 @@ results without creating tar files. Requires 'claude' command to be available in
 			}
 		}
 
+		var maxBytes int64
+		if maxTotalBytes != "" {
+			var err error
+			if maxBytes, err = utils.ParseByteSize(maxTotalBytes); err != nil {
+				return fmt.Errorf("invalid --max-total-bytes: %w", err)
+			}
+		}
+
+		var memLimit int64
+		if maxMemory != "" {
+			var err error
+			if memLimit, err = utils.ParseByteSize(maxMemory); err != nil {
+				return fmt.Errorf("invalid --max-memory: %w", err)
+			}
+		}
+
+		if reportFormat != "" && reportFormat != mustgather.ReportHTML {
+			return fmt.Errorf("invalid --report %q: only %q is supported", reportFormat, mustgather.ReportHTML)
+		}
+		if progressFormat != mustgather.ProgressText && progressFormat != mustgather.ProgressJSON {
+			return fmt.Errorf("invalid --progress-format %q: expected %q or %q", progressFormat, mustgather.ProgressText, mustgather.ProgressJSON)
+		}
+		if (quiet || outputJSON) && (aiQuery != "" || aiInteractive) {
+			return fmt.Errorf("--quiet and --output-json apply to regular gathers, not AI mode")
+		}
+		if outputJSON && progressFormat == mustgather.ProgressJSON {
+			return fmt.Errorf("--output-json cannot be combined with --progress-format json: both write to stdout")
+		}
+		if packageForSupport && (aiQuery != "" || aiInteractive) && !aiArchive {
+			return fmt.Errorf("--package-for-support needs an archive: AI mode writes one only with --ai-archive")
+		}
+		if timezone != "" {
+			if _, err := time.LoadLocation(timezone); err != nil {
+				return fmt.Errorf("invalid --timezone %q: expected an IANA zone such as Europe/Berlin: %w", timezone, err)
+			}
+		}
+		if outFormat != "" && !slices.Contains(mustgather.OutputFormats, outFormat) {
+			return fmt.Errorf("invalid --out-format %q: expected one of %s", outFormat, strings.Join(mustgather.OutputFormats, ", "))
+		}
+		if eventObjects != "" && eventObjects != mustgather.EventObjectsJSON && eventObjects != mustgather.EventObjectsYAML {
+			return fmt.Errorf("invalid --event-objects %q: expected %q or %q", eventObjects, mustgather.EventObjectsJSON, mustgather.EventObjectsYAML)
+		}
+
+		prices, err := mustgather.ParseTokenPrices(aiTokenPrices)
+		if err != nil {
+			return fmt.Errorf("invalid --ai-token-prices: %w", err)
+		}
+		if redactionRules != "" && (aiQuery != "" || aiInteractive) {
+			return fmt.Errorf("--redaction-rules applies to regular gathers, not AI mode")
+		}
+		if len(encryptTo) > 0 && (aiQuery != "" || aiInteractive) {
+			return fmt.Errorf("--encrypt-to applies to regular gathers, not AI mode")
+		}
+		if (namespacesCSV != "" || scopeToRBAC) && (aiQuery != "" || aiInteractive) {
+			return fmt.Errorf("--namespaces and --scope-to-rbac apply to regular gathers, not AI mode")
+		}
+		var namespaces []string
+		for _, ns := range strings.Split(namespacesCSV, ",") {
+			if ns = strings.TrimSpace(ns); ns != "" {
+				namespaces = append(namespaces, ns)
+			}
+		}
+		if restrictedOut != "" && (aiQuery != "" || aiInteractive) {
+			return fmt.Errorf("--restricted-out applies to regular gathers, not AI mode")
+		}
+		if restrictedOut != "" && (redactionRules == "" || len(restrictedEncryptTo) == 0) {
+			return fmt.Errorf("--restricted-out needs --redaction-rules for the public archive and --restricted-encrypt-to for the restricted one")
+		}
+		if restrictedOut == "" && len(restrictedEncryptTo) > 0 {
+			return fmt.Errorf("--restricted-encrypt-to needs --restricted-out")
+		}
+		if signKey != "" && (aiQuery != "" || aiInteractive) {
+			return fmt.Errorf("--sign-key applies to regular gathers, not AI mode")
+		}
+		if len(encryptTo) > 0 && packageForSupport {
+			return fmt.Errorf("--encrypt-to cannot be combined with --package-for-support: support packages are built from the unencrypted archive")
+		}
+		if (inCluster || uploadTo != "") && (aiQuery != "" || aiInteractive) {
+			return fmt.Errorf("--in-cluster and --upload-to apply to regular gathers, not AI mode")
+		}
+		if watch && (aiQuery != "" || aiInteractive) {
+			return fmt.Errorf("--watch applies to regular gathers, not AI mode")
+		}
+		if watch && packageForSupport {
+			return fmt.Errorf("--package-for-support cannot be combined with --watch: package an archive of the watch instead")
+		}
+		if fleetFile != "" && (watch || packageForSupport) {
+			return fmt.Errorf("--fleet cannot be combined with --watch or --package-for-support")
+		}
+		if !watch && cmd.Flags().Changed("interval") {
+			return fmt.Errorf("--interval needs --watch")
+		}
+		if !watch && triggerRules != "" {
+			return fmt.Errorf("--trigger-rules needs --watch")
+		}
+		if !watch && metricsAddr != "" {
+			return fmt.Errorf("--metrics-addr needs --watch: a single gather ends before it can be scraped")
+		}
+		if keep < 0 || keepDays < 0 {
+			return fmt.Errorf("--keep and --keep-days must not be negative")
+		}
+		if timeout < 0 {
+			return fmt.Errorf("--timeout must not be negative")
+		}
+		if (keep > 0 || keepDays > 0) && (aiQuery != "" || aiInteractive) {
+			return fmt.Errorf("--keep and --keep-days apply to regular gathers, not AI mode")
+		}
+		if notifyWebhook != "" && (aiQuery != "" || aiInteractive) {
+			return fmt.Errorf("--notify-webhook applies to regular gathers, not AI mode")
+		}
+		if sinceLastRun && (aiQuery != "" || aiInteractive) {
+			return fmt.Errorf("--since-last-run applies to regular gathers, not AI mode")
+		}
+		if anonymize && (aiQuery != "" || aiInteractive) {
+			return fmt.Errorf("--anonymize applies to regular gathers, not AI mode")
+		}
+		triggers, err := mustgather.LoadTriggerRules(triggerRules)
+		if err != nil {
+			return err
+		}
+		// Trigger rules count events every minute unless told otherwise
+		if triggers != nil && !cmd.Flags().Changed("interval") {
+			watchInterval = mustgather.DefaultTriggerInterval
+		}
+		redactor, err := mustgather.LoadRedactionRules(redactionRules)
+		if err != nil {
+			return err
+		}
+		var anonymizer *mustgather.Anonymizer
+		if anonymize {
+			if anonymizer, err = mustgather.LoadAnonymizer(anonymizeKey); err != nil {
+				return err
+			}
+		}
+
 		config := &mustgather.Config{
 			WorkspaceID:         workspaceID,
+			ClusterID:           clusterID,
 			Timespan:            timespanStr,
+			SinceLastRun:        sinceLastRun,
+			StateFile:           stateFile,
 			OutputFile:          outTar,
+			OutputFormat:        outFormat,
 			TableFilter:         tableFilterCSV,
 			Profiles:            profilesCSV,
 			AllTables:           allTables,
 			StitchLogs:          stitchLogs,
 			StitchIncludeEvents: stitchIncludeEvents,
-			AIMode:              aiQuery != "",
+			AIMode:              aiQuery != "" || aiInteractive,
 			AIQuery:             aiQuery,
+			AIProvider:          aiProvider,
+			AIInteractive:       aiInteractive,
+			AIArchive:           aiArchive,
+			AIHistoryDir:        aiHistoryDir,
+			AIYes:               aiYes,
+			AISteps:             aiSteps,
+			AISummary:           aiSummary,
+			AIMaxPromptTokens:   aiMaxPromptTokens,
+			AISampleRows:        aiSampleRows,
+			AIMaxTokens:         aiMaxTokens,
+			AIMaxCost:           aiMaxCost,
+			AITokenPrices:       prices,
+			Report:              reportFormat,
+			EventObjects:        eventObjects,
+			CacheDir:            cacheDir,
+			FreshnessCheck:      freshnessCheck,
+			ClampToRetention:    clampToRetention,
+			Timezone:            timezone,
+			Strict:              strict,
+			Redaction:           redactor,
+			Anonymizer:          anonymizer,
+			EncryptTo:           encryptTo,
+			RestrictedOutput:    restrictedOut,
+			RestrictedEncryptTo: restrictedEncryptTo,
+			SignKey:             signKey,
+			UploadTo:            uploadTo,
+			Keep:                keep,
+			KeepDays:            keepDays,
+			NotifyWebhook:       notifyWebhook,
+			InCluster:           inCluster,
+			Namespaces:          namespaces,
+			ScopeToRBAC:         scopeToRBAC,
+			Kubeconfig:          kubeconfigPath,
+			KubeContext:         kubeContext,
+			LogVolume:           logVolume,
+			Snippets:            snippetsCSV,
+			MaxRetries:          maxRetries,
+			Parallelism:         parallelism,
+			Timeout:             timeout,
+			QueryRate:           queryRate,
+			BatchQueries:        batchQueries,
+			MaxTotalRows:        maxTotalRows,
+			MaxTotalBytes:       maxBytes,
+			MaxMemory:           memLimit,
+			ProgressFormat:      progressFormat,
+			Quiet:               quiet,
+			OutputJSON:          outputJSON,
+			Flags:               map[string]string{},
+		}
+		cmd.Flags().Visit(func(f *pflag.Flag) { config.Flags[f.Name] = f.Value.String() })
+		if _, ok := config.Flags["notify-webhook"]; ok {
+			// Incoming webhook URLs are their own credential
+			config.Flags["notify-webhook"] = "<redacted>"
+		}
+		for _, name := range []string{"out", "upload-to"} {
+			// The SAS token of a blob container URL is a credential
+			if u, ok := config.Flags[name]; ok && strings.HasPrefix(u, "https://") {
+				config.Flags[name], _, _ = strings.Cut(u, "?")
+			}
+		}
+		if f := config.ResolveOutputFormat(); f != mustgather.OutputTarGz && (fleetFile != "" || packageForSupport) {
+			return fmt.Errorf("--fleet and --package-for-support write tar.gz archives, not a %s output", f)
 		}
 
-		ctx := context.Background()
-		gatherer, err := mustgather.NewGatherer(ctx, config)
+		// The first SIGINT/SIGTERM stops the gather and finalizes a partial
+		// archive; a second one kills the process as usual.
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		sigs := make(chan os.Signal, 1)
+		signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+		defer signal.Stop(sigs)
+		go func() {
+			<-sigs
+			signal.Stop(sigs)
+			fmt.Fprintln(logOutput(), "Interrupted, finishing partial archive (interrupt again to abort)...")
+			cancel()
+		}()
+		// In a pod the workload identity of its service account signs in,
+		// without falling back to other credentials
+		var env *mustgather.Environment
+		if inCluster {
+			cred, err := azidentity.NewWorkloadIdentityCredential(nil)
+			if err != nil {
+				return fmt.Errorf("--in-cluster needs workload identity on the pod's service account: %w", err)
+			}
+			env = &mustgather.Environment{Credential: cred}
+		}
+		if fleetFile != "" {
+			fleet, err := mustgather.LoadFleet(fleetFile)
+			if err != nil {
+				return err
+			}
+			_, err = mustgather.GatherFleet(ctx, config, fleet, mustgather.FleetOptions{Parallel: fleetParallel, Separate: fleetSeparate, Environment: env})
+			return err
+		}
+		if watch {
+			if !cmd.Flags().Changed("out") {
+				// Rotate must-gather-<time>.tar.gz rather than the
+				// timestamped default
+				config.OutputFile = ""
+			}
+			// A watch keeps a week of gathers unless told otherwise, where
+			// its archives can be pruned
+			format := config.ResolveOutputFormat()
+			if !cmd.Flags().Changed("keep") && (format == mustgather.OutputTarGz || format == mustgather.OutputZip) {
+				config.Keep = defaultWatchKeep
+			}
+			if metricsAddr != "" {
+				config.Metrics = mustgather.NewMetrics()
+				if err := serveMetrics(ctx, metricsAddr, config.Metrics); err != nil {
+					return err
+				}
+			}
+			return mustgather.Watch(ctx, config, mustgather.WatchOptions{Interval: watchInterval, Environment: env, Triggers: triggers})
+		}
+		var gatherer mustgather.GathererInterface
+		if env != nil {
+			gatherer, err = mustgather.NewGathererWithEnvironment(ctx, config, *env)
+		} else {
+			gatherer, err = mustgather.NewGatherer(ctx, config)
+		}
 		if err != nil {
 			return err
 		}
 
-		return gatherer.Run()
+		if _, err := gatherer.Run(); err != nil {
+			return err
+		}
+		if packageForSupport {
+			return packageArchiveForSupport(outTar)
+		}
+		return nil
 	},
 }
 
 func init() {
-	rootCmd.Flags().StringVar(&workspaceID, "workspace-id", "", "Log Analytics workspace ARM resource ID")
+	rootCmd.Flags().StringVar(&workspaceID, "workspace-id", "", "Log Analytics workspace ARM resource ID (default: the Container Insights workspace of the AKS cluster of the kubeconfig context)")
+	rootCmd.Flags().StringVar(&kubeconfigPath, "kubeconfig", "", "Path to the kubeconfig file used to infer the cluster when --workspace-id is not set (default: $KUBECONFIG, else ~/.kube/config)")
+	rootCmd.Flags().StringVar(&kubeContext, "context", "", "Kubeconfig context whose AKS cluster to gather from when --workspace-id is not set (default: the current context)")
 	rootCmd.Flags().StringVar(&timespanStr, "timespan", "PT2H", "Timespan to query (ISO-8601 like PT6H, or Go duration like 6h)")
-	rootCmd.Flags().StringVar(&outTar, "out", fmt.Sprintf("must-gather-%s.tar.gz", time.Now().Format("20060102-150405")), "Output tar.gz path")
+	rootCmd.Flags().StringVar(&outTar, "out", fmt.Sprintf("must-gather-%s.tar.gz", time.Now().Format("20060102-150405")), "Output path: a tar.gz archive, a .zip archive, a directory (ending in / or existing) or a blob container URL https://<account>.blob.core.windows.net/<container>/<prefix> to upload each file to as it is written")
+	rootCmd.Flags().StringVar(&outFormat, "out-format", "", "Format of --out: tar.gz, zip, dir or blob (default: from the --out name)")
 	rootCmd.Flags().StringVar(&tableFilterCSV, "tables", "", "Optional comma-separated list of tables to export (overrides profiles)")
-	rootCmd.Flags().StringVar(&profilesCSV, "profiles", "", "Optional comma-separated profiles: aks-debug,podLogs,inventory,metrics,audit")
+	rootCmd.Flags().StringVar(&profilesCSV, "profiles", "", "Optional comma-separated profiles: "+profileNames())
+	_ = rootCmd.RegisterFlagCompletionFunc("profiles", completeProfiles)
+	rootCmd.Flags().StringVar(&redactionRules, "redaction-rules", "", "YAML file of masking rules (builtin email, ip, upn and custom patterns or keys) applied to every row before it is exported, stitched or reported")
+	rootCmd.Flags().StringArrayVar(&encryptTo, "encrypt-to", nil, "Encrypt the archive to this age recipient (age1..., ssh-ed25519/ssh-rsa key) or GPG key in the keyring (ID, fingerprint or email), writing <out>.age or <out>.gpg and removing the unencrypted file; repeat for several recipients")
+	rootCmd.Flags().BoolVar(&inCluster, "in-cluster", false, "Run as a Job or CronJob in an AKS cluster: sign in with the workload identity of the pod's service account and post a Kubernetes Event on the pod when the gather ends; needs --workspace-id")
+	rootCmd.Flags().StringVar(&uploadTo, "upload-to", "", "Also upload the archive (and restricted archive, manifest and signature) to this blob container URL, e.g. https://<account>.blob.core.windows.net/<container>[/<prefix>], with the gather's Azure credential or a SAS token in the URL")
+	rootCmd.Flags().StringVar(&configDir, "config-dir", "", "Directory of files named after flags, e.g. a mounted ConfigMap, setting the flags given neither on the command line nor in the environment")
+	rootCmd.Flags().BoolVar(&sinceLastRun, "since-last-run", false, "Export only the rows newer than the newest one each table had in the last run, as recorded in --state-file; tables not exported before cover --timespan")
+	rootCmd.Flags().StringVar(&stateFile, "state-file", mustgather.DefaultStateFile(), "File where --since-last-run records the newest TimeGenerated exported per workspace and table")
+	rootCmd.Flags().BoolVar(&watch, "watch", false, "Keep running, gathering every --interval the time since the previous gather into a new archive named after --out with the gather time inserted (e.g. must-gather-20260101-120000.tar.gz)")
+	rootCmd.Flags().DurationVar(&watchInterval, "interval", 6*time.Hour, "Time between the starts of two --watch gathers, or between two event counts with --trigger-rules, where it defaults to 1m")
+	rootCmd.Flags().StringVar(&triggerRules, "trigger-rules", "", "With --watch, gather only when a rule of this YAML file fires, e.g. more than 5 BackOff events in a namespace within 10m, exporting the rule's profiles or tables for the namespaces that crossed it")
+	rootCmd.Flags().StringVar(&metricsAddr, "metrics-addr", "", "With --watch, serve Prometheus metrics of the gathers at http://<addr>/metrics, e.g. :9090")
+	rootCmd.Flags().IntVar(&keep, "keep", 0, "Keep only the archives of the newest N gathers named like --out with a time, e.g. from a CronJob, removing older ones next to it and in the --upload-to container (default: all; 28 with --watch to archives)")
+	rootCmd.Flags().IntVar(&keepDays, "keep-days", 0, "Remove the archives of gathers named like --out with a time that are older than D days, next to it and in the --upload-to container (default: never)")
+	rootCmd.Flags().StringVar(&notifyWebhook, "notify-webhook", "", "Post a summary of each gather (outcome, archive, size, warnings, top findings) to this Slack or Teams incoming webhook URL when it finishes")
+	rootCmd.Flags().StringVar(&fleetFile, "fleet", "", "YAML file of clusters (name and workspaceID, clusterID or kubeconfig context each) to gather concurrently into clusters/<name>/ of --out, with a fleet-wide summary of outcomes and shared findings in FLEET.md and metadata/fleet.json")
+	rootCmd.Flags().IntVar(&fleetParallel, "fleet-parallel", 4, "Clusters of --fleet gathered at once")
+	rootCmd.Flags().BoolVar(&fleetSeparate, "fleet-separate", false, "Write an archive per --fleet cluster, named after --out with the cluster name inserted, and the summary to <out>-fleet.json, instead of one archive")
+	rootCmd.Flags().BoolVar(&anonymize, "anonymize", false, "Replace namespace, pod, node and user names with stable pseudonyms such as ns-1a2b3c4d; the mapping is kept in --anonymize-key")
+	rootCmd.Flags().StringVar(&anonymizeKey, "anonymize-key", mustgather.DefaultAnonymizeKeyFile(), "Key file holding the salt and the name of each pseudonym of --anonymize; created on first use and never added to the archive")
+	rootCmd.Flags().StringVar(&signKey, "sign-key", "", "Write <out>.manifest.json with the checksums of the archive and its files and sign it with cosign using this key file or KMS key (e.g. azurekms://<vault>.vault.azure.net/<key>), writing <out>.manifest.json.sig")
+	rootCmd.Flags().StringVar(&restrictedOut, "restricted-out", "", "Also write the unredacted table rows to this second archive, encrypted to --restricted-encrypt-to, while --out gets the rows masked by --redaction-rules")
+	rootCmd.Flags().StringArrayVar(&restrictedEncryptTo, "restricted-encrypt-to", nil, "age or GPG recipient (see --encrypt-to) the --restricted-out archive is encrypted to; repeat for several recipients")
+	rootCmd.Flags().StringVar(&namespacesCSV, "namespaces", "", "Comma-separated namespaces to limit the gather to: only tables with a namespace column are exported, filtered to these namespaces")
+	rootCmd.Flags().BoolVar(&scopeToRBAC, "scope-to-rbac", false, "Limit the gather to the namespaces in which the kubeconfig context may list pods, checked with 'kubectl auth can-i' (within --namespaces when set)")
+	rootCmd.Flags().BoolVar(&strict, "strict", false, "Fail before exporting anything when a table named in --tables is not in the workspace, suggesting the closest names; without it such tables are skipped with a warning. Unknown --profiles always fail")
 	rootCmd.Flags().BoolVar(&allTables, "all-tables", false, "Export all tables in the workspace (may be slow). Overrides profiles/tables if used.")
 	rootCmd.Flags().BoolVar(&stitchLogs, "stitch-logs", true, "Also include time-ordered logs per namespace/pod/container under namespaces/ folder")
 	rootCmd.Flags().BoolVar(&stitchIncludeEvents, "stitch-include-events", true, "Include KubeEvents under namespaces/<ns>/events/events.log")
+	rootCmd.Flags().StringVar(&eventObjects, "event-objects", "", "Also write KubeEvents as a v1 EventList per namespace to namespaces/<ns>/events/events.<json|yaml>; 'json' or 'yaml'")
 	rootCmd.Flags().StringVar(&aiQuery, "ai-mode", "", "Enable AI-powered query mode with natural language query (e.g., --ai-mode \"show me failed pods\")")
+	rootCmd.Flags().StringVar(&aiProvider, "ai-provider", "", "LLM backend for --ai-mode and --ai-summary: claude-cli, anthropic, openai or azure-openai (default: detected from API key env vars, else claude-cli)")
+	rootCmd.Flags().BoolVar(&aiInteractive, "ai-interactive", false, "Keep an AI session open after the first --ai-mode query (or start one without it) to ask follow-up questions with the earlier queries and answers as context")
+	rootCmd.Flags().BoolVar(&aiArchive, "ai-archive", false, "Package AI mode results into the --out tar.gz, laid out like a regular gather, instead of ai-results-<timestamp>/ directories")
+	rootCmd.Flags().StringVar(&aiHistoryDir, "ai-history-dir", mustgather.DefaultAIHistoryDir(), "Directory where AI mode records questions, validated KQL and analyses; repeated questions reuse them instead of calling the model (empty to disable)")
+	rootCmd.Flags().IntVar(&aiSteps, "ai-steps", 1, "Queries AI mode may run per question; above 1 the model inspects each result and drills down with further queries before concluding")
+	rootCmd.Flags().BoolVarP(&aiYes, "yes", "y", false, "Run AI-generated queries without asking, even when the estimated result exceeds 100k rows or 100 MiB")
+	rootCmd.Flags().BoolVar(&aiSummary, "ai-summary", false, "After a regular gather, have the --ai-provider model write an executive summary of crash loops, OOM kills and error bursts to analysis/summary.md in the archive")
+	rootCmd.Flags().IntVar(&aiMaxPromptTokens, "ai-max-prompt-tokens", 0, "Maximum size of a single AI prompt in tokens (about 4 bytes each); inlined files are cut to fit and longer prompts truncated (0 for no limit beyond the 256 KiB of inlined files)")
+	rootCmd.Flags().IntVar(&aiSampleRows, "ai-sample-rows", 0, "Analyze an evenly spaced sample of this many rows of larger AI query results; full results are still saved (0 to analyze all rows)")
+	rootCmd.Flags().Int64Var(&aiMaxTokens, "ai-max-tokens", 0, "Maximum input plus output tokens AI calls may use over the run (0 for unlimited)")
+	rootCmd.Flags().Float64Var(&aiMaxCost, "ai-max-cost", 0, "Maximum USD AI calls may cost over the run, priced with --ai-token-prices or the known list price of the model (0 for unlimited)")
+	rootCmd.Flags().StringVar(&aiTokenPrices, "ai-token-prices", "", "USD per million input and output tokens as <input>,<output>, e.g. 3,15, for cost accounting of models without a known price")
+	rootCmd.Flags().StringVar(&reportFormat, "report", "", "Also write a report to the archive; 'html' adds report/index.html with pods per namespace, restart counts, event summaries and links to the stitched logs")
+	rootCmd.Flags().StringVar(&cacheDir, "cache-dir", "", "Optional directory for caching chunk query results so re-runs over overlapping windows skip re-querying")
+	rootCmd.Flags().BoolVar(&freshnessCheck, "freshness-check", true, "Check each table's most recent TimeGenerated before exporting and warn when data is older than the requested window")
+	rootCmd.Flags().StringVar(&timezone, "timezone", "", "IANA time zone (e.g. Europe/Berlin) to show stitched log, event and report times in, with their UTC offset; default UTC. NDJSON exports stay in UTC")
+	rootCmd.Flags().BoolVar(&clampToRetention, "clamp-to-retention", true, "Start each table's queries at its retention when the timespan reaches further back, instead of querying chunks that can hold no rows; either way a warning is printed and summary.json records the covered range")
+	rootCmd.Flags().BoolVar(&logVolume, "log-volume", true, "Summarize container log lines and billed bytes per namespace, pod and container over the window into analysis/log-volume.json")
+	rootCmd.Flags().StringVar(&snippetsCSV, "snippets", "all", "Comma-separated built-in KQL snippets to run and save under queries/snippets/ ('all', or e.g. restart-counts,error-rates,top-cpu-pods; empty to disable)")
+	rootCmd.Flags().IntVar(&maxRetries, "max-retries", 5, "Retries per query when Log Analytics throttles (HTTP 429/503), with exponential backoff honoring Retry-After")
+	rootCmd.Flags().IntVar(&parallelism, "parallel", 4, "Time chunks of a table queried concurrently")
+	rootCmd.Flags().Float64Var(&queryRate, "query-rate", 5, "Maximum queries per second across all tables, to stay within workspace query limits (0 for unlimited)")
+	rootCmd.Flags().BoolVar(&batchQueries, "batch-queries", true, "Send concurrent queries together through the Log Analytics batch API to save round trips")
+	rootCmd.Flags().Int64Var(&maxTotalRows, "max-total-rows", 0, "Maximum rows exported across all tables, shared in proportion to each table's size (0 for unlimited)")
+	rootCmd.Flags().StringVar(&maxTotalBytes, "max-total-bytes", "", "Maximum NDJSON bytes exported across all tables, e.g. 500MB or 2GiB, shared in proportion to each table's size")
+	rootCmd.Flags().StringVar(&progressFormat, "progress-format", mustgather.ProgressText, "Progress output: 'text' on stderr, or 'json' to also stream newline-delimited JSON progress events on stdout")
+	rootCmd.Flags().BoolVar(&packageForSupport, "package-for-support", false, "After the gather, repackage the archive for a Microsoft support case into <out>-support/: audit data left out, split into parts under the 2 GB attachment limit, with an upload manifest of checksums")
+	rootCmd.Flags().StringVar(&supportCase, "support-case", "", "Support case number to prefix the --package-for-support file names with")
+	rootCmd.Flags().BoolVarP(&quiet, "quiet", "q", false, "Print nothing to stderr but errors")
+	rootCmd.Flags().BoolVar(&outputJSON, "output-json", false, "Write a single JSON document with the archive path, rows and bytes per table, warnings and duration to stdout when the gather ends")
+	rootCmd.Flags().DurationVar(&timeout, "timeout", 0, "Maximum duration of the whole gather, e.g. 30m; when it passes, running queries are cancelled and the archive is finished with what was collected, marked incomplete (0 for no limit)")
+	rootCmd.Flags().StringVar(&maxMemory, "max-memory", "", "Memory limit, e.g. 512MiB; near it, stitched logs are sorted on disk and chunks are queried one at a time")
+}
+
+// profileNames lists the built-in and registered profiles for the help of
+// --profiles.
+func profileNames() string {
+	var names []string
+	for _, p := range mustgather.ListProfiles() {
+		names = append(names, p.Name)
+	}
+	return strings.Join(names, ",")
+}
+
+// completeProfiles completes the last profile of a comma-separated
+// --profiles value, with the descriptions of the profiles.
+func completeProfiles(_ *cobra.Command, _ []string, toComplete string) ([]cobra.Completion, cobra.ShellCompDirective) {
+	done, last := "", toComplete
+	if i := strings.LastIndex(toComplete, ","); i >= 0 {
+		done, last = toComplete[:i+1], toComplete[i+1:]
+	}
+	var out []cobra.Completion
+	for _, p := range mustgather.ListProfiles() {
+		if strings.HasPrefix(p.Name, last) && !slices.Contains(strings.Split(done, ","), p.Name) {
+			out = append(out, cobra.CompletionWithDesc(done+p.Name, p.Description))
+		}
+	}
+	return out, cobra.ShellCompDirectiveNoFileComp | cobra.ShellCompDirectiveNoSpace
+}
+
+// inferWorkspace resolves --context in --kubeconfig and returns the workspace
+// and cluster resource IDs of its AKS cluster.
+func inferWorkspace() (workspace, cluster string, err error) {
+	kc, err := kubeconfig.Load(kubeconfigPath, kubeContext)
+	if err != nil {
+		return "", "", err
+	}
+	workspace, cluster, err = mustgather.InferWorkspace(context.Background(), mustgather.Environment{}, kc)
+	if err != nil {
+		return "", "", err
+	}
+	if cluster != "" {
+		fmt.Fprintf(logOutput(), "Using workspace %s of cluster %s (context %q)\n", workspace, cluster, kc.Name)
+	} else {
+		fmt.Fprintf(logOutput(), "Using workspace %s (context %q)\n", workspace, kc.Name)
+	}
+	return workspace, cluster, nil
+}
+
+// packageArchiveForSupport runs --package-for-support on the written archive.
+func packageArchiveForSupport(archive string) error {
+	dir := strings.TrimSuffix(strings.TrimSuffix(archive, ".gz"), ".tar") + "-support"
+	m, err := mustgather.PackageForSupport(archive, mustgather.SupportOptions{OutDir: dir, CaseID: supportCase})
+	if err != nil {
+		return fmt.Errorf("package for support: %w", err)
+	}
+	if len(m.Excluded) > 0 {
+		fmt.Fprintf(logOutput(), "Left %d sensitive file(s) out of the support package, listed in %s\n", len(m.Excluded), mustgather.SupportManifestName)
+	}
+	fmt.Fprintf(logOutput(), "Wrote %d support file(s) to %s; attach them and %s to the case\n", len(m.Files), dir, mustgather.SupportManifestName)
+	return nil
+}
+
+// logOutput is where progress messages go: stderr, unless --quiet.
+func logOutput() io.Writer {
+	if quiet {
+		return io.Discard
+	}
+	return os.Stderr
+}
+
+// defaultWatchKeep is the --keep of a watch writing archives: a week of
+// gathers at the default --interval of 6h.
+const defaultWatchKeep = 28
+
+// pluginName is the command line kubectl users type when the binary is
+// installed on their PATH as the kubectl-must_gather plugin.
+const pluginName = "kubectl must-gather aks"
 
-	rootCmd.MarkFlagRequired("workspace-id")
+// pluginArgs drops the "aks" of "kubectl must-gather aks ...", which kubectl
+// passes through to the plugin.
+func pluginArgs(args []string) []string {
+	if len(args) > 0 && args[0] == "aks" {
+		return args[1:]
+	}
+	return args
 }
 
 func Execute() error {
+	if strings.HasPrefix(filepath.Base(os.Args[0]), "kubectl-") {
+		rootCmd.Annotations = map[string]string{cobra.CommandDisplayNameAnnotation: pluginName}
+	}
+	bindEnv(rootCmd)
+	rootCmd.SetArgs(pluginArgs(os.Args[1:]))
 	return rootCmd.Execute()
 }
@@ -2,12 +2,14 @@ package main
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"os"
 	"strings"
 	"testing"
 
 	"github.com/spf13/cobra"
+	"kubectl-must-gather/pkg/mustgather"
 )
 
 func TestRootCommandFlags(t *testing.T) {
@@ -388,3 +390,109 @@ func TestRootCommandFlagInteractions(t *testing.T) {
 		})
 	}
 }
+
+func TestPluginArgs(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		want []string
+	}{
+		{name: "kubectl must-gather aks", args: []string{"aks", "--context", "shop"}, want: []string{"--context", "shop"}},
+		{name: "direct invocation", args: []string{"--context", "shop"}, want: []string{"--context", "shop"}},
+		{name: "aks as a flag value", args: []string{"--context", "aks"}, want: []string{"--context", "aks"}},
+		{name: "no args", args: nil, want: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := pluginArgs(tt.args); fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("pluginArgs(%q) = %q, want %q", tt.args, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCompleteProfiles(t *testing.T) {
+	tests := []struct {
+		toComplete string
+		want       []string
+	}{
+		{toComplete: "me", want: []string{"metrics\tMetrics and agent heartbeat"}},
+		{toComplete: "podLogs,a", want: []string{"podLogs,aks-debug\tDefault: podLogs, inventory and metrics", "podLogs,audit\tControl plane and audit logs; needs AKS diagnostic settings"}},
+		{toComplete: "podLogs,po", want: nil},
+		{toComplete: "x", want: nil},
+	}
+	for _, tt := range tests {
+		got, directive := completeProfiles(rootCmd, nil, tt.toComplete)
+		if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+			t.Errorf("completeProfiles(%q) = %q, want %q", tt.toComplete, got, tt.want)
+		}
+		if directive&cobra.ShellCompDirectiveNoFileComp == 0 {
+			t.Errorf("completeProfiles(%q) completes file names", tt.toComplete)
+		}
+	}
+	if all, _ := completeProfiles(rootCmd, nil, ""); len(all) != len(mustgather.ListProfiles()) {
+		t.Errorf("expected every profile, got %q", all)
+	}
+	if !strings.Contains(rootCmd.Flags().Lookup("profiles").Usage, "aks-debug,audit,inventory,metrics,podLogs") {
+		t.Errorf("unexpected usage of --profiles: %q", rootCmd.Flags().Lookup("profiles").Usage)
+	}
+}
+
+func TestRootCommandKubeconfigFlags(t *testing.T) {
+	for _, name := range []string{"kubeconfig", "context"} {
+		if rootCmd.Flags().Lookup(name) == nil {
+			t.Errorf("flag %q not found", name)
+		}
+	}
+	// The workspace can be inferred from the kubeconfig context instead
+	if f := rootCmd.Flags().Lookup("workspace-id"); f == nil || len(f.Annotations[cobra.BashCompOneRequiredFlag]) > 0 {
+		t.Errorf("--workspace-id should be optional")
+	}
+}
+
+func TestParseKeyValues(t *testing.T) {
+	tests := []struct {
+		name      string
+		pairs     []string
+		want      string
+		expectErr bool
+	}{
+		{name: "pairs", pairs: []string{"Authorization=Bearer a=b", " gather = incident-42 "}, want: "map[Authorization:Bearer a=b gather:incident-42]"},
+		{name: "empty entries skipped", pairs: []string{"", " "}, want: "map[]"},
+		{name: "missing value separator", pairs: []string{"gather"}, expectErr: true},
+		{name: "missing key", pairs: []string{"=x"}, expectErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := map[string]string{}
+			err := parseKeyValues("label", tt.pairs, got)
+			if tt.expectErr {
+				if err == nil || !strings.Contains(err.Error(), "--label") {
+					t.Errorf("expected an error naming --label, got %v", err)
+				}
+				return
+			}
+			if err != nil || fmt.Sprint(got) != tt.want {
+				t.Errorf("got %v (err %v), want %s", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestExitCode(t *testing.T) {
+	tests := []struct {
+		err  error
+		want int
+	}{
+		{errors.New("boom"), 1},
+		{fmt.Errorf("get workspace: %w", mustgather.ErrAuth), 2},
+		{fmt.Errorf("get workspace: %w", mustgather.ErrWorkspaceNotFound), 3},
+		{mustgather.ErrThrottled, 4},
+		{errors.Join(fmt.Errorf("gather interrupted: %w", mustgather.ErrPartialData), errors.New("upload failed")), 5},
+	}
+	for _, tt := range tests {
+		if got := exitCode(tt.err); got != tt.want {
+			t.Errorf("exitCode(%v) = %d, want %d", tt.err, got, tt.want)
+		}
+	}
+}
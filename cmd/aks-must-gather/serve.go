@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+	"kubectl-must-gather/pkg/mustgather"
+)
+
+var serveAddr string
+
+var serveCmd = &cobra.Command{
+	Use:   "serve <bundle>",
+	Short: "Browse a must-gather bundle in a local web UI",
+	Long: `serve starts a local HTTP server to browse a bundle (archive or extracted
+directory): the namespace and pod tree with their stitched logs, a log viewer
+filtered by time and text, the KubeEvents timeline, and a browser over the rows
+of every exported table. It runs until interrupted.`,
+	Example: `  aks-must-gather serve must-gather-20240101-120000.tar.gz --addr 127.0.0.1:8080`,
+	Args:    cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		s, err := mustgather.NewArchiveServer(args[0])
+		if err != nil {
+			return err
+		}
+		defer s.Close()
+		ln, err := net.Listen("tcp", serveAddr)
+		if err != nil {
+			return fmt.Errorf("listen: %w", err)
+		}
+		srv := &http.Server{Handler: s.Handler(), ReadHeaderTimeout: 10 * time.Second}
+
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		go func() {
+			<-ctx.Done()
+			shutdown, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			_ = srv.Shutdown(shutdown)
+		}()
+		fmt.Fprintf(os.Stderr, "Serving %s at http://%s/ (interrupt to stop)\n", args[0], ln.Addr())
+		if err := srv.Serve(ln); !errors.Is(err, http.ErrServerClosed) {
+			return err
+		}
+		return nil
+	},
+}
+
+func init() {
+	serveCmd.Flags().StringVar(&serveAddr, "addr", "127.0.0.1:8080", "Address to listen on; keep it on localhost unless the bundle may be shared")
+	rootCmd.AddCommand(serveCmd)
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"kubectl-must-gather/pkg/mustgather"
+)
+
+var statsFormat string
+
+var statsCmd = &cobra.Command{
+	Use:   "stats <bundle>",
+	Short: "Show the sizes, row counts and time coverage of a bundle",
+	Long: `stats counts what a bundle (archive or extracted directory) holds: files and
+bytes per top-level directory, rows, bytes and the oldest and newest row of
+each table next to the window it was queried over, and rows per namespace.
+Tables without rows, or whose rows stop well before the end of the window, are
+pointed out, to judge whether a gather captured an incident.`,
+	Example: `  aks-must-gather stats must-gather-20240101-120000.tar.gz
+  aks-must-gather stats must-gather-20240101-120000.tar.gz --format json | jq '.tables[] | select(.rows == 0)'`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if statsFormat != "text" && statsFormat != "json" {
+			return fmt.Errorf("invalid --format %q: expected text or json", statsFormat)
+		}
+		s, err := mustgather.Stats(args[0])
+		if err != nil {
+			return err
+		}
+		if statsFormat == "json" {
+			b, _ := json.MarshalIndent(s, "", "  ")
+			fmt.Println(string(b))
+			return nil
+		}
+		return s.Render(os.Stdout)
+	},
+}
+
+func init() {
+	statsCmd.Flags().StringVar(&statsFormat, "format", "text", "Output format: text or json")
+	rootCmd.AddCommand(statsCmd)
+}
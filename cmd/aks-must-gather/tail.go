@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"kubectl-must-gather/pkg/mustgather"
+)
+
+var (
+	tailOpts  mustgather.TailOptions
+	tailColor string
+)
+
+var tailCmd = &cobra.Command{
+	Use:   "tail <bundle> [pod-query]",
+	Short: "Replay the stitched logs of a bundle interleaved across pods, like stern",
+	Long: `tail merges the stitched container logs of a bundle (archive or extracted
+directory) in time order, each line prefixed with its namespace, pod and
+container and colored per pod and container like stern. pod-query, --namespace
+and --container are regular expressions; --since and --until narrow the output
+to the incident window.`,
+	Example: `  aks-must-gather tail must-gather-20240101-120000.tar.gz 'cart|web' -n shop --since 15m
+  aks-must-gather tail must-gather-20240101-120000.tar.gz --since 2024-01-01T11:50 --until 2024-01-01T12:05 --grep 'error|panic'`,
+	Args: cobra.RangeArgs(1, 2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		opts := tailOpts
+		if len(args) == 2 {
+			opts.Pod = args[1]
+		}
+		switch tailColor {
+		case "always":
+			opts.Color = true
+		case "never":
+		case "auto":
+			if fi, err := os.Stdout.Stat(); err == nil && fi.Mode()&os.ModeCharDevice != 0 {
+				opts.Color = true
+			}
+		default:
+			return fmt.Errorf("invalid --color %q: expected auto, always or never", tailColor)
+		}
+		_, err := mustgather.Tail(args[0], opts, os.Stdout)
+		return err
+	},
+}
+
+func init() {
+	f := tailCmd.Flags()
+	f.StringVarP(&tailOpts.Namespace, "namespace", "n", "", "Regular expression of the namespaces to show")
+	f.StringVarP(&tailOpts.Container, "container", "c", "", "Regular expression of the containers to show")
+	f.StringVar(&tailOpts.Since, "since", "", "Show lines from this time (RFC 3339, or UTC like 2024-01-01T15:04), or this long before the last line, e.g. 15m")
+	f.StringVar(&tailOpts.Until, "until", "", "Show lines before this time (RFC 3339, or UTC like 2024-01-01T15:04)")
+	f.StringVar(&tailOpts.Grep, "grep", "", "Show only lines whose message matches this regular expression")
+	f.BoolVarP(&tailOpts.Timestamps, "timestamps", "t", false, "Print the timestamp of each line")
+	f.Float64Var(&tailOpts.Replay, "replay", 0, "Pace the output at this multiple of the original speed, e.g. 10; 0 prints at once")
+	f.StringVar(&tailColor, "color", "auto", "Color output: auto, always or never")
+	rootCmd.AddCommand(tailCmd)
+}
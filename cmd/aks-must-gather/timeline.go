@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"kubectl-must-gather/pkg/mustgather"
+)
+
+var (
+	timelineOpts  mustgather.TimelineOptions
+	timelineColor string
+)
+
+var timelineCmd = &cobra.Command{
+	Use:   "timeline <bundle>",
+	Short: "Print a merged timeline of events, error logs and node conditions of a bundle",
+	Long: `timeline merges the KubeEvents, error-level container log lines and node
+condition changes of a bundle (archive or extracted directory) into one list in
+time order. Error lines are counted per container and minute, with the first
+of them as a sample. --around and --window center the timeline on a moment of
+an incident; --namespace and --grep are regular expressions.`,
+	Example: `  aks-must-gather timeline must-gather-20240101-120000.tar.gz -n payments --around 2024-01-01T11:58 --window 10m
+  aks-must-gather timeline must-gather-20240101-120000.tar.gz --kind node --kind event --warnings`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		opts := timelineOpts
+		switch timelineColor {
+		case "always":
+			opts.Color = true
+		case "never":
+		case "auto":
+			if fi, err := os.Stdout.Stat(); err == nil && fi.Mode()&os.ModeCharDevice != 0 {
+				opts.Color = true
+			}
+		default:
+			return fmt.Errorf("invalid --color %q: expected auto, always or never", timelineColor)
+		}
+		n, err := mustgather.Timeline(args[0], opts, os.Stdout)
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			fmt.Fprintln(os.Stderr, "No timeline entries match")
+		}
+		return nil
+	},
+}
+
+func init() {
+	f := timelineCmd.Flags()
+	f.StringVarP(&timelineOpts.Namespace, "namespace", "n", "", "Regular expression of the namespaces of events and error logs to show; node conditions are always shown")
+	f.StringVar(&timelineOpts.Around, "around", "", "Show the entries within --window of this time (RFC 3339, or UTC like 2024-01-01T15:04)")
+	f.DurationVar(&timelineOpts.Window, "window", 0, "How far either side of --around to show (default 10m)")
+	f.StringArrayVar(&timelineOpts.Kinds, "kind", nil, "Show only entries of this kind: event, error or node; repeat for several (default: all)")
+	f.BoolVar(&timelineOpts.WarningsOnly, "warnings", false, "Leave out Normal events")
+	f.StringVar(&timelineOpts.Grep, "grep", "", "Show only entries whose object or text matches this regular expression")
+	f.StringVar(&timelineColor, "color", "auto", "Color output: auto, always or never")
+	rootCmd.AddCommand(timelineCmd)
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"kubectl-must-gather/pkg/mustgather"
+)
+
+var (
+	verifyManifest string
+	verifyFormat   string
+)
+
+var verifyCmd = &cobra.Command{
+	Use:   "verify <bundle>",
+	Short: "Check a bundle's integrity before attaching it to a case",
+	Long: `verify reads every file of a bundle (archive or extracted directory) and
+prints a health report. It checks that:
+  - the archive is not damaged or cut short, and reads to its end;
+  - the archive and every file inside it match the checksum manifest
+    (<bundle>.manifest.json, written with --sign-key), when there is one;
+  - every JSON file and every line of the NDJSON table parts parses, and no
+    part was cut short by an interrupted gather;
+  - each table holds the rows its summary.json counts, and every table
+    index.json lists was exported.
+
+Interrupted gathers and windows the service still truncated are reported as
+warnings. The command exits non-zero when a check fails. It does not check
+the manifest's signature; use cosign verify-blob for that.`,
+	Example: `  aks-must-gather verify must-gather-20240101-120000.tar.gz
+  aks-must-gather verify ./must-gather --manifest must-gather.tar.gz.manifest.json`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if verifyFormat != "text" && verifyFormat != "json" {
+			return fmt.Errorf("invalid --format %q: expected text or json", verifyFormat)
+		}
+		r, err := mustgather.Verify(args[0], mustgather.VerifyOptions{Manifest: verifyManifest})
+		if err != nil {
+			return err
+		}
+		if verifyFormat == "json" {
+			b, _ := json.MarshalIndent(r, "", "  ")
+			fmt.Println(string(b))
+		} else if err := r.Render(os.Stdout); err != nil {
+			return err
+		}
+		if !r.OK() {
+			return errors.New("verify failed: the bundle is damaged or incomplete")
+		}
+		return nil
+	},
+}
+
+func init() {
+	verifyCmd.Flags().StringVar(&verifyManifest, "manifest", "", "Checksum manifest to check the bundle against (default: <bundle>.manifest.json, if present)")
+	verifyCmd.Flags().StringVar(&verifyFormat, "format", "text", "Output format: text or json")
+	rootCmd.AddCommand(verifyCmd)
+}
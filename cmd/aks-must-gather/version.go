@@ -0,0 +1,66 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+	"kubectl-must-gather/pkg/version"
+)
+
+var versionFormat string
+
+var versionCmd = &cobra.Command{
+	Use:   "version",
+	Short: "Print the version, git commit, build date and Azure SDK versions",
+	Long: `version prints the build metadata of aks-must-gather. The same metadata is
+written to metadata/tool.json in every archive, so a bundle records the build
+that produced it.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		info := version.Get()
+		switch versionFormat {
+		case "json":
+			b, _ := json.MarshalIndent(info, "", "  ")
+			fmt.Println(string(b))
+			return nil
+		case "text":
+		default:
+			return fmt.Errorf("invalid --format %q: expected text or json", versionFormat)
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintf(w, "Version:\t%s\n", info.Version)
+		if info.Commit != "" {
+			commit := info.Commit
+			if info.Modified {
+				commit += " (modified)"
+			}
+			fmt.Fprintf(w, "Git commit:\t%s\n", commit)
+		}
+		if info.BuildDate != "" {
+			fmt.Fprintf(w, "Build date:\t%s\n", info.BuildDate)
+		}
+		fmt.Fprintf(w, "Go version:\t%s\n", info.GoVersion)
+		fmt.Fprintf(w, "Platform:\t%s\n", info.Platform)
+		if err := w.Flush(); err != nil {
+			return err
+		}
+		if mods := info.AzureSDKModules(); len(mods) > 0 {
+			fmt.Println("Azure SDK:")
+			w = tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+			for _, m := range mods {
+				fmt.Fprintf(w, "  %s\t%s\n", strings.TrimPrefix(m, "github.com/Azure/azure-sdk-for-go/"), info.AzureSDK[m])
+			}
+		}
+		return w.Flush()
+	},
+}
+
+func init() {
+	versionCmd.Flags().StringVar(&versionFormat, "format", "text", "Output format: text or json")
+	rootCmd.AddCommand(versionCmd)
+	rootCmd.Version = version.Get().String()
+}
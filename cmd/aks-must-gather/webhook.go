@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+	"kubectl-must-gather/pkg/mustgather"
+)
+
+var (
+	webhookAddr        string
+	webhookMetricsAddr string
+	webhookOpts        mustgather.AlertOptions
+	webhookConfig      mustgather.Config
+)
+
+var webhookCmd = &cobra.Command{
+	Use:   "webhook",
+	Short: "Gather when an Azure Monitor alert fires, from an action group webhook",
+	Long: `webhook listens for Azure Monitor alerts sent by an action group webhook
+with the common alert schema. Each fired alert starts a gather of the AKS
+cluster or Log Analytics workspace it targets, scoped to the namespace of its
+dimensions, from --lookback before it fired (or the start of the alert
+condition's window, if earlier, up to --max-lookback before it arrived). Gathers run one at a time in the background;
+resolved and repeated alerts start none. The webhook URL must carry the token,
+e.g. https://<host>/?token=<token>.`,
+	Example: `  aks-must-gather webhook --addr :8080 --token "$(openssl rand -hex 16)" --output-dir /data --profiles aks-debug`,
+	Args:    cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		opts := webhookOpts
+		config := webhookConfig
+		config.Flags = map[string]string{}
+		if webhookMetricsAddr != "" {
+			config.Metrics = mustgather.NewMetrics()
+		}
+		opts.Config = &config
+		l, err := mustgather.NewAlertListener(opts)
+		if err != nil {
+			return err
+		}
+		ln, err := net.Listen("tcp", webhookAddr)
+		if err != nil {
+			return fmt.Errorf("listen: %w", err)
+		}
+		srv := &http.Server{Handler: l.Handler(), ReadHeaderTimeout: 10 * time.Second}
+
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		if webhookMetricsAddr != "" {
+			if err := serveMetrics(ctx, webhookMetricsAddr, config.Metrics); err != nil {
+				return err
+			}
+		}
+		done := make(chan struct{})
+		go func() {
+			l.Run(ctx)
+			close(done)
+		}()
+		go func() {
+			<-ctx.Done()
+			shutdown, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			_ = srv.Shutdown(shutdown)
+		}()
+		fmt.Fprintf(os.Stderr, "Listening for alerts at http://%s/ (interrupt to stop)\n", ln.Addr())
+		if err := srv.Serve(ln); !errors.Is(err, http.ErrServerClosed) {
+			return err
+		}
+		// Let the running gather finish its partial archive
+		<-done
+		return nil
+	},
+}
+
+func init() {
+	f := webhookCmd.Flags()
+	f.StringVar(&webhookAddr, "addr", ":8080", "Address to listen on; put TLS in front of it, e.g. an ingress, as action groups send the token in the URL")
+	f.StringVar(&webhookOpts.Token, "token", "", "Secret the webhook URL must carry as ?token=; required")
+	f.StringVar(&webhookOpts.OutputDir, "output-dir", ".", "Directory the archives are written to, named after the alert rule and time")
+	f.DurationVar(&webhookOpts.Lookback, "lookback", time.Hour, "How long before the alert fired each gather starts")
+	f.DurationVar(&webhookOpts.MaxLookback, "max-lookback", 24*time.Hour, "The longest before an alert is received its gather starts, whatever the alert's times")
+	f.StringVar(&webhookConfig.Profiles, "profiles", "", "Optional comma-separated profiles to gather: "+profileNames())
+	_ = webhookCmd.RegisterFlagCompletionFunc("profiles", completeProfiles)
+	f.StringVar(&webhookConfig.TableFilter, "tables", "", "Optional comma-separated list of tables to gather (overrides profiles)")
+	f.StringVar(&webhookConfig.UploadTo, "upload-to", "", "Also upload each archive to this Azure blob container URL")
+	f.StringVar(&webhookConfig.NotifyWebhook, "notify-webhook", "", "Post a summary of each gather to this Slack or Teams incoming webhook URL when it finishes")
+	f.StringVar(&webhookMetricsAddr, "metrics-addr", "", "Serve Prometheus metrics of the gathers at http://<addr>/metrics, e.g. :9090; keep it off the address exposed for alerts")
+	rootCmd.AddCommand(webhookCmd)
+}
@@ -0,0 +1,283 @@
+// Package bundle reads must-gather archives and tracks their format version.
+//
+// Every archive written by aks-must-gather carries metadata/bundle.json with a
+// bundleFormatVersion. Archives written before versioning was introduced have
+// no such file and are treated as version 0. Readers in this package accept
+// every version up to FormatVersion, so downstream tooling can open old and
+// new bundles alike.
+package bundle
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// FormatVersion is the bundle layout written by this version of the tool.
+//
+//	0: no metadata/bundle.json; stitched container and event logs only.
+//	1: metadata/bundle.json; adds controlplane/, audit/, per-pod events.log,
+//	   metadata/freshness.json, metadata/run.json and queries/snippets/.
+//	2: restarted containers are split into <container>.previous.log,
+//	   <container>.previous-2.log, ... instead of one concatenated log.
+const FormatVersion = 2
+
+// InfoPath is where the bundle format information is stored in an archive.
+const InfoPath = "metadata/bundle.json"
+
+// Info is the content of metadata/bundle.json.
+type Info struct {
+	BundleFormatVersion int    `json:"bundleFormatVersion"`
+	Generator           string `json:"generator,omitempty"`
+	MigratedFrom        *int   `json:"migratedFrom,omitempty"`
+}
+
+// NewInfo returns the Info for a bundle written in the current format.
+func NewInfo() Info {
+	return Info{BundleFormatVersion: FormatVersion, Generator: "aks-must-gather"}
+}
+
+// Bundle is an opened must-gather archive, extracted to a local directory.
+type Bundle struct {
+	// Dir is the root of the extracted bundle.
+	Dir  string
+	Info Info
+	// temp is set when Dir was created by Open and must be removed by Close.
+	temp bool
+}
+
+// Open opens a bundle from a .tar.gz archive or an already extracted
+// directory. Archives are extracted to a temporary directory that Close
+// removes.
+func Open(path string) (*Bundle, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	b := &Bundle{Dir: path}
+	if !fi.IsDir() {
+		dir, err := os.MkdirTemp("", "aks-must-gather-bundle-")
+		if err != nil {
+			return nil, fmt.Errorf("create extract dir: %w", err)
+		}
+		b.Dir, b.temp = dir, true
+		if err := extract(path, dir); err != nil {
+			b.Close()
+			return nil, fmt.Errorf("extract %s: %w", path, err)
+		}
+	}
+	if err := b.readInfo(); err != nil {
+		b.Close()
+		return nil, err
+	}
+	return b, nil
+}
+
+// Close removes the extracted files of a bundle opened from an archive.
+func (b *Bundle) Close() error {
+	if b.temp {
+		return os.RemoveAll(b.Dir)
+	}
+	return nil
+}
+
+func (b *Bundle) readInfo() error {
+	data, err := b.ReadFile(InfoPath)
+	if errors.Is(err, os.ErrNotExist) {
+		// Bundles from before versioning
+		b.Info = Info{BundleFormatVersion: 0}
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, &b.Info); err != nil {
+		return fmt.Errorf("parse %s: %w", InfoPath, err)
+	}
+	if b.Info.BundleFormatVersion > FormatVersion {
+		return fmt.Errorf("bundle format version %d is newer than the supported version %d; upgrade aks-must-gather", b.Info.BundleFormatVersion, FormatVersion)
+	}
+	return nil
+}
+
+// Version returns the bundle's format version.
+func (b *Bundle) Version() int {
+	return b.Info.BundleFormatVersion
+}
+
+// Incomplete reports whether the gather that wrote the bundle was interrupted,
+// leaving tables missing or partially exported.
+func (b *Bundle) Incomplete() bool {
+	data, err := b.ReadFile("index.json")
+	if err != nil {
+		return false
+	}
+	var idx struct {
+		Incomplete bool `json:"incomplete"`
+	}
+	return json.Unmarshal(data, &idx) == nil && idx.Incomplete
+}
+
+// ReadFile reads a file by its slash-separated path inside the bundle.
+func (b *Bundle) ReadFile(name string) ([]byte, error) {
+	return os.ReadFile(filepath.Join(b.Dir, filepath.FromSlash(name)))
+}
+
+// Files returns the slash-separated paths of all files in the bundle, sorted.
+func (b *Bundle) Files() ([]string, error) {
+	var files []string
+	err := filepath.Walk(b.Dir, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if fi.Mode().IsRegular() {
+			rel, err := filepath.Rel(b.Dir, p)
+			if err != nil {
+				return err
+			}
+			files = append(files, filepath.ToSlash(rel))
+		}
+		return nil
+	})
+	sort.Strings(files)
+	return files, err
+}
+
+// Table is an exported table inside a bundle.
+type Table struct {
+	// Name is the Log Analytics table name.
+	Name string
+	// Dir is the table's directory under tables/.
+	Dir string
+	// Parts are the NDJSON part files in export (time) order.
+	Parts []string
+}
+
+// Tables lists the exported tables, in the order recorded in index.json when
+// available and by name otherwise.
+func (b *Bundle) Tables() ([]Table, error) {
+	entries, err := os.ReadDir(filepath.Join(b.Dir, "tables"))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var tables []Table
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		t := Table{Name: e.Name(), Dir: "tables/" + e.Name()}
+		if data, err := b.ReadFile(t.Dir + "/summary.json"); err == nil {
+			var sum struct {
+				Table string `json:"table"`
+			}
+			if json.Unmarshal(data, &sum) == nil && sum.Table != "" {
+				t.Name = sum.Table
+			}
+		}
+		parts, _ := filepath.Glob(filepath.Join(b.Dir, "tables", e.Name(), "parts", "*.ndjson"))
+		sort.Strings(parts)
+		for _, p := range parts {
+			t.Parts = append(t.Parts, t.Dir+"/parts/"+filepath.Base(p))
+		}
+		tables = append(tables, t)
+	}
+
+	// Tables missing from index.json sort after the indexed ones
+	rank := map[string]int{}
+	if data, err := b.ReadFile("index.json"); err == nil {
+		var idx struct {
+			Tables []string `json:"tables"`
+		}
+		if json.Unmarshal(data, &idx) == nil {
+			for i, t := range idx.Tables {
+				rank[t] = i - len(idx.Tables)
+			}
+		}
+	}
+	sort.SliceStable(tables, func(i, j int) bool {
+		ri, rj := rank[tables[i].Name], rank[tables[j].Name]
+		if ri != rj {
+			return ri < rj
+		}
+		return tables[i].Name < tables[j].Name
+	})
+	return tables, nil
+}
+
+// ReadPart calls fn for every row of an NDJSON part file.
+func (b *Bundle) ReadPart(part string, fn func(row map[string]any) error) error {
+	f, err := os.Open(filepath.Join(b.Dir, filepath.FromSlash(part)))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	dec := json.NewDecoder(f)
+	for {
+		var row map[string]any
+		if err := dec.Decode(&row); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("decode %s: %w", part, err)
+		}
+		if err := fn(row); err != nil {
+			return err
+		}
+	}
+}
+
+// extract unpacks a .tar.gz archive into dir, rejecting entries that would
+// escape it.
+func extract(archive, dir string) error {
+	f, err := os.Open(archive)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return err
+	}
+	defer gz.Close()
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		name := filepath.Clean(filepath.FromSlash(hdr.Name))
+		if filepath.IsAbs(name) || name == ".." || strings.HasPrefix(name, ".."+string(filepath.Separator)) {
+			return fmt.Errorf("invalid path in archive: %s", hdr.Name)
+		}
+		dst := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+			return err
+		}
+		out, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(out, tr); err != nil {
+			out.Close()
+			return err
+		}
+		if err := out.Close(); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,168 @@
+package bundle
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeArchive creates a .tar.gz with the given path -> content entries.
+func writeArchive(t *testing.T, files map[string]string) string {
+	t.Helper()
+	name := filepath.Join(t.TempDir(), "bundle.tar.gz")
+	f, err := os.Create(name)
+	if err != nil {
+		t.Fatalf("create archive: %v", err)
+	}
+	gz := gzip.NewWriter(f)
+	tw := tar.NewWriter(gz)
+	for p, c := range files {
+		if err := tw.WriteHeader(&tar.Header{Name: p, Mode: 0644, Size: int64(len(c)), Typeflag: tar.TypeReg}); err != nil {
+			t.Fatalf("write header: %v", err)
+		}
+		_, _ = tw.Write([]byte(c))
+	}
+	tw.Close()
+	gz.Close()
+	f.Close()
+	return name
+}
+
+func TestOpenVersions(t *testing.T) {
+	tests := []struct {
+		name        string
+		files       map[string]string
+		wantVersion int
+		wantErr     string
+	}{
+		{
+			name:        "unversioned bundle is version 0",
+			files:       map[string]string{"index.json": `{"tables":[]}`},
+			wantVersion: 0,
+		},
+		{
+			name:        "older version",
+			files:       map[string]string{InfoPath: `{"bundleFormatVersion":1}`},
+			wantVersion: 1,
+		},
+		{
+			name:        "current version",
+			files:       map[string]string{InfoPath: `{"bundleFormatVersion":2}`},
+			wantVersion: 2,
+		},
+		{
+			name:    "newer version is rejected",
+			files:   map[string]string{InfoPath: `{"bundleFormatVersion":99}`},
+			wantErr: "newer than the supported version",
+		},
+		{
+			name:    "path traversal is rejected",
+			files:   map[string]string{"../evil": "x"},
+			wantErr: "invalid path",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, err := Open(writeArchive(t, tt.files))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("expected error containing %q, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Open failed: %v", err)
+			}
+			defer b.Close()
+			if b.Version() != tt.wantVersion {
+				t.Errorf("expected version %d, got %d", tt.wantVersion, b.Version())
+			}
+		})
+	}
+}
+
+func TestTablesAndParts(t *testing.T) {
+	b, err := Open(writeArchive(t, map[string]string{
+		"index.json":                                 `{"tables":["KubeEvents","ContainerLogV2"]}`,
+		"tables/ContainerLogV2/summary.json":         `{"table":"ContainerLogV2"}`,
+		"tables/ContainerLogV2/parts/0001-b.ndjson":  `{"n":3}` + "\n",
+		"tables/ContainerLogV2/parts/0000-a.ndjson":  `{"n":1}` + "\n" + `{"n":2}` + "\n",
+		"tables/KubeEvents/summary.json":             `{"table":"KubeEvents"}`,
+		"tables/Extra/parts/0000-a.ndjson":           `{"n":4}` + "\n",
+		"namespaces/default/pods/web/nginx.log":      "line\n",
+		"tables/KubeEvents/parts/0000-empty.ndjson":  "",
+		"tables/ContainerLogV2/parts/not-a-part.txt": "",
+	}))
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer b.Close()
+	dir := b.Dir
+
+	tables, err := b.Tables()
+	if err != nil {
+		t.Fatalf("Tables failed: %v", err)
+	}
+	var names []string
+	for _, tb := range tables {
+		names = append(names, tb.Name)
+	}
+	if got := strings.Join(names, ","); got != "KubeEvents,ContainerLogV2,Extra" {
+		t.Errorf("unexpected table order: %s", got)
+	}
+
+	var seen []float64
+	for _, part := range tables[1].Parts {
+		err := b.ReadPart(part, func(row map[string]any) error {
+			seen = append(seen, row["n"].(float64))
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("ReadPart failed: %v", err)
+		}
+	}
+	if len(seen) != 3 || seen[0] != 1 || seen[2] != 3 {
+		t.Errorf("expected rows 1,2,3 in part order, got %v", seen)
+	}
+
+	files, _ := b.Files()
+	if len(files) != 9 {
+		t.Errorf("expected 9 files, got %v", files)
+	}
+
+	b.Close()
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Errorf("expected extract dir to be removed after Close")
+	}
+}
+
+func TestIncomplete(t *testing.T) {
+	tests := []struct {
+		name  string
+		index string
+		want  bool
+	}{
+		{"complete", `{"tables":["Heartbeat"]}`, false},
+		{"interrupted", `{"tables":["Heartbeat"],"incomplete":true,"completedTables":[]}`, true},
+		{"no index", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			files := map[string]string{InfoPath: `{"bundleFormatVersion":2}`}
+			if tt.index != "" {
+				files["index.json"] = tt.index
+			}
+			b, err := Open(writeArchive(t, files))
+			if err != nil {
+				t.Fatalf("Open failed: %v", err)
+			}
+			defer b.Close()
+			if got := b.Incomplete(); got != tt.want {
+				t.Errorf("Incomplete() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
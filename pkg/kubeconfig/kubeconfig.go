@@ -0,0 +1,169 @@
+// Package kubeconfig reads the parts of a kubeconfig that identify the AKS
+// cluster behind a context, the way kubectl resolves them: --kubeconfig, else
+// the $KUBECONFIG list, else ~/.kube/config.
+package kubeconfig
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ExtensionName is the name of the context or cluster extension that pins a
+// context to its AKS cluster and Log Analytics workspace:
+//
+//	extensions:
+//	- name: aks-must-gather
+//	  extension:
+//	    clusterResourceId: /subscriptions/.../managedClusters/<name>
+//	    workspaceResourceId: /subscriptions/.../workspaces/<name>
+const ExtensionName = "aks-must-gather"
+
+// Context is a resolved kubeconfig context.
+type Context struct {
+	Name    string
+	Cluster string
+	Server  string
+	// ClusterResourceID and WorkspaceResourceID come from the aks-must-gather
+	// extension of the context, or else of its cluster; empty if unset.
+	ClusterResourceID   string
+	WorkspaceResourceID string
+}
+
+// Host returns the host name of the API server, without port.
+func (c *Context) Host() string {
+	u, err := url.Parse(c.Server)
+	if err != nil {
+		return ""
+	}
+	return u.Hostname()
+}
+
+type extension struct {
+	Name      string `yaml:"name"`
+	Extension struct {
+		ClusterResourceID   string `yaml:"clusterResourceId"`
+		WorkspaceResourceID string `yaml:"workspaceResourceId"`
+	} `yaml:"extension"`
+}
+
+type file struct {
+	CurrentContext string `yaml:"current-context"`
+	Clusters       []struct {
+		Name    string `yaml:"name"`
+		Cluster struct {
+			Server     string      `yaml:"server"`
+			Extensions []extension `yaml:"extensions"`
+		} `yaml:"cluster"`
+	} `yaml:"clusters"`
+	Contexts []struct {
+		Name    string `yaml:"name"`
+		Context struct {
+			Cluster    string      `yaml:"cluster"`
+			Extensions []extension `yaml:"extensions"`
+		} `yaml:"context"`
+	} `yaml:"contexts"`
+}
+
+// Paths returns the kubeconfig files kubectl would read: explicit if set,
+// else the entries of $KUBECONFIG, else ~/.kube/config.
+func Paths(explicit string) []string {
+	if explicit != "" {
+		return []string{explicit}
+	}
+	var paths []string
+	for _, p := range filepath.SplitList(os.Getenv("KUBECONFIG")) {
+		if p != "" {
+			paths = append(paths, p)
+		}
+	}
+	if len(paths) > 0 {
+		return paths
+	}
+	if home, err := os.UserHomeDir(); err == nil {
+		return []string{filepath.Join(home, ".kube", "config")}
+	}
+	return nil
+}
+
+// Load resolves context name, or the current context if empty, from the
+// kubeconfig files of Paths(explicit). As with kubectl, the files are merged:
+// the first current-context set and the first entry of each name win, and
+// missing $KUBECONFIG entries are skipped.
+func Load(explicit, name string) (*Context, error) {
+	var merged file
+	read := 0
+	for _, p := range Paths(explicit) {
+		data, err := os.ReadFile(p)
+		if errors.Is(err, os.ErrNotExist) && explicit == "" {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("read kubeconfig: %w", err)
+		}
+		var f file
+		if err := yaml.Unmarshal(data, &f); err != nil {
+			return nil, fmt.Errorf("parse kubeconfig %s: %w", p, err)
+		}
+		read++
+		if merged.CurrentContext == "" {
+			merged.CurrentContext = f.CurrentContext
+		}
+		merged.Clusters = append(merged.Clusters, f.Clusters...)
+		merged.Contexts = append(merged.Contexts, f.Contexts...)
+	}
+	if read == 0 {
+		return nil, errors.New("no kubeconfig found")
+	}
+	return merged.resolve(name)
+}
+
+func (f *file) resolve(name string) (*Context, error) {
+	if name == "" {
+		name = f.CurrentContext
+	}
+	if name == "" {
+		return nil, errors.New("kubeconfig has no current context; pass --context")
+	}
+	c := &Context{Name: name}
+	found := false
+	for _, ctx := range f.Contexts {
+		if ctx.Name == name {
+			c.Cluster = ctx.Context.Cluster
+			c.ClusterResourceID, c.WorkspaceResourceID = fromExtensions(ctx.Context.Extensions)
+			found = true
+			break
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("context %q not found in kubeconfig", name)
+	}
+	for _, cl := range f.Clusters {
+		if cl.Name == c.Cluster {
+			c.Server = cl.Cluster.Server
+			cluster, workspace := fromExtensions(cl.Cluster.Extensions)
+			if c.ClusterResourceID == "" {
+				c.ClusterResourceID = cluster
+			}
+			if c.WorkspaceResourceID == "" {
+				c.WorkspaceResourceID = workspace
+			}
+			break
+		}
+	}
+	return c, nil
+}
+
+func fromExtensions(exts []extension) (cluster, workspace string) {
+	for _, e := range exts {
+		if e.Name == ExtensionName {
+			return strings.TrimSpace(e.Extension.ClusterResourceID), strings.TrimSpace(e.Extension.WorkspaceResourceID)
+		}
+	}
+	return "", ""
+}
@@ -0,0 +1,128 @@
+package kubeconfig
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const aksConfig = `apiVersion: v1
+kind: Config
+current-context: shop
+clusters:
+- name: shop
+  cluster:
+    server: https://shop-dns-1a2b3c4d.hcp.eastus.azmk8s.io:443
+    extensions:
+    - name: aks-must-gather
+      extension:
+        clusterResourceId: /subscriptions/s/resourceGroups/rg/providers/Microsoft.ContainerService/managedClusters/shop
+- name: lab
+  cluster:
+    server: https://lab.example.com
+contexts:
+- name: shop
+  context:
+    cluster: shop
+    user: clusterUser_rg_shop
+- name: lab-admin
+  context:
+    cluster: lab
+    extensions:
+    - name: aks-must-gather
+      extension:
+        workspaceResourceId: /subscriptions/s/resourceGroups/rg/providers/Microsoft.OperationalInsights/workspaces/lab
+`
+
+func writeConfig(t *testing.T, content string) string {
+	t.Helper()
+	p := filepath.Join(t.TempDir(), "config")
+	if err := os.WriteFile(p, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestLoad(t *testing.T) {
+	p := writeConfig(t, aksConfig)
+	tests := []struct {
+		name      string
+		context   string
+		want      Context
+		host      string
+		expectErr string
+	}{
+		{
+			name: "current context",
+			want: Context{Name: "shop", Cluster: "shop", Server: "https://shop-dns-1a2b3c4d.hcp.eastus.azmk8s.io:443",
+				ClusterResourceID: "/subscriptions/s/resourceGroups/rg/providers/Microsoft.ContainerService/managedClusters/shop"},
+			host: "shop-dns-1a2b3c4d.hcp.eastus.azmk8s.io",
+		},
+		{
+			name:    "named context with its own extension",
+			context: "lab-admin",
+			want: Context{Name: "lab-admin", Cluster: "lab", Server: "https://lab.example.com",
+				WorkspaceResourceID: "/subscriptions/s/resourceGroups/rg/providers/Microsoft.OperationalInsights/workspaces/lab"},
+			host: "lab.example.com",
+		},
+		{
+			name:      "unknown context",
+			context:   "nope",
+			expectErr: `context "nope" not found`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Load(p, tt.context)
+			if tt.expectErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.expectErr) {
+					t.Fatalf("expected error %q, got %v", tt.expectErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Load failed: %v", err)
+			}
+			if *got != tt.want {
+				t.Errorf("got %+v, want %+v", *got, tt.want)
+			}
+			if h := got.Host(); h != tt.host {
+				t.Errorf("Host() = %q, want %q", h, tt.host)
+			}
+		})
+	}
+}
+
+func TestLoadMergesKUBECONFIG(t *testing.T) {
+	first := writeConfig(t, `current-context: lab
+contexts:
+- name: lab
+  context:
+    cluster: lab
+`)
+	second := writeConfig(t, aksConfig)
+	missing := filepath.Join(t.TempDir(), "missing")
+	t.Setenv("KUBECONFIG", strings.Join([]string{missing, first, second}, string(os.PathListSeparator)))
+
+	got, err := Load("", "")
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	// The first file sets the current context and its definition; the
+	// cluster comes from the second
+	if got.Name != "lab" || got.Server != "https://lab.example.com" || got.WorkspaceResourceID != "" {
+		t.Errorf("unexpected merged context: %+v", *got)
+	}
+
+	if _, err := Load(missing, ""); err == nil {
+		t.Error("expected an explicit missing kubeconfig to fail")
+	}
+}
+
+func TestLoadWithoutCurrentContext(t *testing.T) {
+	p := writeConfig(t, "contexts: []\n")
+	if _, err := Load(p, ""); err == nil || !strings.Contains(err.Error(), "--context") {
+		t.Errorf("expected a hint to pass --context, got %v", err)
+	}
+}
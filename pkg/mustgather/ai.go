@@ -4,65 +4,202 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"os/exec"
+	"io"
+	"os"
+	"path/filepath"
 	"strings"
 )
 
-type AIQueryGenerator struct{}
+// AIQueryGenerator turns natural-language questions into KQL and explains
+// query results, using whichever LLMProvider was selected.
+type AIQueryGenerator struct {
+	provider LLMProvider
+	// conversation is appended to generation and analysis prompts so
+	// follow-up questions see the earlier rounds of a session.
+	conversation string
+	// schemas holds the rendered live table schemas; when set they replace
+	// the docs/tables/ files as the source of column names.
+	schemas string
+	// meter applies the run's LLMLimits; nil is unlimited.
+	meter *llmMeter
+	// log receives warnings, those of the meter too; nil drops them.
+	log io.Writer
+}
+
+// tableDocsDir holds the per-table schema docs the prompts refer to. The
+// claude CLI reads them itself; for HTTP providers they are inlined.
+var tableDocsDir = filepath.Join("docs", "tables")
+
+// maxInlineBytes caps how much file content is inlined into one prompt for
+// providers that cannot read files.
+const maxInlineBytes = 256 << 10
+
+func NewAIQueryGenerator(providerName string) (*AIQueryGenerator, error) {
+	provider, err := NewLLMProvider(providerName)
+	if err != nil {
+		return nil, err
+	}
+	return &AIQueryGenerator{provider: provider}, nil
+}
+
+// SetConversation sets the session context sent with subsequent prompts.
+func (ai *AIQueryGenerator) SetConversation(conversation string) {
+	ai.conversation = conversation
+}
+
+// SetSchemas sets the live table schemas sent with KQL generation and fix
+// prompts.
+func (ai *AIQueryGenerator) SetSchemas(schemas []tableSchema) {
+	ai.schemas = renderSchemas(schemas)
+}
+
+// SetLimits bounds prompt size and the run's token and cost budget.
+func (ai *AIQueryGenerator) SetLimits(limits LLMLimits) {
+	ai.meter = newLLMMeter(limits)
+	ai.meter.log = ai.log
+}
+
+// setLog sends the warnings of the generator and of the budget set with
+// SetLimits to w.
+func (ai *AIQueryGenerator) setLog(w io.Writer) {
+	ai.log = w
+	if ai.meter != nil {
+		ai.meter.log = w
+	}
+}
 
-func NewAIQueryGenerator() (*AIQueryGenerator, error) {
-	// Check if claude command is available
-	if _, err := exec.LookPath("claude"); err != nil {
-		return nil, fmt.Errorf("'claude' command not found in PATH. Please install Claude CLI: %w", err)
+// warnf reports a warning on the log set with setLog, if any.
+func (ai *AIQueryGenerator) warnf(format string, args ...any) {
+	if ai.log != nil {
+		fmt.Fprintf(ai.log, "  warn: "+format+"\n", args...)
 	}
+}
+
+// Usage reports the model usage of the run so far.
+func (ai *AIQueryGenerator) Usage() LLMUsage {
+	return ai.meter.snapshot()
+}
 
-	return &AIQueryGenerator{}, nil
+// ProviderName reports the backend answering prompts.
+func (ai *AIQueryGenerator) ProviderName() string {
+	return ai.provider.Name()
 }
 
 func (ai *AIQueryGenerator) GenerateKQLQuery(ctx context.Context, userQuery string, availableTables []string) (string, error) {
-	prompt := ai.buildKQLPrompt(userQuery, availableTables)
+	prompt := ai.buildKQLPrompt(userQuery, availableTables) + ai.schemas + ai.conversation
+	if !ai.provider.ReadsFiles() && ai.schemas == "" {
+		prompt += ai.schemaContext(availableTables, ai.meter.inlineLimit(prompt))
+	}
 
 	// Stage 1: Generate KQL from natural language
-	cmd := exec.CommandContext(ctx, "claude", prompt)
-	output, err := cmd.Output()
+	output, err := ai.complete(ctx, prompt)
 	if err != nil {
-		return "", fmt.Errorf("failed to execute claude command for KQL generation: %w", err)
+		return "", fmt.Errorf("KQL generation: %w", err)
 	}
 
-	kqlQuery := strings.TrimSpace(string(output))
-	kqlQuery = ai.extractKQLFromResponse(kqlQuery)
-
-	return kqlQuery, nil
+	return ai.extractKQLFromResponse(output), nil
 }
 
 func (ai *AIQueryGenerator) AnalyzeResults(ctx context.Context, userQuery, kqlQuery, tempDir string) (string, error) {
-	prompt := ai.buildAnalysisPrompt(userQuery, kqlQuery, tempDir)
+	files, sampled := analysisFiles(tempDir)
+	prompt := ai.buildAnalysisPrompt(userQuery, kqlQuery, tempDir, sampled) + ai.conversation
+	if !ai.provider.ReadsFiles() {
+		prompt += inlineFiles(tempDir, files, ai.meter.inlineLimit(prompt))
+	}
 
 	// Stage 2: Analyze results and provide human-readable summary
-	cmd := exec.CommandContext(ctx, "claude", prompt)
-	output, err := cmd.Output()
+	output, err := ai.complete(ctx, prompt)
 	if err != nil {
-		return "", fmt.Errorf("failed to execute claude command for result analysis: %w", err)
+		return "", fmt.Errorf("result analysis: %w", err)
 	}
 
-	analysis := strings.TrimSpace(string(output))
-	return analysis, nil
+	return strings.TrimSpace(output), nil
 }
 
 func (ai *AIQueryGenerator) FixKQLQuery(ctx context.Context, userQuery, brokenQuery, errorMessage string, availableTables []string) (string, error) {
-	prompt := ai.buildFixPrompt(userQuery, brokenQuery, errorMessage, availableTables)
+	prompt := ai.buildFixPrompt(userQuery, brokenQuery, errorMessage, availableTables) + ai.schemas
+	if !ai.provider.ReadsFiles() && ai.schemas == "" {
+		prompt += ai.schemaContext(availableTables, ai.meter.inlineLimit(prompt))
+	}
 
 	// Stage 3: Fix broken KQL query
-	cmd := exec.CommandContext(ctx, "claude", prompt)
-	output, err := cmd.Output()
+	output, err := ai.complete(ctx, prompt)
 	if err != nil {
-		return "", fmt.Errorf("failed to execute claude command for KQL fix: %w", err)
+		return "", fmt.Errorf("KQL fix: %w", err)
+	}
+
+	return ai.extractKQLFromResponse(output), nil
+}
+
+// schemaContext inlines the docs/tables schema files that exist for the
+// given tables, standing in for the @docs/tables/ references in the prompts,
+// up to limit bytes.
+func (ai *AIQueryGenerator) schemaContext(tables []string, limit int) string {
+	var files []string
+	for _, t := range tables {
+		f := filepath.Join(tableDocsDir, t+".md")
+		if _, err := os.Stat(f); err == nil {
+			files = append(files, f)
+		}
 	}
+	return inlineFiles(tableDocsDir, files, limit)
+}
 
-	fixedResponse := strings.TrimSpace(string(output))
-	fixedQuery := ai.extractKQLFromResponse(fixedResponse)
+// complete sends prompt to the provider within the run's limits: the prompt
+// is truncated to the prompt size limit, refused once the budget is spent,
+// and its usage recorded. Usage is estimated from text length for providers
+// that do not report it.
+func (ai *AIQueryGenerator) complete(ctx context.Context, prompt string) (string, error) {
+	prompt = ai.meter.fit(prompt)
+	if err := ai.meter.admit(prompt); err != nil {
+		return "", err
+	}
+	if r, ok := ai.provider.(usageReporter); ok {
+		output, u, err := r.completeWithUsage(ctx, prompt)
+		if err == nil {
+			ai.meter.record(u, false)
+		}
+		return output, err
+	}
+	output, err := ai.provider.Complete(ctx, prompt)
+	if err == nil {
+		ai.meter.record(tokenUsage{input: estimateTokens(prompt), output: estimateTokens(output)}, true)
+	}
+	return output, err
+}
 
-	return fixedQuery, nil
+// inlineFiles renders files as prompt sections headed by their path relative
+// to base, stopping once limit bytes have been included. It returns "" when
+// there is nothing to include.
+func inlineFiles(base string, files []string, limit int) string {
+	var sb strings.Builder
+	for _, f := range files {
+		data, err := os.ReadFile(f)
+		if err != nil {
+			continue
+		}
+		rel, err := filepath.Rel(base, f)
+		if err != nil {
+			rel = f
+		}
+		left := limit - sb.Len()
+		if left <= 0 {
+			fmt.Fprintf(&sb, "\n(%s and further files omitted: prompt size limit reached)\n", filepath.ToSlash(rel))
+			break
+		}
+		truncated := len(data) > left
+		if truncated {
+			data = data[:left]
+		}
+		fmt.Fprintf(&sb, "\n--- %s ---\n%s\n", filepath.ToSlash(rel), data)
+		if truncated {
+			sb.WriteString("(truncated)\n")
+		}
+	}
+	if sb.Len() == 0 {
+		return ""
+	}
+	return "\n\nThe referenced files are included below.\n" + sb.String()
 }
 
 func (ai *AIQueryGenerator) buildKQLPrompt(userQuery string, availableTables []string) string {
@@ -127,7 +264,31 @@ Example response:
 Return ONLY valid JSON. No other text before or after.`, userQuery, tablesList, relevanceGuidance)
 }
 
-func (ai *AIQueryGenerator) buildAnalysisPrompt(userQuery, kqlQuery, tempDir string) string {
+// analysisFiles lists the result files analysis reads: summary.json and each
+// table, replaced by its sample_<i>.json when the table was sampled.
+func analysisFiles(tempDir string) (files []string, sampled bool) {
+	dir := filepath.Join(tempDir, "ai-query-results")
+	if _, err := os.Stat(filepath.Join(dir, "summary.json")); err == nil {
+		files = append(files, filepath.Join(dir, "summary.json"))
+	}
+	tables, _ := filepath.Glob(filepath.Join(dir, "table_*.json"))
+	for _, t := range tables {
+		sample := filepath.Join(dir, "sample_"+strings.TrimPrefix(filepath.Base(t), "table_"))
+		if _, err := os.Stat(sample); err == nil {
+			files = append(files, sample)
+			sampled = true
+			continue
+		}
+		files = append(files, t)
+	}
+	return files, sampled
+}
+
+func (ai *AIQueryGenerator) buildAnalysisPrompt(userQuery, kqlQuery, tempDir string, sampled bool) string {
+	var note string
+	if sampled {
+		note = "\n\nNOTE: some result tables were too large to analyze in full. For those, ai-query-results/sample_<i>.json holds an evenly spaced sample of the rows of table_<i>.json; read the samples instead of the full tables. Counts taken from a sample are partial: summary.json has each table's full row count."
+	}
 	return fmt.Sprintf(`You are a Kubernetes troubleshooting expert. Analyze the query results in directory %s to answer this question: "%s"
 
 The KQL query that was executed:
@@ -141,7 +302,7 @@ Please:
 5. Include relevant timestamps, pod names, error messages, and restart counts
 6. Suggest next steps or solutions if applicable
 
-Structure your response with clear headings and bullet points for easy reading.`, tempDir, userQuery, kqlQuery)
+Structure your response with clear headings and bullet points for easy reading.%s`, tempDir, userQuery, kqlQuery, note)
 }
 
 func (ai *AIQueryGenerator) buildFixPrompt(userQuery, brokenQuery, errorMessage string, availableTables []string) string {
@@ -1,19 +1,23 @@
 package mustgather
 
 import (
+	"bufio"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"strings"
 	"time"
 
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
 	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
-	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
 	azquery "github.com/Azure/azure-sdk-for-go/sdk/monitor/azquery"
 	armoperationalinsights "github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/operationalinsights/armoperationalinsights"
 
+	"kubectl-must-gather/pkg/bundle"
 	"kubectl-must-gather/pkg/utils"
 )
 
@@ -30,141 +34,568 @@ type AIQueryGeneratorInterface interface {
 type AIGatherer struct {
 	config *Config
 	ctx    context.Context
-	cred   *azidentity.DefaultAzureCredential
+	cred   azcore.TokenCredential
+	// in supplies follow-up questions with --ai-interactive; os.Stdin when nil.
+	in io.Reader
+	// out receives the questions, answers and progress, and logTo the
+	// warnings; os.Stdout and os.Stderr when nil.
+	out   io.Writer
+	logTo io.Writer
 }
 
-func (ag *AIGatherer) Run() error {
-	fmt.Printf("Running in AI mode with query: %s\n", ag.config.AIQuery)
+// stdout is where the session's questions, answers and progress go.
+func (ag *AIGatherer) stdout() io.Writer {
+	if ag.out != nil {
+		return ag.out
+	}
+	return os.Stdout
+}
 
-	iso, err := utils.ISO8601Duration(ag.config.Timespan)
+// log is where the session's warnings and failures go.
+func (ag *AIGatherer) log() io.Writer {
+	if ag.logTo != nil {
+		return ag.logTo
+	}
+	return os.Stderr
+}
+
+// aiSession is the state shared by the questions of one AI mode run.
+type aiSession struct {
+	aiGen           *AIQueryGenerator
+	lcli            LogsClientInterface
+	workspaceGUID   string
+	subID           string
+	rg              string
+	wsName          string
+	iso             string
+	availableTables []string
+	started         time.Time
+	rounds          []aiRound
+	// stageDir collects the rounds' results for the --ai-archive tar.gz,
+	// written to archive.
+	stageDir string
+	archive  string
+	history  *AIHistory
+	input    *bufio.Scanner
+}
+
+// aiRound is one answered question of a session.
+type aiRound struct {
+	Question   string
+	KQL        string
+	Rows       int
+	ResultsDir string
+	Analysis   string
+}
+
+// Conversation context sent with follow-up questions is limited to the most
+// recent rounds, with each analysis cut to a summary-sized prefix.
+const (
+	maxContextRounds   = 5
+	maxContextAnalysis = 2000
+)
+
+// Results estimated above these sizes need confirmation (or --yes) before
+// AI mode pulls them.
+const (
+	aiConfirmRows  = 100000
+	aiConfirmBytes = 100 << 20
+)
+
+// Run answers the questions of the session. Its result has the --ai-archive
+// written, if any, and no tables: AI mode exports none.
+func (ag *AIGatherer) Run() (*GatherResult, error) {
+	parent := ag.ctx
+	var cancel context.CancelFunc
+	ag.ctx, cancel = ag.config.withTimeout(parent)
+	defer func() {
+		cancel()
+		ag.ctx = parent
+	}()
+	started := time.Now()
+	archive, err := ag.run()
+	err = classify(err)
+	d := time.Since(started)
+	r := &GatherResult{
+		Archive:         archive,
+		Status:          "ok",
+		StartedAt:       started.UTC().Format(time.RFC3339),
+		Duration:        d.Round(time.Millisecond).String(),
+		DurationSeconds: d.Seconds(),
+		Tables:          []TableResult{},
+		Warnings:        []string{},
+	}
+	switch {
+	case ag.ctx.Err() != nil:
+		r.Status = "incomplete"
+	case err != nil:
+		r.Status = "failed"
+	}
 	if err != nil {
-		return fmt.Errorf("invalid timespan: %w", err)
+		r.Error = err.Error()
+	}
+	return r, err
+}
+
+// run answers the questions and returns where the --ai-archive went.
+func (ag *AIGatherer) run() (string, error) {
+	s, err := ag.newSession()
+	if err != nil {
+		return "", err
+	}
+
+	if ag.config.AIArchive {
+		if s.stageDir, err = os.MkdirTemp("", "aks-must-gather-ai-"); err != nil {
+			return "", fmt.Errorf("create staging dir: %w", err)
+		}
+		defer os.RemoveAll(s.stageDir)
+	}
+
+	err = ag.converse(s)
+	if ag.config.AIArchive && len(s.rounds) > 0 {
+		if aerr := ag.writeArchive(s); aerr != nil {
+			return s.archive, aerr
+		}
+	}
+	return s.archive, err
+}
+
+// converse answers the --ai-mode question and, with --ai-interactive, the
+// follow-ups.
+func (ag *AIGatherer) converse(s *aiSession) error {
+	if ag.config.AIQuery != "" {
+		if err := ag.ask(s, ag.config.AIQuery); err != nil {
+			if !ag.config.AIInteractive {
+				return err
+			}
+			fmt.Fprintf(ag.log(), "❌ %v\n", err)
+		}
+	}
+	if ag.config.AIInteractive {
+		return ag.repl(s)
 	}
+	return nil
+}
 
-	// Resolve workspace information
-	var (
-		subID         string
-		rg            string
-		wsName        string
-		workspaceGUID string
-	)
+// newSession resolves the workspace and sets up the AI provider and logs
+// client used by every question.
+func (ag *AIGatherer) newSession() (*aiSession, error) {
+	if ag.config.AIQuery != "" {
+		fmt.Fprintf(ag.stdout(), "Running in AI mode with query: %s\n", ag.config.AIQuery)
+	}
+
+	iso, err := utils.ISO8601Duration(ag.config.Timespan)
+	if err != nil {
+		return nil, fmt.Errorf("invalid timespan: %w", err)
+	}
+	s := &aiSession{iso: iso, started: time.Now()}
 
 	if ag.config.WorkspaceID != "" {
-		subID, rg, wsName, err = utils.ParseResourceID(ag.config.WorkspaceID)
+		s.subID, s.rg, s.wsName, err = utils.ParseResourceID(ag.config.WorkspaceID)
 		if err != nil {
-			return fmt.Errorf("parse workspace-id: %w", err)
+			return nil, fmt.Errorf("parse workspace-id: %w", err)
 		}
 
 		// Get workspace properties including customerId
-		wcli, err := armoperationalinsights.NewWorkspacesClient(subID, ag.cred, nil)
+		wcli, err := armoperationalinsights.NewWorkspacesClient(s.subID, authCredential{ag.cred}, nil)
 		if err != nil {
-			return err
+			return nil, err
 		}
-		w, err := wcli.Get(ag.ctx, rg, wsName, nil)
+		w, err := wcli.Get(ag.ctx, s.rg, s.wsName, nil)
 		if err != nil {
-			return fmt.Errorf("get workspace: %w", err)
+			return nil, fmt.Errorf("get workspace: %w", workspaceError(err))
 		}
 		if w.Properties != nil && w.Properties.CustomerID != nil {
-			workspaceGUID = *w.Properties.CustomerID
+			s.workspaceGUID = *w.Properties.CustomerID
 		}
 	}
 
-	if workspaceGUID == "" {
-		return fmt.Errorf("could not determine workspace GUID from workspace; check permissions or workspace-id")
+	if s.workspaceGUID == "" {
+		return nil, markErr(ErrWorkspaceNotFound, errors.New("could not determine workspace GUID from workspace; check permissions or workspace-id"))
 	}
 
 	// Get available tables
-	availableTables := ag.getAvailableTablesForAI()
+	s.availableTables = ag.getAvailableTablesForAI()
 
 	// Initialize AI query generator
-	aiGen, err := NewAIQueryGenerator()
+	s.aiGen, err = NewAIQueryGenerator(ag.config.AIProvider)
 	if err != nil {
-		return fmt.Errorf("failed to initialize AI query generator: %w", err)
+		return nil, fmt.Errorf("failed to initialize AI query generator: %w", err)
 	}
+	s.aiGen.SetLimits(ag.config.llmLimits())
+	s.aiGen.setLog(ag.log())
+	fmt.Fprintf(ag.stdout(), "Using AI provider: %s\n", s.aiGen.ProviderName())
 
-	// Generate KQL query
-	fmt.Printf("Generating KQL query from natural language...\n")
-	kqlQuery, err := aiGen.GenerateKQLQuery(ag.ctx, ag.config.AIQuery, availableTables)
+	// Initialize logs client for validation
+	s.lcli, err = azquery.NewLogsClient(authCredential{ag.cred}, nil)
 	if err != nil {
-		return fmt.Errorf("failed to generate KQL query: %w", err)
+		return nil, fmt.Errorf("logs client: %w", err)
 	}
 
-	fmt.Printf("Generated KQL query:\n%s\n\n", kqlQuery)
+	if s.history, err = OpenAIHistory(ag.config.AIHistoryDir); err != nil {
+		return nil, err
+	}
 
-	// Initialize logs client for validation
-	lcli, err := azquery.NewLogsClient(ag.cred, nil)
-	if err != nil {
-		return fmt.Errorf("logs client: %w", err)
+	// Live schemas keep the model to columns that exist in this workspace
+	fmt.Fprintf(ag.stdout(), "Fetching table schemas...\n")
+	if schemas, err := ag.fetchTableSchemas(s.lcli, s.workspaceGUID, s.availableTables); err != nil {
+		fmt.Fprintf(ag.log(), "  warn: could not fetch live table schemas, falling back to docs/tables: %v\n", err)
+	} else if len(schemas) > 0 {
+		s.aiGen.SetSchemas(schemas)
+		s.availableTables = s.availableTables[:0]
+		for _, t := range schemas {
+			s.availableTables = append(s.availableTables, t.Table)
+		}
 	}
+	return s, nil
+}
+
+// ask answers one question: it generates KQL, validates (and if needed
+// fixes) it, runs it, saves the results and has the AI analyze them.
+func (ag *AIGatherer) ask(s *aiSession, question string) error {
+	conversation := s.context()
+	s.aiGen.SetConversation(conversation)
+
+	usageBefore := s.aiGen.Usage()
+	now := time.Now().UTC()
+	entry := &AIHistoryEntry{
+		ID:            historyKey(s.workspaceGUID, question, conversation),
+		Question:      question,
+		FollowUp:      conversation != "",
+		WorkspaceID:   ag.config.WorkspaceID,
+		WorkspaceGUID: s.workspaceGUID,
+		Timespan:      s.iso,
+		Provider:      s.aiGen.ProviderName(),
+		CreatedAt:     now,
+	}
+	cached := s.history.Get(entry.ID)
+	if cached != nil {
+		entry.CreatedAt, entry.Uses = cached.CreatedAt, cached.Uses
+	}
+	entry.Uses++
+	entry.LastUsedAt = now
+
+	// Generate KQL query, unless this question was answered before. A
+	// replayed query (history rerun) is used as is.
+	var kqlQuery string
+	switch {
+	case ag.config.AIKQL != "" && len(s.rounds) == 0:
+		kqlQuery = ag.config.AIKQL
+		fmt.Fprintf(ag.stdout(), "Replaying KQL query:\n%s\n\n", kqlQuery)
+	case cached != nil && cached.Validation != validationFailed && cached.KQL != "":
+		kqlQuery = cached.KQL
+		fmt.Fprintf(ag.stdout(), "Using KQL from history (%s, first asked %s):\n%s\n\n", entry.ID[:12], cached.CreatedAt.Local().Format(time.RFC822), kqlQuery)
+	default:
+		fmt.Fprintf(ag.stdout(), "Generating KQL query from natural language...\n")
+		var err error
+		kqlQuery, err = s.aiGen.GenerateKQLQuery(ag.ctx, question, s.availableTables)
+		if err != nil {
+			return fmt.Errorf("failed to generate KQL query: %w", err)
+		}
+		fmt.Fprintf(ag.stdout(), "Generated KQL query:\n%s\n\n", kqlQuery)
+	}
+	entry.KQL = kqlQuery
 
 	// Basic client-side validation first
-	fmt.Printf("Validating KQL syntax...\n")
+	fmt.Fprintf(ag.stdout(), "Validating KQL syntax...\n")
 	if err := ag.basicKQLValidation(kqlQuery); err != nil {
-		fmt.Printf("❌ Basic validation failed: %v\n", err)
+		fmt.Fprintf(ag.stdout(), "❌ Basic validation failed: %v\n", err)
+		ag.recordFailure(s, entry, err)
 		return fmt.Errorf("KQL basic validation failed: %w", err)
 	}
 
 	// Server-side validation with retry
-	validatedQuery, err := ag.validateAndFixKQLQuery(aiGen, lcli, kqlQuery, workspaceGUID, availableTables)
+	validatedQuery, err := ag.validateAndFixKQLQuery(s.aiGen, s.lcli, question, kqlQuery, s.workspaceGUID, s.availableTables)
 	if err != nil {
+		ag.recordFailure(s, entry, err)
 		return fmt.Errorf("KQL validation failed: %w", err)
 	}
+	entry.Validation = validationValid
+	if validatedQuery != kqlQuery {
+		entry.Validation = validationFixed
+	}
 	kqlQuery = validatedQuery
-	fmt.Printf("✅ KQL syntax is valid\n\n")
+	entry.KQL = kqlQuery
+	fmt.Fprintf(ag.stdout(), "✅ KQL syntax is valid\n\n")
+
+	// Estimate the result size before pulling it
+	if err := ag.checkAIQuerySize(s, kqlQuery); err != nil {
+		return err
+	}
 
 	// Execute the AI-generated query
-	fmt.Printf("Executing query...\n")
-	result, err := ag.executeAIQuery(lcli, kqlQuery, workspaceGUID, iso)
+	fmt.Fprintf(ag.stdout(), "Executing query...\n")
+	result, err := ag.executeAIQuery(s.lcli, kqlQuery, s.workspaceGUID, s.iso)
 	if err != nil {
 		return fmt.Errorf("failed to execute AI query: %w", err)
 	}
 
-	// Create timestamped results directory in current working directory
-	cwd, err := os.Getwd()
-	if err != nil {
-		return fmt.Errorf("failed to get current directory: %w", err)
+	// Create timestamped results directory in current working directory;
+	// follow-up questions get numbered siblings. With --ai-archive they are
+	// staged for the archive instead.
+	var resultsDir string
+	if s.stageDir != "" {
+		resultsDir = filepath.Join(s.stageDir, fmt.Sprintf("round-%d", len(s.rounds)+1))
+	} else {
+		cwd, err := os.Getwd()
+		if err != nil {
+			return fmt.Errorf("failed to get current directory: %w", err)
+		}
+		name := fmt.Sprintf("ai-results-%s", s.started.Format("20060102-150405"))
+		if n := len(s.rounds) + 1; n > 1 {
+			name += fmt.Sprintf("-%d", n)
+		}
+		resultsDir = filepath.Join(cwd, name)
 	}
-	timestamp := time.Now().Format("20060102-150405")
-	resultsDir := filepath.Join(cwd, fmt.Sprintf("ai-results-%s", timestamp))
 	if err := os.MkdirAll(resultsDir, 0755); err != nil {
 		return fmt.Errorf("failed to create results directory: %w", err)
 	}
 	// Don't clean up - keep results for user inspection
 
-	fmt.Printf("Writing results to directory: %s\n", resultsDir)
+	fmt.Fprintf(ag.stdout(), "Writing results to directory: %s\n", resultsDir)
 
 	// Write query results to files (similar to tar structure but in results dir)
-	err = ag.writeResultsToFiles(resultsDir, kqlQuery, result, workspaceGUID, subID, rg, wsName, iso)
+	err = ag.writeResultsToFiles(resultsDir, question, kqlQuery, result, s.workspaceGUID, s.subID, s.rg, s.wsName, s.iso)
 	if err != nil {
 		return fmt.Errorf("failed to write results to files: %w", err)
 	}
 
-	// Stage 2: Analyze results with Claude
-	fmt.Printf("Analyzing results with AI...\n")
-	analysis, err := aiGen.AnalyzeResults(ag.ctx, ag.config.AIQuery, kqlQuery, resultsDir)
+	// Stage 2: Analyze results with the AI provider; identical results to a
+	// previous run of the same question reuse its analysis
+	entry.ResultsHash = resultsHash(result)
+	var analysis string
+	switch {
+	case ag.config.AISteps > 1:
+		fmt.Fprintf(ag.stdout(), "Investigating with up to %d queries...\n", ag.config.AISteps)
+		analysis, err = ag.investigate(s, question, kqlQuery, result, resultsDir)
+	case cached != nil && cached.Analysis != "" && cached.ResultsHash == entry.ResultsHash && cached.KQL == kqlQuery:
+		fmt.Fprintf(ag.stdout(), "Results unchanged since %s, reusing the earlier analysis\n", cached.LastUsedAt.Local().Format(time.RFC822))
+		analysis = cached.Analysis
+	default:
+		fmt.Fprintf(ag.stdout(), "Analyzing results with AI...\n")
+		analysis, err = s.aiGen.AnalyzeResults(ag.ctx, question, kqlQuery, resultsDir)
+	}
+	run := s.aiGen.Usage()
+	if uerr := recordLLMUsage(resultsDir, run.sub(usageBefore), run); uerr != nil {
+		fmt.Fprintf(ag.log(), "  warn: could not record LLM usage: %v\n", uerr)
+	}
 	if err != nil {
-		fmt.Printf("Warning: Failed to analyze results with AI: %v\n", err)
-		fmt.Printf("Falling back to raw results display...\n")
+		fmt.Fprintf(ag.stdout(), "Warning: Failed to analyze results with AI: %v\n", err)
+		fmt.Fprintf(ag.stdout(), "Falling back to raw results display...\n")
 		ag.displayAIResults(result)
 	} else if strings.TrimSpace(analysis) == "" {
-		fmt.Printf("Warning: AI analysis returned empty result\n")
-		fmt.Printf("Falling back to raw results display...\n")
+		fmt.Fprintf(ag.stdout(), "Warning: AI analysis returned empty result\n")
+		fmt.Fprintf(ag.stdout(), "Falling back to raw results display...\n")
 		ag.displayAIResults(result)
 	} else {
 		// Display the AI analysis
-		fmt.Println("\n" + strings.Repeat("=", 80))
-		fmt.Println("AI ANALYSIS")
-		fmt.Println(strings.Repeat("=", 80))
-		fmt.Println(analysis)
-		fmt.Println(strings.Repeat("=", 80))
+		fmt.Fprintln(ag.stdout(), "\n"+strings.Repeat("=", 80))
+		fmt.Fprintln(ag.stdout(), "AI ANALYSIS")
+		fmt.Fprintln(ag.stdout(), strings.Repeat("=", 80))
+		fmt.Fprintln(ag.stdout(), analysis)
+		fmt.Fprintln(ag.stdout(), strings.Repeat("=", 80))
 	}
 
-	fmt.Printf("\nQuery results saved to: %s\n", resultsDir)
-	fmt.Printf("You can inspect the raw data, KQL query, and metadata in this directory.\n")
+	if s.stageDir == "" {
+		fmt.Fprintf(ag.stdout(), "\nQuery results saved to: %s\n", resultsDir)
+		fmt.Fprintf(ag.stdout(), "You can inspect the raw data, KQL query, and metadata in this directory.\n")
+	}
 
+	rows := 0
+	for _, t := range result.Tables {
+		rows += len(t.Rows)
+	}
+	entry.Rows = rows
+	if err == nil {
+		entry.Analysis = strings.TrimSpace(analysis)
+	}
+	if herr := s.history.Put(entry); herr != nil {
+		fmt.Fprintf(ag.log(), "  warn: save AI history: %v\n", herr)
+	}
+	s.rounds = append(s.rounds, aiRound{
+		Question:   question,
+		KQL:        kqlQuery,
+		Rows:       rows,
+		ResultsDir: resultsDir,
+		Analysis:   analysis,
+	})
 	return nil
 }
 
+// recordFailure stores a question whose KQL never validated, so the history
+// shows it but later runs generate a fresh query.
+func (ag *AIGatherer) recordFailure(s *aiSession, entry *AIHistoryEntry, err error) {
+	entry.Validation = validationFailed
+	entry.ValidationErr = err.Error()
+	if herr := s.history.Put(entry); herr != nil {
+		fmt.Fprintf(ag.log(), "  warn: save AI history: %v\n", herr)
+	}
+}
+
+// repl reads follow-up questions until EOF, "exit" or "quit". Failed
+// questions are reported and the session continues.
+func (ag *AIGatherer) repl(s *aiSession) error {
+	sc := ag.input(s)
+	fmt.Fprintln(ag.stdout(), "\nInteractive AI session. Ask a follow-up question, 'history' to list earlier queries, or 'exit' to quit.")
+	for {
+		if ag.ctx.Err() != nil {
+			return context.Cause(ag.ctx)
+		}
+		fmt.Fprint(ag.stdout(), "\nai> ")
+		if !sc.Scan() {
+			fmt.Fprintln(ag.stdout())
+			return sc.Err()
+		}
+		question := strings.TrimSpace(sc.Text())
+		switch strings.ToLower(question) {
+		case "":
+			continue
+		case "exit", "quit":
+			return nil
+		case "history":
+			s.printHistory(ag.stdout())
+			continue
+		}
+		if err := ag.ask(s, question); err != nil {
+			fmt.Fprintf(ag.log(), "❌ %v\n", err)
+		}
+	}
+}
+
+// input returns the session's line reader over ag.in (os.Stdin by default),
+// shared by the REPL and confirmation prompts.
+func (ag *AIGatherer) input(s *aiSession) *bufio.Scanner {
+	if s.input == nil {
+		in := ag.in
+		if in == nil {
+			in = os.Stdin
+		}
+		s.input = bufio.NewScanner(in)
+	}
+	return s.input
+}
+
+// interactiveInput reports whether confirmation prompts can be answered:
+// input was supplied, or stdin is a terminal.
+func (ag *AIGatherer) interactiveInput() bool {
+	if ag.in != nil {
+		return true
+	}
+	fi, err := os.Stdin.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+// checkAIQuerySize counts the rows and bytes kqlQuery would return and, when
+// that exceeds aiConfirmRows or aiConfirmBytes, asks before running it. --yes
+// skips the question. A failed estimate only warns.
+func (ag *AIGatherer) checkAIQuerySize(s *aiSession, kqlQuery string) error {
+	fmt.Fprintf(ag.stdout(), "Estimating result size...\n")
+	rows, bytes, err := ag.estimateAIQuery(s.lcli, kqlQuery, s.workspaceGUID, s.iso)
+	if err != nil {
+		fmt.Fprintf(ag.log(), "  warn: could not estimate result size: %v\n", err)
+		return nil
+	}
+	fmt.Fprintf(ag.stdout(), "Estimated result: %d rows, %.1f MiB\n", rows, float64(bytes)/(1<<20))
+	if ag.config.AIYes || (rows <= aiConfirmRows && bytes <= aiConfirmBytes) {
+		return nil
+	}
+	if !ag.interactiveInput() {
+		return fmt.Errorf("estimated %d rows (%.1f MiB) exceeds %d rows or %d MiB; narrow the question or --timespan, or re-run with --yes", rows, float64(bytes)/(1<<20), aiConfirmRows, aiConfirmBytes>>20)
+	}
+	fmt.Fprint(ag.stdout(), "This is a large result. Run the query anyway? [y/N] ")
+	sc := ag.input(s)
+	if !sc.Scan() {
+		fmt.Fprintln(ag.stdout())
+		return fmt.Errorf("query not confirmed")
+	}
+	switch strings.ToLower(strings.TrimSpace(sc.Text())) {
+	case "y", "yes":
+		return nil
+	}
+	return fmt.Errorf("query cancelled; narrow the question or --timespan")
+}
+
+// estimateAIQuery runs kqlQuery wrapped in a count over the same window the
+// real query will use.
+func (ag *AIGatherer) estimateAIQuery(lcli LogsClientInterface, kqlQuery, workspaceGUID, iso string) (int64, int64, error) {
+	duration, err := utils.ParseISO8601ToDuration(iso)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to parse timespan: %w", err)
+	}
+	t1 := time.Now().UTC()
+	t0 := t1.Add(-duration)
+
+	q := estimateQuery(kqlQuery)
+	body := azquery.Body{
+		Query:    &q,
+		Timespan: to.Ptr(azquery.NewTimeInterval(t0, t1)),
+	}
+	options := &azquery.LogsClientQueryWorkspaceOptions{
+		Options: &azquery.LogsQueryOptions{Wait: to.Ptr(60)},
+	}
+	res, err := lcli.QueryWorkspace(ag.ctx, workspaceGUID, body, options)
+	if err != nil {
+		return 0, 0, err
+	}
+	if len(res.Tables) == 0 || len(res.Tables[0].Rows) == 0 || len(res.Tables[0].Rows[0]) < 2 {
+		return 0, 0, fmt.Errorf("unexpected estimate result")
+	}
+	row := res.Tables[0].Rows[0]
+	rows, _ := row[0].(float64)
+	bytes, _ := row[1].(float64)
+	return int64(rows), int64(bytes), nil
+}
+
+// estimateQuery wraps a query so it returns only the row count and
+// approximate size of its result. Comment lines are dropped so a trailing
+// semicolon, which would end the statement before the pipe, can be removed.
+func estimateQuery(kqlQuery string) string {
+	var lines []string
+	for _, line := range strings.Split(kqlQuery, "\n") {
+		if t := strings.TrimSpace(line); t != "" && !strings.HasPrefix(t, "//") {
+			lines = append(lines, line)
+		}
+	}
+	q := strings.TrimRight(strings.TrimSpace(strings.Join(lines, "\n")), ";")
+	return q + "\n| summarize Rows=count(), Bytes=sum(estimate_data_size(*))"
+}
+
+// context renders the recent rounds for the prompts of a follow-up question,
+// so "that pod" or "the same namespace" resolve against earlier answers.
+func (s *aiSession) context() string {
+	if len(s.rounds) == 0 {
+		return ""
+	}
+	rounds := s.rounds
+	if len(rounds) > maxContextRounds {
+		rounds = rounds[len(rounds)-maxContextRounds:]
+	}
+	var sb strings.Builder
+	sb.WriteString("\n\nThis question is a follow-up in an ongoing session. Earlier questions, the KQL that answered them and the conclusions reached:\n")
+	for i, r := range rounds {
+		analysis := strings.TrimSpace(r.Analysis)
+		if len(analysis) > maxContextAnalysis {
+			analysis = analysis[:maxContextAnalysis] + "..."
+		}
+		if analysis == "" {
+			analysis = "(no analysis)"
+		}
+		fmt.Fprintf(&sb, "\nQuestion %d: %s\nKQL:\n%s\nRows returned: %d\nAnswer:\n%s\n", len(s.rounds)-len(rounds)+i+1, r.Question, r.KQL, r.Rows, analysis)
+	}
+	sb.WriteString("\nInterpret the new question in the light of this conversation; references like \"that pod\" mean objects discussed above.")
+	return sb.String()
+}
+
+func (s *aiSession) printHistory(w io.Writer) {
+	if len(s.rounds) == 0 {
+		fmt.Fprintln(w, "No questions answered yet.")
+		return
+	}
+	for i, r := range s.rounds {
+		fmt.Fprintf(w, "%d. %s\n   KQL: %s\n   Rows: %d, results: %s\n", i+1, r.Question, strings.ReplaceAll(r.KQL, "\n", " "), r.Rows, r.ResultsDir)
+	}
+}
+
 func (ag *AIGatherer) getAvailableTablesForAI() []string {
 	// Return commonly available tables for AKS/Kubernetes workloads
 	return []string{
@@ -176,7 +607,7 @@ func (ag *AIGatherer) getAvailableTablesForAI() []string {
 	}
 }
 
-func (ag *AIGatherer) executeAIQuery(lcli *azquery.LogsClient, kqlQuery, workspaceGUID, iso string) (*azquery.LogsClientQueryWorkspaceResponse, error) {
+func (ag *AIGatherer) executeAIQuery(lcli LogsClientInterface, kqlQuery, workspaceGUID, iso string) (*azquery.LogsClientQueryWorkspaceResponse, error) {
 	// Parse the ISO8601 duration to get time range
 	duration, err := utils.ParseISO8601ToDuration(iso)
 	if err != nil {
@@ -203,7 +634,7 @@ func (ag *AIGatherer) executeAIQuery(lcli *azquery.LogsClient, kqlQuery, workspa
 	return &result, nil
 }
 
-func (ag *AIGatherer) writeResultsToFiles(tempDir, kqlQuery string, result *azquery.LogsClientQueryWorkspaceResponse, workspaceGUID, subID, rg, wsName, iso string) error {
+func (ag *AIGatherer) writeResultsToFiles(tempDir, userQuery, kqlQuery string, result *azquery.LogsClientQueryWorkspaceResponse, workspaceGUID, subID, rg, wsName, iso string) error {
 	// Write metadata similar to regular gatherer
 	meta := map[string]any{
 		"generatedAt":   time.Now().UTC().Format(time.RFC3339Nano),
@@ -211,7 +642,7 @@ func (ag *AIGatherer) writeResultsToFiles(tempDir, kqlQuery string, result *azqu
 		"workspaceID":   ag.config.WorkspaceID,
 		"timespan":      iso,
 		"aiMode":        true,
-		"userQuery":     ag.config.AIQuery,
+		"userQuery":     userQuery,
 		"kqlQuery":      kqlQuery,
 	}
 
@@ -247,20 +678,39 @@ func (ag *AIGatherer) writeResultsToFiles(tempDir, kqlQuery string, result *azqu
 			return err
 		}
 
-		// Write each table result
+		// Write each table result; tables over --ai-sample-rows also get a
+		// sample for the analysis prompt
+		rows := make([]int, len(result.Tables))
+		sampled := map[string]int{}
 		for i, table := range result.Tables {
+			scrubTable(table)
 			tableFile := filepath.Join(resultsDir, fmt.Sprintf("table_%d.json", i))
 			tableBytes, _ := json.MarshalIndent(table, "", "  ")
 			if err := os.WriteFile(tableFile, tableBytes, 0644); err != nil {
 				return err
 			}
+			rows[i] = len(table.Rows)
+			if n := ag.config.AISampleRows; n > 0 && len(table.Rows) > n {
+				sample := *table
+				sample.Rows = sampleRows(table.Rows, n)
+				sampleBytes, _ := json.MarshalIndent(&sample, "", "  ")
+				if err := os.WriteFile(filepath.Join(resultsDir, fmt.Sprintf("sample_%d.json", i)), sampleBytes, 0644); err != nil {
+					return err
+				}
+				sampled[fmt.Sprintf("table_%d", i)] = len(sample.Rows)
+			}
 		}
 
 		// Write summary
 		summary := map[string]any{
+			"userQuery":  userQuery,
 			"tableCount": len(result.Tables),
+			"rows":       rows,
 			"timestamp":  time.Now().UTC().Format(time.RFC3339Nano),
 		}
+		if len(sampled) > 0 {
+			summary["sampledRows"] = sampled
+		}
 		summaryBytes, _ := json.MarshalIndent(summary, "", "  ")
 		if err := os.WriteFile(filepath.Join(resultsDir, "summary.json"), summaryBytes, 0644); err != nil {
 			return err
@@ -270,22 +720,142 @@ func (ag *AIGatherer) writeResultsToFiles(tempDir, kqlQuery string, result *azqu
 	return nil
 }
 
+// sampleRows picks n rows spread evenly over rows, keeping the first and
+// last, so a sample still spans the query's whole time range.
+func sampleRows(rows []azquery.Row, n int) []azquery.Row {
+	if n >= len(rows) {
+		return rows
+	}
+	if n == 1 {
+		return rows[:1]
+	}
+	out := make([]azquery.Row, 0, n)
+	for i := 0; i < n; i++ {
+		out = append(out, rows[i*(len(rows)-1)/(n-1)])
+	}
+	return out
+}
+
+// recordLLMUsage adds the model usage of a question, and of the run so far,
+// to its ai-query-results/summary.json.
+func recordLLMUsage(resultsDir string, question, run LLMUsage) error {
+	dir := filepath.Join(resultsDir, "ai-query-results")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	path := filepath.Join(dir, "summary.json")
+	summary := map[string]any{}
+	if b, err := os.ReadFile(path); err == nil {
+		if err := json.Unmarshal(b, &summary); err != nil {
+			return fmt.Errorf("read %s: %w", path, err)
+		}
+	}
+	summary["llmUsage"] = question
+	summary["llmRunUsage"] = run
+	b, _ := json.MarshalIndent(summary, "", "  ")
+	return os.WriteFile(path, b, 0644)
+}
+
+// aiResultsPath is where round n's ai-query-results/ directory is stored in
+// an --ai-archive tar.gz.
+func aiResultsPath(n int) string {
+	if n == 1 {
+		return "ai-query-results"
+	}
+	return fmt.Sprintf("ai-query-results-%d", n)
+}
+
+// writeArchive packages the session's results into the output, a tar.gz by
+// default, with the same metadata/ and index.json as a regular gather, each
+// question's results in its own ai-query-results directory and the AI
+// analysis next to them.
+func (ag *AIGatherer) writeArchive(s *aiSession) (err error) {
+	sink, outFile, err := openOutput(ag.ctx, ag.config, ag.config.GenerateDefaultOutputName(), time.Now(), ag.cred, nil)
+	if err != nil {
+		return err
+	}
+	s.archive = outFile
+	tarw := utils.NewSinkWriter(sink)
+	defer func() {
+		if closeErr := tarw.Close(); closeErr != nil {
+			err = errors.Join(err, fmt.Errorf("close %s: %w", outFile, closeErr))
+		}
+	}()
+
+	_ = writeBundleInfo(tarw, bundle.NewInfo())
+	_ = writeToolInfo(tarw)
+	meta := map[string]any{
+		"generatedAt":   time.Now().UTC().Format(time.RFC3339Nano),
+		"workspaceGUID": s.workspaceGUID,
+		"workspaceID":   ag.config.WorkspaceID,
+		"timespan":      s.iso,
+		"aiMode":        true,
+	}
+	metaBytes, _ := json.MarshalIndent(meta, "", "  ")
+	_ = utils.WriteFileToTar(tarw, "metadata/workspace.json", metaBytes)
+	if s.subID != "" && s.rg != "" && s.wsName != "" {
+		mp := map[string]string{"subscriptionId": s.subID, "resourceGroup": s.rg, "workspaceName": s.wsName}
+		mpb, _ := json.MarshalIndent(mp, "", "  ")
+		_ = utils.WriteFileToTar(tarw, "metadata/azure.json", mpb)
+	}
+
+	var queries []map[string]any
+	for i, r := range s.rounds {
+		dir := aiResultsPath(i + 1)
+		src := filepath.Join(r.ResultsDir, "ai-query-results")
+		entries, err := os.ReadDir(src)
+		if err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("read %s: %w", src, err)
+		}
+		for _, e := range entries {
+			if e.Type().IsRegular() {
+				if err := utils.WriteLocalFileToTar(tarw, dir+"/"+e.Name(), filepath.Join(src, e.Name())); err != nil {
+					return fmt.Errorf("add %s: %w", e.Name(), err)
+				}
+			}
+		}
+		if len(entries) == 0 {
+			// No result tables; keep the query so the round is still on record
+			_ = utils.WriteFileToTar(tarw, dir+"/query.kql", []byte(r.KQL))
+		}
+		// Follow-up queries of a multi-step investigation
+		stepFiles, _ := filepath.Glob(filepath.Join(r.ResultsDir, "step-*", "ai-query-results", "*"))
+		for _, f := range stepFiles {
+			step := filepath.Base(filepath.Dir(filepath.Dir(f)))
+			if err := utils.WriteLocalFileToTar(tarw, dir+"/"+step+"/"+filepath.Base(f), f); err != nil {
+				return fmt.Errorf("add %s: %w", f, err)
+			}
+		}
+		if strings.TrimSpace(r.Analysis) != "" {
+			_ = utils.WriteFileToTar(tarw, dir+"/analysis.md", []byte(r.Analysis+"\n"))
+		}
+		queries = append(queries, map[string]any{"userQuery": r.Question, "kqlQuery": r.KQL, "rows": r.Rows, "dir": dir})
+	}
+
+	index := map[string]any{"tables": []string{}, "aiQueries": queries}
+	idxb, _ := json.MarshalIndent(index, "", "  ")
+	_ = utils.WriteFileToTar(tarw, "index.json", idxb)
+
+	fmt.Fprintf(ag.log(), "Wrote %s\n", outFile)
+	return nil
+}
+
 func (ag *AIGatherer) displayAIResults(result *azquery.LogsClientQueryWorkspaceResponse) {
 	if result.Tables == nil || len(result.Tables) == 0 {
-		fmt.Println("No results found.")
+		fmt.Fprintln(ag.stdout(), "No results found.")
 		return
 	}
 
 	for i, table := range result.Tables {
 		if i > 0 {
-			fmt.Println("\n" + strings.Repeat("=", 80))
+			fmt.Fprintln(ag.stdout(), "\n"+strings.Repeat("=", 80))
 		}
 
-		fmt.Printf("Results (Table %d):\n", i+1)
-		fmt.Println(strings.Repeat("-", 40))
+		fmt.Fprintf(ag.stdout(), "Results (Table %d):\n", i+1)
+		fmt.Fprintln(ag.stdout(), strings.Repeat("-", 40))
 
 		if table.Columns == nil || table.Rows == nil {
-			fmt.Println("No data in this table.")
+			fmt.Fprintln(ag.stdout(), "No data in this table.")
 			continue
 		}
 
@@ -296,14 +866,14 @@ func (ag *AIGatherer) displayAIResults(result *azquery.LogsClientQueryWorkspaceR
 				headers = append(headers, *col.Name)
 			}
 		}
-		fmt.Println(strings.Join(headers, " | "))
-		fmt.Println(strings.Repeat("-", len(strings.Join(headers, " | "))))
+		fmt.Fprintln(ag.stdout(), strings.Join(headers, " | "))
+		fmt.Fprintln(ag.stdout(), strings.Repeat("-", len(strings.Join(headers, " | "))))
 
 		// Print rows (limit to first 50 rows for readability)
 		maxRows := 50
 		rowCount := len(table.Rows)
 		if rowCount > maxRows {
-			fmt.Printf("Showing first %d of %d rows:\n", maxRows, rowCount)
+			fmt.Fprintf(ag.stdout(), "Showing first %d of %d rows:\n", maxRows, rowCount)
 		}
 
 		for i, row := range table.Rows {
@@ -324,23 +894,23 @@ func (ag *AIGatherer) displayAIResults(result *azquery.LogsClientQueryWorkspaceR
 					rowData = append(rowData, cellStr)
 				}
 			}
-			fmt.Println(strings.Join(rowData, " | "))
+			fmt.Fprintln(ag.stdout(), strings.Join(rowData, " | "))
 		}
 
 		if rowCount > maxRows {
-			fmt.Printf("\n... and %d more rows\n", rowCount-maxRows)
+			fmt.Fprintf(ag.stdout(), "\n... and %d more rows\n", rowCount-maxRows)
 		}
 	}
 }
 
 // validateAndFixKQLQuery validates KQL syntax and attempts to fix errors using AI
-func (ag *AIGatherer) validateAndFixKQLQuery(aiGen *AIQueryGenerator, lcli *azquery.LogsClient, kqlQuery, workspaceGUID string, availableTables []string) (string, error) {
+func (ag *AIGatherer) validateAndFixKQLQuery(aiGen *AIQueryGenerator, lcli LogsClientInterface, userQuery, kqlQuery, workspaceGUID string, availableTables []string) (string, error) {
 	maxRetries := 2
 	currentQuery := kqlQuery
 
 	for attempt := 0; attempt <= maxRetries; attempt++ {
 		if attempt > 0 {
-			fmt.Fprintf(os.Stderr, "Retrying validation (attempt %d/%d)...\n", attempt+1, maxRetries+1)
+			fmt.Fprintf(ag.log(), "Retrying validation (attempt %d/%d)...\n", attempt+1, maxRetries+1)
 		}
 
 		err := ag.validateKQLQuery(lcli, currentQuery, workspaceGUID)
@@ -350,16 +920,16 @@ func (ag *AIGatherer) validateAndFixKQLQuery(aiGen *AIQueryGenerator, lcli *azqu
 
 		// If this is not the last attempt, try to fix the query with AI
 		if attempt < maxRetries {
-			fmt.Fprintf(os.Stderr, "❌ Validation failed: %v\n", err)
-			fmt.Fprintf(os.Stderr, "🔧 Asking Claude to fix the KQL query...\n")
+			fmt.Fprintf(ag.log(), "❌ Validation failed: %v\n", err)
+			fmt.Fprintf(ag.log(), "🔧 Asking AI to fix the KQL query...\n")
 
-			fixedQuery, fixErr := aiGen.FixKQLQuery(ag.ctx, ag.config.AIQuery, currentQuery, err.Error(), availableTables)
+			fixedQuery, fixErr := aiGen.FixKQLQuery(ag.ctx, userQuery, currentQuery, err.Error(), availableTables)
 			if fixErr != nil {
-				fmt.Fprintf(os.Stderr, "⚠️ Failed to fix query with AI: %v\n", fixErr)
+				fmt.Fprintf(ag.log(), "⚠️ Failed to fix query with AI: %v\n", fixErr)
 				continue
 			}
 
-			fmt.Fprintf(os.Stderr, "🔄 Fixed KQL query:\n%s\n\n", fixedQuery)
+			fmt.Fprintf(ag.log(), "🔄 Fixed KQL query:\n%s\n\n", fixedQuery)
 			currentQuery = fixedQuery
 		} else {
 			return "", fmt.Errorf("failed to validate KQL after %d attempts: %v", maxRetries+1, err)
@@ -444,7 +1014,7 @@ func (ag *AIGatherer) basicKQLValidation(kqlQuery string) error {
 }
 
 // validateKQLQuery validates the syntax of a KQL query by running it with limit 0
-func (ag *AIGatherer) validateKQLQuery(lcli *azquery.LogsClient, kqlQuery, workspaceGUID string) error {
+func (ag *AIGatherer) validateKQLQuery(lcli LogsClientInterface, kqlQuery, workspaceGUID string) error {
 	// Create a validation query by appending "| limit 0" to check syntax without returning data
 	validationQuery := strings.TrimSpace(kqlQuery)
 	if !strings.HasSuffix(strings.ToLower(validationQuery), "| limit 0") {
@@ -476,7 +1046,7 @@ func (ag *AIGatherer) validateKQLQuery(lcli *azquery.LogsClient, kqlQuery, works
 		}
 		if strings.Contains(errStr, "PartialError") {
 			// Partial errors might be acceptable (e.g., some tables don't exist)
-			fmt.Fprintf(os.Stderr, "⚠️ KQL validation warning (partial error): %v\n", err)
+			fmt.Fprintf(ag.log(), "⚠️ KQL validation warning (partial error): %v\n", err)
 			return nil
 		}
 		return fmt.Errorf("KQL validation error: %v", err)
@@ -518,7 +1088,7 @@ func (ag *AIGatherer) validateKQLQueryWithClient(lcli LogsClientInterface, kqlQu
 		}
 		if strings.Contains(errStr, "PartialError") {
 			// Partial errors might be acceptable (e.g., some tables don't exist)
-			fmt.Fprintf(os.Stderr, "⚠️ KQL validation warning (partial error): %v\n", err)
+			fmt.Fprintf(ag.log(), "⚠️ KQL validation warning (partial error): %v\n", err)
 			return nil
 		}
 		return fmt.Errorf("KQL validation error: %v", err)
@@ -528,13 +1098,13 @@ func (ag *AIGatherer) validateKQLQueryWithClient(lcli LogsClientInterface, kqlQu
 }
 
 // validateAndFixKQLQueryWithClient is a testable version that accepts client and AI interfaces
-func (ag *AIGatherer) validateAndFixKQLQueryWithClient(aiGen AIQueryGeneratorInterface, lcli LogsClientInterface, kqlQuery, workspaceGUID string, availableTables []string) (string, error) {
+func (ag *AIGatherer) validateAndFixKQLQueryWithClient(aiGen AIQueryGeneratorInterface, lcli LogsClientInterface, userQuery, kqlQuery, workspaceGUID string, availableTables []string) (string, error) {
 	maxRetries := 2
 	currentQuery := kqlQuery
 
 	for attempt := 0; attempt <= maxRetries; attempt++ {
 		if attempt > 0 {
-			fmt.Fprintf(os.Stderr, "Retrying validation (attempt %d/%d)...\n", attempt+1, maxRetries+1)
+			fmt.Fprintf(ag.log(), "Retrying validation (attempt %d/%d)...\n", attempt+1, maxRetries+1)
 		}
 
 		err := ag.validateKQLQueryWithClient(lcli, currentQuery, workspaceGUID)
@@ -544,16 +1114,16 @@ func (ag *AIGatherer) validateAndFixKQLQueryWithClient(aiGen AIQueryGeneratorInt
 
 		// If this is not the last attempt, try to fix the query with AI
 		if attempt < maxRetries {
-			fmt.Fprintf(os.Stderr, "❌ Validation failed: %v\n", err)
-			fmt.Fprintf(os.Stderr, "🔧 Asking Claude to fix the KQL query...\n")
+			fmt.Fprintf(ag.log(), "❌ Validation failed: %v\n", err)
+			fmt.Fprintf(ag.log(), "🔧 Asking AI to fix the KQL query...\n")
 
-			fixedQuery, fixErr := aiGen.FixKQLQuery(ag.ctx, ag.config.AIQuery, currentQuery, err.Error(), availableTables)
+			fixedQuery, fixErr := aiGen.FixKQLQuery(ag.ctx, userQuery, currentQuery, err.Error(), availableTables)
 			if fixErr != nil {
-				fmt.Fprintf(os.Stderr, "⚠️ Failed to fix query with AI: %v\n", fixErr)
+				fmt.Fprintf(ag.log(), "⚠️ Failed to fix query with AI: %v\n", fixErr)
 				continue
 			}
 
-			fmt.Fprintf(os.Stderr, "🔄 Fixed KQL query:\n%s\n\n", fixedQuery)
+			fmt.Fprintf(ag.log(), "🔄 Fixed KQL query:\n%s\n\n", fixedQuery)
 			currentQuery = fixedQuery
 		} else {
 			return "", fmt.Errorf("failed to validate KQL after %d attempts: %v", maxRetries+1, err)
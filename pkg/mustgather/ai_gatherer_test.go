@@ -1,8 +1,15 @@
 package mustgather
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+	azquery "github.com/Azure/azure-sdk-for-go/sdk/monitor/azquery"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 )
@@ -179,6 +186,181 @@ KubePodInventory | take 10
 	}
 }
 
+func TestAISessionContext(t *testing.T) {
+	s := &aiSession{}
+	if s.context() != "" {
+		t.Fatalf("empty session should add no context")
+	}
+
+	for i := 1; i <= maxContextRounds+2; i++ {
+		s.rounds = append(s.rounds, aiRound{
+			Question: fmt.Sprintf("question %d", i),
+			KQL:      fmt.Sprintf("KubeEvents | take %d", i),
+			Rows:     i,
+			Analysis: strings.Repeat("a", maxContextAnalysis+10),
+		})
+	}
+	ctx := s.context()
+	if strings.Contains(ctx, "Question 2:") {
+		t.Errorf("rounds beyond the last %d should be dropped", maxContextRounds)
+	}
+	for _, want := range []string{"Question 3: question 3", "Question 7: question 7\nKQL:\nKubeEvents | take 7\nRows returned: 7", "that pod"} {
+		if !strings.Contains(ctx, want) {
+			t.Errorf("context missing %q", want)
+		}
+	}
+	if strings.Contains(ctx, strings.Repeat("a", maxContextAnalysis+1)) {
+		t.Errorf("analysis should be cut to %d bytes", maxContextAnalysis)
+	}
+}
+
+func TestAIReplCommands(t *testing.T) {
+	ag := &AIGatherer{
+		config: &Config{AIInteractive: true},
+		ctx:    context.Background(),
+		in:     strings.NewReader("\n  \nhistory\nexit\nnever asked\n"),
+	}
+	s := &aiSession{rounds: []aiRound{{Question: "show pods", KQL: "KubePodInventory\n| take 1", Rows: 1, ResultsDir: "ai-results-x"}}}
+	if err := ag.repl(s); err != nil {
+		t.Fatalf("repl: %v", err)
+	}
+	if len(s.rounds) != 1 {
+		t.Errorf("exit should stop before further questions, got %d rounds", len(s.rounds))
+	}
+
+	var buf bytes.Buffer
+	s.printHistory(&buf)
+	if got, want := buf.String(), "1. show pods\n   KQL: KubePodInventory | take 1\n   Rows: 1, results: ai-results-x\n"; got != want {
+		t.Errorf("history = %q, want %q", got, want)
+	}
+
+	// EOF ends the session like exit
+	ag.in = strings.NewReader("")
+	if err := ag.repl(&aiSession{}); err != nil {
+		t.Fatalf("repl at EOF: %v", err)
+	}
+}
+
+func TestAIWriteArchive(t *testing.T) {
+	stage := t.TempDir()
+	round1 := filepath.Join(stage, "round-1")
+	if err := os.MkdirAll(filepath.Join(round1, "ai-query-results"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range map[string]string{"query.kql": "KubePodInventory | take 1", "table_0.json": `{"rows":[]}`} {
+		if err := os.WriteFile(filepath.Join(round1, "ai-query-results", name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	out := filepath.Join(t.TempDir(), "ai.tar.gz")
+	ag := &AIGatherer{config: &Config{OutputFile: out, WorkspaceID: "/subscriptions/s/resourceGroups/rg/providers/Microsoft.OperationalInsights/workspaces/ws"}, ctx: context.Background()}
+	s := &aiSession{
+		workspaceGUID: "guid", subID: "s", rg: "rg", wsName: "ws", iso: "PT2H", stageDir: stage,
+		rounds: []aiRound{
+			{Question: "show pods", KQL: "KubePodInventory | take 1", ResultsDir: round1, Analysis: "all good"},
+			{Question: "and events?", KQL: "KubeEvents | take 1", ResultsDir: filepath.Join(stage, "round-2")},
+		},
+	}
+	if err := ag.writeArchive(s); err != nil {
+		t.Fatal(err)
+	}
+
+	files := readArchive(t, out)
+	for _, want := range []string{
+		"metadata/bundle.json", "metadata/workspace.json", "metadata/azure.json", "index.json",
+		"ai-query-results/query.kql", "ai-query-results/table_0.json", "ai-query-results/analysis.md",
+		"ai-query-results-2/query.kql",
+	} {
+		if _, ok := files[want]; !ok {
+			t.Errorf("archive missing %s", want)
+		}
+	}
+	if _, ok := files["ai-query-results-2/analysis.md"]; ok {
+		t.Errorf("round without analysis should have no analysis.md")
+	}
+	if files["ai-query-results-2/query.kql"] != "KubeEvents | take 1" {
+		t.Errorf("query.kql = %q", files["ai-query-results-2/query.kql"])
+	}
+
+	var idx struct {
+		AIQueries []struct {
+			UserQuery string `json:"userQuery"`
+			Dir       string `json:"dir"`
+		} `json:"aiQueries"`
+	}
+	if err := json.Unmarshal([]byte(files["index.json"]), &idx); err != nil {
+		t.Fatal(err)
+	}
+	if len(idx.AIQueries) != 2 || idx.AIQueries[1].UserQuery != "and events?" || idx.AIQueries[1].Dir != "ai-query-results-2" {
+		t.Errorf("index aiQueries = %+v", idx.AIQueries)
+	}
+}
+
+func TestEstimateQuery(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		want  string
+	}{
+		{
+			name:  "plain",
+			query: "Perf | where CounterName == 'cpu'",
+			want:  "Perf | where CounterName == 'cpu'\n| summarize Rows=count(), Bytes=sum(estimate_data_size(*))",
+		},
+		{
+			name:  "trailing semicolon and comment",
+			query: "let ns = 'default';\nKubePodInventory | where Namespace == ns;\n// done",
+			want:  "let ns = 'default';\nKubePodInventory | where Namespace == ns\n| summarize Rows=count(), Bytes=sum(estimate_data_size(*))",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := estimateQuery(tt.query); got != tt.want {
+				t.Errorf("estimateQuery =\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckAIQuerySize(t *testing.T) {
+	estimate := func(rows, bytes float64) azquery.Results {
+		return azquery.Results{Tables: []*azquery.Table{{Rows: []azquery.Row{{rows, bytes}}}}}
+	}
+	tests := []struct {
+		name    string
+		res     azquery.Results
+		err     error
+		yes     bool
+		input   string
+		wantErr string
+	}{
+		{name: "small result runs", res: estimate(10, 1000)},
+		{name: "large result confirmed", res: estimate(aiConfirmRows+1, 1000), input: "y\n"},
+		{name: "large result declined", res: estimate(1000, aiConfirmBytes+1), input: "n\n", wantErr: "cancelled"},
+		{name: "large result at EOF", res: estimate(aiConfirmRows+1, 1000), wantErr: "not confirmed"},
+		{name: "yes skips confirmation", res: estimate(aiConfirmRows+1, aiConfirmBytes+1), yes: true},
+		{name: "failed estimate only warns", err: errors.New("boom")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ag := &AIGatherer{config: &Config{AIYes: tt.yes}, ctx: context.Background(), in: strings.NewReader(tt.input)}
+			lcli := &fakeLogsClient{res: tt.res, err: tt.err}
+			s := &aiSession{lcli: lcli, workspaceGUID: "guid", iso: "PT1H"}
+			err := ag.checkAIQuerySize(s, "Perf")
+			if tt.wantErr == "" && err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Fatalf("err = %v, want containing %q", err, tt.wantErr)
+			}
+			if len(lcli.queries) != 1 || !strings.HasSuffix(lcli.queries[0], "summarize Rows=count(), Bytes=sum(estimate_data_size(*))") {
+				t.Errorf("queries = %v", lcli.queries)
+			}
+		})
+	}
+}
+
 // Helper function for case-insensitive string contains check
 func containsIgnoreCase(s, substr string) bool {
 	return strings.Contains(strings.ToLower(s), strings.ToLower(substr))
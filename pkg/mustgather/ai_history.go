@@ -0,0 +1,181 @@
+package mustgather
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	azquery "github.com/Azure/azure-sdk-for-go/sdk/monitor/azquery"
+)
+
+// Validation outcomes recorded in AIHistoryEntry.Validation.
+const (
+	validationValid  = "valid"
+	validationFixed  = "fixed"
+	validationFailed = "failed"
+)
+
+// AIHistoryEntry is one AI mode question as recorded in the history: the KQL
+// that answered it, how validation went and the latest analysis.
+type AIHistoryEntry struct {
+	ID            string    `json:"id"`
+	Question      string    `json:"question"`
+	FollowUp      bool      `json:"followUp,omitempty"`
+	WorkspaceID   string    `json:"workspaceId"`
+	WorkspaceGUID string    `json:"workspaceGuid"`
+	Timespan      string    `json:"timespan"`
+	Provider      string    `json:"provider,omitempty"`
+	KQL           string    `json:"kql"`
+	Validation    string    `json:"validation"`
+	ValidationErr string    `json:"validationError,omitempty"`
+	Rows          int       `json:"rows"`
+	ResultsHash   string    `json:"resultsHash,omitempty"`
+	Analysis      string    `json:"analysis,omitempty"`
+	CreatedAt     time.Time `json:"createdAt"`
+	LastUsedAt    time.Time `json:"lastUsedAt"`
+	Uses          int       `json:"uses"`
+}
+
+// AIHistory stores AIHistoryEntry records on disk, one file per question and
+// workspace (and, for follow-ups, conversation), so repeated questions reuse
+// their validated KQL and unchanged results reuse their analysis.
+type AIHistory struct {
+	dir string
+}
+
+// DefaultAIHistoryDir is the per-user history location, or "" when the user
+// cache directory is unknown.
+func DefaultAIHistoryDir() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "aks-must-gather", "ai-history")
+}
+
+// OpenAIHistory opens the history in dir, creating it if needed. An empty dir
+// disables the history and returns nil, which all methods accept.
+func OpenAIHistory(dir string) (*AIHistory, error) {
+	if dir == "" {
+		return nil, nil
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("create AI history dir: %w", err)
+	}
+	return &AIHistory{dir: dir}, nil
+}
+
+// historyKey identifies a question asked of a workspace. Questions are
+// compared case- and whitespace-insensitively; follow-ups also depend on the
+// conversation they were asked in.
+func historyKey(workspaceGUID, question, conversation string) string {
+	norm := strings.Join(strings.Fields(strings.ToLower(question)), " ")
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\n%s\n%s", workspaceGUID, norm, conversation)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// resultsHash fingerprints query results so an analysis is only reused for
+// identical data.
+func resultsHash(res *azquery.LogsClientQueryWorkspaceResponse) string {
+	b, err := json.Marshal(res.Tables)
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+func (h *AIHistory) path(id string) string {
+	return filepath.Join(h.dir, id[:2], id+".json")
+}
+
+// Get returns the entry with id, or nil on a miss.
+func (h *AIHistory) Get(id string) *AIHistoryEntry {
+	if h == nil || len(id) < 2 {
+		return nil
+	}
+	b, err := os.ReadFile(h.path(id))
+	if err != nil {
+		return nil
+	}
+	var e AIHistoryEntry
+	if err := json.Unmarshal(b, &e); err != nil {
+		return nil
+	}
+	return &e
+}
+
+// Find returns the entry whose ID starts with prefix. It fails when no entry
+// or more than one entry matches.
+func (h *AIHistory) Find(prefix string) (*AIHistoryEntry, error) {
+	entries, err := h.List()
+	if err != nil {
+		return nil, err
+	}
+	var found *AIHistoryEntry
+	for _, e := range entries {
+		if strings.HasPrefix(e.ID, prefix) {
+			if found != nil {
+				return nil, fmt.Errorf("history id %q is ambiguous", prefix)
+			}
+			found = e
+		}
+	}
+	if found == nil {
+		return nil, fmt.Errorf("no history entry %q", prefix)
+	}
+	return found, nil
+}
+
+// Put stores e, replacing an earlier entry for the same question.
+func (h *AIHistory) Put(e *AIHistoryEntry) error {
+	if h == nil {
+		return nil
+	}
+	p := h.path(e.ID)
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return err
+	}
+	b, err := json.MarshalIndent(e, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := p + ".tmp"
+	if err := os.WriteFile(tmp, b, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, p)
+}
+
+// List returns all entries, most recently used first.
+func (h *AIHistory) List() ([]*AIHistoryEntry, error) {
+	if h == nil {
+		return nil, nil
+	}
+	var entries []*AIHistoryEntry
+	err := filepath.WalkDir(h.dir, func(p string, d os.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if d.IsDir() || filepath.Ext(p) != ".json" {
+			return nil
+		}
+		if e := h.Get(strings.TrimSuffix(d.Name(), ".json")); e != nil {
+			entries = append(entries, e)
+		}
+		return nil
+	})
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].LastUsedAt.After(entries[j].LastUsedAt) })
+	return entries, err
+}
@@ -0,0 +1,109 @@
+package mustgather
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	azquery "github.com/Azure/azure-sdk-for-go/sdk/monitor/azquery"
+)
+
+func TestHistoryKey(t *testing.T) {
+	base := historyKey("guid", "Why are pods failing?", "")
+	tests := []struct {
+		name         string
+		guid         string
+		question     string
+		conversation string
+		same         bool
+	}{
+		{name: "case and spacing ignored", guid: "guid", question: "  why are   PODS failing? ", same: true},
+		{name: "other workspace", guid: "other", question: "Why are pods failing?"},
+		{name: "other question", guid: "guid", question: "Why are nodes failing?"},
+		{name: "follow-up", guid: "guid", question: "Why are pods failing?", conversation: "earlier rounds"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := historyKey(tt.guid, tt.question, tt.conversation) == base; got != tt.same {
+				t.Errorf("same key = %v, want %v", got, tt.same)
+			}
+		})
+	}
+}
+
+func TestAIHistoryStore(t *testing.T) {
+	h, err := OpenAIHistory(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now().UTC()
+	older := &AIHistoryEntry{ID: historyKey("g", "show pods", ""), Question: "show pods", KQL: "KubePodInventory", Validation: validationValid, LastUsedAt: now.Add(-time.Hour)}
+	newer := &AIHistoryEntry{ID: historyKey("g", "show events", ""), Question: "show events", KQL: "KubeEvents", Validation: validationFixed, LastUsedAt: now}
+	for _, e := range []*AIHistoryEntry{older, newer} {
+		if err := h.Put(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if got := h.Get(older.ID); got == nil || got.KQL != "KubePodInventory" {
+		t.Errorf("Get = %+v", got)
+	}
+	if h.Get(strings.Repeat("0", 64)) != nil {
+		t.Errorf("expected miss for unknown id")
+	}
+
+	entries, err := h.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || entries[0].Question != "show events" {
+		t.Errorf("List should return most recently used first, got %+v", entries)
+	}
+
+	if e, err := h.Find(newer.ID[:8]); err != nil || e.Question != "show events" {
+		t.Errorf("Find(prefix) = %+v, %v", e, err)
+	}
+	if _, err := h.Find(""); err == nil || !strings.Contains(err.Error(), "ambiguous") {
+		t.Errorf("empty prefix should be ambiguous, got %v", err)
+	}
+	if _, err := h.Find("zzz"); err == nil {
+		t.Errorf("expected error for unknown prefix")
+	}
+
+	// Replacing an entry keeps one file per question
+	older.Uses = 2
+	if err := h.Put(older); err != nil {
+		t.Fatal(err)
+	}
+	if entries, _ := h.List(); len(entries) != 2 {
+		t.Errorf("Put should replace, got %d entries", len(entries))
+	}
+}
+
+func TestAIHistoryDisabled(t *testing.T) {
+	h, err := OpenAIHistory("")
+	if err != nil || h != nil {
+		t.Fatalf("OpenAIHistory(\"\") = %v, %v; want nil, nil", h, err)
+	}
+	if h.Get("abc") != nil {
+		t.Errorf("nil history should miss")
+	}
+	if err := h.Put(&AIHistoryEntry{ID: "abc"}); err != nil {
+		t.Errorf("nil history Put = %v", err)
+	}
+	if entries, err := h.List(); err != nil || entries != nil {
+		t.Errorf("nil history List = %v, %v", entries, err)
+	}
+}
+
+func TestResultsHash(t *testing.T) {
+	res := func(v string) *azquery.LogsClientQueryWorkspaceResponse {
+		return &azquery.LogsClientQueryWorkspaceResponse{Results: azquery.Results{Tables: []*azquery.Table{{Rows: []azquery.Row{{v}}}}}}
+	}
+	if resultsHash(res("a")) != resultsHash(res("a")) {
+		t.Errorf("identical results should hash equally")
+	}
+	if resultsHash(res("a")) == resultsHash(res("b")) {
+		t.Errorf("different results should hash differently")
+	}
+}
@@ -0,0 +1,213 @@
+package mustgather
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	azquery "github.com/Azure/azure-sdk-for-go/sdk/monitor/azquery"
+)
+
+// Limits on how much of each step's results is shown to the model when it
+// decides the next step.
+const (
+	stepPreviewRows  = 20
+	stepPreviewBytes = 8 << 10
+	stepPreviewCell  = 200
+)
+
+// investigationStep is one query run during a multi-step investigation.
+type investigationStep struct {
+	Reason  string
+	KQL     string
+	Rows    int
+	Preview string
+	// Err is set when the step's query could not be validated or run.
+	Err string
+}
+
+// stepDecision is the model's choice after seeing the steps so far.
+type stepDecision struct {
+	Action string `json:"action"` // "query" or "conclude"
+	Reason string `json:"reason"`
+	KQL    string `json:"kql"`
+	Answer string `json:"answer"`
+}
+
+// investigate drives a multi-step investigation that starts from the
+// already executed first query: the model sees every step's results and
+// either asks for another query or concludes. When the --ai-steps budget is
+// spent it must conclude. Later steps' results are saved under
+// resultsDir/step-<n>/.
+func (ag *AIGatherer) investigate(s *aiSession, question, firstKQL string, first *azquery.LogsClientQueryWorkspaceResponse, resultsDir string) (string, error) {
+	steps := []investigationStep{newStep("initial query", firstKQL, first)}
+	for len(steps) < ag.config.AISteps {
+		if ag.ctx.Err() != nil {
+			return "", context.Cause(ag.ctx)
+		}
+		fmt.Fprintf(ag.stdout(), "Deciding next step (%d/%d)...\n", len(steps)+1, ag.config.AISteps)
+		d, err := s.aiGen.NextStep(ag.ctx, question, steps, false)
+		if err != nil {
+			return "", err
+		}
+		if d.Action != "query" || strings.TrimSpace(d.KQL) == "" {
+			return d.Answer, nil
+		}
+
+		n := len(steps) + 1
+		fmt.Fprintf(ag.stdout(), "Step %d: %s\n%s\n\n", n, d.Reason, d.KQL)
+		step, err := ag.runStep(s, question, d, filepath.Join(resultsDir, fmt.Sprintf("step-%d", n)))
+		if err != nil {
+			fmt.Fprintf(ag.log(), "  warn: step %d failed: %v\n", n, err)
+			step = investigationStep{Reason: d.Reason, KQL: d.KQL, Err: err.Error()}
+		}
+		steps = append(steps, step)
+	}
+
+	fmt.Fprintf(ag.stdout(), "Step budget reached, concluding...\n")
+	d, err := s.aiGen.NextStep(ag.ctx, question, steps, true)
+	if err != nil {
+		return "", err
+	}
+	return d.Answer, nil
+}
+
+// runStep validates (fixing if needed), size-checks and runs one follow-up
+// query, saving its results to dir.
+func (ag *AIGatherer) runStep(s *aiSession, question string, d *stepDecision, dir string) (investigationStep, error) {
+	if err := ag.basicKQLValidation(d.KQL); err != nil {
+		return investigationStep{}, fmt.Errorf("KQL basic validation failed: %w", err)
+	}
+	kql, err := ag.validateAndFixKQLQuery(s.aiGen, s.lcli, question, d.KQL, s.workspaceGUID, s.availableTables)
+	if err != nil {
+		return investigationStep{}, fmt.Errorf("KQL validation failed: %w", err)
+	}
+	if err := ag.checkAIQuerySize(s, kql); err != nil {
+		return investigationStep{}, err
+	}
+	res, err := ag.executeAIQuery(s.lcli, kql, s.workspaceGUID, s.iso)
+	if err != nil {
+		return investigationStep{}, fmt.Errorf("failed to execute AI query: %w", err)
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return investigationStep{}, fmt.Errorf("failed to create results directory: %w", err)
+	}
+	if err := ag.writeResultsToFiles(dir, question, kql, res, s.workspaceGUID, s.subID, s.rg, s.wsName, s.iso); err != nil {
+		return investigationStep{}, fmt.Errorf("failed to write results to files: %w", err)
+	}
+	return newStep(d.Reason, kql, res), nil
+}
+
+func newStep(reason, kql string, res *azquery.LogsClientQueryWorkspaceResponse) investigationStep {
+	step := investigationStep{Reason: reason, KQL: kql, Preview: previewResults(res)}
+	for _, t := range res.Tables {
+		step.Rows += len(t.Rows)
+	}
+	return step
+}
+
+// previewResults renders the first rows of each result table as
+// pipe-separated text, bounded by the stepPreview limits.
+func previewResults(res *azquery.LogsClientQueryWorkspaceResponse) string {
+	var sb strings.Builder
+	for i, t := range res.Tables {
+		if len(res.Tables) > 1 {
+			fmt.Fprintf(&sb, "Table %d:\n", i+1)
+		}
+		var headers []string
+		for _, c := range t.Columns {
+			if c.Name != nil {
+				headers = append(headers, *c.Name)
+			}
+		}
+		sb.WriteString(strings.Join(headers, " | ") + "\n")
+		for j, row := range t.Rows {
+			if j == stepPreviewRows {
+				fmt.Fprintf(&sb, "... %d more rows\n", len(t.Rows)-j)
+				break
+			}
+			cells := make([]string, len(row))
+			for k, v := range row {
+				cell := "<null>"
+				if v != nil {
+					cell = strings.ReplaceAll(toStr(v), "\n", " ")
+				}
+				if len(cell) > stepPreviewCell {
+					cell = cell[:stepPreviewCell] + "..."
+				}
+				cells[k] = cell
+			}
+			line := strings.Join(cells, " | ") + "\n"
+			if sb.Len()+len(line) > stepPreviewBytes {
+				sb.WriteString("... (preview truncated)\n")
+				return sb.String()
+			}
+			sb.WriteString(line)
+		}
+	}
+	return sb.String()
+}
+
+// NextStep shows the model the investigation so far and asks whether to run
+// another query or conclude. With final set, it must conclude.
+func (ai *AIQueryGenerator) NextStep(ctx context.Context, question string, steps []investigationStep, final bool) (*stepDecision, error) {
+	prompt := ai.buildStepPrompt(question, steps, final) + ai.schemas + ai.conversation
+	output, err := ai.complete(ctx, prompt)
+	if err != nil {
+		return nil, fmt.Errorf("investigation step: %w", err)
+	}
+	d := parseStepDecision(output)
+	if final {
+		d.Action = "conclude"
+	}
+	return d, nil
+}
+
+func (ai *AIQueryGenerator) buildStepPrompt(question string, steps []investigationStep, final bool) string {
+	var sb strings.Builder
+	for i, st := range steps {
+		fmt.Fprintf(&sb, "\nStep %d (%s):\nKQL:\n%s\n", i+1, st.Reason, st.KQL)
+		if st.Err != "" {
+			fmt.Fprintf(&sb, "FAILED: %s\n", st.Err)
+			continue
+		}
+		fmt.Fprintf(&sb, "Rows returned: %d\n%s", st.Rows, st.Preview)
+	}
+
+	instructions := `Decide the next step. If the results so far answer the question, conclude. Otherwise run one more KQL query that drills down (a specific pod, container, time range, or a related table such as KubeEvents or ContainerLogV2) and explain why. Do not repeat a query that already ran.`
+	if final {
+		instructions = `The query budget is spent. Conclude now with the best answer the results support, saying what remains uncertain.`
+	}
+
+	return fmt.Sprintf(`You are a Kubernetes troubleshooting expert investigating an AKS cluster through its Azure Log Analytics workspace, one KQL query at a time.
+
+Question: "%s"
+
+Queries run so far and their results (first rows only):
+%s
+%s
+
+Respond with a JSON object and nothing else, in one of these forms:
+{"action": "query", "reason": "<what this query will check>", "kql": "<executable KQL>"}
+{"action": "conclude", "answer": "<final answer: findings with timestamps, pod names, error messages and restart counts, then suggested next steps; markdown headings and bullet points>"}`, question, sb.String(), instructions)
+}
+
+// parseStepDecision reads the model's JSON decision. A reply that is not
+// JSON is taken as a conclusion in prose.
+func parseStepDecision(output string) *stepDecision {
+	text := strings.TrimSpace(output)
+	text = strings.TrimPrefix(text, "```json")
+	text = strings.TrimPrefix(text, "```")
+	text = strings.TrimSuffix(text, "```")
+	if i, j := strings.Index(text, "{"), strings.LastIndex(text, "}"); i >= 0 && j > i {
+		var d stepDecision
+		if err := json.Unmarshal([]byte(text[i:j+1]), &d); err == nil && d.Action != "" {
+			d.KQL = strings.TrimSpace(d.KQL)
+			return &d
+		}
+	}
+	return &stepDecision{Action: "conclude", Answer: strings.TrimSpace(output)}
+}
@@ -0,0 +1,132 @@
+package mustgather
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	azquery "github.com/Azure/azure-sdk-for-go/sdk/monitor/azquery"
+)
+
+// scriptedProvider replies with a fixed sequence, recording every prompt.
+type scriptedProvider struct {
+	replies []string
+	prompts []string
+}
+
+func (p *scriptedProvider) Name() string     { return "scripted" }
+func (p *scriptedProvider) ReadsFiles() bool { return false }
+func (p *scriptedProvider) Complete(ctx context.Context, prompt string) (string, error) {
+	p.prompts = append(p.prompts, prompt)
+	if len(p.prompts) > len(p.replies) {
+		return `{"action": "conclude", "answer": "out of script"}`, nil
+	}
+	return p.replies[len(p.prompts)-1], nil
+}
+
+func TestParseStepDecision(t *testing.T) {
+	tests := []struct {
+		name   string
+		output string
+		want   stepDecision
+	}{
+		{
+			name:   "query",
+			output: `{"action": "query", "reason": "check events", "kql": " KubeEvents | take 5 "}`,
+			want:   stepDecision{Action: "query", Reason: "check events", KQL: "KubeEvents | take 5"},
+		},
+		{
+			name:   "fenced conclusion",
+			output: "```json\n{\"action\": \"conclude\", \"answer\": \"OOMKilled\"}\n```",
+			want:   stepDecision{Action: "conclude", Answer: "OOMKilled"},
+		},
+		{
+			name:   "prose is a conclusion",
+			output: "The pod was OOMKilled.",
+			want:   stepDecision{Action: "conclude", Answer: "The pod was OOMKilled."},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseStepDecision(tt.output); *got != tt.want {
+				t.Errorf("parseStepDecision = %+v, want %+v", *got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPreviewResults(t *testing.T) {
+	var rows []azquery.Row
+	for i := 0; i < stepPreviewRows+5; i++ {
+		rows = append(rows, azquery.Row{"pod-a", nil, "line\nbreak"})
+	}
+	res := &azquery.LogsClientQueryWorkspaceResponse{Results: azquery.Results{Tables: []*azquery.Table{{
+		Columns: []*azquery.Column{{Name: to.Ptr("Name")}, {Name: to.Ptr("Node")}, {Name: to.Ptr("Msg")}},
+		Rows:    rows,
+	}}}}
+	got := previewResults(res)
+	if !strings.HasPrefix(got, "Name | Node | Msg\npod-a | <null> | line break\n") {
+		t.Errorf("preview = %q", got)
+	}
+	if strings.Count(got, "pod-a") != stepPreviewRows || !strings.Contains(got, "... 5 more rows") {
+		t.Errorf("preview should stop at %d rows:\n%s", stepPreviewRows, got)
+	}
+}
+
+func TestInvestigate(t *testing.T) {
+	p := &scriptedProvider{replies: []string{
+		`{"action": "query", "reason": "events of pod-a", "kql": "KubeEvents | where Name == 'pod-a'"}`,
+		`{"action": "query", "reason": "bad", "kql": "SELECT * FROM KubeEvents"}`,
+		`{"action": "conclude", "answer": "pod-a is OOMKilled"}`,
+	}}
+	res := azquery.Results{Tables: []*azquery.Table{{
+		Columns: []*azquery.Column{{Name: to.Ptr("Name")}},
+		Rows:    []azquery.Row{{"pod-a"}},
+	}}}
+	lcli := &fakeLogsClient{res: res}
+	ag := &AIGatherer{config: &Config{AISteps: 3}, ctx: context.Background()}
+	s := &aiSession{aiGen: &AIQueryGenerator{provider: p}, lcli: lcli, workspaceGUID: "guid", iso: "PT1H"}
+	dir := t.TempDir()
+
+	answer, err := ag.investigate(s, "why is pod-a crashing", "KubePodInventory | take 1", &azquery.LogsClientQueryWorkspaceResponse{Results: res}, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if answer != "pod-a is OOMKilled" {
+		t.Errorf("answer = %q", answer)
+	}
+	if len(p.prompts) != 3 {
+		t.Fatalf("expected 3 model calls, got %d", len(p.prompts))
+	}
+	final := p.prompts[2]
+	for _, want := range []string{
+		"Step 1 (initial query):\nKQL:\nKubePodInventory | take 1\nRows returned: 1\nName\npod-a\n",
+		"Step 2 (events of pod-a)",
+		"Step 3 (bad):\nKQL:\nSELECT * FROM KubeEvents\nFAILED: KQL basic validation failed",
+		"budget is spent",
+	} {
+		if !strings.Contains(final, want) {
+			t.Errorf("final prompt missing %q:\n%s", want, final)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "step-2", "ai-query-results", "query.kql")); err != nil {
+		t.Errorf("step 2 results not saved: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "step-3")); !os.IsNotExist(err) {
+		t.Errorf("failed step should not save results")
+	}
+}
+
+func TestInvestigateConcludesEarly(t *testing.T) {
+	p := &scriptedProvider{replies: []string{`{"action": "conclude", "answer": "nothing wrong"}`}}
+	ag := &AIGatherer{config: &Config{AISteps: 5}, ctx: context.Background()}
+	s := &aiSession{aiGen: &AIQueryGenerator{provider: p}, lcli: &fakeLogsClient{}, iso: "PT1H"}
+
+	answer, err := ag.investigate(s, "is anything wrong", "KubeEvents", &azquery.LogsClientQueryWorkspaceResponse{}, t.TempDir())
+	if err != nil || answer != "nothing wrong" || len(p.prompts) != 1 {
+		t.Errorf("answer = %q, err = %v, calls = %d", answer, err, len(p.prompts))
+	}
+}
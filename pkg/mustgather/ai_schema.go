@@ -0,0 +1,116 @@
+package mustgather
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	azquery "github.com/Azure/azure-sdk-for-go/sdk/monitor/azquery"
+)
+
+// tableSchema is the live column list of a workspace table.
+type tableSchema struct {
+	Table   string
+	Columns []schemaColumn
+}
+
+type schemaColumn struct {
+	Name string
+	Type string
+}
+
+// schemaQuery returns a single query listing the columns of every table via
+// getschema. isfuzzy skips tables the workspace does not have, so they are
+// simply absent from the result.
+func schemaQuery(tables []string) string {
+	parts := make([]string, 0, len(tables))
+	for _, t := range tables {
+		parts = append(parts, fmt.Sprintf("(['%s'] | getschema | extend TableName='%s')", t, t))
+	}
+	return fmt.Sprintf("union isfuzzy=true %s | project TableName, ColumnName, ColumnType, ColumnOrdinal", strings.Join(parts, ", "))
+}
+
+// fetchTableSchemas reads the live schemas of tables, in the order given.
+// Tables missing from the workspace are left out.
+func (ag *AIGatherer) fetchTableSchemas(lcli LogsClientInterface, workspaceGUID string, tables []string) ([]tableSchema, error) {
+	q := schemaQuery(tables)
+	t1 := time.Now().UTC()
+	body := azquery.Body{
+		Query:    &q,
+		Timespan: to.Ptr(azquery.NewTimeInterval(t1.Add(-time.Hour), t1)),
+	}
+	options := &azquery.LogsClientQueryWorkspaceOptions{
+		Options: &azquery.LogsQueryOptions{Wait: to.Ptr(30)},
+	}
+	res, err := lcli.QueryWorkspace(ag.ctx, workspaceGUID, body, options)
+	if err != nil {
+		return nil, err
+	}
+	if len(res.Tables) == 0 {
+		return nil, nil
+	}
+	return parseTableSchemas(res.Tables[0], tables)
+}
+
+func parseTableSchemas(tab *azquery.Table, order []string) ([]tableSchema, error) {
+	idx := map[string]int{"TableName": -1, "ColumnName": -1, "ColumnType": -1, "ColumnOrdinal": -1}
+	for i, c := range tab.Columns {
+		if c.Name == nil {
+			continue
+		}
+		if _, ok := idx[*c.Name]; ok {
+			idx[*c.Name] = i
+		}
+	}
+	if idx["TableName"] < 0 || idx["ColumnName"] < 0 || idx["ColumnType"] < 0 {
+		return nil, fmt.Errorf("unexpected getschema result columns")
+	}
+
+	type col struct {
+		schemaColumn
+		ordinal float64
+	}
+	byTable := map[string][]col{}
+	for _, row := range tab.Rows {
+		c := col{schemaColumn: schemaColumn{Name: toStr(row[idx["ColumnName"]]), Type: toStr(row[idx["ColumnType"]])}}
+		if i := idx["ColumnOrdinal"]; i >= 0 {
+			c.ordinal, _ = row[i].(float64)
+		}
+		t := toStr(row[idx["TableName"]])
+		byTable[t] = append(byTable[t], c)
+	}
+
+	var schemas []tableSchema
+	for _, t := range order {
+		cols, ok := byTable[t]
+		if !ok {
+			continue
+		}
+		sort.SliceStable(cols, func(i, j int) bool { return cols[i].ordinal < cols[j].ordinal })
+		s := tableSchema{Table: t}
+		for _, c := range cols {
+			s.Columns = append(s.Columns, c.schemaColumn)
+		}
+		schemas = append(schemas, s)
+	}
+	return schemas, nil
+}
+
+// renderSchemas formats live schemas for the KQL prompts.
+func renderSchemas(schemas []tableSchema) string {
+	if len(schemas) == 0 {
+		return ""
+	}
+	var sb strings.Builder
+	sb.WriteString("\n\nLIVE TABLE SCHEMAS from this workspace. These are authoritative: only these tables exist, and only these columns may be used (they override docs/tables/):\n")
+	for _, s := range schemas {
+		cols := make([]string, 0, len(s.Columns))
+		for _, c := range s.Columns {
+			cols = append(cols, c.Name+":"+c.Type)
+		}
+		fmt.Fprintf(&sb, "%s(%s)\n", s.Table, strings.Join(cols, ", "))
+	}
+	return sb.String()
+}
@@ -0,0 +1,95 @@
+package mustgather
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	azquery "github.com/Azure/azure-sdk-for-go/sdk/monitor/azquery"
+)
+
+// fakeLogsClient answers every query with the same result or error.
+type fakeLogsClient struct {
+	queries []string
+	res     azquery.Results
+	err     error
+}
+
+func (f *fakeLogsClient) QueryWorkspace(ctx context.Context, workspaceID string, body azquery.Body, options *azquery.LogsClientQueryWorkspaceOptions) (azquery.LogsClientQueryWorkspaceResponse, error) {
+	f.queries = append(f.queries, *body.Query)
+	return azquery.LogsClientQueryWorkspaceResponse{Results: f.res}, f.err
+}
+
+func schemaTable(rows ...azquery.Row) *azquery.Table {
+	cols := []*azquery.Column{}
+	for _, n := range []string{"TableName", "ColumnName", "ColumnType", "ColumnOrdinal"} {
+		cols = append(cols, &azquery.Column{Name: to.Ptr(n)})
+	}
+	return &azquery.Table{Columns: cols, Rows: rows}
+}
+
+func TestSchemaQuery(t *testing.T) {
+	got := schemaQuery([]string{"KubeEvents", "Perf"})
+	want := "union isfuzzy=true (['KubeEvents'] | getschema | extend TableName='KubeEvents'), (['Perf'] | getschema | extend TableName='Perf') | project TableName, ColumnName, ColumnType, ColumnOrdinal"
+	if got != want {
+		t.Errorf("schemaQuery =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestFetchTableSchemas(t *testing.T) {
+	lcli := &fakeLogsClient{res: azquery.Results{Tables: []*azquery.Table{schemaTable(
+		azquery.Row{"Perf", "CounterValue", "real", float64(2)},
+		azquery.Row{"KubeEvents", "Reason", "string", float64(1)},
+		azquery.Row{"KubeEvents", "TimeGenerated", "datetime", float64(0)},
+		azquery.Row{"Perf", "TimeGenerated", "datetime", float64(0)},
+	)}}}
+	ag := &AIGatherer{config: &Config{}, ctx: context.Background()}
+
+	schemas, err := ag.fetchTableSchemas(lcli, "guid", []string{"KubeEvents", "Missing", "Perf"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := renderSchemas(schemas)
+	for _, want := range []string{
+		"KubeEvents(TimeGenerated:datetime, Reason:string)\nPerf(TimeGenerated:datetime, CounterValue:real)\n",
+		"authoritative",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("rendered schemas missing %q:\n%s", want, got)
+		}
+	}
+	if strings.Contains(got, "Missing") {
+		t.Errorf("tables absent from the workspace should be left out")
+	}
+	if len(lcli.queries) != 1 || !strings.Contains(lcli.queries[0], "getschema") {
+		t.Errorf("queries = %v", lcli.queries)
+	}
+
+	lcli.err = errors.New("forbidden")
+	if _, err := ag.fetchTableSchemas(lcli, "guid", []string{"KubeEvents"}); err == nil {
+		t.Errorf("expected query error to be returned")
+	}
+}
+
+func TestLiveSchemasReplaceDocs(t *testing.T) {
+	p := &recordingProvider{reply: `{"kql": "KubeEvents | take 1", "tables_used": ["KubeEvents"]}`}
+	ai := &AIQueryGenerator{provider: p}
+	ai.SetSchemas([]tableSchema{{Table: "KubeEvents", Columns: []schemaColumn{{Name: "Reason", Type: "string"}}}})
+
+	if _, err := ai.GenerateKQLQuery(context.Background(), "show events", []string{"KubeEvents"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ai.FixKQLQuery(context.Background(), "show events", "KubeEvents | where Foo == 1", "Foo not found", []string{"KubeEvents"}); err != nil {
+		t.Fatal(err)
+	}
+	for i, prompt := range p.prompts {
+		if !strings.Contains(prompt, "KubeEvents(Reason:string)") {
+			t.Errorf("prompt %d missing live schema", i)
+		}
+		if strings.Contains(prompt, "The referenced files are included below.") {
+			t.Errorf("prompt %d should not inline docs when live schemas are known", i)
+		}
+	}
+}
@@ -0,0 +1,176 @@
+package mustgather
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	"kubectl-must-gather/pkg/utils"
+)
+
+// aiSummaryPath is where --ai-summary writes the executive summary.
+const aiSummaryPath = "analysis/summary.md"
+
+// Limits on the digest of notable signals sent to the model.
+const (
+	// summaryTopN caps each section of the digest.
+	summaryTopN = 30
+	// summarySampleLen caps sample messages quoted in the digest.
+	summarySampleLen = 300
+)
+
+// aiSummary writes analysis/summary.md, an AI-written executive summary of a
+// gather. It renders a compact digest of the signals worth summarizing from
+// the gather's clusterSignals: containers that restart or crash loop, OOM
+// kills, warning events and bursts of error logs. finish hands the digest to
+// the model; when that fails the digest itself is written so the archive
+// still gets a summary.
+type aiSummary struct {
+	ctx     context.Context
+	ai      *AIQueryGenerator
+	signals *clusterSignals
+	// log receives progress and warnings: the log of the gather.
+	log io.Writer
+}
+
+// newAISummary returns the summary transform, logging to log. signals must
+// be observed by a transform that runs before it.
+func newAISummary(ctx context.Context, ai *AIQueryGenerator, signals *clusterSignals, log io.Writer) *aiSummary {
+	return &aiSummary{ctx: ctx, ai: ai, signals: signals, log: log}
+}
+
+func (a *aiSummary) observe(table string, row map[string]any) {}
+
+func (a *aiSummary) endChunk(tarw *utils.TarWriter) error {
+	return nil
+}
+
+func (a *aiSummary) warnings(table string) []string {
+	return nil
+}
+
+// finish asks the model for the summary and writes it. Failing to reach the
+// model is not fatal: the digest is written in its place.
+func (a *aiSummary) finish(tarw *utils.TarWriter) error {
+	digest := a.digest()
+	var out string
+	if a.ai == nil {
+		out = summaryFallback(digest, "no AI provider is available")
+	} else {
+		fmt.Fprintf(a.log, "Summarizing gather with AI provider %s...\n", a.ai.ProviderName())
+		summary, err := a.ai.SummarizeGather(a.ctx, digest)
+		if err != nil {
+			fmt.Fprintf(a.log, "  warn: AI summary failed: %v\n", err)
+			out = summaryFallback(digest, err.Error())
+		} else {
+			out = fmt.Sprintf("# Executive summary\n\n_Written by AI provider %s from the signals listed under \"Signals considered\"; verify against the stitched logs before acting on it._\n\n%s\n\n## Signals considered\n\n%s", a.ai.ProviderName(), summary, digest)
+		}
+	}
+	return utils.WriteFileToTar(tarw, aiSummaryPath, []byte(out))
+}
+
+func summaryFallback(digest, reason string) string {
+	return fmt.Sprintf("# Executive summary\n\n_The AI summary could not be generated (%s); the signals it would have been written from follow._\n\n%s", reason, digest)
+}
+
+// digest renders the notable signals as markdown, most severe first within
+// each section.
+func (a *aiSummary) digest() string {
+	var sb strings.Builder
+
+	loops, ooms := a.signals.restarting(), a.signals.oomKilled()
+	oomEvents, warnings := a.signals.eventGroups()
+	bursts := a.signals.errorBursts()
+
+	sb.WriteString("### Restarting and crash-looping containers\n")
+	for i, c := range loops {
+		if i == summaryTopN {
+			fmt.Fprintf(&sb, "- ... %d more\n", len(loops)-i)
+			break
+		}
+		fmt.Fprintf(&sb, "- %s: %d restarts (%d during the window), last state %s at %s\n", containerKey(c), c.maxRestarts, c.maxRestarts-c.minRestarts, orNone(c.reason), orNone(c.lastTime))
+	}
+	if len(loops) == 0 {
+		sb.WriteString("- none\n")
+	}
+
+	sb.WriteString("\n### OOM kills\n")
+	for i, c := range ooms {
+		if i == summaryTopN {
+			fmt.Fprintf(&sb, "- ... %d more\n", len(ooms)-i)
+			break
+		}
+		fmt.Fprintf(&sb, "- %s: OOMKilled, %d restarts\n", containerKey(c), c.maxRestarts)
+	}
+	writeEvents(&sb, oomEvents)
+	if len(ooms) == 0 && len(oomEvents) == 0 {
+		sb.WriteString("- none\n")
+	}
+
+	sb.WriteString("\n### Warning events\n")
+	writeEvents(&sb, warnings)
+	if len(warnings) == 0 {
+		sb.WriteString("- none\n")
+	}
+
+	fmt.Fprintf(&sb, "\n### Error bursts (%d+ error lines in a minute)\n", errorBurstLines)
+	for i, b := range bursts {
+		if i == summaryTopN {
+			fmt.Fprintf(&sb, "- ... %d more\n", len(bursts)-i)
+			break
+		}
+		fmt.Fprintf(&sb, "- %s/%s/%s at %s: %d error lines, e.g. %q\n", b.namespace, b.pod, b.container, b.minute, b.count, b.sample)
+	}
+	if len(bursts) == 0 {
+		sb.WriteString("- none\n")
+	}
+	return sb.String()
+}
+
+func writeEvents(sb *strings.Builder, es []*eventGroup) {
+	for i, e := range es {
+		if i == summaryTopN {
+			fmt.Fprintf(sb, "- ... %d more\n", len(es)-i)
+			break
+		}
+		fmt.Fprintf(sb, "- %s %s/%s %s x%d (%s to %s): %q\n", orNone(e.kind), e.namespace, e.name, e.reason, e.count, e.first, e.last, truncateSample(e.message))
+	}
+}
+
+func truncateSample(s string) string {
+	s = strings.Join(strings.Fields(s), " ")
+	if len(s) > summarySampleLen {
+		return s[:summarySampleLen] + "..."
+	}
+	return s
+}
+
+func orNone(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+// SummarizeGather asks the provider for an executive summary of a gather
+// from the digest of its notable signals.
+func (ai *AIQueryGenerator) SummarizeGather(ctx context.Context, digest string) (string, error) {
+	prompt := fmt.Sprintf(`You are a Kubernetes troubleshooting expert. A must-gather of an AKS cluster was just exported from its Azure Log Analytics workspace. Below are the notable signals found in its pod inventory, events and container logs.
+
+Write a short executive summary for an engineer opening the archive:
+1. Start with the overall health of the cluster in one or two sentences
+2. Highlight crash loops, OOM kills and error bursts, naming the namespaces, pods and containers, with timestamps and counts
+3. Point out where signals are probably related (e.g. an OOM kill followed by a crash loop, an error burst right before restarts)
+4. Finish with the files in the archive to look at next (namespaces/<ns>/pods/<pod>/<container>.log, .previous.log, events.log)
+
+Use markdown headings and bullet points. Only state what the signals support; if there is nothing notable, say so.
+
+Signals:
+%s`, digest)
+	output, err := ai.complete(ctx, prompt)
+	if err != nil {
+		return "", fmt.Errorf("gather summary: %w", err)
+	}
+	return strings.TrimSpace(output), nil
+}
@@ -0,0 +1,61 @@
+package mustgather
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestAISummaryDigest(t *testing.T) {
+	s := newClusterSignals()
+	observeSignalsFixture(s)
+	a := newAISummary(context.Background(), nil, s, io.Discard)
+
+	got := a.digest()
+	for _, want := range []string{
+		"- shop/cart-7d9f/cart: 7 restarts (4 during the window), last state CrashLoopBackOff at 2024-01-01T00:10:00Z\n",
+		"### OOM kills\n- shop/cart-7d9f/cart: OOMKilled, 7 restarts\n",
+		`- Pod shop/cart-7d9f BackOff x5 (2024-01-01T00:05:00Z to 2024-01-01T00:05:00Z): "Back-off restarting failed container"`,
+		`- shop/cart-7d9f/cart at 2024-01-01T00:07:00Z: 10 error lines, e.g. "ERROR connection refused"`,
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("digest missing %q:\n%s", want, got)
+		}
+	}
+	for _, unwanted := range []string{"web-1/web", "Scheduled", "panic"} {
+		if strings.Contains(got, unwanted) {
+			t.Errorf("digest should not mention %q:\n%s", unwanted, got)
+		}
+	}
+}
+
+func TestAISummaryFinish(t *testing.T) {
+	p := &recordingProvider{reply: "## Health\nshop/cart-7d9f is crash looping after OOM kills."}
+	s := newClusterSignals()
+	observeSignalsFixture(s)
+	a := newAISummary(context.Background(), &AIQueryGenerator{provider: p}, s, io.Discard)
+
+	files := readTransform(t, a)
+	got, ok := files[aiSummaryPath]
+	if !ok {
+		t.Fatalf("%s not written, got %v", aiSummaryPath, files)
+	}
+	if !strings.Contains(got, "crash looping after OOM kills") || !strings.Contains(got, "## Signals considered") {
+		t.Errorf("unexpected summary:\n%s", got)
+	}
+	if len(p.prompts) != 1 || !strings.Contains(p.prompts[0], "shop/cart-7d9f/cart: OOMKilled") {
+		t.Errorf("prompt should carry the digest, got %v", p.prompts)
+	}
+}
+
+func TestAISummaryFallback(t *testing.T) {
+	s := newClusterSignals()
+	observeSignalsFixture(s)
+	a := newAISummary(context.Background(), nil, s, io.Discard)
+
+	got := readTransform(t, a)[aiSummaryPath]
+	if !strings.Contains(got, "could not be generated (no AI provider is available)") || !strings.Contains(got, "### OOM kills") {
+		t.Errorf("fallback should hold the digest:\n%s", got)
+	}
+}
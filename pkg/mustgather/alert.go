@@ -0,0 +1,362 @@
+package mustgather
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"kubectl-must-gather/pkg/kubeconfig"
+)
+
+// commonAlertSchemaID identifies Azure Monitor alerts sent with the common
+// alert schema, the only one the listener reads.
+const commonAlertSchemaID = "azureMonitorCommonAlertSchema"
+
+// maxAlertBody bounds the alert payloads the listener reads.
+const maxAlertBody = 1 << 20
+
+// defaultAlertLookback is how long before an alert fired its gather starts.
+const defaultAlertLookback = time.Hour
+
+// defaultAlertMaxLookback bounds how long before an alert is received its
+// gather may start, whatever the times in the alert.
+const defaultAlertMaxLookback = 24 * time.Hour
+
+// alertSeenTTL is how long the ID of a gathered alert is remembered to
+// acknowledge repeated deliveries of it without a gather.
+const alertSeenTTL = 24 * time.Hour
+
+// alertNamespaceDimensions are the alert dimensions, compared without case,
+// that name the Kubernetes namespace of an alert.
+var alertNamespaceDimensions = []string{"namespace", "kubernetes namespace", "k8s.namespace.name", "podnamespace"}
+
+// commonAlert is the part of a common alert schema payload the listener
+// reads: what fired, when, on which resource, and the dimensions and window
+// of the condition.
+type commonAlert struct {
+	SchemaID string `json:"schemaId"`
+	Data     struct {
+		Essentials struct {
+			AlertID          string   `json:"alertId"`
+			AlertRule        string   `json:"alertRule"`
+			MonitorCondition string   `json:"monitorCondition"`
+			FiredDateTime    string   `json:"firedDateTime"`
+			AlertTargetIDs   []string `json:"alertTargetIDs"`
+		} `json:"essentials"`
+		AlertContext struct {
+			Condition struct {
+				WindowStartTime string `json:"windowStartTime"`
+				AllOf           []struct {
+					Dimensions []struct {
+						Name  string `json:"name"`
+						Value string `json:"value"`
+					} `json:"dimensions"`
+				} `json:"allOf"`
+			} `json:"condition"`
+		} `json:"alertContext"`
+	} `json:"data"`
+}
+
+// alertGather is the gather an alert requests.
+type alertGather struct {
+	alertID    string
+	rule       string
+	clusterID  string
+	workspace  string
+	namespaces []string
+	since      time.Time
+	output     string
+}
+
+// AlertOptions configures an AlertListener.
+type AlertOptions struct {
+	// Token must be given as the token query parameter of every request,
+	// e.g. in the webhook URL of the action group.
+	Token string
+	// OutputDir receives the archives, named after the alert rule, the
+	// time the alert was received and the alert.
+	OutputDir string
+	// Lookback is how long before the alert fired its gather starts, unless
+	// the window of the alert's condition starts earlier. It defaults to an
+	// hour.
+	Lookback time.Duration
+	// MaxLookback is the longest time before an alert is received its
+	// gather starts, however early the alert says it fired or its window
+	// started. It defaults to a day, or Lookback when that is longer.
+	MaxLookback time.Duration
+	// QueueSize is the number of gathers waiting to run beyond which alerts
+	// are refused. It defaults to 16.
+	QueueSize int
+	// Config holds the settings every gather shares, such as tables,
+	// redaction and upload; the workspace, namespaces and window come from
+	// each alert.
+	Config *Config
+	// Environment overrides the Azure endpoints of every gather and of the
+	// cluster lookup, as with NewGathererWithEnvironment.
+	Environment *Environment
+
+	// now stands in for time.Now in tests.
+	now func() time.Time
+}
+
+// AlertListener receives Azure Monitor alert webhooks and runs a gather for
+// each fired alert, scoped to the alerting cluster or workspace, the
+// namespace of the alert's dimensions and the time around it.
+type AlertListener struct {
+	opts  AlertOptions
+	log   io.Writer
+	queue chan alertGather
+	mu    sync.Mutex
+	// seen holds when each alert ID was queued, for alertSeenTTL.
+	seen map[string]time.Time
+}
+
+// NewAlertListener returns a listener; Run performs the gathers its Handler
+// queues.
+func NewAlertListener(opts AlertOptions) (*AlertListener, error) {
+	if opts.Token == "" {
+		return nil, errors.New("the alert listener needs a --token for its webhook URL")
+	}
+	if opts.Config == nil {
+		opts.Config = &Config{}
+	}
+	if opts.Config.AIMode {
+		return nil, errors.New("the alert listener runs regular gathers, not AI mode")
+	}
+	if opts.OutputDir == "" {
+		opts.OutputDir = "."
+	}
+	if opts.Lookback <= 0 {
+		opts.Lookback = defaultAlertLookback
+	}
+	if opts.MaxLookback <= 0 {
+		opts.MaxLookback = defaultAlertMaxLookback
+	}
+	opts.MaxLookback = max(opts.MaxLookback, opts.Lookback)
+	if opts.QueueSize <= 0 {
+		opts.QueueSize = 16
+	}
+	if opts.now == nil {
+		opts.now = time.Now
+	}
+	return &AlertListener{opts: opts, log: opts.Config.logOutput(), queue: make(chan alertGather, opts.QueueSize), seen: map[string]time.Time{}}, nil
+}
+
+// Handler accepts alert webhooks: POST requests with the listener's token
+// and a common alert schema payload. Fired alerts are queued and answered
+// with 202 Accepted and the archive they will be written to; resolved and
+// repeated alerts are acknowledged without a gather.
+func (l *AlertListener) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			alertReply(w, http.StatusMethodNotAllowed, map[string]string{"error": "alerts are POSTed"})
+			return
+		}
+		if subtle.ConstantTimeCompare([]byte(r.URL.Query().Get("token")), []byte(l.opts.Token)) != 1 {
+			alertReply(w, http.StatusUnauthorized, map[string]string{"error": "missing or wrong token"})
+			return
+		}
+		var alert commonAlert
+		if err := json.NewDecoder(io.LimitReader(r.Body, maxAlertBody)).Decode(&alert); err != nil {
+			alertReply(w, http.StatusBadRequest, map[string]string{"error": "invalid alert payload: " + err.Error()})
+			return
+		}
+		if alert.SchemaID != commonAlertSchemaID {
+			alertReply(w, http.StatusBadRequest, map[string]string{"error": "unsupported schema " + alert.SchemaID + ": enable the common alert schema on the action group"})
+			return
+		}
+		ess := alert.Data.Essentials
+		if strings.EqualFold(ess.MonitorCondition, "Resolved") {
+			alertReply(w, http.StatusOK, map[string]string{"status": "ignored", "reason": "alert resolved"})
+			return
+		}
+		req, err := l.gatherFor(&alert)
+		if err != nil {
+			alertReply(w, http.StatusUnprocessableEntity, map[string]string{"error": err.Error()})
+			return
+		}
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		now := l.opts.now()
+		for id, queued := range l.seen {
+			if now.Sub(queued) > alertSeenTTL {
+				delete(l.seen, id)
+			}
+		}
+		if _, ok := l.seen[req.alertID]; ok && req.alertID != "" {
+			alertReply(w, http.StatusOK, map[string]string{"status": "ignored", "reason": "alert already gathered"})
+			return
+		}
+		select {
+		case l.queue <- req:
+		default:
+			alertReply(w, http.StatusServiceUnavailable, map[string]string{"error": "too many gathers queued"})
+			return
+		}
+		if req.alertID != "" {
+			l.seen[req.alertID] = now
+		}
+		fmt.Fprintf(l.log, "Queued a gather for alert %q to %s\n", req.rule, req.output)
+		alertReply(w, http.StatusAccepted, map[string]string{"status": "queued", "output": req.output})
+	})
+}
+
+func alertReply(w http.ResponseWriter, status int, body map[string]string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(body)
+}
+
+// gatherFor returns the gather a fired alert requests: its target cluster
+// or workspace, the namespaces of its dimensions, and a window from Lookback
+// before it fired, or the start of its condition's window when earlier, but
+// no earlier than MaxLookback before now.
+func (l *AlertListener) gatherFor(alert *commonAlert) (alertGather, error) {
+	ess := alert.Data.Essentials
+	req := alertGather{alertID: ess.AlertID, rule: ess.AlertRule}
+	for _, id := range ess.AlertTargetIDs {
+		if req.clusterID != "" || req.workspace != "" {
+			break
+		}
+		lower := strings.ToLower(id)
+		switch {
+		case strings.Contains(lower, "/providers/microsoft.containerservice/managedclusters/"):
+			req.clusterID = id
+		case strings.Contains(lower, "/providers/microsoft.operationalinsights/workspaces/"):
+			req.workspace = id
+		}
+	}
+	if req.clusterID == "" && req.workspace == "" {
+		return req, fmt.Errorf("alert targets no AKS cluster or Log Analytics workspace: %v", ess.AlertTargetIDs)
+	}
+
+	seen := map[string]bool{}
+	for _, c := range alert.Data.AlertContext.Condition.AllOf {
+		for _, d := range c.Dimensions {
+			for _, name := range alertNamespaceDimensions {
+				if strings.EqualFold(d.Name, name) && d.Value != "" && !seen[d.Value] {
+					seen[d.Value] = true
+					req.namespaces = append(req.namespaces, d.Value)
+				}
+			}
+		}
+	}
+
+	now := l.opts.now()
+	fired, err := time.Parse(time.RFC3339Nano, ess.FiredDateTime)
+	if err != nil || fired.After(now) {
+		fired = now
+	}
+	req.since = fired.Add(-l.opts.Lookback)
+	if start, err := time.Parse(time.RFC3339Nano, alert.Data.AlertContext.Condition.WindowStartTime); err == nil && start.Before(req.since) {
+		req.since = start
+	}
+	if earliest := now.Add(-l.opts.MaxLookback); req.since.Before(earliest) {
+		req.since = earliest
+	}
+	// Alerts received in the same second get archives of their own, still
+	// named like the rule's others for --keep
+	name := rotatedName(filepath.Join(l.opts.OutputDir, alertSlug(ess.AlertRule)+".tar.gz"), now)
+	req.output = strings.TrimSuffix(name, ".tar.gz") + "." + alertSuffix(ess.AlertID) + ".tar.gz"
+	return req, nil
+}
+
+// alertSuffix tells the archives of alerts apart: the start of the GUID that
+// ends the alert ID, or a random one for alerts without an ID.
+func alertSuffix(alertID string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(path.Base(alertID)) {
+		if r >= 'a' && r <= 'z' || r >= '0' && r <= '9' {
+			b.WriteRune(r)
+		}
+		if b.Len() == 8 {
+			return b.String()
+		}
+	}
+	if b.Len() > 0 {
+		return b.String()
+	}
+	var r [4]byte
+	_, _ = rand.Read(r[:])
+	return hex.EncodeToString(r[:])
+}
+
+// alertSlug is the alert rule name made safe for a file name.
+func alertSlug(rule string) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(rule) {
+		if r >= 'a' && r <= 'z' || r >= '0' && r <= '9' {
+			b.WriteRune(r)
+			dash = false
+		} else if !dash && b.Len() > 0 {
+			b.WriteByte('-')
+			dash = true
+		}
+	}
+	if s := strings.TrimSuffix(b.String(), "-"); s != "" {
+		return s
+	}
+	return "alert"
+}
+
+// Run performs the queued gathers one at a time until ctx is cancelled. A
+// failed gather is logged and does not stop the listener.
+func (l *AlertListener) Run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case req := <-l.queue:
+			if err := l.gather(ctx, req); err != nil {
+				fmt.Fprintf(l.log, "  warn: gather for alert %q failed: %v\n", req.rule, err)
+			}
+		}
+	}
+}
+
+func (l *AlertListener) gather(ctx context.Context, req alertGather) error {
+	env := Environment{}
+	if l.opts.Environment != nil {
+		env = *l.opts.Environment
+	}
+	cfg := *l.opts.Config
+	cfg.WorkspaceID, cfg.ClusterID = req.workspace, req.clusterID
+	if cfg.WorkspaceID == "" {
+		ws, _, err := InferWorkspace(ctx, env, &kubeconfig.Context{ClusterResourceID: req.clusterID})
+		if err != nil {
+			return err
+		}
+		cfg.WorkspaceID = ws
+	}
+	cfg.Namespaces = req.namespaces
+	// The window starts at Since; the timespan only has to be valid
+	cfg.Since = req.since
+	if cfg.Timespan == "" {
+		cfg.Timespan = "PT2H"
+	}
+	cfg.OutputFile = req.output
+	if err := os.MkdirAll(filepath.Dir(req.output), 0o755); err != nil {
+		return err
+	}
+	scope := "all namespaces"
+	if len(req.namespaces) > 0 {
+		scope = "namespaces " + strings.Join(req.namespaces, ", ")
+	}
+	fmt.Fprintf(l.log, "Gathering alert %q: %s since %s\n", req.rule, scope, req.since.UTC().Format(time.RFC3339))
+	_, _, err := runGather(ctx, &cfg, l.opts.Environment)
+	return err
+}
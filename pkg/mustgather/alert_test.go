@@ -0,0 +1,162 @@
+package mustgather
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testClusterID = "/subscriptions/sub/resourceGroups/rg/providers/Microsoft.ContainerService/managedClusters/prod"
+
+// testAlert is a common alert schema payload of a fired alert on target.
+func testAlert(id, condition, target string, dims map[string]string) string {
+	var dimensions []map[string]string
+	for k, v := range dims {
+		dimensions = append(dimensions, map[string]string{"name": k, "value": v})
+	}
+	b, _ := json.Marshal(map[string]any{
+		"schemaId": commonAlertSchemaID,
+		"data": map[string]any{
+			"essentials": map[string]any{
+				"alertId":          id,
+				"alertRule":        "Pod restarts > 5 (prod)",
+				"monitorCondition": condition,
+				"firedDateTime":    "2026-01-02T03:00:00Z",
+				"alertTargetIDs":   []string{"/subscriptions/sub/resourceGroups/rg/providers/Microsoft.Insights/actionGroups/x", target},
+			},
+			"alertContext": map[string]any{
+				"condition": map[string]any{
+					"windowStartTime": "2026-01-02T02:55:00Z",
+					"allOf":           []map[string]any{{"dimensions": dimensions}},
+				},
+			},
+		},
+	})
+	return string(b)
+}
+
+func TestAlertListenerHandler(t *testing.T) {
+	now := time.Date(2026, 1, 2, 3, 1, 0, 0, time.UTC)
+	l, err := NewAlertListener(AlertOptions{Token: "s3cret", OutputDir: "out", QueueSize: 2, Config: &Config{Quiet: true}, now: func() time.Time { return now }})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fired := testAlert("a1", "Fired", testClusterID, map[string]string{"Kubernetes namespace": "shop"})
+
+	tests := []struct {
+		name, method, token, body string
+		status                    int
+		want                      string
+	}{
+		{"get", http.MethodGet, "s3cret", "", http.StatusMethodNotAllowed, "POSTed"},
+		{"no token", http.MethodPost, "", fired, http.StatusUnauthorized, "token"},
+		{"wrong token", http.MethodPost, "guess", fired, http.StatusUnauthorized, "token"},
+		{"not json", http.MethodPost, "s3cret", "{", http.StatusBadRequest, "invalid alert payload"},
+		{"other schema", http.MethodPost, "s3cret", `{"schemaId":"Microsoft.Insights/activityLogs"}`, http.StatusBadRequest, "common alert schema"},
+		{"resolved", http.MethodPost, "s3cret", testAlert("a1", "Resolved", testClusterID, nil), http.StatusOK, "resolved"},
+		{"no target", http.MethodPost, "s3cret", testAlert("a2", "Fired", "/subscriptions/sub/resourceGroups/rg/providers/Microsoft.Compute/virtualMachines/vm", nil), http.StatusUnprocessableEntity, "no AKS cluster"},
+		{"fired", http.MethodPost, "s3cret", fired, http.StatusAccepted, filepath.Join("out", "pod-restarts-5-prod-20260102-030100.a1.tar.gz")},
+		{"repeated", http.MethodPost, "s3cret", fired, http.StatusOK, "already gathered"},
+		{"second", http.MethodPost, "s3cret", testAlert("a3", "Fired", testClusterID, nil), http.StatusAccepted, "queued"},
+		{"queue full", http.MethodPost, "s3cret", testAlert("a4", "Fired", testClusterID, nil), http.StatusServiceUnavailable, "too many"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, "/?token="+tt.token, strings.NewReader(tt.body))
+			w := httptest.NewRecorder()
+			l.Handler().ServeHTTP(w, r)
+			if w.Code != tt.status || !strings.Contains(w.Body.String(), tt.want) {
+				t.Errorf("got %d %s, want %d containing %q", w.Code, w.Body, tt.status, tt.want)
+			}
+		})
+	}
+
+	req := <-l.queue
+	if req.clusterID != testClusterID || req.workspace != "" || strings.Join(req.namespaces, ",") != "shop" {
+		t.Errorf("queued gather = %+v", req)
+	}
+	// An hour before the alert fired, earlier than the condition's window
+	if want := time.Date(2026, 1, 2, 2, 0, 0, 0, time.UTC); !req.since.Equal(want) {
+		t.Errorf("since = %v, want %v", req.since, want)
+	}
+
+	// A repeated delivery is gathered again once its ID has expired
+	<-l.queue
+	now = now.Add(alertSeenTTL + time.Minute)
+	r := httptest.NewRequest(http.MethodPost, "/?token=s3cret", strings.NewReader(fired))
+	w := httptest.NewRecorder()
+	l.Handler().ServeHTTP(w, r)
+	if w.Code != http.StatusAccepted || len(l.seen) != 1 {
+		t.Errorf("got %d %s with %d seen, want %d and 1 seen", w.Code, w.Body, len(l.seen), http.StatusAccepted)
+	}
+
+	if _, err := NewAlertListener(AlertOptions{}); err == nil {
+		t.Error("NewAlertListener accepted no token")
+	}
+}
+
+func TestAlertGatherFor(t *testing.T) {
+	now := time.Date(2026, 1, 2, 3, 1, 0, 0, time.UTC)
+	l, _ := NewAlertListener(AlertOptions{Token: "t", Lookback: time.Minute, now: func() time.Time { return now }})
+	ws := "/subscriptions/sub/resourceGroups/rg/providers/Microsoft.OperationalInsights/workspaces/logs"
+	var alert commonAlert
+	if err := json.Unmarshal([]byte(testAlert("a", "Fired", ws, map[string]string{"namespace": "shop", "PodNamespace": "shop", "Pod": "cart-1"})), &alert); err != nil {
+		t.Fatal(err)
+	}
+	req, err := l.gatherFor(&alert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if req.workspace != ws || req.clusterID != "" || strings.Join(req.namespaces, ",") != "shop" {
+		t.Errorf("gather = %+v", req)
+	}
+	// The condition's window starts before the lookback
+	if want := time.Date(2026, 1, 2, 2, 55, 0, 0, time.UTC); !req.since.Equal(want) {
+		t.Errorf("since = %v, want %v", req.since, want)
+	}
+
+	// A fired time in the future or missing counts from now
+	alert.Data.Essentials.FiredDateTime = "2027-01-01T00:00:00Z"
+	alert.Data.AlertContext.Condition.WindowStartTime = ""
+	if req, _ := l.gatherFor(&alert); !req.since.Equal(now.Add(-time.Minute)) {
+		t.Errorf("since = %v", req.since)
+	}
+
+	// A window starting long ago is cut to MaxLookback before now
+	alert.Data.AlertContext.Condition.WindowStartTime = "2025-01-01T00:00:00Z"
+	if req, _ := l.gatherFor(&alert); !req.since.Equal(now.Add(-defaultAlertMaxLookback)) {
+		t.Errorf("since = %v, want %v", req.since, now.Add(-defaultAlertMaxLookback))
+	}
+
+	// Alerts received in the same second get archives of their own
+	outputs := map[string]bool{req.output: true}
+	for _, id := range []string{"/subscriptions/sub/providers/Microsoft.AlertsManagement/alerts/0F8C2D4E-1111-2222-3333-444455556666", "", ""} {
+		alert.Data.Essentials.AlertID = id
+		req, _ := l.gatherFor(&alert)
+		if outputs[req.output] {
+			t.Errorf("output %s reused", req.output)
+		}
+		outputs[req.output] = true
+	}
+	if !outputs["pod-restarts-5-prod-20260102-030100.0f8c2d4e.tar.gz"] {
+		t.Errorf("outputs = %v", outputs)
+	}
+}
+
+func TestAlertSlug(t *testing.T) {
+	tests := map[string]string{
+		"Pod restarts > 5 (prod)": "pod-restarts-5-prod",
+		"node-NotReady":           "node-notready",
+		"  ":                      "alert",
+		"":                        "alert",
+	}
+	for in, want := range tests {
+		if got := alertSlug(in); got != want {
+			t.Errorf("alertSlug(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
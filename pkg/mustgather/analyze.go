@@ -0,0 +1,270 @@
+package mustgather
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode"
+
+	"kubectl-must-gather/pkg/bundle"
+)
+
+// maxAnalyzeFileBytes caps how much of a single bundle file is inlined. Logs
+// and NDJSON parts keep their tail, where crashes and the latest state are.
+const maxAnalyzeFileBytes = 64 << 10
+
+// maxAnalyzeFiles caps how many ranked bundle files are listed in the prompt.
+const maxAnalyzeFiles = 200
+
+// analyzeStopwords are question words too common to pick out bundle files,
+// including words that appear in nearly every bundle path.
+var analyzeStopwords = map[string]bool{
+	"the": true, "and": true, "why": true, "did": true, "does": true, "what": true, "which": true,
+	"was": true, "were": true, "are": true, "is": true, "for": true, "with": true, "from": true,
+	"show": true, "how": true, "when": true, "not": true, "has": true, "have": true, "this": true,
+	"that": true, "any": true, "all": true, "keep": true, "my": true,
+	"pod": true, "pods": true, "namespace": true, "namespaces": true, "container": true,
+	"containers": true, "node": true, "nodes": true, "log": true, "logs": true, "events": true,
+	"tables": true, "parts": true, "json": true, "ndjson": true,
+}
+
+// Analyze answers question from an existing must-gather bundle (a .tar.gz
+// archive or an extracted directory) without contacting Azure. Progress and
+// warnings go to log, which may be nil.
+func Analyze(ctx context.Context, src, question, providerName string, log io.Writer) (string, error) {
+	b, err := bundle.Open(src)
+	if err != nil {
+		return "", fmt.Errorf("open bundle: %w", err)
+	}
+	defer b.Close()
+
+	ai, err := NewAIQueryGenerator(providerName)
+	if err != nil {
+		return "", fmt.Errorf("failed to initialize AI provider: %w", err)
+	}
+	ai.setLog(log)
+	if log != nil {
+		fmt.Fprintf(log, "Analyzing %s with AI provider %s...\n", src, ai.ProviderName())
+	}
+	return ai.AnalyzeBundle(ctx, question, b)
+}
+
+// AnalyzeBundle asks the provider to answer question from the files of an
+// opened bundle. Files are ranked by relevance to the question; providers
+// that cannot read files get the top of that ranking inlined.
+func (ai *AIQueryGenerator) AnalyzeBundle(ctx context.Context, question string, b *bundle.Bundle) (string, error) {
+	files, err := b.Files()
+	if err != nil {
+		return "", fmt.Errorf("list bundle files: %w", err)
+	}
+	tables, err := b.Tables()
+	if err != nil {
+		return "", fmt.Errorf("list tables: %w", err)
+	}
+	var tableNames []string
+	for _, t := range tables {
+		tableNames = append(tableNames, t.Name)
+	}
+	ranked := rankBundleFiles(question, files, ai.suggestRelevantTables(question, tableNames))
+	if len(ranked) == 0 {
+		return "", fmt.Errorf("bundle has no files to analyze")
+	}
+	if b.Incomplete() {
+		ai.warnf("bundle is from an interrupted gather; some tables are missing or partial")
+	}
+
+	prompt := ai.buildBundlePrompt(question, b.Dir, ranked, b.Incomplete())
+	if !ai.provider.ReadsFiles() {
+		prompt += inlineBundleFiles(b.Dir, ranked, ai.meter.inlineLimit(prompt))
+	}
+	output, err := ai.complete(ctx, prompt)
+	if err != nil {
+		return "", fmt.Errorf("bundle analysis: %w", err)
+	}
+	return strings.TrimSpace(output), nil
+}
+
+func (ai *AIQueryGenerator) buildBundlePrompt(question, dir string, ranked []string, incomplete bool) string {
+	var listing strings.Builder
+	for _, f := range ranked {
+		size := int64(-1)
+		if fi, err := os.Stat(filepath.Join(dir, filepath.FromSlash(f))); err == nil {
+			size = fi.Size()
+		}
+		fmt.Fprintf(&listing, "- %s (%d bytes)\n", f, size)
+	}
+	var note string
+	if incomplete {
+		note = "\nNOTE: the gather that wrote this bundle was interrupted; tables not listed in index.json completedTables are missing or partial.\n"
+	}
+
+	return fmt.Sprintf(`You are a Kubernetes troubleshooting expert. An AKS must-gather bundle exported from Azure Log Analytics is extracted at %s. Use it to answer this question: "%s"
+%s
+Bundle layout:
+- index.json and metadata/: exported tables, time window and data freshness
+- tables/<Table>/parts/*.ndjson: raw Log Analytics rows, one JSON object per line
+- namespaces/<ns>/pods/<pod>/<container>.log: time-ordered container logs (.previous.log for earlier restarts)
+- namespaces/<ns>/pods/<pod>/events.log and pod.yaml: pod events and reconstructed manifest
+- namespaces/<ns>/core/*.yaml, cluster-scoped/*.yaml: reconstructed pods, services, volumes and claims at the end of the window
+- namespaces/<ns>/events/events.log, nodes/<node>/, controlplane/, audit/: cluster events, node state, control-plane and audit logs
+- queries/snippets/*.json: restart counts, error rates and top resource consumers
+
+Files most relevant to the question, most relevant first:
+%s
+Please:
+1. Read the relevant files above
+2. Analyze the data to understand what's happening with the Kubernetes resources
+3. Provide a clear, actionable summary of your findings
+4. Focus on the specific question asked
+5. Include relevant timestamps, pod names, error messages, and restart counts
+6. Suggest next steps or solutions if applicable
+
+Structure your response with clear headings and bullet points for easy reading.`, dir, question, note, listing.String())
+}
+
+// rankBundleFiles orders bundle files by relevance to question: bundle-wide
+// metadata first, then files whose path names something in the question (a
+// pod, namespace, node...), then stitched logs and finally the NDJSON parts
+// of relevantTables. Schemas and files with no relevance are left out.
+func rankBundleFiles(question string, files, relevantTables []string) []string {
+	terms := questionTerms(question)
+	relevant := map[string]bool{}
+	for _, t := range relevantTables {
+		relevant[t] = true
+	}
+
+	type scored struct {
+		file  string
+		score int
+	}
+	var head []string
+	var rest []scored
+	for _, f := range files {
+		if f == "index.json" || strings.HasPrefix(f, "metadata/") || strings.HasPrefix(f, "queries/snippets/") {
+			head = append(head, f)
+			continue
+		}
+
+		lf := strings.ToLower(f)
+		score := 0
+		for _, t := range terms {
+			if strings.Contains(lf, t) {
+				score += 10
+			}
+		}
+		switch {
+		case strings.HasSuffix(f, "/schema.json"):
+			continue
+		case strings.HasPrefix(f, "tables/"):
+			parts := strings.SplitN(f, "/", 3)
+			if relevant[parts[1]] && strings.HasSuffix(f, ".ndjson") {
+				score += 2
+			} else if path.Base(f) == "summary.json" {
+				score++
+			}
+		case strings.HasSuffix(f, ".log"), strings.HasSuffix(f, ".yaml"), strings.HasSuffix(f, ".json"):
+			// Stitched logs and reconstructed objects read better than raw rows
+			score += 3
+		}
+		if score > 0 {
+			rest = append(rest, scored{f, score})
+		}
+	}
+	sort.SliceStable(rest, func(i, j int) bool { return rest[i].score > rest[j].score })
+
+	ranked := head
+	for _, s := range rest {
+		ranked = append(ranked, s.file)
+	}
+	if len(ranked) > maxAnalyzeFiles {
+		ranked = ranked[:maxAnalyzeFiles]
+	}
+	return ranked
+}
+
+// questionTerms splits a question into lowercase words that may name bundle
+// objects, keeping '-', '.' and '_' so names like "web-7d9f" stay whole.
+func questionTerms(question string) []string {
+	words := strings.FieldsFunc(strings.ToLower(question), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '-' && r != '.' && r != '_'
+	})
+	var terms []string
+	seen := map[string]bool{}
+	for _, w := range words {
+		w = strings.Trim(w, "-._")
+		if len(w) < 3 || analyzeStopwords[w] || seen[w] {
+			continue
+		}
+		seen[w] = true
+		terms = append(terms, w)
+	}
+	return terms
+}
+
+// inlineBundleFiles renders ranked bundle files as prompt sections until limit
+// bytes are used. Each file contributes at most maxAnalyzeFileBytes; logs and
+// NDJSON keep their tail, starting at a line boundary.
+func inlineBundleFiles(dir string, ranked []string, limit int) string {
+	var sb strings.Builder
+	for _, f := range ranked {
+		left := limit - sb.Len()
+		if left <= 0 {
+			fmt.Fprintf(&sb, "\n(%s and further files omitted: prompt size limit reached)\n", f)
+			break
+		}
+		n := min(left, maxAnalyzeFileBytes)
+		tail := strings.HasSuffix(f, ".log") || strings.HasSuffix(f, ".ndjson")
+		data, truncated, err := readLimited(filepath.Join(dir, filepath.FromSlash(f)), n, tail)
+		if err != nil {
+			continue
+		}
+		if truncated && tail {
+			fmt.Fprintf(&sb, "\n--- %s (last %d bytes) ---\n%s\n", f, len(data), data)
+		} else {
+			fmt.Fprintf(&sb, "\n--- %s ---\n%s\n", f, data)
+			if truncated {
+				sb.WriteString("(truncated)\n")
+			}
+		}
+	}
+	if sb.Len() == 0 {
+		return ""
+	}
+	return "\n\nThe referenced files are included below.\n" + sb.String()
+}
+
+// readLimited reads up to n bytes of a file, from its end when tail is set.
+func readLimited(name string, n int, tail bool) ([]byte, bool, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, false, err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, false, err
+	}
+	if fi.Size() <= int64(n) {
+		data, err := io.ReadAll(f)
+		return data, false, err
+	}
+	if !tail {
+		data := make([]byte, n)
+		_, err := io.ReadFull(f, data)
+		return data, true, err
+	}
+	data := make([]byte, n)
+	if _, err := f.ReadAt(data, fi.Size()-int64(n)); err != nil {
+		return nil, false, err
+	}
+	// Drop the partial first line
+	if i := strings.IndexByte(string(data), '\n'); i >= 0 {
+		data = data[i+1:]
+	}
+	return data, true, nil
+}
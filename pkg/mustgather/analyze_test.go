@@ -0,0 +1,128 @@
+package mustgather
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"kubectl-must-gather/pkg/bundle"
+)
+
+func TestQuestionTerms(t *testing.T) {
+	tests := []struct {
+		question string
+		want     []string
+	}{
+		{"why did pod web-7d9f crash?", []string{"web-7d9f", "crash"}},
+		{"Show me the logs of the payments namespace", []string{"payments"}},
+		{"node aks-nodepool1-123.internal NotReady", []string{"aks-nodepool1-123.internal", "notready"}},
+		{"why", nil},
+	}
+	for _, tt := range tests {
+		if got := questionTerms(tt.question); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("questionTerms(%q) = %v, want %v", tt.question, got, tt.want)
+		}
+	}
+}
+
+func TestRankBundleFiles(t *testing.T) {
+	files := []string{
+		"index.json",
+		"metadata/run.json",
+		"namespaces/default/events/events.log",
+		"namespaces/default/pods/api-1/api.log",
+		"namespaces/default/pods/web-7d9f/events.log",
+		"namespaces/default/pods/web-7d9f/web.log",
+		"queries/snippets/restart-counts.json",
+		"tables/ContainerLogV2/parts/0000.ndjson",
+		"tables/ContainerLogV2/schema.json",
+		"tables/ContainerLogV2/summary.json",
+		"tables/Perf/parts/0000.ndjson",
+	}
+	got := rankBundleFiles("why did pod web-7d9f crash", files, []string{"ContainerLogV2"})
+	want := []string{
+		"index.json",
+		"metadata/run.json",
+		"queries/snippets/restart-counts.json",
+		"namespaces/default/pods/web-7d9f/events.log",
+		"namespaces/default/pods/web-7d9f/web.log",
+		"namespaces/default/events/events.log",
+		"namespaces/default/pods/api-1/api.log",
+		"tables/ContainerLogV2/parts/0000.ndjson",
+		"tables/ContainerLogV2/summary.json",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("rankBundleFiles =\n%v\nwant\n%v", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestReadLimitedTail(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "c.log")
+	if err := os.WriteFile(name, []byte("line one\nline two\nline three\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	data, truncated, err := readLimited(name, 14, true)
+	if err != nil || !truncated || string(data) != "line three\n" {
+		t.Errorf("tail = %q, %v, %v; want last whole line", data, truncated, err)
+	}
+	data, truncated, err = readLimited(name, 8, false)
+	if err != nil || !truncated || string(data) != "line one" {
+		t.Errorf("head = %q, %v, %v", data, truncated, err)
+	}
+	data, truncated, err = readLimited(name, 100, true)
+	if err != nil || truncated || len(data) != 29 {
+		t.Errorf("whole = %q, %v, %v", data, truncated, err)
+	}
+}
+
+func TestAnalyzeBundle(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(bundle.InfoPath, `{"bundleFormatVersion": 2}`)
+	write("index.json", `{"tables": ["ContainerLogV2"], "incomplete": true, "completedTables": []}`)
+	write("namespaces/default/pods/web-7d9f/web.log", "2024-01-01T00:00:00Z panic: nil map\n")
+	write("tables/ContainerLogV2/parts/0000.ndjson", `{"LogMessage":"panic: nil map"}`+"\n")
+
+	b, err := bundle.Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &recordingProvider{reply: "  web-7d9f panicked  "}
+	ai := &AIQueryGenerator{provider: p}
+	var log strings.Builder
+	ai.setLog(&log)
+	got, err := ai.AnalyzeBundle(context.Background(), "why did web-7d9f crash", b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "web-7d9f panicked" {
+		t.Errorf("analysis = %q", got)
+	}
+	if !strings.Contains(log.String(), "warn: bundle is from an interrupted gather") {
+		t.Errorf("expected the partial bundle warning in the log, got %q", log.String())
+	}
+
+	prompt := p.prompts[0]
+	for _, want := range []string{
+		`"why did web-7d9f crash"`,
+		"interrupted",
+		"- namespaces/default/pods/web-7d9f/web.log (",
+		"--- namespaces/default/pods/web-7d9f/web.log ---\n2024-01-01T00:00:00Z panic: nil map",
+	} {
+		if !strings.Contains(prompt, want) {
+			t.Errorf("prompt missing %q:\n%s", want, prompt)
+		}
+	}
+}
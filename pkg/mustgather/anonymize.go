@@ -0,0 +1,281 @@
+package mustgather
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// Kinds of names --anonymize replaces, used as the prefix of their
+// pseudonyms.
+const (
+	anonNamespace = "ns"
+	anonPod       = "pod"
+	anonNode      = "node"
+	anonUser      = "user"
+)
+
+// anonymizedColumns are the columns holding a name, in every table.
+var anonymizedColumns = map[string]string{
+	"PodNamespace": anonNamespace,
+	"Namespace":    anonNamespace,
+	"PVCNamespace": anonNamespace,
+	"PodName":      anonPod,
+	"Computer":     anonNode,
+	"NodeName":     anonNode,
+}
+
+// anonymizedKeys are the keys of dynamic JSON values holding a name, e.g. the
+// user and object of an audit event.
+var anonymizedKeys = map[string]string{
+	"namespace":         anonNamespace,
+	"nodeName":          anonNode,
+	"username":          anonUser,
+	"userPrincipalName": anonUser,
+	"upn":               anonUser,
+}
+
+// systemNamespaces are the same in every cluster and are kept.
+var systemNamespaces = map[string]bool{
+	"default":         true,
+	"kube-system":     true,
+	"kube-public":     true,
+	"kube-node-lease": true,
+}
+
+// anonKeyRe matches a string value of an anonymizedKeys key in JSON.
+var anonKeyRe = regexp.MustCompile(`"(namespace|nodeName|username|userPrincipalName|upn)"(\s*:\s*)"((?:[^"\\]|\\.)*)"`)
+
+// anonTokenRe matches the words of free text that may be names: DNS labels
+// and subdomains, and user principal names.
+var anonTokenRe = regexp.MustCompile(`[A-Za-z0-9](?:[A-Za-z0-9._@-]*[A-Za-z0-9])?`)
+
+// anonSeedQueries list the pod and node names of the cluster before the
+// export, so their mentions in log lines and messages exported earlier than
+// the inventory are replaced too.
+var anonSeedQueries = map[string]string{
+	"KubePodInventory | distinct Namespace, Name, Computer": "KubePodInventory",
+	"KubeNodeInventory | distinct Computer":                 "KubeNodeInventory",
+}
+
+// Anonymizer replaces namespace, pod, node and user names in exported rows
+// with pseudonyms: the kind and the first 8 hex digits of an HMAC-SHA256 of
+// the name, keyed with a secret salt. The salt and the pseudonyms seen are
+// kept in a local key file, so names map the same way in every gather that
+// uses it, and pseudonyms can be traced back by whoever holds it.
+type Anonymizer struct {
+	path string
+
+	mu    sync.Mutex
+	salt  []byte
+	names map[string]string
+	// pseudonyms maps each pseudonym back to its name and kind.
+	pseudonyms map[string]anonEntry
+}
+
+type anonEntry struct {
+	Name string `json:"name"`
+	Kind string `json:"kind"`
+}
+
+// anonKeyFile is the content of the key file.
+type anonKeyFile struct {
+	Salt       string               `json:"salt"`
+	Pseudonyms map[string]anonEntry `json:"pseudonyms"`
+}
+
+// DefaultAnonymizeKeyFile is the per-user key file location, or "" when the
+// user config directory is unknown.
+func DefaultAnonymizeKeyFile() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "aks-must-gather", "anonymize-key.json")
+}
+
+// LoadAnonymizer reads the key file at path, or starts a new one with a
+// random salt when it does not exist yet. Save writes it back.
+func LoadAnonymizer(path string) (*Anonymizer, error) {
+	if path == "" {
+		return nil, errors.New("--anonymize needs a key file: set --anonymize-key")
+	}
+	a := &Anonymizer{path: path, names: map[string]string{}, pseudonyms: map[string]anonEntry{}}
+	b, err := os.ReadFile(path)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		a.salt = make([]byte, 32)
+		if _, err := rand.Read(a.salt); err != nil {
+			return nil, err
+		}
+		return a, nil
+	case err != nil:
+		return nil, fmt.Errorf("read anonymize key: %w", err)
+	}
+	var kf anonKeyFile
+	if err := json.Unmarshal(b, &kf); err != nil {
+		return nil, fmt.Errorf("parse anonymize key %s: %w", path, err)
+	}
+	if a.salt, err = hex.DecodeString(kf.Salt); err != nil || len(a.salt) == 0 {
+		return nil, fmt.Errorf("anonymize key %s has no valid salt", path)
+	}
+	for p, e := range kf.Pseudonyms {
+		a.pseudonyms[p] = e
+		a.names[e.Name] = p
+	}
+	return a, nil
+}
+
+// Save writes the key file, readable only by its owner.
+func (a *Anonymizer) Save() error {
+	if a == nil {
+		return nil
+	}
+	a.mu.Lock()
+	kf := anonKeyFile{Salt: hex.EncodeToString(a.salt), Pseudonyms: a.pseudonyms}
+	b, _ := json.MarshalIndent(kf, "", "  ")
+	a.mu.Unlock()
+	if err := os.MkdirAll(filepath.Dir(a.path), 0o700); err != nil {
+		return fmt.Errorf("write anonymize key: %w", err)
+	}
+	if err := os.WriteFile(a.path, append(b, '\n'), 0o600); err != nil {
+		return fmt.Errorf("write anonymize key: %w", err)
+	}
+	return nil
+}
+
+// pseudonym returns the pseudonym of name, registering it so its mentions in
+// free text are replaced too. A name keeps the pseudonym of the kind it was
+// first seen as. System namespaces and empty names are kept.
+func (a *Anonymizer) pseudonym(kind, name string) string {
+	if name == "" || kind == anonNamespace && systemNamespaces[name] {
+		return name
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if p, ok := a.names[name]; ok {
+		return p
+	}
+	if _, ok := a.pseudonyms[name]; ok {
+		// Already a pseudonym, e.g. a value anonymized twice
+		return name
+	}
+	mac := hmac.New(sha256.New, a.salt)
+	mac.Write([]byte(name))
+	p := kind + "-" + hex.EncodeToString(mac.Sum(nil)[:4])
+	a.names[name] = p
+	a.pseudonyms[p] = anonEntry{Name: name, Kind: kind}
+	return p
+}
+
+// text replaces the known names among the words of s.
+func (a *Anonymizer) text(s string) string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if len(a.names) == 0 {
+		return s
+	}
+	return anonTokenRe.ReplaceAllStringFunc(s, func(w string) string {
+		if p, ok := a.names[w]; ok {
+			return p
+		}
+		return w
+	})
+}
+
+// anonymizeRow replaces the names in a row of table in place: first the name
+// columns and JSON keys, then every mention of a known name in any string. A
+// nil Anonymizer leaves the row unchanged.
+func (a *Anonymizer) anonymizeRow(table string, row map[string]any) {
+	if a == nil {
+		return
+	}
+	for col, v := range row {
+		kind := anonymizedColumns[col]
+		if col == "Name" {
+			kind = rowNameKind(table, row)
+		}
+		if s, ok := v.(string); ok && kind != "" {
+			row[col] = a.pseudonym(kind, s)
+		}
+	}
+	for col, v := range row {
+		switch val := v.(type) {
+		case string:
+			row[col] = a.text(a.jsonKeys(val))
+		case json.RawMessage:
+			s := a.text(a.jsonKeys(string(val)))
+			if json.Valid([]byte(s)) {
+				row[col] = json.RawMessage(s)
+			} else {
+				row[col] = s
+			}
+		}
+	}
+}
+
+// rowNameKind is the kind of the Name column of table: the pod of pod
+// inventory, and the involved object of events when it is a pod or node.
+func rowNameKind(table string, row map[string]any) string {
+	switch strings.ToLower(table) {
+	case "kubepodinventory":
+		return anonPod
+	case "kubeevents":
+		switch toStr(row["ObjectKind"]) {
+		case "Pod":
+			return anonPod
+		case "Node":
+			return anonNode
+		}
+	}
+	return ""
+}
+
+// jsonKeys replaces the string values of anonymizedKeys in JSON text.
+func (a *Anonymizer) jsonKeys(s string) string {
+	if !strings.Contains(s, `"`) {
+		return s
+	}
+	return anonKeyRe.ReplaceAllStringFunc(s, func(m string) string {
+		parts := anonKeyRe.FindStringSubmatch(m)
+		var value string
+		if err := json.Unmarshal([]byte(`"`+parts[3]+`"`), &value); err != nil {
+			return m
+		}
+		return `"` + parts[1] + `"` + parts[2] + jsonString(a.pseudonym(anonymizedKeys[parts[1]], value))
+	})
+}
+
+// count is the number of names replaced so far, for metadata/run.json.
+func (a *Anonymizer) count() int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return len(a.pseudonyms)
+}
+
+// seedAnonymizer registers the pod and node names of the cluster before the
+// export. Tables that cannot be queried are skipped.
+func (g *Gatherer) seedAnonymizer(lcli LogsQueryClient, workspaceGUID string) {
+	for q, table := range anonSeedQueries {
+		if g.interrupted() {
+			return
+		}
+		res, err := g.query(g.ctx, lcli, workspaceGUID, q, g.start, g.end)
+		if err != nil || res.Error != nil || len(res.Tables) == 0 {
+			continue
+		}
+		for _, row := range tableRows(res.Tables[0]) {
+			g.config.Anonymizer.anonymizeRow(table, row)
+		}
+	}
+}
@@ -0,0 +1,193 @@
+package mustgather
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func newTestAnonymizer(t *testing.T) *Anonymizer {
+	t.Helper()
+	a, err := LoadAnonymizer(filepath.Join(t.TempDir(), "key.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return a
+}
+
+func TestPseudonym(t *testing.T) {
+	a := newTestAnonymizer(t)
+	p := a.pseudonym(anonNamespace, "shop")
+	if !regexp.MustCompile(`^ns-[0-9a-f]{8}$`).MatchString(p) {
+		t.Fatalf("pseudonym = %q", p)
+	}
+	if got := a.pseudonym(anonNamespace, "shop"); got != p {
+		t.Errorf("second pseudonym = %q, want %q", got, p)
+	}
+	if got := a.pseudonym(anonNamespace, p); got != p {
+		t.Errorf("pseudonym of a pseudonym = %q, want it kept", got)
+	}
+	for _, ns := range []string{"kube-system", "default", ""} {
+		if got := a.pseudonym(anonNamespace, ns); got != ns {
+			t.Errorf("pseudonym(%q) = %q, want it kept", ns, got)
+		}
+	}
+	if got := a.pseudonym(anonPod, "default"); got == "default" {
+		t.Error("a pod named default should be replaced")
+	}
+
+	// The same salt gives the same pseudonyms
+	b := &Anonymizer{salt: a.salt, names: map[string]string{}, pseudonyms: map[string]anonEntry{}}
+	if got := b.pseudonym(anonNamespace, "shop"); got != p {
+		t.Errorf("pseudonym with the same salt = %q, want %q", got, p)
+	}
+	c := newTestAnonymizer(t)
+	if got := c.pseudonym(anonNamespace, "shop"); got == p {
+		t.Error("pseudonym with another salt should differ")
+	}
+}
+
+func TestAnonymizeRow(t *testing.T) {
+	tests := []struct {
+		name   string
+		table  string
+		row    map[string]any
+		col    string
+		want   string
+		absent []string
+	}{
+		{
+			name:  "pod inventory",
+			table: "KubePodInventory",
+			row:   map[string]any{"Namespace": "shop", "Name": "cart-1", "Computer": "aks-node-1"},
+			col:   "Name",
+			want:  "pod-",
+		},
+		{
+			name:   "log line mentions",
+			table:  "ContainerLogV2",
+			row:    map[string]any{"PodNamespace": "shop", "PodName": "cart-1", "LogMessage": "cart-1 scheduled on aks-node-1 in shop"},
+			col:    "LogMessage",
+			absent: []string{"cart-1", "aks-node-1", "shop"},
+		},
+		{
+			name:  "event about a node",
+			table: "KubeEvents",
+			row:   map[string]any{"ObjectKind": "Node", "Name": "aks-node-2", "Namespace": "default"},
+			col:   "Name",
+			want:  "node-",
+		},
+		{
+			name:  "event about a deployment",
+			table: "KubeEvents",
+			row:   map[string]any{"ObjectKind": "Deployment", "Name": "cart"},
+			col:   "Name",
+			want:  "cart",
+		},
+		{
+			name:   "audit user",
+			table:  "AKSAudit",
+			row:    map[string]any{"User": json.RawMessage(`{"username":"alice@contoso.com","groups":["system:authenticated"]}`)},
+			col:    "User",
+			want:   `{"username":"user-`,
+			absent: []string{"alice"},
+		},
+		{
+			name:  "system namespace kept",
+			table: "KubePodInventory",
+			row:   map[string]any{"Namespace": "kube-system", "Name": "coredns-1"},
+			col:   "Namespace",
+			want:  "kube-system",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := newTestAnonymizer(t)
+			a.pseudonym(anonNode, "aks-node-1")
+			a.anonymizeRow(tt.table, tt.row)
+			got := toStr(tt.row[tt.col])
+			if !strings.HasPrefix(got, tt.want) {
+				t.Errorf("%s = %s, want prefix %s", tt.col, got, tt.want)
+			}
+			for _, s := range tt.absent {
+				if strings.Contains(got, s) {
+					t.Errorf("%s still holds %q: %s", tt.col, s, got)
+				}
+			}
+		})
+	}
+}
+
+func TestAnonymizeRowNil(t *testing.T) {
+	var a *Anonymizer
+	row := map[string]any{"Namespace": "shop"}
+	a.anonymizeRow("KubePodInventory", row)
+	if row["Namespace"] != "shop" {
+		t.Errorf("nil Anonymizer changed the row: %v", row)
+	}
+	if err := a.Save(); err != nil {
+		t.Errorf("nil Save = %v", err)
+	}
+}
+
+func TestAnonymizerKeyFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sub", "key.json")
+	a, err := LoadAnonymizer(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := a.pseudonym(anonPod, "cart-1")
+	if err := a.Save(); err != nil {
+		t.Fatal(err)
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Perm() != 0o600 {
+		t.Errorf("key file mode = %v, want 0600", fi.Mode().Perm())
+	}
+
+	b, err := LoadAnonymizer(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := b.text("restarted cart-1"); got != "restarted "+p {
+		t.Errorf("text after reload = %q", got)
+	}
+	if got := b.pseudonym(anonPod, "cart-2"); got == "cart-2" || got == p {
+		t.Errorf("new pseudonym after reload = %q", got)
+	}
+	if e := b.pseudonyms[p]; e.Name != "cart-1" || e.Kind != anonPod {
+		t.Errorf("pseudonyms[%s] = %+v", p, e)
+	}
+
+	if err := os.WriteFile(path, []byte(`{"salt":""}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadAnonymizer(path); err == nil {
+		t.Error("LoadAnonymizer accepted a key file without a salt")
+	}
+	if _, err := LoadAnonymizer(""); err == nil {
+		t.Error("LoadAnonymizer accepted an empty path")
+	}
+}
+
+func TestAnonymizerText(t *testing.T) {
+	a := newTestAnonymizer(t)
+	p := a.pseudonym(anonPod, "web")
+	tests := []struct{ in, want string }{
+		{"web crashed", p + " crashed"},
+		{"webhook crashed", "webhook crashed"},
+		{"web-2 crashed", "web-2 crashed"},
+		{"pod/web crashed", "pod/" + p + " crashed"},
+	}
+	for _, tt := range tests {
+		if got := a.text(tt.in); got != tt.want {
+			t.Errorf("text(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package mustgather
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path"
+	"sort"
+
+	"kubectl-must-gather/pkg/utils"
+)
+
+// auditColumns maps AKSAudit/AKSAuditAdmin columns to the fields of an
+// audit.k8s.io/v1 Event. Dynamic columns are decoded back into JSON values.
+var auditColumns = []struct {
+	column  string
+	field   string
+	dynamic bool
+}{
+	{"Level", "level", false},
+	{"AuditId", "auditID", false},
+	{"Stage", "stage", false},
+	{"RequestUri", "requestURI", false},
+	{"Verb", "verb", false},
+	{"User", "user", true},
+	{"ImpersonatedUser", "impersonatedUser", true},
+	{"SourceIps", "sourceIPs", true},
+	{"UserAgent", "userAgent", false},
+	{"ObjectRef", "objectRef", true},
+	{"ResponseStatus", "responseStatus", true},
+	{"RequestObject", "requestObject", true},
+	{"ResponseObject", "responseObject", true},
+	{"RequestReceivedTime", "requestReceivedTimestamp", false},
+	{"StageReceivedTime", "stageTimestamp", false},
+	{"Annotations", "annotations", true},
+}
+
+// auditWriter reassembles AKSAudit and AKSAuditAdmin rows into Kubernetes
+// audit events and writes them as JSON lines, one file per query chunk, under
+// audit/kube-apiserver/audit-<n>.log — the same format the API server's log
+// backend produces, so existing audit tooling can consume the gather.
+type auditWriter struct {
+	pending []auditEvent
+	files   int
+}
+
+type auditEvent struct {
+	ts   string
+	line []byte
+}
+
+func newAuditWriter() *auditWriter {
+	return &auditWriter{}
+}
+
+func (a *auditWriter) observe(table string, row map[string]any) {
+	if table != "AKSAudit" && table != "AKSAuditAdmin" {
+		return
+	}
+	ev := auditEventFromRow(row)
+	if ev == nil {
+		return
+	}
+	b, err := json.Marshal(ev)
+	if err != nil {
+		return
+	}
+	ts, _ := ev["stageTimestamp"].(string)
+	if ts == "" {
+		ts = toStr(row["TimeGenerated"])
+	}
+	a.pending = append(a.pending, auditEvent{ts: ts, line: b})
+}
+
+func (a *auditWriter) endChunk(tarw *utils.TarWriter) error {
+	if len(a.pending) == 0 {
+		return nil
+	}
+	sort.SliceStable(a.pending, func(i, j int) bool {
+		ti := utils.ParseTimeRFC3339(a.pending[i].ts)
+		tj := utils.ParseTimeRFC3339(a.pending[j].ts)
+		if ti.IsZero() || tj.IsZero() {
+			return a.pending[i].ts < a.pending[j].ts
+		}
+		return ti.Before(tj)
+	})
+	var buf bytes.Buffer
+	for _, ev := range a.pending {
+		buf.Write(ev.line)
+		buf.WriteByte('\n')
+	}
+	a.pending = a.pending[:0]
+	name := path.Join("audit", "kube-apiserver", fmt.Sprintf("audit-%04d.log", a.files))
+	a.files++
+	return utils.WriteFileToTar(tarw, name, buf.Bytes())
+}
+
+func (a *auditWriter) finish(tarw *utils.TarWriter) error {
+	return a.endChunk(tarw)
+}
+
+func (a *auditWriter) warnings(table string) []string {
+	return nil
+}
+
+// auditEventFromRow builds an audit.k8s.io/v1 Event from a table row. Rows
+// without an audit ID are not audit events and yield nil.
+func auditEventFromRow(row map[string]any) map[string]any {
+	if toStr(row["AuditId"]) == "" {
+		return nil
+	}
+	ev := map[string]any{
+		"kind":       "Event",
+		"apiVersion": "audit.k8s.io/v1",
+	}
+	for _, c := range auditColumns {
+		v, ok := row[c.column]
+		if !ok || v == nil || v == "" {
+			continue
+		}
+		if c.dynamic {
+			v = dynamicValue(v)
+		}
+		ev[c.field] = v
+	}
+	return ev
+}
@@ -0,0 +1,79 @@
+package mustgather
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestAuditEventFromRow(t *testing.T) {
+	row := map[string]any{
+		"TimeGenerated":       "2024-01-01T00:00:01Z",
+		"Level":               "Metadata",
+		"AuditId":             "abc-123",
+		"Stage":               "ResponseComplete",
+		"RequestUri":          "/api/v1/namespaces/default/pods",
+		"Verb":                "list",
+		"User":                `{"username":"system:admin","groups":["system:masters"]}`,
+		"SourceIps":           `["10.0.0.1"]`,
+		"UserAgent":           "kubectl/v1.29",
+		"ObjectRef":           `{"resource":"pods","namespace":"default"}`,
+		"ResponseStatus":      `{"code":200}`,
+		"RequestReceivedTime": "2024-01-01T00:00:00.5Z",
+		"StageReceivedTime":   "2024-01-01T00:00:01Z",
+		"ImpersonatedUser":    "",
+		"PodName":             "kube-apiserver-abc",
+	}
+
+	ev := auditEventFromRow(row)
+	if ev == nil {
+		t.Fatal("expected an audit event")
+	}
+	if ev["apiVersion"] != "audit.k8s.io/v1" || ev["kind"] != "Event" {
+		t.Errorf("unexpected type meta: %v/%v", ev["apiVersion"], ev["kind"])
+	}
+	if ev["auditID"] != "abc-123" || ev["verb"] != "list" || ev["requestURI"] != "/api/v1/namespaces/default/pods" {
+		t.Errorf("unexpected scalar fields: %v", ev)
+	}
+	user, ok := ev["user"].(map[string]any)
+	if !ok || user["username"] != "system:admin" {
+		t.Errorf("expected decoded user object, got %#v", ev["user"])
+	}
+	if ips, ok := ev["sourceIPs"].([]any); !ok || len(ips) != 1 || ips[0] != "10.0.0.1" {
+		t.Errorf("expected decoded sourceIPs, got %#v", ev["sourceIPs"])
+	}
+	if _, ok := ev["impersonatedUser"]; ok {
+		t.Error("empty columns should be omitted")
+	}
+	if _, ok := ev["PodName"]; ok {
+		t.Error("non-audit columns should not leak into the event")
+	}
+
+	if auditEventFromRow(map[string]any{"Verb": "get"}) != nil {
+		t.Error("rows without an audit ID should be skipped")
+	}
+}
+
+func TestAuditWriterChunks(t *testing.T) {
+	a := newAuditWriter()
+	a.observe("AKSAudit", map[string]any{"AuditId": "2", "StageReceivedTime": "2024-01-01T00:00:02Z"})
+	a.observe("AKSAudit", map[string]any{"AuditId": "1", "StageReceivedTime": "2024-01-01T00:00:01Z"})
+	a.observe("KubeEvents", map[string]any{"AuditId": "ignored"})
+
+	files := readTransform(t, a)
+	got, ok := files["audit/kube-apiserver/audit-0000.log"]
+	if !ok {
+		t.Fatalf("expected audit-0000.log, got %v", files)
+	}
+	lines := strings.Split(strings.TrimSpace(got), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 audit lines, got %d: %q", len(lines), got)
+	}
+	var first map[string]any
+	if err := json.Unmarshal([]byte(lines[0]), &first); err != nil {
+		t.Fatalf("audit line is not JSON: %v", err)
+	}
+	if first["auditID"] != "1" {
+		t.Errorf("expected events ordered by stage timestamp, first was %v", first["auditID"])
+	}
+}
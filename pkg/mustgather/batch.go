@@ -0,0 +1,191 @@
+package mustgather
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	azquery "github.com/Azure/azure-sdk-for-go/sdk/monitor/azquery"
+)
+
+// maxBatchQueries is the most queries the Logs batch API accepts in one
+// request.
+const maxBatchQueries = 10
+
+// batchLinger is how long a query waits for concurrent queries to share its
+// batch request. Variable so tests can change it.
+var batchLinger = 20 * time.Millisecond
+
+// queryBatcher coalesces concurrent workspace queries into Logs $batch
+// requests, so parallel chunk queries cost one round trip per batch instead
+// of one per query. Each query keeps its own result, error and throttling
+// status, so callers treat it exactly like QueryWorkspace.
+type queryBatcher struct {
+	ctx   context.Context
+	lcli  LogsQueryClient
+	calls chan *batchCall
+	done  chan struct{}
+}
+
+type batchCall struct {
+	req    azquery.BatchQueryRequest
+	result chan batchResult
+}
+
+type batchResult struct {
+	res azquery.LogsClientQueryWorkspaceResponse
+	err error
+}
+
+func newQueryBatcher(ctx context.Context, lcli LogsQueryClient) *queryBatcher {
+	b := &queryBatcher{ctx: ctx, lcli: lcli, calls: make(chan *batchCall), done: make(chan struct{})}
+	go b.loop()
+	return b
+}
+
+// close stops the batcher; queries in flight still complete.
+func (b *queryBatcher) close() {
+	close(b.done)
+}
+
+// query sends one workspace query as part of the next batch. Cancelling ctx
+// abandons the query, leaving its batch to finish without it.
+func (b *queryBatcher) query(ctx context.Context, workspaceGUID string, body azquery.Body, opts azquery.LogsQueryOptions) (azquery.LogsClientQueryWorkspaceResponse, error) {
+	call := &batchCall{
+		req:    azquery.NewBatchQueryRequest(workspaceGUID, *body.Query, *body.Timespan, "", opts),
+		result: make(chan batchResult, 1),
+	}
+	select {
+	case b.calls <- call:
+	case <-b.done:
+		return azquery.LogsClientQueryWorkspaceResponse{}, fmt.Errorf("query batcher closed")
+	case <-ctx.Done():
+		return azquery.LogsClientQueryWorkspaceResponse{}, ctx.Err()
+	}
+	select {
+	case r := <-call.result:
+		return r.res, r.err
+	case <-ctx.Done():
+		return azquery.LogsClientQueryWorkspaceResponse{}, ctx.Err()
+	}
+}
+
+// loop collects calls until the batch is full or has lingered long enough,
+// then sends it.
+func (b *queryBatcher) loop() {
+	for {
+		var calls []*batchCall
+		select {
+		case c := <-b.calls:
+			calls = append(calls, c)
+		case <-b.done:
+			return
+		}
+		timer := time.NewTimer(batchLinger)
+	collect:
+		for len(calls) < maxBatchQueries {
+			select {
+			case c := <-b.calls:
+				calls = append(calls, c)
+			case <-timer.C:
+				break collect
+			}
+		}
+		timer.Stop()
+		go b.send(calls)
+	}
+}
+
+func (b *queryBatcher) send(calls []*batchCall) {
+	reqs := make([]*azquery.BatchQueryRequest, len(calls))
+	for i, c := range calls {
+		c.req.CorrelationID = to.Ptr(strconv.Itoa(i))
+		reqs[i] = &c.req
+	}
+	resp, err := b.lcli.QueryBatch(b.ctx, azquery.BatchRequest{Requests: reqs}, nil)
+	if err != nil {
+		for _, c := range calls {
+			c.result <- batchResult{err: err}
+		}
+		return
+	}
+	answered := make([]bool, len(calls))
+	for _, r := range resp.Responses {
+		if r.CorrelationID == nil {
+			continue
+		}
+		i, err := strconv.Atoi(*r.CorrelationID)
+		if err != nil || i < 0 || i >= len(calls) || answered[i] {
+			continue
+		}
+		answered[i] = true
+		calls[i].result <- batchResponseResult(r)
+	}
+	for i, c := range calls {
+		if !answered[i] {
+			c.result <- batchResult{err: fmt.Errorf("no response for query in batch")}
+		}
+	}
+}
+
+// batchResponseResult converts one response of a batch into what
+// QueryWorkspace would have returned. Failures become *azcore.ResponseError
+// carrying the response's status and headers, so throttling is detected and
+// retried the same way.
+func batchResponseResult(r *azquery.BatchQueryResponse) batchResult {
+	status := http.StatusOK
+	if r.Status != nil {
+		status = int(*r.Status)
+	}
+	body := r.Body
+	if body == nil {
+		body = &azquery.BatchQueryResults{}
+	}
+	if status == http.StatusOK {
+		return batchResult{res: azquery.LogsClientQueryWorkspaceResponse{Results: azquery.Results{
+			Tables:        body.Tables,
+			Error:         body.Error,
+			Statistics:    body.Statistics,
+			Visualization: body.Visualization,
+		}}}
+	}
+	header := http.Header{}
+	for k, v := range r.Headers {
+		if v != nil {
+			header.Set(k, *v)
+		}
+	}
+	// Rebuild the body QueryWorkspace would have seen, for the error message.
+	// The text of the error is not JSON itself, so it becomes the message.
+	var payload []byte
+	if body.Error != nil {
+		var errBody struct {
+			Error struct {
+				Code    string `json:"code"`
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		errBody.Error.Code, errBody.Error.Message = body.Error.Code, body.Error.Error()
+		payload, _ = json.Marshal(errBody)
+	}
+	respErr := &azcore.ResponseError{
+		StatusCode: status,
+		RawResponse: &http.Response{
+			StatusCode: status,
+			Status:     fmt.Sprintf("%d %s", status, http.StatusText(status)),
+			Header:     header,
+			Body:       io.NopCloser(bytes.NewReader(payload)),
+		},
+	}
+	if body.Error != nil {
+		respErr.ErrorCode = body.Error.Code
+	}
+	return batchResult{err: respErr}
+}
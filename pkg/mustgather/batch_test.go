@@ -0,0 +1,116 @@
+package mustgather
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	azquery "github.com/Azure/azure-sdk-for-go/sdk/monitor/azquery"
+
+	"kubectl-must-gather/pkg/testhelpers"
+)
+
+func TestQueryBatcher(t *testing.T) {
+	defer func(d time.Duration) { batchLinger = d }(batchLinger)
+	batchLinger = 200 * time.Millisecond
+	defer func(d time.Duration) { retryBaseDelay = d }(retryBaseDelay)
+	retryBaseDelay = time.Millisecond
+
+	base := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	table := testhelpers.EmulatedTable{
+		Name:    "Heartbeat",
+		Columns: []testhelpers.EmulatedColumn{{Name: "TimeGenerated", Type: "datetime"}, {Name: "Computer", Type: "string"}},
+	}
+	for i := 0; i < 4; i++ {
+		table.Rows = append(table.Rows, []any{base.Add(time.Duration(i) * 15 * time.Minute).Format(time.RFC3339), "node-1"})
+	}
+	emu := testhelpers.NewLogAnalyticsEmulator(table)
+	defer emu.Close()
+
+	g := &Gatherer{
+		config: &Config{MaxRetries: 2, BatchQueries: true},
+		ctx:    context.Background(),
+		cred:   emu.Credential(),
+		cloud:  emu.Cloud(),
+		client: emu.Client(),
+		log:    io.Discard,
+	}
+	g.usage = newUsageTracker(g.client)
+	defer g.usage.stop()
+	lcli, err := azquery.NewLogsClient(g.cred, g.logsOptions())
+	if err != nil {
+		t.Fatalf("logs client: %v", err)
+	}
+	g.batcher = newQueryBatcher(g.ctx, lcli)
+	defer g.batcher.close()
+
+	// The first query of the batch is throttled and retried on its own
+	emu.Throttle(1, http.StatusTooManyRequests, "0")
+	var wg sync.WaitGroup
+	rows := make([]int, 4)
+	errs := make([]error, 4)
+	for i := range rows {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			t0 := base.Add(time.Duration(i) * 15 * time.Minute)
+			res, err := g.query(g.ctx, lcli, emu.WorkspaceGUID, "Heartbeat", t0, t0.Add(15*time.Minute))
+			errs[i] = err
+			if err == nil {
+				rows[i] = len(res.Tables[0].Rows)
+			}
+		}(i)
+	}
+	wg.Wait()
+	for i := range rows {
+		if errs[i] != nil || rows[i] != 1 {
+			t.Errorf("query %d: expected 1 row, got %d (err %v)", i, rows[i], errs[i])
+		}
+	}
+	if got := emu.Batches(); got != 2 {
+		t.Errorf("expected the 4 queries in one batch plus a retry batch, got %d batches", got)
+	}
+	if usage := g.usage.stop(); usage.Retries != 1 {
+		t.Errorf("expected 1 throttled retry, got %d", usage.Retries)
+	}
+
+	_, err = g.query(g.ctx, lcli, emu.WorkspaceGUID, "Missing", base, base.Add(time.Hour))
+	if err == nil || !strings.Contains(err.Error(), "could not be resolved") {
+		t.Errorf("expected per-query error from the batch, got %v", err)
+	}
+}
+
+func TestBatchResponseResultError(t *testing.T) {
+	var info azquery.ErrorInfo
+	if err := json.Unmarshal([]byte(`{"code":"BadArgumentError","message":"'Missing' could not be resolved to a table"}`), &info); err != nil {
+		t.Fatal(err)
+	}
+	res := batchResponseResult(&azquery.BatchQueryResponse{
+		Status:  to.Ptr[int32](http.StatusBadRequest),
+		Headers: map[string]*string{"Retry-After": to.Ptr("3")},
+		Body:    &azquery.BatchQueryResults{Error: &info},
+	})
+	var respErr *azcore.ResponseError
+	if !errors.As(res.err, &respErr) || respErr.StatusCode != http.StatusBadRequest || respErr.ErrorCode != "BadArgumentError" {
+		t.Fatalf("unexpected error %v", res.err)
+	}
+	if respErr.RawResponse.Header.Get("Retry-After") != "3" {
+		t.Errorf("headers not kept: %v", respErr.RawResponse.Header)
+	}
+	// The body is JSON, whatever the text of the error
+	b, _ := io.ReadAll(respErr.RawResponse.Body)
+	var body struct {
+		Error struct{ Code, Message string }
+	}
+	if err := json.Unmarshal(b, &body); err != nil || body.Error.Code != "BadArgumentError" || body.Error.Message != info.Error() {
+		t.Errorf("body %s: %+v, %v", b, body, err)
+	}
+}
@@ -0,0 +1,284 @@
+package mustgather
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+
+	"kubectl-must-gather/pkg/utils"
+)
+
+// blobAPIVersion is the Blob service REST version of the upload requests.
+const blobAPIVersion = "2021-08-06"
+
+// blobBlockSize is the size of the blocks archives are uploaded in, so large
+// archives need no single huge request and memory stays bounded.
+const blobBlockSize = 16 << 20
+
+// blobScope is the token scope of Azure Storage.
+const blobScope = "https://storage.azure.com/.default"
+
+// blobUploader uploads files to a blob container, authenticating with an
+// Azure AD token or the SAS token of the container URL.
+type blobUploader struct {
+	ctx       context.Context
+	client    *http.Client
+	cred      azcore.TokenCredential
+	container *url.URL
+	token     string
+}
+
+// newBlobUploader returns an uploader to containerURL, e.g.
+// https://<account>.blob.core.windows.net/<container>[/<prefix>], optionally
+// with a SAS query string. Without a SAS, requests carry a token of cred.
+func newBlobUploader(ctx context.Context, containerURL string, cred azcore.TokenCredential, client *http.Client) (*blobUploader, error) {
+	u, err := url.Parse(containerURL)
+	if err != nil || u.Host == "" || strings.Trim(u.Path, "/") == "" {
+		return nil, fmt.Errorf("%q is not a blob container URL: expected https://<account>.blob.core.windows.net/<container>", containerURL)
+	}
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Minute}
+	}
+	return &blobUploader{ctx: ctx, client: client, cred: cred, container: u}, nil
+}
+
+// blobURL is the URL of the blob named name in the container, with the SAS
+// token if there is one.
+func (b *blobUploader) blobURL(name string, query url.Values) string {
+	u := *b.container
+	u.Path = path.Join(u.Path, name)
+	q := u.Query()
+	for k, v := range query {
+		q[k] = v
+	}
+	u.RawQuery = q.Encode()
+	return u.String()
+}
+
+// upload uploads the file at p as a block blob named after it, returning the
+// blob URL without the SAS token.
+func (b *blobUploader) upload(p string) (string, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	return b.uploadFrom(filepath.Base(p), f)
+}
+
+// uploadFrom uploads the content of r as a block blob named name under the
+// container URL, returning the blob URL without the SAS token.
+func (b *blobUploader) uploadFrom(name string, r io.Reader) (string, error) {
+	buf := make([]byte, blobBlockSize)
+	var blocks []string
+	for {
+		n, err := io.ReadFull(r, buf)
+		if n > 0 {
+			id := base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("block-%06d", len(blocks))))
+			q := url.Values{"comp": {"block"}, "blockid": {id}}
+			if err := b.put(b.blobURL(name, q), buf[:n], nil); err != nil {
+				return "", fmt.Errorf("upload %s: %w", name, err)
+			}
+			blocks = append(blocks, id)
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+	var list bytes.Buffer
+	list.WriteString(`<?xml version="1.0" encoding="utf-8"?><BlockList>`)
+	for _, id := range blocks {
+		list.WriteString("<Latest>" + id + "</Latest>")
+	}
+	list.WriteString("</BlockList>")
+	if err := b.put(b.blobURL(name, url.Values{"comp": {"blocklist"}}), list.Bytes(), map[string]string{"x-ms-blob-content-type": blobContentType(name)}); err != nil {
+		return "", fmt.Errorf("upload %s: %w", name, err)
+	}
+	u := *b.container
+	u.Path, u.RawQuery = path.Join(u.Path, name), ""
+	return u.String(), nil
+}
+
+func (b *blobUploader) put(u string, body []byte, headers map[string]string) error {
+	_, err := b.send(http.MethodPut, u, body, headers)
+	return err
+}
+
+// send sends a request to the Blob service and returns the response body.
+func (b *blobUploader) send(method, u string, body []byte, headers map[string]string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(b.ctx, method, u, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("x-ms-version", blobAPIVersion)
+	req.Header.Set("x-ms-date", time.Now().UTC().Format(http.TimeFormat))
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	if !b.container.Query().Has("sig") {
+		if b.token == "" {
+			tok, err := b.cred.GetToken(b.ctx, policy.TokenRequestOptions{Scopes: []string{blobScope}})
+			if err != nil {
+				return nil, fmt.Errorf("storage token: %w", err)
+			}
+			b.token = tok.Token
+		}
+		req.Header.Set("Authorization", "Bearer "+b.token)
+	}
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		if code := resp.Header.Get("x-ms-error-code"); code != "" {
+			return nil, fmt.Errorf("blob storage returned %s (%s)", resp.Status, code)
+		}
+		return nil, fmt.Errorf("blob storage returned %s: %s", resp.Status, firstLine(strings.TrimSpace(string(msg))))
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// list returns the names of the blobs directly under the container URL whose
+// names start with prefix.
+func (b *blobUploader) list(prefix string) ([]string, error) {
+	container, dir, _ := strings.Cut(strings.Trim(b.container.Path, "/"), "/")
+	if dir != "" {
+		dir += "/"
+	}
+	var names []string
+	marker := ""
+	for {
+		u := *b.container
+		u.Path = "/" + container
+		q := u.Query()
+		q.Set("restype", "container")
+		q.Set("comp", "list")
+		q.Set("prefix", dir+prefix)
+		if marker != "" {
+			q.Set("marker", marker)
+		}
+		u.RawQuery = q.Encode()
+		body, err := b.send(http.MethodGet, u.String(), nil, nil)
+		if err != nil {
+			return nil, fmt.Errorf("list blobs: %w", err)
+		}
+		var page struct {
+			Blobs []struct {
+				Name string `xml:"Name"`
+			} `xml:"Blobs>Blob"`
+			NextMarker string `xml:"NextMarker"`
+		}
+		if err := xml.Unmarshal(body, &page); err != nil {
+			return nil, fmt.Errorf("list blobs: %w", err)
+		}
+		for _, blob := range page.Blobs {
+			if name := strings.TrimPrefix(blob.Name, dir); !strings.Contains(name, "/") {
+				names = append(names, name)
+			}
+		}
+		if marker = page.NextMarker; marker == "" {
+			return names, nil
+		}
+	}
+}
+
+// remove deletes the blob named name under the container URL.
+func (b *blobUploader) remove(name string) error {
+	if _, err := b.send(http.MethodDelete, b.blobURL(name, nil), nil, nil); err != nil {
+		return fmt.Errorf("delete %s: %w", name, err)
+	}
+	return nil
+}
+
+// redacted is the container URL without its SAS token, for messages.
+func (b *blobUploader) redacted() string {
+	u := *b.container
+	u.RawQuery = ""
+	return u.String()
+}
+
+// blobContentType is the content type archives, manifests and the files of a
+// blob output are stored with.
+func blobContentType(name string) string {
+	switch {
+	case strings.HasSuffix(name, ".json"):
+		return "application/json"
+	case strings.HasSuffix(name, ".gz"):
+		return "application/gzip"
+	case strings.HasSuffix(name, ".zip"):
+		return "application/zip"
+	case strings.HasSuffix(name, ".ndjson"):
+		return "application/x-ndjson"
+	case strings.HasSuffix(name, ".log"), strings.HasSuffix(name, ".md"), strings.HasSuffix(name, ".kql"):
+		return "text/plain; charset=utf-8"
+	case strings.HasSuffix(name, ".html"):
+		return "text/html; charset=utf-8"
+	}
+	return "application/octet-stream"
+}
+
+// uploadOutputs uploads the files the gather wrote to UploadTo: the archive,
+// the restricted archive and the signed manifest.
+func (g *Gatherer) uploadOutputs() error {
+	up, err := newBlobUploader(context.Background(), g.config.UploadTo, g.cred, g.client)
+	if err != nil {
+		return err
+	}
+	for _, f := range []string{g.outFile, g.restrictedFile, g.manifestFile, g.signatureFile} {
+		if f == "" {
+			continue
+		}
+		u, err := up.upload(f)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(g.log, "Uploaded %s to %s\n", f, u)
+		g.uploaded = append(g.uploaded, u)
+	}
+	return nil
+}
+
+// blobSink uploads each file of a bundle as a blob below a container URL.
+type blobSink struct {
+	up *blobUploader
+}
+
+// NewBlobSink returns a Sink uploading each file of a bundle as a block blob
+// below containerURL, e.g. https://<account>.blob.core.windows.net/<container>/<prefix>,
+// named after its path in the bundle. Requests carry the SAS token of the
+// URL, or else a token of cred; client may be nil.
+func NewBlobSink(ctx context.Context, containerURL string, cred azcore.TokenCredential, client *http.Client) (utils.Sink, error) {
+	up, err := newBlobUploader(ctx, containerURL, cred, client)
+	if err != nil {
+		return nil, err
+	}
+	return &blobSink{up: up}, nil
+}
+
+func (s *blobSink) WriteFile(name string, r io.Reader) error {
+	_, err := s.up.uploadFrom(name, r)
+	return err
+}
+
+// Close does nothing: every blob is committed once written.
+func (s *blobSink) Close() error {
+	return nil
+}
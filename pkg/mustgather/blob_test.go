@@ -0,0 +1,167 @@
+package mustgather
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+)
+
+type fakeStorageCredential struct{ scopes []string }
+
+func (c *fakeStorageCredential) GetToken(_ context.Context, opts policy.TokenRequestOptions) (azcore.AccessToken, error) {
+	c.scopes = append(c.scopes, opts.Scopes...)
+	return azcore.AccessToken{Token: "storage-token", ExpiresOn: time.Now().Add(time.Hour)}, nil
+}
+
+// fakeBlobService records the blocks and block lists it is sent.
+type fakeBlobService struct {
+	mu       sync.Mutex
+	requests []string
+	auth     []string
+	blocks   map[string]string
+	lists    map[string]string
+}
+
+func (f *fakeBlobService) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	body, _ := io.ReadAll(r.Body)
+	f.requests = append(f.requests, r.Method+" "+r.URL.Path+" "+r.URL.Query().Get("comp"))
+	f.auth = append(f.auth, r.Header.Get("Authorization"))
+	if r.URL.Path == "/diag/denied/a.tar.gz" {
+		w.Header().Set("x-ms-error-code", "AuthorizationPermissionMismatch")
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	switch {
+	case r.Method == http.MethodGet && r.URL.Query().Get("comp") == "list":
+		// One blob per page, to follow the markers
+		var names []string
+		for p := range f.lists {
+			if name := strings.TrimPrefix(p, r.URL.Path+"/"); name != p && strings.HasPrefix(name, r.URL.Query().Get("prefix")) && name > r.URL.Query().Get("marker") {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+		page := "<EnumerationResults><Blobs>"
+		if len(names) > 0 {
+			page += "<Blob><Name>" + names[0] + "</Name></Blob>"
+		}
+		page += "</Blobs><NextMarker>"
+		if len(names) > 1 {
+			page += names[0]
+		}
+		_, _ = io.WriteString(w, page+"</NextMarker></EnumerationResults>")
+		return
+	case r.Method == http.MethodDelete:
+		delete(f.lists, r.URL.Path)
+		w.WriteHeader(http.StatusAccepted)
+		return
+	case r.URL.Query().Get("comp") == "block":
+		f.blocks[r.URL.Path+"#"+r.URL.Query().Get("blockid")] = string(body)
+	case r.URL.Query().Get("comp") == "blocklist":
+		f.lists[r.URL.Path] = string(body)
+	}
+	w.WriteHeader(http.StatusCreated)
+}
+
+func TestBlobUpload(t *testing.T) {
+	svc := &fakeBlobService{blocks: map[string]string{}, lists: map[string]string{}}
+	srv := httptest.NewServer(svc)
+	defer srv.Close()
+	file := filepath.Join(t.TempDir(), "a.tar.gz")
+	if err := os.WriteFile(file, []byte("archive"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	cred := &fakeStorageCredential{}
+	up, err := newBlobUploader(context.Background(), srv.URL+"/diag/prod", cred, srv.Client())
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err := up.upload(file)
+	if err != nil {
+		t.Fatalf("upload failed: %v", err)
+	}
+	if u != srv.URL+"/diag/prod/a.tar.gz" {
+		t.Errorf("blob URL = %s", u)
+	}
+	if strings.Join(svc.requests, "; ") != "PUT /diag/prod/a.tar.gz block; PUT /diag/prod/a.tar.gz blocklist" {
+		t.Errorf("requests = %v", svc.requests)
+	}
+	if svc.auth[0] != "Bearer storage-token" || len(cred.scopes) != 1 || cred.scopes[0] != blobScope {
+		t.Errorf("auth = %v, scopes = %v", svc.auth, cred.scopes)
+	}
+	for id, data := range svc.blocks {
+		if data != "archive" || !strings.Contains(svc.lists["/diag/prod/a.tar.gz"], "<Latest>"+strings.TrimPrefix(id, "/diag/prod/a.tar.gz#")+"</Latest>") {
+			t.Errorf("block %s = %q not in list %s", id, data, svc.lists)
+		}
+	}
+
+	// A SAS token in the URL replaces the bearer token
+	svc.auth = nil
+	up, err = newBlobUploader(context.Background(), srv.URL+"/diag?sv=2021-08-06&sig=abc", nil, srv.Client())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u, err = up.upload(file); err != nil || u != srv.URL+"/diag/a.tar.gz" {
+		t.Fatalf("upload with SAS = %s, %v", u, err)
+	}
+	for _, a := range svc.auth {
+		if a != "" {
+			t.Errorf("SAS upload sent Authorization %q", a)
+		}
+	}
+
+	up, _ = newBlobUploader(context.Background(), srv.URL+"/diag/denied", cred, srv.Client())
+	if _, err := up.upload(file); err == nil || !strings.Contains(err.Error(), "AuthorizationPermissionMismatch") {
+		t.Errorf("expected the storage error code, got %v", err)
+	}
+
+	for _, bad := range []string{"", "https://account.blob.core.windows.net", "://x"} {
+		if _, err := newBlobUploader(context.Background(), bad, cred, nil); err == nil {
+			t.Errorf("newBlobUploader accepted %q", bad)
+		}
+	}
+}
+
+func TestBlobListAndRemove(t *testing.T) {
+	svc := &fakeBlobService{blocks: map[string]string{}, lists: map[string]string{}}
+	for _, p := range []string{"/diag/prod/a-1", "/diag/prod/a-2", "/diag/prod/b-1", "/diag/prod/sub/a-3", "/diag/a-4"} {
+		svc.lists[p] = ""
+	}
+	srv := httptest.NewServer(svc)
+	defer srv.Close()
+
+	up, err := newBlobUploader(context.Background(), srv.URL+"/diag/prod?sv=2021-08-06&sig=abc", nil, srv.Client())
+	if err != nil {
+		t.Fatal(err)
+	}
+	names, err := up.list("a-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(names, ",") != "a-1,a-2" {
+		t.Errorf("list = %v", names)
+	}
+	if err := up.remove("a-1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := svc.lists["/diag/prod/a-1"]; ok {
+		t.Error("a-1 was not deleted")
+	}
+	if got := up.redacted(); got != srv.URL+"/diag/prod" {
+		t.Errorf("redacted = %s", got)
+	}
+}
@@ -0,0 +1,215 @@
+package mustgather
+
+import (
+	"fmt"
+	"strings"
+)
+
+// gatherBudget enforces MaxTotalRows and MaxTotalBytes across a whole gather.
+// Each table gets a share of the remaining budget proportional to its
+// estimated size among the tables not exported yet, so budget a table leaves
+// unused flows to the tables after it.
+type gatherBudget struct {
+	maxRows, maxBytes   int64
+	usedRows, usedBytes int64
+	estimates           map[string]tableEstimate
+	// pending lists tables not exported yet, in export order.
+	pending   []string
+	truncated []string
+}
+
+// tableEstimate is a table's size in the gather window, from a count probe.
+type tableEstimate struct {
+	Rows  int64
+	Bytes int64
+}
+
+// tableBudget is one table's share of the gather budget. A zero limit is
+// unlimited; a nil tableBudget never runs out.
+type tableBudget struct {
+	maxRows, maxBytes int64
+	rows, bytes       int64
+	// exhausted is set once a row did not fit.
+	exhausted bool
+	// stoppedAt is the TimeGenerated, or chunk start, of the first row left out.
+	stoppedAt string
+}
+
+// newGatherBudget returns nil when neither limit is set.
+func newGatherBudget(maxRows, maxBytes int64, tables []string, estimates map[string]tableEstimate) *gatherBudget {
+	if maxRows <= 0 && maxBytes <= 0 {
+		return nil
+	}
+	return &gatherBudget{
+		maxRows:   maxRows,
+		maxBytes:  maxBytes,
+		estimates: estimates,
+		pending:   append([]string{}, tables...),
+	}
+}
+
+// forTable allocates the next table's share.
+func (b *gatherBudget) forTable(table string) *tableBudget {
+	if b == nil {
+		return nil
+	}
+	var estRows, estBytes, sumRows, sumBytes int64
+	for _, t := range b.pending {
+		e := b.estimates[t]
+		sumRows += e.Rows
+		sumBytes += e.Bytes
+		if t == table {
+			estRows, estBytes = e.Rows, e.Bytes
+		}
+	}
+	tb := &tableBudget{}
+	if b.maxRows > 0 {
+		tb.maxRows = share(b.maxRows-b.usedRows, estRows, sumRows, len(b.pending))
+	}
+	if b.maxBytes > 0 {
+		tb.maxBytes = share(b.maxBytes-b.usedBytes, estBytes, sumBytes, len(b.pending))
+	}
+	return tb
+}
+
+// share splits remaining in proportion to est/sum, or evenly over n tables
+// when there are no estimates. It is never zero, which would mean unlimited:
+// every table gets at least 1 while budget is left, and -1 once it is gone.
+func share(remaining, est, sum int64, n int) int64 {
+	if remaining <= 0 {
+		return -1
+	}
+	var s int64
+	switch {
+	case sum > 0:
+		s = int64(float64(remaining) * float64(est) / float64(sum))
+	case n > 0:
+		s = remaining / int64(n)
+	}
+	if s < 1 {
+		s = 1
+	}
+	return s
+}
+
+// done records what a table used.
+func (b *gatherBudget) done(table string, tb *tableBudget) {
+	if b == nil || tb == nil {
+		return
+	}
+	b.usedRows += tb.rows
+	b.usedBytes += tb.bytes
+	if tb.exhausted {
+		b.truncated = append(b.truncated, table)
+	}
+	for i, t := range b.pending {
+		if t == table {
+			b.pending = append(b.pending[:i], b.pending[i+1:]...)
+			break
+		}
+	}
+}
+
+// take reserves room for one row of n bytes, reporting false when it does
+// not fit.
+func (tb *tableBudget) take(n int) bool {
+	if tb == nil {
+		return true
+	}
+	if tb.exhausted ||
+		(tb.maxRows != 0 && tb.rows+1 > tb.maxRows) ||
+		(tb.maxBytes != 0 && tb.bytes+int64(n) > tb.maxBytes) {
+		tb.exhausted = true
+		return false
+	}
+	tb.rows++
+	tb.bytes += int64(n)
+	return true
+}
+
+// spent reports whether the table ran out of budget.
+func (tb *tableBudget) spent() bool {
+	return tb != nil && tb.exhausted
+}
+
+// summary is the budget note recorded in a table's summary.json.
+func (tb *tableBudget) summary() map[string]any {
+	s := map[string]any{"rows": tb.rows, "bytes": tb.bytes, "truncated": tb.exhausted}
+	if tb.maxRows > 0 {
+		s["maxRows"] = tb.maxRows
+	}
+	if tb.maxBytes > 0 {
+		s["maxBytes"] = tb.maxBytes
+	}
+	if tb.exhausted {
+		s["note"] = fmt.Sprintf("table's share of --max-total-rows/--max-total-bytes used up; rows from %s onward were not exported", tb.stoppedAt)
+	}
+	return s
+}
+
+// summary is the budget section of metadata/run.json.
+func (b *gatherBudget) summary() map[string]any {
+	s := map[string]any{"rows": b.usedRows, "bytes": b.usedBytes}
+	if b.maxRows > 0 {
+		s["maxTotalRows"] = b.maxRows
+	}
+	if b.maxBytes > 0 {
+		s["maxTotalBytes"] = b.maxBytes
+	}
+	if len(b.truncated) > 0 {
+		s["truncatedTables"] = b.truncated
+	}
+	return s
+}
+
+// estimateTables counts the rows and approximate bytes of each table in the
+// gather window, to split the budget between them.
+func (g *Gatherer) estimateTables(lcli LogsQueryClient, workspaceGUID string, tables []string) (map[string]tableEstimate, error) {
+	refs := make([]string, 0, len(tables))
+	for _, t := range tables {
+		refs = append(refs, "['"+t+"']")
+	}
+	q := fmt.Sprintf("union isfuzzy=true withsource=SourceTable %s | summarize Rows=count(), Bytes=sum(estimate_data_size(*)) by SourceTable", strings.Join(refs, ", "))
+	res, err := g.query(g.ctx, lcli, workspaceGUID, q, g.start, g.end)
+	if err != nil {
+		return nil, err
+	}
+	est := map[string]tableEstimate{}
+	if len(res.Tables) == 0 {
+		return est, nil
+	}
+	tab := res.Tables[0]
+	srcIdx, rowsIdx, bytesIdx := -1, -1, -1
+	for i, c := range tab.Columns {
+		switch *c.Name {
+		case "SourceTable":
+			srcIdx = i
+		case "Rows":
+			rowsIdx = i
+		case "Bytes":
+			bytesIdx = i
+		}
+	}
+	if srcIdx < 0 || rowsIdx < 0 || bytesIdx < 0 {
+		return nil, fmt.Errorf("unexpected size estimate columns")
+	}
+	for _, row := range tab.Rows {
+		rows, _ := toFloat(row[rowsIdx])
+		bytes, _ := toFloat(row[bytesIdx])
+		est[toStr(row[srcIdx])] = tableEstimate{Rows: int64(rows), Bytes: int64(bytes)}
+	}
+	return est, nil
+}
+
+// newBudget sets up the gather budget, estimating table sizes when a limit is
+// set. Without estimates the budget is split evenly.
+func (g *Gatherer) newBudget(lcli LogsQueryClient, workspaceGUID string, tables []string) *gatherBudget {
+	if g.config.MaxTotalRows <= 0 && g.config.MaxTotalBytes <= 0 {
+		return nil
+	}
+	est, err := g.estimateTables(lcli, workspaceGUID, tables)
+	if err != nil {
+		g.warnf("", "estimating table sizes failed, splitting budget evenly: %v", err)
+	}
+	return newGatherBudget(g.config.MaxTotalRows, g.config.MaxTotalBytes, tables, est)
+}
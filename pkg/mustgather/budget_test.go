@@ -0,0 +1,178 @@
+package mustgather
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	azquery "github.com/Azure/azure-sdk-for-go/sdk/monitor/azquery"
+
+	"kubectl-must-gather/pkg/testhelpers"
+	"kubectl-must-gather/pkg/utils"
+)
+
+func TestGatherBudgetAllocation(t *testing.T) {
+	tests := []struct {
+		name      string
+		maxRows   int64
+		estimates map[string]tableEstimate
+		used      map[string]int64 // rows each table ends up using
+		want      map[string]int64 // row share per table
+	}{
+		{
+			name:      "proportional to estimates",
+			maxRows:   100,
+			estimates: map[string]tableEstimate{"A": {Rows: 300}, "B": {Rows: 100}},
+			used:      map[string]int64{"A": 75},
+			want:      map[string]int64{"A": 75, "B": 25},
+		},
+		{
+			name:      "unused share flows to later tables",
+			maxRows:   100,
+			estimates: map[string]tableEstimate{"A": {Rows: 300}, "B": {Rows: 100}},
+			used:      map[string]int64{"A": 10},
+			want:      map[string]int64{"A": 75, "B": 90},
+		},
+		{
+			name:    "even split without estimates",
+			maxRows: 100,
+			used:    map[string]int64{"A": 50},
+			want:    map[string]int64{"A": 50, "B": 50},
+		},
+		{
+			name:      "nothing left",
+			maxRows:   10,
+			estimates: map[string]tableEstimate{"A": {Rows: 1}, "B": {Rows: 1}},
+			used:      map[string]int64{"A": 10},
+			want:      map[string]int64{"A": 5, "B": -1},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := newGatherBudget(tt.maxRows, 0, []string{"A", "B"}, tt.estimates)
+			for _, table := range []string{"A", "B"} {
+				tb := b.forTable(table)
+				if tb.maxRows != tt.want[table] {
+					t.Errorf("%s: share = %d, want %d", table, tb.maxRows, tt.want[table])
+				}
+				tb.rows = tt.used[table]
+				b.done(table, tb)
+			}
+		})
+	}
+
+	if newGatherBudget(0, 0, []string{"A"}, nil) != nil {
+		t.Error("expected no budget without limits")
+	}
+	var unlimited *tableBudget
+	if !unlimited.take(1 << 30) {
+		t.Error("nil table budget should never run out")
+	}
+}
+
+func TestTableBudgetTake(t *testing.T) {
+	tb := &tableBudget{maxRows: 10, maxBytes: 25}
+	if !tb.take(10) || !tb.take(10) {
+		t.Fatal("expected rows within budget to fit")
+	}
+	if tb.take(10) {
+		t.Error("expected byte budget to stop the third row")
+	}
+	if tb.take(1) {
+		t.Error("expected budget to stay spent once a row did not fit")
+	}
+	if tb.rows != 2 || tb.bytes != 20 || !tb.spent() {
+		t.Errorf("unexpected accounting: %+v", tb)
+	}
+}
+
+func TestExportTablesWithinBudget(t *testing.T) {
+	base := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	table := func(name string, n int) testhelpers.EmulatedTable {
+		tab := testhelpers.EmulatedTable{
+			Name:    name,
+			Columns: []testhelpers.EmulatedColumn{{Name: "TimeGenerated", Type: "datetime"}, {Name: "Computer", Type: "string"}},
+		}
+		for i := 0; i < n; i++ {
+			tab.Rows = append(tab.Rows, []any{base.Add(time.Duration(i) * 5 * time.Minute).Format(time.RFC3339), "node-1"})
+		}
+		return tab
+	}
+	emu := testhelpers.NewLogAnalyticsEmulator(table("Heartbeat", 8), table("Perf", 2))
+	defer emu.Close()
+
+	g := &Gatherer{
+		config: &Config{MaxTotalRows: 5},
+		ctx:    context.Background(),
+		cred:   emu.Credential(),
+		cloud:  emu.Cloud(),
+		client: emu.Client(),
+		log:    io.Discard,
+		start:  base,
+		end:    base.Add(time.Hour),
+	}
+	g.usage = newUsageTracker(g.client)
+	defer g.usage.stop()
+	lcli, err := azquery.NewLogsClient(g.cred, g.logsOptions())
+	if err != nil {
+		t.Fatalf("logs client: %v", err)
+	}
+
+	var buf bytes.Buffer
+	tarw := utils.NewTarWriter(&buf, time.Time{})
+	if err := g.exportTables(tarw, lcli, nil, []string{"Heartbeat", "Perf"}, emu.WorkspaceGUID, "", "", "", "PT1H"); err != nil {
+		t.Fatalf("exportTables: %v", err)
+	}
+	tarw.Close()
+
+	files := map[string]string{}
+	tr := tar.NewReader(&buf)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("read tar: %v", err)
+		}
+		if hdr.Typeflag == tar.TypeDir {
+			continue
+		}
+		b, _ := io.ReadAll(tr)
+		files[hdr.Name] += string(b)
+	}
+
+	// Heartbeat has 8 of 10 estimated rows: 4 of the 5-row budget
+	for table, want := range map[string]int{"Heartbeat": 4, "Perf": 1} {
+		var sum struct {
+			Rows   int `json:"rows"`
+			Budget struct {
+				Truncated bool   `json:"truncated"`
+				Note      string `json:"note"`
+			} `json:"budget"`
+		}
+		if err := json.Unmarshal([]byte(files["tables/"+table+"/summary.json"]), &sum); err != nil {
+			t.Fatalf("%s summary: %v", table, err)
+		}
+		if sum.Rows != want || !sum.Budget.Truncated || !strings.Contains(sum.Budget.Note, "were not exported") {
+			t.Errorf("%s: unexpected summary %+v", table, sum)
+		}
+		var parts int
+		for name, content := range files {
+			if strings.HasPrefix(name, "tables/"+table+"/parts/") {
+				parts += strings.Count(content, "\n")
+			}
+		}
+		if parts != want {
+			t.Errorf("%s: expected %d exported rows, got %d", table, want, parts)
+		}
+	}
+	if got := g.budget.summary()["truncatedTables"]; len(got.([]string)) != 2 {
+		t.Errorf("expected both tables truncated, got %v", got)
+	}
+}
@@ -0,0 +1,82 @@
+package mustgather
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	azquery "github.com/Azure/azure-sdk-for-go/sdk/monitor/azquery"
+)
+
+// cacheSettleDelay is how long after a chunk's end we wait before caching it.
+// Log Analytics ingestion is delayed by several minutes, so recent chunks may
+// still receive rows and must always be re-queried.
+const cacheSettleDelay = 15 * time.Minute
+
+// queryCache stores chunk query results on disk, keyed by workspace, query
+// text and time window, so overlapping gathers can reuse earlier results.
+// The results are cached as queried, before scrubbing and redaction, so only
+// their owner may read them.
+type queryCache struct {
+	dir string
+}
+
+func newQueryCache(dir string) (*queryCache, error) {
+	if dir == "" {
+		return nil, nil
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("create cache dir: %w", err)
+	}
+	return &queryCache{dir: dir}, nil
+}
+
+func (c *queryCache) key(workspaceGUID, query string, t0, t1 time.Time) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\n%s\n%s\n%s", workspaceGUID, query, t0.UTC().Format(time.RFC3339Nano), t1.UTC().Format(time.RFC3339Nano))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func (c *queryCache) path(key string) string {
+	return filepath.Join(c.dir, key[:2], key+".json")
+}
+
+// get returns the cached table for the chunk, or nil on a miss.
+func (c *queryCache) get(workspaceGUID, query string, t0, t1 time.Time) *azquery.Table {
+	if c == nil {
+		return nil
+	}
+	b, err := os.ReadFile(c.path(c.key(workspaceGUID, query, t0, t1)))
+	if err != nil {
+		return nil
+	}
+	var tab azquery.Table
+	if err := json.Unmarshal(b, &tab); err != nil {
+		return nil
+	}
+	return &tab
+}
+
+// put stores a chunk result if the window is old enough to be complete.
+func (c *queryCache) put(workspaceGUID, query string, t0, t1 time.Time, tab *azquery.Table) error {
+	if c == nil || tab == nil || time.Since(t1) < cacheSettleDelay {
+		return nil
+	}
+	p := c.path(c.key(workspaceGUID, query, t0, t1))
+	if err := os.MkdirAll(filepath.Dir(p), 0o700); err != nil {
+		return err
+	}
+	b, err := json.Marshal(tab)
+	if err != nil {
+		return err
+	}
+	tmp := p + ".tmp"
+	if err := os.WriteFile(tmp, b, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, p)
+}
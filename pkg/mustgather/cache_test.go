@@ -0,0 +1,84 @@
+package mustgather
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	azquery "github.com/Azure/azure-sdk-for-go/sdk/monitor/azquery"
+)
+
+func TestQueryCacheRoundTrip(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "cache")
+	c, err := newQueryCache(dir)
+	if err != nil {
+		t.Fatalf("newQueryCache failed: %v", err)
+	}
+
+	t0 := time.Now().UTC().Add(-2 * time.Hour).Truncate(time.Hour)
+	t1 := t0.Add(time.Hour)
+	tab := &azquery.Table{
+		Name:    to.Ptr("PrimaryResult"),
+		Columns: []*azquery.Column{{Name: to.Ptr("TimeGenerated"), Type: to.Ptr(azquery.LogsColumnTypeDatetime)}},
+		Rows:    []azquery.Row{{"2024-01-01T00:00:00Z"}},
+	}
+
+	if got := c.get("ws", "KubeEvents", t0, t1); got != nil {
+		t.Fatalf("expected cache miss before put, got %v", got)
+	}
+	if err := c.put("ws", "KubeEvents", t0, t1, tab); err != nil {
+		t.Fatalf("put failed: %v", err)
+	}
+
+	got := c.get("ws", "KubeEvents", t0, t1)
+	if got == nil {
+		t.Fatal("expected cache hit after put")
+	}
+	if len(got.Rows) != 1 || got.Rows[0][0] != "2024-01-01T00:00:00Z" {
+		t.Errorf("unexpected cached rows: %v", got.Rows)
+	}
+	// The unredacted rows are readable by their owner only
+	p := c.path(c.key("ws", "KubeEvents", t0, t1))
+	for path, want := range map[string]os.FileMode{dir: 0o700, filepath.Dir(p): 0o700, p: 0o600} {
+		fi, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fi.Mode().Perm() != want {
+			t.Errorf("mode of %s = %v, want %v", path, fi.Mode().Perm(), want)
+		}
+	}
+
+	// Different query text or window must not collide.
+	if c.get("ws", "KubeEvents | where Namespace == 'x'", t0, t1) != nil {
+		t.Error("expected miss for a different query")
+	}
+	if c.get("ws", "KubeEvents", t0.Add(time.Minute), t1) != nil {
+		t.Error("expected miss for a different window")
+	}
+}
+
+func TestQueryCacheSkipsRecentChunks(t *testing.T) {
+	c, _ := newQueryCache(t.TempDir())
+	t1 := time.Now().UTC()
+	t0 := t1.Add(-15 * time.Minute)
+	if err := c.put("ws", "KubeEvents", t0, t1, &azquery.Table{}); err != nil {
+		t.Fatalf("put failed: %v", err)
+	}
+	if c.get("ws", "KubeEvents", t0, t1) != nil {
+		t.Error("chunks still receiving ingestion should not be cached")
+	}
+}
+
+func TestQueryCacheDisabled(t *testing.T) {
+	c, err := newQueryCache("")
+	if err != nil || c != nil {
+		t.Fatalf("expected nil cache for empty dir, got %v, %v", c, err)
+	}
+	// nil cache must be safe to use
+	if c.get("ws", "q", time.Now(), time.Now()) != nil {
+		t.Error("nil cache should always miss")
+	}
+}
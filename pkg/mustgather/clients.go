@@ -0,0 +1,67 @@
+package mustgather
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/runtime"
+	azquery "github.com/Azure/azure-sdk-for-go/sdk/monitor/azquery"
+	armoperationalinsights "github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/operationalinsights/armoperationalinsights"
+)
+
+// LogsQueryClient runs queries against the Log Analytics data plane.
+// *azquery.LogsClient implements it.
+type LogsQueryClient interface {
+	LogsClientInterface
+	QueryBatch(ctx context.Context, body azquery.BatchRequest, options *azquery.LogsClientQueryBatchOptions) (azquery.LogsClientQueryBatchResponse, error)
+}
+
+// TablesClient lists and reads the tables of a workspace through the
+// management plane. *armoperationalinsights.TablesClient implements it.
+type TablesClient interface {
+	Get(ctx context.Context, resourceGroupName, workspaceName, tableName string, options *armoperationalinsights.TablesClientGetOptions) (armoperationalinsights.TablesClientGetResponse, error)
+	NewListByWorkspacePager(resourceGroupName, workspaceName string, options *armoperationalinsights.TablesClientListByWorkspaceOptions) *runtime.Pager[armoperationalinsights.TablesClientListByWorkspaceResponse]
+}
+
+// WorkspacesClient looks up workspaces through the management plane.
+// *armoperationalinsights.WorkspacesClient implements it.
+type WorkspacesClient interface {
+	Get(ctx context.Context, resourceGroupName, workspaceName string, options *armoperationalinsights.WorkspacesClientGetOptions) (armoperationalinsights.WorkspacesClientGetResponse, error)
+}
+
+var (
+	_ LogsQueryClient  = (*azquery.LogsClient)(nil)
+	_ TablesClient     = (*armoperationalinsights.TablesClient)(nil)
+	_ WorkspacesClient = (*armoperationalinsights.WorkspacesClient)(nil)
+)
+
+// logsClient returns the Environment's logs client, or a new one for the
+// gather's cloud and credential.
+func (g *Gatherer) logsClient() (LogsQueryClient, error) {
+	if g.lcli != nil {
+		return g.lcli, nil
+	}
+	lcli, err := azquery.NewLogsClient(authCredential{g.cred}, g.logsOptions())
+	if err != nil {
+		return nil, fmt.Errorf("logs client: %w", err)
+	}
+	return lcli, nil
+}
+
+// tablesClient returns the Environment's tables client, or a new one for the
+// subscription.
+func (g *Gatherer) tablesClient(subID string) (TablesClient, error) {
+	if g.tcli != nil {
+		return g.tcli, nil
+	}
+	return armoperationalinsights.NewTablesClient(subID, authCredential{g.cred}, g.armOptions())
+}
+
+// workspacesClient returns the Environment's workspaces client, or a new one
+// for the subscription.
+func (g *Gatherer) workspacesClient(subID string) (WorkspacesClient, error) {
+	if g.wcli != nil {
+		return g.wcli, nil
+	}
+	return armoperationalinsights.NewWorkspacesClient(subID, authCredential{g.cred}, g.armOptions())
+}
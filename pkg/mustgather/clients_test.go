@@ -0,0 +1,172 @@
+package mustgather
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/runtime"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	azquery "github.com/Azure/azure-sdk-for-go/sdk/monitor/azquery"
+	armoperationalinsights "github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/operationalinsights/armoperationalinsights"
+)
+
+// noCredential fails every token request, so a test that reaches Azure fails.
+type noCredential struct{}
+
+func (noCredential) GetToken(context.Context, policy.TokenRequestOptions) (azcore.AccessToken, error) {
+	return azcore.AccessToken{}, errors.New("no Azure access in tests")
+}
+
+// fakeLogs answers every query with the rows of the table it names whose
+// first column, TimeGenerated, falls in the query's timespan.
+type fakeLogs struct {
+	mu      sync.Mutex
+	queries []string
+	rows    map[string][]azquery.Row
+}
+
+func (f *fakeLogs) QueryWorkspace(ctx context.Context, workspaceID string, body azquery.Body, options *azquery.LogsClientQueryWorkspaceOptions) (azquery.LogsClientQueryWorkspaceResponse, error) {
+	f.mu.Lock()
+	f.queries = append(f.queries, *body.Query)
+	f.mu.Unlock()
+	tab := &azquery.Table{Name: to.Ptr("PrimaryResult"), Columns: []*azquery.Column{
+		{Name: to.Ptr("TimeGenerated"), Type: to.Ptr(azquery.LogsColumnTypeDatetime)},
+		{Name: to.Ptr("Computer"), Type: to.Ptr(azquery.LogsColumnTypeString)},
+	}}
+	start, end, _ := strings.Cut(string(*body.Timespan), "/")
+	t0, _ := time.Parse(time.RFC3339Nano, start)
+	t1, _ := time.Parse(time.RFC3339Nano, end)
+	for table, rows := range f.rows {
+		if !strings.HasPrefix(*body.Query, table) {
+			continue
+		}
+		for _, row := range rows {
+			if ts, _ := time.Parse(time.RFC3339Nano, row[0].(string)); !ts.Before(t0) && ts.Before(t1) {
+				tab.Rows = append(tab.Rows, row)
+			}
+		}
+	}
+	return azquery.LogsClientQueryWorkspaceResponse{Results: azquery.Results{Tables: []*azquery.Table{tab}}}, nil
+}
+
+func (f *fakeLogs) QueryBatch(ctx context.Context, body azquery.BatchRequest, options *azquery.LogsClientQueryBatchOptions) (azquery.LogsClientQueryBatchResponse, error) {
+	return azquery.LogsClientQueryBatchResponse{}, errors.New("not implemented")
+}
+
+type fakeWorkspaces struct{}
+
+func (fakeWorkspaces) Get(ctx context.Context, rg, ws string, options *armoperationalinsights.WorkspacesClientGetOptions) (armoperationalinsights.WorkspacesClientGetResponse, error) {
+	var r armoperationalinsights.WorkspacesClientGetResponse
+	r.Properties = &armoperationalinsights.WorkspaceProperties{CustomerID: to.Ptr("00000000-0000-0000-0000-000000000001")}
+	return r, nil
+}
+
+type fakeTables struct{ names []string }
+
+func (f fakeTables) Get(ctx context.Context, rg, ws, table string, options *armoperationalinsights.TablesClientGetOptions) (armoperationalinsights.TablesClientGetResponse, error) {
+	var r armoperationalinsights.TablesClientGetResponse
+	r.Name = to.Ptr(table)
+	return r, nil
+}
+
+func (f fakeTables) NewListByWorkspacePager(rg, ws string, options *armoperationalinsights.TablesClientListByWorkspaceOptions) *runtime.Pager[armoperationalinsights.TablesClientListByWorkspaceResponse] {
+	return runtime.NewPager(runtime.PagingHandler[armoperationalinsights.TablesClientListByWorkspaceResponse]{
+		More: func(armoperationalinsights.TablesClientListByWorkspaceResponse) bool { return false },
+		Fetcher: func(context.Context, *armoperationalinsights.TablesClientListByWorkspaceResponse) (armoperationalinsights.TablesClientListByWorkspaceResponse, error) {
+			var r armoperationalinsights.TablesClientListByWorkspaceResponse
+			for _, n := range f.names {
+				r.Value = append(r.Value, &armoperationalinsights.Table{Name: to.Ptr(n)})
+			}
+			return r, nil
+		},
+	})
+}
+
+func TestGatherWithInjectedClients(t *testing.T) {
+	ts := time.Now().Add(-10 * time.Minute).UTC().Format(time.RFC3339Nano)
+	logs := &fakeLogs{rows: map[string][]azquery.Row{"Heartbeat": {{ts, "node-1"}}}}
+	out := filepath.Join(t.TempDir(), "bundle.tar.gz")
+	config := &Config{
+		WorkspaceID: "/subscriptions/sub/resourceGroups/rg/providers/Microsoft.OperationalInsights/workspaces/ws",
+		Timespan:    "PT1H",
+		OutputFile:  out,
+		AllTables:   true,
+		Quiet:       true,
+	}
+	g, err := NewGathererWithEnvironment(context.Background(), config, Environment{
+		Credential:       noCredential{},
+		LogsClient:       logs,
+		TablesClient:     fakeTables{names: []string{"Heartbeat"}},
+		WorkspacesClient: fakeWorkspaces{},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := g.Run()
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if res.Status != "ok" || res.Archive != out || res.Rows != 1 || len(res.Tables) != 1 || res.Tables[0].Name != "Heartbeat" || res.Tables[0].DurationSeconds <= 0 {
+		t.Errorf("unexpected result %+v", res)
+	}
+	if len(logs.queries) == 0 {
+		t.Fatal("expected queries through the injected logs client")
+	}
+	files := readArchive(t, out)
+	if got := files["tables/Heartbeat/summary.json"]; !strings.Contains(got, `"rows": 1`) {
+		t.Errorf("unexpected Heartbeat summary: %s", got)
+	}
+	if !strings.Contains(files["index.json"], `"Heartbeat"`) {
+		t.Errorf("expected the listed table in index.json: %s", files["index.json"])
+	}
+}
+
+// hangingLogs blocks every query until its context is done, as a query held
+// up on the server does.
+type hangingLogs struct{ fakeLogs }
+
+func (h *hangingLogs) QueryWorkspace(ctx context.Context, workspaceID string, body azquery.Body, options *azquery.LogsClientQueryWorkspaceOptions) (azquery.LogsClientQueryWorkspaceResponse, error) {
+	<-ctx.Done()
+	return azquery.LogsClientQueryWorkspaceResponse{}, ctx.Err()
+}
+
+func TestGatherTimeout(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "bundle.tar.gz")
+	config := &Config{
+		WorkspaceID: "/subscriptions/sub/resourceGroups/rg/providers/Microsoft.OperationalInsights/workspaces/ws",
+		Timespan:    "PT1H",
+		TableFilter: "Heartbeat",
+		OutputFile:  out,
+		Quiet:       true,
+		Timeout:     200 * time.Millisecond,
+	}
+	g, err := NewGatherer(context.Background(), config, WithCredential(noCredential{}), WithLogsClient(&hangingLogs{}),
+		WithWorkspacesClient(fakeWorkspaces{}), WithTablesClient(fakeTables{names: []string{"Heartbeat"}}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	res, err := g.Run()
+	if !errors.Is(err, context.DeadlineExceeded) || !errors.Is(err, ErrPartialData) || !strings.Contains(err.Error(), "timed out after 200ms") {
+		t.Fatalf("expected a timeout, got %v", err)
+	}
+	if d := time.Since(start); d > 10*time.Second {
+		t.Errorf("Run took %s to return after the timeout", d)
+	}
+	if res.Status != "incomplete" || !strings.Contains(res.Error, "timed out") {
+		t.Errorf("unexpected result %+v", res)
+	}
+	if idx := readArchive(t, out)["index.json"]; !strings.Contains(idx, `"incomplete": true`) {
+		t.Errorf("expected an incomplete archive, got index.json %s", idx)
+	}
+	if g.(*Gatherer).interrupted() {
+		t.Error("expected the gatherer's context to be restored after Run")
+	}
+}
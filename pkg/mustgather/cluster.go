@@ -0,0 +1,211 @@
+package mustgather
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/arm"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/runtime"
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+
+	"kubectl-must-gather/pkg/kubeconfig"
+)
+
+// managedClustersAPIVersion is the Microsoft.ContainerService API version
+// used to look up AKS clusters.
+const managedClustersAPIVersion = "2024-02-01"
+
+// managedCluster is the part of an AKS managed cluster resource needed to
+// match it to a kubeconfig context and find its Container Insights workspace.
+type managedCluster struct {
+	ID         string `json:"id"`
+	Name       string `json:"name"`
+	Properties struct {
+		FQDN          string `json:"fqdn"`
+		PrivateFQDN   string `json:"privateFQDN"`
+		PortalFQDN    string `json:"azurePortalFQDN"`
+		AddonProfiles map[string]struct {
+			Enabled bool              `json:"enabled"`
+			Config  map[string]string `json:"config"`
+		} `json:"addonProfiles"`
+	} `json:"properties"`
+}
+
+// workspace returns the Log Analytics workspace the monitoring addon of the
+// cluster sends to, or "" if the addon is not enabled.
+func (c *managedCluster) workspace() string {
+	for name, addon := range c.Properties.AddonProfiles {
+		if !strings.EqualFold(name, "omsagent") || !addon.Enabled {
+			continue
+		}
+		for k, v := range addon.Config {
+			if strings.EqualFold(k, "logAnalyticsWorkspaceResourceID") {
+				return "/" + strings.TrimPrefix(strings.TrimSpace(v), "/")
+			}
+		}
+	}
+	return ""
+}
+
+// serves reports whether host is one of the cluster's API server names.
+func (c *managedCluster) serves(host string) bool {
+	for _, fqdn := range []string{c.Properties.FQDN, c.Properties.PrivateFQDN, c.Properties.PortalFQDN} {
+		if fqdn != "" && strings.EqualFold(fqdn, host) {
+			return true
+		}
+	}
+	return false
+}
+
+// InferWorkspace finds the Log Analytics workspace of the AKS cluster behind
+// a kubeconfig context and returns its ARM resource ID along with the
+// cluster's. A workspace pinned by the context's aks-must-gather extension is
+// used as is. Otherwise the cluster is the one pinned by the extension, or
+// the managed cluster of DefaultSubscription whose API server name matches
+// the context's server, and the workspace is that of its monitoring addon.
+func InferWorkspace(ctx context.Context, env Environment, kc *kubeconfig.Context) (workspaceID, clusterID string, err error) {
+	if kc.WorkspaceResourceID != "" {
+		return kc.WorkspaceResourceID, kc.ClusterResourceID, nil
+	}
+	cli, err := newARMClient(env)
+	if err != nil {
+		return "", "", err
+	}
+	var mc *managedCluster
+	if kc.ClusterResourceID != "" {
+		mc = &managedCluster{}
+		if err := armGet(ctx, cli, kc.ClusterResourceID, mc); err != nil {
+			return "", "", fmt.Errorf("get cluster: %w", err)
+		}
+	} else {
+		host := kc.Host()
+		if host == "" {
+			return "", "", fmt.Errorf("context %q has no API server to match to an AKS cluster", kc.Name)
+		}
+		sub := DefaultSubscription()
+		if sub == "" {
+			return "", "", errors.New("no Azure subscription to search for the cluster; set AZURE_SUBSCRIPTION_ID or run 'az account set'")
+		}
+		if mc, err = findManagedCluster(ctx, cli, sub, host); err != nil {
+			return "", "", err
+		}
+		if mc == nil {
+			return "", "", fmt.Errorf("no AKS cluster in subscription %s serves %s (context %q)", sub, host, kc.Name)
+		}
+	}
+	clusterID = mc.ID
+	if clusterID == "" {
+		clusterID = kc.ClusterResourceID
+	}
+	if workspaceID = mc.workspace(); workspaceID == "" {
+		return "", clusterID, fmt.Errorf("cluster %s has no Container Insights (omsagent) workspace; pass --workspace-id", clusterID)
+	}
+	return workspaceID, clusterID, nil
+}
+
+// findManagedCluster lists the managed clusters of subscription sub and
+// returns the one serving host, or nil if none does.
+func findManagedCluster(ctx context.Context, cli *arm.Client, sub, host string) (*managedCluster, error) {
+	next := "/subscriptions/" + sub + "/providers/Microsoft.ContainerService/managedClusters"
+	for next != "" {
+		var page struct {
+			Value    []*managedCluster `json:"value"`
+			NextLink string            `json:"nextLink"`
+		}
+		if err := armGet(ctx, cli, next, &page); err != nil {
+			return nil, fmt.Errorf("list clusters: %w", err)
+		}
+		for _, mc := range page.Value {
+			if mc.serves(host) {
+				return mc, nil
+			}
+		}
+		next = page.NextLink
+	}
+	return nil, nil
+}
+
+func newARMClient(env Environment) (*arm.Client, error) {
+	cred := env.Credential
+	if cred == nil {
+		c, err := azidentity.NewDefaultAzureCredential(nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to init credential: %w", err)
+		}
+		cred = c
+	}
+	opts := &arm.ClientOptions{ClientOptions: azcore.ClientOptions{Cloud: env.Cloud}}
+	if env.HTTPClient != nil {
+		opts.Transport = env.HTTPClient
+	}
+	return arm.NewClient("mustgather", "v0.0.0", cred, opts)
+}
+
+// armGet decodes the resource at path, a resource ID or an absolute
+// nextLink, into v.
+func armGet(ctx context.Context, cli *arm.Client, path string, v any) error {
+	url := path
+	if !strings.HasPrefix(path, "https://") && !strings.HasPrefix(path, "http://") {
+		url = strings.TrimSuffix(cli.Endpoint(), "/") + "/" + strings.TrimPrefix(path, "/")
+	}
+	req, err := runtime.NewRequest(ctx, http.MethodGet, url)
+	if err != nil {
+		return err
+	}
+	if q := req.Raw().URL.Query(); q.Get("api-version") == "" {
+		q.Set("api-version", managedClustersAPIVersion)
+		req.Raw().URL.RawQuery = q.Encode()
+	}
+	resp, err := cli.Pipeline().Do(req)
+	if err != nil {
+		return err
+	}
+	if !runtime.HasStatusCode(resp, http.StatusOK) {
+		return runtime.NewResponseError(resp)
+	}
+	return runtime.UnmarshalAsJSON(resp, v)
+}
+
+// DefaultSubscription returns $AZURE_SUBSCRIPTION_ID, or else the default
+// subscription of the Azure CLI profile, or "" if neither is set.
+func DefaultSubscription() string {
+	if sub := strings.TrimSpace(os.Getenv("AZURE_SUBSCRIPTION_ID")); sub != "" {
+		return sub
+	}
+	dir := os.Getenv("AZURE_CONFIG_DIR")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return ""
+		}
+		dir = filepath.Join(home, ".azure")
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "azureProfile.json"))
+	if err != nil {
+		return ""
+	}
+	var profile struct {
+		Subscriptions []struct {
+			ID        string `json:"id"`
+			IsDefault bool   `json:"isDefault"`
+		} `json:"subscriptions"`
+	}
+	// The Azure CLI writes the profile with a byte order mark
+	if json.Unmarshal(bytes.TrimPrefix(data, []byte("\xef\xbb\xbf")), &profile) != nil {
+		return ""
+	}
+	for _, s := range profile.Subscriptions {
+		if s.IsDefault {
+			return s.ID
+		}
+	}
+	return ""
+}
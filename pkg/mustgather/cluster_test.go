@@ -0,0 +1,91 @@
+package mustgather
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"kubectl-must-gather/pkg/kubeconfig"
+	"kubectl-must-gather/pkg/testhelpers"
+)
+
+func TestInferWorkspace(t *testing.T) {
+	emu := testhelpers.NewLogAnalyticsEmulator()
+	defer emu.Close()
+	shop := emu.AddManagedCluster(testhelpers.EmulatedCluster{Name: "shop", FQDN: "shop-dns-1a2b.hcp.eastus.azmk8s.io", Monitored: true})
+	bare := emu.AddManagedCluster(testhelpers.EmulatedCluster{Name: "bare", FQDN: "bare-dns-3c4d.hcp.eastus.azmk8s.io"})
+	env := Environment{Credential: emu.Credential(), Cloud: emu.Cloud(), HTTPClient: emu.Client()}
+	t.Setenv("AZURE_SUBSCRIPTION_ID", emu.SubscriptionID)
+
+	tests := []struct {
+		name          string
+		kc            kubeconfig.Context
+		wantWorkspace string
+		wantCluster   string
+		expectErr     string
+	}{
+		{
+			name:          "workspace pinned by the extension",
+			kc:            kubeconfig.Context{Name: "pinned", WorkspaceResourceID: "/subscriptions/s/resourceGroups/rg/providers/Microsoft.OperationalInsights/workspaces/w"},
+			wantWorkspace: "/subscriptions/s/resourceGroups/rg/providers/Microsoft.OperationalInsights/workspaces/w",
+		},
+		{
+			name:          "cluster pinned by the extension",
+			kc:            kubeconfig.Context{Name: "shop", ClusterResourceID: shop},
+			wantWorkspace: emu.WorkspaceID(),
+			wantCluster:   shop,
+		},
+		{
+			name:          "cluster matched by API server name",
+			kc:            kubeconfig.Context{Name: "shop", Server: "https://SHOP-dns-1a2b.hcp.eastus.azmk8s.io:443"},
+			wantWorkspace: emu.WorkspaceID(),
+			wantCluster:   shop,
+		},
+		{
+			name:      "no cluster serves the context",
+			kc:        kubeconfig.Context{Name: "kind", Server: "https://127.0.0.1:6443"},
+			expectErr: "no AKS cluster",
+		},
+		{
+			name:        "cluster without monitoring",
+			kc:          kubeconfig.Context{Name: "bare", Server: "https://bare-dns-3c4d.hcp.eastus.azmk8s.io"},
+			wantCluster: bare,
+			expectErr:   "no Container Insights",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ws, cluster, err := InferWorkspace(context.Background(), env, &tt.kc)
+			if tt.expectErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.expectErr) {
+					t.Errorf("expected error %q, got %v", tt.expectErr, err)
+				}
+			} else if err != nil {
+				t.Fatalf("InferWorkspace failed: %v", err)
+			}
+			if ws != tt.wantWorkspace || cluster != tt.wantCluster {
+				t.Errorf("got workspace %q cluster %q, want %q and %q", ws, cluster, tt.wantWorkspace, tt.wantCluster)
+			}
+		})
+	}
+}
+
+func TestDefaultSubscription(t *testing.T) {
+	dir := t.TempDir()
+	profile := "\xef\xbb\xbf" + `{"subscriptions": [{"id": "sub-a", "isDefault": false}, {"id": "sub-b", "isDefault": true}]}`
+	if err := os.WriteFile(filepath.Join(dir, "azureProfile.json"), []byte(profile), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("AZURE_CONFIG_DIR", dir)
+
+	t.Setenv("AZURE_SUBSCRIPTION_ID", "")
+	if got := DefaultSubscription(); got != "sub-b" {
+		t.Errorf("expected the Azure CLI default subscription, got %q", got)
+	}
+	t.Setenv("AZURE_SUBSCRIPTION_ID", "sub-env")
+	if got := DefaultSubscription(); got != "sub-env" {
+		t.Errorf("expected $AZURE_SUBSCRIPTION_ID to win, got %q", got)
+	}
+}
@@ -0,0 +1,65 @@
+package mustgather
+
+import (
+	"bytes"
+	"encoding/json"
+	"strconv"
+	"time"
+
+	azquery "github.com/Azure/azure-sdk-for-go/sdk/monitor/azquery"
+)
+
+// exportColumn is a column of an exported table, as listed in
+// tables/<Table>/columns.json in the order of the query result.
+type exportColumn struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
+
+// resultColumns returns the names and types of the columns of tab.
+func resultColumns(tab *azquery.Table) []exportColumn {
+	cols := make([]exportColumn, len(tab.Columns))
+	for i, c := range tab.Columns {
+		if c.Name != nil {
+			cols[i].Name = *c.Name
+		}
+		if c.Type != nil {
+			cols[i].Type = string(*c.Type)
+		}
+	}
+	return cols
+}
+
+// typedValue converts a value of a column of type typ, as decoded from the
+// query API, to its NDJSON form: datetimes as RFC 3339 in UTC, dynamic
+// objects and arrays as the JSON they hold rather than a string of it, and
+// bools as booleans. Other values, including numbers, which the API already
+// returns as JSON numbers, are unchanged.
+func typedValue(typ string, v any) any {
+	switch azquery.LogsColumnType(typ) {
+	case azquery.LogsColumnTypeDatetime:
+		if s, ok := v.(string); ok && s != "" {
+			if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+				return t.UTC().Format(time.RFC3339Nano)
+			}
+		}
+	case azquery.LogsColumnTypeDynamic:
+		if s, ok := v.(string); ok {
+			// Kept as raw JSON, so key order survives
+			trimmed := bytes.TrimSpace([]byte(s))
+			if len(trimmed) > 0 && (trimmed[0] == '{' || trimmed[0] == '[') && json.Valid(trimmed) {
+				return json.RawMessage(trimmed)
+			}
+		}
+	case azquery.LogsColumnTypeBool:
+		switch b := v.(type) {
+		case string:
+			if parsed, err := strconv.ParseBool(b); err == nil {
+				return parsed
+			}
+		case float64:
+			return b != 0
+		}
+	}
+	return v
+}
@@ -0,0 +1,52 @@
+package mustgather
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestTypedValue(t *testing.T) {
+	tests := []struct {
+		typ  string
+		in   any
+		want string // JSON of the converted value
+	}{
+		{"datetime", "2024-01-01T01:02:03.1234567+01:00", `"2024-01-01T00:02:03.1234567Z"`},
+		{"datetime", "2024-01-01T00:00:00Z", `"2024-01-01T00:00:00Z"`},
+		{"datetime", "", `""`},
+		{"datetime", nil, `null`},
+		{"dynamic", `{"b":1,"a":[true]}`, `{"b":1,"a":[true]}`},
+		{"dynamic", ` ["x"] `, `["x"]`},
+		{"dynamic", "panic: boom", `"panic: boom"`},
+		{"dynamic", "{not json", `"{not json"`},
+		{"bool", "True", `true`},
+		{"bool", float64(0), `false`},
+		{"bool", true, `true`},
+		{"long", float64(1234567890), `1234567890`},
+		{"real", "NaN", `"NaN"`},
+		{"string", `{"a":1}`, `"{\"a\":1}"`},
+		{"timespan", "00:05:00", `"00:05:00"`},
+	}
+	for _, tt := range tests {
+		b, err := json.Marshal(typedValue(tt.typ, tt.in))
+		if err != nil || string(b) != tt.want {
+			t.Errorf("typedValue(%s, %#v) = %s (err %v), want %s", tt.typ, tt.in, b, err, tt.want)
+		}
+	}
+}
+
+func TestDynamicValueForms(t *testing.T) {
+	// Gathered rows hold raw JSON, rows read back from NDJSON decoded values
+	for _, v := range []any{`{"reason":"OOMKilled"}`, json.RawMessage(`{"reason":"OOMKilled"}`), map[string]any{"reason": "OOMKilled"}} {
+		m, ok := dynamicValue(v).(map[string]any)
+		if !ok || m["reason"] != "OOMKilled" {
+			t.Errorf("dynamicValue(%#v) = %#v", v, dynamicValue(v))
+		}
+	}
+	if got := toStr(json.RawMessage(`{"b":1,"a":2}`)); got != `{"b":1,"a":2}` {
+		t.Errorf("toStr of raw JSON = %q", got)
+	}
+	if got := toStr(map[string]any{"a": 2}); got != `{"a":2}` {
+		t.Errorf("toStr of a decoded object = %q", got)
+	}
+}
@@ -1,11 +1,22 @@
 package mustgather
 
-import "time"
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
 
 type Config struct {
 	WorkspaceID         string
+	ClusterID           string
 	Timespan            string
+	Since               time.Time
+	SinceLastRun        bool
+	StateFile           string
 	OutputFile          string
+	OutputFormat        string
 	TableFilter         string
 	Profiles            string
 	AllTables           bool
@@ -13,33 +24,97 @@ type Config struct {
 	StitchIncludeEvents bool
 	AIMode              bool
 	AIQuery             string
+	AIProvider          string
+	AIInteractive       bool
+	AIArchive           bool
+	AIHistoryDir        string
+	AIKQL               string
+	AIYes               bool
+	AISteps             int
+	AISummary           bool
+	AIMaxPromptTokens   int
+	AISampleRows        int
+	AIMaxTokens         int64
+	AIMaxCost           float64
+	AITokenPrices       *TokenPrices
+	Report              string
+	EventObjects        string
+	CacheDir            string
+	FreshnessCheck      bool
+	ClampToRetention    bool
+	Timezone            string
+	Strict              bool
+	Redaction           *Redactor
+	Anonymizer          *Anonymizer
+	Metrics             *Metrics
+	EncryptTo           []string
+	RestrictedOutput    string
+	RestrictedEncryptTo []string
+	SignKey             string
+	UploadTo            string
+	Keep                int
+	KeepDays            int
+	NotifyWebhook       string
+	InCluster           bool
+	Namespaces          []string
+	ScopeToRBAC         bool
+	Kubeconfig          string
+	KubeContext         string
+	Flags               map[string]string
+	LogVolume           bool
+	Snippets            string
+	MaxRetries          int
+	Parallelism         int
+	QueryRate           float64
+	BatchQueries        bool
+	MaxTotalRows        int64
+	MaxTotalBytes       int64
+	MaxMemory           int64
+	Timeout             time.Duration
+	ProgressFormat      string
+	Quiet               bool
+	OutputJSON          bool
 }
 
-type ProfileMap map[string][]string
+// withTimeout bounds ctx by Timeout when it is set. The cause of the
+// returned context says when the gather timed out rather than was cancelled.
+func (c *Config) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if c.Timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeoutCause(ctx, c.Timeout, fmt.Errorf("gather timed out after %s: %w", c.Timeout, context.DeadlineExceeded))
+}
 
-func GetDefaultProfiles() ProfileMap {
-	profileMap := ProfileMap{
-		"podLogs":   {"ContainerLogV2", "ContainerLog", "KubeEvents", "KubeMonAgentEvents", "Syslog"},
-		"inventory": {"KubePodInventory", "KubeNodeInventory", "KubeServices", "KubePVInventory", "ContainerInventory", "ContainerImageInventory", "ContainerNodeInventory", "KubeHealth"},
-		"metrics":   {"InsightsMetrics", "Perf", "Heartbeat"},
-		"audit":     {"AKSControlPlane", "AKSAudit", "AKSAuditAdmin"},
+// logOutput is where a gather reports progress and warnings for people:
+// stderr, or nowhere when Quiet.
+func (c *Config) logOutput() io.Writer {
+	if c.Quiet {
+		return io.Discard
 	}
+	return os.Stderr
+}
 
-	// Alias: aks-debug = podLogs + inventory + metrics
-	combined := make([]string, 0, 32)
-	seen := map[string]struct{}{}
-	for _, k := range []string{"podLogs", "inventory", "metrics"} {
-		for _, t := range profileMap[k] {
-			if _, ok := seen[t]; ok {
-				continue
-			}
-			seen[t] = struct{}{}
-			combined = append(combined, t)
-		}
+// location returns the Timezone zone, or nil for UTC or a zone that does not
+// load; Run rejects the latter up front.
+func (c *Config) location() *time.Location {
+	if c.Timezone == "" {
+		return nil
 	}
-	profileMap["aks-debug"] = combined
+	loc, err := time.LoadLocation(c.Timezone)
+	if err != nil {
+		return nil
+	}
+	return loc
+}
 
-	return profileMap
+// llmLimits collects the AI budget settings.
+func (c *Config) llmLimits() LLMLimits {
+	return LLMLimits{
+		MaxPromptTokens: c.AIMaxPromptTokens,
+		MaxTokens:       c.AIMaxTokens,
+		MaxCost:         c.AIMaxCost,
+		Prices:          c.AITokenPrices,
+	}
 }
 
 func (c *Config) GenerateDefaultOutputName() string {
@@ -257,3 +257,31 @@ func TestTimestampGeneration(t *testing.T) {
 		t.Errorf("timestamp date %v should be from today %v", timestamp, now)
 	}
 }
+
+func TestResolveSnippets(t *testing.T) {
+	lib := GetDefaultSnippets()
+	for _, name := range []string{"restart-counts", "error-rates", "top-cpu-pods"} {
+		if _, ok := lib[name]; !ok {
+			t.Errorf("expected snippet %q in library", name)
+		}
+	}
+	for name, sn := range lib {
+		if sn.Name != name || sn.Description == "" || strings.TrimSpace(sn.Query) == "" {
+			t.Errorf("snippet %q is incomplete: %+v", name, sn)
+		}
+	}
+
+	if got, _ := resolveSnippets("all"); len(got) != len(lib) {
+		t.Errorf("expected 'all' to select %d snippets, got %d", len(lib), len(got))
+	}
+	got, unknown := resolveSnippets("error-rates, restart-counts,error-rates,unknown")
+	if len(got) != 2 || got[0].Name != "error-rates" || got[1].Name != "restart-counts" {
+		t.Errorf("unexpected selection: %+v", got)
+	}
+	if len(unknown) != 1 || unknown[0] != "unknown" {
+		t.Errorf("expected the unknown name back, got %v", unknown)
+	}
+	if got, _ := resolveSnippets(""); len(got) != 0 {
+		t.Errorf("expected no snippets for empty selection, got %d", len(got))
+	}
+}
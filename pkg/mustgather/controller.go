@@ -0,0 +1,352 @@
+package mustgather
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// The MustGather custom resource the controller serves, defined by
+// deploy/mustgather-crd.yaml.
+const (
+	mustGatherGroup   = "aks-must-gather.io"
+	mustGatherVersion = "v1alpha1"
+	mustGatherKind    = "MustGather"
+)
+
+// MustGather phases. A new resource has no phase.
+const (
+	phaseRunning   = "Running"
+	phaseSucceeded = "Succeeded"
+	phaseFailed    = "Failed"
+)
+
+// controllerWatchTimeout is how long a watch stays open before the
+// controller lists the resources again.
+const controllerWatchTimeout = 10 * time.Minute
+
+// controllerRetryDelay is the wait before listing again after the API server
+// failed a list or watch.
+var controllerRetryDelay = 5 * time.Second
+
+// mustGather is a MustGather resource: a gather requested by its spec, whose
+// outcome the controller reports in its status.
+type mustGather struct {
+	Metadata struct {
+		Name              string `json:"name"`
+		Namespace         string `json:"namespace"`
+		UID               string `json:"uid"`
+		ResourceVersion   string `json:"resourceVersion"`
+		Generation        int64  `json:"generation"`
+		DeletionTimestamp string `json:"deletionTimestamp,omitempty"`
+	} `json:"metadata"`
+	Spec   mustGatherSpec   `json:"spec"`
+	Status mustGatherStatus `json:"status"`
+}
+
+type mustGatherSpec struct {
+	WorkspaceID string                `json:"workspaceID"`
+	Timespan    string                `json:"timespan,omitempty"`
+	Profiles    []string              `json:"profiles,omitempty"`
+	Tables      []string              `json:"tables,omitempty"`
+	Destination mustGatherDestination `json:"destination,omitempty"`
+}
+
+// mustGatherDestination is where the archive goes besides the controller's
+// output directory.
+type mustGatherDestination struct {
+	BlobContainer string `json:"blobContainer,omitempty"`
+}
+
+type mustGatherStatus struct {
+	Phase              string                `json:"phase,omitempty"`
+	ObservedGeneration int64                 `json:"observedGeneration,omitempty"`
+	StartTime          string                `json:"startTime,omitempty"`
+	CompletionTime     string                `json:"completionTime,omitempty"`
+	WindowStart        string                `json:"windowStart,omitempty"`
+	WindowEnd          string                `json:"windowEnd,omitempty"`
+	Archive            string                `json:"archive,omitempty"`
+	Uploaded           []string              `json:"uploaded,omitempty"`
+	Rows               int                   `json:"rows,omitempty"`
+	Conditions         []mustGatherCondition `json:"conditions,omitempty"`
+}
+
+type mustGatherCondition struct {
+	Type               string `json:"type"`
+	Status             string `json:"status"`
+	Reason             string `json:"reason"`
+	Message            string `json:"message"`
+	LastTransitionTime string `json:"lastTransitionTime"`
+}
+
+// ControllerOptions configures RunController.
+type ControllerOptions struct {
+	// Namespace limits the controller to the MustGathers of one namespace;
+	// empty serves all namespaces.
+	Namespace string
+	// OutputDir receives the archives, in a directory per namespace.
+	OutputDir string
+	// Config holds the settings every gather shares, such as redaction and
+	// budgets; the workspace, timespan, tables and destination come from
+	// each MustGather.
+	Config *Config
+	// Environment overrides the Azure endpoints of every gather, as with
+	// NewGathererWithEnvironment.
+	Environment *Environment
+}
+
+// controller runs the gathers MustGather resources request, one at a time.
+type controller struct {
+	api  *kubeAPI
+	opts ControllerOptions
+	log  io.Writer
+	// handled are the UIDs of the resources this controller gathered, whose
+	// older versions watches still deliver.
+	handled map[string]bool
+	now     func() time.Time
+}
+
+// RunController serves MustGather resources until ctx is cancelled: it lists
+// and watches them as the pod's service account and runs a gather for each
+// new one, reporting its progress and outcome in the status and as Events on
+// the resource. Gathers run one at a time. A resource left Running by a
+// previous controller is marked Failed, as its gather did not finish.
+func RunController(ctx context.Context, opts ControllerOptions) error {
+	if opts.Config == nil {
+		opts.Config = &Config{}
+	}
+	if opts.Config.AIMode {
+		return errors.New("the controller runs regular gathers, not AI mode")
+	}
+	if opts.OutputDir == "" {
+		opts.OutputDir = "."
+	}
+	api, err := inClusterAPI()
+	if err != nil {
+		return fmt.Errorf("controller: %w", err)
+	}
+	c := &controller{api: api, opts: opts, log: opts.Config.logOutput(), handled: map[string]bool{}, now: time.Now}
+	scope := "all namespaces"
+	if opts.Namespace != "" {
+		scope = "namespace " + opts.Namespace
+	}
+	fmt.Fprintf(c.log, "Serving MustGather resources in %s\n", scope)
+	for {
+		rv, err := c.sync(ctx)
+		if err == nil {
+			err = c.watch(ctx, rv)
+		}
+		if ctx.Err() != nil {
+			return nil
+		}
+		if err != nil {
+			fmt.Fprintf(c.log, "  warn: %v; listing again in %s\n", err, controllerRetryDelay)
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-time.After(controllerRetryDelay):
+			}
+		}
+	}
+}
+
+// resourcePath is the API path of the MustGathers of namespace, or of all
+// namespaces when it is empty.
+func resourcePath(namespace string) string {
+	p := "/apis/" + mustGatherGroup + "/" + mustGatherVersion
+	if namespace != "" {
+		p += "/namespaces/" + namespace
+	}
+	return p + "/mustgathers"
+}
+
+// sync reconciles every MustGather and returns the resource version of the
+// list to watch from.
+func (c *controller) sync(ctx context.Context) (string, error) {
+	var list struct {
+		Metadata struct {
+			ResourceVersion string `json:"resourceVersion"`
+		} `json:"metadata"`
+		Items []mustGather `json:"items"`
+	}
+	if err := c.api.do(ctx, http.MethodGet, resourcePath(c.opts.Namespace), "", nil, &list); err != nil {
+		return "", fmt.Errorf("list MustGathers: %w", err)
+	}
+	for i := range list.Items {
+		if ctx.Err() != nil {
+			return "", ctx.Err()
+		}
+		c.reconcile(ctx, &list.Items[i])
+	}
+	return list.Metadata.ResourceVersion, nil
+}
+
+// watch reconciles the MustGathers added or changed after resource version
+// rv until the watch ends. An expired resource version ends it without error,
+// so the controller lists again.
+func (c *controller) watch(ctx context.Context, rv string) error {
+	q := url.Values{
+		"watch":           {"1"},
+		"resourceVersion": {rv},
+		"timeoutSeconds":  {fmt.Sprint(int(controllerWatchTimeout.Seconds()))},
+	}
+	body, err := c.api.open(ctx, http.MethodGet, resourcePath(c.opts.Namespace)+"?"+q.Encode(), "", nil)
+	if err != nil {
+		return fmt.Errorf("watch MustGathers: %w", err)
+	}
+	defer body.Close()
+	dec := json.NewDecoder(body)
+	for {
+		var ev struct {
+			Type   string          `json:"type"`
+			Object json.RawMessage `json:"object"`
+		}
+		if err := dec.Decode(&ev); err != nil {
+			if ctx.Err() != nil || errors.Is(err, io.EOF) {
+				return nil
+			}
+			return fmt.Errorf("watch MustGathers: %w", err)
+		}
+		switch ev.Type {
+		case "ADDED", "MODIFIED":
+			var mg mustGather
+			if err := json.Unmarshal(ev.Object, &mg); err != nil {
+				return fmt.Errorf("decode MustGather: %w", err)
+			}
+			c.reconcile(ctx, &mg)
+		case "ERROR":
+			return nil
+		}
+	}
+}
+
+// reconcile runs the gather of a new MustGather, and fails one left Running
+// by a previous controller.
+func (c *controller) reconcile(ctx context.Context, mg *mustGather) {
+	if mg.Metadata.DeletionTimestamp != "" || c.handled[mg.Metadata.UID] {
+		return
+	}
+	switch mg.Status.Phase {
+	case phaseSucceeded, phaseFailed:
+		return
+	case phaseRunning:
+		c.handled[mg.Metadata.UID] = true
+		c.finish(mg, mustGatherStatus{}, "Warning", "ControllerRestarted", "The controller stopped during the gather; create a new MustGather to gather again")
+		return
+	}
+	c.handled[mg.Metadata.UID] = true
+	c.gather(ctx, mg)
+}
+
+// gather runs the gather mg requests, with the status Running while it does.
+func (c *controller) gather(ctx context.Context, mg *mustGather) {
+	ref := mg.Metadata.Namespace + "/" + mg.Metadata.Name
+	if mg.Spec.WorkspaceID == "" {
+		c.finish(mg, mustGatherStatus{}, "Warning", "InvalidSpec", "spec.workspaceID is required")
+		return
+	}
+	started := c.now().UTC()
+	status := mustGatherStatus{
+		Phase:              phaseRunning,
+		ObservedGeneration: mg.Metadata.Generation,
+		StartTime:          started.Format(time.RFC3339),
+		Conditions:         []mustGatherCondition{c.condition("Complete", "False", "Gathering", "Gather started")},
+	}
+	if err := c.patchStatus(ctx, mg, status); err != nil {
+		fmt.Fprintf(c.log, "  warn: %s: %v\n", ref, err)
+		// Without the Running status a later list retries it
+		delete(c.handled, mg.Metadata.UID)
+		return
+	}
+	fmt.Fprintf(c.log, "Gathering %s\n", ref)
+
+	cfg := *c.opts.Config
+	cfg.WorkspaceID = mg.Spec.WorkspaceID
+	cfg.Timespan = mg.Spec.Timespan
+	if cfg.Timespan == "" {
+		cfg.Timespan = "PT2H"
+	}
+	cfg.Profiles = strings.Join(mg.Spec.Profiles, ",")
+	cfg.TableFilter = strings.Join(mg.Spec.Tables, ",")
+	cfg.UploadTo = mg.Spec.Destination.BlobContainer
+	cfg.InCluster = false
+	dir := filepath.Join(c.opts.OutputDir, mg.Metadata.Namespace)
+	cfg.OutputFile = rotatedName(filepath.Join(dir, mg.Metadata.Name+".tar.gz"), started)
+	if cfg.RestrictedOutput != "" {
+		cfg.RestrictedOutput = rotatedName(filepath.Join(dir, mg.Metadata.Name+"-restricted.tar.gz"), started)
+	}
+
+	var g *Gatherer
+	var r *GatherResult
+	err := os.MkdirAll(dir, 0o755)
+	if err == nil {
+		g, r, err = runGather(ctx, &cfg, c.opts.Environment)
+	}
+	if g == nil {
+		c.finish(mg, status, "Warning", "GatherFailed", "Gather failed: "+err.Error())
+		return
+	}
+	eventType, reason, message := g.completionEvent(err)
+	status.Archive, status.Uploaded, status.Rows = r.Archive, r.Uploaded, int(r.Rows)
+	if !g.start.IsZero() {
+		status.WindowStart, status.WindowEnd = g.start.Format(time.RFC3339), g.end.Format(time.RFC3339)
+	}
+	c.finish(mg, status, eventType, reason, message)
+}
+
+// finish records the outcome of the gather of mg in its status, Succeeded
+// for a Normal outcome and Failed otherwise, and posts it as an Event.
+// It runs with a context of its own so an interrupted gather is still
+// reported.
+func (c *controller) finish(mg *mustGather, status mustGatherStatus, eventType, reason, message string) {
+	ctx, cancel := context.WithTimeout(context.Background(), kubeRequestTimeout)
+	defer cancel()
+	ref := mg.Metadata.Namespace + "/" + mg.Metadata.Name
+	status.ObservedGeneration = mg.Metadata.Generation
+	status.CompletionTime = c.now().UTC().Format(time.RFC3339)
+	if eventType == "Normal" {
+		status.Phase = phaseSucceeded
+		status.Conditions = []mustGatherCondition{c.condition("Complete", "True", reason, message)}
+	} else {
+		status.Phase = phaseFailed
+		status.Conditions = []mustGatherCondition{c.condition("Failed", "True", reason, message)}
+	}
+	if len(message) > maxEventMessage {
+		status.Conditions[0].Message = message[:maxEventMessage-3] + "..."
+	}
+	fmt.Fprintf(c.log, "%s: %s: %s\n", ref, status.Phase, message)
+	if err := c.patchStatus(ctx, mg, status); err != nil {
+		fmt.Fprintf(c.log, "  warn: %s: %v\n", ref, err)
+	}
+	object := map[string]any{
+		"apiVersion": mustGatherGroup + "/" + mustGatherVersion,
+		"kind":       mustGatherKind,
+		"name":       mg.Metadata.Name,
+		"namespace":  mg.Metadata.Namespace,
+		"uid":        mg.Metadata.UID,
+	}
+	if err := c.api.postEvent(ctx, object, eventType, reason, message); err != nil {
+		fmt.Fprintf(c.log, "  warn: %s: %v\n", ref, err)
+	}
+}
+
+func (c *controller) condition(typ, status, reason, message string) mustGatherCondition {
+	return mustGatherCondition{Type: typ, Status: status, Reason: reason, Message: message, LastTransitionTime: c.now().UTC().Format(time.RFC3339)}
+}
+
+// patchStatus replaces the status of mg through its status subresource.
+func (c *controller) patchStatus(ctx context.Context, mg *mustGather, status mustGatherStatus) error {
+	p := resourcePath(mg.Metadata.Namespace) + "/" + mg.Metadata.Name + "/status"
+	if err := c.api.do(ctx, http.MethodPatch, p, "application/merge-patch+json", map[string]any{"status": status}, nil); err != nil {
+		return fmt.Errorf("update status: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,212 @@
+package mustgather
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeMustGatherAPI serves MustGather resources like an API server: the list
+// returns items, a watch streams watchEvents and then stays open until the
+// request ends, and status patches and created Events are recorded.
+type fakeMustGatherAPI struct {
+	mu          sync.Mutex
+	items       []map[string]any
+	watchEvents []map[string]any
+	patches     map[string][]mustGatherStatus
+	created     []map[string]any
+}
+
+func newFakeMustGatherAPI(t *testing.T, items ...map[string]any) *fakeMustGatherAPI {
+	t.Helper()
+	f := &fakeMustGatherAPI{items: items, patches: map[string][]mustGatherStatus{}}
+	srv := httptest.NewTLSServer(f)
+	t.Cleanup(srv.Close)
+	fakeServiceAccount(t, srv, "diag")
+	return f
+}
+
+func (f *fakeMustGatherAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	const base = "/apis/" + mustGatherGroup + "/" + mustGatherVersion
+	list := r.Method == http.MethodGet && (r.URL.Path == base+"/mustgathers" || r.URL.Path == base+"/namespaces/diag/mustgathers")
+	switch {
+	case list && r.URL.Query().Get("watch") == "1":
+		f.mu.Lock()
+		evs := f.watchEvents
+		f.watchEvents = nil
+		f.mu.Unlock()
+		enc := json.NewEncoder(w)
+		for _, ev := range evs {
+			_ = enc.Encode(ev)
+		}
+		w.(http.Flusher).Flush()
+		if len(evs) == 0 {
+			<-r.Context().Done()
+		}
+	case list:
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		_ = json.NewEncoder(w).Encode(map[string]any{"metadata": map[string]any{"resourceVersion": "100"}, "items": f.items})
+	case r.Method == http.MethodPatch && strings.HasSuffix(r.URL.Path, "/status"):
+		if ct := r.Header.Get("Content-Type"); ct != "application/merge-patch+json" {
+			http.Error(w, `{"message":"unsupported content type `+ct+`"}`, http.StatusUnsupportedMediaType)
+			return
+		}
+		var patch struct {
+			Status mustGatherStatus `json:"status"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&patch)
+		ref := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, base+"/namespaces/"), "/status")
+		ref = strings.Replace(ref, "/mustgathers/", "/", 1)
+		f.mu.Lock()
+		f.patches[ref] = append(f.patches[ref], patch.Status)
+		f.mu.Unlock()
+		_, _ = io.WriteString(w, "{}")
+	case r.Method == http.MethodPost && strings.HasSuffix(r.URL.Path, "/events"):
+		var ev map[string]any
+		_ = json.NewDecoder(r.Body).Decode(&ev)
+		f.mu.Lock()
+		f.created = append(f.created, ev)
+		f.mu.Unlock()
+		w.WriteHeader(http.StatusCreated)
+	default:
+		http.Error(w, `{"message":"unexpected request"}`, http.StatusNotFound)
+	}
+}
+
+// events returns the Events created.
+func (f *fakeMustGatherAPI) events() []map[string]any {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]map[string]any(nil), f.created...)
+}
+
+// statuses returns the status patches of the MustGather namespace/name.
+func (f *fakeMustGatherAPI) statuses(ref string) []mustGatherStatus {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]mustGatherStatus(nil), f.patches[ref]...)
+}
+
+func testMustGather(name, uid, phase string, spec map[string]any) map[string]any {
+	return map[string]any{
+		"apiVersion": mustGatherGroup + "/" + mustGatherVersion,
+		"kind":       mustGatherKind,
+		"metadata":   map[string]any{"name": name, "namespace": "diag", "uid": uid, "generation": 2},
+		"spec":       spec,
+		"status":     map[string]any{"phase": phase},
+	}
+}
+
+func testController(t *testing.T) *controller {
+	t.Helper()
+	api, err := inClusterAPI()
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	return &controller{api: api, opts: ControllerOptions{Config: &Config{}, OutputDir: t.TempDir()}, log: io.Discard, handled: map[string]bool{}, now: func() time.Time { return now }}
+}
+
+func TestControllerSync(t *testing.T) {
+	deleting := testMustGather("deleting", "u4", "", map[string]any{})
+	deleting["metadata"].(map[string]any)["deletionTimestamp"] = "2026-01-02T00:00:00Z"
+	api := newFakeMustGatherAPI(t,
+		testMustGather("invalid", "u1", "", map[string]any{"tables": []string{"Heartbeat"}}),
+		testMustGather("orphaned", "u2", phaseRunning, map[string]any{"workspaceID": "ws"}),
+		testMustGather("done", "u3", phaseSucceeded, map[string]any{"workspaceID": "ws"}),
+		deleting,
+	)
+	c := testController(t)
+	rv, err := c.sync(context.Background())
+	if err != nil || rv != "100" {
+		t.Fatalf("sync = %q, %v", rv, err)
+	}
+
+	tests := []struct {
+		ref, condition, reason string
+	}{
+		{"diag/invalid", "Failed", "InvalidSpec"},
+		{"diag/orphaned", "Failed", "ControllerRestarted"},
+	}
+	for _, tt := range tests {
+		got := api.statuses(tt.ref)
+		if len(got) != 1 {
+			t.Errorf("%s: patches = %+v", tt.ref, got)
+			continue
+		}
+		st := got[0]
+		if st.Phase != phaseFailed || st.ObservedGeneration != 2 || st.CompletionTime != "2026-01-02T03:04:05Z" ||
+			len(st.Conditions) != 1 || st.Conditions[0].Type != tt.condition || st.Conditions[0].Status != "True" || st.Conditions[0].Reason != tt.reason {
+			t.Errorf("%s: status = %+v", tt.ref, st)
+		}
+	}
+	for _, ref := range []string{"diag/done", "diag/deleting"} {
+		if got := api.statuses(ref); len(got) != 0 {
+			t.Errorf("%s should not be updated: %+v", ref, got)
+		}
+	}
+	events := api.events()
+	if len(events) != 2 {
+		t.Fatalf("events = %v", events)
+	}
+	obj, _ := events[0]["involvedObject"].(map[string]any)
+	if events[0]["type"] != "Warning" || obj["kind"] != mustGatherKind || obj["name"] != "invalid" || obj["uid"] != "u1" || obj["apiVersion"] != mustGatherGroup+"/"+mustGatherVersion {
+		t.Errorf("unexpected event %v", events[0])
+	}
+
+	// Resources already handled are left alone, e.g. when a watch delivers
+	// their older versions
+	if _, err := c.sync(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if got := api.statuses("diag/invalid"); len(got) != 1 {
+		t.Errorf("a handled resource was updated again: %+v", got)
+	}
+}
+
+func TestControllerWatch(t *testing.T) {
+	api := newFakeMustGatherAPI(t)
+	api.watchEvents = []map[string]any{
+		{"type": "ADDED", "object": testMustGather("new", "u1", "", map[string]any{})},
+		{"type": "ERROR", "object": map[string]any{"kind": "Status", "code": 410, "reason": "Expired"}},
+		{"type": "ADDED", "object": testMustGather("after-error", "u2", "", map[string]any{})},
+	}
+	c := testController(t)
+	if err := c.watch(context.Background(), "100"); err != nil {
+		t.Fatalf("watch = %v", err)
+	}
+	if got := api.statuses("diag/new"); len(got) != 1 || got[0].Conditions[0].Reason != "InvalidSpec" {
+		t.Errorf("statuses = %+v", got)
+	}
+	if got := api.statuses("diag/after-error"); len(got) != 0 {
+		t.Errorf("an ERROR event should end the watch, got %+v", got)
+	}
+
+	// A watch without events ends with its context
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if err := c.watch(ctx, "100"); err != nil {
+		t.Errorf("cancelled watch = %v", err)
+	}
+
+	c.opts.Namespace = "other"
+	if err := c.watch(context.Background(), "100"); err == nil || !strings.Contains(err.Error(), "unexpected request") {
+		t.Errorf("expected the API server message, got %v", err)
+	}
+}
+
+func TestResourcePath(t *testing.T) {
+	if got := resourcePath(""); got != "/apis/aks-must-gather.io/v1alpha1/mustgathers" {
+		t.Errorf("resourcePath() = %s", got)
+	}
+	if got := resourcePath("diag"); got != "/apis/aks-must-gather.io/v1alpha1/namespaces/diag/mustgathers" {
+		t.Errorf("resourcePath(diag) = %s", got)
+	}
+}
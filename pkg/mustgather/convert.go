@@ -0,0 +1,355 @@
+package mustgather
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"kubectl-must-gather/pkg/bundle"
+)
+
+// Formats Convert rewrites table data to.
+const (
+	ConvertCSV     = "csv"
+	ConvertParquet = "parquet"
+	ConvertSQLite  = "sqlite"
+)
+
+// convertTools are the tools Convert needs on PATH per format.
+var convertTools = map[string]string{
+	ConvertParquet: "duckdb",
+	ConvertSQLite:  "sqlite3",
+}
+
+// SQLiteDatabaseName is the database Convert writes for ConvertSQLite.
+const SQLiteDatabaseName = "tables.sqlite"
+
+// ConvertOptions selects the format and destination of Convert.
+type ConvertOptions struct {
+	// Format is ConvertCSV, ConvertParquet or ConvertSQLite.
+	Format string
+	// OutDir receives one <table>.csv or <table>.parquet per table, or
+	// SQLiteDatabaseName with one SQL table per table.
+	OutDir string
+}
+
+// ConvertStats counts what Convert wrote.
+type ConvertStats struct {
+	Tables int
+	Rows   int64
+	// Files are the files written, relative to OutDir.
+	Files []string
+}
+
+// Convert rewrites the table data of the bundle at src (archive or
+// extracted directory) from NDJSON parts to opts.Format in opts.OutDir.
+// Columns keep the order and types of the query result recorded in
+// columns.json: datetimes stay RFC 3339 in UTC and dynamic values JSON
+// text. Parquet is written with duckdb and SQLite with sqlite3, which must
+// be on PATH.
+func Convert(src string, opts ConvertOptions) (*ConvertStats, error) {
+	if opts.OutDir == "" {
+		return nil, errors.New("no output directory")
+	}
+	switch opts.Format {
+	case ConvertCSV, ConvertParquet, ConvertSQLite:
+	default:
+		return nil, fmt.Errorf("invalid format %q: expected %s, %s or %s", opts.Format, ConvertCSV, ConvertParquet, ConvertSQLite)
+	}
+	if tool := convertTools[opts.Format]; tool != "" {
+		if _, err := exec.LookPath(tool); err != nil {
+			return nil, fmt.Errorf("converting to %s needs %s on PATH: %w", opts.Format, tool, err)
+		}
+	}
+
+	b, err := bundle.Open(src)
+	if err != nil {
+		return nil, fmt.Errorf("open bundle: %w", err)
+	}
+	defer b.Close()
+	tables, err := b.Tables()
+	if err != nil {
+		return nil, fmt.Errorf("list tables: %w", err)
+	}
+	if len(tables) == 0 {
+		return nil, fmt.Errorf("no exported tables in %s", src)
+	}
+	if err := os.MkdirAll(opts.OutDir, 0755); err != nil {
+		return nil, err
+	}
+
+	stats := &ConvertStats{Tables: len(tables)}
+	if opts.Format == ConvertSQLite {
+		if err := convertSQLite(b, tables, filepath.Join(opts.OutDir, SQLiteDatabaseName), stats); err != nil {
+			return nil, err
+		}
+		stats.Files = append(stats.Files, SQLiteDatabaseName)
+		return stats, nil
+	}
+	for _, t := range tables {
+		cols, err := convertColumns(b, t)
+		if err != nil {
+			return nil, err
+		}
+		name := path.Base(t.Dir) + "." + opts.Format
+		dst := filepath.Join(opts.OutDir, name)
+		csvPath := dst
+		if opts.Format == ConvertParquet {
+			// duckdb reads the CSV with the column types given
+			csvPath = filepath.Join(opts.OutDir, "."+name+".csv")
+		}
+		rows, err := writeConvertCSV(b, t, cols, csvPath)
+		if err == nil && opts.Format == ConvertParquet {
+			err = csvToParquet(csvPath, dst, cols)
+			os.Remove(csvPath)
+		}
+		if err != nil {
+			os.Remove(dst)
+			return nil, fmt.Errorf("convert %s: %w", t.Name, err)
+		}
+		stats.Rows += rows
+		stats.Files = append(stats.Files, name)
+	}
+	return stats, nil
+}
+
+// convertColumns returns the columns of t from its columns.json or, for
+// bundles without one, the keys of its rows with TimeGenerated first.
+func convertColumns(b *bundle.Bundle, t bundle.Table) ([]exportColumn, error) {
+	if data, err := b.ReadFile(t.Dir + "/columns.json"); err == nil {
+		var cols []exportColumn
+		if err := json.Unmarshal(data, &cols); err != nil {
+			return nil, fmt.Errorf("decode %s/columns.json: %w", t.Dir, err)
+		}
+		return cols, nil
+	}
+	seen := map[string]bool{}
+	err := readConvertRows(b, t, func(row map[string]any) error {
+		for k := range row {
+			seen[k] = true
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	names := sortedKeys(seen)
+	sort.SliceStable(names, func(i, j int) bool { return names[i] == "TimeGenerated" && names[j] != "TimeGenerated" })
+	cols := make([]exportColumn, len(names))
+	for i, n := range names {
+		cols[i].Name = n
+	}
+	return cols, nil
+}
+
+// readConvertRows calls fn for every row of t, decoding numbers as
+// json.Number so long values keep their precision.
+func readConvertRows(b *bundle.Bundle, t bundle.Table, fn func(row map[string]any) error) error {
+	for _, part := range t.Parts {
+		f, err := os.Open(filepath.Join(b.Dir, filepath.FromSlash(part)))
+		if err != nil {
+			return err
+		}
+		dec := json.NewDecoder(bufio.NewReader(f))
+		dec.UseNumber()
+		for {
+			var row map[string]any
+			if err := dec.Decode(&row); err == io.EOF {
+				break
+			} else if err != nil {
+				f.Close()
+				return fmt.Errorf("decode %s: %w", part, err)
+			}
+			if err := fn(row); err != nil {
+				f.Close()
+				return err
+			}
+		}
+		f.Close()
+	}
+	return nil
+}
+
+// writeConvertCSV writes the rows of t to dst as CSV with a header line of
+// cols, and returns the number of rows.
+func writeConvertCSV(b *bundle.Bundle, t bundle.Table, cols []exportColumn, dst string) (int64, error) {
+	f, err := os.Create(dst)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	cw := csv.NewWriter(f)
+	cells := make([]string, len(cols))
+	for i, c := range cols {
+		cells[i] = c.Name
+	}
+	if err := cw.Write(cells); err != nil {
+		return 0, err
+	}
+	var rows int64
+	err = readConvertRows(b, t, func(row map[string]any) error {
+		for i, c := range cols {
+			cells[i] = cellText(row[c.Name])
+		}
+		rows++
+		return cw.Write(cells)
+	})
+	if err != nil {
+		return rows, err
+	}
+	cw.Flush()
+	if err := cw.Error(); err != nil {
+		return rows, err
+	}
+	return rows, f.Close()
+}
+
+// duckdbTypes map Log Analytics column types to the duckdb types of the
+// Parquet columns; others are VARCHAR.
+var duckdbTypes = map[string]string{
+	"bool":     "BOOLEAN",
+	"datetime": "TIMESTAMPTZ",
+	"int":      "INTEGER",
+	"long":     "BIGINT",
+	"real":     "DOUBLE",
+	"decimal":  "DOUBLE",
+}
+
+// csvToParquet converts the CSV at src, with a header line of cols, to a
+// Parquet file at dst with duckdb.
+func csvToParquet(src, dst string, cols []exportColumn) error {
+	types := make([]string, len(cols))
+	for i, c := range cols {
+		typ := duckdbTypes[c.Type]
+		if typ == "" {
+			typ = "VARCHAR"
+		}
+		types[i] = sqlString(c.Name) + ": " + sqlString(typ)
+	}
+	stmt := fmt.Sprintf("COPY (SELECT * FROM read_csv(%s, header = true, auto_detect = false, columns = {%s})) TO %s (FORMAT parquet);",
+		sqlString(src), strings.Join(types, ", "), sqlString(dst))
+	return runSQL("duckdb", nil, strings.NewReader(stmt))
+}
+
+// sqliteTypes map Log Analytics column types to SQLite column types; others
+// are TEXT.
+var sqliteTypes = map[string]string{
+	"bool":    "INTEGER",
+	"int":     "INTEGER",
+	"long":    "INTEGER",
+	"real":    "REAL",
+	"decimal": "REAL",
+}
+
+// convertSQLite writes every table of b to a SQLite database at dst, made
+// anew, with sqlite3.
+func convertSQLite(b *bundle.Bundle, tables []bundle.Table, dst string, stats *ConvertStats) error {
+	if err := os.Remove(dst); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	pr, pw := io.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		err := runSQL("sqlite3", []string{"-bail", dst}, pr)
+		// Unblocks the writer when sqlite3 stops early
+		pr.CloseWithError(errors.New("sqlite3 exited"))
+		done <- err
+	}()
+
+	err := func() error {
+		w := bufio.NewWriter(pw)
+		fmt.Fprintln(w, "BEGIN;")
+		for _, t := range tables {
+			cols, err := convertColumns(b, t)
+			if err != nil {
+				return err
+			}
+			if len(cols) == 0 {
+				continue
+			}
+			defs := make([]string, len(cols))
+			for i, c := range cols {
+				typ := sqliteTypes[c.Type]
+				if typ == "" {
+					typ = "TEXT"
+				}
+				defs[i] = sqlIdent(c.Name) + " " + typ
+			}
+			fmt.Fprintf(w, "CREATE TABLE %s (%s);\n", sqlIdent(t.Name), strings.Join(defs, ", "))
+			values := make([]string, len(cols))
+			err = readConvertRows(b, t, func(row map[string]any) error {
+				for i, c := range cols {
+					values[i] = sqliteValue(row[c.Name])
+				}
+				stats.Rows++
+				_, err := fmt.Fprintf(w, "INSERT INTO %s VALUES (%s);\n", sqlIdent(t.Name), strings.Join(values, ", "))
+				return err
+			})
+			if err != nil {
+				return fmt.Errorf("convert %s: %w", t.Name, err)
+			}
+		}
+		fmt.Fprintln(w, "COMMIT;")
+		return w.Flush()
+	}()
+	pw.Close()
+	if runErr := <-done; runErr != nil {
+		err = runErr
+	}
+	if err != nil {
+		os.Remove(dst)
+		return err
+	}
+	return nil
+}
+
+// sqliteValue renders v as a SQLite literal.
+func sqliteValue(v any) string {
+	switch v := v.(type) {
+	case nil:
+		return "NULL"
+	case bool:
+		if v {
+			return "1"
+		}
+		return "0"
+	case json.Number:
+		return v.String()
+	}
+	return sqlString(cellText(v))
+}
+
+// sqlString quotes s as a SQL string literal.
+func sqlString(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+// sqlIdent quotes s as a SQL identifier.
+func sqlIdent(s string) string {
+	return `"` + strings.ReplaceAll(s, `"`, `""`) + `"`
+}
+
+// runSQL runs tool with args, feeding it the statements of stdin.
+func runSQL(tool string, args []string, stdin io.Reader) error {
+	var stderr bytes.Buffer
+	cmd := exec.Command(tool, args...)
+	cmd.Stdin = stdin
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return fmt.Errorf("%s: %w: %s", tool, err, firstLine(msg))
+		}
+		return fmt.Errorf("%s: %w", tool, err)
+	}
+	return nil
+}
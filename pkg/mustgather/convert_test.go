@@ -0,0 +1,105 @@
+package mustgather
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func convertTestBundle(t *testing.T) string {
+	return writeBundleDir(t, map[string]string{
+		"index.json":                     `{"tables":["KubeEvents","Perf"]}`,
+		"tables/KubeEvents/columns.json": `[{"name":"TimeGenerated","type":"datetime"},{"name":"Reason","type":"string"},{"name":"Count","type":"long"},{"name":"Labels","type":"dynamic"}]`,
+		"tables/KubeEvents/parts/0000-a.ndjson": strings.Join([]string{
+			`{"TimeGenerated":"2024-01-01T00:00:01Z","Reason":"BackOff","Count":3,"Labels":{"app":"cart"}}`,
+			`{"TimeGenerated":"2024-01-01T00:00:02Z","Reason":"it's \"quoted\"","Count":null}`,
+		}, "\n") + "\n",
+		"tables/KubeEvents/parts/0001-b.ndjson": `{"TimeGenerated":"2024-01-01T00:20:00Z","Reason":"Pulled","Count":9007199254740993,"Labels":[1,2]}` + "\n",
+		// No columns.json, as in bundles of older versions
+		"tables/Perf/parts/0000-a.ndjson": `{"Computer":"node-1","TimeGenerated":"2024-01-01T00:00:05Z","CounterValue":0.5}` + "\n",
+	})
+}
+
+func TestConvertCSV(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "out")
+	stats, err := Convert(convertTestBundle(t), ConvertOptions{Format: ConvertCSV, OutDir: out})
+	if err != nil {
+		t.Fatalf("Convert: %v", err)
+	}
+	if stats.Tables != 2 || stats.Rows != 4 || strings.Join(stats.Files, ",") != "KubeEvents.csv,Perf.csv" {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+	got, _ := os.ReadFile(filepath.Join(out, "KubeEvents.csv"))
+	want := `TimeGenerated,Reason,Count,Labels
+2024-01-01T00:00:01Z,BackOff,3,"{""app"":""cart""}"
+2024-01-01T00:00:02Z,"it's ""quoted""",,
+2024-01-01T00:20:00Z,Pulled,9007199254740993,"[1,2]"
+`
+	if string(got) != want {
+		t.Errorf("unexpected KubeEvents.csv:\n%s\nwant:\n%s", got, want)
+	}
+	got, _ = os.ReadFile(filepath.Join(out, "Perf.csv"))
+	if want := "TimeGenerated,Computer,CounterValue\n2024-01-01T00:00:05Z,node-1,0.5\n"; string(got) != want {
+		t.Errorf("unexpected Perf.csv:\n%s\nwant:\n%s", got, want)
+	}
+
+	if _, err := Convert(convertTestBundle(t), ConvertOptions{Format: "xlsx", OutDir: out}); err == nil {
+		t.Error("expected an error for an unknown format")
+	}
+}
+
+func TestConvertSQLite(t *testing.T) {
+	if _, err := exec.LookPath("sqlite3"); err != nil {
+		t.Skip("sqlite3 not installed")
+	}
+	out := filepath.Join(t.TempDir(), "out")
+	stats, err := Convert(convertTestBundle(t), ConvertOptions{Format: ConvertSQLite, OutDir: out})
+	if err != nil {
+		t.Fatalf("Convert: %v", err)
+	}
+	if stats.Rows != 4 || strings.Join(stats.Files, ",") != SQLiteDatabaseName {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+	db := filepath.Join(out, SQLiteDatabaseName)
+	got, err := exec.Command("sqlite3", db, `select Reason, typeof(Count), Count, Labels from KubeEvents order by TimeGenerated; select sum(CounterValue) from Perf;`).Output()
+	if err != nil {
+		t.Fatalf("query database: %v", err)
+	}
+	want := `BackOff|integer|3|{"app":"cart"}
+it's "quoted"|null||
+Pulled|integer|9007199254740993|[1,2]
+0.5
+`
+	if string(got) != want {
+		t.Errorf("unexpected rows:\n%s\nwant:\n%s", got, want)
+	}
+
+	// Converting again replaces the database
+	if _, err := Convert(convertTestBundle(t), ConvertOptions{Format: ConvertSQLite, OutDir: out}); err != nil {
+		t.Fatalf("Convert again: %v", err)
+	}
+}
+
+func TestConvertParquet(t *testing.T) {
+	if _, err := exec.LookPath("duckdb"); err != nil {
+		t.Skip("duckdb not installed")
+	}
+	out := filepath.Join(t.TempDir(), "out")
+	if _, err := Convert(convertTestBundle(t), ConvertOptions{Format: ConvertParquet, OutDir: out}); err != nil {
+		t.Fatalf("Convert: %v", err)
+	}
+	cmd := exec.Command("duckdb", "-csv", "-noheader")
+	cmd.Stdin = strings.NewReader("select count(*), max(Count) from '" + filepath.Join(out, "KubeEvents.parquet") + "';")
+	got, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("read parquet: %v", err)
+	}
+	if strings.TrimSpace(string(got)) != "3,9007199254740993" {
+		t.Errorf("unexpected result %q", got)
+	}
+	if _, err := os.Stat(filepath.Join(out, ".KubeEvents.parquet.csv")); !os.IsNotExist(err) {
+		t.Error("expected the intermediate CSV to be removed")
+	}
+}
@@ -0,0 +1,393 @@
+package mustgather
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"kubectl-must-gather/pkg/bundle"
+	"kubectl-must-gather/pkg/utils"
+)
+
+// BundleDiff is what changed between the gathers of two bundles, e.g.
+// before and after an upgrade. Inventory is compared as of the end of each
+// window, events over the whole of it.
+type BundleDiff struct {
+	Before string `json:"before"`
+	After  string `json:"after"`
+	// Windows are the spans of the inventory reports and events read.
+	BeforeWindow string `json:"beforeWindow,omitempty"`
+	AfterWindow  string `json:"afterWindow,omitempty"`
+
+	PodsAdded       []string     `json:"podsAdded"`
+	PodsRemoved     []string     `json:"podsRemoved"`
+	PodStatus       []DiffChange `json:"podStatus"`
+	NodesAdded      []string     `json:"nodesAdded"`
+	NodesRemoved    []string     `json:"nodesRemoved"`
+	NodeChanges     []DiffChange `json:"nodeChanges"`
+	ServicesAdded   []string     `json:"servicesAdded"`
+	ServicesRemoved []string     `json:"servicesRemoved"`
+	// Restarts are the containers whose restart count rose, or that
+	// restarted in a pod only in After.
+	Restarts []DiffChange `json:"restarts"`
+	// Events are the event types and reasons whose count changed, largest
+	// change first.
+	Events []EventCountChange `json:"events"`
+	// Images are the containers of a workload running other images.
+	Images []DiffChange `json:"images"`
+}
+
+// DiffChange is a value of an object that differs between the bundles.
+type DiffChange struct {
+	Object string `json:"object"`
+	Field  string `json:"field,omitempty"`
+	Before string `json:"before"`
+	After  string `json:"after"`
+}
+
+// EventCountChange is the change of the number of events of a type and
+// reason.
+type EventCountChange struct {
+	Type   string `json:"type"`
+	Reason string `json:"reason"`
+	Before int    `json:"before"`
+	After  int    `json:"after"`
+}
+
+// Empty reports whether nothing changed.
+func (d *BundleDiff) Empty() bool {
+	return len(d.PodsAdded)+len(d.PodsRemoved)+len(d.PodStatus)+len(d.NodesAdded)+len(d.NodesRemoved)+len(d.NodeChanges)+
+		len(d.ServicesAdded)+len(d.ServicesRemoved)+len(d.Restarts)+len(d.Events)+len(d.Images) == 0
+}
+
+// DiffBundles compares the bundles at before and after (archives or
+// extracted directories).
+func DiffBundles(before, after string) (*BundleDiff, error) {
+	a, err := readDiffSnapshot(before)
+	if err != nil {
+		return nil, err
+	}
+	b, err := readDiffSnapshot(after)
+	if err != nil {
+		return nil, err
+	}
+	d := &BundleDiff{Before: before, After: after, BeforeWindow: a.window(), AfterWindow: b.window()}
+
+	podsA, podsB := a.present(a.pods, "KubePodInventory"), b.present(b.pods, "KubePodInventory")
+	d.PodsAdded, d.PodsRemoved = addedRemoved(podsA, podsB)
+	for _, k := range sortedKeys(podsB) {
+		if ra, ok := podsA[k]; ok && ra.status != podsB[k].status {
+			d.PodStatus = append(d.PodStatus, DiffChange{Object: k, Field: "status", Before: ra.status, After: podsB[k].status})
+		}
+	}
+	nodesA, nodesB := a.present(a.nodes, "KubeNodeInventory"), b.present(b.nodes, "KubeNodeInventory")
+	d.NodesAdded, d.NodesRemoved = addedRemoved(nodesA, nodesB)
+	for _, k := range sortedKeys(nodesB) {
+		ra, ok := nodesA[k]
+		if !ok {
+			continue
+		}
+		if ra.status != nodesB[k].status {
+			d.NodeChanges = append(d.NodeChanges, DiffChange{Object: k, Field: "status", Before: ra.status, After: nodesB[k].status})
+		}
+		if ra.version != nodesB[k].version {
+			d.NodeChanges = append(d.NodeChanges, DiffChange{Object: k, Field: "kubeletVersion", Before: ra.version, After: nodesB[k].version})
+		}
+	}
+	d.ServicesAdded, d.ServicesRemoved = addedRemoved(a.present(a.services, "KubeServices"), b.present(b.services, "KubeServices"))
+
+	for _, k := range sortedKeys(b.restarts) {
+		before, after := a.restarts[k], b.restarts[k]
+		if after > before {
+			d.Restarts = append(d.Restarts, DiffChange{Object: k, Field: "restarts", Before: fmt.Sprint(before), After: fmt.Sprint(after)})
+		}
+	}
+
+	reasons := map[string]bool{}
+	for k := range a.events {
+		reasons[k] = true
+	}
+	for k := range b.events {
+		reasons[k] = true
+	}
+	for k := range reasons {
+		if a.events[k] != b.events[k] {
+			typ, reason, _ := strings.Cut(k, "/")
+			d.Events = append(d.Events, EventCountChange{Type: typ, Reason: reason, Before: a.events[k], After: b.events[k]})
+		}
+	}
+	sort.Slice(d.Events, func(i, j int) bool {
+		di, dj := abs(d.Events[i].After-d.Events[i].Before), abs(d.Events[j].After-d.Events[j].Before)
+		if di != dj {
+			return di > dj
+		}
+		return d.Events[i].Type+d.Events[i].Reason < d.Events[j].Type+d.Events[j].Reason
+	})
+
+	for _, k := range sortedKeys(b.images) {
+		ia, ok := a.images[k]
+		if !ok {
+			continue
+		}
+		if before, after := strings.Join(sortedKeys(ia), ", "), strings.Join(sortedKeys(b.images[k]), ", "); before != after {
+			d.Images = append(d.Images, DiffChange{Object: k, Field: "image", Before: before, After: after})
+		}
+	}
+	for _, s := range []*[]string{&d.PodsAdded, &d.PodsRemoved, &d.NodesAdded, &d.NodesRemoved, &d.ServicesAdded, &d.ServicesRemoved} {
+		if *s == nil {
+			*s = []string{}
+		}
+	}
+	for _, s := range []*[]DiffChange{&d.PodStatus, &d.NodeChanges, &d.Restarts, &d.Images} {
+		if *s == nil {
+			*s = []DiffChange{}
+		}
+	}
+	if d.Events == nil {
+		d.Events = []EventCountChange{}
+	}
+	return d, nil
+}
+
+// Render writes d as text, one section per kind of change.
+func (d *BundleDiff) Render(w io.Writer) error {
+	bw := &strings.Builder{}
+	fmt.Fprintf(bw, "--- %s (%s)\n+++ %s (%s)\n", d.Before, orNone(d.BeforeWindow), d.After, orNone(d.AfterWindow))
+	if d.Empty() {
+		bw.WriteString("\nNo changes.\n")
+		_, err := io.WriteString(w, bw.String())
+		return err
+	}
+	list := func(title string, added, removed []string) {
+		if len(added)+len(removed) == 0 {
+			return
+		}
+		fmt.Fprintf(bw, "\n%s:\n", title)
+		for _, k := range removed {
+			fmt.Fprintf(bw, "- %s\n", k)
+		}
+		for _, k := range added {
+			fmt.Fprintf(bw, "+ %s\n", k)
+		}
+	}
+	changes := func(title string, cs []DiffChange) {
+		if len(cs) == 0 {
+			return
+		}
+		fmt.Fprintf(bw, "\n%s:\n", title)
+		tw := tabwriter.NewWriter(bw, 0, 0, 2, ' ', 0)
+		for _, c := range cs {
+			fmt.Fprintf(tw, "  %s\t%s\t%s -> %s\n", c.Object, c.Field, orNone(c.Before), orNone(c.After))
+		}
+		tw.Flush()
+	}
+	list("Pods", d.PodsAdded, d.PodsRemoved)
+	changes("Pod status", d.PodStatus)
+	list("Nodes", d.NodesAdded, d.NodesRemoved)
+	changes("Node changes", d.NodeChanges)
+	list("Services", d.ServicesAdded, d.ServicesRemoved)
+	changes("Restarts", d.Restarts)
+	changes("Images", d.Images)
+	if len(d.Events) > 0 {
+		bw.WriteString("\nEvents:\n")
+		tw := tabwriter.NewWriter(bw, 0, 0, 2, ' ', 0)
+		for _, e := range d.Events {
+			fmt.Fprintf(tw, "  %s\t%s\t%d -> %d\t(%+d)\n", e.Type, e.Reason, e.Before, e.After, e.After-e.Before)
+		}
+		tw.Flush()
+	}
+	_, err := io.WriteString(w, bw.String())
+	return err
+}
+
+// diffSnapshot is what DiffBundles compares of a bundle: the latest report
+// of each pod, node and service, the highest restart count of each
+// container, the images of each workload's containers and the number of
+// events per type and reason.
+type diffSnapshot struct {
+	pods, nodes, services map[string]diffReport
+	restarts              map[string]int64
+	images                map[string]map[string]bool
+	events                map[string]int
+	// last is the latest report time of each inventory table.
+	last       map[string]time.Time
+	first, end time.Time
+	// containers are the latest container of each pod and container name,
+	// for the images of the pods present at the end.
+	containers map[string]diffContainer
+	inventory  map[string]map[string]any
+}
+
+type diffContainer struct {
+	pod, workload, id string
+}
+
+// diffReport is the latest inventory report of an object.
+type diffReport struct {
+	tm              time.Time
+	status, version string
+}
+
+func readDiffSnapshot(src string) (*diffSnapshot, error) {
+	b, err := bundle.Open(src)
+	if err != nil {
+		return nil, fmt.Errorf("open bundle %s: %w", src, err)
+	}
+	defer b.Close()
+	tables, err := b.Tables()
+	if err != nil {
+		return nil, fmt.Errorf("list tables of %s: %w", src, err)
+	}
+	s := &diffSnapshot{
+		pods: map[string]diffReport{}, nodes: map[string]diffReport{}, services: map[string]diffReport{},
+		restarts: map[string]int64{}, images: map[string]map[string]bool{}, events: map[string]int{},
+		last: map[string]time.Time{}, containers: map[string]diffContainer{}, inventory: map[string]map[string]any{},
+	}
+	found := false
+	for _, t := range tables {
+		switch t.Name {
+		case "KubePodInventory", "KubeNodeInventory", "KubeServices", "KubeEvents", "ContainerInventory":
+		default:
+			continue
+		}
+		found = true
+		for _, part := range t.Parts {
+			if err := b.ReadPart(part, func(row map[string]any) error { s.observe(t.Name, row); return nil }); err != nil {
+				return nil, fmt.Errorf("read %s of %s: %w", part, src, err)
+			}
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("%s has none of KubePodInventory, KubeNodeInventory, KubeServices, KubeEvents or ContainerInventory to compare; gather with the inventory profile", src)
+	}
+	// Images are known once ContainerInventory is read, whatever the order
+	// of the tables
+	pods := s.present(s.pods, "KubePodInventory")
+	for _, c := range s.containers {
+		if _, ok := pods[c.pod]; !ok {
+			continue
+		}
+		if image := containerImage(s.inventory[c.id]); image != "" {
+			key := c.workload
+			if s.images[key] == nil {
+				s.images[key] = map[string]bool{}
+			}
+			s.images[key][image] = true
+		}
+	}
+	return s, nil
+}
+
+func (s *diffSnapshot) observe(table string, row map[string]any) {
+	tm := utils.ParseTimeRFC3339(toStr(row["TimeGenerated"]))
+	if tm.IsZero() {
+		return
+	}
+	if s.first.IsZero() || tm.Before(s.first) {
+		s.first = tm
+	}
+	if tm.After(s.end) {
+		s.end = tm
+	}
+	if tm.After(s.last[table]) {
+		s.last[table] = tm
+	}
+	report := func(m map[string]diffReport, key string, r diffReport) {
+		if prev, ok := m[key]; !ok || !tm.Before(prev.tm) {
+			r.tm = tm
+			m[key] = r
+		}
+	}
+	switch table {
+	case "KubePodInventory":
+		ns, name := toStr(row["Namespace"]), toStr(row["Name"])
+		if name == "" {
+			return
+		}
+		report(s.pods, ns+"/"+name, diffReport{status: toStr(row["PodStatus"])})
+		cn := podContainerName(toStr(row["ContainerName"]))
+		if cn == "" {
+			return
+		}
+		if rc, ok := toFloat(row["ContainerRestartCount"]); ok && int64(rc) > s.restarts[ns+"/"+name+"/"+cn] {
+			s.restarts[ns+"/"+name+"/"+cn] = int64(rc)
+		}
+		// Pods of a workload are renamed by every rollout; their images
+		// are compared per workload
+		workload := name
+		if owner := toStr(row["ControllerName"]); owner != "" {
+			workload = strings.TrimPrefix(toStr(row["ControllerKind"])+"/"+owner, "/")
+		}
+		if id := toStr(row["ContainerID"]); id != "" {
+			s.containers[ns+"/"+name+"/"+cn] = diffContainer{pod: ns + "/" + name, workload: ns + "/" + workload + "/" + cn, id: id}
+		}
+	case "KubeNodeInventory":
+		if name := toStr(row["Computer"]); name != "" {
+			report(s.nodes, name, diffReport{status: toStr(row["Status"]), version: toStr(row["KubeletVersion"])})
+		}
+	case "KubeServices":
+		if name := toStr(row["ServiceName"]); name != "" {
+			report(s.services, toStr(row["Namespace"])+"/"+name, diffReport{})
+		}
+	case "KubeEvents":
+		s.events[toStr(row["KubeEventType"])+"/"+toStr(row["Reason"])]++
+	case "ContainerInventory":
+		id := toStr(row["ContainerID"])
+		if prev, ok := s.inventory[id]; id != "" && (!ok || later(toStr(row["TimeGenerated"]), toStr(prev["TimeGenerated"]))) {
+			s.inventory[id] = row
+		}
+	}
+}
+
+// present returns the objects of reports reported within snapshotMaxAge of
+// the last report of table, those still there at the end of the window.
+func (s *diffSnapshot) present(reports map[string]diffReport, table string) map[string]diffReport {
+	out := map[string]diffReport{}
+	for k, r := range reports {
+		if !r.tm.Before(s.last[table].Add(-snapshotMaxAge)) {
+			out[k] = r
+		}
+	}
+	return out
+}
+
+func (s *diffSnapshot) window() string {
+	if s.first.IsZero() {
+		return ""
+	}
+	return s.first.UTC().Format(time.RFC3339) + " to " + s.end.UTC().Format(time.RFC3339)
+}
+
+// addedRemoved returns the keys only in b and those only in a, sorted.
+func addedRemoved[V any](a, b map[string]V) (added, removed []string) {
+	for _, k := range sortedKeys(b) {
+		if _, ok := a[k]; !ok {
+			added = append(added, k)
+		}
+	}
+	for _, k := range sortedKeys(a) {
+		if _, ok := b[k]; !ok {
+			removed = append(removed, k)
+		}
+	}
+	return added, removed
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
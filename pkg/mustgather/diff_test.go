@@ -0,0 +1,79 @@
+package mustgather
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestDiffBundles(t *testing.T) {
+	before := writeBundleDir(t, map[string]string{
+		"index.json": `{"tables":["KubePodInventory","KubeNodeInventory","ContainerInventory","KubeEvents"]}`,
+		"tables/KubePodInventory/parts/0000-a.ndjson": strings.Join([]string{
+			`{"TimeGenerated":"2024-01-01T00:00:00Z","Namespace":"shop","Name":"cart-1","PodStatus":"Running","ContainerName":"uid/cart","ContainerRestartCount":1,"ContainerID":"c1","ControllerKind":"ReplicaSet","ControllerName":"cart-7d"}`,
+			`{"TimeGenerated":"2024-01-01T00:00:00Z","Namespace":"shop","Name":"web-1","PodStatus":"Running","ContainerName":"uid/web","ContainerRestartCount":0,"ContainerID":"w1"}`,
+			// Deleted long before the end
+			`{"TimeGenerated":"2023-12-31T23:00:00Z","Namespace":"shop","Name":"job-1","PodStatus":"Succeeded","ContainerName":"uid/job"}`,
+		}, "\n") + "\n",
+		"tables/KubeNodeInventory/parts/0000-a.ndjson": `{"TimeGenerated":"2024-01-01T00:00:00Z","Computer":"aks-node-1","Status":"Ready","KubeletVersion":"v1.29.4"}` + "\n",
+		"tables/ContainerInventory/parts/0000-a.ndjson": strings.Join([]string{
+			`{"TimeGenerated":"2024-01-01T00:00:00Z","ContainerID":"c1","Image":"cart","Repository":"acr.io","ImageTag":"1.0"}`,
+			`{"TimeGenerated":"2024-01-01T00:00:00Z","ContainerID":"w1","Image":"web","ImageTag":"2.0"}`,
+		}, "\n") + "\n",
+		"tables/KubeEvents/parts/0000-a.ndjson": `{"TimeGenerated":"2024-01-01T00:00:00Z","KubeEventType":"Warning","Reason":"BackOff"}` + "\n",
+	})
+	after := writeBundleDir(t, map[string]string{
+		"index.json": `{"tables":["KubePodInventory","KubeNodeInventory","ContainerInventory","KubeEvents"]}`,
+		"tables/KubePodInventory/parts/0000-a.ndjson": strings.Join([]string{
+			`{"TimeGenerated":"2024-01-02T00:00:00Z","Namespace":"shop","Name":"cart-2","PodStatus":"Running","ContainerName":"uid/cart","ContainerRestartCount":4,"ContainerID":"c2","ControllerKind":"ReplicaSet","ControllerName":"cart-7d"}`,
+			`{"TimeGenerated":"2024-01-02T00:00:00Z","Namespace":"shop","Name":"web-1","PodStatus":"Pending","ContainerName":"uid/web","ContainerRestartCount":0,"ContainerID":"w1"}`,
+		}, "\n") + "\n",
+		"tables/KubeNodeInventory/parts/0000-a.ndjson": strings.Join([]string{
+			`{"TimeGenerated":"2024-01-02T00:00:00Z","Computer":"aks-node-1","Status":"Ready","KubeletVersion":"v1.30.1"}`,
+			`{"TimeGenerated":"2024-01-02T00:00:00Z","Computer":"aks-node-2","Status":"NotReady","KubeletVersion":"v1.30.1"}`,
+		}, "\n") + "\n",
+		"tables/ContainerInventory/parts/0000-a.ndjson": strings.Join([]string{
+			`{"TimeGenerated":"2024-01-02T00:00:00Z","ContainerID":"c2","Image":"cart","Repository":"acr.io","ImageTag":"1.1"}`,
+			`{"TimeGenerated":"2024-01-02T00:00:00Z","ContainerID":"w1","Image":"web","ImageTag":"2.0"}`,
+		}, "\n") + "\n",
+		"tables/KubeEvents/parts/0000-a.ndjson": strings.Repeat(`{"TimeGenerated":"2024-01-02T00:00:00Z","KubeEventType":"Warning","Reason":"BackOff"}`+"\n", 3) +
+			`{"TimeGenerated":"2024-01-02T00:00:00Z","KubeEventType":"Normal","Reason":"Pulled"}` + "\n",
+	})
+
+	d, err := DiffBundles(before, after)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(d.PodsAdded, ",") != "shop/cart-2" || strings.Join(d.PodsRemoved, ",") != "shop/cart-1" {
+		t.Errorf("pods added %v, removed %v", d.PodsAdded, d.PodsRemoved)
+	}
+	if len(d.PodStatus) != 1 || d.PodStatus[0] != (DiffChange{Object: "shop/web-1", Field: "status", Before: "Running", After: "Pending"}) {
+		t.Errorf("pod status = %+v", d.PodStatus)
+	}
+	if strings.Join(d.NodesAdded, ",") != "aks-node-2" || len(d.NodeChanges) != 1 || d.NodeChanges[0].After != "v1.30.1" {
+		t.Errorf("nodes added %v, changes %+v", d.NodesAdded, d.NodeChanges)
+	}
+	if len(d.Restarts) != 1 || d.Restarts[0].Object != "shop/cart-2/cart" || d.Restarts[0].After != "4" {
+		t.Errorf("restarts = %+v", d.Restarts)
+	}
+	if len(d.Images) != 1 || d.Images[0] != (DiffChange{Object: "shop/ReplicaSet/cart-7d/cart", Field: "image", Before: "acr.io/cart:1.0", After: "acr.io/cart:1.1"}) {
+		t.Errorf("images = %+v", d.Images)
+	}
+	if len(d.Events) != 2 || d.Events[0] != (EventCountChange{Type: "Warning", Reason: "BackOff", Before: 1, After: 3}) {
+		t.Errorf("events = %+v", d.Events)
+	}
+
+	var out bytes.Buffer
+	if err := d.Render(&out); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"Pods:\n- shop/cart-1\n+ shop/cart-2\n", "aks-node-1  kubeletVersion  v1.29.4 -> v1.30.1", "Warning  BackOff  1 -> 3  (+2)"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("diff lacks %q:\n%s", want, out.String())
+		}
+	}
+
+	if _, err := DiffBundles(before, writeBundleDir(t, map[string]string{"index.json": `{"tables":[]}`})); err == nil || !strings.Contains(err.Error(), "inventory profile") {
+		t.Errorf("bundle without inventory = %v", err)
+	}
+}
@@ -0,0 +1,84 @@
+package mustgather
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// Tools that encrypt archives for --encrypt-to, chosen by the form of the
+// recipients.
+const (
+	EncryptAge = "age"
+	EncryptGPG = "gpg"
+)
+
+// isAgeRecipient reports whether r is an age public key, a recipient of an
+// age plugin, or an SSH public key, all of which age encrypts to. Anything
+// else is taken to name a GPG key in the local keyring.
+func isAgeRecipient(r string) bool {
+	return strings.HasPrefix(r, "age1") || strings.HasPrefix(r, "ssh-ed25519 ") || strings.HasPrefix(r, "ssh-rsa ")
+}
+
+// encryptionTool returns the tool that encrypts to recipients and checks it
+// is installed, so a gather does not run only to fail at the end.
+func encryptionTool(recipients []string) (string, error) {
+	tool := ""
+	for _, r := range recipients {
+		t := EncryptGPG
+		if isAgeRecipient(r) {
+			t = EncryptAge
+		}
+		if tool != "" && t != tool {
+			return "", fmt.Errorf("--encrypt-to mixes age and GPG recipients; an archive is encrypted with one of them")
+		}
+		tool = t
+	}
+	if _, err := exec.LookPath(tool); err != nil {
+		return "", fmt.Errorf("--encrypt-to needs %s on PATH: %w", tool, err)
+	}
+	return tool, nil
+}
+
+// encryptFile encrypts src to recipients with tool into src plus ".age" or
+// ".gpg" and removes src, returning the encrypted file. On failure src is
+// left in place and no partial output is kept.
+func encryptFile(ctx context.Context, tool, src string, recipients []string) (string, error) {
+	dst := src + "." + tool
+	var args []string
+	switch tool {
+	case EncryptAge:
+		args = []string{"--encrypt"}
+		for _, r := range recipients {
+			args = append(args, "--recipient", r)
+		}
+	case EncryptGPG:
+		// Batch mode cannot ask whether to trust a key; importing it into
+		// the keyring is taken as trusting it
+		args = []string{"--batch", "--yes", "--trust-model", "always", "--encrypt"}
+		for _, r := range recipients {
+			args = append(args, "--recipient", r)
+		}
+	default:
+		return "", fmt.Errorf("unknown encryption tool %q", tool)
+	}
+	args = append(args, "--output", dst, src)
+
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, tool, args...)
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		os.Remove(dst)
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", fmt.Errorf("%s: %w: %s", tool, err, firstLine(msg))
+		}
+		return "", fmt.Errorf("%s: %w", tool, err)
+	}
+	if err := os.Remove(src); err != nil {
+		return "", fmt.Errorf("remove unencrypted archive: %w", err)
+	}
+	return dst, nil
+}
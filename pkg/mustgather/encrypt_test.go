@@ -0,0 +1,144 @@
+package mustgather
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// fakeAge puts an age stand-in on PATH that writes "encrypted:" and the
+// input to --output, or fails when a recipient is "age1bad".
+func fakeAge(t *testing.T) {
+	t.Helper()
+	dir := t.TempDir()
+	script := `#!/bin/sh
+out=""; in=""
+while [ $# -gt 0 ]; do
+  case "$1" in
+    --output) out="$2"; shift 2 ;;
+    --recipient) [ "$2" = age1bad ] && { echo "age: error: malformed recipient" >&2; printf partial > "$out"; exit 1; }; shift 2 ;;
+    --*) shift ;;
+    *) in="$1"; shift ;;
+  esac
+done
+{ printf 'encrypted:'; cat "$in"; } > "$out"
+`
+	if err := os.WriteFile(filepath.Join(dir, "age"), []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+}
+
+func TestEncryptionTool(t *testing.T) {
+	fakeAge(t)
+	tests := []struct {
+		name       string
+		recipients []string
+		want       string
+		wantErr    string
+	}{
+		{"age key", []string{"age1ql3z7hjy54pw3hyww5ayyfg7zqgvc7w3j2elw8zmrj2kg5sfn9aqmcac8p"}, EncryptAge, ""},
+		{"ssh key", []string{"ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIHsKLqeplhpW+uObz5dvMgjz1OxfM/XXUB+VHtZ6isGN alice"}, EncryptAge, ""},
+		{"mixed", []string{"age1ql3z7hjy54pw3hyww5ayyfg7zqgvc7w3j2elw8zmrj2kg5sfn9aqmcac8p", "alice@contoso.com"}, "", "mixes age and GPG"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := encryptionTool(tt.recipients)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("expected error containing %q, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Errorf("encryptionTool = %q, %v; want %q", got, err, tt.want)
+			}
+		})
+	}
+
+	t.Setenv("PATH", t.TempDir())
+	if _, err := encryptionTool([]string{"age1abc"}); err == nil || !strings.Contains(err.Error(), "needs age on PATH") {
+		t.Errorf("expected a missing age to fail, got %v", err)
+	}
+}
+
+func TestEncryptFileAge(t *testing.T) {
+	fakeAge(t)
+	src := filepath.Join(t.TempDir(), "bundle.tar.gz")
+	if err := os.WriteFile(src, []byte("archive"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := encryptFile(context.Background(), EncryptAge, src, []string{"age1bad"}); err == nil || !strings.Contains(err.Error(), "malformed recipient") {
+		t.Errorf("expected the age error, got %v", err)
+	}
+	if _, err := os.Stat(src + ".age"); !os.IsNotExist(err) {
+		t.Error("partial output should be removed on failure")
+	}
+	if _, err := os.Stat(src); err != nil {
+		t.Errorf("the archive should be kept on failure: %v", err)
+	}
+
+	dst, err := encryptFile(context.Background(), EncryptAge, src, []string{"age1good"})
+	if err != nil {
+		t.Fatalf("encryptFile failed: %v", err)
+	}
+	if b, _ := os.ReadFile(dst); dst != src+".age" || string(b) != "encrypted:archive" {
+		t.Errorf("unexpected output %s: %q", dst, b)
+	}
+	if _, err := os.Stat(src); !os.IsNotExist(err) {
+		t.Error("expected the unencrypted archive to be removed")
+	}
+}
+
+func TestEncryptFileGPG(t *testing.T) {
+	if _, err := exec.LookPath("gpg"); err != nil {
+		t.Skip("gpg not installed")
+	}
+	home := t.TempDir()
+	t.Setenv("GNUPGHOME", home)
+	t.Cleanup(func() { _ = exec.Command("gpgconf", "--kill", "gpg-agent").Run() })
+	gen := exec.Command("gpg", "--batch", "--passphrase", "", "--quick-gen-key", "must-gather-test@example.com", "ed25519", "default", "never")
+	if out, err := gen.CombinedOutput(); err != nil {
+		t.Skipf("cannot create a test key: %v: %s", err, out)
+	}
+	sub := exec.Command("gpg", "--batch", "--passphrase", "", "--quick-add-key", "--", firstGPGFingerprint(t), "cv25519", "encr", "never")
+	if out, err := sub.CombinedOutput(); err != nil {
+		t.Skipf("cannot create a test key: %v: %s", err, out)
+	}
+
+	src := filepath.Join(t.TempDir(), "bundle.tar.gz")
+	if err := os.WriteFile(src, []byte("archive"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	dst, err := encryptFile(context.Background(), EncryptGPG, src, []string{"must-gather-test@example.com"})
+	if err != nil {
+		t.Fatalf("encryptFile failed: %v", err)
+	}
+	out, err := exec.Command("gpg", "--batch", "--quiet", "--decrypt", dst).Output()
+	if err != nil || string(out) != "archive" {
+		t.Errorf("decrypt = %q, %v", out, err)
+	}
+
+	if _, err := encryptFile(context.Background(), EncryptGPG, dst, []string{"nobody@example.com"}); err == nil || !strings.Contains(err.Error(), "gpg:") {
+		t.Errorf("expected an unknown recipient to fail with gpg's message, got %v", err)
+	}
+}
+
+func firstGPGFingerprint(t *testing.T) string {
+	t.Helper()
+	out, err := exec.Command("gpg", "--batch", "--with-colons", "--list-keys").Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range strings.Split(string(out), "\n") {
+		if f := strings.Split(line, ":"); f[0] == "fpr" && len(f) > 9 {
+			return f[9]
+		}
+	}
+	t.Fatal("no key listed")
+	return ""
+}
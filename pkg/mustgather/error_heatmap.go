@@ -0,0 +1,108 @@
+package mustgather
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"kubectl-must-gather/pkg/utils"
+)
+
+// errorHeatmapPath is where the error counts per namespace and time bucket
+// are written.
+const errorHeatmapPath = "analysis/error-heatmap.csv"
+
+// heatmapBucket is the interval error logs are counted over.
+const heatmapBucket = 5 * time.Minute
+
+// errorHeatmap writes analysis/error-heatmap.csv: error-level ContainerLogV2
+// lines counted per namespace per heatmapBucket, one row per bucket and one
+// column per namespace, busiest namespace first. Buckets span all gathered
+// container logs, so quiet periods are rows of zeros rather than missing.
+type errorHeatmap struct {
+	counts map[string]map[int64]int
+	totals map[string]int
+	// first and last bound the buckets of all container logs.
+	first, last int64
+	seen        bool
+}
+
+func newErrorHeatmap() *errorHeatmap {
+	return &errorHeatmap{counts: map[string]map[int64]int{}, totals: map[string]int{}}
+}
+
+func (h *errorHeatmap) observe(table string, row map[string]any) {
+	if table != "ContainerLogV2" {
+		return
+	}
+	ts := utils.ParseTimeRFC3339(toStr(row["TimeGenerated"]))
+	if ts.IsZero() {
+		return
+	}
+	bucket := ts.Unix() / int64(heatmapBucket/time.Second)
+	if !h.seen || bucket < h.first {
+		h.first = bucket
+	}
+	if !h.seen || bucket > h.last {
+		h.last = bucket
+	}
+	h.seen = true
+	if _, ok := errorLog(row); !ok {
+		return
+	}
+	ns := toStr(row["PodNamespace"])
+	if h.counts[ns] == nil {
+		h.counts[ns] = map[int64]int{}
+	}
+	h.counts[ns][bucket]++
+	h.totals[ns]++
+}
+
+func (h *errorHeatmap) endChunk(tarw *utils.TarWriter) error {
+	return nil
+}
+
+func (h *errorHeatmap) warnings(table string) []string {
+	return nil
+}
+
+func (h *errorHeatmap) finish(tarw *utils.TarWriter) error {
+	namespaces := make([]string, 0, len(h.totals))
+	for ns := range h.totals {
+		namespaces = append(namespaces, ns)
+	}
+	sort.Slice(namespaces, func(i, j int) bool {
+		if h.totals[namespaces[i]] != h.totals[namespaces[j]] {
+			return h.totals[namespaces[i]] > h.totals[namespaces[j]]
+		}
+		return namespaces[i] < namespaces[j]
+	})
+
+	header := []string{"bucket", "total"}
+	for _, ns := range namespaces {
+		if ns == "" {
+			ns = "(none)"
+		}
+		header = append(header, ns)
+	}
+	rows := [][]string{header}
+	for b := h.first; h.seen && b <= h.last; b++ {
+		row := []string{time.Unix(b*int64(heatmapBucket/time.Second), 0).UTC().Format(time.RFC3339), ""}
+		total := 0
+		for _, ns := range namespaces {
+			n := h.counts[ns][b]
+			total += n
+			row = append(row, strconv.Itoa(n))
+		}
+		row[1] = strconv.Itoa(total)
+		rows = append(rows, row)
+	}
+	var buf bytes.Buffer
+	if err := csv.NewWriter(&buf).WriteAll(rows); err != nil {
+		return fmt.Errorf("write %s: %w", errorHeatmapPath, err)
+	}
+	return utils.WriteFileToTar(tarw, errorHeatmapPath, buf.Bytes())
+}
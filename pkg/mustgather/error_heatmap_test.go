@@ -0,0 +1,45 @@
+package mustgather
+
+import "testing"
+
+func TestErrorHeatmap(t *testing.T) {
+	h := newErrorHeatmap()
+	for _, row := range []map[string]any{
+		{"TimeGenerated": "2024-01-01T00:01:00Z", "PodNamespace": "shop", "LogLevel": "info", "LogMessage": "started"},
+		{"TimeGenerated": "2024-01-01T00:02:00Z", "PodNamespace": "shop", "LogLevel": "error", "LogMessage": "db down"},
+		// Quiet from 00:05 to 00:15
+		{"TimeGenerated": "2024-01-01T00:16:00Z", "PodNamespace": "shop", "LogLevel": "error", "LogMessage": "db down"},
+		{"TimeGenerated": "2024-01-01T00:17:00Z", "PodNamespace": "shop", "LogLevel": "critical", "LogMessage": "giving up"},
+		{"TimeGenerated": "2024-01-01T00:18:00Z", "PodNamespace": "kube-system", "LogLevel": "error", "LogMessage": "probe failed"},
+		{"TimeGenerated": "2024-01-01T00:19:00Z", "PodNamespace": "kube-system", "LogLevel": "warning", "LogMessage": "slow"},
+	} {
+		h.observe("ContainerLogV2", row)
+	}
+	h.observe("KubeEvents", map[string]any{"TimeGenerated": "2024-01-01T01:00:00Z", "Namespace": "shop"})
+
+	want := "bucket,total,shop,kube-system\n" +
+		"2024-01-01T00:00:00Z,1,1,0\n" +
+		"2024-01-01T00:05:00Z,0,0,0\n" +
+		"2024-01-01T00:10:00Z,0,0,0\n" +
+		"2024-01-01T00:15:00Z,3,2,1\n"
+	if got := readTransform(t, h)[errorHeatmapPath]; got != want {
+		t.Errorf("error-heatmap.csv\n got %q\nwant %q", got, want)
+	}
+}
+
+func TestErrorHeatmapWithoutLogLevel(t *testing.T) {
+	h := newErrorHeatmap()
+	for _, row := range []map[string]any{
+		{"TimeGenerated": "2024-01-01T00:01:00Z", "PodNamespace": "", "LogMessage": "panic: boom"},
+		{"TimeGenerated": "2024-01-01T00:02:00Z", "PodNamespace": "", "LogMessage": "all good"},
+	} {
+		h.observe("ContainerLogV2", row)
+	}
+	want := "bucket,total,(none)\n2024-01-01T00:00:00Z,1,1\n"
+	if got := readTransform(t, h)[errorHeatmapPath]; got != want {
+		t.Errorf("error-heatmap.csv\n got %q\nwant %q", got, want)
+	}
+	if got := readTransform(t, newErrorHeatmap())[errorHeatmapPath]; got != "bucket,total\n" {
+		t.Errorf("expected only a header without logs, got %q", got)
+	}
+}
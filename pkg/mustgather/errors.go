@@ -0,0 +1,106 @@
+package mustgather
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+)
+
+// Classes of failure of a gather or query. The errors returned by Run and
+// Query wrap the class they belong to, if any, for errors.Is; their messages
+// stay those of the underlying error.
+var (
+	// ErrAuth is a credential that could not get a token, or whose token
+	// was refused (HTTP 401 or 403).
+	ErrAuth = errors.New("authentication failed")
+	// ErrWorkspaceNotFound is a workspace that does not exist, or that the
+	// credential cannot see.
+	ErrWorkspaceNotFound = errors.New("workspace not found")
+	// ErrThrottled is a query still throttled (HTTP 429 or 503) after
+	// MaxRetries retries.
+	ErrThrottled = errors.New("throttled")
+	// ErrPartialData is a gather that stopped early, interrupted or timed
+	// out, and wrote an incomplete bundle.
+	ErrPartialData = errors.New("partial data")
+)
+
+// errClasses lists the classes, in the order a failure is classified.
+var errClasses = []error{ErrAuth, ErrWorkspaceNotFound, ErrThrottled, ErrPartialData}
+
+// classError is an error marked with its class.
+type classError struct {
+	class error
+	err   error
+}
+
+func (e *classError) Error() string {
+	return e.err.Error()
+}
+
+func (e *classError) Unwrap() []error {
+	return []error{e.class, e.err}
+}
+
+// markErr marks err with class, keeping its message.
+func markErr(class, err error) error {
+	if err == nil || errors.Is(err, class) {
+		return err
+	}
+	return &classError{class: class, err: err}
+}
+
+// classify marks err with the class of the Azure response or credential
+// error it wraps, unless it is marked already.
+func classify(err error) error {
+	if err == nil {
+		return nil
+	}
+	for _, class := range errClasses {
+		if errors.Is(err, class) {
+			return err
+		}
+	}
+	var authErr *azidentity.AuthenticationFailedError
+	if errors.As(err, &authErr) {
+		return markErr(ErrAuth, err)
+	}
+	var respErr *azcore.ResponseError
+	if errors.As(err, &respErr) {
+		switch respErr.StatusCode {
+		case http.StatusUnauthorized, http.StatusForbidden:
+			return markErr(ErrAuth, err)
+		case http.StatusTooManyRequests, http.StatusServiceUnavailable:
+			return markErr(ErrThrottled, err)
+		}
+	}
+	return err
+}
+
+// workspaceError marks the error of looking up a workspace that does not
+// exist with ErrWorkspaceNotFound.
+func workspaceError(err error) error {
+	var respErr *azcore.ResponseError
+	if errors.As(err, &respErr) && respErr.StatusCode == http.StatusNotFound {
+		return markErr(ErrWorkspaceNotFound, err)
+	}
+	return classify(err)
+}
+
+// authCredential marks the errors of a credential with ErrAuth. Credentials
+// such as DefaultAzureCredential fail with errors of their own types when
+// nothing is signed in, which the SDK passes on from every client call.
+type authCredential struct {
+	azcore.TokenCredential
+}
+
+func (c authCredential) GetToken(ctx context.Context, opts policy.TokenRequestOptions) (azcore.AccessToken, error) {
+	tk, err := c.TokenCredential.GetToken(ctx, opts)
+	if err != nil && ctx.Err() == nil {
+		err = markErr(ErrAuth, err)
+	}
+	return tk, err
+}
@@ -0,0 +1,73 @@
+package mustgather
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	armoperationalinsights "github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/operationalinsights/armoperationalinsights"
+)
+
+// missingWorkspaces answers every lookup with a 404.
+type missingWorkspaces struct{}
+
+func (missingWorkspaces) Get(ctx context.Context, rg, ws string, options *armoperationalinsights.WorkspacesClientGetOptions) (armoperationalinsights.WorkspacesClientGetResponse, error) {
+	return armoperationalinsights.WorkspacesClientGetResponse{}, &azcore.ResponseError{StatusCode: http.StatusNotFound, ErrorCode: "ResourceNotFound"}
+}
+
+func TestClassify(t *testing.T) {
+	tests := []struct {
+		err  error
+		want error
+	}{
+		{fmt.Errorf("list tables: %w", &azcore.ResponseError{StatusCode: http.StatusForbidden}), ErrAuth},
+		{&azcore.ResponseError{StatusCode: http.StatusTooManyRequests}, ErrThrottled},
+		{workspaceError(&azcore.ResponseError{StatusCode: http.StatusNotFound}), ErrWorkspaceNotFound},
+		{markErr(ErrPartialData, &azcore.ResponseError{StatusCode: http.StatusForbidden}), ErrPartialData},
+	}
+	for _, tt := range tests {
+		got := classify(tt.err)
+		if !errors.Is(got, tt.want) {
+			t.Errorf("classify(%v) is not %v", tt.err, tt.want)
+		}
+		if got.Error() != tt.err.Error() {
+			t.Errorf("classify changed the message to %q", got)
+		}
+	}
+	if err := errors.New("boom"); classify(err) != err {
+		t.Error("expected an unknown error to be left alone")
+	}
+	// Marked with its own class only
+	if errors.Is(classify(markErr(ErrPartialData, &azcore.ResponseError{StatusCode: http.StatusForbidden})), ErrAuth) {
+		t.Error("expected a marked error not to be classified again")
+	}
+}
+
+func TestGatherErrorClasses(t *testing.T) {
+	run := func(t *testing.T, opts ...Option) error {
+		t.Helper()
+		config := &Config{
+			WorkspaceID: "/subscriptions/sub/resourceGroups/rg/providers/Microsoft.OperationalInsights/workspaces/ws",
+			Timespan:    "PT1H",
+			OutputFile:  t.TempDir() + "/bundle.tar.gz",
+			Quiet:       true,
+		}
+		g, err := NewGatherer(context.Background(), config, append([]Option{WithCredential(noCredential{})}, opts...)...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = g.Run()
+		return err
+	}
+
+	// The credential fails before any request is sent
+	if err := run(t); !errors.Is(err, ErrAuth) {
+		t.Errorf("expected ErrAuth, got %v", err)
+	}
+	if err := run(t, WithWorkspacesClient(missingWorkspaces{})); !errors.Is(err, ErrWorkspaceNotFound) {
+		t.Errorf("expected ErrWorkspaceNotFound, got %v", err)
+	}
+}
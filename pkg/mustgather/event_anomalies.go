@@ -0,0 +1,256 @@
+package mustgather
+
+import (
+	"encoding/json"
+	"sort"
+	"time"
+
+	"kubectl-must-gather/pkg/utils"
+)
+
+// eventAnomaliesPath is where event bursts are written.
+const eventAnomaliesPath = "analysis/event-anomalies.json"
+
+// Burst detection parameters.
+const (
+	// anomalyBucket is the interval event rates are counted over.
+	anomalyBucket = 5 * time.Minute
+	// burstMinEvents is the fewest events in a bucket that count as a burst.
+	burstMinEvents = 5
+	// burstFactor is how many times the baseline rate a burst must reach.
+	burstFactor = 3
+	// burstTopObjects caps the objects listed per burst.
+	burstTopObjects = 5
+)
+
+// eventAnomalies writes analysis/event-anomalies.json. KubeEvents rows are
+// counted per namespace and reason in anomalyBucket intervals; the baseline
+// of a series is its median bucket over the span of all gathered events, so
+// a reason that is always noisy is not flagged, while one that spikes at
+// incident time is. Consecutive burst buckets are merged into one anomaly.
+type eventAnomalies struct {
+	series map[seriesKey]*eventSeries
+	// first and last bound the buckets of all series.
+	first, last int64
+}
+
+type seriesKey struct {
+	namespace, reason string
+}
+
+// eventSeries counts one namespace and reason per bucket.
+type eventSeries struct {
+	kind    string
+	buckets map[int64]*eventBucket
+	total   int
+}
+
+type eventBucket struct {
+	count   int
+	sample  string
+	objects map[string]int
+}
+
+// EventAnomaly is a burst of one event reason in one namespace.
+type EventAnomaly struct {
+	Namespace string `json:"namespace,omitempty"`
+	Reason    string `json:"reason"`
+	Type      string `json:"type,omitempty"`
+	Start     string `json:"start"`
+	End       string `json:"end"`
+	Count     int    `json:"count"`
+	// Peak is the busiest bucket of the burst, Baseline the median bucket
+	// of the series; Ratio is Peak over Baseline, or over 1 when the
+	// reason is usually absent.
+	Peak     int     `json:"peak"`
+	Baseline float64 `json:"baselinePerBucket"`
+	Ratio    float64 `json:"ratio"`
+	Sample   string  `json:"sample"`
+	// Objects are the objects with the most events in the burst.
+	Objects []string `json:"objects"`
+}
+
+// eventBaseline is the rate of one series over the window.
+type eventBaseline struct {
+	Namespace string  `json:"namespace,omitempty"`
+	Reason    string  `json:"reason"`
+	Total     int     `json:"total"`
+	Baseline  float64 `json:"baselinePerBucket"`
+	Peak      int     `json:"peak"`
+}
+
+func newEventAnomalies() *eventAnomalies {
+	return &eventAnomalies{series: map[seriesKey]*eventSeries{}}
+}
+
+func (a *eventAnomalies) observe(table string, row map[string]any) {
+	if table != "KubeEvents" {
+		return
+	}
+	ts := utils.ParseTimeRFC3339(toStr(row["TimeGenerated"]))
+	if ts.IsZero() {
+		return
+	}
+	bucket := ts.Unix() / int64(anomalyBucket/time.Second)
+	if len(a.series) == 0 || bucket < a.first {
+		a.first = bucket
+	}
+	if len(a.series) == 0 || bucket > a.last {
+		a.last = bucket
+	}
+	key := seriesKey{namespace: toStr(row["Namespace"]), reason: toStr(row["Reason"])}
+	s, ok := a.series[key]
+	if !ok {
+		s = &eventSeries{buckets: map[int64]*eventBucket{}}
+		a.series[key] = s
+	}
+	// Warning wins, so a reason reported as both is not hidden as Normal
+	if t := toStr(row["KubeEventType"]); s.kind != "Warning" {
+		s.kind = t
+	}
+	b, ok := s.buckets[bucket]
+	if !ok {
+		b = &eventBucket{sample: truncateSample(toStr(row["Message"])), objects: map[string]int{}}
+		s.buckets[bucket] = b
+	}
+	b.count++
+	b.objects[toStr(row["ObjectKind"])+"/"+toStr(row["Name"])]++
+	s.total++
+}
+
+func (a *eventAnomalies) endChunk(tarw *utils.TarWriter) error {
+	return nil
+}
+
+func (a *eventAnomalies) warnings(table string) []string {
+	return nil
+}
+
+func (a *eventAnomalies) finish(tarw *utils.TarWriter) error {
+	anomalies, baselines := a.detect()
+	out := map[string]any{
+		"bucketMinutes": int(anomalyBucket / time.Minute),
+		"anomalies":     anomalies,
+		"baselines":     baselines,
+	}
+	if len(a.series) > 0 {
+		out["from"] = bucketTime(a.first)
+		out["to"] = bucketTime(a.last + 1)
+	}
+	b, err := json.MarshalIndent(out, "", "  ")
+	if err != nil {
+		return err
+	}
+	return utils.WriteFileToTar(tarw, eventAnomaliesPath, b)
+}
+
+// detect returns the bursts in time order and the baselines of all series,
+// busiest first.
+func (a *eventAnomalies) detect() ([]*EventAnomaly, []*eventBaseline) {
+	anomalies := []*EventAnomaly{}
+	baselines := []*eventBaseline{}
+	for key, s := range a.series {
+		counts := make([]int, 0, a.last-a.first+1)
+		peak := 0
+		for i := a.first; i <= a.last; i++ {
+			n := 0
+			if b, ok := s.buckets[i]; ok {
+				n = b.count
+			}
+			counts = append(counts, n)
+			if n > peak {
+				peak = n
+			}
+		}
+		baseline := median(counts)
+		baselines = append(baselines, &eventBaseline{Namespace: key.namespace, Reason: key.reason, Total: s.total, Baseline: baseline, Peak: peak})
+
+		threshold := burstFactor * baseline
+		if threshold < burstMinEvents {
+			threshold = burstMinEvents
+		}
+		var cur *EventAnomaly
+		var objects map[string]int
+		flush := func() {
+			if cur == nil {
+				return
+			}
+			cur.Ratio = float64(cur.Peak) / max(baseline, 1)
+			cur.Objects = topObjects(objects, burstTopObjects)
+			anomalies = append(anomalies, cur)
+			cur = nil
+		}
+		for i := a.first; i <= a.last; i++ {
+			b, ok := s.buckets[i]
+			if !ok || float64(b.count) < threshold {
+				flush()
+				continue
+			}
+			if cur == nil {
+				cur = &EventAnomaly{Namespace: key.namespace, Reason: key.reason, Type: s.kind, Start: bucketTime(i), Baseline: baseline, Sample: b.sample}
+				objects = map[string]int{}
+			}
+			cur.End = bucketTime(i + 1)
+			cur.Count += b.count
+			if b.count > cur.Peak {
+				cur.Peak = b.count
+			}
+			for o, n := range b.objects {
+				objects[o] += n
+			}
+		}
+		flush()
+	}
+	sort.Slice(anomalies, func(i, j int) bool {
+		x, y := anomalies[i], anomalies[j]
+		if x.Start != y.Start {
+			return x.Start < y.Start
+		}
+		if x.Count != y.Count {
+			return x.Count > y.Count
+		}
+		return x.Namespace+"/"+x.Reason < y.Namespace+"/"+y.Reason
+	})
+	sort.Slice(baselines, func(i, j int) bool {
+		x, y := baselines[i], baselines[j]
+		if x.Total != y.Total {
+			return x.Total > y.Total
+		}
+		return x.Namespace+"/"+x.Reason < y.Namespace+"/"+y.Reason
+	})
+	return anomalies, baselines
+}
+
+func bucketTime(i int64) string {
+	return time.Unix(i*int64(anomalyBucket/time.Second), 0).UTC().Format(time.RFC3339)
+}
+
+func median(counts []int) float64 {
+	if len(counts) == 0 {
+		return 0
+	}
+	s := append([]int(nil), counts...)
+	sort.Ints(s)
+	if n := len(s); n%2 == 0 {
+		return float64(s[n/2-1]+s[n/2]) / 2
+	}
+	return float64(s[len(s)/2])
+}
+
+// topObjects returns up to n objects with the most events.
+func topObjects(objects map[string]int, n int) []string {
+	names := make([]string, 0, len(objects))
+	for o := range objects {
+		names = append(names, o)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if objects[names[i]] != objects[names[j]] {
+			return objects[names[i]] > objects[names[j]]
+		}
+		return names[i] < names[j]
+	})
+	if len(names) > n {
+		names = names[:n]
+	}
+	return names
+}
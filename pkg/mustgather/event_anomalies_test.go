@@ -0,0 +1,102 @@
+package mustgather
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestEventAnomalies(t *testing.T) {
+	a := newEventAnomalies()
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	event := func(at time.Duration, ns, reason, typ, name string) {
+		a.observe("KubeEvents", map[string]any{
+			"TimeGenerated": start.Add(at).Format(time.RFC3339), "Namespace": ns, "Reason": reason,
+			"KubeEventType": typ, "ObjectKind": "Pod", "Name": name, "Message": reason + " on " + name,
+		})
+	}
+	// A steady reason: 4 Unhealthy events every 5 minutes for an hour
+	for m := 0; m < 60; m += 5 {
+		for i := 0; i < 4; i++ {
+			event(time.Duration(m)*time.Minute+time.Duration(i)*time.Second, "shop", "Unhealthy", "Warning", "web-1")
+		}
+	}
+	// FailedMount bursts over two buckets at 00:30
+	for i := 0; i < 16; i++ {
+		event(30*time.Minute+time.Duration(i*30)*time.Second, "shop", "FailedMount", "Warning", fmt.Sprintf("db-%d", i%3))
+	}
+	// Too few to be a burst
+	for i := 0; i < 3; i++ {
+		event(40*time.Minute, "shop", "BackOff", "Warning", "cart-1")
+	}
+	a.observe("KubePodInventory", map[string]any{"TimeGenerated": start.Format(time.RFC3339), "Reason": "FailedMount"})
+
+	raw, ok := readTransform(t, a)[eventAnomaliesPath]
+	if !ok {
+		t.Fatalf("%s not written", eventAnomaliesPath)
+	}
+	var got struct {
+		BucketMinutes int              `json:"bucketMinutes"`
+		From          string           `json:"from"`
+		To            string           `json:"to"`
+		Anomalies     []*EventAnomaly  `json:"anomalies"`
+		Baselines     []*eventBaseline `json:"baselines"`
+	}
+	if err := json.Unmarshal([]byte(raw), &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.BucketMinutes != 5 || got.From != "2024-01-01T00:00:00Z" || got.To != "2024-01-01T01:00:00Z" || len(got.Baselines) != 3 {
+		t.Errorf("unexpected header or baselines:\n%s", raw)
+	}
+	if len(got.Anomalies) != 1 {
+		t.Fatalf("anomalies = %d, want only the FailedMount burst:\n%s", len(got.Anomalies), raw)
+	}
+	b := got.Anomalies[0]
+	if b.Reason != "FailedMount" || b.Type != "Warning" || b.Start != "2024-01-01T00:30:00Z" || b.End != "2024-01-01T00:40:00Z" ||
+		b.Count != 16 || b.Peak != 10 || b.Baseline != 0 || b.Ratio != 10 {
+		t.Errorf("burst = %+v", b)
+	}
+	if len(b.Objects) != 3 || b.Objects[0] != "Pod/db-0" || b.Sample != "FailedMount on db-0" {
+		t.Errorf("burst objects %v, sample %q", b.Objects, b.Sample)
+	}
+}
+
+func TestEventAnomaliesAgainstBaseline(t *testing.T) {
+	a := newEventAnomalies()
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	// 6 per bucket normally, 20 in one bucket: above 3x the baseline
+	for m := 0; m < 30; m += 5 {
+		n := 6
+		if m == 15 {
+			n = 20
+		}
+		for i := 0; i < n; i++ {
+			a.observe("KubeEvents", map[string]any{
+				"TimeGenerated": start.Add(time.Duration(m)*time.Minute + time.Duration(i)*time.Second).Format(time.RFC3339),
+				"Namespace":     "kube-system", "Reason": "Pulling", "KubeEventType": "Normal", "ObjectKind": "Pod", "Name": "ds-1",
+			})
+		}
+	}
+	anomalies, _ := a.detect()
+	if len(anomalies) != 1 || anomalies[0].Start != "2024-01-01T00:15:00Z" || anomalies[0].Baseline != 6 || anomalies[0].Type != "Normal" {
+		t.Errorf("anomalies = %+v", anomalies)
+	}
+}
+
+func TestMedian(t *testing.T) {
+	tests := []struct {
+		in   []int
+		want float64
+	}{
+		{nil, 0},
+		{[]int{3}, 3},
+		{[]int{5, 1, 3}, 3},
+		{[]int{0, 0, 4, 10}, 2},
+	}
+	for _, tt := range tests {
+		if got := median(tt.in); got != tt.want {
+			t.Errorf("median(%v) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,199 @@
+package mustgather
+
+import (
+	"bytes"
+	"encoding/json"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"kubectl-must-gather/pkg/utils"
+)
+
+// --event-objects formats, writing namespaces/<ns>/events/events.json or
+// events.yaml.
+const (
+	EventObjectsJSON = "json"
+	EventObjectsYAML = "yaml"
+)
+
+const eventObjectsHeader = "# Reconstructed from Log Analytics (KubeEvents).\n# Best effort: fields not recorded by Container Insights are missing.\n"
+
+// eventObjectsFile is the per-namespace file name of an event list format.
+func eventObjectsFile(format string) string {
+	return "events." + format
+}
+
+// eventObjects writes the KubeEvents of each namespace as a v1 EventList, so
+// tools expecting native Kubernetes events can read them. Container Insights
+// collects an event again whenever its count grows; only the latest report of
+// each event is kept.
+type eventObjects struct {
+	format string
+	events map[string]*eventSnapshot
+}
+
+type eventSnapshot struct {
+	tm  string
+	row map[string]any
+}
+
+func newEventObjects(format string) *eventObjects {
+	return &eventObjects{format: format, events: map[string]*eventSnapshot{}}
+}
+
+func (e *eventObjects) observe(table string, row map[string]any) {
+	if table != "KubeEvents" {
+		return
+	}
+	name := toStr(row["Name"])
+	if name == "" {
+		return
+	}
+	tm := toStr(row["TimeGenerated"])
+	first := toStr(row["FirstSeen"])
+	if first == "" {
+		// Without FirstSeen every report is its own event
+		first = tm
+	}
+	key := strings.Join([]string{eventNamespace(row), toStr(row["ObjectKind"]), name, toStr(row["Reason"]), toStr(row["Message"]), first}, "\x00")
+	if prev, ok := e.events[key]; !ok || later(tm, prev.tm) {
+		e.events[key] = &eventSnapshot{tm: tm, row: row}
+	}
+}
+
+func (e *eventObjects) endChunk(tarw *utils.TarWriter) error {
+	return nil
+}
+
+func (e *eventObjects) finish(tarw *utils.TarWriter) error {
+	byNamespace := map[string][]*eventSnapshot{}
+	for _, ev := range e.events {
+		ns := eventNamespace(ev.row)
+		byNamespace[ns] = append(byNamespace[ns], ev)
+	}
+	namespaces := make([]string, 0, len(byNamespace))
+	for ns := range byNamespace {
+		namespaces = append(namespaces, ns)
+	}
+	sort.Strings(namespaces)
+	for _, ns := range namespaces {
+		items := make([]map[string]any, 0, len(byNamespace[ns]))
+		for _, ev := range byNamespace[ns] {
+			items = append(items, kubeEventObject(ev.row))
+		}
+		sort.Slice(items, func(i, j int) bool {
+			if a, b := toStr(items[i]["lastTimestamp"]), toStr(items[j]["lastTimestamp"]); a != b {
+				return timeBefore(a, b)
+			}
+			return toStr(items[i]["metadata"].(map[string]any)["name"]) < toStr(items[j]["metadata"].(map[string]any)["name"])
+		})
+		list := map[string]any{
+			"apiVersion": "v1",
+			"kind":       "EventList",
+			"metadata":   map[string]any{},
+			"items":      items,
+		}
+		var buf bytes.Buffer
+		switch e.format {
+		case EventObjectsYAML:
+			buf.WriteString(eventObjectsHeader)
+			enc := yaml.NewEncoder(&buf)
+			enc.SetIndent(2)
+			if err := enc.Encode(list); err != nil {
+				return err
+			}
+		default:
+			enc := json.NewEncoder(&buf)
+			enc.SetIndent("", "  ")
+			if err := enc.Encode(list); err != nil {
+				return err
+			}
+		}
+		path := filepath.Join("namespaces", utils.SafeFileName(ns), "events", eventObjectsFile(e.format))
+		if err := utils.WriteFileToTar(tarw, path, buf.Bytes()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *eventObjects) warnings(table string) []string {
+	return nil
+}
+
+// eventNamespace is the namespace an event is filed under; cluster-scoped
+// events go to default, as in events.log.
+func eventNamespace(row map[string]any) string {
+	if ns := toStr(row["Namespace"]); ns != "" {
+		return ns
+	}
+	return "default"
+}
+
+// kubeEventObject builds a v1 Event from a KubeEvents row.
+func kubeEventObject(row map[string]any) map[string]any {
+	ns, name := eventNamespace(row), toStr(row["Name"])
+	tm := toStr(row["TimeGenerated"])
+	first, last := toStr(row["FirstSeen"]), toStr(row["LastSeen"])
+	if first == "" {
+		first = tm
+	}
+	if last == "" {
+		last = tm
+	}
+	// Events are named after their object and first occurrence, as the
+	// kubelet does
+	suffix := first
+	if t := utils.ParseTimeRFC3339(first); !t.IsZero() {
+		suffix = strconv.FormatInt(t.UnixNano(), 16)
+	}
+	first, last = eventTimestamp(first), eventTimestamp(last)
+	meta := map[string]any{
+		"name":      name + "." + suffix,
+		"namespace": ns,
+	}
+	setIf(meta, "creationTimestamp", first)
+
+	involved := map[string]any{"name": name}
+	setIf(involved, "kind", toStr(row["ObjectKind"]))
+	if toStr(row["Namespace"]) != "" {
+		involved["namespace"] = ns
+	}
+	obj := map[string]any{
+		"apiVersion":     "v1",
+		"kind":           "Event",
+		"metadata":       meta,
+		"involvedObject": involved,
+	}
+	setIf(obj, "reason", toStr(row["Reason"]))
+	setIf(obj, "message", toStr(row["Message"]))
+	setIf(obj, "type", toStr(row["KubeEventType"]))
+	source := map[string]any{}
+	setIf(source, "component", toStr(row["SourceComponent"]))
+	setIf(source, "host", toStr(row["Computer"]))
+	if len(source) > 0 {
+		obj["source"] = source
+	}
+	setIf(obj, "firstTimestamp", first)
+	setIf(obj, "lastTimestamp", last)
+	count := int64(1)
+	if n, err := strconv.ParseFloat(toStr(row["Count"]), 64); err == nil && n >= 1 {
+		count = int64(n)
+	}
+	obj["count"] = count
+	return obj
+}
+
+// eventTimestamp formats a time as the second-precision RFC 3339 of v1 Event
+// timestamps, keeping values that cannot be parsed.
+func eventTimestamp(v string) string {
+	if t := utils.ParseTimeRFC3339(v); !t.IsZero() {
+		return t.UTC().Format(time.RFC3339)
+	}
+	return v
+}
@@ -0,0 +1,81 @@
+package mustgather
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestEventObjects(t *testing.T) {
+	event := func(tm, ns, name, reason string, count float64) map[string]any {
+		return map[string]any{
+			"TimeGenerated": tm, "Namespace": ns, "Name": name, "ObjectKind": "Pod", "Reason": reason,
+			"Message": reason + " message", "KubeEventType": "Warning", "SourceComponent": "kubelet", "Computer": "aks-node-1",
+			"FirstSeen": "2024-01-01T00:01:00.5Z", "LastSeen": tm, "Count": count,
+		}
+	}
+	rows := []map[string]any{
+		// The same BackOff event reported twice as its count grows
+		event("2024-01-01T00:05:00Z", "shop", "cart-1", "BackOff", 3),
+		event("2024-01-01T00:03:00Z", "shop", "cart-1", "BackOff", 2),
+		event("2024-01-01T00:02:00Z", "shop", "web-1", "Unhealthy", 1),
+		{"TimeGenerated": "2024-01-01T00:04:00Z", "Namespace": "", "Name": "aks-node-1", "ObjectKind": "Node", "Reason": "NodeNotReady", "Message": "not ready"},
+		{"TimeGenerated": "2024-01-01T00:04:00Z", "Namespace": "shop", "Name": "", "Reason": "Ignored"},
+	}
+
+	tests := []struct {
+		name      string
+		format    string
+		path      string
+		unmarshal func([]byte, any) error
+	}{
+		{name: "json", format: EventObjectsJSON, path: "namespaces/shop/events/events.json", unmarshal: json.Unmarshal},
+		{name: "yaml", format: EventObjectsYAML, path: "namespaces/shop/events/events.yaml", unmarshal: yaml.Unmarshal},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := newEventObjects(tt.format)
+			for _, row := range rows {
+				e.observe("KubeEvents", row)
+			}
+			e.observe("KubePodInventory", map[string]any{"Name": "cart-1"})
+			files := readTransform(t, e)
+			if len(files) != 2 {
+				t.Fatalf("expected shop and default lists, got %v", files)
+			}
+
+			var list struct {
+				Kind  string
+				Items []map[string]any
+			}
+			if err := tt.unmarshal([]byte(files[tt.path]), &list); err != nil {
+				t.Fatalf("invalid %s: %v\n%s", tt.format, err, files[tt.path])
+			}
+			if list.Kind != "EventList" || len(list.Items) != 2 {
+				t.Fatalf("unexpected list: %+v", list)
+			}
+			// Ordered by lastTimestamp
+			web, cart := list.Items[0], list.Items[1]
+			if web["reason"] != "Unhealthy" {
+				t.Errorf("expected the earlier event first, got %v", web)
+			}
+			meta := cart["metadata"].(map[string]any)
+			involved := cart["involvedObject"].(map[string]any)
+			source := cart["source"].(map[string]any)
+			if cart["kind"] != "Event" || meta["name"] != "cart-1.17a610251779bd00" || meta["namespace"] != "shop" ||
+				involved["kind"] != "Pod" || involved["name"] != "cart-1" || involved["namespace"] != "shop" ||
+				cart["type"] != "Warning" || source["component"] != "kubelet" || source["host"] != "aks-node-1" ||
+				cart["firstTimestamp"] != "2024-01-01T00:01:00Z" || cart["lastTimestamp"] != "2024-01-01T00:05:00Z" ||
+				toStr(cart["count"]) != "3" {
+				t.Errorf("unexpected event: %v", cart)
+			}
+
+			cluster := files["namespaces/default/events/"+eventObjectsFile(tt.format)]
+			if !strings.Contains(cluster, "NodeNotReady") || strings.Contains(cluster, `namespace: default`) != (tt.format == EventObjectsYAML) {
+				t.Errorf("unexpected cluster-scoped events:\n%s", cluster)
+			}
+		})
+	}
+}
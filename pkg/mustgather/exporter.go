@@ -0,0 +1,114 @@
+package mustgather
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Exporter exports the rows of one table of a gather. The default exporter
+// reads a Log Analytics table as is; registering an Exporter adds a data
+// source, such as a custom table, Application Insights data or metrics
+// reached through a cross-resource query, or changes how a table is read.
+//
+// The gatherer runs Query once per chunk of the gather window, which it
+// applies as the query time range, and passes every row to Transform before
+// the audit scrubbing, anonymization and redaction of the gather.
+type Exporter interface {
+	// Query returns the KQL query reading table. Its rows need a
+	// TimeGenerated column for incremental gathers and stitched files.
+	Query(table string) string
+	// Transform adjusts row in place; returning false drops it.
+	Transform(table string, row map[string]any) bool
+}
+
+// QueryExporter is an Exporter reading a fixed query, with an optional
+// function transforming its rows.
+type QueryExporter struct {
+	// KQL is the query; empty reads the table itself.
+	KQL string
+	// RowFunc, when set, adjusts each row and returns false to drop it.
+	RowFunc func(row map[string]any) bool
+}
+
+// Query returns e.KQL, or table when it is empty.
+func (e QueryExporter) Query(table string) string {
+	if e.KQL == "" {
+		return table
+	}
+	return e.KQL
+}
+
+// Transform calls e.RowFunc, keeping every row when it is nil.
+func (e QueryExporter) Transform(_ string, row map[string]any) bool {
+	if e.RowFunc == nil {
+		return true
+	}
+	return e.RowFunc(row)
+}
+
+// defaultExporter exports the tables without a registered Exporter.
+var defaultExporter Exporter = QueryExporter{}
+
+var (
+	exportersMu sync.RWMutex
+	// exporters holds the registered exporters by lower-cased table name,
+	// as Log Analytics matches table names case-insensitively.
+	exporters = map[string]registeredExporter{}
+)
+
+type registeredExporter struct {
+	table    string
+	exporter Exporter
+}
+
+// RegisterExporter makes e export table in every later gather. A
+// registered table is gathered when it is requested, e.g. with --tables,
+// even if the workspace has no table of that name. RegisterExporter panics
+// if e is nil or table already has an exporter; it is meant to be called
+// from init functions.
+func RegisterExporter(table string, e Exporter) {
+	if table == "" {
+		panic("mustgather: RegisterExporter with an empty table name")
+	}
+	if e == nil {
+		panic("mustgather: RegisterExporter exporter is nil for " + table)
+	}
+	exportersMu.Lock()
+	defer exportersMu.Unlock()
+	key := strings.ToLower(table)
+	if r, dup := exporters[key]; dup {
+		panic(fmt.Sprintf("mustgather: RegisterExporter called twice for %s (registered as %s)", table, r.table))
+	}
+	exporters[key] = registeredExporter{table: table, exporter: e}
+}
+
+// Exporters returns the sorted names of the tables with a registered
+// Exporter.
+func Exporters() []string {
+	exportersMu.RLock()
+	defer exportersMu.RUnlock()
+	names := make([]string, 0, len(exporters))
+	for _, r := range exporters {
+		names = append(names, r.table)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// registeredExporterFor returns the Exporter registered for table, or nil.
+func registeredExporterFor(table string) Exporter {
+	exportersMu.RLock()
+	defer exportersMu.RUnlock()
+	return exporters[strings.ToLower(table)].exporter
+}
+
+// exporterFor returns the Exporter of table: the registered one, or the
+// default reading the table as is.
+func exporterFor(table string) Exporter {
+	if e := registeredExporterFor(table); e != nil {
+		return e
+	}
+	return defaultExporter
+}
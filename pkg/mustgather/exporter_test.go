@@ -0,0 +1,74 @@
+package mustgather
+
+import (
+	"context"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	azquery "github.com/Azure/azure-sdk-for-go/sdk/monitor/azquery"
+)
+
+func TestRegisterExporter(t *testing.T) {
+	// A virtual table reading the Heartbeat rows of nodes, without node-2
+	if registeredExporterFor("NodeHeartbeat") == nil {
+		RegisterExporter("NodeHeartbeat", QueryExporter{
+			KQL: `Heartbeat | where Computer startswith "node"`,
+			RowFunc: func(row map[string]any) bool {
+				row["Source"] = "exporter"
+				return row["Computer"] != "node-2"
+			},
+		})
+	}
+	if !slices.Contains(Exporters(), "NodeHeartbeat") {
+		t.Fatalf("expected NodeHeartbeat in %v", Exporters())
+	}
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Error("expected a panic registering a table twice")
+			}
+		}()
+		RegisterExporter("nodeheartbeat", QueryExporter{})
+	}()
+	if got := exporterFor("Syslog").Query("Syslog"); got != "Syslog" {
+		t.Errorf("unexpected default query %q", got)
+	}
+
+	ts := time.Now().Add(-10 * time.Minute).UTC().Format(time.RFC3339Nano)
+	logs := &fakeLogs{rows: map[string][]azquery.Row{"Heartbeat": {{ts, "node-1"}, {ts, "node-2"}}}}
+	out := filepath.Join(t.TempDir(), "bundle.tar.gz")
+	config := &Config{
+		WorkspaceID: "/subscriptions/sub/resourceGroups/rg/providers/Microsoft.OperationalInsights/workspaces/ws",
+		Timespan:    "PT1H",
+		OutputFile:  out,
+		TableFilter: "NodeHeartbeat",
+		Quiet:       true,
+	}
+	g, err := NewGatherer(context.Background(), config, WithCredential(noCredential{}), WithLogsClient(logs),
+		WithWorkspacesClient(fakeWorkspaces{}), WithTablesClient(fakeTables{names: []string{"Heartbeat"}}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := g.Run(); err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if len(logs.queries) == 0 || !strings.HasPrefix(logs.queries[0], `Heartbeat | where Computer startswith "node"`) {
+		t.Errorf("expected the exporter's query, got %q", logs.queries)
+	}
+	files := readArchive(t, out)
+	if got := files["tables/NodeHeartbeat/summary.json"]; !strings.Contains(got, `"rows": 1`) {
+		t.Errorf("unexpected NodeHeartbeat summary: %s", got)
+	}
+	var rows string
+	for name, content := range files {
+		if strings.HasPrefix(name, "tables/NodeHeartbeat/parts/") {
+			rows += content
+		}
+	}
+	if !strings.Contains(rows, `"Source":"exporter"`) || strings.Contains(rows, "node-2") {
+		t.Errorf("expected the transformed rows without node-2, got %q", rows)
+	}
+}
@@ -0,0 +1,337 @@
+package mustgather
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ExtractOptions selects the files Extract pulls out of a bundle.
+type ExtractOptions struct {
+	// Paths are slash-separated glob patterns of the files to extract,
+	// where ** matches any number of directories, e.g.
+	// namespaces/payments/**. A pattern matching a directory selects all
+	// of it. Empty selects every file.
+	Paths []string
+	// Since and Until limit the window (RFC 3339, or UTC like
+	// 2024-01-01T15:04): stitched logs are cut to the lines within it and
+	// table parts covering none of it are left out.
+	Since string
+	Until string
+	// Where is a RowFilter: table parts are cut to the rows matching it
+	// and left out when none do. Other files are extracted whole.
+	Where string
+	// OutDir is where the files are written, keeping their paths.
+	OutDir string
+}
+
+// ExtractStats counts what Extract wrote.
+type ExtractStats struct {
+	Files int
+	Bytes int64
+	// Trimmed is the number of logs cut to the window and parts cut to
+	// the rows matching Where, and Skipped the matching logs and parts
+	// with nothing left.
+	Trimmed int
+	Skipped int
+}
+
+// Extract copies the files of the bundle at src (archive or extracted
+// directory) that match opts to opts.OutDir. Archives are read as a stream,
+// so only the matching files are ever written to disk.
+func Extract(src string, opts ExtractOptions) (*ExtractStats, error) {
+	if opts.OutDir == "" {
+		return nil, errors.New("no output directory")
+	}
+	for _, p := range opts.Paths {
+		if _, err := path.Match(p, ""); err != nil {
+			return nil, fmt.Errorf("invalid path pattern %q: %w", p, err)
+		}
+	}
+	var w serveWindow
+	var err error
+	if w.since, err = parseServeTime(opts.Since); err != nil {
+		return nil, fmt.Errorf("invalid --since: %w", err)
+	}
+	if w.until, err = parseServeTime(opts.Until); err != nil {
+		return nil, fmt.Errorf("invalid --until: %w", err)
+	}
+	if !w.since.IsZero() && !w.until.IsZero() && !w.since.Before(w.until) {
+		return nil, errors.New("--since must be before --until")
+	}
+	var filter *RowFilter
+	if opts.Where != "" {
+		if filter, err = ParseRowFilter(opts.Where); err != nil {
+			return nil, fmt.Errorf("invalid --where: %w", err)
+		}
+	}
+
+	stats := &ExtractStats{}
+	copyFile := func(name string, r io.Reader) error {
+		if !matchExtractPaths(opts.Paths, name) {
+			return nil
+		}
+		windowed := !w.since.IsZero() || !w.until.IsZero()
+		if windowed && strings.HasSuffix(name, ".ndjson") && !partOverlaps(name, w) {
+			stats.Skipped++
+			return nil
+		}
+		if windowed && strings.HasSuffix(name, ".log") {
+			var buf bytes.Buffer
+			kept, dropped, err := trimStitchedLog(r, &buf, w)
+			if err != nil {
+				return fmt.Errorf("%s: %w", name, err)
+			}
+			if kept == 0 {
+				stats.Skipped++
+				return nil
+			}
+			if dropped > 0 {
+				stats.Trimmed++
+			}
+			r = &buf
+		}
+		if filter != nil && isTablePart(name) {
+			var buf bytes.Buffer
+			kept, dropped, err := filterRows(r, &buf, filter)
+			if err != nil {
+				return fmt.Errorf("%s: %w", name, err)
+			}
+			if kept == 0 {
+				stats.Skipped++
+				return nil
+			}
+			if dropped > 0 {
+				stats.Trimmed++
+			}
+			r = &buf
+		}
+		n, err := writeExtracted(opts.OutDir, name, r)
+		if err != nil {
+			return err
+		}
+		stats.Files++
+		stats.Bytes += n
+		return nil
+	}
+
+	err = walkBundle(src, copyFile)
+	if err != nil {
+		return stats, err
+	}
+	if stats.Files == 0 {
+		return stats, fmt.Errorf("no files of %s match", src)
+	}
+	return stats, nil
+}
+
+// walkBundle calls fn with the slash-separated name and content of every
+// file of the bundle at src, an archive or an extracted directory.
+func walkBundle(src string, fn func(name string, r io.Reader) error) error {
+	fi, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+	if !fi.IsDir() {
+		return walkArchive(src, fn)
+	}
+	return filepath.WalkDir(src, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(src, p)
+		if err != nil {
+			return err
+		}
+		f, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		return fn(filepath.ToSlash(rel), f)
+	})
+}
+
+// walkArchive calls fn with the name and content of every regular file of
+// the .tar.gz archive at src, rejecting names that would escape a directory.
+func walkArchive(src string, fn func(name string, r io.Reader) error) error {
+	f, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return fmt.Errorf("read %s: %w", src, err)
+	}
+	defer gz.Close()
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("read %s: %w", src, err)
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		name := path.Clean(hdr.Name)
+		if path.IsAbs(name) || name == ".." || strings.HasPrefix(name, "../") {
+			return fmt.Errorf("invalid path in archive: %s", hdr.Name)
+		}
+		if err := fn(name, tr); err != nil {
+			return err
+		}
+	}
+}
+
+// writeExtracted writes r to name under dir.
+func writeExtracted(dir, name string, r io.Reader) (int64, error) {
+	dst := filepath.Join(dir, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+		return 0, err
+	}
+	out, err := os.Create(dst)
+	if err != nil {
+		return 0, err
+	}
+	n, err := io.Copy(out, r)
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	return n, err
+}
+
+// matchExtractPaths reports whether name, or a directory holding it, matches
+// one of patterns. Empty patterns match everything.
+func matchExtractPaths(patterns []string, name string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	segs := strings.Split(name, "/")
+	for _, p := range patterns {
+		pat := strings.Split(strings.Trim(p, "/"), "/")
+		for n := len(segs); n > 0; n-- {
+			if matchSegments(pat, segs[:n]) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// matchSegments matches path segments against pattern segments, where **
+// stands for any number of segments.
+func matchSegments(pat, segs []string) bool {
+	for len(pat) > 0 {
+		if pat[0] == "**" {
+			for i := 0; i <= len(segs); i++ {
+				if matchSegments(pat[1:], segs[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(segs) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pat[0], segs[0]); !ok {
+			return false
+		}
+		pat, segs = pat[1:], segs[1:]
+	}
+	return len(segs) == 0
+}
+
+// partOverlaps reports whether the table part name covers some of w. Parts
+// named otherwise than partWindow expects are kept.
+func partOverlaps(name string, w serveWindow) bool {
+	start, end, ok := partWindow(name)
+	if !ok {
+		return true
+	}
+	return (w.until.IsZero() || start.Before(w.until)) && (w.since.IsZero() || end.After(w.since))
+}
+
+// partWindow returns the chunk a table part holds, from its name
+// parts/<n>-<start>_<end>.ndjson.
+func partWindow(name string) (start, end time.Time, ok bool) {
+	base := strings.TrimSuffix(path.Base(name), ".ndjson")
+	_, span, ok := strings.Cut(base, "-")
+	if !ok {
+		return start, end, false
+	}
+	from, to, ok := strings.Cut(span, "_")
+	if !ok {
+		return start, end, false
+	}
+	start, err1 := time.Parse(time.RFC3339, from)
+	end, err2 := time.Parse(time.RFC3339, to)
+	return start, end, err1 == nil && err2 == nil
+}
+
+// trimStitchedLog copies the lines of a stitched log within w from r to out.
+// Lines without a timestamp, such as stack traces, go with the line before
+// them; those before the first timestamp are kept.
+func trimStitchedLog(r io.Reader, out io.Writer, w serveWindow) (kept, dropped int, err error) {
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	bw := bufio.NewWriter(out)
+	keep := true
+	for sc.Scan() {
+		if ts, _, _, ok := parseStitchedLine(sc.Text()); ok {
+			keep = w.contains(ts)
+		}
+		if !keep {
+			dropped++
+			continue
+		}
+		kept++
+		bw.Write(sc.Bytes())
+		bw.WriteByte('\n')
+	}
+	if err := sc.Err(); err != nil {
+		return kept, dropped, err
+	}
+	return kept, dropped, bw.Flush()
+}
+
+// isTablePart reports whether name is an NDJSON part of an exported table.
+func isTablePart(name string) bool {
+	return strings.HasPrefix(name, "tables/") && strings.HasSuffix(name, ".ndjson")
+}
+
+// filterRows copies the NDJSON rows of r matching f to out.
+func filterRows(r io.Reader, out io.Writer, f *RowFilter) (kept, dropped int, err error) {
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	bw := bufio.NewWriter(out)
+	for sc.Scan() {
+		if len(bytes.TrimSpace(sc.Bytes())) == 0 {
+			continue
+		}
+		if !f.MatchLine(sc.Bytes()) {
+			dropped++
+			continue
+		}
+		kept++
+		bw.Write(sc.Bytes())
+		bw.WriteByte('\n')
+	}
+	if err := sc.Err(); err != nil {
+		return kept, dropped, err
+	}
+	return kept, dropped, bw.Flush()
+}
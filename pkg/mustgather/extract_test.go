@@ -0,0 +1,102 @@
+package mustgather
+
+import (
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"kubectl-must-gather/pkg/utils"
+)
+
+func TestExtract(t *testing.T) {
+	files := map[string]string{
+		"index.json": `{"tables":["KubeEvents"]}`,
+		"namespaces/payments/pods/api-1/api.log": "2024-01-01T00:00:01Z [stdout] starting\n" +
+			"2024-01-01T00:05:00Z [stderr] panic: boom\n" +
+			"  at main.go:12\n" +
+			"2024-01-01T00:20:00Z [stdout] recovered\n",
+		"namespaces/payments/pods/api-1/events.log":                                     "2024-01-01T00:30:00Z [Warning] BackOff: restarting\n",
+		"namespaces/shop/pods/cart-1/cart.log":                                          "2024-01-01T00:05:00Z [stdout] ok\n",
+		"tables/KubeEvents/parts/0000-2024-01-01T00:00:00Z_2024-01-01T00:10:00Z.ndjson": "{}\n",
+		"tables/KubeEvents/parts/0001-2024-01-01T00:10:00Z_2024-01-01T00:20:00Z.ndjson": "{}\n",
+	}
+	dir := writeBundleDir(t, files)
+	archive := filepath.Join(t.TempDir(), "bundle.tar.gz")
+	f, err := os.Create(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gz := gzip.NewWriter(f)
+	tw := utils.NewTarWriter(gz, time.Now())
+	for name, content := range files {
+		if err := utils.WriteFileToTar(tw, name, []byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tw.Close()
+	gz.Close()
+	f.Close()
+
+	for _, src := range []string{dir, archive} {
+		out := filepath.Join(t.TempDir(), "out")
+		stats, err := Extract(src, ExtractOptions{
+			Paths:  []string{"namespaces/payments/**", "tables/*/parts"},
+			Since:  "2024-01-01T00:02",
+			Until:  "2024-01-01T00:10:00Z",
+			OutDir: out,
+		})
+		if err != nil {
+			t.Fatalf("Extract(%s): %v", src, err)
+		}
+		var got []string
+		filepath.WalkDir(out, func(p string, d os.DirEntry, err error) error {
+			if err == nil && !d.IsDir() {
+				rel, _ := filepath.Rel(out, p)
+				got = append(got, filepath.ToSlash(rel))
+			}
+			return err
+		})
+		sort.Strings(got)
+		want := "namespaces/payments/pods/api-1/api.log,tables/KubeEvents/parts/0000-2024-01-01T00:00:00Z_2024-01-01T00:10:00Z.ndjson"
+		if strings.Join(got, ",") != want {
+			t.Errorf("extracted %v", got)
+		}
+		if stats.Files != 2 || stats.Trimmed != 1 || stats.Skipped != 2 {
+			t.Errorf("stats = %+v", stats)
+		}
+		b, _ := os.ReadFile(filepath.Join(out, "namespaces/payments/pods/api-1/api.log"))
+		if string(b) != "2024-01-01T00:05:00Z [stderr] panic: boom\n  at main.go:12\n" {
+			t.Errorf("trimmed log = %q", b)
+		}
+	}
+
+	if _, err := Extract(dir, ExtractOptions{Paths: []string{"nodes/**"}, OutDir: t.TempDir()}); err == nil || !strings.Contains(err.Error(), "no files") {
+		t.Errorf("no match = %v", err)
+	}
+	if _, err := Extract(dir, ExtractOptions{Since: "1h", OutDir: t.TempDir()}); err == nil || !strings.Contains(err.Error(), "--since") {
+		t.Errorf("duration since = %v", err)
+	}
+}
+
+func TestMatchExtractPaths(t *testing.T) {
+	for _, tt := range []struct {
+		pattern, name string
+		want          bool
+	}{
+		{"namespaces/payments/**", "namespaces/payments/pods/api-1/api.log", true},
+		{"namespaces/payments", "namespaces/payments/events/events.log", true},
+		{"namespaces/*/pods/cart-*", "namespaces/shop/pods/cart-1/cart.log", true},
+		{"**/events.log", "namespaces/shop/pods/cart-1/events.log", true},
+		{"**/*.json", "index.json", true},
+		{"namespaces/pay*", "namespaces/shop/pods/cart-1/cart.log", false},
+		{"analysis/*.json", "analysis/utilization/report.md", false},
+	} {
+		if got := matchExtractPaths([]string{tt.pattern}, tt.name); got != tt.want {
+			t.Errorf("match(%s, %s) = %v, want %v", tt.pattern, tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,271 @@
+package mustgather
+
+import (
+	"encoding/json"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+
+	"kubectl-must-gather/pkg/utils"
+)
+
+// findingsPath is where the known-issue rules write what they matched.
+const findingsPath = "analysis/findings.json"
+
+// Severities of findings, most severe first.
+const (
+	severityCritical = "critical"
+	severityWarning  = "warning"
+)
+
+// rule detects a known issue in exported rows. match reports the object a
+// row shows the issue on; rows of other tables or without the issue do not
+// match.
+type rule interface {
+	id() string
+	severity() string
+	description() string
+	match(table string, row map[string]any) (subject, bool)
+}
+
+// subject is the object a finding is about. container is set when the rule
+// can tell which container of a pod is affected.
+type subject struct {
+	namespace, kind, name, container string
+}
+
+// knownIssue is a rule matching KubeEvents by reason and message and,
+// optionally, container log lines by pattern.
+type knownIssue struct {
+	ruleID, sev, desc string
+	// event reports whether an event shows the issue; nil skips events.
+	event func(kind, reason, message string) bool
+	// log matches container log lines showing the issue; nil skips logs.
+	log *regexp.Regexp
+}
+
+func (k *knownIssue) id() string          { return k.ruleID }
+func (k *knownIssue) severity() string    { return k.sev }
+func (k *knownIssue) description() string { return k.desc }
+
+func (k *knownIssue) match(table string, row map[string]any) (subject, bool) {
+	switch {
+	case table == "KubeEvents" && k.event != nil:
+		kind, reason, msg := toStr(row["ObjectKind"]), toStr(row["Reason"]), toStr(row["Message"])
+		if !k.event(kind, reason, msg) {
+			return subject{}, false
+		}
+		s := subject{namespace: toStr(row["Namespace"]), kind: kind, name: toStr(row["Name"])}
+		if m := failedContainer.FindStringSubmatch(msg); m != nil {
+			s.container = m[1]
+		}
+		return s, true
+	case table == "ContainerLogV2" && k.log != nil:
+		if !k.log.MatchString(stitchMessage(row["LogMessage"])) {
+			return subject{}, false
+		}
+		return subject{namespace: toStr(row["PodNamespace"]), kind: "Pod", name: toStr(row["PodName"]), container: toStr(row["ContainerName"])}, true
+	}
+	return subject{}, false
+}
+
+// failedContainer picks the container out of kubelet back-off events, e.g.
+// "Back-off restarting failed container cart in pod cart-1_shop(...)".
+var failedContainer = regexp.MustCompile(`failed container "?([\w.-]+)"?`)
+
+// imagePullError matches the kubelet's image pull failures.
+var imagePullError = regexp.MustCompile(`ErrImagePull|ImagePullBackOff|Back-off pulling image|Failed to pull image`)
+
+func reasonIn(reasons ...string) func(kind, reason, message string) bool {
+	return func(_, reason, _ string) bool {
+		for _, r := range reasons {
+			if reason == r {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// builtinRules are the known issues every gather is scanned for.
+var builtinRules = []rule{
+	&knownIssue{
+		ruleID: "CrashLoopBackOff", sev: severityCritical,
+		desc: "Container keeps exiting and kubelet backs off restarting it",
+		event: func(_, reason, msg string) bool {
+			return reason == "BackOff" && strings.Contains(msg, "restarting failed container")
+		},
+	},
+	&knownIssue{
+		ruleID: "ImagePullBackOff", sev: severityCritical,
+		desc: "Container image cannot be pulled",
+		event: func(_, reason, msg string) bool {
+			return (reason == "Failed" || reason == "BackOff" || reason == "ErrImagePull") && imagePullError.MatchString(msg)
+		},
+	},
+	&knownIssue{
+		ruleID: "OOMKilled", sev: severityCritical,
+		desc: "Process killed or failing for running out of memory",
+		event: func(_, reason, msg string) bool {
+			return isOOMEvent(reason, msg)
+		},
+		log: regexp.MustCompile(`OOMKilled|java\.lang\.OutOfMemoryError|fatal error: runtime: out of memory|Cannot allocate memory`),
+	},
+	&knownIssue{
+		ruleID: "FailedScheduling", sev: severityWarning,
+		desc:  "Pod cannot be scheduled onto any node",
+		event: reasonIn("FailedScheduling"),
+	},
+	&knownIssue{
+		ruleID: "NodeNotReady", sev: severityCritical,
+		desc: "Node stopped reporting Ready",
+		event: func(kind, reason, _ string) bool {
+			return strings.EqualFold(kind, "Node") && (reason == "NodeNotReady" || reason == "NodeStatusUnknown")
+		},
+	},
+	&knownIssue{
+		ruleID: "Evicted", sev: severityWarning,
+		desc:  "Pod evicted from its node, e.g. for node pressure or a NoExecute taint",
+		event: reasonIn("Evicted", "TaintManagerEviction"),
+	},
+}
+
+// Finding is a known issue matched on one object, as written to
+// analysis/findings.json.
+type Finding struct {
+	Rule        string `json:"rule"`
+	Severity    string `json:"severity"`
+	Description string `json:"description"`
+	Namespace   string `json:"namespace,omitempty"`
+	Kind        string `json:"kind,omitempty"`
+	Name        string `json:"name"`
+	Container   string `json:"container,omitempty"`
+	Count       int    `json:"count"`
+	FirstSeen   string `json:"firstSeen"`
+	LastSeen    string `json:"lastSeen"`
+	// Message is the latest matching event message or log line.
+	Message string `json:"message"`
+	// Evidence lists the archive files holding the matched rows.
+	Evidence []string `json:"evidence"`
+}
+
+// findings runs the known-issue rules over KubeEvents and ContainerLogV2
+// rows and writes analysis/findings.json. Evidence paths point at the
+// table's parts and, when logs are stitched, at the stitched files the rows
+// went to.
+type findings struct {
+	config *Config
+	rules  []rule
+	found  map[string]*Finding
+	// evidence collects each finding's paths as a set.
+	evidence map[string]map[string]bool
+}
+
+func newFindings(config *Config, rules []rule) *findings {
+	return &findings{config: config, rules: rules, found: map[string]*Finding{}, evidence: map[string]map[string]bool{}}
+}
+
+func (f *findings) observe(table string, row map[string]any) {
+	if table != "KubeEvents" && table != "ContainerLogV2" {
+		return
+	}
+	for _, r := range f.rules {
+		s, ok := r.match(table, row)
+		if !ok {
+			continue
+		}
+		key := strings.Join([]string{r.id(), s.namespace, s.kind, s.name, s.container}, "/")
+		fd, ok := f.found[key]
+		if !ok {
+			fd = &Finding{Rule: r.id(), Severity: r.severity(), Description: r.description(),
+				Namespace: s.namespace, Kind: s.kind, Name: s.name, Container: s.container}
+			f.found[key] = fd
+			f.evidence[key] = map[string]bool{}
+		}
+		fd.Count++
+		tm := toStr(row["TimeGenerated"])
+		if fd.FirstSeen == "" || timeBefore(tm, fd.FirstSeen) {
+			fd.FirstSeen = tm
+		}
+		if later(tm, fd.LastSeen) {
+			fd.LastSeen = tm
+			if table == "KubeEvents" {
+				fd.Message = truncateSample(toStr(row["Message"]))
+			} else {
+				fd.Message = truncateSample(stitchMessage(row["LogMessage"]))
+			}
+		}
+		for _, p := range f.evidencePaths(table, s) {
+			f.evidence[key][p] = true
+		}
+	}
+}
+
+// evidencePaths lists the files a matched row of table is written to.
+func (f *findings) evidencePaths(table string, s subject) []string {
+	paths := []string{path.Join("tables", utils.SafeFileName(table), "parts") + "/"}
+	if !f.config.StitchLogs {
+		return paths
+	}
+	switch table {
+	case "KubeEvents":
+		if !f.config.StitchIncludeEvents {
+			break
+		}
+		paths = append(paths, path.Join("namespaces", utils.SafeFileName(s.namespace), "events", "events.log"))
+		if strings.EqualFold(s.kind, "Pod") && s.name != "" {
+			paths = append(paths, podFile(s.namespace, s.name, "events.log"))
+		}
+	case "ContainerLogV2":
+		if s.name != "" && s.container != "" {
+			paths = append(paths, podFile(s.namespace, s.name, utils.SafeFileName(s.container)+".log"))
+		}
+	}
+	return paths
+}
+
+func (f *findings) endChunk(tarw *utils.TarWriter) error {
+	return nil
+}
+
+func (f *findings) warnings(table string) []string {
+	return nil
+}
+
+// finish writes the findings, most severe and most frequent first.
+func (f *findings) finish(tarw *utils.TarWriter) error {
+	out := f.list()
+	rules := make([]string, 0, len(f.rules))
+	for _, r := range f.rules {
+		rules = append(rules, r.id())
+	}
+	b, err := json.MarshalIndent(map[string]any{"rules": rules, "findings": out}, "", "  ")
+	if err != nil {
+		return err
+	}
+	return utils.WriteFileToTar(tarw, findingsPath, b)
+}
+
+func (f *findings) list() []*Finding {
+	out := make([]*Finding, 0, len(f.found))
+	for key, fd := range f.found {
+		fd.Evidence = fd.Evidence[:0]
+		for p := range f.evidence[key] {
+			fd.Evidence = append(fd.Evidence, p)
+		}
+		sort.Strings(fd.Evidence)
+		out = append(out, fd)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		a, b := out[i], out[j]
+		if a.Severity != b.Severity {
+			return a.Severity == severityCritical
+		}
+		if a.Count != b.Count {
+			return a.Count > b.Count
+		}
+		return a.Rule+"/"+a.Namespace+"/"+a.Name+"/"+a.Container < b.Rule+"/"+b.Namespace+"/"+b.Name+"/"+b.Container
+	})
+	return out
+}
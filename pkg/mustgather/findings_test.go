@@ -0,0 +1,149 @@
+package mustgather
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestKnownIssueRules(t *testing.T) {
+	tests := []struct {
+		name    string
+		table   string
+		row     map[string]any
+		want    string
+		subject subject
+	}{
+		{
+			name:    "crash loop",
+			table:   "KubeEvents",
+			row:     map[string]any{"Namespace": "shop", "ObjectKind": "Pod", "Name": "cart-1", "Reason": "BackOff", "Message": "Back-off restarting failed container cart in pod cart-1_shop(uid)"},
+			want:    "CrashLoopBackOff",
+			subject: subject{namespace: "shop", kind: "Pod", name: "cart-1", container: "cart"},
+		},
+		{
+			name:    "image pull",
+			table:   "KubeEvents",
+			row:     map[string]any{"Namespace": "shop", "ObjectKind": "Pod", "Name": "web-1", "Reason": "Failed", "Message": `Failed to pull image "web:bad": not found`},
+			want:    "ImagePullBackOff",
+			subject: subject{namespace: "shop", kind: "Pod", name: "web-1"},
+		},
+		{
+			name:    "OOM event",
+			table:   "KubeEvents",
+			row:     map[string]any{"Namespace": "", "ObjectKind": "Node", "Name": "aks-node-1", "Reason": "OOMKilling", "Message": "Memory cgroup out of memory: Killed process 42"},
+			want:    "OOMKilled",
+			subject: subject{kind: "Node", name: "aks-node-1"},
+		},
+		{
+			name:    "OOM log",
+			table:   "ContainerLogV2",
+			row:     map[string]any{"PodNamespace": "shop", "PodName": "api-1", "ContainerName": "api", "LogMessage": "Exception in thread main java.lang.OutOfMemoryError: Java heap space"},
+			want:    "OOMKilled",
+			subject: subject{namespace: "shop", kind: "Pod", name: "api-1", container: "api"},
+		},
+		{
+			name:    "scheduling",
+			table:   "KubeEvents",
+			row:     map[string]any{"Namespace": "shop", "ObjectKind": "Pod", "Name": "big-1", "Reason": "FailedScheduling", "Message": "0/3 nodes are available: 3 Insufficient cpu."},
+			want:    "FailedScheduling",
+			subject: subject{namespace: "shop", kind: "Pod", name: "big-1"},
+		},
+		{
+			name:    "node not ready",
+			table:   "KubeEvents",
+			row:     map[string]any{"ObjectKind": "Node", "Name": "aks-node-2", "Reason": "NodeNotReady", "Message": "Node aks-node-2 status is now: NodeNotReady"},
+			want:    "NodeNotReady",
+			subject: subject{kind: "Node", name: "aks-node-2"},
+		},
+		{
+			name:    "eviction",
+			table:   "KubeEvents",
+			row:     map[string]any{"Namespace": "shop", "ObjectKind": "Pod", "Name": "cache-1", "Reason": "Evicted", "Message": "The node was low on resource: memory."},
+			want:    "Evicted",
+			subject: subject{namespace: "shop", kind: "Pod", name: "cache-1"},
+		},
+		{
+			name:  "pod NodeNotReady is not a node finding",
+			table: "KubeEvents",
+			row:   map[string]any{"Namespace": "shop", "ObjectKind": "Pod", "Name": "cart-1", "Reason": "NodeNotReady", "Message": "Node is not ready"},
+		},
+		{
+			name:  "ordinary log line",
+			table: "ContainerLogV2",
+			row:   map[string]any{"PodNamespace": "shop", "PodName": "api-1", "ContainerName": "api", "LogMessage": "GET /healthz 200"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var matched []string
+			for _, r := range builtinRules {
+				if s, ok := r.match(tt.table, tt.row); ok {
+					matched = append(matched, r.id())
+					if s != tt.subject {
+						t.Errorf("%s subject = %+v, want %+v", r.id(), s, tt.subject)
+					}
+				}
+			}
+			if got := strings.Join(matched, ","); got != tt.want {
+				t.Errorf("matched %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFindingsWritten(t *testing.T) {
+	f := newFindings(&Config{StitchLogs: true, StitchIncludeEvents: true}, builtinRules)
+	for _, tm := range []string{"2024-01-01T00:05:00Z", "2024-01-01T00:01:00Z"} {
+		f.observe("KubeEvents", map[string]any{
+			"TimeGenerated": tm, "Namespace": "shop", "ObjectKind": "Pod", "Name": "cart-1",
+			"Reason": "BackOff", "Message": "Back-off restarting failed container cart in pod cart-1_shop(uid) at " + tm,
+		})
+	}
+	f.observe("KubeEvents", map[string]any{
+		"TimeGenerated": "2024-01-01T00:02:00Z", "Namespace": "shop", "ObjectKind": "Pod", "Name": "big-1",
+		"Reason": "FailedScheduling", "Message": "0/3 nodes are available",
+	})
+	f.observe("ContainerLogV2", map[string]any{
+		"TimeGenerated": "2024-01-01T00:03:00Z", "PodNamespace": "shop", "PodName": "api-1", "ContainerName": "api",
+		"LogMessage": "fatal error: runtime: out of memory",
+	})
+	f.observe("Heartbeat", map[string]any{"Reason": "BackOff", "Message": "restarting failed container"})
+
+	raw, ok := readTransform(t, f)[findingsPath]
+	if !ok {
+		t.Fatalf("%s not written", findingsPath)
+	}
+	var got struct {
+		Rules    []string  `json:"rules"`
+		Findings []Finding `json:"findings"`
+	}
+	if err := json.Unmarshal([]byte(raw), &got); err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Rules) != len(builtinRules) || len(got.Findings) != 3 {
+		t.Fatalf("findings.json = %s", raw)
+	}
+	crash := got.Findings[0]
+	if crash.Rule != "CrashLoopBackOff" || crash.Count != 2 || crash.FirstSeen != "2024-01-01T00:01:00Z" || crash.LastSeen != "2024-01-01T00:05:00Z" || !strings.HasSuffix(crash.Message, "00:05:00Z") {
+		t.Errorf("crash loop finding = %+v", crash)
+	}
+	if want := "namespaces/shop/events/events.log,namespaces/shop/pods/cart-1/events.log,tables/KubeEvents/parts/"; strings.Join(crash.Evidence, ",") != want {
+		t.Errorf("evidence = %v, want %s", crash.Evidence, want)
+	}
+	if oom := got.Findings[1]; oom.Rule != "OOMKilled" || strings.Join(oom.Evidence, ",") != "namespaces/shop/pods/api-1/api.log,tables/ContainerLogV2/parts/" {
+		t.Errorf("critical findings should come first, got %+v", oom)
+	}
+	if got.Findings[2].Rule != "FailedScheduling" || got.Findings[2].Severity != severityWarning {
+		t.Errorf("warning finding = %+v", got.Findings[2])
+	}
+}
+
+func TestFindingsEvidenceWithoutStitching(t *testing.T) {
+	f := newFindings(&Config{}, builtinRules)
+	f.observe("KubeEvents", map[string]any{"Namespace": "shop", "ObjectKind": "Pod", "Name": "cache-1", "Reason": "Evicted"})
+	fd := f.list()
+	if len(fd) != 1 || strings.Join(fd[0].Evidence, ",") != "tables/KubeEvents/parts/" {
+		t.Errorf("findings = %+v", fd)
+	}
+}
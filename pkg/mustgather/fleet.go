@@ -0,0 +1,463 @@
+package mustgather
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"kubectl-must-gather/pkg/bundle"
+	"kubectl-must-gather/pkg/kubeconfig"
+	"kubectl-must-gather/pkg/utils"
+)
+
+// Files of a fleet archive besides the clusters/<name>/ trees.
+const (
+	fleetSummaryPath = "metadata/fleet.json"
+	fleetReportPath  = "FLEET.md"
+)
+
+// FleetCluster is a cluster of a --fleet file. Its workspace is WorkspaceID,
+// else the Container Insights workspace of ClusterID, else that of the AKS
+// cluster of the kubeconfig Context.
+type FleetCluster struct {
+	Name        string `yaml:"name"`
+	WorkspaceID string `yaml:"workspaceID"`
+	ClusterID   string `yaml:"clusterID"`
+	Context     string `yaml:"context"`
+}
+
+// Fleet is the content of a --fleet file.
+type Fleet struct {
+	Clusters []FleetCluster `yaml:"clusters"`
+}
+
+// LoadFleet reads a fleet file, e.g.
+//
+//	clusters:
+//	  - name: prod-eu
+//	    workspaceID: /subscriptions/<sub>/resourceGroups/<rg>/providers/Microsoft.OperationalInsights/workspaces/<ws>
+//	  - name: prod-us
+//	    clusterID: /subscriptions/<sub>/resourceGroups/<rg>/providers/Microsoft.ContainerService/managedClusters/<name>
+//	  - context: staging
+//
+// A cluster without a name is named after its context, cluster or workspace.
+func LoadFleet(path string) (*Fleet, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read fleet: %w", err)
+	}
+	var f Fleet
+	dec := yaml.NewDecoder(strings.NewReader(string(b)))
+	dec.KnownFields(true)
+	if err := dec.Decode(&f); err != nil {
+		return nil, fmt.Errorf("parse fleet %s: %w", path, err)
+	}
+	if err := f.check(); err != nil {
+		return nil, fmt.Errorf("fleet %s: %w", path, err)
+	}
+	return &f, nil
+}
+
+// check names the unnamed clusters and rejects clusters without a target and
+// names used twice.
+func (f *Fleet) check() error {
+	if len(f.Clusters) == 0 {
+		return errors.New("no clusters")
+	}
+	seen := map[string]bool{}
+	for i := range f.Clusters {
+		c := &f.Clusters[i]
+		if c.WorkspaceID == "" && c.ClusterID == "" && c.Context == "" {
+			return fmt.Errorf("cluster %d needs a workspaceID, clusterID or context", i+1)
+		}
+		if c.Name == "" {
+			for _, n := range []string{c.Context, c.ClusterID, c.WorkspaceID} {
+				if n != "" {
+					c.Name = path.Base(strings.TrimSuffix(n, "/"))
+					break
+				}
+			}
+		}
+		c.Name = utils.SafeFileName(c.Name)
+		if seen[c.Name] {
+			return fmt.Errorf("cluster name %s is used twice; set distinct names", c.Name)
+		}
+		seen[c.Name] = true
+	}
+	return nil
+}
+
+// FleetOptions configures GatherFleet.
+type FleetOptions struct {
+	// Parallel is the number of clusters gathered at once; 1 when not
+	// positive.
+	Parallel int
+	// Separate writes an archive per cluster, named after OutputFile with
+	// the cluster name inserted, instead of one archive holding them all.
+	Separate bool
+	// Environment overrides the Azure endpoints of every gather, as with
+	// NewGathererWithEnvironment.
+	Environment *Environment
+}
+
+// FleetSummary is metadata/fleet.json: the outcome of each cluster's gather
+// and the findings they share.
+type FleetSummary struct {
+	GeneratedAt string               `json:"generatedAt"`
+	Clusters    []FleetClusterResult `json:"clusters"`
+	// Findings are the known issues found, grouped by rule across clusters,
+	// those on the most clusters first.
+	Findings []FleetFinding `json:"findings"`
+}
+
+// FleetClusterResult is the outcome of the gather of one cluster.
+type FleetClusterResult struct {
+	Name        string `json:"name"`
+	WorkspaceID string `json:"workspaceID,omitempty"`
+	ClusterID   string `json:"clusterID,omitempty"`
+	// Archive is the cluster's own archive, or its directory in the fleet
+	// archive.
+	Archive     string   `json:"archive,omitempty"`
+	Status      string   `json:"status"`
+	Error       string   `json:"error,omitempty"`
+	WindowStart string   `json:"windowStart,omitempty"`
+	WindowEnd   string   `json:"windowEnd,omitempty"`
+	Duration    string   `json:"duration"`
+	Tables      int      `json:"tables"`
+	Rows        int64    `json:"rows"`
+	Bytes       int64    `json:"bytes"`
+	Warnings    []string `json:"warnings"`
+	Findings    int      `json:"findings"`
+
+	findings []*Finding
+}
+
+// FleetFinding is a known-issue rule matched on one or more clusters.
+type FleetFinding struct {
+	Rule        string   `json:"rule"`
+	Severity    string   `json:"severity"`
+	Description string   `json:"description"`
+	Clusters    []string `json:"clusters"`
+	// Objects is the number of objects the rule matched across clusters,
+	// and Count the rows that showed it.
+	Objects int `json:"objects"`
+	Count   int `json:"count"`
+}
+
+// GatherFleet gathers every cluster of fleet, Parallel at a time, each with
+// config aimed at its workspace. The archives are combined into OutputFile,
+// one clusters/<name>/ tree per cluster next to metadata/fleet.json and
+// FLEET.md, or kept apart with Separate. A failed cluster does not stop the
+// others; GatherFleet returns an error naming the failed ones once all are
+// done and the summary is written.
+func GatherFleet(ctx context.Context, config *Config, fleet *Fleet, opts FleetOptions) (*FleetSummary, error) {
+	if err := config.checkFleet(opts.Separate); err != nil {
+		return nil, err
+	}
+	log := config.logOutput()
+	out := config.GenerateDefaultOutputName()
+	var tmp string
+	if !opts.Separate {
+		var err error
+		if tmp, err = os.MkdirTemp("", "aks-must-gather-fleet-"); err != nil {
+			return nil, err
+		}
+		defer os.RemoveAll(tmp)
+	}
+
+	results := make([]FleetClusterResult, len(fleet.Clusters))
+	sem := make(chan struct{}, max(1, opts.Parallel))
+	var wg sync.WaitGroup
+	fmt.Fprintf(log, "Gathering %d clusters, %d at a time...\n", len(fleet.Clusters), max(1, opts.Parallel))
+	for i, c := range fleet.Clusters {
+		archive := filepath.Join(tmp, c.Name+".tar.gz")
+		if opts.Separate {
+			archive = fleetArchiveName(out, c.Name)
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			select {
+			case sem <- struct{}{}:
+				defer func() { <-sem }()
+			case <-ctx.Done():
+			}
+			results[i] = gatherFleetCluster(ctx, config, c, archive, opts.Environment)
+			r := &results[i]
+			switch r.Status {
+			case "ok":
+				fmt.Fprintf(log, "[%s] gathered %d rows from %d tables\n", c.Name, r.Rows, r.Tables)
+			default:
+				fmt.Fprintf(log, "[%s] gather %s: %s\n", c.Name, r.Status, r.Error)
+			}
+		}()
+	}
+	wg.Wait()
+
+	sum := newFleetSummary(results)
+	if !opts.Separate {
+		if err := writeFleetArchive(out, sum); err != nil {
+			return sum, err
+		}
+		fmt.Fprintf(log, "Wrote %s\n", out)
+	} else {
+		b, _ := json.MarshalIndent(sum, "", "  ")
+		summaryFile := strings.TrimSuffix(out, ".tar.gz") + "-fleet.json"
+		if err := os.WriteFile(summaryFile, append(b, '\n'), 0o644); err != nil {
+			return sum, fmt.Errorf("write fleet summary: %w", err)
+		}
+		fmt.Fprintf(log, "Wrote fleet summary %s\n", summaryFile)
+	}
+
+	var failed []string
+	for _, r := range sum.Clusters {
+		if r.Status != "ok" {
+			failed = append(failed, r.Name)
+		}
+	}
+	if ctx.Err() != nil {
+		return sum, markErr(ErrPartialData, fmt.Errorf("fleet gather interrupted, %d of %d clusters incomplete: %w", len(failed), len(sum.Clusters), ctx.Err()))
+	}
+	if len(failed) > 0 {
+		return sum, fmt.Errorf("gathers of %d of %d clusters failed: %s", len(failed), len(sum.Clusters), strings.Join(failed, ", "))
+	}
+	return sum, nil
+}
+
+// checkFleet rejects settings that do not apply to the gathers of a fleet:
+// those writing to stdout or a shared state, and, in a combined archive,
+// those acting on a cluster's archive, which is only an intermediate file.
+func (c *Config) checkFleet(separate bool) error {
+	switch {
+	case c.AIMode:
+		return errors.New("--fleet applies to regular gathers, not AI mode")
+	case c.OutputJSON || c.ProgressFormat == ProgressJSON:
+		return errors.New("--fleet cannot be combined with --output-json or --progress-format json; read metadata/fleet.json instead")
+	case c.SinceLastRun:
+		return errors.New("--fleet cannot be combined with --since-last-run")
+	case c.InCluster:
+		return errors.New("--fleet cannot be combined with --in-cluster")
+	}
+	if separate {
+		return nil
+	}
+	for flag, set := range map[string]bool{
+		"--encrypt-to":     len(c.EncryptTo) > 0,
+		"--restricted-out": c.RestrictedOutput != "",
+		"--sign-key":       c.SignKey != "",
+		"--upload-to":      c.UploadTo != "",
+		"--keep":           c.Keep > 0 || c.KeepDays > 0,
+		"--notify-webhook": c.NotifyWebhook != "",
+	} {
+		if set {
+			return fmt.Errorf("%s applies to each cluster's archive: combine it with --fleet-separate", flag)
+		}
+	}
+	return nil
+}
+
+// fleetArchiveName inserts the name of a cluster into the archive name out.
+func fleetArchiveName(out, name string) string {
+	dir, base := filepath.Split(out)
+	ext := filepath.Ext(base)
+	if strings.HasSuffix(base, ".tar.gz") {
+		ext = ".tar.gz"
+	}
+	return dir + strings.TrimSuffix(base, ext) + "-" + name + ext
+}
+
+// gatherFleetCluster resolves the workspace of c and gathers it to archive
+// with a copy of config, quietly: the fleet reports each cluster's outcome.
+func gatherFleetCluster(ctx context.Context, config *Config, c FleetCluster, archive string, env *Environment) FleetClusterResult {
+	r := FleetClusterResult{Name: c.Name, WorkspaceID: c.WorkspaceID, ClusterID: c.ClusterID, Status: "failed", Warnings: []string{}}
+	startedAt := time.Now()
+	defer func() { r.Duration = time.Since(startedAt).Round(time.Second).String() }()
+	if ctx.Err() != nil {
+		r.Status, r.Error = "incomplete", "not started: "+ctx.Err().Error()
+		return r
+	}
+	cfg := *config
+	cfg.OutputFile, cfg.Quiet = archive, true
+	cfg.WorkspaceID, cfg.ClusterID = c.WorkspaceID, c.ClusterID
+	if cfg.WorkspaceID == "" {
+		environment := Environment{}
+		if env != nil {
+			environment = *env
+		}
+		kc := &kubeconfig.Context{ClusterResourceID: c.ClusterID}
+		if c.ClusterID == "" {
+			var err error
+			if kc, err = kubeconfig.Load(config.Kubeconfig, c.Context); err != nil {
+				r.Error = err.Error()
+				return r
+			}
+		}
+		ws, cluster, err := InferWorkspace(ctx, environment, kc)
+		if err != nil {
+			r.Error = err.Error()
+			return r
+		}
+		cfg.WorkspaceID, cfg.ClusterID = ws, cluster
+		r.WorkspaceID, r.ClusterID = ws, cluster
+	}
+
+	g, res, err := runGather(ctx, &cfg, env)
+	switch {
+	case ctx.Err() != nil:
+		r.Status = "incomplete"
+	case err == nil:
+		r.Status = "ok"
+	}
+	if err != nil {
+		r.Error = err.Error()
+	}
+	if g == nil {
+		return r
+	}
+	r.Archive, r.WindowStart, r.WindowEnd = res.Archive, res.WindowStart, res.WindowEnd
+	r.Tables = len(g.completed)
+	r.Rows, r.Bytes = res.Rows, res.Bytes
+	r.Warnings = append(r.Warnings, res.Warnings...)
+	if g.findings != nil {
+		r.findings = g.findings.list()
+		r.Findings = len(r.findings)
+	}
+	return r
+}
+
+// newFleetSummary groups the findings of the clusters by rule.
+func newFleetSummary(results []FleetClusterResult) *FleetSummary {
+	sum := &FleetSummary{GeneratedAt: time.Now().UTC().Format(time.RFC3339), Clusters: results, Findings: []FleetFinding{}}
+	byRule := map[string]*FleetFinding{}
+	for _, r := range results {
+		for _, f := range r.findings {
+			ff, ok := byRule[f.Rule]
+			if !ok {
+				ff = &FleetFinding{Rule: f.Rule, Severity: f.Severity, Description: f.Description}
+				byRule[f.Rule] = ff
+			}
+			if len(ff.Clusters) == 0 || ff.Clusters[len(ff.Clusters)-1] != r.Name {
+				ff.Clusters = append(ff.Clusters, r.Name)
+			}
+			ff.Objects++
+			ff.Count += f.Count
+		}
+	}
+	for _, ff := range byRule {
+		sum.Findings = append(sum.Findings, *ff)
+	}
+	sort.Slice(sum.Findings, func(i, j int) bool {
+		a, b := sum.Findings[i], sum.Findings[j]
+		if len(a.Clusters) != len(b.Clusters) {
+			return len(a.Clusters) > len(b.Clusters)
+		}
+		if a.Severity != b.Severity {
+			return a.Severity == severityCritical
+		}
+		return a.Rule < b.Rule
+	})
+	return sum
+}
+
+// writeFleetArchive writes the archive of each cluster under
+// clusters/<name>/ of out, with the fleet summary.
+func writeFleetArchive(out string, sum *FleetSummary) (err error) {
+	f, err := os.Create(out)
+	if err != nil {
+		return fmt.Errorf("create out: %w", err)
+	}
+	defer func() { err = errors.Join(err, f.Close()) }()
+	gz := gzip.NewWriter(f)
+	defer func() { err = errors.Join(err, gz.Close()) }()
+	tarw := utils.NewTarWriter(gz, time.Now())
+	defer func() { err = errors.Join(err, tarw.Close()) }()
+
+	if err := writeBundleInfo(tarw, bundle.NewInfo()); err != nil {
+		return err
+	}
+	if err := writeToolInfo(tarw); err != nil {
+		return err
+	}
+	var clusters []string
+	for i := range sum.Clusters {
+		r := &sum.Clusters[i]
+		if r.Archive == "" {
+			continue
+		}
+		prefix := "clusters/" + r.Name
+		if err := copyArchive(tarw, r.Archive, prefix); err != nil {
+			return fmt.Errorf("add %s to the fleet archive: %w", r.Name, err)
+		}
+		r.Archive = prefix + "/"
+		clusters = append(clusters, r.Name)
+	}
+	b, _ := json.MarshalIndent(sum, "", "  ")
+	if err := utils.WriteFileToTar(tarw, fleetSummaryPath, b); err != nil {
+		return err
+	}
+	if err := utils.WriteFileToTar(tarw, fleetReportPath, []byte(sum.render())); err != nil {
+		return err
+	}
+	idx, _ := json.MarshalIndent(map[string]any{"clusters": clusters}, "", "  ")
+	return utils.WriteFileToTar(tarw, "index.json", idx)
+}
+
+// copyArchive writes the files of the archive at src to tarw under prefix.
+func copyArchive(tarw *utils.TarWriter, src, prefix string) error {
+	b, err := bundle.Open(src)
+	if err != nil {
+		return err
+	}
+	defer b.Close()
+	files, err := b.Files()
+	if err != nil {
+		return err
+	}
+	for _, name := range files {
+		if err := utils.WriteLocalFileToTar(tarw, prefix+"/"+name, filepath.Join(b.Dir, filepath.FromSlash(name))); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// render writes FLEET.md: a table of the clusters' outcomes and the findings
+// shared across them.
+func (s *FleetSummary) render() string {
+	var sb strings.Builder
+	sb.WriteString("# Fleet must-gather summary\n\n")
+	fmt.Fprintf(&sb, "Generated %s for %d clusters.\n\n", s.GeneratedAt, len(s.Clusters))
+	sb.WriteString("| Cluster | Status | Tables | Rows | Warnings | Findings |\n|---|---|---|---|---|---|\n")
+	for _, r := range s.Clusters {
+		status := r.Status
+		if r.Error != "" {
+			status += ": " + strings.ReplaceAll(firstLine(r.Error), "|", "\\|")
+		}
+		fmt.Fprintf(&sb, "| %s | %s | %d | %d | %d | %d |\n", r.Name, status, r.Tables, r.Rows, len(r.Warnings), r.Findings)
+	}
+	sb.WriteString("\n## Findings across clusters\n\n")
+	if len(s.Findings) == 0 {
+		sb.WriteString("No known issues found.\n")
+		return sb.String()
+	}
+	for i, f := range s.Findings {
+		if i == incidentTopN {
+			fmt.Fprintf(&sb, "- ... and %d more in %s\n", len(s.Findings)-i, fleetSummaryPath)
+			break
+		}
+		fmt.Fprintf(&sb, "- **%s** (%s) on %d of %d clusters (%s): %d objects, %d times. %s\n",
+			f.Rule, f.Severity, len(f.Clusters), len(s.Clusters), strings.Join(f.Clusters, ", "), f.Objects, f.Count, f.Description)
+	}
+	return sb.String()
+}
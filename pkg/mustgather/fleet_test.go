@@ -0,0 +1,98 @@
+package mustgather
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeFleet(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "clusters.yaml")
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadFleet(t *testing.T) {
+	f, err := LoadFleet(writeFleet(t, `
+clusters:
+  - name: prod eu
+    workspaceID: /subscriptions/s/resourceGroups/rg/providers/Microsoft.OperationalInsights/workspaces/ws
+  - clusterID: /subscriptions/s/resourceGroups/rg/providers/Microsoft.ContainerService/managedClusters/prod-us
+  - context: staging
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, c := range f.Clusters {
+		names = append(names, c.Name)
+	}
+	if got := strings.Join(names, ","); got != "prod_eu,prod-us,staging" {
+		t.Errorf("names = %s", got)
+	}
+
+	for _, tt := range []struct{ name, yaml, want string }{
+		{"no clusters", "clusters: []", "no clusters"},
+		{"no target", "clusters: [{name: a}]", "workspaceID, clusterID or context"},
+		{"duplicate", "clusters: [{context: a}, {name: a, workspaceID: /w}]", "twice"},
+		{"unknown field", "clusters: [{context: a, workspace: b}]", "workspace"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := LoadFleet(writeFleet(t, tt.yaml))
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("LoadFleet = %v, want an error about %s", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestFleetArchiveName(t *testing.T) {
+	for out, want := range map[string]string{
+		"out/incident.tar.gz": "out/incident-eu.tar.gz",
+		"incident.tgz":        "incident-eu.tgz",
+		"incident":            "incident-eu",
+	} {
+		if got := fleetArchiveName(out, "eu"); got != want {
+			t.Errorf("fleetArchiveName(%s) = %s, want %s", out, got, want)
+		}
+	}
+}
+
+func TestCheckFleet(t *testing.T) {
+	if err := (&Config{UploadTo: "https://a.blob.core.windows.net/c"}).checkFleet(false); err == nil || !strings.Contains(err.Error(), "--fleet-separate") {
+		t.Errorf("upload to a combined archive = %v", err)
+	}
+	if err := (&Config{UploadTo: "https://a.blob.core.windows.net/c"}).checkFleet(true); err != nil {
+		t.Errorf("upload of separate archives = %v", err)
+	}
+	if err := (&Config{SinceLastRun: true}).checkFleet(true); err == nil {
+		t.Error("--since-last-run was accepted")
+	}
+}
+
+func TestNewFleetSummary(t *testing.T) {
+	crash := func(name string) *Finding {
+		return &Finding{Rule: "CrashLoopBackOff", Severity: severityCritical, Name: name, Count: 2}
+	}
+	sum := newFleetSummary([]FleetClusterResult{
+		{Name: "eu", Status: "ok", findings: []*Finding{crash("a"), crash("b"), {Rule: "ImagePullBackOff", Severity: severityWarning, Count: 1}}},
+		{Name: "us", Status: "ok", findings: []*Finding{crash("a")}},
+		{Name: "ap", Status: "failed", Error: "get workspace: 403 | denied"},
+	})
+	if len(sum.Findings) != 2 {
+		t.Fatalf("findings = %+v", sum.Findings)
+	}
+	if f := sum.Findings[0]; f.Rule != "CrashLoopBackOff" || strings.Join(f.Clusters, ",") != "eu,us" || f.Objects != 3 || f.Count != 6 {
+		t.Errorf("crash loops = %+v", f)
+	}
+	md := sum.render()
+	for _, want := range []string{"| ap | failed: get workspace: 403 \\| denied |", "**CrashLoopBackOff** (critical) on 2 of 3 clusters (eu, us)"} {
+		if !strings.Contains(md, want) {
+			t.Errorf("FLEET.md lacks %q:\n%s", want, md)
+		}
+	}
+}
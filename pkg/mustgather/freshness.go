@@ -0,0 +1,168 @@
+package mustgather
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"kubectl-must-gather/pkg/utils"
+)
+
+// freshnessLookback bounds how far back the freshness check looks for the
+// most recent row of each table.
+const freshnessLookback = 30 * 24 * time.Hour
+
+// Freshness statuses recorded in metadata/freshness.json.
+const (
+	// FreshnessFresh means the table has rows inside the gather window.
+	FreshnessFresh = "fresh"
+	// FreshnessQuiet means the agent is reporting but the table had no rows
+	// in the window: most likely nothing happened.
+	FreshnessQuiet = "quiet"
+	// FreshnessNotCollected means no data is arriving for the table, so an
+	// empty export says nothing about what happened in the cluster.
+	FreshnessNotCollected = "not-collected"
+)
+
+// livenessTables are written periodically by the monitoring agent; if any of
+// them is fresh the agent is alive and collecting.
+var livenessTables = []string{"Heartbeat", "KubeNodeInventory", "KubePodInventory"}
+
+// periodicTables are written on a fixed interval regardless of cluster
+// activity, so a gap in them always means collection stopped.
+var periodicTables = map[string]bool{
+	"Heartbeat": true, "KubePodInventory": true, "KubeNodeInventory": true, "KubeServices": true,
+	"KubePVInventory": true, "ContainerInventory": true, "ContainerNodeInventory": true,
+	"InsightsMetrics": true, "Perf": true,
+}
+
+type tableFreshness struct {
+	Status            string `json:"status"`
+	LastTimeGenerated string `json:"lastTimeGenerated,omitempty"`
+	Note              string `json:"note,omitempty"`
+}
+
+type freshnessReport struct {
+	CheckedAt   string                    `json:"checkedAt"`
+	WindowStart string                    `json:"windowStart"`
+	Lookback    string                    `json:"lookback"`
+	AgentAlive  bool                      `json:"agentAlive"`
+	Tables      map[string]tableFreshness `json:"tables"`
+}
+
+// checkFreshness looks up the latest TimeGenerated of every requested table
+// and classifies tables whose data is older than the gather window.
+func (g *Gatherer) checkFreshness(lcli LogsQueryClient, workspaceGUID string, tables []string) (*freshnessReport, error) {
+	probe := append(append([]string{}, tables...), livenessTables...)
+	last, err := g.queryLastTimeGenerated(lcli, workspaceGUID, probe)
+	if err != nil {
+		return nil, err
+	}
+	return classifyFreshness(tables, last, g.start), nil
+}
+
+func (g *Gatherer) queryLastTimeGenerated(lcli LogsQueryClient, workspaceGUID string, tables []string) (map[string]time.Time, error) {
+	seen := map[string]bool{}
+	refs := make([]string, 0, len(tables))
+	for _, t := range tables {
+		if !seen[t] {
+			seen[t] = true
+			refs = append(refs, "['"+t+"']")
+		}
+	}
+	q := fmt.Sprintf("union isfuzzy=true withsource=SourceTable %s | summarize LastTimeGenerated=max(TimeGenerated) by SourceTable", strings.Join(refs, ", "))
+	res, err := g.query(g.ctx, lcli, workspaceGUID, q, g.end.Add(-freshnessLookback), g.end)
+	if err != nil {
+		return nil, err
+	}
+	last := map[string]time.Time{}
+	if len(res.Tables) == 0 {
+		return last, nil
+	}
+	tab := res.Tables[0]
+	srcIdx, tsIdx := -1, -1
+	for i, c := range tab.Columns {
+		switch *c.Name {
+		case "SourceTable":
+			srcIdx = i
+		case "LastTimeGenerated":
+			tsIdx = i
+		}
+	}
+	if srcIdx < 0 || tsIdx < 0 {
+		return nil, fmt.Errorf("unexpected freshness result columns")
+	}
+	for _, row := range tab.Rows {
+		if ts := utils.ParseTimeRFC3339(toStr(row[tsIdx])); !ts.IsZero() {
+			last[toStr(row[srcIdx])] = ts
+		}
+	}
+	return last, nil
+}
+
+func classifyFreshness(tables []string, last map[string]time.Time, windowStart time.Time) *freshnessReport {
+	report := &freshnessReport{
+		CheckedAt:   time.Now().UTC().Format(time.RFC3339),
+		WindowStart: windowStart.UTC().Format(time.RFC3339),
+		Lookback:    freshnessLookback.String(),
+		Tables:      map[string]tableFreshness{},
+	}
+	for _, t := range livenessTables {
+		if ts, ok := last[t]; ok && !ts.Before(windowStart) {
+			report.AgentAlive = true
+		}
+	}
+	for _, t := range tables {
+		ts, ok := last[t]
+		tf := tableFreshness{}
+		if ok {
+			tf.LastTimeGenerated = ts.UTC().Format(time.RFC3339)
+		}
+		switch {
+		case ok && !ts.Before(windowStart):
+			tf.Status = FreshnessFresh
+		case ok && report.AgentAlive && !periodicTables[t]:
+			tf.Status = FreshnessQuiet
+			tf.Note = "agent is reporting but no rows were written in the window; likely nothing happened"
+		case ok:
+			tf.Status = FreshnessNotCollected
+			tf.Note = fmt.Sprintf("no data collected since %s; collection appears to have stopped before the window", tf.LastTimeGenerated)
+		default:
+			tf.Status = FreshnessNotCollected
+			tf.Note = fmt.Sprintf("no data collected in the last %s; the table is not being populated", freshnessLookback)
+		}
+		report.Tables[t] = tf
+	}
+	return report
+}
+
+// staleTables lists the tables whose data is not being collected, sorted.
+func (r *freshnessReport) staleTables() []string {
+	var stale []string
+	for t, tf := range r.Tables {
+		if tf.Status == FreshnessNotCollected {
+			stale = append(stale, t)
+		}
+	}
+	sort.Strings(stale)
+	return stale
+}
+
+// warnStale prints a prominent warning to w for tables whose data is not being
+// collected.
+func (r *freshnessReport) warnStale(w io.Writer) {
+	stale := r.staleTables()
+	if len(stale) == 0 {
+		return
+	}
+	bar := strings.Repeat("!", 80)
+	fmt.Fprintln(w, bar)
+	fmt.Fprintf(w, "WARNING: %d table(s) have no data newer than the requested window (%s).\n", len(stale), r.WindowStart)
+	fmt.Fprintln(w, "Empty exports for these tables mean data was NOT collected, not that nothing happened:")
+	for _, t := range stale {
+		fmt.Fprintf(w, "  - %s: %s\n", t, r.Tables[t].Note)
+	}
+	fmt.Fprintln(w, bar)
+}
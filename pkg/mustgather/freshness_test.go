@@ -0,0 +1,73 @@
+package mustgather
+
+import (
+	"testing"
+	"time"
+)
+
+func TestClassifyFreshness(t *testing.T) {
+	windowStart := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	inWindow := windowStart.Add(30 * time.Minute)
+	beforeWindow := windowStart.Add(-48 * time.Hour)
+
+	tests := []struct {
+		name       string
+		table      string
+		last       map[string]time.Time
+		wantStatus string
+		wantAlive  bool
+	}{
+		{
+			name:       "rows inside the window",
+			table:      "ContainerLogV2",
+			last:       map[string]time.Time{"ContainerLogV2": inWindow},
+			wantStatus: FreshnessFresh,
+		},
+		{
+			name:       "event table quiet while agent reports",
+			table:      "KubeEvents",
+			last:       map[string]time.Time{"KubeEvents": beforeWindow, "Heartbeat": inWindow},
+			wantStatus: FreshnessQuiet,
+			wantAlive:  true,
+		},
+		{
+			name:       "periodic table stale while agent reports",
+			table:      "Perf",
+			last:       map[string]time.Time{"Perf": beforeWindow, "Heartbeat": inWindow},
+			wantStatus: FreshnessNotCollected,
+			wantAlive:  true,
+		},
+		{
+			name:       "agent stopped before window",
+			table:      "KubeEvents",
+			last:       map[string]time.Time{"KubeEvents": beforeWindow, "Heartbeat": beforeWindow},
+			wantStatus: FreshnessNotCollected,
+		},
+		{
+			name:       "table never populated",
+			table:      "Syslog",
+			last:       map[string]time.Time{},
+			wantStatus: FreshnessNotCollected,
+		},
+		{
+			name:       "table never populated while agent reports",
+			table:      "AKSAudit",
+			last:       map[string]time.Time{"Heartbeat": inWindow},
+			wantStatus: FreshnessNotCollected,
+			wantAlive:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			report := classifyFreshness([]string{tt.table}, tt.last, windowStart)
+			if report.AgentAlive != tt.wantAlive {
+				t.Errorf("expected agentAlive=%v, got %v", tt.wantAlive, report.AgentAlive)
+			}
+			got := report.Tables[tt.table]
+			if got.Status != tt.wantStatus {
+				t.Errorf("expected status %q, got %q (%s)", tt.wantStatus, got.Status, got.Note)
+			}
+		})
+	}
+}
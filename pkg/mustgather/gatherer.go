@@ -1,63 +1,325 @@
 package mustgather
 
 import (
-	"archive/tar"
-	"compress/gzip"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"net/http"
 	"os"
 	"path/filepath"
-	"sort"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/arm"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/cloud"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
 	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
 	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
 	azquery "github.com/Azure/azure-sdk-for-go/sdk/monitor/azquery"
-	armoperationalinsights "github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/operationalinsights/armoperationalinsights"
 
+	"kubectl-must-gather/pkg/bundle"
 	"kubectl-must-gather/pkg/utils"
 )
 
-type ckey struct{ ns, pod, container string }
-
 type GathererInterface interface {
-	Run() error
+	Run() (*GatherResult, error)
 }
 
 type Gatherer struct {
 	config *Config
 	ctx    context.Context
-	cred   *azidentity.DefaultAzureCredential
+	cred   azcore.TokenCredential
+	cloud  cloud.Configuration
+	client *http.Client
+	// lcli, tcli and wcli are the clients of the Environment; nil ones are
+	// created for the gather's cloud and credential.
+	lcli    LogsQueryClient
+	tcli    TablesClient
+	wcli    WorkspacesClient
+	cache   *queryCache
+	usage   *usageTracker
+	limiter *rateLimiter
+	batcher *queryBatcher
+	budget  *gatherBudget
+	memory  *memoryGovernor
+	// completed lists tables exported in full, for interrupted gathers.
+	completed []string
+	// signals and outcomes feed SUMMARY.md.
+	signals  *clusterSignals
+	outcomes []tableOutcome
+	// findings are the known issues matched, for NotifyWebhook.
+	findings *findings
+
+	// start and end bound the gather window; all tables share them.
+	start, end time.Time
+
+	// progress streams --progress-format json events to stdout, where the
+	// --output-json result also goes. log receives progress and warnings for
+	// people: stderr, or nothing with Quiet.
+	progress  *progressStream
+	stdout    io.Writer
+	log       io.Writer
+	outFile   string
+	startedAt time.Time
+	// warns collects warnings for the --output-json result; chunks are
+	// queried concurrently.
+	warnMu sync.Mutex
+	warns  []string
+	// provenance records the queries for metadata/provenance.json.
+	provenance provenanceLog
+	// scope limits table queries to some namespaces; nil when unscoped.
+	scope *namespaceScope
+	// restricted receives the unredacted rows of a two-tier gather;
+	// restrictedFile is its encrypted path once written.
+	restricted     *restrictedArchive
+	restrictedFile string
+	// incremental tracks the rows exported per table with SinceLastRun.
+	incremental *incremental
+	// entries are the files of the archive, for the checksum manifest, and
+	// manifestFile and signatureFile the manifest and its signature.
+	entries       []utils.TarFile
+	manifestFile  string
+	signatureFile string
+	// uploaded are the blob URLs of the files uploaded with UploadTo.
+	uploaded []string
+	// logTo replaces stderr as log, from WithLogger.
+	logTo io.Writer
+	// concurrency overrides Config.Parallelism when positive, from
+	// WithConcurrency.
+	concurrency int
+	// hooks are called with the progress of the gather, from WithHooks.
+	hooks hookList
+	// sink replaces the output file as where the bundle goes, from WithSink.
+	sink utils.Sink
+	// filters are the row filters of the selected profiles, by table.
+	filters map[string]string
+	// redactions counts the values this run masked with Config.Redaction.
+	redactions *redactionCounts
+	// cutShort is whether the gather was interrupted or timed out, read
+	// once its bundle is finished; nil before then.
+	cutShort *bool
 }
 
-func NewGatherer(ctx context.Context, config *Config) (GathererInterface, error) {
-	cred, err := azidentity.NewDefaultAzureCredential(nil)
-	if err != nil {
-		return nil, fmt.Errorf("failed to init credential: %w", err)
+// Environment overrides the Azure cloud, credential, HTTP client and service
+// clients used by a gather, e.g. to run against an emulated workspace in tests
+// or to embed the gatherer with other implementations. Zero fields keep the
+// defaults: Azure public cloud, DefaultAzureCredential and the Azure SDK
+// clients.
+type Environment struct {
+	Credential azcore.TokenCredential
+	Cloud      cloud.Configuration
+	HTTPClient *http.Client
+	// Stdout receives the --progress-format json stream and the
+	// --output-json result instead of os.Stdout.
+	Stdout io.Writer
+	// LogsClient, TablesClient and WorkspacesClient replace the clients of
+	// the data plane, and of the workspace's tables and the workspace
+	// itself in the management plane. Their traffic is not counted in the
+	// run summary's bytes downloaded.
+	LogsClient       LogsQueryClient
+	TablesClient     TablesClient
+	WorkspacesClient WorkspacesClient
+}
+
+// NewGatherer returns the gatherer of config: an AI-mode gatherer with
+// AIMode, a table-export gatherer otherwise. opts customize it, e.g. with
+// another credential or service clients.
+func NewGatherer(ctx context.Context, config *Config, opts ...Option) (GathererInterface, error) {
+	var o gathererOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.env.Credential == nil {
+		cred, err := azidentity.NewDefaultAzureCredential(nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to init credential: %w", err)
+		}
+		o.env.Credential = cred
 	}
 
 	if config.AIMode {
 		return &AIGatherer{
 			config: config,
 			ctx:    ctx,
-			cred:   cred,
+			cred:   o.env.Credential,
+			out:    o.env.Stdout,
+			logTo:  o.log,
 		}, nil
 	}
+	return newGatherer(ctx, config, o), nil
+}
+
+// NewGathererWithEnvironment returns a table-export gatherer that talks to the
+// Azure endpoints described by env.
+func NewGathererWithEnvironment(ctx context.Context, config *Config, env Environment) (GathererInterface, error) {
+	if env.Credential == nil {
+		cred, err := azidentity.NewDefaultAzureCredential(nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to init credential: %w", err)
+		}
+		env.Credential = cred
+	}
+	return newGatherer(ctx, config, gathererOptions{env: env}), nil
+}
+
+// newGatherer returns a table-export gatherer customized by o, whose
+// credential is set.
+func newGatherer(ctx context.Context, config *Config, o gathererOptions) *Gatherer {
+	env := o.env
+	g := &Gatherer{config: config, ctx: ctx, cred: env.Credential, cloud: env.Cloud, client: env.HTTPClient, stdout: env.Stdout,
+		lcli: env.LogsClient, tcli: env.TablesClient, wcli: env.WorkspacesClient, logTo: o.log, concurrency: o.concurrency, hooks: o.hooks, sink: o.sink}
+	if g.stdout == nil {
+		g.stdout = os.Stdout
+	}
+	return g
+}
 
-	return &Gatherer{
-		config: config,
-		ctx:    ctx,
-		cred:   cred,
-	}, nil
+// Run gathers the bundle and returns what it wrote. The result is returned
+// with the error of a failed or interrupted gather too, describing the
+// partial bundle.
+func (g *Gatherer) Run() (*GatherResult, error) {
+	// g.ctx is restored so a timed out Run leaves the gatherer usable
+	parent := g.ctx
+	var cancel context.CancelFunc
+	g.ctx, cancel = g.config.withTimeout(parent)
+	defer func() {
+		cancel()
+		g.ctx = parent
+	}()
+	err := classify(g.run())
+	r := g.result(err)
+	g.writeResult(r)
+	return r, err
 }
 
-func (g *Gatherer) Run() error {
+func (g *Gatherer) run() (err error) {
+	g.startedAt = time.Now()
+	g.log = g.config.logOutput()
+	if g.logTo != nil && !g.config.Quiet {
+		g.log = g.logTo
+	}
+	g.progress = newProgressStream(g.config.ProgressFormat, g.stdout)
+	if g.config.Metrics != nil {
+		g.config.Metrics.started()
+	}
+	g.cutShort = nil
+	defer func() {
+		g.markIncomplete()
+		if g.config.Metrics != nil {
+			g.config.Metrics.observe(g, err)
+		}
+		if g.config.InCluster {
+			g.postCompletionEvent(err)
+		}
+		if g.config.NotifyWebhook != "" {
+			g.postNotification(err)
+		}
+		g.progressDone(err)
+	}()
+	g.usage = newUsageTracker(g.client)
+	// Also ends sampling when the gather fails before its run summary
+	defer g.usage.stop()
+	g.redactions = &redactionCounts{}
+	g.limiter = newRateLimiter(g.config.QueryRate)
+	g.memory = newMemoryGovernor(g.config.MaxMemory, g.log)
+	defer g.memory.stop()
 	iso, err := utils.ISO8601Duration(g.config.Timespan)
 	if err != nil {
 		return fmt.Errorf("invalid timespan: %w", err)
 	}
+	if g.config.Timezone != "" {
+		if _, err := time.LoadLocation(g.config.Timezone); err != nil {
+			return fmt.Errorf("invalid timezone: %w", err)
+		}
+	}
+	if err := checkProfiles(g.config.Profiles, GetDefaultProfiles()); err != nil {
+		return err
+	}
+	restrictedTool, err := g.config.checkRestricted()
+	if err != nil {
+		return err
+	}
+	if err := g.config.checkOutput(g.sink != nil); err != nil {
+		return err
+	}
+	if g.config.Anonymizer != nil {
+		// Keeps the pseudonyms of partial gathers too
+		defer func() { err = errors.Join(err, g.config.Anonymizer.Save()) }()
+	}
+	if g.config.SinceLastRun {
+		if g.incremental, err = loadIncremental(g.config.StateFile); err != nil {
+			return err
+		}
+		// Runs once the archive is written and encrypted. A failed gather
+		// leaves the state, so the next run exports its rows again.
+		defer func() {
+			if err == nil || g.incomplete() {
+				err = errors.Join(err, g.incremental.save())
+			}
+		}()
+	}
+	if g.config.Keep > 0 || g.config.KeepDays > 0 {
+		// Runs after the upload, and only once this gather succeeded, so
+		// a failing schedule does not prune the last good archives
+		defer func() {
+			if err == nil && g.outFile != "" && !g.incomplete() {
+				g.pruneOutputs()
+			}
+		}()
+	}
+	if g.config.UploadTo != "" {
+		if _, err := newBlobUploader(g.ctx, g.config.UploadTo, g.cred, g.client); err != nil {
+			return fmt.Errorf("invalid --upload-to: %w", err)
+		}
+		// Runs once the archives are encrypted and signed, for a partial
+		// gather too
+		defer func() {
+			if g.outFile == "" {
+				return
+			}
+			if upErr := g.uploadOutputs(); upErr != nil {
+				err = errors.Join(err, upErr)
+			}
+		}()
+	}
+	if g.config.SignKey != "" {
+		if err := checkSigner(); err != nil {
+			return err
+		}
+		// Runs last, once the archives are closed and encrypted
+		defer func() {
+			if g.outFile == "" {
+				return
+			}
+			if signErr := g.signArchive(); signErr != nil {
+				err = errors.Join(err, signErr)
+			}
+		}()
+	}
+	if len(g.config.EncryptTo) > 0 {
+		tool, err := encryptionTool(g.config.EncryptTo)
+		if err != nil {
+			return err
+		}
+		// Runs once the archive is closed, for a partial one too, and is not
+		// cancelled with the gather
+		defer func() {
+			if g.outFile == "" {
+				return
+			}
+			enc, encErr := encryptFile(context.Background(), tool, g.outFile, g.config.EncryptTo)
+			if encErr != nil {
+				err = errors.Join(err, fmt.Errorf("encrypt archive: %w", encErr))
+				return
+			}
+			fmt.Fprintf(g.log, "Encrypted %s to %s and removed the unencrypted archive\n", g.outFile, enc)
+			g.outFile = enc
+		}()
+	}
 
 	// Resolve GUID and list of tables
 	var (
@@ -66,6 +328,10 @@ func (g *Gatherer) Run() error {
 		wsName        string
 		tables        []string
 		workspaceGUID string
+		// workspaceHas holds the lower-cased names of the workspace's
+		// tables, when they could be listed
+		workspaceHas map[string]bool
+		existing     []string
 	)
 
 	if g.config.WorkspaceID != "" {
@@ -75,58 +341,109 @@ func (g *Gatherer) Run() error {
 		}
 
 		// Get workspace properties including customerId
-		wcli, err := armoperationalinsights.NewWorkspacesClient(subID, g.cred, nil)
+		wcli, err := g.workspacesClient(subID)
 		if err != nil {
 			return err
 		}
 		w, err := wcli.Get(g.ctx, rg, wsName, nil)
 		if err != nil {
-			return fmt.Errorf("get workspace: %w", err)
+			return fmt.Errorf("get workspace: %w", workspaceError(err))
 		}
 		if w.Properties != nil && w.Properties.CustomerID != nil {
 			workspaceGUID = *w.Properties.CustomerID
 		}
 
-		if g.config.AllTables {
-			// List tables via management plane only when explicitly requested
-			tcli, err := armoperationalinsights.NewTablesClient(subID, g.cred, nil)
-			if err != nil {
-				return err
-			}
-			pager := tcli.NewListByWorkspacePager(rg, wsName, nil)
-			for pager.More() {
-				page, err := pager.NextPage(g.ctx)
-				if err != nil {
-					return fmt.Errorf("list tables: %w", err)
-				}
-				for _, t := range page.Value {
-					if t.Name != nil {
-						tables = append(tables, *t.Name)
-					}
-				}
+		// The management plane lists the workspace's tables: all of them are
+		// exported with AllTables, and requested ones not among them skipped
+		existing, err = g.workspaceTables(subID, rg, wsName)
+		switch {
+		case err != nil && g.config.AllTables:
+			return err
+		case err != nil && g.config.Strict && g.config.TableFilter != "":
+			return fmt.Errorf("could not check which of the requested tables exist: %w", err)
+		case err != nil:
+			g.warnf("", "could not check which tables exist, exporting all requested tables: %v", err)
+		case g.config.AllTables:
+			tables = existing
+		default:
+			workspaceHas = map[string]bool{}
+			for _, t := range existing {
+				workspaceHas[strings.ToLower(t)] = true
 			}
 		}
 	}
 
 	if workspaceGUID == "" {
-		return fmt.Errorf("could not determine workspace GUID from workspace; check permissions or workspace-id")
+		return markErr(ErrWorkspaceNotFound, errors.New("could not determine workspace GUID from workspace; check permissions or workspace-id"))
 	}
 
 	tables = g.resolveTables(tables)
+	var missing []string
+	if workspaceHas != nil {
+		tables, missing = splitMissingTables(tables, workspaceHas)
+	}
+	if len(missing) > 0 && g.config.Strict && g.config.TableFilter != "" {
+		return fmt.Errorf("requested tables not in the workspace: %s", describeUnknown(missing, existing))
+	}
+	if len(missing) > 0 {
+		g.warnf("", "skipping %d requested tables not in the workspace: %s", len(missing), describeUnknown(missing, existing))
+		for _, t := range missing {
+			g.outcomes = append(g.outcomes, tableOutcome{table: t, notes: []string{"not in the workspace, skipped"}})
+		}
+	}
+	g.start, g.end = g.timeWindow(iso)
+	if !g.config.Since.IsZero() {
+		// The window of a rolling gather is the time since the previous one
+		iso, _ = utils.ISO8601Duration(g.end.Sub(g.start).Round(time.Second).String())
+	}
 
-	// Prepare tar.gz writer
+	if g.cache, err = newQueryCache(g.config.CacheDir); err != nil {
+		return err
+	}
+
+	// Prepare the bundle writer: the sink of WithSink, or an archive,
+	// directory or blob container at the output name
 	outFile := g.config.GenerateDefaultOutputName()
-	outF, err := os.Create(outFile)
-	if err != nil {
-		return fmt.Errorf("create out: %w", err)
+	sink := g.sink
+	if sink == nil {
+		if sink, outFile, err = openOutput(g.ctx, g.config, outFile, g.startedAt, g.cred, g.client); err != nil {
+			return err
+		}
+		g.outFile = outFile
+	} else {
+		outFile = "the bundle"
 	}
-	defer outF.Close()
-	gz := gzip.NewWriter(outF)
-	defer gz.Close()
-	tarw := tar.NewWriter(gz)
-	defer tarw.Close()
+	g.progress.emit(progressGatherStarted, map[string]any{
+		"output": g.outFile,
+		"tables": tables,
+		"start":  g.start.UTC().Format(time.RFC3339),
+		"end":    g.end.UTC().Format(time.RFC3339),
+	})
+	tarw := utils.NewSinkWriter(sink)
+	if len(g.hooks) > 0 {
+		tarw.OnFile = func(f utils.TarFile) {
+			g.hooks.fileWritten(FileWrittenEvent{Path: f.Path, Size: f.Size, SHA256: f.SHA256})
+		}
+	}
+	defer func() {
+		if closeErr := tarw.Close(); closeErr != nil {
+			err = errors.Join(err, fmt.Errorf("close %s: %w", outFile, closeErr))
+		}
+	}()
+	defer func() { g.entries = tarw.Files() }()
+	if restrictedTool != "" {
+		if g.restricted, err = openRestrictedArchive(g.config.RestrictedOutput, g.startedAt); err != nil {
+			return err
+		}
+		defer func() { err = errors.Join(err, g.finishRestricted(restrictedTool)) }()
+	}
+	// Settles the outcome for the deferred finishing when the gather ends
+	// before its bundle is
+	defer g.markIncomplete()
 
 	// Write metadata
+	_ = writeBundleInfo(tarw, bundle.NewInfo())
+	_ = writeToolInfo(tarw)
 	meta := map[string]any{
 		"generatedAt":   time.Now().UTC().Format(time.RFC3339Nano),
 		"workspaceGUID": workspaceGUID,
@@ -134,6 +451,9 @@ func (g *Gatherer) Run() error {
 		"timespan":      iso,
 		"tablesCount":   len(tables),
 	}
+	if g.config.ClusterID != "" {
+		meta["clusterID"] = g.config.ClusterID
+	}
 	metaBytes, _ := json.MarshalIndent(meta, "", "  ")
 	_ = utils.WriteFileToTar(tarw, "metadata/workspace.json", metaBytes)
 
@@ -145,15 +465,60 @@ func (g *Gatherer) Run() error {
 	}
 
 	// Initialize logs client
-	lcli, err := azquery.NewLogsClient(g.cred, nil)
+	lcli, err := g.logsClient()
 	if err != nil {
-		return fmt.Errorf("logs client: %w", err)
+		return err
+	}
+	if g.config.BatchQueries {
+		g.batcher = newQueryBatcher(g.ctx, lcli)
+		defer g.batcher.close()
+	}
+
+	// Limit the gather to the namespaces of the allowlist or the caller's
+	// RBAC, before the estimates and freshness checks see the tables
+	if g.scope, err = g.resolveScope(lcli, workspaceGUID); err != nil {
+		return err
+	}
+	if g.scope != nil {
+		fmt.Fprintf(g.log, "Scoped to namespaces: %s\n", strings.Join(g.scope.namespaces, ", "))
+		var unscoped []string
+		tables, unscoped = g.scope.split(tables)
+		if len(unscoped) > 0 {
+			g.warnf("", "skipping %d tables without a namespace column in a scoped gather: %s", len(unscoped), strings.Join(unscoped, ", "))
+			for _, t := range unscoped {
+				g.outcomes = append(g.outcomes, tableOutcome{table: t, notes: []string{"no namespace column, skipped in a scoped gather"}})
+			}
+		}
+	}
+
+	if g.incremental != nil {
+		g.incremental.selectWorkspace(workspaceGUID, g.scope)
+	}
+	if g.config.Anonymizer != nil {
+		g.seedAnonymizer(lcli, workspaceGUID)
+	}
+
+	// Check table freshness before the (potentially long) export
+	if g.config.FreshnessCheck {
+		report, err := g.checkFreshness(lcli, workspaceGUID, tables)
+		if err != nil {
+			g.warnf("", "table freshness check failed: %v", err)
+		} else {
+			report.warnStale(g.log)
+			for _, t := range report.staleTables() {
+				g.progress.emit(progressWarning, map[string]any{"table": t, "message": report.Tables[t].Note})
+				g.addWarning(t + ": " + report.Tables[t].Note)
+				g.hooks.warning(t, report.Tables[t].Note)
+			}
+			fb, _ := json.MarshalIndent(report, "", "  ")
+			_ = utils.WriteFileToTar(tarw, "metadata/freshness.json", fb)
+		}
 	}
 
 	// Helper: fetch schema for a table if we can (management plane only)
-	var tcli *armoperationalinsights.TablesClient
+	var tcli TablesClient
 	if subID != "" {
-		if tcli, err = armoperationalinsights.NewTablesClient(subID, g.cred, nil); err != nil {
+		if tcli, err = g.tablesClient(subID); err != nil {
 			return err
 		}
 	}
@@ -163,15 +528,192 @@ func (g *Gatherer) Run() error {
 		return err
 	}
 
+	if !g.interrupted() {
+		g.runSnippets(tarw, lcli, workspaceGUID)
+	}
+	if g.config.LogVolume && !g.interrupted() {
+		g.gatherLogVolume(tarw, lcli, workspaceGUID)
+	}
+
+	// Whether the gather was cut short, read once so that run.json, the
+	// index, SUMMARY.md, the error returned and everything reporting on the
+	// gather after its upload, signing or encryption agree
+	g.markIncomplete()
+	incomplete := g.incomplete()
+
+	// Resource usage of this run
+	usage := g.usage.stop()
+	run := map[string]any{"usage": usage}
+	if g.budget != nil {
+		run["budget"] = g.budget.summary()
+	}
+	if incomplete {
+		run["incomplete"] = true
+	}
+	if g.config.Redaction != nil {
+		run["redactionRules"] = g.config.Redaction.ruleNames()
+	}
+	if g.scope != nil {
+		run["scope"] = g.scope.summary(g.config.Anonymizer)
+	}
+	if g.config.Anonymizer != nil {
+		run["anonymizedNames"] = g.config.Anonymizer.count()
+	}
+	if g.incremental != nil {
+		run["sinceLastRun"] = g.incremental.summary()
+	}
+	if g.memory.lowMemory() {
+		run["lowMemoryMode"] = true
+	}
+	runb, _ := json.MarshalIndent(run, "", "  ")
+	_ = utils.WriteFileToTar(tarw, "metadata/run.json", runb)
+	if g.config.Redaction != nil {
+		red := g.config.Redaction.summary(g.redactions)
+		fmt.Fprintf(g.log, "Redacted %d values in %d tables\n", red.Total, len(red.Tables))
+		redb, _ := json.MarshalIndent(red, "", "  ")
+		_ = utils.WriteFileToTar(tarw, redactionsPath, redb)
+	}
+
+	// Incident summary
+	sum := &incidentSummary{
+		generatedAt:   time.Now().UTC(),
+		loc:           g.config.location(),
+		workspaceID:   g.config.WorkspaceID,
+		workspaceGUID: workspaceGUID,
+		subscription:  subID,
+		resourceGroup: rg,
+		workspaceName: wsName,
+		start:         g.start,
+		end:           g.end,
+		timespan:      iso,
+		profiles:      g.config.Profiles,
+		tables:        tables,
+		missing:       missing,
+		incomplete:    incomplete,
+		outcomes:      g.outcomes,
+		signals:       g.signals,
+	}
+	_ = utils.WriteFileToTar(tarw, incidentSummaryPath, []byte(sum.render()))
+
 	// Index file
 	index := map[string]any{"tables": tables}
+	if len(missing) > 0 {
+		index["missingTables"] = missing
+	}
+	if incomplete {
+		index["incomplete"] = true
+		index["completedTables"] = g.completed
+	}
 	idxb, _ := json.MarshalIndent(index, "", "  ")
 	_ = utils.WriteFileToTar(tarw, "index.json", idxb)
+	_ = g.writeProvenance(tarw)
 
-	fmt.Fprintf(os.Stderr, "Wrote %s\n", outFile)
+	if tarw.Err() == nil {
+		// Close returns the error otherwise
+		fmt.Fprintf(g.log, "Wrote %s\n", outFile)
+	}
+	fmt.Fprintf(g.log, "Run summary: %s\n", usage)
+	if incomplete {
+		return markErr(ErrPartialData, fmt.Errorf("gather interrupted, %s is incomplete: %w", outFile, context.Cause(g.ctx)))
+	}
 	return nil
 }
 
+// workspaceTables lists the tables of the workspace through the management
+// plane.
+func (g *Gatherer) workspaceTables(subID, rg, wsName string) ([]string, error) {
+	tcli, err := g.tablesClient(subID)
+	if err != nil {
+		return nil, err
+	}
+	var tables []string
+	pager := tcli.NewListByWorkspacePager(rg, wsName, nil)
+	for pager.More() {
+		page, err := pager.NextPage(g.ctx)
+		if err != nil {
+			return nil, fmt.Errorf("list tables: %w", err)
+		}
+		for _, t := range page.Value {
+			if t.Name != nil {
+				tables = append(tables, *t.Name)
+			}
+		}
+	}
+	return tables, nil
+}
+
+// splitMissingTables separates the requested tables the workspace has, keyed
+// by lower-cased name in has, or that have a registered Exporter from those
+// it does not.
+func splitMissingTables(tables []string, has map[string]bool) (present, missing []string) {
+	for _, t := range tables {
+		if has[strings.ToLower(t)] || registeredExporterFor(t) != nil {
+			present = append(present, t)
+		} else {
+			missing = append(missing, t)
+		}
+	}
+	return present, missing
+}
+
+// parallelism is how many chunks of a table are queried at once: one in
+// low-memory mode, WithConcurrency or Parallelism otherwise.
+func (g *Gatherer) parallelism() int {
+	if g.memory.lowMemory() {
+		return 1
+	}
+	if g.concurrency > 0 {
+		return g.concurrency
+	}
+	return max(1, g.config.Parallelism)
+}
+
+// interrupted reports whether the gather was cancelled, e.g. by SIGINT. Once
+// it is, no new queries are started and the archive is finalized with what
+// was collected so far.
+func (g *Gatherer) interrupted() bool {
+	return g.ctx.Err() != nil
+}
+
+// markIncomplete settles whether the gather was cut short, for incomplete to
+// report from then on.
+func (g *Gatherer) markIncomplete() {
+	if g.cutShort == nil {
+		cut := g.interrupted()
+		g.cutShort = &cut
+	}
+}
+
+// incomplete reports whether the gather was cut short: as settled once its
+// bundle was finished, so a signal during the upload does not change the
+// outcome reported, or else whether it is interrupted now.
+func (g *Gatherer) incomplete() bool {
+	if g.cutShort != nil {
+		return *g.cutShort
+	}
+	return g.interrupted()
+}
+
+// timeWindow returns the [start, end) range covered by the gather, ending now
+// and starting at Since when that is set and earlier.
+func (g *Gatherer) timeWindow(iso string) (time.Time, time.Time) {
+	end := time.Now().UTC()
+	if since := g.config.Since; !since.IsZero() && since.Before(end) {
+		return since.UTC(), end
+	}
+	// Parse iso timespan to duration for chunking
+	dur := time.Duration(0)
+	if d2, err := utils.ParseISO8601ToDuration(iso); err == nil {
+		dur = d2
+	} else if d3, err := time.ParseDuration(g.config.Timespan); err == nil {
+		dur = d3
+	}
+	if dur == 0 {
+		dur = 2 * time.Hour
+	}
+	return end.Add(-dur), end
+}
+
 func (g *Gatherer) resolveTables(tables []string) []string {
 	if g.config.TableFilter != "" {
 		// override tables with filter list
@@ -196,17 +738,15 @@ func (g *Gatherer) resolveTables(tables []string) []string {
 			if p == "" {
 				continue
 			}
-			if lst, ok := profileMap[p]; ok {
-				for _, t := range lst {
-					if _, ok := seen[t]; !ok {
-						tables = append(tables, t)
-						seen[t] = struct{}{}
-					}
+			// Unknown profiles were rejected by checkProfiles
+			for _, t := range profileMap[p] {
+				if _, ok := seen[t]; !ok {
+					tables = append(tables, t)
+					seen[t] = struct{}{}
 				}
-			} else {
-				fmt.Fprintf(os.Stderr, "warning: unknown profile '%s'\n", p)
 			}
 		}
+		g.filters = profileFilters(g.config.Profiles)
 	}
 
 	// If still empty, default to union of podLogs+inventory+metrics (same as aks-debug)
@@ -225,300 +765,479 @@ func (g *Gatherer) resolveTables(tables []string) []string {
 	return tables
 }
 
-func (g *Gatherer) exportTables(tarw *tar.Writer, lcli *azquery.LogsClient, tcli *armoperationalinsights.TablesClient, tables []string, workspaceGUID, subID, rg, wsName, iso string) error {
-	// Accumulators for stitched logs
-	stitchedLogs := map[ckey]*strings.Builder{}
-	stitchedEvents := map[string]*strings.Builder{}
+func (g *Gatherer) exportTables(tarw *utils.TarWriter, lcli LogsQueryClient, tcli TablesClient, tables []string, workspaceGUID, subID, rg, wsName, iso string) error {
+	g.signals = newClusterSignals()
+	warn := func(table, msg string) { g.warnf(table, "%s: %s", table, msg) }
+	transforms := append(newTransforms(g.config, g.memory, warn), g.signals, newRestartAnalysis(g.signals))
+	for _, tr := range transforms {
+		if f, ok := tr.(*findings); ok {
+			g.findings = f
+		}
+	}
+	if g.config.AISummary {
+		transforms = append(transforms, g.newAISummary())
+	}
+	g.budget = g.newBudget(lcli, workspaceGUID, tables)
 
-	for _, table := range tables {
-		fmt.Fprintf(os.Stderr, "Exporting %s...\n", table)
+	for i, table := range tables {
+		if g.interrupted() {
+			break
+		}
+		fmt.Fprintf(g.log, "Exporting %s...\n", table)
+		g.progress.emit(progressTableStarted, map[string]any{"table": table, "index": i + 1, "tables": len(tables)})
+		g.hooks.tableStart(TableStartEvent{Table: table, Index: i + 1, Tables: len(tables)})
 		safe := utils.SafeFileName(table)
 
-		// Schema
+		// Schema, and the retention bounding what the window can cover
+		var retention int32
 		if tcli != nil {
 			if resp, err := tcli.Get(g.ctx, rg, wsName, table, nil); err == nil {
 				b, _ := json.MarshalIndent(resp.Table, "", "  ")
 				_ = utils.WriteFileToTar(tarw, filepath.Join("tables", safe, "schema.json"), b)
+				retention = tableRetention(resp.Table)
 			}
 		}
+		cov := g.coverage(table, retention, time.Now())
 
-		err := g.exportTableData(tarw, lcli, table, safe, workspaceGUID, iso, stitchedLogs, stitchedEvents)
+		began := time.Now()
+		tb := g.budget.forTable(table)
+		err := g.exportTableData(tarw, lcli, table, safe, workspaceGUID, iso, transforms, tb, cov)
+		g.budget.done(table, tb)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error exporting table %s: %v\n", table, err)
+			fmt.Fprintf(g.log, "Error exporting table %s: %v\n", table, err)
+			g.outcomes = append(g.outcomes, tableOutcome{table: table, notes: []string{"export failed: " + err.Error()}, err: err, duration: time.Since(began)})
+			g.progress.emit(progressTableDone, map[string]any{"table": table, "status": "failed", "error": err.Error()})
+			g.addWarning(fmt.Sprintf("%s: export failed: %v", table, err))
+			g.hooks.warning(table, "export failed: "+err.Error())
 			continue
 		}
+		if !g.interrupted() {
+			g.completed = append(g.completed, table)
+		}
 	}
 
-	// Write stitched logs into the tar
-	if g.config.StitchLogs {
-		for k, b := range stitchedLogs {
-			if b.Len() == 0 {
-				continue
-			}
-			ns := utils.SafeFileName(k.ns)
-			pod := utils.SafeFileName(k.pod)
-			cn := utils.SafeFileName(k.container)
-			path := filepath.Join("namespaces", ns, "pods", pod, cn+".log")
-			_ = utils.WriteFileToTar(tarw, path, []byte(b.String()))
-		}
-		if g.config.StitchIncludeEvents {
-			for ns, b := range stitchedEvents {
-				if b.Len() == 0 {
-					continue
-				}
-				path := filepath.Join("namespaces", utils.SafeFileName(ns), "events", "events.log")
-				_ = utils.WriteFileToTar(tarw, path, []byte(b.String()))
-			}
+	// Write stitched logs and other derived files into the tar
+	for _, tr := range transforms {
+		if err := tr.finish(tarw); err != nil {
+			return err
 		}
 	}
-
 	return nil
 }
 
-func (g *Gatherer) exportTableData(tarw *tar.Writer, lcli *azquery.LogsClient, table, safe, workspaceGUID, iso string, stitchedLogs map[ckey]*strings.Builder, stitchedEvents map[string]*strings.Builder) error {
-	// Data: chunk queries by hour to avoid limits.
-	// Determine time window now-iso to since.
-	since := time.Now().UTC()
-	// Parse iso timespan to duration for chunking
-	dur := time.Duration(0)
-	if d2, err := utils.ParseISO8601ToDuration(iso); err == nil {
-		dur = d2
-	} else if d3, err := time.ParseDuration(g.config.Timespan); err == nil {
-		dur = d3
-	}
-	start := since.Add(-dur)
-	if dur == 0 {
-		start = since.Add(-2 * time.Hour)
+// newAISummary sets up the --ai-summary transform. Without a usable provider
+// the summary falls back to the digest of notable signals.
+func (g *Gatherer) newAISummary() *aiSummary {
+	ai, err := NewAIQueryGenerator(g.config.AIProvider)
+	if err != nil {
+		g.warnf("", "AI summary disabled: %v", err)
+		ai = nil
+	} else {
+		ai.SetLimits(g.config.llmLimits())
+		ai.setLog(g.log)
 	}
+	return newAISummary(g.ctx, ai, g.signals, g.log)
+}
 
+func (g *Gatherer) exportTableData(tarw *utils.TarWriter, lcli LogsQueryClient, table, safe, workspaceGUID, iso string, transforms []transform, tb *tableBudget, cov *tableCoverage) error {
+	began := time.Now()
+	start, since := g.start, g.end
+	if cov.Clamped {
+		start = cov.start
+	}
 	// chunk = 1h if dur>2h else 15m
 	chunk := time.Hour
-	if dur <= 2*time.Hour {
+	if since.Sub(start) <= 2*time.Hour {
 		chunk = 15 * time.Minute
 	}
 
-	// helpers
-	getBuf := func(k ckey) *strings.Builder {
-		if b, ok := stitchedLogs[k]; ok {
-			return b
-		}
-		b := &strings.Builder{}
-		stitchedLogs[k] = b
-		return b
+	exp := exporterFor(table)
+	query := g.scope.tableQuery(table)
+	if f := g.filters[table]; f != "" {
+		query += " | where " + f
 	}
-	getEvt := func(ns string) *strings.Builder {
-		if b, ok := stitchedEvents[ns]; ok {
-			return b
+	last := g.incremental.since(table)
+	if !last.IsZero() {
+		// Only the rows after the newest one of the last run
+		if t := last.Truncate(time.Second); t.After(start) {
+			start = t
 		}
-		b := &strings.Builder{}
-		stitchedEvents[ns] = b
-		return b
+		query += sinceFilter(last)
 	}
-
+	// newest is the newest row exported with none missing before it: it
+	// stops at the first chunk that failed or was cut short.
+	var newest time.Time
+	contiguous := true
 	rowsTotal := 0
+	var bytesTotal int64
 	chunkIndex := 0
+	cachedChunks := 0
+	bisected := 0
+	failedChunks := 0
+	stopped := false
+	var truncatedWindows []string
+	var columns []exportColumn
 
-	for t0 := start; t0.Before(since); t0 = t0.Add(chunk) {
-		t1 := t0.Add(chunk)
+	// Chunk boundaries are aligned to multiples of the chunk size so that
+	// overlapping runs query identical windows and can share cached results.
+	var windows [][2]time.Time
+	for t0, t1 := start, start; t0.Before(since); t0 = t1 {
+		t1 = t0.Truncate(chunk).Add(chunk)
 		if t1.After(since) {
 			t1 = since
 		}
-		// Build time-bounded query via timespan
-		q := table
-		body := azquery.Body{Query: &q, Timespan: to.Ptr(azquery.NewTimeInterval(t0.UTC(), t1.UTC()))}
-		// Increase server-side wait timeout
-		res, err := lcli.QueryWorkspace(g.ctx, workspaceGUID, body, &azquery.LogsClientQueryWorkspaceOptions{Options: &azquery.LogsQueryOptions{Wait: to.Ptr(180)}})
-		if err != nil {
-			// Note: If the table doesn't exist, ignore.
-			fmt.Fprintf(os.Stderr, "  warn: query chunk failed for %s: %v\n", table, err)
-			continue
+		windows = append(windows, [2]time.Time{t0, t1})
+	}
+
+	// Query up to Parallelism chunks ahead while consuming results in time
+	// order, which the transforms depend on. Leaving the loop early, when the
+	// gather is interrupted or the budget is spent, cancels the chunks still
+	// in flight.
+	ctx, cancel := context.WithCancel(g.ctx)
+	defer cancel()
+	type chunkResult struct {
+		rng rangeResult
+		err error
+	}
+	pending := make([]chan chunkResult, len(windows))
+	launch := func(i int) {
+		pending[i] = make(chan chunkResult, 1)
+		go func(w [2]time.Time, ch chan<- chunkResult) {
+			rng, err := g.queryRange(ctx, lcli, workspaceGUID, query, w[0], w[1], chunk)
+			ch <- chunkResult{rng, err}
+		}(windows[i], pending[i])
+	}
+	next := 0
+	for ; next < len(windows) && next < g.parallelism(); next++ {
+		launch(next)
+	}
+
+	for i, w := range windows {
+		if g.interrupted() {
+			stopped = true
+			break
+		}
+		if tb.spent() {
+			// Out of budget: cancel the chunks queried ahead, whose queries
+			// may have run already, and leave the rest unqueried
+			break
+		}
+		t0, t1 := w[0], w[1]
+		res := <-pending[i]
+		pending[i] = nil
+		// Chunks i+1..next-1 are still in flight
+		for ; next < len(windows) && next-i-1 < g.parallelism(); next++ {
+			launch(next)
 		}
-		if res.Error != nil {
-			fmt.Fprintf(os.Stderr, "  warn: partial/error for %s: %v\n", table, res.Error.Error())
+		rng, err := res.rng, res.err
+		cachedChunks += rng.cached
+		bisected += rng.bisected
+		truncatedWindows = append(truncatedWindows, rng.truncated...)
+		if err != nil && g.interrupted() {
+			stopped = true
+			break
 		}
-		if len(res.Tables) == 0 {
+		if err != nil {
+			g.warnf(table, "query chunk failed for %s: %v", table, err)
+			failedChunks++
+			contiguous = false
 			continue
 		}
-		tab := res.Tables[0]
-		// Create a mapping col index -> name
-		colNames := make([]string, len(tab.Columns))
-		for i, c := range tab.Columns {
-			colNames[i] = *c.Name
+		// Stream NDJSON for this chunk to disk and write it as a separate part
+		// file, so memory does not grow with the chunk size
+		part, err := utils.NewTarSpool()
+		if err != nil {
+			return fmt.Errorf("spool part: %w", err)
+		}
+		// The unredacted rows of a two-tier gather
+		var rawPart *utils.TarSpool
+		if g.restricted != nil {
+			if rawPart, err = utils.NewTarSpool(); err != nil {
+				part.Close()
+				return fmt.Errorf("spool part: %w", err)
+			}
 		}
-		// Build NDJSON for this chunk only and write as a separate part file
-		var partBuilder strings.Builder
 		rowsChunk := 0
+		var bytesChunk int64
+		var newestChunk time.Time
 
-		// If stitching enabled and relevant table, collect rows for sorting
-		type v2row struct {
-			tm  string
-			ns  string
-			pod string
-			cn  string
-			src string
-			msg any
-		}
-		v2rows := make([]v2row, 0, len(tab.Rows))
-		type evtrow struct {
-			tm      string
-			ns      string
-			name    string
-			reason  string
-			message string
-		}
-		evrows := make([]evtrow, 0, len(tab.Rows))
-
-		// Column index helpers
-		idx := func(name string) int {
-			for i, n := range colNames {
-				if n == name {
-					return i
-				}
-			}
-			return -1
-		}
-		timeIdx := idx("TimeGenerated")
-		// For ContainerLogV2
-		nsIdx := idx("PodNamespace")
-		podIdx := idx("PodName")
-		cnIdx := idx("ContainerName")
-		srcIdx := idx("LogSource")
-		msgIdx := idx("LogMessage")
-		// For KubeEvents
-		evNsIdx := idx("Namespace")
-		evNameIdx := idx("Name")
-		evReasonIdx := idx("Reason")
-		evMsgIdx := idx("Message")
-
-		for _, row := range tab.Rows {
-			obj := map[string]any{}
-			for i, v := range row {
-				var val any = v
-				obj[colNames[i]] = val
+	rows:
+		for _, tab := range rng.tables {
+			cols := resultColumns(tab)
+			if columns == nil {
+				columns = cols
 			}
-			b, _ := json.Marshal(obj)
-			partBuilder.Write(b)
-			partBuilder.WriteByte('\n')
-			rowsChunk++
-
-			// Stitch accumulation
-			if g.config.StitchLogs && table == "ContainerLogV2" && timeIdx >= 0 && nsIdx >= 0 && podIdx >= 0 && cnIdx >= 0 && srcIdx >= 0 && msgIdx >= 0 {
-				toStr := func(v any) string {
-					if v == nil {
-						return ""
-					}
-					switch t := v.(type) {
-					case string:
-						return t
-					default:
-						return fmt.Sprint(t)
+			for _, row := range tab.Rows {
+				obj := map[string]any{}
+				for i, v := range row {
+					obj[cols[i].Name] = typedValue(cols[i].Type, v)
+				}
+				if !exp.Transform(table, obj) {
+					continue
+				}
+				scrubRow(table, obj)
+				var raw []byte
+				if rawPart != nil {
+					if raw, err = json.Marshal(obj); err != nil {
+						part.Close()
+						rawPart.Close()
+						return fmt.Errorf("write restricted part: %w", err)
 					}
 				}
-				v2rows = append(v2rows, v2row{
-					tm:  toStr(row[timeIdx]),
-					ns:  toStr(row[nsIdx]),
-					pod: toStr(row[podIdx]),
-					cn:  toStr(row[cnIdx]),
-					src: toStr(row[srcIdx]),
-					msg: row[msgIdx],
-				})
-			}
-			if g.config.StitchLogs && g.config.StitchIncludeEvents && table == "KubeEvents" && timeIdx >= 0 && evNsIdx >= 0 && evNameIdx >= 0 && evReasonIdx >= 0 && evMsgIdx >= 0 {
-				toStr := func(v any) string {
-					if v == nil {
-						return ""
+				g.config.Anonymizer.anonymizeRow(table, obj)
+				g.config.Redaction.redactRow(table, obj, g.redactions)
+				b, err := json.Marshal(obj)
+				if err != nil {
+					part.Close()
+					rawPart.Close()
+					return fmt.Errorf("write part: %w", err)
+				}
+				if !tb.take(len(b) + 1) {
+					tb.stoppedAt = toStr(obj["TimeGenerated"])
+					if tb.stoppedAt == "" {
+						tb.stoppedAt = t0.UTC().Format(time.RFC3339)
 					}
-					switch t := v.(type) {
-					case string:
-						return t
-					default:
-						return fmt.Sprint(t)
+					break rows
+				}
+				b = append(b, '\n')
+				if _, err := part.Write(b); err != nil {
+					part.Close()
+					rawPart.Close()
+					return fmt.Errorf("write part: %w", err)
+				}
+				if rawPart != nil {
+					if _, err := rawPart.Write(append(raw, '\n')); err != nil {
+						part.Close()
+						rawPart.Close()
+						return fmt.Errorf("write restricted part: %w", err)
 					}
 				}
-				evrows = append(evrows, evtrow{
-					tm:      toStr(row[timeIdx]),
-					ns:      toStr(row[evNsIdx]),
-					name:    toStr(row[evNameIdx]),
-					reason:  toStr(row[evReasonIdx]),
-					message: toStr(row[evMsgIdx]),
-				})
+				rowsChunk++
+				bytesChunk += int64(len(b))
+				if ts, err := time.Parse(time.RFC3339Nano, toStr(obj["TimeGenerated"])); err == nil && ts.After(newestChunk) {
+					newestChunk = ts
+				}
+
+				for _, tr := range transforms {
+					tr.observe(table, obj)
+				}
 			}
 		}
 		if rowsChunk > 0 {
 			partName := fmt.Sprintf("parts/%04d-%s_%s.ndjson", chunkIndex, t0.UTC().Format(time.RFC3339), t1.UTC().Format(time.RFC3339))
-			_ = utils.WriteFileToTar(tarw, filepath.Join("tables", safe, partName), []byte(partBuilder.String()))
+			err = part.WriteToTar(tarw, filepath.Join("tables", safe, partName))
+			if err != nil {
+				part.Close()
+				rawPart.Close()
+				return fmt.Errorf("write part: %w", err)
+			}
+			if rawPart != nil {
+				if err := rawPart.WriteToTar(g.restricted.tarw, filepath.Join("tables", safe, partName)); err != nil {
+					part.Close()
+					rawPart.Close()
+					return fmt.Errorf("write restricted part: %w", err)
+				}
+			}
 			chunkIndex++
 			rowsTotal += rowsChunk
+			bytesTotal += bytesChunk
+		}
+		part.Close()
+		rawPart.Close()
+		if tb != nil && tb.stoppedAt != "" || len(rng.truncated) > 0 {
+			contiguous = false
 		}
+		if contiguous && newestChunk.After(newest) {
+			newest = newestChunk
+		}
+		g.progress.emit(progressChunkDone, map[string]any{
+			"table":  table,
+			"chunk":  i + 1,
+			"chunks": len(windows),
+			"start":  t0.UTC().Format(time.RFC3339),
+			"end":    t1.UTC().Format(time.RFC3339),
+			"rows":   rowsChunk,
+			"bytes":  bytesChunk,
+			"cached": rng.cached > 0,
+		})
+		g.hooks.chunkDone(ChunkDoneEvent{Table: table, Chunk: i + 1, Chunks: len(windows), Start: t0.UTC(), End: t1.UTC(),
+			Rows: rowsChunk, Bytes: bytesChunk, Cached: rng.cached > 0})
 
-		// After writing parts, write stitched chunk into builders in time order
-		if g.config.StitchLogs && table == "ContainerLogV2" && len(v2rows) > 0 {
-			sort.Slice(v2rows, func(i, j int) bool {
-				ti := utils.ParseTimeRFC3339(v2rows[i].tm)
-				tj := utils.ParseTimeRFC3339(v2rows[j].tm)
-				if ti.IsZero() || tj.IsZero() {
-					return v2rows[i].tm < v2rows[j].tm
-				}
-				return ti.Before(tj)
-			})
-			// marshal message
-			for _, r := range v2rows {
-				if r.ns == "" && r.pod == "" && r.cn == "" {
-					continue
-				}
-				// format line
-				ts := utils.ParseTimeRFC3339(r.tm).Format(time.RFC3339Nano)
-				if ts == "0001-01-01T00:00:00Z" {
-					ts = r.tm
-				}
-				msg := ""
-				switch m := r.msg.(type) {
-				case string:
-					msg = m
-				case map[string]any, []any:
-					if bb, err := json.Marshal(m); err == nil {
-						msg = string(bb)
-					} else {
-						msg = fmt.Sprint(m)
-					}
-				default:
-					msg = fmt.Sprint(m)
-				}
-				msg = strings.ReplaceAll(msg, "\r", "")
-				msg = strings.ReplaceAll(msg, "\n", "\\n")
-				line := fmt.Sprintf("%s [%s] %s\n", ts, r.src, msg)
-				buf := getBuf(ckey{ns: r.ns, pod: r.pod, container: r.cn})
-				buf.WriteString(line)
+		// After writing parts, let transforms flush this chunk in time order
+		for _, tr := range transforms {
+			if err := tr.endChunk(tarw); err != nil {
+				return err
 			}
 		}
-		if g.config.StitchLogs && g.config.StitchIncludeEvents && table == "KubeEvents" && len(evrows) > 0 {
-			sort.Slice(evrows, func(i, j int) bool {
-				ti := utils.ParseTimeRFC3339(evrows[i].tm)
-				tj := utils.ParseTimeRFC3339(evrows[j].tm)
-				if ti.IsZero() || tj.IsZero() {
-					return evrows[i].tm < evrows[j].tm
-				}
-				return ti.Before(tj)
-			})
-			for _, r := range evrows {
-				ns := r.ns
-				if ns == "" {
-					ns = "default"
-				}
-				ts := utils.ParseTimeRFC3339(r.tm).Format(time.RFC3339Nano)
-				if ts == "0001-01-01T00:00:00Z" {
-					ts = r.tm
-				}
-				line := fmt.Sprintf("%s %s/%s %s %s\n", ts, ns, r.name, r.reason, strings.ReplaceAll(r.message, "\n", " "))
-				buf := getEvt(ns)
-				buf.WriteString(line)
-			}
+	}
+	if columns != nil {
+		cb, _ := json.MarshalIndent(columns, "", "  ")
+		_ = utils.WriteFileToTar(tarw, filepath.Join("tables", safe, "columns.json"), cb)
+		if g.restricted != nil {
+			_ = utils.WriteFileToTar(g.restricted.tarw, filepath.Join("tables", safe, "columns.json"), cb)
+			g.restricted.tables = append(g.restricted.tables, table)
 		}
 	}
+
 	// Write summary
-	sum := map[string]any{"table": table, "rows": rowsTotal, "duration": iso}
+	sum := map[string]any{"table": table, "rows": rowsTotal, "duration": iso, "coverage": cov}
+	if g.cache != nil {
+		sum["cachedChunks"] = cachedChunks
+	}
+	if bisected > 0 {
+		sum["bisectedQueries"] = bisected
+	}
+	if tb != nil {
+		sum["budget"] = tb.summary()
+	}
+	if stopped {
+		// Interrupted before all chunks were exported
+		sum["incomplete"] = true
+	}
+	if len(truncatedWindows) > 0 {
+		// Windows still truncated at the smallest bisectable size
+		sum["truncatedWindows"] = truncatedWindows
+	}
+	if !last.IsZero() {
+		sum["sinceLastRun"] = last.Format(time.RFC3339Nano)
+	}
+	if f := g.filters[table]; f != "" {
+		sum["filter"] = f
+	}
+	// Rows are ingested up to cacheSettleDelay late, with their original
+	// TimeGenerated: the next run starts before the rows that may still
+	// arrive, exporting some again rather than skipping late ones.
+	if settled := g.end.Add(-cacheSettleDelay); newest.After(settled) {
+		newest = settled
+	}
+	g.incremental.advance(table, newest)
+	outcome := tableOutcome{table: table, rows: rowsTotal, bytes: bytesTotal, duration: time.Since(began), failedChunks: failedChunks}
+	if note := cov.note(); note != "" {
+		outcome.notes = append(outcome.notes, note)
+	}
+	if stopped {
+		outcome.notes = append(outcome.notes, "incomplete, gather interrupted")
+	}
+	if tb != nil && tb.stoppedAt != "" {
+		outcome.notes = append(outcome.notes, "budget used up, rows from "+tb.stoppedAt+" onward not exported")
+	}
+	if len(truncatedWindows) > 0 {
+		outcome.notes = append(outcome.notes, fmt.Sprintf("%d windows truncated by the service", len(truncatedWindows)))
+	}
+	g.outcomes = append(g.outcomes, outcome)
+	var warnings []string
+	for _, tr := range transforms {
+		warnings = append(warnings, tr.warnings(table)...)
+	}
+	if len(warnings) > 0 {
+		sum["warnings"] = warnings
+	}
+	done := map[string]any{"table": table, "status": "ok", "rows": rowsTotal, "bytes": bytesTotal}
+	if stopped {
+		done["status"] = "incomplete"
+	}
+	if len(outcome.notes) > 0 {
+		done["notes"] = outcome.notes
+	}
+	if len(warnings) > 0 {
+		done["warnings"] = warnings
+	}
+	for _, w := range warnings {
+		g.addWarning(table + ": " + w)
+		g.hooks.warning(table, w)
+	}
+	g.progress.emit(progressTableDone, done)
 	b, _ := json.MarshalIndent(sum, "", "  ")
 	_ = utils.WriteFileToTar(tarw, filepath.Join("tables", safe, "summary.json"), b)
 
 	return nil
 }
+
+// queryChunk runs the query for a single time chunk, serving it from the local
+// cache when possible. A nil table means the query returned no result set;
+// truncated reports that the service capped the result.
+func (g *Gatherer) queryChunk(ctx context.Context, lcli LogsQueryClient, workspaceGUID, query string, t0, t1 time.Time, chunk time.Duration) (tab *azquery.Table, cached, truncated bool, err error) {
+	// Only whole, aligned chunks are cacheable; partial edge chunks never recur.
+	cacheable := t0.Equal(t0.Truncate(chunk)) && t1.Sub(t0) == chunk
+	if cacheable {
+		if tab := g.cache.get(workspaceGUID, query, t0, t1); tab != nil {
+			g.provenance.record(query, t0, t1, true, nil)
+			return tab, true, false, nil
+		}
+	}
+
+	res, err := g.query(ctx, lcli, workspaceGUID, query, t0, t1)
+	if err != nil {
+		return nil, false, false, err
+	}
+	// A scoped query starts with its table
+	table, _, _ := strings.Cut(query, " ")
+	truncated = isTruncated(res)
+	if res.Error != nil && !truncated {
+		g.warnf(table, "partial/error for %s: %v", table, res.Error.Error())
+	}
+	if len(res.Tables) == 0 {
+		return nil, false, truncated, nil
+	}
+	tab = res.Tables[0]
+	if cacheable && res.Error == nil && !truncated {
+		if err := g.cache.put(workspaceGUID, query, t0, t1, tab); err != nil {
+			g.warnf(table, "caching chunk for %s: %v", table, err)
+		}
+	}
+	return tab, false, truncated, nil
+}
+
+func (g *Gatherer) logsOptions() *azquery.LogsClientOptions {
+	return &azquery.LogsClientOptions{ClientOptions: azcore.ClientOptions{
+		Cloud:     g.cloud,
+		Transport: g.usage,
+		Retry:     policy.RetryOptions{StatusCodes: sdkRetryStatusCodes},
+	}}
+}
+
+func (g *Gatherer) armOptions() *arm.ClientOptions {
+	return &arm.ClientOptions{ClientOptions: azcore.ClientOptions{Cloud: g.cloud, Transport: g.usage}}
+}
+
+// query runs a single workspace query over [t0, t1). Every data-plane query
+// of a gather goes through here so it is accounted for in the run summary.
+// Throttled queries (429/503) are retried up to MaxRetries times with backoff,
+// and every attempt waits for the gather's shared rate limiter. With
+// BatchQueries, queries are sent through the batcher. Cancelling ctx ends the
+// wait for the limiter or a retry and the query in flight.
+func (g *Gatherer) query(ctx context.Context, lcli LogsQueryClient, workspaceGUID, query string, t0, t1 time.Time) (res azquery.LogsClientQueryWorkspaceResponse, err error) {
+	defer func() { g.provenance.record(query, t0, t1, false, err) }()
+	body := azquery.Body{Query: &query, Timespan: to.Ptr(azquery.NewTimeInterval(t0.UTC(), t1.UTC()))}
+	for attempt := 0; ; attempt++ {
+		if err := g.limiter.wait(ctx); err != nil {
+			return azquery.LogsClientQueryWorkspaceResponse{}, err
+		}
+		g.usage.queries.Add(1)
+		// Increase server-side wait timeout
+		opts := azquery.LogsQueryOptions{Wait: to.Ptr(180)}
+		if g.batcher != nil {
+			res, err = g.batcher.query(ctx, workspaceGUID, body, opts)
+		} else {
+			res, err = lcli.QueryWorkspace(ctx, workspaceGUID, body, &azquery.LogsClientQueryWorkspaceOptions{Options: &opts})
+		}
+		if err == nil {
+			return res, nil
+		}
+		delay, throttled := throttleDelay(err, attempt)
+		if !throttled || attempt >= g.config.MaxRetries {
+			if ctx.Err() == nil {
+				g.usage.failed.Add(1)
+			}
+			if throttled {
+				err = markErr(ErrThrottled, err)
+			}
+			return res, err
+		}
+		g.usage.retries.Add(1)
+		g.warnf("", "query throttled, retrying in %s (attempt %d/%d)", delay.Round(time.Millisecond), attempt+1, g.config.MaxRetries)
+		if err := sleepCtx(ctx, delay); err != nil {
+			return res, err
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package mustgather
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+)
+
+// GrepOptions selects the table rows Grep writes.
+type GrepOptions struct {
+	// Where is the RowFilter rows must match.
+	Where string
+	// Tables are glob patterns of the tables to search, e.g. Kube*. Empty
+	// searches every table.
+	Tables []string
+	// MaxCount stops the search after that many matching rows; 0 does not.
+	MaxCount int
+}
+
+// GrepStats counts what Grep searched and found.
+type GrepStats struct {
+	Tables  int
+	Parts   int
+	Rows    int
+	Matched int
+}
+
+// errGrepDone stops the walk of a bundle once MaxCount rows matched.
+var errGrepDone = errors.New("enough rows matched")
+
+// Grep writes the NDJSON rows of the table parts of the bundle at src
+// (archive or extracted directory) that match opts.Where to out, unchanged
+// and in bundle order. Archives are read as a stream.
+func Grep(src string, opts GrepOptions, out io.Writer) (*GrepStats, error) {
+	filter, err := ParseRowFilter(opts.Where)
+	if err != nil {
+		return nil, fmt.Errorf("invalid expression: %w", err)
+	}
+	for _, p := range opts.Tables {
+		if _, err := path.Match(p, ""); err != nil {
+			return nil, fmt.Errorf("invalid table pattern %q: %w", p, err)
+		}
+	}
+	stats := &GrepStats{}
+	tables := map[string]bool{}
+	bw := bufio.NewWriter(out)
+	err = walkBundle(src, func(name string, r io.Reader) error {
+		if !isTablePart(name) {
+			return nil
+		}
+		table := strings.Split(name, "/")[1]
+		if !matchTable(opts.Tables, table) {
+			return nil
+		}
+		if !tables[table] {
+			tables[table] = true
+			stats.Tables++
+		}
+		stats.Parts++
+		sc := bufio.NewScanner(r)
+		sc.Buffer(make([]byte, 64*1024), 16*1024*1024)
+		for sc.Scan() {
+			if len(bytes.TrimSpace(sc.Bytes())) == 0 {
+				continue
+			}
+			stats.Rows++
+			if !filter.MatchLine(sc.Bytes()) {
+				continue
+			}
+			stats.Matched++
+			bw.Write(sc.Bytes())
+			bw.WriteByte('\n')
+			if opts.MaxCount > 0 && stats.Matched >= opts.MaxCount {
+				return errGrepDone
+			}
+		}
+		if err := sc.Err(); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, errGrepDone) {
+		return stats, err
+	}
+	return stats, bw.Flush()
+}
+
+// matchTable reports whether table matches one of patterns. Empty patterns
+// match every table.
+func matchTable(patterns []string, table string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	for _, p := range patterns {
+		if ok, _ := path.Match(p, table); ok {
+			return true
+		}
+	}
+	return false
+}
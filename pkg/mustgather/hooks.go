@@ -0,0 +1,91 @@
+package mustgather
+
+import "time"
+
+// Hooks receive the progress of a table-export gather as it happens, e.g. for
+// an embedding application to render its own progress or collect warnings.
+// Tables are exported one at a time, but warnings also come from chunks
+// queried concurrently, so OnWarning may be called from several goroutines.
+// Hooks run on the gather's path and should return quickly. Embed NopHooks
+// to implement only some of them.
+type Hooks interface {
+	// OnTableStart is called before the export of each table.
+	OnTableStart(TableStartEvent)
+	// OnChunkDone is called when a time chunk of a table is written.
+	OnChunkDone(ChunkDoneEvent)
+	// OnWarning is called with each warning of the gather.
+	OnWarning(WarningEvent)
+	// OnFileWritten is called when a file of the archive is complete.
+	OnFileWritten(FileWrittenEvent)
+}
+
+// TableStartEvent is the start of the export of a table, the Index-th of
+// Tables.
+type TableStartEvent struct {
+	Table  string
+	Index  int
+	Tables int
+}
+
+// ChunkDoneEvent is a time chunk of a table written to the archive, the
+// Chunk-th of Chunks. Cached is set when it came from the query cache.
+type ChunkDoneEvent struct {
+	Table  string
+	Chunk  int
+	Chunks int
+	Start  time.Time
+	End    time.Time
+	Rows   int
+	Bytes  int64
+	Cached bool
+}
+
+// WarningEvent is a gather warning; Table is empty for warnings not about a
+// table.
+type WarningEvent struct {
+	Table   string
+	Message string
+}
+
+// FileWrittenEvent is a file of the archive, with the SHA-256 of its content.
+type FileWrittenEvent struct {
+	Path   string
+	Size   int64
+	SHA256 string
+}
+
+// NopHooks implements Hooks by doing nothing.
+type NopHooks struct{}
+
+func (NopHooks) OnTableStart(TableStartEvent)   {}
+func (NopHooks) OnChunkDone(ChunkDoneEvent)     {}
+func (NopHooks) OnWarning(WarningEvent)         {}
+func (NopHooks) OnFileWritten(FileWrittenEvent) {}
+
+// hookList calls every Hooks of a gather in order; it is empty without
+// WithHooks.
+type hookList []Hooks
+
+func (l hookList) tableStart(e TableStartEvent) {
+	for _, h := range l {
+		h.OnTableStart(e)
+	}
+}
+
+func (l hookList) chunkDone(e ChunkDoneEvent) {
+	for _, h := range l {
+		h.OnChunkDone(e)
+	}
+}
+
+func (l hookList) warning(table, msg string) {
+	for _, h := range l {
+		h.OnWarning(WarningEvent{Table: table, Message: msg})
+	}
+}
+
+func (l hookList) fileWritten(e FileWrittenEvent) {
+	for _, h := range l {
+		h.OnFileWritten(e)
+	}
+}
@@ -0,0 +1,134 @@
+package mustgather
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	azquery "github.com/Azure/azure-sdk-for-go/sdk/monitor/azquery"
+)
+
+// recordingHooks records the events of a gather.
+type recordingHooks struct {
+	NopHooks
+	mu       sync.Mutex
+	tables   []TableStartEvent
+	chunks   []ChunkDoneEvent
+	warnings []WarningEvent
+	files    []string
+}
+
+func (h *recordingHooks) OnTableStart(e TableStartEvent) { h.tables = append(h.tables, e) }
+func (h *recordingHooks) OnChunkDone(e ChunkDoneEvent)   { h.chunks = append(h.chunks, e) }
+func (h *recordingHooks) OnFileWritten(e FileWrittenEvent) {
+	h.files = append(h.files, e.Path)
+}
+
+func (h *recordingHooks) OnWarning(e WarningEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.warnings = append(h.warnings, e)
+}
+
+func TestGatherHooks(t *testing.T) {
+	ts := time.Now().Add(-10 * time.Minute).UTC().Format(time.RFC3339Nano)
+	logs := &fakeLogs{rows: map[string][]azquery.Row{"Heartbeat": {{ts, "node-1"}}}}
+	config := &Config{
+		WorkspaceID: "/subscriptions/sub/resourceGroups/rg/providers/Microsoft.OperationalInsights/workspaces/ws",
+		Timespan:    "PT1H",
+		OutputFile:  filepath.Join(t.TempDir(), "bundle.tar.gz"),
+		TableFilter: "Heartbeat,Syslog",
+		Quiet:       true,
+	}
+	hooks := &recordingHooks{}
+	other := &recordingHooks{}
+	g, err := NewGatherer(context.Background(), config, WithCredential(noCredential{}), WithLogsClient(logs),
+		WithWorkspacesClient(fakeWorkspaces{}), WithTablesClient(fakeTables{names: []string{"Heartbeat"}}), WithHooks(hooks, other))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := g.Run(); err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+
+	if len(hooks.tables) != 1 || hooks.tables[0] != (TableStartEvent{Table: "Heartbeat", Index: 1, Tables: 1}) {
+		t.Errorf("unexpected table starts: %+v", hooks.tables)
+	}
+	rows := 0
+	for _, c := range hooks.chunks {
+		if c.Table != "Heartbeat" || c.Chunks != len(hooks.chunks) || !c.Start.Before(c.End) {
+			t.Errorf("unexpected chunk: %+v", c)
+		}
+		rows += c.Rows
+	}
+	if len(hooks.chunks) == 0 || rows != 1 {
+		t.Errorf("expected the chunks of Heartbeat with 1 row, got %+v", hooks.chunks)
+	}
+	if len(hooks.warnings) == 0 || !strings.Contains(hooks.warnings[0].Message, "Syslog") {
+		t.Errorf("expected a warning about the missing table, got %+v", hooks.warnings)
+	}
+	files := strings.Join(hooks.files, ",")
+	if !strings.Contains(files, "index.json") || !strings.Contains(files, "tables/Heartbeat/summary.json") {
+		t.Errorf("unexpected files: %s", files)
+	}
+	if len(other.tables) != 1 || len(other.files) != len(hooks.files) {
+		t.Error("expected every hook to be called")
+	}
+}
+
+// cancelHooks cancels the gather once the file at path is written.
+type cancelHooks struct {
+	NopHooks
+	path   string
+	cancel context.CancelFunc
+}
+
+func (h cancelHooks) OnFileWritten(e FileWrittenEvent) {
+	if e.Path == h.path {
+		h.cancel()
+	}
+}
+
+func TestGatherInterruptedWhileFinishing(t *testing.T) {
+	ts := time.Now().Add(-10 * time.Minute).UTC().Format(time.RFC3339Nano)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sink := &memorySink{files: map[string]string{}}
+	config := &Config{
+		WorkspaceID: "/subscriptions/sub/resourceGroups/rg/providers/Microsoft.OperationalInsights/workspaces/ws",
+		Timespan:    "PT1H",
+		TableFilter: "Heartbeat",
+		Quiet:       true,
+		Metrics:     NewMetrics(),
+	}
+	g, err := NewGatherer(ctx, config, WithCredential(noCredential{}),
+		WithLogsClient(&fakeLogs{rows: map[string][]azquery.Row{"Heartbeat": {{ts, "node-1"}}}}),
+		WithWorkspacesClient(fakeWorkspaces{}), WithTablesClient(fakeTables{names: []string{"Heartbeat"}}),
+		WithSink(sink), WithHooks(cancelHooks{path: "metadata/run.json", cancel: cancel}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Cancelled after run.json: the rest of the bundle and the error agree
+	// with it, as do the result and metrics
+	r, err := g.Run()
+	if err != nil {
+		t.Errorf("Run: %v", err)
+	}
+	if r.Status != "ok" {
+		t.Errorf("result status = %s, want ok", r.Status)
+	}
+	if n := config.Metrics.gathers[outcomeSucceeded]; n != 1 {
+		t.Errorf("metrics counted %d succeeded gathers, want 1", n)
+	}
+	for _, name := range []string{"metadata/run.json", "index.json"} {
+		if strings.Contains(sink.files[name], "incomplete") {
+			t.Errorf("%s marked incomplete: %s", name, sink.files[name])
+		}
+	}
+	if strings.Contains(sink.files["SUMMARY.md"], "incomplete") {
+		t.Errorf("SUMMARY.md marked incomplete")
+	}
+}
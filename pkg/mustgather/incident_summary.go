@@ -0,0 +1,176 @@
+package mustgather
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// incidentSummaryPath is where every gather writes its human readable
+// summary.
+const incidentSummaryPath = "SUMMARY.md"
+
+// incidentTopN caps each list in SUMMARY.md, which is meant to be pasted into
+// an incident channel.
+const incidentTopN = 10
+
+// tableOutcome is what the export of one table produced.
+type tableOutcome struct {
+	table string
+	rows  int
+	bytes int64
+	// notes explain why the table may be incomplete.
+	notes []string
+	// err is why the export failed, and duration how long it took.
+	err      error
+	duration time.Duration
+	// failedChunks counts the chunks whose query failed.
+	failedChunks int
+}
+
+// incidentSummary renders SUMMARY.md: the gather parameters, row counts per
+// table and the top signals of the window, as a short markdown list.
+type incidentSummary struct {
+	generatedAt time.Time
+	// loc is the zone times are shown in; nil for UTC
+	loc *time.Location
+
+	workspaceID, workspaceGUID                 string
+	subscription, resourceGroup, workspaceName string
+
+	start, end time.Time
+	timespan   string
+	profiles   string
+	tables     []string
+	// missing are requested tables the workspace does not have
+	missing    []string
+	incomplete bool
+
+	outcomes []tableOutcome
+	signals  *clusterSignals
+}
+
+// time formats t as RFC 3339 in the summary's zone.
+func (s *incidentSummary) time(t time.Time) string {
+	if s.loc != nil {
+		return t.In(s.loc).Format(time.RFC3339)
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+func (s *incidentSummary) render() string {
+	var sb strings.Builder
+	sb.WriteString("# Must-gather summary\n\n")
+
+	status := "complete"
+	if s.incomplete {
+		status = "INCOMPLETE (interrupted)"
+	}
+	ws := s.workspaceID
+	if s.workspaceName != "" {
+		ws = fmt.Sprintf("%s (subscription %s, resource group %s)", s.workspaceName, s.subscription, s.resourceGroup)
+	}
+	fmt.Fprintf(&sb, "- Workspace: %s, GUID %s\n", orNone(ws), orNone(s.workspaceGUID))
+	fmt.Fprintf(&sb, "- Window: %s to %s (%s)\n", s.time(s.start), s.time(s.end), s.timespan)
+	fmt.Fprintf(&sb, "- Profiles: %s\n", orNone(s.profiles))
+	fmt.Fprintf(&sb, "- Generated: %s, %s\n", s.time(s.generatedAt), status)
+
+	sb.WriteString("\n## Tables\n\n")
+	exported := map[string]tableOutcome{}
+	for _, o := range s.outcomes {
+		exported[o.table] = o
+	}
+	for _, t := range s.tables {
+		o, ok := exported[t]
+		switch {
+		case !ok:
+			fmt.Fprintf(&sb, "- %s: not exported\n", t)
+		case len(o.notes) > 0:
+			fmt.Fprintf(&sb, "- %s: %d rows (%s)\n", t, o.rows, strings.Join(o.notes, "; "))
+		default:
+			fmt.Fprintf(&sb, "- %s: %d rows\n", t, o.rows)
+		}
+	}
+	for _, t := range s.missing {
+		fmt.Fprintf(&sb, "- %s: not in the workspace, skipped\n", t)
+	}
+	if len(s.tables) == 0 && len(s.missing) == 0 {
+		sb.WriteString("- none\n")
+	}
+
+	sig := s.signals
+	if sig == nil {
+		sig = newClusterSignals()
+	}
+
+	sb.WriteString("\n## Top warning events\n\n")
+	oom, warnings := sig.eventGroups()
+	events := append(oom, warnings...)
+	sort.SliceStable(events, func(i, j int) bool { return events[i].count > events[j].count })
+	for i, e := range events {
+		if i == incidentTopN {
+			fmt.Fprintf(&sb, "- ... %d more\n", len(events)-i)
+			break
+		}
+		fmt.Fprintf(&sb, "- %s x%d on %s %s: %s (last %s)\n", e.reason, e.count, orNone(e.kind), eventObject(e), truncateSample(e.message), formatStitchTime(e.last, s.loc))
+	}
+	if len(events) == 0 {
+		sb.WriteString("- none\n")
+	}
+
+	sb.WriteString("\n## Top error log sources\n\n")
+	sources := sig.errorSources()
+	for i, src := range sources {
+		if i == incidentTopN {
+			fmt.Fprintf(&sb, "- ... %d more\n", len(sources)-i)
+			break
+		}
+		fmt.Fprintf(&sb, "- %s/%s/%s: %d error lines, e.g. %q\n", src.namespace, src.pod, src.container, src.count, src.sample)
+	}
+	if len(sources) == 0 {
+		sb.WriteString("- none\n")
+	}
+
+	sb.WriteString("\n## Pods with restarts\n\n")
+	restarting := sig.restarting()
+	for i, c := range restarting {
+		if i == incidentTopN {
+			fmt.Fprintf(&sb, "- ... %d more\n", len(restarting)-i)
+			break
+		}
+		line := fmt.Sprintf("- %s: %d restarts (%d during the window)", containerKey(c), c.maxRestarts, c.maxRestarts-c.minRestarts)
+		if c.reason != "" {
+			line += ", " + c.reason
+		}
+		if c.oomKilled {
+			line += ", OOMKilled"
+		}
+		sb.WriteString(line + "\n")
+	}
+	if len(restarting) == 0 {
+		sb.WriteString("- none\n")
+	}
+
+	sb.WriteString("\n## Nodes with pressure conditions\n\n")
+	nodes := sig.pressuredNodes()
+	for i, n := range nodes {
+		if i == incidentTopN {
+			fmt.Fprintf(&sb, "- ... %d more\n", len(nodes)-i)
+			break
+		}
+		fmt.Fprintf(&sb, "- %s: %s (%s to %s)\n", n.node, n.conditionList(), orNone(formatStitchTime(n.first, s.loc)), orNone(formatStitchTime(n.last, s.loc)))
+	}
+	if len(nodes) == 0 {
+		sb.WriteString("- none\n")
+	}
+	return sb.String()
+}
+
+// eventObject names the object an event is about, namespaced when it is.
+func eventObject(e *eventGroup) string {
+	if e.namespace == "" {
+		return e.name
+	}
+	return e.namespace + "/" + e.name
+}
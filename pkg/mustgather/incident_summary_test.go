@@ -0,0 +1,82 @@
+package mustgather
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestIncidentSummaryRender(t *testing.T) {
+	s := newClusterSignals()
+	observeSignalsFixture(s)
+	s.observe("KubeNodeInventory", map[string]any{"TimeGenerated": "2024-01-01T00:05:00Z", "Computer": "aks-node-1", "Status": "Ready,MemoryPressure"})
+
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	sum := &incidentSummary{
+		generatedAt:   start.Add(2 * time.Hour),
+		workspaceID:   "/subscriptions/sub/resourceGroups/rg/providers/Microsoft.OperationalInsights/workspaces/ws",
+		workspaceGUID: "guid",
+		subscription:  "sub",
+		resourceGroup: "rg",
+		workspaceName: "ws",
+		start:         start,
+		end:           start.Add(time.Hour),
+		timespan:      "PT1H",
+		profiles:      "aks-debug",
+		tables:        []string{"KubePodInventory", "ContainerLogV2", "KubeEvents"},
+		missing:       []string{"Syslog"},
+		outcomes: []tableOutcome{
+			{table: "KubePodInventory", rows: 4},
+			{table: "ContainerLogV2", rows: 11, notes: []string{"budget used up, rows from 2024-01-01T00:30:00Z onward not exported"}},
+		},
+		signals: s,
+	}
+	got := sum.render()
+	for _, want := range []string{
+		"- Workspace: ws (subscription sub, resource group rg), GUID guid\n",
+		"- Window: 2024-01-01T00:00:00Z to 2024-01-01T01:00:00Z (PT1H)\n",
+		"- Generated: 2024-01-01T02:00:00Z, complete\n",
+		"- KubePodInventory: 4 rows\n",
+		"- ContainerLogV2: 11 rows (budget used up, rows from 2024-01-01T00:30:00Z onward not exported)\n",
+		"- KubeEvents: not exported\n",
+		"- Syslog: not in the workspace, skipped\n",
+		"- BackOff x5 on Pod shop/cart-7d9f: Back-off restarting failed container (last 2024-01-01T00:05:00Z)\n",
+		`- shop/cart-7d9f/cart: 10 error lines, e.g. "ERROR connection refused"`,
+		"- shop/cart-7d9f/cart: 7 restarts (4 during the window), CrashLoopBackOff, OOMKilled\n",
+		"## Nodes with pressure conditions\n\n- aks-node-1: MemoryPressure (2024-01-01T00:05:00Z to 2024-01-01T00:05:00Z)\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("SUMMARY.md missing %q:\n%s", want, got)
+		}
+	}
+	if strings.Contains(got, "web-1/web: 0 restarts") || strings.Contains(got, "Scheduled") {
+		t.Errorf("healthy containers and normal events should be left out:\n%s", got)
+	}
+}
+
+func TestIncidentSummaryTimezone(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := newClusterSignals()
+	observeSignalsFixture(s)
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	got := (&incidentSummary{generatedAt: start.Add(2 * time.Hour), loc: berlin, start: start, end: start.Add(time.Hour), timespan: "PT1H", signals: s}).render()
+	for _, want := range []string{
+		"- Window: 2024-01-01T01:00:00+01:00 to 2024-01-01T02:00:00+01:00 (PT1H)\n",
+		"- Generated: 2024-01-01T03:00:00+01:00, complete\n",
+		"(last 2024-01-01T01:05:00+01:00)\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("SUMMARY.md missing %q:\n%s", want, got)
+		}
+	}
+}
+
+func TestIncidentSummaryEmpty(t *testing.T) {
+	got := (&incidentSummary{incomplete: true, tables: []string{"Heartbeat"}}).render()
+	if !strings.Contains(got, "INCOMPLETE (interrupted)") || !strings.Contains(got, "## Pods with restarts\n\n- none\n") {
+		t.Errorf("unexpected empty summary:\n%s", got)
+	}
+}
@@ -0,0 +1,208 @@
+package mustgather
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// serviceAccountDir is where Kubernetes mounts the pod's service account
+// token, CA and namespace.
+var serviceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+
+// eventComponent is the source of the Events an in-cluster gather posts.
+const eventComponent = "aks-must-gather"
+
+// kubeAPI talks to the API server of the cluster the process runs in, as the
+// pod's service account. Scheduled gathers post core/v1 Events about their pod
+// with it, so `kubectl get events` and alerting on Warning events show how
+// they went, and the controller reads and updates MustGather resources.
+type kubeAPI struct {
+	server    string
+	token     string
+	namespace string
+	pod       string
+	client    *http.Client
+}
+
+// kubeRequestTimeout bounds API requests other than watches.
+const kubeRequestTimeout = 30 * time.Second
+
+// inClusterAPI returns the API client of the pod the process runs in, from
+// the service account mount and the environment Kubernetes sets. The pod is
+// $POD_NAME, e.g. from the downward API, else $HOSTNAME.
+func inClusterAPI() (*kubeAPI, error) {
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil, errors.New("not running in a Kubernetes pod: KUBERNETES_SERVICE_HOST is not set")
+	}
+	token, err := os.ReadFile(filepath.Join(serviceAccountDir, "token"))
+	if err != nil {
+		return nil, fmt.Errorf("read service account token: %w", err)
+	}
+	ns, err := os.ReadFile(filepath.Join(serviceAccountDir, "namespace"))
+	if err != nil {
+		return nil, fmt.Errorf("read service account namespace: %w", err)
+	}
+	ca, err := os.ReadFile(filepath.Join(serviceAccountDir, "ca.crt"))
+	if err != nil {
+		return nil, fmt.Errorf("read service account CA: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return nil, errors.New("service account CA holds no certificate")
+	}
+	pod := os.Getenv("POD_NAME")
+	if pod == "" {
+		pod, _ = os.Hostname()
+	}
+	return &kubeAPI{
+		server:    "https://" + net.JoinHostPort(host, port),
+		token:     strings.TrimSpace(string(token)),
+		namespace: strings.TrimSpace(string(ns)),
+		pod:       pod,
+		// Watches stay open, so requests are bounded by their context
+		// rather than a client timeout
+		client: &http.Client{
+			Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12}},
+		},
+	}, nil
+}
+
+// open sends a request to the API server, returning the response body of a
+// successful one and the message of the API server's Status otherwise.
+func (k *kubeAPI) open(ctx context.Context, method, path, contentType string, body any) (io.ReadCloser, error) {
+	var r io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		r = bytes.NewReader(b)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, k.server+path, r)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+k.token)
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", contentType)
+	}
+	resp, err := k.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		defer resp.Body.Close()
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		var status struct {
+			Message string `json:"message"`
+		}
+		if json.Unmarshal(msg, &status) == nil && status.Message != "" {
+			return nil, fmt.Errorf("%s: %s", resp.Status, status.Message)
+		}
+		return nil, errors.New(resp.Status)
+	}
+	return resp.Body, nil
+}
+
+// do sends a request with a JSON body and decodes the JSON response into out
+// unless it is nil.
+func (k *kubeAPI) do(ctx context.Context, method, path, contentType string, body, out any) error {
+	ctx, cancel := context.WithTimeout(ctx, kubeRequestTimeout)
+	defer cancel()
+	rc, err := k.open(ctx, method, path, contentType, body)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(rc).Decode(out)
+}
+
+// post creates an Event of type Normal or Warning with reason and message
+// about the pod.
+func (k *kubeAPI) post(ctx context.Context, eventType, reason, message string) error {
+	pod := map[string]any{"apiVersion": "v1", "kind": "Pod", "name": k.pod, "namespace": k.namespace}
+	return k.postEvent(ctx, pod, eventType, reason, message)
+}
+
+// postEvent creates an Event about object, a reference with the apiVersion,
+// kind, name and namespace of a namespaced object, in its namespace.
+func (k *kubeAPI) postEvent(ctx context.Context, object map[string]any, eventType, reason, message string) error {
+	if len(message) > maxEventMessage {
+		message = message[:maxEventMessage-3] + "..."
+	}
+	now := time.Now().UTC().Format(time.RFC3339)
+	ns, _ := object["namespace"].(string)
+	name, _ := object["name"].(string)
+	ev := map[string]any{
+		"apiVersion":         "v1",
+		"kind":               "Event",
+		"metadata":           map[string]any{"generateName": name + ".", "namespace": ns},
+		"involvedObject":     object,
+		"type":               eventType,
+		"reason":             reason,
+		"message":            message,
+		"source":             map[string]any{"component": eventComponent},
+		"reportingComponent": eventComponent,
+		"reportingInstance":  k.pod,
+		"firstTimestamp":     now,
+		"lastTimestamp":      now,
+		"count":              1,
+	}
+	if err := k.do(ctx, http.MethodPost, "/api/v1/namespaces/"+ns+"/events", "application/json", ev, nil); err != nil {
+		return fmt.Errorf("create event: %w", err)
+	}
+	return nil
+}
+
+// maxEventMessage bounds Event messages, which the API server keeps short.
+const maxEventMessage = 1024
+
+// completionEvent is the Event reporting a gather that ended with err.
+func (g *Gatherer) completionEvent(err error) (eventType, reason, message string) {
+	archive := g.outFile
+	if len(g.uploaded) > 0 {
+		archive = g.uploaded[0]
+	}
+	var rows int
+	for _, o := range g.outcomes {
+		rows += o.rows
+	}
+	switch {
+	case g.incomplete():
+		return "Warning", "GatherIncomplete", fmt.Sprintf("Gather interrupted, wrote an incomplete %s with %d rows", archive, rows)
+	case err != nil:
+		return "Warning", "GatherFailed", "Gather failed: " + err.Error()
+	}
+	return "Normal", "GatherSucceeded", fmt.Sprintf("Wrote %s with %d rows from %d tables, window %s to %s",
+		archive, rows, len(g.completed), g.start.Format(time.RFC3339), g.end.Format(time.RFC3339))
+}
+
+// postCompletionEvent reports the outcome of an in-cluster gather as an Event.
+// Failing to post it is only a warning: the gather itself is done.
+func (g *Gatherer) postCompletionEvent(err error) {
+	api, evErr := inClusterAPI()
+	if evErr == nil {
+		eventType, reason, message := g.completionEvent(err)
+		evErr = api.post(context.Background(), eventType, reason, message)
+	}
+	if evErr != nil {
+		g.warnf("", "no completion event: %v", evErr)
+	}
+}
@@ -0,0 +1,124 @@
+package mustgather
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeKubeAPI serves the events of namespace like an API server the pod's
+// service account mount and environment point to, and returns the events
+// created and their Authorization headers.
+func fakeKubeAPI(t *testing.T, namespace string) func() (events []map[string]any, auth []string) {
+	t.Helper()
+	var mu sync.Mutex
+	var events []map[string]any
+	var auth []string
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/api/v1/namespaces/"+namespace+"/events" {
+			http.Error(w, `{"message":"unexpected request"}`, http.StatusNotFound)
+			return
+		}
+		var ev map[string]any
+		_ = json.NewDecoder(r.Body).Decode(&ev)
+		mu.Lock()
+		events, auth = append(events, ev), append(auth, r.Header.Get("Authorization"))
+		mu.Unlock()
+		w.WriteHeader(http.StatusCreated)
+	}))
+	t.Cleanup(srv.Close)
+	fakeServiceAccount(t, srv, namespace)
+	return func() ([]map[string]any, []string) {
+		mu.Lock()
+		defer mu.Unlock()
+		return events, auth
+	}
+}
+
+// fakeServiceAccount points the service account mount and the environment of
+// the pod to the API server srv.
+func fakeServiceAccount(t *testing.T, srv *httptest.Server, namespace string) {
+	t.Helper()
+	dir := t.TempDir()
+	old := serviceAccountDir
+	serviceAccountDir = dir
+	t.Cleanup(func() { serviceAccountDir = old })
+	ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw})
+	for name, content := range map[string]string{"token": "sa-token\n", "namespace": namespace, "ca.crt": string(ca)} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	host, port, _ := net.SplitHostPort(strings.TrimPrefix(srv.URL, "https://"))
+	t.Setenv("KUBERNETES_SERVICE_HOST", host)
+	t.Setenv("KUBERNETES_SERVICE_PORT", port)
+	t.Setenv("POD_NAME", "must-gather-28000000-abcde")
+}
+
+func TestInClusterEvents(t *testing.T) {
+	created := fakeKubeAPI(t, "diag")
+	api, err := inClusterAPI()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := api.post(context.Background(), "Normal", "GatherSucceeded", "Wrote a.tar.gz"); err != nil {
+		t.Fatalf("post failed: %v", err)
+	}
+	got, auth := created()
+	if len(got) != 1 || auth[0] != "Bearer sa-token" {
+		t.Fatalf("events = %v, Authorization = %v", got, auth)
+	}
+	obj, _ := got[0]["involvedObject"].(map[string]any)
+	if got[0]["reason"] != "GatherSucceeded" || got[0]["type"] != "Normal" || obj["kind"] != "Pod" || obj["name"] != "must-gather-28000000-abcde" || obj["namespace"] != "diag" {
+		t.Errorf("unexpected event %v", got[0])
+	}
+
+	api.namespace = "other"
+	if err := api.post(context.Background(), "Normal", "GatherSucceeded", "x"); err == nil || !strings.Contains(err.Error(), "unexpected request") {
+		t.Errorf("expected the API server message, got %v", err)
+	}
+
+	t.Setenv("KUBERNETES_SERVICE_HOST", "")
+	if _, err := inClusterAPI(); err == nil {
+		t.Error("inClusterAPI outside a pod should fail")
+	}
+}
+
+func TestCompletionEvent(t *testing.T) {
+	end := time.Date(2026, 1, 2, 3, 0, 0, 0, time.UTC)
+	g := &Gatherer{
+		ctx:       context.Background(),
+		outFile:   "a.tar.gz",
+		start:     end.Add(-time.Hour),
+		end:       end,
+		outcomes:  []tableOutcome{{table: "Heartbeat", rows: 3}, {table: "KubeEvents", rows: 2}},
+		completed: []string{"Heartbeat", "KubeEvents"},
+	}
+	typ, reason, msg := g.completionEvent(nil)
+	if typ != "Normal" || reason != "GatherSucceeded" || msg != "Wrote a.tar.gz with 5 rows from 2 tables, window 2026-01-02T02:00:00Z to 2026-01-02T03:00:00Z" {
+		t.Errorf("success event = %s %s %q", typ, reason, msg)
+	}
+	g.uploaded = []string{"https://acct.blob.core.windows.net/diag/a.tar.gz"}
+	if _, _, msg := g.completionEvent(nil); !strings.HasPrefix(msg, "Wrote https://acct.blob.core.windows.net/diag/a.tar.gz") {
+		t.Errorf("event should name the uploaded blob: %q", msg)
+	}
+	if typ, reason, msg := g.completionEvent(errors.New("boom")); typ != "Warning" || reason != "GatherFailed" || msg != "Gather failed: boom" {
+		t.Errorf("failure event = %s %s %q", typ, reason, msg)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	g.ctx = ctx
+	if _, reason, _ := g.completionEvent(ctx.Err()); reason != "GatherIncomplete" {
+		t.Errorf("interrupted reason = %s", reason)
+	}
+}
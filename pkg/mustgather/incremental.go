@@ -0,0 +1,136 @@
+package mustgather
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// incrementalState is the content of the --since-last-run state file: the
+// newest TimeGenerated exported from each table, per workspace and scope.
+type incrementalState struct {
+	Workspaces map[string]map[string]string `json:"workspaces"`
+}
+
+// incremental tracks the tables of a --since-last-run gather: where each
+// starts, the newest row of the last run, and how far this run got.
+type incremental struct {
+	path  string
+	state incrementalState
+	// key names the workspace and scope of the gather in the state file.
+	key string
+	// last is the newest TimeGenerated exported per table by earlier runs;
+	// newest is the newest this run exported, where it may move on from.
+	last   map[string]time.Time
+	newest map[string]time.Time
+}
+
+// DefaultStateFile is the per-user --since-last-run state file, or "" when
+// the user config directory is unknown.
+func DefaultStateFile() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "aks-must-gather", "state.json")
+}
+
+func loadIncremental(path string) (*incremental, error) {
+	if path == "" {
+		return nil, errors.New("--since-last-run needs a state file: set --state-file")
+	}
+	in := &incremental{path: path, newest: map[string]time.Time{}}
+	b, err := os.ReadFile(path)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+	case err != nil:
+		return nil, fmt.Errorf("read state file: %w", err)
+	default:
+		if err := json.Unmarshal(b, &in.state); err != nil {
+			return nil, fmt.Errorf("parse state file %s: %w", path, err)
+		}
+	}
+	if in.state.Workspaces == nil {
+		in.state.Workspaces = map[string]map[string]string{}
+	}
+	return in, nil
+}
+
+// selectWorkspace picks the tables of the workspace and scope of the gather:
+// a scoped gather only exported some namespaces' rows, so it tracks its own.
+func (in *incremental) selectWorkspace(workspaceGUID string, scope *namespaceScope) {
+	in.key = workspaceGUID
+	if scope != nil {
+		in.key += "/" + strings.Join(scope.namespaces, ",")
+	}
+	in.last = map[string]time.Time{}
+	for table, ts := range in.state.Workspaces[in.key] {
+		if t, err := time.Parse(time.RFC3339Nano, ts); err == nil {
+			in.last[table] = t
+		}
+	}
+}
+
+// since returns the newest TimeGenerated of table the last run exported, or
+// the zero time for a table not exported before. A nil incremental always
+// returns the zero time.
+func (in *incremental) since(table string) time.Time {
+	if in == nil {
+		return time.Time{}
+	}
+	return in.last[table]
+}
+
+// advance records that this run exported the rows of table up to t, with
+// none missing before it.
+func (in *incremental) advance(table string, t time.Time) {
+	if in == nil || t.IsZero() || !t.After(in.last[table]) {
+		return
+	}
+	in.newest[table] = t
+}
+
+// summary is the sinceLastRun section of metadata/run.json: the time each
+// table was exported after.
+func (in *incremental) summary() map[string]string {
+	s := map[string]string{}
+	for table, t := range in.last {
+		s[table] = t.Format(time.RFC3339Nano)
+	}
+	return s
+}
+
+// save writes the tables this run moved on to the state file.
+func (in *incremental) save() error {
+	if len(in.newest) == 0 {
+		return nil
+	}
+	tables := in.state.Workspaces[in.key]
+	if tables == nil {
+		tables = map[string]string{}
+		in.state.Workspaces[in.key] = tables
+	}
+	for table, t := range in.newest {
+		tables[table] = t.UTC().Format(time.RFC3339Nano)
+	}
+	b, _ := json.MarshalIndent(in.state, "", "  ")
+	if err := os.MkdirAll(filepath.Dir(in.path), 0o700); err != nil {
+		return fmt.Errorf("write state file: %w", err)
+	}
+	if err := os.WriteFile(in.path, append(b, '\n'), 0o600); err != nil {
+		return fmt.Errorf("write state file: %w", err)
+	}
+	return nil
+}
+
+// sinceFilter keeps the rows newer than t. The query time range is only
+// precise to the second, so the filter is what leaves out the rows exported
+// before.
+func sinceFilter(t time.Time) string {
+	return " | where TimeGenerated > datetime(" + t.UTC().Format(time.RFC3339Nano) + ")"
+}
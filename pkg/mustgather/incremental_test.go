@@ -0,0 +1,131 @@
+package mustgather
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	azquery "github.com/Azure/azure-sdk-for-go/sdk/monitor/azquery"
+)
+
+func TestIncrementalState(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sub", "state.json")
+	in, err := loadIncremental(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	in.selectWorkspace("guid", nil)
+	if got := in.since("Heartbeat"); !got.IsZero() {
+		t.Errorf("since of a new table = %v", got)
+	}
+	t1 := time.Date(2026, 1, 2, 3, 4, 5, 600, time.UTC)
+	in.advance("Heartbeat", t1)
+	in.advance("KubeEvents", time.Time{})
+	if err := in.save(); err != nil {
+		t.Fatal(err)
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Perm() != 0o600 {
+		t.Errorf("state file mode = %v, want 0600", fi.Mode().Perm())
+	}
+
+	in, err = loadIncremental(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	in.selectWorkspace("guid", nil)
+	if got := in.since("Heartbeat"); !got.Equal(t1) {
+		t.Errorf("since after reload = %v, want %v", got, t1)
+	}
+	if _, ok := in.summary()["KubeEvents"]; ok {
+		t.Error("a table without rows should not be recorded")
+	}
+	// An older time does not move the table back
+	in.advance("Heartbeat", t1.Add(-time.Hour))
+	if len(in.newest) != 0 {
+		t.Errorf("advance to an older time recorded %v", in.newest)
+	}
+
+	// Another scope of the workspace is tracked separately
+	in.selectWorkspace("guid", &namespaceScope{namespaces: []string{"shop"}})
+	if got := in.since("Heartbeat"); !got.IsZero() {
+		t.Errorf("since in another scope = %v", got)
+	}
+	in.selectWorkspace("other", nil)
+	if got := in.since("Heartbeat"); !got.IsZero() {
+		t.Errorf("since in another workspace = %v", got)
+	}
+
+	var none *incremental
+	none.advance("Heartbeat", t1)
+	if got := none.since("Heartbeat"); !got.IsZero() {
+		t.Errorf("nil since = %v", got)
+	}
+
+	if err := os.WriteFile(path, []byte("{"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadIncremental(path); err == nil {
+		t.Error("loadIncremental accepted a corrupt state file")
+	}
+	if _, err := loadIncremental(""); err == nil {
+		t.Error("loadIncremental accepted an empty path")
+	}
+}
+
+func TestSinceFilter(t *testing.T) {
+	got := sinceFilter(time.Date(2026, 1, 2, 3, 4, 5, 600, time.FixedZone("CET", 3600)))
+	if want := " | where TimeGenerated > datetime(2026-01-02T02:04:05.0000006Z)"; got != want {
+		t.Errorf("sinceFilter = %q, want %q", got, want)
+	}
+}
+
+func TestSinceLastRunLateRows(t *testing.T) {
+	ts := func(d time.Duration) string { return time.Now().Add(d).UTC().Format(time.RFC3339Nano) }
+	logs := &fakeLogs{rows: map[string][]azquery.Row{"Heartbeat": {{ts(-10 * time.Minute), "aks-node-1"}}}}
+	stateFile := filepath.Join(t.TempDir(), "state.json")
+	gather := func() string {
+		t.Helper()
+		sink := &memorySink{files: map[string]string{}}
+		config := &Config{
+			WorkspaceID:  "/subscriptions/sub/resourceGroups/rg/providers/Microsoft.OperationalInsights/workspaces/ws",
+			Timespan:     "PT1H",
+			TableFilter:  "Heartbeat",
+			SinceLastRun: true,
+			StateFile:    stateFile,
+			Quiet:        true,
+		}
+		g, err := NewGatherer(context.Background(), config, WithCredential(noCredential{}), WithLogsClient(logs),
+			WithWorkspacesClient(fakeWorkspaces{}), WithTablesClient(fakeTables{names: []string{"Heartbeat"}}), WithSink(sink))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := g.Run(); err != nil {
+			t.Fatalf("Run: %v", err)
+		}
+		var rows strings.Builder
+		for name, content := range sink.files {
+			if strings.HasPrefix(name, "tables/Heartbeat/parts/") {
+				rows.WriteString(content)
+			}
+		}
+		return rows.String()
+	}
+
+	if first := gather(); !strings.Contains(first, "aks-node-1") {
+		t.Fatalf("first run exported:\n%s", first)
+	}
+	// A row older than the newest one exported is ingested after the run
+	logs.mu.Lock()
+	logs.rows["Heartbeat"] = append(logs.rows["Heartbeat"], azquery.Row{ts(-12 * time.Minute), "aks-node-late"})
+	logs.mu.Unlock()
+	if second := gather(); !strings.Contains(second, "aks-node-late") {
+		t.Errorf("second run skipped the late row:\n%s", second)
+	}
+}
@@ -0,0 +1,303 @@
+package mustgather
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// LLM provider names accepted by --ai-provider.
+const (
+	ProviderClaudeCLI   = "claude-cli"
+	ProviderAnthropic   = "anthropic"
+	ProviderOpenAI      = "openai"
+	ProviderAzureOpenAI = "azure-openai"
+)
+
+// aiProviderEnv selects the provider when --ai-provider is not given. It is
+// the variable the CLI binds to --ai-provider, so embedders and the CLI
+// read the same name. legacyAIProviderEnv is its older spelling, still
+// honored when aiProviderEnv is unset.
+const (
+	aiProviderEnv       = "AKS_MG_AI_PROVIDER"
+	legacyAIProviderEnv = "AKS_MUST_GATHER_AI_PROVIDER"
+)
+
+const (
+	defaultAnthropicModel    = "claude-sonnet-4-5"
+	defaultOpenAIModel       = "gpt-4o"
+	defaultAzureAPIVersion   = "2024-10-21"
+	defaultLLMMaxTokens      = 4096
+	defaultLLMRequestTimeout = 5 * time.Minute
+)
+
+// LLMProvider sends a single prompt to a language model and returns its
+// text reply.
+type LLMProvider interface {
+	Name() string
+	Complete(ctx context.Context, prompt string) (string, error)
+	// ReadsFiles reports whether the model can open local files named in
+	// the prompt itself. The claude CLI can; the HTTP APIs only see what
+	// is inlined into the prompt.
+	ReadsFiles() bool
+}
+
+// NewLLMProvider returns the provider called name. An empty name falls back
+// to $AKS_MG_AI_PROVIDER and then to the first backend whose
+// credentials are present: Azure OpenAI, OpenAI, Anthropic, and finally the
+// claude CLI.
+func NewLLMProvider(name string) (LLMProvider, error) {
+	if name == "" {
+		name = aiProviderFromEnv()
+	}
+	if name == "" {
+		name = detectLLMProvider()
+	}
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case ProviderClaudeCLI, "claude":
+		if _, err := exec.LookPath("claude"); err != nil {
+			return nil, fmt.Errorf("'claude' command not found in PATH. Please install Claude CLI or choose another --ai-provider: %w", err)
+		}
+		return &claudeCLIProvider{}, nil
+	case ProviderAnthropic:
+		key := os.Getenv("ANTHROPIC_API_KEY")
+		if key == "" {
+			return nil, fmt.Errorf("anthropic provider requires ANTHROPIC_API_KEY")
+		}
+		return &anthropicProvider{
+			baseURL: envOr("ANTHROPIC_BASE_URL", "https://api.anthropic.com"),
+			apiKey:  key,
+			model:   envOr("ANTHROPIC_MODEL", defaultAnthropicModel),
+			client:  &http.Client{Timeout: defaultLLMRequestTimeout},
+		}, nil
+	case ProviderOpenAI:
+		key := os.Getenv("OPENAI_API_KEY")
+		if key == "" {
+			return nil, fmt.Errorf("openai provider requires OPENAI_API_KEY")
+		}
+		return &openAIProvider{
+			url:    strings.TrimRight(envOr("OPENAI_BASE_URL", "https://api.openai.com/v1"), "/") + "/chat/completions",
+			apiKey: key,
+			model:  envOr("OPENAI_MODEL", defaultOpenAIModel),
+			client: &http.Client{Timeout: defaultLLMRequestTimeout},
+		}, nil
+	case ProviderAzureOpenAI:
+		endpoint := os.Getenv("AZURE_OPENAI_ENDPOINT")
+		key := os.Getenv("AZURE_OPENAI_API_KEY")
+		deployment := os.Getenv("AZURE_OPENAI_DEPLOYMENT")
+		if endpoint == "" || key == "" || deployment == "" {
+			return nil, fmt.Errorf("azure-openai provider requires AZURE_OPENAI_ENDPOINT, AZURE_OPENAI_API_KEY and AZURE_OPENAI_DEPLOYMENT")
+		}
+		return &openAIProvider{
+			url: fmt.Sprintf("%s/openai/deployments/%s/chat/completions?api-version=%s",
+				strings.TrimRight(endpoint, "/"), deployment, envOr("AZURE_OPENAI_API_VERSION", defaultAzureAPIVersion)),
+			apiKey: key,
+			azure:  true,
+			client: &http.Client{Timeout: defaultLLMRequestTimeout},
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown AI provider %q (want %s, %s, %s or %s)", name, ProviderClaudeCLI, ProviderAnthropic, ProviderOpenAI, ProviderAzureOpenAI)
+	}
+}
+
+// aiProviderFromEnv returns the provider named by $AKS_MG_AI_PROVIDER, or
+// by the legacy $AKS_MUST_GATHER_AI_PROVIDER when the former is unset.
+func aiProviderFromEnv() string {
+	if name := os.Getenv(aiProviderEnv); name != "" {
+		return name
+	}
+	return os.Getenv(legacyAIProviderEnv)
+}
+
+func detectLLMProvider() string {
+	switch {
+	case os.Getenv("AZURE_OPENAI_ENDPOINT") != "":
+		return ProviderAzureOpenAI
+	case os.Getenv("OPENAI_API_KEY") != "":
+		return ProviderOpenAI
+	case os.Getenv("ANTHROPIC_API_KEY") != "":
+		return ProviderAnthropic
+	default:
+		return ProviderClaudeCLI
+	}
+}
+
+func envOr(key, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return def
+}
+
+// claudeCLIProvider shells out to a locally installed and authenticated
+// claude binary.
+type claudeCLIProvider struct{}
+
+func (p *claudeCLIProvider) Name() string     { return ProviderClaudeCLI }
+func (p *claudeCLIProvider) ReadsFiles() bool { return true }
+
+func (p *claudeCLIProvider) Complete(ctx context.Context, prompt string) (string, error) {
+	output, err := exec.CommandContext(ctx, "claude", prompt).Output()
+	if err != nil {
+		return "", fmt.Errorf("execute claude command: %w", err)
+	}
+	return strings.TrimSpace(string(output)), nil
+}
+
+// anthropicProvider calls the Anthropic Messages API.
+type anthropicProvider struct {
+	baseURL string
+	apiKey  string
+	model   string
+	client  *http.Client
+}
+
+func (p *anthropicProvider) Name() string     { return ProviderAnthropic }
+func (p *anthropicProvider) ReadsFiles() bool { return false }
+
+func (p *anthropicProvider) Complete(ctx context.Context, prompt string) (string, error) {
+	output, _, err := p.completeWithUsage(ctx, prompt)
+	return output, err
+}
+
+func (p *anthropicProvider) completeWithUsage(ctx context.Context, prompt string) (string, tokenUsage, error) {
+	req := map[string]any{
+		"model":      p.model,
+		"max_tokens": defaultLLMMaxTokens,
+		"messages":   []map[string]string{{"role": "user", "content": prompt}},
+	}
+	headers := map[string]string{
+		"x-api-key":         p.apiKey,
+		"anthropic-version": "2023-06-01",
+	}
+	var resp struct {
+		Model   string `json:"model"`
+		Content []struct {
+			Type string `json:"type"`
+			Text string `json:"text"`
+		} `json:"content"`
+		Usage struct {
+			InputTokens  int64 `json:"input_tokens"`
+			OutputTokens int64 `json:"output_tokens"`
+		} `json:"usage"`
+	}
+	if err := postLLM(ctx, p.client, strings.TrimRight(p.baseURL, "/")+"/v1/messages", headers, req, &resp); err != nil {
+		return "", tokenUsage{}, fmt.Errorf("anthropic: %w", err)
+	}
+	var sb strings.Builder
+	for _, c := range resp.Content {
+		if c.Type == "text" {
+			sb.WriteString(c.Text)
+		}
+	}
+	if sb.Len() == 0 {
+		return "", tokenUsage{}, fmt.Errorf("anthropic: response has no text content")
+	}
+	if resp.Model == "" {
+		resp.Model = p.model
+	}
+	return strings.TrimSpace(sb.String()), tokenUsage{input: resp.Usage.InputTokens, output: resp.Usage.OutputTokens, model: resp.Model}, nil
+}
+
+// openAIProvider calls a chat completions endpoint, either OpenAI's or an
+// Azure OpenAI deployment's; they differ only in URL and auth header.
+type openAIProvider struct {
+	url    string
+	apiKey string
+	model  string // empty for Azure, where the deployment picks the model
+	azure  bool
+	client *http.Client
+}
+
+func (p *openAIProvider) Name() string {
+	if p.azure {
+		return ProviderAzureOpenAI
+	}
+	return ProviderOpenAI
+}
+
+func (p *openAIProvider) ReadsFiles() bool { return false }
+
+func (p *openAIProvider) Complete(ctx context.Context, prompt string) (string, error) {
+	output, _, err := p.completeWithUsage(ctx, prompt)
+	return output, err
+}
+
+func (p *openAIProvider) completeWithUsage(ctx context.Context, prompt string) (string, tokenUsage, error) {
+	req := map[string]any{
+		"messages": []map[string]string{{"role": "user", "content": prompt}},
+	}
+	if p.model != "" {
+		req["model"] = p.model
+	}
+	headers := map[string]string{"Authorization": "Bearer " + p.apiKey}
+	if p.azure {
+		headers = map[string]string{"api-key": p.apiKey}
+	}
+	var resp struct {
+		Model   string `json:"model"`
+		Choices []struct {
+			Message struct {
+				Content string `json:"content"`
+			} `json:"message"`
+		} `json:"choices"`
+		Usage struct {
+			PromptTokens     int64 `json:"prompt_tokens"`
+			CompletionTokens int64 `json:"completion_tokens"`
+		} `json:"usage"`
+	}
+	if err := postLLM(ctx, p.client, p.url, headers, req, &resp); err != nil {
+		return "", tokenUsage{}, fmt.Errorf("%s: %w", p.Name(), err)
+	}
+	if len(resp.Choices) == 0 {
+		return "", tokenUsage{}, fmt.Errorf("%s: response has no choices", p.Name())
+	}
+	if resp.Model == "" {
+		resp.Model = p.model
+	}
+	return strings.TrimSpace(resp.Choices[0].Message.Content), tokenUsage{input: resp.Usage.PromptTokens, output: resp.Usage.CompletionTokens, model: resp.Model}, nil
+}
+
+// postLLM posts body as JSON and decodes a 2xx reply into out. Error replies
+// are returned with their status and (truncated) body.
+func postLLM(ctx context.Context, client *http.Client, url string, headers map[string]string, body, out any) error {
+	b, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("read response: %w", err)
+	}
+	if resp.StatusCode/100 != 2 {
+		msg := strings.TrimSpace(string(data))
+		if len(msg) > 500 {
+			msg = msg[:500] + "..."
+		}
+		return fmt.Errorf("HTTP %d: %s", resp.StatusCode, msg)
+	}
+	if err := json.Unmarshal(data, out); err != nil {
+		return fmt.Errorf("decode response: %w", err)
+	}
+	return nil
+}
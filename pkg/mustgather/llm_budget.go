@@ -0,0 +1,238 @@
+package mustgather
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// bytesPerToken approximates token counts from text length, for prompt
+// limits and for providers that do not report usage.
+const bytesPerToken = 4
+
+// ErrLLMBudgetExceeded is returned instead of calling the model once the
+// run's token or cost budget is spent.
+var ErrLLMBudgetExceeded = errors.New("LLM budget exceeded")
+
+// TokenPrices are USD per million input and output tokens.
+type TokenPrices struct {
+	Input, Output float64
+}
+
+// defaultTokenPrices are list prices of the default models, matched by model
+// name prefix. Other models need --ai-token-prices for cost accounting.
+var defaultTokenPrices = map[string]TokenPrices{
+	"claude-sonnet-4": {Input: 3, Output: 15},
+	"gpt-4o":          {Input: 2.5, Output: 10},
+	"gpt-4o-mini":     {Input: 0.15, Output: 0.6},
+}
+
+// ParseTokenPrices parses "<input>,<output>" USD per million tokens, as given
+// to --ai-token-prices. An empty string returns nil.
+func ParseTokenPrices(s string) (*TokenPrices, error) {
+	if strings.TrimSpace(s) == "" {
+		return nil, nil
+	}
+	in, out, ok := strings.Cut(s, ",")
+	if !ok {
+		return nil, fmt.Errorf("token prices %q: want <input>,<output> USD per million tokens", s)
+	}
+	var p TokenPrices
+	var err error
+	if p.Input, err = strconv.ParseFloat(strings.TrimSpace(in), 64); err != nil || p.Input < 0 {
+		return nil, fmt.Errorf("token prices %q: invalid input price", s)
+	}
+	if p.Output, err = strconv.ParseFloat(strings.TrimSpace(out), 64); err != nil || p.Output < 0 {
+		return nil, fmt.Errorf("token prices %q: invalid output price", s)
+	}
+	return &p, nil
+}
+
+// pricesFor returns the list price of model, or nil when unknown.
+func pricesFor(model string) *TokenPrices {
+	var prefixes []string
+	for p := range defaultTokenPrices {
+		prefixes = append(prefixes, p)
+	}
+	// Longest prefix first, so gpt-4o-mini is not priced as gpt-4o
+	sort.Slice(prefixes, func(i, j int) bool { return len(prefixes[i]) > len(prefixes[j]) })
+	for _, p := range prefixes {
+		if strings.HasPrefix(model, p) {
+			prices := defaultTokenPrices[p]
+			return &prices
+		}
+	}
+	return nil
+}
+
+// LLMLimits bound what a run may send to and spend on the model. Zero values
+// are unlimited.
+type LLMLimits struct {
+	// MaxPromptTokens caps a single prompt; inlined files are cut to fit and
+	// anything still over is truncated from the end.
+	MaxPromptTokens int
+	// MaxTokens caps input plus output tokens over the run.
+	MaxTokens int64
+	// MaxCost caps the run's cost in USD. It is only enforced for calls whose
+	// price is known, from Prices or the default model prices.
+	MaxCost float64
+	Prices  *TokenPrices
+}
+
+// LLMUsage is the model usage reported in ai-query-results/summary.json.
+type LLMUsage struct {
+	Calls        int   `json:"calls"`
+	InputTokens  int64 `json:"inputTokens"`
+	OutputTokens int64 `json:"outputTokens"`
+	// Estimated is set when some counts were approximated from text length
+	// because the provider does not report usage.
+	Estimated        bool    `json:"estimated,omitempty"`
+	CostUSD          float64 `json:"costUSD,omitempty"`
+	TruncatedPrompts int     `json:"truncatedPrompts,omitempty"`
+}
+
+// sub returns the usage accrued since earlier.
+func (u LLMUsage) sub(earlier LLMUsage) LLMUsage {
+	return LLMUsage{
+		Calls:            u.Calls - earlier.Calls,
+		InputTokens:      u.InputTokens - earlier.InputTokens,
+		OutputTokens:     u.OutputTokens - earlier.OutputTokens,
+		Estimated:        u.Estimated,
+		CostUSD:          u.CostUSD - earlier.CostUSD,
+		TruncatedPrompts: u.TruncatedPrompts - earlier.TruncatedPrompts,
+	}
+}
+
+// tokenUsage is what one completion consumed, as reported by the provider.
+type tokenUsage struct {
+	input, output int64
+	model         string
+}
+
+// usageReporter is implemented by providers that report token usage.
+type usageReporter interface {
+	completeWithUsage(ctx context.Context, prompt string) (string, tokenUsage, error)
+}
+
+// llmMeter enforces LLMLimits and accounts for usage over a run. A nil
+// llmMeter is unlimited and records nothing.
+type llmMeter struct {
+	limits LLMLimits
+
+	mu    sync.Mutex
+	usage LLMUsage
+	// unpriced records that a cost budget could not be applied to a call.
+	unpriced bool
+	// log receives warnings; nil drops them.
+	log io.Writer
+}
+
+func newLLMMeter(limits LLMLimits) *llmMeter {
+	return &llmMeter{limits: limits}
+}
+
+// maxPromptBytes is the prompt size limit in bytes, or 0 when unlimited.
+func (m *llmMeter) maxPromptBytes() int {
+	if m == nil || m.limits.MaxPromptTokens <= 0 {
+		return 0
+	}
+	return m.limits.MaxPromptTokens * bytesPerToken
+}
+
+// inlineLimit is how many bytes of file content may still be inlined into
+// prompt.
+func (m *llmMeter) inlineLimit(prompt string) int {
+	limit := maxInlineBytes
+	if max := m.maxPromptBytes(); max > 0 {
+		if left := max - len(prompt); left < limit {
+			limit = left
+		}
+	}
+	if limit < 0 {
+		return 0
+	}
+	return limit
+}
+
+// fit truncates prompt to the prompt size limit.
+func (m *llmMeter) fit(prompt string) string {
+	max := m.maxPromptBytes()
+	if max <= 0 || len(prompt) <= max {
+		return prompt
+	}
+	note := fmt.Sprintf("\n\n[... %d bytes truncated to fit the prompt size limit]", len(prompt)-max)
+	keep := max - len(note)
+	if keep < 0 {
+		keep = 0
+	}
+	m.mu.Lock()
+	m.usage.TruncatedPrompts++
+	m.mu.Unlock()
+	return prompt[:keep] + note
+}
+
+// admit fails when sending prompt would exceed the token budget, or the cost
+// budget is already spent.
+func (m *llmMeter) admit(prompt string) error {
+	if m == nil {
+		return nil
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	used := m.usage.InputTokens + m.usage.OutputTokens
+	if max := m.limits.MaxTokens; max > 0 && used+estimateTokens(prompt) > max {
+		return fmt.Errorf("%w: %d of %d tokens used, the next prompt needs about %d", ErrLLMBudgetExceeded, used, max, estimateTokens(prompt))
+	}
+	if max := m.limits.MaxCost; max > 0 && m.usage.CostUSD >= max {
+		return fmt.Errorf("%w: $%.4f of $%.2f spent", ErrLLMBudgetExceeded, m.usage.CostUSD, max)
+	}
+	return nil
+}
+
+// record accounts for one completed call.
+func (m *llmMeter) record(u tokenUsage, estimated bool) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.usage.Calls++
+	m.usage.InputTokens += u.input
+	m.usage.OutputTokens += u.output
+	if estimated {
+		m.usage.Estimated = true
+	}
+	prices := m.limits.Prices
+	if prices == nil {
+		prices = pricesFor(u.model)
+	}
+	if prices == nil {
+		if m.limits.MaxCost > 0 && !m.unpriced {
+			m.unpriced = true
+			if m.log != nil {
+				fmt.Fprintf(m.log, "  warn: no token prices known for model %q, --ai-max-cost is not enforced; set --ai-token-prices\n", u.model)
+			}
+		}
+		return
+	}
+	m.usage.CostUSD += (float64(u.input)*prices.Input + float64(u.output)*prices.Output) / 1e6
+}
+
+// snapshot returns the usage so far.
+func (m *llmMeter) snapshot() LLMUsage {
+	if m == nil {
+		return LLMUsage{}
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.usage
+}
+
+func estimateTokens(s string) int64 {
+	return int64((len(s) + bytesPerToken - 1) / bytesPerToken)
+}
@@ -0,0 +1,262 @@
+package mustgather
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	azquery "github.com/Azure/azure-sdk-for-go/sdk/monitor/azquery"
+)
+
+// meteredProvider reports fixed token usage for every call.
+type meteredProvider struct {
+	recordingProvider
+	usage tokenUsage
+}
+
+func (p *meteredProvider) completeWithUsage(ctx context.Context, prompt string) (string, tokenUsage, error) {
+	out, err := p.Complete(ctx, prompt)
+	return out, p.usage, err
+}
+
+func TestParseTokenPrices(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    *TokenPrices
+		wantErr bool
+	}{
+		{in: "", want: nil},
+		{in: "3,15", want: &TokenPrices{Input: 3, Output: 15}},
+		{in: " 0.15 , 0.6 ", want: &TokenPrices{Input: 0.15, Output: 0.6}},
+		{in: "3", wantErr: true},
+		{in: "3,x", wantErr: true},
+		{in: "-1,2", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := ParseTokenPrices(tt.in)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if (got == nil) != (tt.want == nil) || (got != nil && *got != *tt.want) {
+				t.Errorf("ParseTokenPrices(%q) = %+v, want %+v", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPricesFor(t *testing.T) {
+	if p := pricesFor("gpt-4o-mini-2024-07-18"); p == nil || p.Input != 0.15 {
+		t.Errorf("gpt-4o-mini priced as %+v", p)
+	}
+	if p := pricesFor("claude-sonnet-4-5-20250929"); p == nil || p.Output != 15 {
+		t.Errorf("claude-sonnet-4-5 priced as %+v", p)
+	}
+	if p := pricesFor("my-deployment"); p != nil {
+		t.Errorf("unknown model priced as %+v", p)
+	}
+}
+
+func TestLLMMeterPromptLimit(t *testing.T) {
+	p := &recordingProvider{reply: "ok"}
+	ai := &AIQueryGenerator{provider: p}
+	ai.SetLimits(LLMLimits{MaxPromptTokens: 25})
+
+	if got := ai.meter.inlineLimit(strings.Repeat("a", 60)); got != 40 {
+		t.Errorf("inlineLimit = %d, want 40", got)
+	}
+	if got := ai.meter.inlineLimit(strings.Repeat("a", 200)); got != 0 {
+		t.Errorf("inlineLimit over the limit = %d, want 0", got)
+	}
+
+	if _, err := ai.complete(context.Background(), strings.Repeat("a", 500)); err != nil {
+		t.Fatal(err)
+	}
+	if got := p.prompts[0]; len(got) != 100 || !strings.HasSuffix(got, "bytes truncated to fit the prompt size limit]") {
+		t.Errorf("prompt not truncated to 100 bytes (%d): %q", len(got), got)
+	}
+	if u := ai.Usage(); u.TruncatedPrompts != 1 || u.Calls != 1 || !u.Estimated || u.InputTokens != 25 {
+		t.Errorf("usage = %+v", u)
+	}
+}
+
+func TestLLMMeterBudgets(t *testing.T) {
+	tests := []struct {
+		name      string
+		limits    LLMLimits
+		usage     tokenUsage
+		wantCalls int
+		wantCost  float64
+	}{
+		{
+			name:      "token budget",
+			limits:    LLMLimits{MaxTokens: 2000},
+			usage:     tokenUsage{input: 1000, output: 200, model: "gpt-4o"},
+			wantCalls: 2,
+			wantCost:  2 * (1000*2.5 + 200*10) / 1e6,
+		},
+		{
+			name:      "cost budget with explicit prices",
+			limits:    LLMLimits{MaxCost: 0.01, Prices: &TokenPrices{Input: 3, Output: 15}},
+			usage:     tokenUsage{input: 2000, output: 100, model: "my-deployment"},
+			wantCalls: 2,
+			wantCost:  2 * (2000*3 + 100*15) / 1e6,
+		},
+		{
+			name:      "cost budget not enforced without prices",
+			limits:    LLMLimits{MaxCost: 0.000001},
+			usage:     tokenUsage{input: 2000, output: 100, model: "my-deployment"},
+			wantCalls: 5,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &meteredProvider{recordingProvider: recordingProvider{reply: "ok"}, usage: tt.usage}
+			ai := &AIQueryGenerator{provider: p}
+			ai.SetLimits(tt.limits)
+			var log strings.Builder
+			ai.setLog(&log)
+			var err error
+			for i := 0; i < 5 && err == nil; i++ {
+				_, err = ai.complete(context.Background(), "show events")
+			}
+			if len(p.prompts) != tt.wantCalls {
+				t.Errorf("made %d calls, want %d", len(p.prompts), tt.wantCalls)
+			}
+			if tt.wantCalls < 5 && !errors.Is(err, ErrLLMBudgetExceeded) {
+				t.Errorf("err = %v, want ErrLLMBudgetExceeded", err)
+			}
+			u := ai.Usage()
+			if u.Estimated || u.Calls != tt.wantCalls || u.InputTokens != int64(tt.wantCalls)*tt.usage.input {
+				t.Errorf("usage = %+v", u)
+			}
+			if diff := u.CostUSD - tt.wantCost; diff > 1e-12 || diff < -1e-12 {
+				t.Errorf("cost = %v, want %v", u.CostUSD, tt.wantCost)
+			}
+			if unpriced := tt.limits.MaxCost > 0 && tt.limits.Prices == nil; unpriced != strings.Contains(log.String(), "--ai-max-cost is not enforced") {
+				t.Errorf("unexpected warnings %q", log.String())
+			}
+		})
+	}
+}
+
+func TestLLMProvidersReportUsage(t *testing.T) {
+	tests := []struct {
+		name     string
+		provider func(url string) usageReporter
+		reply    string
+	}{
+		{
+			name: "anthropic",
+			provider: func(url string) usageReporter {
+				return &anthropicProvider{baseURL: url, apiKey: "k", model: "m", client: http.DefaultClient}
+			},
+			reply: `{"model":"claude-sonnet-4-5","content":[{"type":"text","text":"ok"}],"usage":{"input_tokens":12,"output_tokens":3}}`,
+		},
+		{
+			name: "openai",
+			provider: func(url string) usageReporter {
+				return &openAIProvider{url: url, apiKey: "k", model: "m", client: http.DefaultClient}
+			},
+			reply: `{"model":"claude-sonnet-4-5","choices":[{"message":{"content":"ok"}}],"usage":{"prompt_tokens":12,"completion_tokens":3}}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte(tt.reply))
+			}))
+			defer srv.Close()
+			_, u, err := tt.provider(srv.URL).completeWithUsage(context.Background(), "hi")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if u != (tokenUsage{input: 12, output: 3, model: "claude-sonnet-4-5"}) {
+				t.Errorf("usage = %+v", u)
+			}
+		})
+	}
+}
+
+func TestSampleRows(t *testing.T) {
+	var rows []azquery.Row
+	for i := 0; i < 10; i++ {
+		rows = append(rows, azquery.Row{i})
+	}
+	got := sampleRows(rows, 4)
+	var idx []int
+	for _, r := range got {
+		idx = append(idx, r[0].(int))
+	}
+	if want := []int{0, 3, 6, 9}; len(idx) != 4 || idx[0] != want[0] || idx[1] != want[1] || idx[2] != want[2] || idx[3] != want[3] {
+		t.Errorf("sampled rows %v, want %v", idx, want)
+	}
+	if len(sampleRows(rows, 20)) != 10 {
+		t.Errorf("sampling more rows than there are should keep all")
+	}
+}
+
+func TestAnalyzeSampledResults(t *testing.T) {
+	dir := t.TempDir()
+	results := filepath.Join(dir, "ai-query-results")
+	if err := os.MkdirAll(results, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range map[string]string{
+		"summary.json":  `{"rows":[1000,1]}`,
+		"table_0.json":  `FULL`,
+		"sample_0.json": `SAMPLE`,
+		"table_1.json":  `SMALL`,
+	} {
+		if err := os.WriteFile(filepath.Join(results, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	p := &recordingProvider{reply: "ok"}
+	ai := &AIQueryGenerator{provider: p}
+	if _, err := ai.AnalyzeResults(context.Background(), "why", "KubeEvents", dir); err != nil {
+		t.Fatal(err)
+	}
+	prompt := p.prompts[0]
+	for _, want := range []string{"SAMPLE", "SMALL", `{"rows":[1000,1]}`, "evenly spaced sample"} {
+		if !strings.Contains(prompt, want) {
+			t.Errorf("prompt missing %q", want)
+		}
+	}
+	if strings.Contains(prompt, "FULL") {
+		t.Errorf("sampled table should not be inlined in full")
+	}
+}
+
+func TestRecordLLMUsage(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "ai-query-results"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "ai-query-results", "summary.json")
+	if err := os.WriteFile(path, []byte(`{"userQuery":"why","tableCount":1}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := recordLLMUsage(dir, LLMUsage{Calls: 2, InputTokens: 100}, LLMUsage{Calls: 5, InputTokens: 400, CostUSD: 0.5}); err != nil {
+		t.Fatal(err)
+	}
+	b, _ := os.ReadFile(path)
+	var got struct {
+		UserQuery   string   `json:"userQuery"`
+		LLMUsage    LLMUsage `json:"llmUsage"`
+		LLMRunUsage LLMUsage `json:"llmRunUsage"`
+	}
+	if err := json.Unmarshal(b, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.UserQuery != "why" || got.LLMUsage.Calls != 2 || got.LLMRunUsage.CostUSD != 0.5 {
+		t.Errorf("summary.json = %s", b)
+	}
+}
@@ -0,0 +1,215 @@
+package mustgather
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLLMProvidersComplete(t *testing.T) {
+	tests := []struct {
+		name       string
+		provider   func(url string) LLMProvider
+		wantPath   string
+		wantHeader [2]string
+		reply      string
+	}{
+		{
+			name: "anthropic",
+			provider: func(url string) LLMProvider {
+				return &anthropicProvider{baseURL: url, apiKey: "k", model: "m", client: http.DefaultClient}
+			},
+			wantPath:   "/v1/messages",
+			wantHeader: [2]string{"x-api-key", "k"},
+			reply:      `{"content":[{"type":"text","text":" KubeEvents | take 1 "}]}`,
+		},
+		{
+			name: "openai",
+			provider: func(url string) LLMProvider {
+				return &openAIProvider{url: url + "/v1/chat/completions", apiKey: "k", model: "m", client: http.DefaultClient}
+			},
+			wantPath:   "/v1/chat/completions",
+			wantHeader: [2]string{"Authorization", "Bearer k"},
+			reply:      `{"choices":[{"message":{"content":"KubeEvents | take 1"}}]}`,
+		},
+		{
+			name: "azure-openai",
+			provider: func(url string) LLMProvider {
+				return &openAIProvider{url: url + "/openai/deployments/d/chat/completions?api-version=v", apiKey: "k", azure: true, client: http.DefaultClient}
+			},
+			wantPath:   "/openai/deployments/d/chat/completions",
+			wantHeader: [2]string{"api-key", "k"},
+			reply:      `{"choices":[{"message":{"content":"KubeEvents | take 1"}}]}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var prompt string
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != tt.wantPath {
+					t.Errorf("path = %s, want %s", r.URL.Path, tt.wantPath)
+				}
+				if got := r.Header.Get(tt.wantHeader[0]); got != tt.wantHeader[1] {
+					t.Errorf("header %s = %q, want %q", tt.wantHeader[0], got, tt.wantHeader[1])
+				}
+				var body struct {
+					Messages []struct{ Content string } `json:"messages"`
+				}
+				if err := json.NewDecoder(r.Body).Decode(&body); err != nil || len(body.Messages) != 1 {
+					t.Errorf("bad request body: %v", err)
+				} else {
+					prompt = body.Messages[0].Content
+				}
+				w.Write([]byte(tt.reply))
+			}))
+			defer srv.Close()
+
+			p := tt.provider(srv.URL)
+			if p.Name() != tt.name {
+				t.Errorf("Name() = %s, want %s", p.Name(), tt.name)
+			}
+			got, err := p.Complete(context.Background(), "show events")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != "KubeEvents | take 1" {
+				t.Errorf("Complete() = %q", got)
+			}
+			if prompt != "show events" {
+				t.Errorf("sent prompt %q", prompt)
+			}
+		})
+	}
+}
+
+func TestLLMProviderHTTPError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, `{"error":{"message":"invalid api key"}}`, http.StatusUnauthorized)
+	}))
+	defer srv.Close()
+
+	p := &openAIProvider{url: srv.URL, apiKey: "bad", model: "m", client: http.DefaultClient}
+	_, err := p.Complete(context.Background(), "hi")
+	if err == nil || !strings.Contains(err.Error(), "HTTP 401") || !strings.Contains(err.Error(), "invalid api key") {
+		t.Fatalf("err = %v, want HTTP 401 with message", err)
+	}
+}
+
+func TestNewLLMProviderSelection(t *testing.T) {
+	tests := []struct {
+		name    string
+		flag    string
+		env     map[string]string
+		want    string
+		wantErr string
+	}{
+		{name: "explicit anthropic", flag: "anthropic", env: map[string]string{"ANTHROPIC_API_KEY": "k"}, want: ProviderAnthropic},
+		{name: "explicit openai without key", flag: "openai", wantErr: "OPENAI_API_KEY"},
+		{name: "env var selects provider", env: map[string]string{aiProviderEnv: "openai", "OPENAI_API_KEY": "k", "ANTHROPIC_API_KEY": "k"}, want: ProviderOpenAI},
+		{name: "legacy env var selects provider", env: map[string]string{legacyAIProviderEnv: "openai", "OPENAI_API_KEY": "k", "ANTHROPIC_API_KEY": "k"}, want: ProviderOpenAI},
+		{name: "env var wins over legacy", env: map[string]string{aiProviderEnv: "anthropic", legacyAIProviderEnv: "openai", "OPENAI_API_KEY": "k", "ANTHROPIC_API_KEY": "k"}, want: ProviderAnthropic},
+		{name: "flag wins over env var", flag: "anthropic", env: map[string]string{aiProviderEnv: "openai", "OPENAI_API_KEY": "k", "ANTHROPIC_API_KEY": "k"}, want: ProviderAnthropic},
+		{name: "detect azure first", env: map[string]string{"AZURE_OPENAI_ENDPOINT": "https://x", "AZURE_OPENAI_API_KEY": "k", "AZURE_OPENAI_DEPLOYMENT": "d", "OPENAI_API_KEY": "k"}, want: ProviderAzureOpenAI},
+		{name: "detect anthropic", env: map[string]string{"ANTHROPIC_API_KEY": "k"}, want: ProviderAnthropic},
+		{name: "azure missing deployment", flag: "azure-openai", env: map[string]string{"AZURE_OPENAI_ENDPOINT": "https://x", "AZURE_OPENAI_API_KEY": "k"}, wantErr: "AZURE_OPENAI_DEPLOYMENT"},
+		{name: "unknown provider", flag: "bard", wantErr: "unknown AI provider"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, k := range []string{aiProviderEnv, legacyAIProviderEnv, "ANTHROPIC_API_KEY", "OPENAI_API_KEY", "AZURE_OPENAI_ENDPOINT", "AZURE_OPENAI_API_KEY", "AZURE_OPENAI_DEPLOYMENT"} {
+				t.Setenv(k, tt.env[k])
+			}
+			p, err := NewLLMProvider(tt.flag)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("err = %v, want containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if p.Name() != tt.want {
+				t.Errorf("provider = %s, want %s", p.Name(), tt.want)
+			}
+		})
+	}
+}
+
+// recordingProvider captures prompts for providers that cannot read files.
+type recordingProvider struct {
+	prompts []string
+	reply   string
+}
+
+func (p *recordingProvider) Name() string     { return "fake" }
+func (p *recordingProvider) ReadsFiles() bool { return false }
+func (p *recordingProvider) Complete(ctx context.Context, prompt string) (string, error) {
+	p.prompts = append(p.prompts, prompt)
+	return p.reply, nil
+}
+
+func TestAIQueryGeneratorInlinesFiles(t *testing.T) {
+	docs := t.TempDir()
+	if err := os.WriteFile(filepath.Join(docs, "KubeEvents.md"), []byte("| Reason | string |"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	old := tableDocsDir
+	tableDocsDir = docs
+	defer func() { tableDocsDir = old }()
+
+	p := &recordingProvider{reply: `{"kql": "KubeEvents | take 1", "tables_used": ["KubeEvents"]}`}
+	ai := &AIQueryGenerator{provider: p}
+
+	kql, err := ai.GenerateKQLQuery(context.Background(), "show events", []string{"KubeEvents", "Perf"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if kql != "KubeEvents | take 1" {
+		t.Errorf("kql = %q", kql)
+	}
+	if !strings.Contains(p.prompts[0], "--- KubeEvents.md ---\n| Reason | string |") {
+		t.Errorf("schema doc not inlined:\n%s", p.prompts[0])
+	}
+
+	results := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(results, "ai-query-results"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(results, "ai-query-results", "table_0.json"), []byte(`{"rows":[["pod-a"]]}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	p.reply = "pod-a is crashing"
+	if _, err := ai.AnalyzeResults(context.Background(), "why", "KubeEvents", results); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(p.prompts[1], `--- ai-query-results/table_0.json ---`+"\n"+`{"rows":[["pod-a"]]}`) {
+		t.Errorf("results not inlined:\n%s", p.prompts[1])
+	}
+}
+
+func TestInlineFilesLimit(t *testing.T) {
+	dir := t.TempDir()
+	a := filepath.Join(dir, "a.json")
+	b := filepath.Join(dir, "b.json")
+	os.WriteFile(a, []byte(strings.Repeat("x", 100)), 0o644)
+	os.WriteFile(b, []byte("yyy"), 0o644)
+
+	got := inlineFiles(dir, []string{a, b}, 50)
+	if !strings.Contains(got, "(truncated)") || !strings.Contains(got, "b.json and further files omitted") {
+		t.Errorf("limit not applied:\n%s", got)
+	}
+	if strings.Count(got, "x") > 50 {
+		t.Errorf("included more than the limit")
+	}
+	if inlineFiles(dir, nil, 50) != "" {
+		t.Errorf("expected empty string for no files")
+	}
+}
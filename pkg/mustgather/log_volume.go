@@ -0,0 +1,151 @@
+package mustgather
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"kubectl-must-gather/pkg/utils"
+)
+
+// logVolumePath is where the log volume statistics are written.
+const logVolumePath = "analysis/log-volume.json"
+
+// logVolumeTopN caps the pods and containers listed; namespaces are all kept.
+const logVolumeTopN = 50
+
+// logVolumeQuery sums container log lines and billed bytes per container over
+// the whole window, server side, so the statistics cover every row whether
+// or not the rows themselves are exported.
+const logVolumeQuery = `ContainerLogV2
+| summarize Lines=count(), Bytes=sum(_BilledSize) by PodNamespace, PodName, ContainerName`
+
+// LogVolume is the entry of one namespace, pod or container in
+// analysis/log-volume.json. Share is its percentage of all log bytes.
+type LogVolume struct {
+	Namespace string  `json:"namespace"`
+	Pod       string  `json:"pod,omitempty"`
+	Container string  `json:"container,omitempty"`
+	Lines     int64   `json:"lines"`
+	Bytes     int64   `json:"bytes"`
+	Share     float64 `json:"share"`
+}
+
+// logVolumeReport is the content of analysis/log-volume.json. Pods and
+// containers are the top talkers; Omitted counts those left out.
+type logVolumeReport struct {
+	From       string       `json:"from"`
+	To         string       `json:"to"`
+	Query      string       `json:"query"`
+	Lines      int64        `json:"totalLines"`
+	Bytes      int64        `json:"totalBytes"`
+	Namespaces []*LogVolume `json:"namespaces"`
+	Pods       []*LogVolume `json:"pods"`
+	Containers []*LogVolume `json:"containers"`
+	Omitted    struct {
+		Pods       int `json:"pods,omitempty"`
+		Containers int `json:"containers,omitempty"`
+	} `json:"omitted"`
+}
+
+// gatherLogVolume runs logVolumeQuery over the gather window and writes the
+// per namespace, pod and container totals, largest first, to
+// analysis/log-volume.json. A failed query is reported and skipped.
+func (g *Gatherer) gatherLogVolume(tarw *utils.TarWriter, lcli LogsQueryClient, workspaceGUID string) {
+	fmt.Fprintln(g.log, "Summarizing log volume...")
+	query := logVolumeQuery
+	if g.scope != nil {
+		query = strings.Replace(query, "\n", "\n| where "+g.scope.filter("PodNamespace")+"\n", 1)
+	}
+	res, err := g.query(g.ctx, lcli, workspaceGUID, query, g.start, g.end)
+	if err == nil && res.Error != nil {
+		err = res.Error
+	}
+	if err != nil {
+		g.warnf("", "log volume query failed: %v", err)
+		return
+	}
+	var rows []map[string]any
+	if len(res.Tables) > 0 {
+		rows = tableRows(res.Tables[0])
+	}
+	report := summarizeLogVolume(rows)
+	report.Query = query
+	report.From = g.start.Format(time.RFC3339)
+	report.To = g.end.Format(time.RFC3339)
+	b, _ := json.MarshalIndent(report, "", "  ")
+	_ = utils.WriteFileToTar(tarw, logVolumePath, b)
+}
+
+// summarizeLogVolume rolls the per container rows of logVolumeQuery up to
+// pods and namespaces.
+func summarizeLogVolume(rows []map[string]any) *logVolumeReport {
+	r := &logVolumeReport{Query: logVolumeQuery, Namespaces: []*LogVolume{}, Pods: []*LogVolume{}, Containers: []*LogVolume{}}
+	namespaces := map[string]*LogVolume{}
+	pods := map[string]*LogVolume{}
+	for _, row := range rows {
+		lines, _ := toFloat(row["Lines"])
+		bytes, _ := toFloat(row["Bytes"])
+		c := &LogVolume{
+			Namespace: toStr(row["PodNamespace"]),
+			Pod:       toStr(row["PodName"]),
+			Container: toStr(row["ContainerName"]),
+			Lines:     int64(lines),
+			Bytes:     int64(bytes),
+		}
+		r.Containers = append(r.Containers, c)
+		r.Lines += c.Lines
+		r.Bytes += c.Bytes
+
+		ns, ok := namespaces[c.Namespace]
+		if !ok {
+			ns = &LogVolume{Namespace: c.Namespace}
+			namespaces[c.Namespace] = ns
+			r.Namespaces = append(r.Namespaces, ns)
+		}
+		ns.Lines += c.Lines
+		ns.Bytes += c.Bytes
+
+		key := c.Namespace + "/" + c.Pod
+		p, ok := pods[key]
+		if !ok {
+			p = &LogVolume{Namespace: c.Namespace, Pod: c.Pod}
+			pods[key] = p
+			r.Pods = append(r.Pods, p)
+		}
+		p.Lines += c.Lines
+		p.Bytes += c.Bytes
+	}
+	for _, l := range [][]*LogVolume{r.Namespaces, r.Pods, r.Containers} {
+		for _, v := range l {
+			if r.Bytes > 0 {
+				v.Share = math.Round(1000*float64(v.Bytes)/float64(r.Bytes)) / 10
+			}
+		}
+		sortLogVolumes(l)
+	}
+	if n := len(r.Pods); n > logVolumeTopN {
+		r.Pods, r.Omitted.Pods = r.Pods[:logVolumeTopN], n-logVolumeTopN
+	}
+	if n := len(r.Containers); n > logVolumeTopN {
+		r.Containers, r.Omitted.Containers = r.Containers[:logVolumeTopN], n-logVolumeTopN
+	}
+	return r
+}
+
+// sortLogVolumes orders l by bytes, then lines, largest first.
+func sortLogVolumes(l []*LogVolume) {
+	sort.Slice(l, func(i, j int) bool {
+		a, b := l[i], l[j]
+		if a.Bytes != b.Bytes {
+			return a.Bytes > b.Bytes
+		}
+		if a.Lines != b.Lines {
+			return a.Lines > b.Lines
+		}
+		return a.Namespace+"/"+a.Pod+"/"+a.Container < b.Namespace+"/"+b.Pod+"/"+b.Container
+	})
+}
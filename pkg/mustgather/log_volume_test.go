@@ -0,0 +1,63 @@
+package mustgather
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestSummarizeLogVolume(t *testing.T) {
+	rows := []map[string]any{
+		{"PodNamespace": "shop", "PodName": "web-1", "ContainerName": "web", "Lines": float64(600), "Bytes": float64(60000)},
+		{"PodNamespace": "shop", "PodName": "web-1", "ContainerName": "sidecar", "Lines": float64(100), "Bytes": float64(5000)},
+		{"PodNamespace": "shop", "PodName": "cart-1", "ContainerName": "cart", "Lines": float64(50), "Bytes": float64(5000)},
+		{"PodNamespace": "kube-system", "PodName": "coredns-1", "ContainerName": "coredns", "Lines": "250", "Bytes": "30000"},
+	}
+	r := summarizeLogVolume(rows)
+	if r.Lines != 1000 || r.Bytes != 100000 {
+		t.Errorf("totals = %d lines, %d bytes, want 1000, 100000", r.Lines, r.Bytes)
+	}
+
+	format := func(l []*LogVolume) []string {
+		var out []string
+		for _, v := range l {
+			out = append(out, fmt.Sprintf("%s/%s/%s %d %d %.1f", v.Namespace, v.Pod, v.Container, v.Lines, v.Bytes, v.Share))
+		}
+		return out
+	}
+	for _, tc := range []struct {
+		name string
+		got  []*LogVolume
+		want []string
+	}{
+		{"namespaces", r.Namespaces, []string{"shop// 750 70000 70.0", "kube-system// 250 30000 30.0"}},
+		{"pods", r.Pods, []string{"shop/web-1/ 700 65000 65.0", "kube-system/coredns-1/ 250 30000 30.0", "shop/cart-1/ 50 5000 5.0"}},
+		// Ties on bytes go to the container with more lines
+		{"containers", r.Containers, []string{"shop/web-1/web 600 60000 60.0", "kube-system/coredns-1/coredns 250 30000 30.0", "shop/web-1/sidecar 100 5000 5.0", "shop/cart-1/cart 50 5000 5.0"}},
+	} {
+		got := format(tc.got)
+		if fmt.Sprint(got) != fmt.Sprint(tc.want) {
+			t.Errorf("%s\n got %q\nwant %q", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestSummarizeLogVolumeTopN(t *testing.T) {
+	var rows []map[string]any
+	for i := 0; i < logVolumeTopN+5; i++ {
+		rows = append(rows, map[string]any{"PodNamespace": "ns", "PodName": fmt.Sprintf("pod-%d", i), "ContainerName": "c", "Lines": float64(1), "Bytes": float64(i + 1)})
+	}
+	r := summarizeLogVolume(rows)
+	if len(r.Pods) != logVolumeTopN || r.Omitted.Pods != 5 || len(r.Containers) != logVolumeTopN || r.Omitted.Containers != 5 {
+		t.Errorf("got %d pods (%d omitted), %d containers (%d omitted)", len(r.Pods), r.Omitted.Pods, len(r.Containers), r.Omitted.Containers)
+	}
+	if r.Pods[0].Pod != fmt.Sprintf("pod-%d", logVolumeTopN+4) {
+		t.Errorf("largest pod first, got %s", r.Pods[0].Pod)
+	}
+	if len(r.Namespaces) != 1 || r.Namespaces[0].Share != 100 {
+		t.Errorf("namespaces = %+v", r.Namespaces)
+	}
+
+	if empty := summarizeLogVolume(nil); empty.Namespaces == nil || empty.Containers == nil || empty.Pods == nil {
+		t.Errorf("empty lists should be [] in JSON: %+v", empty)
+	}
+}
@@ -0,0 +1,65 @@
+package mustgather
+
+import (
+	"bytes"
+	"strings"
+	"sync"
+)
+
+// Logger receives what a gather reports for people, one line per call:
+// progress at Info and warnings at Warn. Warnings about a table carry it as
+// a "table" attribute. *slog.Logger implements it.
+type Logger interface {
+	Info(msg string, args ...any)
+	Warn(msg string, args ...any)
+}
+
+// logWriter logs each line written to it with a Logger, so the code writing
+// progress to an io.Writer needs no change. Lines marked "warn:" are logged
+// as warnings.
+type logWriter struct {
+	l   Logger
+	mu  sync.Mutex
+	buf []byte
+}
+
+func newLogWriter(l Logger) *logWriter {
+	return &logWriter{l: l}
+}
+
+func (w *logWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.buf = append(w.buf, p...)
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 {
+			return len(p), nil
+		}
+		w.logLine(string(w.buf[:i]))
+		w.buf = w.buf[i+1:]
+	}
+}
+
+// logLine logs a line without its indentation; blank lines are dropped.
+func (w *logWriter) logLine(line string) {
+	line = strings.TrimSpace(line)
+	if line == "" {
+		return
+	}
+	if msg, ok := strings.CutPrefix(line, "warn: "); ok {
+		w.l.Warn(msg)
+		return
+	}
+	w.l.Info(line)
+}
+
+// warn logs msg as a warning about table, or about the gather when table
+// is empty.
+func (w *logWriter) warn(table, msg string) {
+	if table != "" {
+		w.l.Warn(msg, "table", table)
+		return
+	}
+	w.l.Warn(msg)
+}
@@ -0,0 +1,262 @@
+package mustgather
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"kubectl-must-gather/pkg/bundle"
+)
+
+// lokiPushPath is the push API of Loki, appended to the --url base.
+const lokiPushPath = "/loki/api/v1/push"
+
+// stitchedContainerLog matches the stitched container logs of a bundle:
+// namespaces/<ns>/pods/<pod>/<container>.log and its .previous[-N].log
+// files.
+var stitchedContainerLog = regexp.MustCompile(`^namespaces/([^/]+)/pods/([^/]+)/([^/]+?)(?:\.previous(?:-(\d+))?)?\.log$`)
+
+// LokiOptions configures ExportLoki.
+type LokiOptions struct {
+	// URL is the base URL of Loki, to which /loki/api/v1/push is appended
+	// unless it already ends with it.
+	URL string
+	// TenantID, if set, is sent as X-Scope-OrgID to multi-tenant Loki.
+	TenantID string
+	// Headers are added to every request, e.g. for authentication.
+	Headers map[string]string
+	// Labels are added to every stream, e.g. to tell gathers apart.
+	Labels map[string]string
+	// BatchSize caps the log lines sent per request.
+	BatchSize  int
+	MaxRetries int
+	Client     *http.Client
+	// Logger receives a warning for each throttled request retried; nil
+	// drops them.
+	Logger Logger
+}
+
+// LokiStats counts what ExportLoki sent.
+type LokiStats struct {
+	Containers int
+	Lines      int64
+	// Skipped counts lines without a parsable timestamp.
+	Skipped  int64
+	Requests int
+}
+
+// ExportLoki pushes the stitched container logs of the bundle at src to
+// Loki, one stream per container and output stream, labeled with
+// namespace, pod, container and stream (stdout or stderr). Logs of previous
+// container instances are sent before the current ones so every stream
+// stays in time order, as Loki requires.
+func ExportLoki(ctx context.Context, src string, opts LokiOptions) (*LokiStats, error) {
+	b, err := bundle.Open(src)
+	if err != nil {
+		return nil, fmt.Errorf("open bundle: %w", err)
+	}
+	defer b.Close()
+	files, err := b.Files()
+	if err != nil {
+		return nil, fmt.Errorf("list bundle files: %w", err)
+	}
+	logs := stitchedContainerLogs(files)
+	if len(logs) == 0 {
+		return &LokiStats{}, fmt.Errorf("no stitched container logs in %s; gather with --stitch-logs or run migrate first", src)
+	}
+
+	url := strings.TrimSuffix(opts.URL, "/")
+	if !strings.HasSuffix(url, lokiPushPath) {
+		url += lokiPushPath
+	}
+	headers := map[string]string{}
+	for k, v := range opts.Headers {
+		headers[k] = v
+	}
+	if opts.TenantID != "" {
+		headers["X-Scope-OrgID"] = opts.TenantID
+	}
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = 1000
+	}
+	e := &lokiExporter{
+		target:    newPushTarget(ctx, "Loki", url, opts.Client, headers, opts.MaxRetries, opts.Logger),
+		batchSize: opts.BatchSize,
+		labels:    opts.Labels,
+		stats:     &LokiStats{Containers: len(logs)},
+	}
+	e.reset()
+	for _, c := range logs {
+		for _, f := range c.files {
+			if err := e.pushFile(filepath.Join(b.Dir, filepath.FromSlash(f)), c); err != nil {
+				return e.stats, fmt.Errorf("%s: %w", f, err)
+			}
+		}
+	}
+	err = e.flush()
+	return e.stats, err
+}
+
+// containerLogs are the stitched log files of one container, oldest first.
+type containerLogs struct {
+	namespace, pod, container string
+	files                     []string
+}
+
+// stitchedContainerLogs groups the stitched container logs among files by
+// container, ordering each container's files from its oldest previous
+// instance to the current one.
+func stitchedContainerLogs(files []string) []*containerLogs {
+	type file struct {
+		path string
+		n    int
+	}
+	byKey := map[string]*containerLogs{}
+	instances := map[*containerLogs][]file{}
+	var out []*containerLogs
+	for _, f := range files {
+		m := stitchedContainerLog.FindStringSubmatch(f)
+		if m == nil || m[3] == "events" {
+			continue
+		}
+		n := 0
+		if strings.Contains(f, ".previous") {
+			n = 1
+			if m[4] != "" {
+				n, _ = strconv.Atoi(m[4])
+			}
+		}
+		key := m[1] + "/" + m[2] + "/" + m[3]
+		c, ok := byKey[key]
+		if !ok {
+			c = &containerLogs{namespace: m[1], pod: m[2], container: m[3]}
+			byKey[key] = c
+			out = append(out, c)
+		}
+		instances[c] = append(instances[c], file{f, n})
+	}
+	for _, c := range out {
+		fs := instances[c]
+		sort.Slice(fs, func(i, j int) bool { return fs[i].n > fs[j].n })
+		for _, f := range fs {
+			c.files = append(c.files, f.path)
+		}
+	}
+	return out
+}
+
+// lokiPush is the JSON body of the Loki push API.
+type lokiPush struct {
+	Streams []*lokiStream `json:"streams"`
+}
+
+type lokiStream struct {
+	Stream map[string]string `json:"stream"`
+	Values [][2]string       `json:"values"`
+}
+
+// lokiExporter batches log lines by stream and pushes them to Loki.
+type lokiExporter struct {
+	target    *pushTarget
+	batchSize int
+	labels    map[string]string
+	stats     *LokiStats
+	batch     *lokiPush
+	streams   map[string]*lokiStream
+	buffered  int
+}
+
+func (e *lokiExporter) reset() {
+	e.batch = &lokiPush{}
+	e.streams = map[string]*lokiStream{}
+	e.buffered = 0
+}
+
+// pushFile sends the lines of a stitched log file of container c, each
+// "<time> [<stream>] <message>".
+func (e *lokiExporter) pushFile(path string, c *containerLogs) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for sc.Scan() {
+		ts, stream, msg, ok := parseStitchedLine(sc.Text())
+		if !ok {
+			e.stats.Skipped++
+			continue
+		}
+		if err := e.add(c, stream, ts, msg); err != nil {
+			return err
+		}
+	}
+	return sc.Err()
+}
+
+// parseStitchedLine splits a stitched container log line.
+func parseStitchedLine(line string) (ts time.Time, stream, msg string, ok bool) {
+	raw, rest, found := strings.Cut(line, " ")
+	if !found {
+		return time.Time{}, "", "", false
+	}
+	ts, err := time.Parse(time.RFC3339Nano, raw)
+	if err != nil {
+		return time.Time{}, "", "", false
+	}
+	if strings.HasPrefix(rest, "[") {
+		if end := strings.Index(rest, "] "); end > 0 {
+			return ts, rest[1:end], rest[end+2:], true
+		}
+	}
+	return ts, "", rest, true
+}
+
+func (e *lokiExporter) add(c *containerLogs, stream string, ts time.Time, msg string) error {
+	key := c.namespace + "/" + c.pod + "/" + c.container + "/" + stream
+	s, ok := e.streams[key]
+	if !ok {
+		labels := map[string]string{"job": "aks-must-gather"}
+		for k, v := range e.labels {
+			labels[k] = v
+		}
+		labels["namespace"], labels["pod"], labels["container"] = c.namespace, c.pod, c.container
+		if stream != "" {
+			labels["stream"] = stream
+		}
+		s = &lokiStream{Stream: labels}
+		e.streams[key] = s
+		e.batch.Streams = append(e.batch.Streams, s)
+	}
+	s.Values = append(s.Values, [2]string{strconv.FormatInt(ts.UnixNano(), 10), msg})
+	e.stats.Lines++
+	if e.buffered++; e.buffered >= e.batchSize {
+		return e.flush()
+	}
+	return nil
+}
+
+// flush sends the buffered lines.
+func (e *lokiExporter) flush() error {
+	if e.buffered == 0 {
+		return nil
+	}
+	body, err := json.Marshal(e.batch)
+	if err != nil {
+		return err
+	}
+	e.reset()
+	err = e.target.post(body)
+	e.stats.Requests = e.target.requests
+	return err
+}
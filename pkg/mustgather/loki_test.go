@@ -0,0 +1,111 @@
+package mustgather
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestExportLoki(t *testing.T) {
+	src := writeBundleDir(t, map[string]string{
+		"index.json": `{"tables":["ContainerLogV2"]}`,
+		"namespaces/shop/pods/cart-1/cart.log": "2024-01-01T00:00:05Z [stdout] starting again\n" +
+			"2024-01-01T00:00:06Z [stderr] warn: slow\n",
+		"namespaces/shop/pods/cart-1/cart.previous.log":   "2024-01-01T00:00:03Z [stderr] panic: boom\n",
+		"namespaces/shop/pods/cart-1/cart.previous-2.log": "2024-01-01T00:00:01Z [stdout] first run\nnot a log line\n",
+		"namespaces/shop/pods/cart-1/events.log":          "2024-01-01T00:00:04Z [Warning] BackOff: Back-off restarting failed container\n",
+		"namespaces/shop/pods/cart-1/pod.yaml":            "kind: Pod\n",
+		"nodes/node-1/syslog.log":                         "2024-01-01T00:00:02Z [-] kernel: hello\n",
+	})
+
+	var pushes []lokiPush
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != lokiPushPath || r.Header.Get("X-Scope-OrgID") != "team-a" {
+			http.Error(w, "bad request "+r.URL.Path, http.StatusBadRequest)
+			return
+		}
+		var p lokiPush
+		if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		pushes = append(pushes, p)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	stats, err := ExportLoki(context.Background(), src, LokiOptions{
+		URL:       srv.URL,
+		TenantID:  "team-a",
+		Labels:    map[string]string{"gather": "incident-42"},
+		BatchSize: 3,
+	})
+	if err != nil {
+		t.Fatalf("ExportLoki failed: %v", err)
+	}
+	if stats.Containers != 1 || stats.Lines != 4 || stats.Skipped != 1 || stats.Requests != 2 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+
+	// Per stream, lines in push order
+	streams := map[string][]string{}
+	for _, p := range pushes {
+		for _, s := range p.Streams {
+			var labels []string
+			for k, v := range s.Stream {
+				labels = append(labels, k+"="+v)
+			}
+			sort.Strings(labels)
+			key := strings.Join(labels, ",")
+			for _, v := range s.Values {
+				streams[key] = append(streams[key], v[0]+" "+v[1])
+			}
+		}
+	}
+	want := map[string][]string{
+		"container=cart,gather=incident-42,job=aks-must-gather,namespace=shop,pod=cart-1,stream=stdout": {
+			"1704067201000000000 first run",
+			"1704067205000000000 starting again",
+		},
+		"container=cart,gather=incident-42,job=aks-must-gather,namespace=shop,pod=cart-1,stream=stderr": {
+			"1704067203000000000 panic: boom",
+			"1704067206000000000 warn: slow",
+		},
+	}
+	if fmt.Sprint(streams) != fmt.Sprint(want) {
+		t.Errorf("pushed streams\n got %q\nwant %q", streams, want)
+	}
+}
+
+func TestExportLokiWithoutStitchedLogs(t *testing.T) {
+	src := writeBundleDir(t, map[string]string{"index.json": `{"tables":[]}`})
+	_, err := ExportLoki(context.Background(), src, LokiOptions{URL: "http://127.0.0.1:0"})
+	if err == nil || !strings.Contains(err.Error(), "--stitch-logs") {
+		t.Errorf("expected a hint to stitch logs, got %v", err)
+	}
+}
+
+func TestParseStitchedLine(t *testing.T) {
+	tests := []struct {
+		line           string
+		stream, msg    string
+		ok             bool
+		wantUnixSecond int64
+	}{
+		{line: "2024-01-01T00:00:01.5Z [stderr] a [b] c", stream: "stderr", msg: "a [b] c", ok: true, wantUnixSecond: 1704067201},
+		{line: "2024-01-01T00:00:01Z no stream", msg: "no stream", ok: true, wantUnixSecond: 1704067201},
+		{line: "yesterday [stdout] x"},
+		{line: "single"},
+	}
+	for _, tt := range tests {
+		ts, stream, msg, ok := parseStitchedLine(tt.line)
+		if ok != tt.ok || stream != tt.stream || msg != tt.msg || (ok && ts.Unix() != tt.wantUnixSecond) {
+			t.Errorf("parseStitchedLine(%q) = %v, %q, %q, %v", tt.line, ts, stream, msg, ok)
+		}
+	}
+}
@@ -0,0 +1,256 @@
+package mustgather
+
+import (
+	"bytes"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"kubectl-must-gather/pkg/utils"
+)
+
+// podManifestHeader marks reconstructed manifests so nobody mistakes them for
+// objects read from the API server.
+const podManifestHeader = "# Reconstructed from Log Analytics (KubePodInventory, ContainerInventory).\n# Best effort: fields not recorded by Container Insights are missing.\n"
+
+// podManifests reconstructs namespaces/<ns>/pods/<pod>/pod.yaml from the most
+// recent KubePodInventory snapshot of each pod, enriched with image and
+// termination details from ContainerInventory.
+type podManifests struct {
+	pods map[string]*podSnapshot
+	// containers holds the latest ContainerInventory row per container ID.
+	containers map[string]map[string]any
+}
+
+type podSnapshot struct {
+	tm         string
+	row        map[string]any
+	containers map[string]map[string]any
+}
+
+func newPodManifests() *podManifests {
+	return &podManifests{pods: map[string]*podSnapshot{}, containers: map[string]map[string]any{}}
+}
+
+func (m *podManifests) observe(table string, row map[string]any) {
+	switch table {
+	case "KubePodInventory":
+		ns, name := toStr(row["Namespace"]), toStr(row["Name"])
+		if name == "" {
+			return
+		}
+		key := ns + "/" + name
+		tm := toStr(row["TimeGenerated"])
+		p, ok := m.pods[key]
+		if !ok {
+			p = &podSnapshot{containers: map[string]map[string]any{}}
+			m.pods[key] = p
+		}
+		if later(tm, p.tm) {
+			p.tm, p.row = tm, row
+		}
+		cn := podContainerName(toStr(row["ContainerName"]))
+		if cn == "" {
+			return
+		}
+		if prev, ok := p.containers[cn]; !ok || later(tm, toStr(prev["TimeGenerated"])) {
+			p.containers[cn] = row
+		}
+	case "ContainerInventory":
+		id := toStr(row["ContainerID"])
+		if id == "" {
+			return
+		}
+		if prev, ok := m.containers[id]; !ok || later(toStr(row["TimeGenerated"]), toStr(prev["TimeGenerated"])) {
+			m.containers[id] = row
+		}
+	}
+}
+
+func (m *podManifests) endChunk(tarw *utils.TarWriter) error {
+	return nil
+}
+
+func (m *podManifests) finish(tarw *utils.TarWriter) error {
+	keys := make([]string, 0, len(m.pods))
+	for k := range m.pods {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		p := m.pods[k]
+		var buf bytes.Buffer
+		buf.WriteString(podManifestHeader)
+		enc := yaml.NewEncoder(&buf)
+		enc.SetIndent(2)
+		if err := enc.Encode(m.podObject(p)); err != nil {
+			return err
+		}
+		ns, name := toStr(p.row["Namespace"]), toStr(p.row["Name"])
+		path := filepath.Join("namespaces", utils.SafeFileName(ns), "pods", utils.SafeFileName(name), "pod.yaml")
+		if err := utils.WriteFileToTar(tarw, path, buf.Bytes()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *podManifests) warnings(table string) []string {
+	return nil
+}
+
+// podObject builds a v1 Pod from a pod's latest snapshot.
+func (m *podManifests) podObject(p *podSnapshot) map[string]any {
+	row := p.row
+	meta := map[string]any{
+		"name":      toStr(row["Name"]),
+		"namespace": toStr(row["Namespace"]),
+	}
+	setIf(meta, "uid", toStr(row["PodUid"]))
+	setIf(meta, "creationTimestamp", toStr(row["PodCreationTimeStamp"]))
+	if labels := podLabels(row["PodLabel"]); len(labels) > 0 {
+		meta["labels"] = labels
+	}
+	if kind, owner := toStr(row["ControllerKind"]), toStr(row["ControllerName"]); kind != "" && owner != "" {
+		meta["ownerReferences"] = []any{map[string]any{"kind": kind, "name": owner, "controller": true}}
+	}
+
+	names := make([]string, 0, len(p.containers))
+	for cn := range p.containers {
+		names = append(names, cn)
+	}
+	sort.Strings(names)
+	var containers, statuses []any
+	for _, cn := range names {
+		crow := p.containers[cn]
+		inv := m.containers[toStr(crow["ContainerID"])]
+		image := containerImage(inv)
+		c := map[string]any{"name": cn}
+		setIf(c, "image", image)
+		containers = append(containers, c)
+		statuses = append(statuses, containerStatus(cn, image, crow, inv))
+	}
+
+	spec := map[string]any{}
+	setIf(spec, "nodeName", toStr(row["Computer"]))
+	if len(containers) > 0 {
+		spec["containers"] = containers
+	}
+	status := map[string]any{}
+	setIf(status, "phase", toStr(row["PodStatus"]))
+	setIf(status, "podIP", toStr(row["PodIp"]))
+	setIf(status, "startTime", toStr(row["PodStartTime"]))
+	if len(statuses) > 0 {
+		status["containerStatuses"] = statuses
+	}
+	return map[string]any{
+		"apiVersion": "v1",
+		"kind":       "Pod",
+		"metadata":   meta,
+		"spec":       spec,
+		"status":     status,
+	}
+}
+
+func containerStatus(name, image string, row, inv map[string]any) map[string]any {
+	st := map[string]any{"name": name}
+	setIf(st, "image", image)
+	setIf(st, "containerID", toStr(row["ContainerID"]))
+	if rc, ok := row["ContainerRestartCount"]; ok && rc != nil {
+		st["restartCount"] = rc
+	}
+	reason := toStr(row["ContainerStatusReason"])
+	state := map[string]any{}
+	switch strings.ToLower(toStr(row["ContainerStatus"])) {
+	case "running":
+		s := map[string]any{}
+		setIf(s, "startedAt", toStr(row["ContainerStartTime"]))
+		state["running"] = s
+	case "waiting":
+		s := map[string]any{}
+		setIf(s, "reason", reason)
+		state["waiting"] = s
+	case "terminated":
+		s := map[string]any{}
+		setIf(s, "reason", reason)
+		if inv != nil {
+			if code, ok := inv["ExitCode"]; ok && code != nil {
+				s["exitCode"] = code
+			}
+			setIf(s, "startedAt", toStr(inv["StartedTime"]))
+			setIf(s, "finishedAt", toStr(inv["FinishedTime"]))
+		}
+		state["terminated"] = s
+	}
+	if len(state) > 0 {
+		st["state"] = state
+	}
+	if last, ok := dynamicValue(row["ContainerLastStatus"]).(map[string]any); ok && len(last) > 0 {
+		st["lastState"] = map[string]any{"terminated": last}
+	}
+	return st
+}
+
+// podContainerName strips the "<pod uid>/" prefix KubePodInventory puts on
+// container names.
+func podContainerName(v string) string {
+	if i := strings.LastIndex(v, "/"); i >= 0 {
+		return v[i+1:]
+	}
+	return v
+}
+
+// podLabels flattens the PodLabel column, a JSON array of label maps.
+func podLabels(v any) map[string]any {
+	labels := map[string]any{}
+	switch l := dynamicValue(v).(type) {
+	case []any:
+		for _, item := range l {
+			if m, ok := item.(map[string]any); ok {
+				for k, val := range m {
+					labels[k] = toStr(val)
+				}
+			}
+		}
+	case map[string]any:
+		for k, val := range l {
+			labels[k] = toStr(val)
+		}
+	}
+	return labels
+}
+
+func containerImage(inv map[string]any) string {
+	if inv == nil {
+		return ""
+	}
+	image := toStr(inv["Image"])
+	if repo := toStr(inv["Repository"]); repo != "" && image != "" && !strings.Contains(image, "/") {
+		image = repo + "/" + image
+	}
+	if tag := toStr(inv["ImageTag"]); tag != "" && image != "" && !strings.Contains(image, ":") && !strings.Contains(image, "@") {
+		image += ":" + tag
+	}
+	return image
+}
+
+func setIf(m map[string]any, key, value string) {
+	if value != "" {
+		m[key] = value
+	}
+}
+
+// later reports whether TimeGenerated a is after b; an empty b is always
+// earlier.
+func later(a, b string) bool {
+	if b == "" {
+		return true
+	}
+	ta, tb := utils.ParseTimeRFC3339(a), utils.ParseTimeRFC3339(b)
+	if ta.IsZero() || tb.IsZero() {
+		return a >= b
+	}
+	return !ta.Before(tb)
+}
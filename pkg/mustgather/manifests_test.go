@@ -0,0 +1,81 @@
+package mustgather
+
+import (
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestPodManifestFromInventory(t *testing.T) {
+	m := newPodManifests()
+	podRow := func(tm, container, status, reason string) map[string]any {
+		return map[string]any{
+			"TimeGenerated": tm, "Namespace": "shop", "Name": "cart-7d9f", "PodUid": "uid-1",
+			"PodCreationTimeStamp": "2024-01-01T00:00:00Z", "PodStatus": "Running", "PodIp": "10.0.0.5",
+			"Computer": "aks-node-1", "ControllerKind": "ReplicaSet", "ControllerName": "cart-7d",
+			"PodLabel":      `[{"app":"cart","pod-template-hash":"7d"}]`,
+			"ContainerName": "uid-1/" + container, "ContainerID": "cid-" + container,
+			"ContainerStatus": status, "ContainerStatusReason": reason, "ContainerRestartCount": float64(2),
+			"ContainerLastStatus": `{"reason":"Error","exitCode":1}`,
+		}
+	}
+	m.observe("KubePodInventory", podRow("2024-01-01T00:10:00Z", "cart", "waiting", "CrashLoopBackOff"))
+	m.observe("KubePodInventory", podRow("2024-01-01T00:05:00Z", "cart", "running", ""))
+	m.observe("KubePodInventory", podRow("2024-01-01T00:10:00Z", "sidecar", "terminated", "Completed"))
+	m.observe("ContainerInventory", map[string]any{
+		"TimeGenerated": "2024-01-01T00:10:00Z", "ContainerID": "cid-sidecar", "Image": "envoy", "ImageTag": "v1.30",
+		"Repository": "docker.io", "ExitCode": float64(0), "FinishedTime": "2024-01-01T00:09:00Z",
+	})
+
+	files := readTransform(t, m)
+	raw, ok := files["namespaces/shop/pods/cart-7d9f/pod.yaml"]
+	if !ok {
+		t.Fatalf("pod.yaml not written, got %v", files)
+	}
+	if !strings.HasPrefix(raw, "# Reconstructed") {
+		t.Error("expected reconstruction header")
+	}
+
+	var pod struct {
+		Kind     string
+		Metadata struct {
+			Name            string
+			Labels          map[string]string
+			OwnerReferences []map[string]any `yaml:"ownerReferences"`
+		}
+		Spec struct {
+			NodeName   string `yaml:"nodeName"`
+			Containers []map[string]any
+		}
+		Status struct {
+			Phase             string
+			ContainerStatuses []map[string]any `yaml:"containerStatuses"`
+		}
+	}
+	if err := yaml.Unmarshal([]byte(raw), &pod); err != nil {
+		t.Fatalf("invalid YAML: %v\n%s", err, raw)
+	}
+	if pod.Kind != "Pod" || pod.Metadata.Name != "cart-7d9f" || pod.Spec.NodeName != "aks-node-1" || pod.Status.Phase != "Running" {
+		t.Errorf("unexpected pod: %+v", pod)
+	}
+	if pod.Metadata.Labels["app"] != "cart" || len(pod.Metadata.OwnerReferences) != 1 || pod.Metadata.OwnerReferences[0]["kind"] != "ReplicaSet" {
+		t.Errorf("unexpected metadata: %+v", pod.Metadata)
+	}
+	if len(pod.Status.ContainerStatuses) != 2 {
+		t.Fatalf("expected 2 container statuses, got %v", pod.Status.ContainerStatuses)
+	}
+	cart, sidecar := pod.Status.ContainerStatuses[0], pod.Status.ContainerStatuses[1]
+	if waiting, _ := cart["state"].(map[string]any)["waiting"].(map[string]any); waiting["reason"] != "CrashLoopBackOff" {
+		t.Errorf("expected latest cart status to be waiting/CrashLoopBackOff, got %v", cart["state"])
+	}
+	if cart["lastState"] == nil || cart["restartCount"] != 2 {
+		t.Errorf("expected lastState and restartCount for cart, got %v", cart)
+	}
+	if sidecar["image"] != "docker.io/envoy:v1.30" {
+		t.Errorf("unexpected sidecar image: %v", sidecar["image"])
+	}
+	if term, _ := sidecar["state"].(map[string]any)["terminated"].(map[string]any); term["exitCode"] != 0 || term["finishedAt"] != "2024-01-01T00:09:00Z" {
+		t.Errorf("unexpected sidecar terminated state: %v", sidecar["state"])
+	}
+}
@@ -0,0 +1,87 @@
+package mustgather
+
+import (
+	"fmt"
+	"io"
+	"runtime"
+	"runtime/debug"
+	"sync/atomic"
+	"time"
+)
+
+// memoryHighWater is the fraction of MaxMemory at which a gather degrades to
+// its low-memory mode.
+const memoryHighWater = 0.8
+
+// memoryGovernor watches the process's memory against MaxMemory. Once use
+// gets close to the limit the gather switches to low-memory mode for the rest
+// of the run: stitched logs are sorted on disk instead of in memory and time
+// chunks are queried one at a time. A nil governor never reports low memory.
+type memoryGovernor struct {
+	limit     int64
+	prevLimit int64
+	// out receives the low-memory warning.
+	out  io.Writer
+	low  atomic.Bool
+	done chan struct{}
+}
+
+// newMemoryGovernor returns nil when limit is not positive. The limit is also
+// set as the Go runtime's soft memory limit, so the GC works harder before the
+// gather has to degrade.
+func newMemoryGovernor(limit int64, out io.Writer) *memoryGovernor {
+	if limit <= 0 {
+		return nil
+	}
+	m := &memoryGovernor{limit: limit, prevLimit: debug.SetMemoryLimit(limit), out: out, done: make(chan struct{})}
+	m.sample()
+	go func() {
+		ticker := time.NewTicker(250 * time.Millisecond)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				m.sample()
+			case <-m.done:
+				return
+			}
+		}
+	}()
+	return m
+}
+
+func (m *memoryGovernor) sample() {
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	m.observe(ms.Sys - ms.HeapReleased)
+}
+
+// observe switches to low-memory mode when used bytes reach the high-water
+// mark.
+func (m *memoryGovernor) observe(used uint64) {
+	if float64(used) < float64(m.limit)*memoryHighWater {
+		return
+	}
+	if m.low.CompareAndSwap(false, true) {
+		fmt.Fprintf(m.out, "  warn: memory use %.1f MiB is close to --max-memory %.1f MiB; sorting stitched logs on disk and querying one chunk at a time\n",
+			float64(used)/(1<<20), float64(m.limit)/(1<<20))
+	}
+}
+
+// lowMemory reports whether the gather is in low-memory mode.
+func (m *memoryGovernor) lowMemory() bool {
+	return m != nil && m.low.Load()
+}
+
+// stop ends sampling and restores the previous runtime memory limit.
+func (m *memoryGovernor) stop() {
+	if m == nil {
+		return
+	}
+	select {
+	case <-m.done:
+	default:
+		close(m.done)
+		debug.SetMemoryLimit(m.prevLimit)
+	}
+}
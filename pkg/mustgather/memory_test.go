@@ -0,0 +1,46 @@
+package mustgather
+
+import (
+	"io"
+	"runtime/debug"
+	"testing"
+)
+
+func TestMemoryGovernor(t *testing.T) {
+	var none *memoryGovernor
+	if none.lowMemory() {
+		t.Error("nil governor should never report low memory")
+	}
+	none.stop()
+	if newMemoryGovernor(0, io.Discard) != nil {
+		t.Error("expected no governor without a limit")
+	}
+
+	before := debug.SetMemoryLimit(-1)
+	m := newMemoryGovernor(1<<40, io.Discard)
+	if got := debug.SetMemoryLimit(-1); got != 1<<40 {
+		t.Errorf("expected runtime memory limit to be set, got %d", got)
+	}
+	m.observe(1 << 30)
+	if m.lowMemory() {
+		t.Error("expected normal mode well below the limit")
+	}
+	m.observe(1 << 40 / 10 * 9)
+	if !m.lowMemory() {
+		t.Error("expected low-memory mode at the high-water mark")
+	}
+	m.stop()
+	m.stop()
+	if got := debug.SetMemoryLimit(-1); got != before {
+		t.Errorf("expected runtime memory limit restored to %d, got %d", before, got)
+	}
+
+	g := &Gatherer{config: &Config{Parallelism: 4}, memory: m}
+	if g.parallelism() != 1 {
+		t.Errorf("expected one chunk at a time in low-memory mode, got %d", g.parallelism())
+	}
+	g.memory = nil
+	if g.parallelism() != 4 {
+		t.Errorf("expected configured parallelism, got %d", g.parallelism())
+	}
+}
@@ -0,0 +1,420 @@
+package mustgather
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"kubectl-must-gather/pkg/bundle"
+	"kubectl-must-gather/pkg/utils"
+)
+
+const (
+	// mergeInfoPath records the sources of a merged bundle.
+	mergeInfoPath = "metadata/merge.json"
+	// mergedDir holds the files of later sources that differ from the
+	// first source's copy at the same path.
+	mergedDir = "merged"
+)
+
+// MergeStats counts what Merge wrote.
+type MergeStats struct {
+	Tables int
+	Parts  int
+	Rows   int64
+	// Duplicates is the number of rows dropped because an overlapping
+	// chunk of another source holds the same row.
+	Duplicates int64
+	// Conflicts are the files sources hold with different contents: the
+	// first source's copy keeps the path, the others are written under
+	// merged/<source>/.
+	Conflicts []string
+}
+
+// mergeSource is a bundle being merged.
+type mergeSource struct {
+	name       string
+	path       string
+	b          *bundle.Bundle
+	incomplete bool
+}
+
+// mergePart is a table part of a source.
+type mergePart struct {
+	src        int
+	name       string
+	start, end time.Time
+	windowed   bool
+}
+
+// mergeTableInfo is what the sources say of a table in its summary.json.
+type mergeTableInfo struct {
+	Coverage   tableCoverage `json:"coverage"`
+	Incomplete bool          `json:"incomplete"`
+}
+
+// Merge unions the bundles at srcs (archives or extracted directories),
+// e.g. several incremental gathers or gathers of different workspaces, into
+// a new archive at dst. Table parts of all sources are written in time
+// order; where chunks of different sources overlap, rows both hold are
+// written once. Stitched logs and the other derived files are regenerated
+// from the merged rows, and index.json lists the tables of all sources.
+func Merge(srcs []string, dst string) (*MergeStats, error) {
+	if len(srcs) < 2 {
+		return nil, errors.New("merge needs at least two bundles")
+	}
+	var sources []*mergeSource
+	defer func() {
+		for _, s := range sources {
+			s.b.Close()
+		}
+	}()
+	names := map[string]int{}
+	for _, src := range srcs {
+		b, err := bundle.Open(src)
+		if err != nil {
+			return nil, fmt.Errorf("open bundle %s: %w", src, err)
+		}
+		s := &mergeSource{path: src, b: b, incomplete: b.Incomplete()}
+		sources = append(sources, s)
+		if v := b.Version(); v != bundle.FormatVersion {
+			return nil, fmt.Errorf("%s is at bundle format version %d, not %d; upgrade it with migrate first", src, v, bundle.FormatVersion)
+		}
+		base := strings.TrimSuffix(strings.TrimSuffix(strings.TrimSuffix(filepath.Base(filepath.Clean(src)), ".tgz"), ".gz"), ".tar")
+		s.name = utils.SafeFileName(base)
+		if n := names[s.name]; n > 0 {
+			s.name = fmt.Sprintf("%s-%d", s.name, n+1)
+		}
+		names[s.name]++
+	}
+
+	outF, err := os.Create(dst)
+	if err != nil {
+		return nil, fmt.Errorf("create out: %w", err)
+	}
+	defer outF.Close()
+	gz := gzip.NewWriter(outF)
+	defer gz.Close()
+	tarw := utils.NewTarWriter(gz, time.Now())
+	defer tarw.Close()
+
+	stats := &MergeStats{}
+	var allFiles []string
+	report := false
+	// digests holds the digest of the file written at each path
+	digests := map[string][32]byte{}
+	for _, s := range sources {
+		files, err := s.b.Files()
+		if err != nil {
+			return nil, fmt.Errorf("list files of %s: %w", s.path, err)
+		}
+		allFiles = append(allFiles, files...)
+		for _, f := range files {
+			switch {
+			case f == reportPath:
+				report = true
+				continue
+			case f == bundle.InfoPath, f == toolInfoPath, f == "index.json", f == mergeInfoPath,
+				strings.HasPrefix(f, "tables/"), isDerived(f):
+				continue
+			}
+			local := filepath.Join(s.b.Dir, filepath.FromSlash(f))
+			sum, err := fileDigest(local)
+			if err != nil {
+				return nil, err
+			}
+			name := f
+			if first, ok := digests[f]; ok {
+				if first == sum {
+					continue
+				}
+				name = path.Join(mergedDir, s.name, f)
+				stats.Conflicts = append(stats.Conflicts, f)
+			} else {
+				digests[f] = sum
+			}
+			if err := utils.WriteLocalFileToTar(tarw, name, local); err != nil {
+				return nil, fmt.Errorf("copy %s: %w", f, err)
+			}
+		}
+	}
+
+	config := derivedConfig(allFiles)
+	if report {
+		config.Report = ReportHTML
+	}
+	transforms := newTransforms(config, nil, nil)
+	tables, err := mergeTables(sources, tarw, transforms, stats)
+	if err != nil {
+		return nil, err
+	}
+	for _, tr := range transforms {
+		if err := tr.finish(tarw); err != nil {
+			return nil, err
+		}
+	}
+
+	index := map[string]any{"tables": tables}
+	var missing []string
+	present := map[string]bool{}
+	for _, t := range tables {
+		present[t] = true
+	}
+	info := map[string]any{"duplicateRows": stats.Duplicates}
+	var infoSources []map[string]any
+	for _, s := range sources {
+		var idx struct {
+			MissingTables []string `json:"missingTables"`
+		}
+		if data, err := s.b.ReadFile("index.json"); err == nil {
+			_ = json.Unmarshal(data, &idx)
+		}
+		for _, t := range idx.MissingTables {
+			if !present[t] {
+				present[t] = true
+				missing = append(missing, t)
+			}
+		}
+		src := map[string]any{"name": s.name, "path": s.path}
+		if s.incomplete {
+			// A table one source left incomplete may be whole in
+			// another, but which rows are missing cannot be told
+			index["incomplete"] = true
+			src["incomplete"] = true
+		}
+		infoSources = append(infoSources, src)
+	}
+	if len(missing) > 0 {
+		index["missingTables"] = missing
+	}
+	info["sources"] = infoSources
+	if len(stats.Conflicts) > 0 {
+		info["conflicts"] = stats.Conflicts
+	}
+	infob, _ := json.MarshalIndent(info, "", "  ")
+	if err := utils.WriteFileToTar(tarw, mergeInfoPath, infob); err != nil {
+		return nil, err
+	}
+	idxb, _ := json.MarshalIndent(index, "", "  ")
+	if err := utils.WriteFileToTar(tarw, "index.json", idxb); err != nil {
+		return nil, err
+	}
+	if err := writeToolInfo(tarw); err != nil {
+		return nil, err
+	}
+	if err := writeBundleInfo(tarw, bundle.NewInfo()); err != nil {
+		return nil, err
+	}
+	stats.Tables = len(tables)
+	return stats, nil
+}
+
+// mergeTables writes the tables of all sources to tarw, feeding their rows
+// to transforms, and returns the table names in the order of the sources'
+// indexes.
+func mergeTables(sources []*mergeSource, tarw *utils.TarWriter, transforms []transform, stats *MergeStats) ([]string, error) {
+	var order []string
+	dirs := map[string]string{}
+	parts := map[string][]mergePart{}
+	columns := map[string][]byte{}
+	infos := map[string][]mergeTableInfo{}
+	for i, s := range sources {
+		tables, err := s.b.Tables()
+		if err != nil {
+			return nil, fmt.Errorf("list tables of %s: %w", s.path, err)
+		}
+		for _, t := range tables {
+			if _, ok := dirs[t.Name]; !ok {
+				dirs[t.Name] = path.Base(t.Dir)
+				order = append(order, t.Name)
+			}
+			if _, ok := columns[t.Name]; !ok {
+				if data, err := s.b.ReadFile(t.Dir + "/columns.json"); err == nil {
+					columns[t.Name] = data
+				}
+			}
+			var info mergeTableInfo
+			if data, err := s.b.ReadFile(t.Dir + "/summary.json"); err == nil {
+				_ = json.Unmarshal(data, &info)
+			}
+			infos[t.Name] = append(infos[t.Name], info)
+			for _, p := range t.Parts {
+				start, end, ok := partWindow(p)
+				parts[t.Name] = append(parts[t.Name], mergePart{src: i, name: p, start: start, end: end, windowed: ok})
+			}
+		}
+	}
+
+	for _, table := range order {
+		dir := "tables/" + dirs[table]
+		rows, err := mergeTableParts(sources, table, dir, parts[table], tarw, transforms, stats)
+		if err != nil {
+			return nil, err
+		}
+		if data, ok := columns[table]; ok {
+			if err := utils.WriteFileToTar(tarw, dir+"/columns.json", data); err != nil {
+				return nil, err
+			}
+		}
+		var cov tableCoverage
+		incomplete := false
+		for _, info := range infos[table] {
+			if s := info.Coverage.Start; s != "" && (cov.Start == "" || timeBefore(s, cov.Start)) {
+				cov.Start = s
+			}
+			if e := info.Coverage.End; e != "" && (cov.End == "" || timeBefore(cov.End, e)) {
+				cov.End = e
+			}
+			incomplete = incomplete || info.Incomplete
+		}
+		sum := map[string]any{"table": table, "rows": rows, "coverage": cov}
+		if incomplete {
+			sum["incomplete"] = true
+		}
+		sumb, _ := json.MarshalIndent(sum, "", "  ")
+		if err := utils.WriteFileToTar(tarw, dir+"/summary.json", sumb); err != nil {
+			return nil, err
+		}
+	}
+	return order, nil
+}
+
+// mergeTableParts writes the parts of a table in time order under dir,
+// dropping the rows of overlapping chunks that another source already
+// wrote. It returns the number of rows written.
+func mergeTableParts(sources []*mergeSource, table, dir string, parts []mergePart, tarw *utils.TarWriter, transforms []transform, stats *MergeStats) (int64, error) {
+	sort.SliceStable(parts, func(i, j int) bool {
+		if !parts[i].start.Equal(parts[j].start) {
+			return parts[i].start.Before(parts[j].start)
+		}
+		if !parts[i].end.Equal(parts[j].end) {
+			return parts[i].end.Before(parts[j].end)
+		}
+		return parts[i].src < parts[j].src
+	})
+	// overlaps[i] are the windows of the parts of other sources overlapping
+	// part i; rows of parts without a window may be in any of them.
+	overlaps := make([][]mergePart, len(parts))
+	for i, p := range parts {
+		for _, q := range parts {
+			if q.src != p.src && (!p.windowed || !q.windowed || p.start.Before(q.end) && q.start.Before(p.end)) {
+				overlaps[i] = append(overlaps[i], q)
+			}
+		}
+	}
+	contested := func(i int, t time.Time) bool {
+		for _, q := range overlaps[i] {
+			if !parts[i].windowed || !q.windowed || t.IsZero() || !t.Before(q.start) && t.Before(q.end) {
+				return true
+			}
+		}
+		return false
+	}
+	// seen maps the digest of a contested row to the source that wrote it
+	seen := map[[32]byte]int{}
+	var rows int64
+	written := 0
+	for i, p := range parts {
+		s := sources[p.src]
+		f, err := os.Open(filepath.Join(s.b.Dir, filepath.FromSlash(p.name)))
+		if err != nil {
+			return rows, err
+		}
+		spool, err := utils.NewTarSpool()
+		if err != nil {
+			f.Close()
+			return rows, fmt.Errorf("spool part: %w", err)
+		}
+		n := 0
+		r := bufio.NewReader(f)
+		for {
+			line, rerr := r.ReadBytes('\n')
+			if line = bytes.TrimSpace(line); len(line) > 0 {
+				var row map[string]any
+				if err := json.Unmarshal(line, &row); err != nil {
+					f.Close()
+					spool.Close()
+					return rows, fmt.Errorf("decode %s of %s: %w", p.name, s.path, err)
+				}
+				keep := true
+				if contested(i, utils.ParseTimeRFC3339(toStr(row["TimeGenerated"]))) {
+					sum := sha256.Sum256(line)
+					if src, ok := seen[sum]; ok && src != p.src {
+						keep = false
+						stats.Duplicates++
+					} else if !ok {
+						seen[sum] = p.src
+					}
+				}
+				if keep {
+					if _, err := spool.Write(append(line, '\n')); err != nil {
+						f.Close()
+						spool.Close()
+						return rows, fmt.Errorf("write part: %w", err)
+					}
+					for _, tr := range transforms {
+						tr.observe(table, row)
+					}
+					n++
+				}
+			}
+			if rerr == io.EOF {
+				break
+			}
+			if rerr != nil {
+				f.Close()
+				spool.Close()
+				return rows, rerr
+			}
+		}
+		f.Close()
+		if n > 0 {
+			name := fmt.Sprintf("%s/parts/%04d-%s_%s.ndjson", dir, written, p.start.UTC().Format(time.RFC3339), p.end.UTC().Format(time.RFC3339))
+			if !p.windowed {
+				name = fmt.Sprintf("%s/parts/%04d-%s-%s", dir, written, s.name, path.Base(p.name))
+			}
+			if err := spool.WriteToTar(tarw, name); err != nil {
+				spool.Close()
+				return rows, fmt.Errorf("write part: %w", err)
+			}
+			written++
+			rows += int64(n)
+			stats.Parts++
+			stats.Rows += int64(n)
+		}
+		spool.Close()
+		for _, tr := range transforms {
+			if err := tr.endChunk(tarw); err != nil {
+				return rows, err
+			}
+		}
+	}
+	return rows, nil
+}
+
+// fileDigest returns the SHA-256 of the file at p.
+func fileDigest(p string) ([32]byte, error) {
+	var sum [32]byte
+	f, err := os.Open(p)
+	if err != nil {
+		return sum, err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return sum, err
+	}
+	copy(sum[:], h.Sum(nil))
+	return sum, nil
+}
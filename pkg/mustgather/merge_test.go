@@ -0,0 +1,104 @@
+package mustgather
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMerge(t *testing.T) {
+	event := func(ts, reason string) string {
+		return `{"TimeGenerated":"` + ts + `","Namespace":"shop","Name":"cart-1","ObjectKind":"Pod","Reason":"` + reason + `","Message":"m"}`
+	}
+	// Two incremental gathers whose windows overlap from 10:15 to 10:30
+	first := writeBundleDir(t, map[string]string{
+		"metadata/bundle.json":           `{"bundleFormatVersion":2}`,
+		"metadata/workspace.json":        `{"workspaceID":"ws-a"}`,
+		"index.json":                     `{"tables":["KubeEvents"],"missingTables":["Syslog"]}`,
+		"tables/KubeEvents/summary.json": `{"table":"KubeEvents","rows":3,"coverage":{"start":"2024-01-01T10:00:00Z","end":"2024-01-01T10:30:00Z"}}`,
+		"tables/KubeEvents/columns.json": `[{"name":"TimeGenerated","type":"datetime"}]`,
+		"tables/KubeEvents/parts/0000-2024-01-01T10:00:00Z_2024-01-01T10:15:00Z.ndjson": event("2024-01-01T10:05:00Z", "Scheduled") + "\n",
+		"tables/KubeEvents/parts/0001-2024-01-01T10:15:00Z_2024-01-01T10:30:00Z.ndjson": event("2024-01-01T10:20:00Z", "BackOff") + "\n" + event("2024-01-01T10:20:00Z", "BackOff") + "\n",
+		"namespaces/shop/events/events.log":                                             "stale\n",
+	})
+	second := writeBundleDir(t, map[string]string{
+		"metadata/bundle.json":           `{"bundleFormatVersion":2}`,
+		"metadata/workspace.json":        `{"workspaceID":"ws-b"}`,
+		"index.json":                     `{"tables":["KubeEvents","Heartbeat"],"incomplete":true}`,
+		"tables/KubeEvents/summary.json": `{"table":"KubeEvents","rows":3,"coverage":{"start":"2024-01-01T10:15:00Z","end":"2024-01-01T10:45:00Z"}}`,
+		"tables/KubeEvents/parts/0000-2024-01-01T10:15:00Z_2024-01-01T10:30:00Z.ndjson": event("2024-01-01T10:20:00Z", "BackOff") + "\n" + event("2024-01-01T10:25:00Z", "Killing") + "\n",
+		"tables/KubeEvents/parts/0001-2024-01-01T10:30:00Z_2024-01-01T10:45:00Z.ndjson": event("2024-01-01T10:40:00Z", "Started") + "\n",
+		"tables/Heartbeat/summary.json": `{"table":"Heartbeat","rows":1,"incomplete":true}`,
+		"tables/Heartbeat/parts/0000-2024-01-01T10:15:00Z_2024-01-01T10:30:00Z.ndjson": `{"TimeGenerated":"2024-01-01T10:16:00Z","Computer":"node-1"}` + "\n",
+	})
+	dst := filepath.Join(t.TempDir(), "merged.tar.gz")
+	stats, err := Merge([]string{first, second}, dst)
+	if err != nil {
+		t.Fatalf("Merge: %v", err)
+	}
+	if stats.Tables != 2 || stats.Parts != 5 || stats.Rows != 6 || stats.Duplicates != 1 {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+	if strings.Join(stats.Conflicts, ",") != "metadata/workspace.json" {
+		t.Errorf("unexpected conflicts: %v", stats.Conflicts)
+	}
+
+	files := readArchive(t, dst)
+	// Both sources are directories named bundle; the second is bundle-2
+	if files["metadata/workspace.json"] != `{"workspaceID":"ws-a"}` || files["merged/bundle-2/metadata/workspace.json"] != `{"workspaceID":"ws-b"}` {
+		t.Errorf("expected the first source's metadata at its path and the second's under merged/")
+	}
+	var parts []string
+	for name := range files {
+		if strings.HasPrefix(name, "tables/KubeEvents/parts/") {
+			parts = append(parts, strings.TrimPrefix(name, "tables/KubeEvents/parts/"))
+		}
+	}
+	if len(parts) != 4 {
+		t.Fatalf("expected 4 KubeEvents parts, got %v", parts)
+	}
+	// Both BackOff rows of the first source are kept, the second's copy is
+	// dropped
+	overlap := files["tables/KubeEvents/parts/0002-2024-01-01T10:15:00Z_2024-01-01T10:30:00Z.ndjson"]
+	if strings.Count(overlap, "BackOff") != 0 || !strings.Contains(overlap, "Killing") {
+		t.Errorf("unexpected overlapping part of the second source: %q", overlap)
+	}
+	if got := files["tables/KubeEvents/parts/0001-2024-01-01T10:15:00Z_2024-01-01T10:30:00Z.ndjson"]; strings.Count(got, "BackOff") != 2 {
+		t.Errorf("unexpected overlapping part of the first source: %q", got)
+	}
+
+	var sum struct {
+		Rows       int           `json:"rows"`
+		Coverage   tableCoverage `json:"coverage"`
+		Incomplete bool          `json:"incomplete"`
+	}
+	_ = json.Unmarshal([]byte(files["tables/KubeEvents/summary.json"]), &sum)
+	if sum.Rows != 5 || sum.Coverage.Start != "2024-01-01T10:00:00Z" || sum.Coverage.End != "2024-01-01T10:45:00Z" || sum.Incomplete {
+		t.Errorf("unexpected KubeEvents summary: %s", files["tables/KubeEvents/summary.json"])
+	}
+	if files["tables/KubeEvents/columns.json"] == "" || !strings.Contains(files["tables/Heartbeat/summary.json"], `"incomplete": true`) {
+		t.Error("expected columns.json and the incomplete Heartbeat summary")
+	}
+
+	var idx struct {
+		Tables        []string `json:"tables"`
+		MissingTables []string `json:"missingTables"`
+		Incomplete    bool     `json:"incomplete"`
+	}
+	_ = json.Unmarshal([]byte(files["index.json"]), &idx)
+	if strings.Join(idx.Tables, ",") != "KubeEvents,Heartbeat" || strings.Join(idx.MissingTables, ",") != "Syslog" || !idx.Incomplete {
+		t.Errorf("unexpected index.json: %s", files["index.json"])
+	}
+	if !strings.Contains(files[mergeInfoPath], `"duplicateRows": 1`) {
+		t.Errorf("unexpected %s: %s", mergeInfoPath, files[mergeInfoPath])
+	}
+	events := files["namespaces/shop/events/events.log"]
+	if strings.Contains(events, "stale") || strings.Count(events, "\n") != 5 {
+		t.Errorf("expected the events log to be regenerated from the merged rows, got %q", events)
+	}
+
+	if _, err := Merge([]string{first}, dst); err == nil {
+		t.Error("expected an error for a single bundle")
+	}
+}
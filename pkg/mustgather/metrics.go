@@ -0,0 +1,144 @@
+package mustgather
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// metricsDurationBuckets are the upper bounds, in seconds, of the gather
+// duration histogram: from small scoped gathers to long catch-up windows.
+var metricsDurationBuckets = []float64{10, 30, 60, 120, 300, 600, 1800, 3600, 7200}
+
+// Metrics accumulates the outcome of the gathers of a long-running mode,
+// --watch, the controller or the alert listener, and serves them in the
+// Prometheus text format so the gather pipeline can be monitored and alerted
+// on. One Metrics is shared by the gathers through their Config.
+type Metrics struct {
+	mu          sync.Mutex
+	running     int
+	gathers     map[string]int64
+	buckets     []int64
+	durationSum float64
+	rows        int64
+	bytes       int64
+	queries     int64
+	queryErrors int64
+	throttled   int64
+	lastSuccess time.Time
+}
+
+// Gather outcomes, the outcome label of aks_must_gather_gathers_total.
+const (
+	outcomeSucceeded  = "succeeded"
+	outcomeFailed     = "failed"
+	outcomeIncomplete = "incomplete"
+)
+
+// NewMetrics returns empty metrics.
+func NewMetrics() *Metrics {
+	return &Metrics{
+		gathers: map[string]int64{outcomeSucceeded: 0, outcomeFailed: 0, outcomeIncomplete: 0},
+		buckets: make([]int64, len(metricsDurationBuckets)),
+	}
+}
+
+// started records a gather that began.
+func (m *Metrics) started() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.running++
+}
+
+// observe records the outcome of the gather g that ended with err.
+func (m *Metrics) observe(g *Gatherer, err error) {
+	outcome := outcomeSucceeded
+	switch {
+	case g.incomplete():
+		outcome = outcomeIncomplete
+	case err != nil:
+		outcome = outcomeFailed
+	}
+	took := time.Since(g.startedAt).Seconds()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.running--
+	m.gathers[outcome]++
+	m.durationSum += took
+	for i, le := range metricsDurationBuckets {
+		if took <= le {
+			m.buckets[i]++
+		}
+	}
+	for _, o := range g.outcomes {
+		m.rows += int64(o.rows)
+		m.bytes += o.bytes
+	}
+	if g.usage != nil {
+		m.queries += g.usage.queries.Load()
+		m.queryErrors += g.usage.failed.Load()
+		m.throttled += g.usage.retries.Load()
+	}
+	if outcome == outcomeSucceeded {
+		m.lastSuccess = time.Now()
+	}
+}
+
+// Handler serves the metrics in the Prometheus text exposition format.
+func (m *Metrics) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		m.write(w)
+	})
+}
+
+func (m *Metrics) write(w io.Writer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	metric := func(name, typ, help string) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+	}
+
+	metric("aks_must_gather_gathers_total", "counter", "Gathers that ended, by outcome.")
+	outcomes := make([]string, 0, len(m.gathers))
+	var count int64
+	for o, n := range m.gathers {
+		outcomes = append(outcomes, o)
+		count += n
+	}
+	sort.Strings(outcomes)
+	for _, o := range outcomes {
+		fmt.Fprintf(w, "aks_must_gather_gathers_total{outcome=%q} %d\n", o, m.gathers[o])
+	}
+	metric("aks_must_gather_gathers_running", "gauge", "Gathers in progress.")
+	fmt.Fprintf(w, "aks_must_gather_gathers_running %d\n", m.running)
+
+	metric("aks_must_gather_gather_duration_seconds", "histogram", "Duration of the gathers that ended.")
+	for i, le := range metricsDurationBuckets {
+		fmt.Fprintf(w, "aks_must_gather_gather_duration_seconds_bucket{le=\"%g\"} %d\n", le, m.buckets[i])
+	}
+	fmt.Fprintf(w, "aks_must_gather_gather_duration_seconds_bucket{le=\"+Inf\"} %d\n", count)
+	fmt.Fprintf(w, "aks_must_gather_gather_duration_seconds_sum %g\n", m.durationSum)
+	fmt.Fprintf(w, "aks_must_gather_gather_duration_seconds_count %d\n", count)
+
+	metric("aks_must_gather_rows_exported_total", "counter", "Table rows written to archives.")
+	fmt.Fprintf(w, "aks_must_gather_rows_exported_total %d\n", m.rows)
+	metric("aks_must_gather_bytes_exported_total", "counter", "Bytes of table rows written to archives.")
+	fmt.Fprintf(w, "aks_must_gather_bytes_exported_total %d\n", m.bytes)
+	metric("aks_must_gather_queries_total", "counter", "Workspace queries sent, retries included.")
+	fmt.Fprintf(w, "aks_must_gather_queries_total %d\n", m.queries)
+	metric("aks_must_gather_query_errors_total", "counter", "Workspace queries that failed after their retries.")
+	fmt.Fprintf(w, "aks_must_gather_query_errors_total %d\n", m.queryErrors)
+	metric("aks_must_gather_throttled_queries_total", "counter", "Workspace queries throttled (HTTP 429/503) and retried.")
+	fmt.Fprintf(w, "aks_must_gather_throttled_queries_total %d\n", m.throttled)
+
+	metric("aks_must_gather_last_success_timestamp_seconds", "gauge", "Unix time the last successful gather ended, 0 before the first.")
+	var last int64
+	if !m.lastSuccess.IsZero() {
+		last = m.lastSuccess.Unix()
+	}
+	fmt.Fprintf(w, "aks_must_gather_last_success_timestamp_seconds %d\n", last)
+}
@@ -0,0 +1,72 @@
+package mustgather
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMetrics(t *testing.T) {
+	m := NewMetrics()
+	ok := &Gatherer{
+		ctx:       context.Background(),
+		startedAt: time.Now().Add(-45 * time.Second),
+		outcomes:  []tableOutcome{{table: "Heartbeat", rows: 3, bytes: 300}, {table: "KubeEvents", rows: 2, bytes: 200}},
+		usage:     &usageTracker{},
+	}
+	ok.usage.queries.Add(4)
+	ok.usage.retries.Add(1)
+	m.started()
+	m.observe(ok, nil)
+
+	failed := &Gatherer{ctx: context.Background(), startedAt: time.Now().Add(-5 * time.Second), usage: &usageTracker{}}
+	failed.usage.queries.Add(2)
+	failed.usage.failed.Add(1)
+	m.started()
+	m.observe(failed, errors.New("boom"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	m.started()
+	m.observe(&Gatherer{ctx: ctx, startedAt: time.Now()}, ctx.Err())
+	m.started()
+
+	w := httptest.NewRecorder()
+	m.Handler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("Content-Type = %s", ct)
+	}
+	body := w.Body.String()
+	for _, want := range []string{
+		"# TYPE aks_must_gather_gathers_total counter\n",
+		`aks_must_gather_gathers_total{outcome="failed"} 1` + "\n",
+		`aks_must_gather_gathers_total{outcome="incomplete"} 1` + "\n",
+		`aks_must_gather_gathers_total{outcome="succeeded"} 1` + "\n",
+		"aks_must_gather_gathers_running 1\n",
+		`aks_must_gather_gather_duration_seconds_bucket{le="10"} 2` + "\n",
+		`aks_must_gather_gather_duration_seconds_bucket{le="60"} 3` + "\n",
+		`aks_must_gather_gather_duration_seconds_bucket{le="+Inf"} 3` + "\n",
+		"aks_must_gather_gather_duration_seconds_count 3\n",
+		"aks_must_gather_rows_exported_total 5\n",
+		"aks_must_gather_bytes_exported_total 500\n",
+		"aks_must_gather_queries_total 6\n",
+		"aks_must_gather_query_errors_total 1\n",
+		"aks_must_gather_throttled_queries_total 1\n",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("metrics lack %q:\n%s", want, body)
+		}
+	}
+	if strings.Contains(body, "aks_must_gather_last_success_timestamp_seconds 0\n") {
+		t.Error("the last success time was not recorded")
+	}
+
+	w = httptest.NewRecorder()
+	NewMetrics().Handler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	if !strings.Contains(w.Body.String(), "aks_must_gather_last_success_timestamp_seconds 0\n") {
+		t.Errorf("empty metrics:\n%s", w.Body)
+	}
+}
@@ -0,0 +1,138 @@
+package mustgather
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"kubectl-must-gather/pkg/bundle"
+	"kubectl-must-gather/pkg/utils"
+	"kubectl-must-gather/pkg/version"
+)
+
+// derivedPrefixes are archive paths generated from table rows by transforms.
+// Migration drops them from the source bundle and regenerates them, so the
+// result matches what the current version would have written.
+var derivedPrefixes = []string{"namespaces/", "containers/", "controlplane/", "audit/", "nodes/", clusterScopedDir + "/", findingsPath, eventAnomaliesPath, nodeConditionsPath, utilizationDir + "/", terminationsPath, errorHeatmapPath}
+
+// Migrate upgrades the bundle at src to the current bundle format and writes
+// it to dst. Exported table data is kept as is; derived files (stitched logs,
+// audit logs) are regenerated from it.
+func Migrate(src, dst string) error {
+	b, err := bundle.Open(src)
+	if err != nil {
+		return fmt.Errorf("open bundle: %w", err)
+	}
+	defer b.Close()
+	from := b.Version()
+	if from == bundle.FormatVersion {
+		return fmt.Errorf("%s is already at bundle format version %d", src, from)
+	}
+
+	files, err := b.Files()
+	if err != nil {
+		return fmt.Errorf("list bundle files: %w", err)
+	}
+
+	// Keep the stitching and event list choices the bundle was gathered with
+	config := derivedConfig(files)
+
+	outF, err := os.Create(dst)
+	if err != nil {
+		return fmt.Errorf("create out: %w", err)
+	}
+	defer outF.Close()
+	gz := gzip.NewWriter(outF)
+	defer gz.Close()
+	tarw := utils.NewTarWriter(gz, time.Now())
+	defer tarw.Close()
+
+	for _, f := range files {
+		if f == bundle.InfoPath || isDerived(f) {
+			continue
+		}
+		if err := utils.WriteLocalFileToTar(tarw, f, filepath.Join(b.Dir, filepath.FromSlash(f))); err != nil {
+			return fmt.Errorf("copy %s: %w", f, err)
+		}
+	}
+
+	tables, err := b.Tables()
+	if err != nil {
+		return fmt.Errorf("list tables: %w", err)
+	}
+	transforms := newTransforms(config, nil, nil)
+	for _, t := range tables {
+		for _, part := range t.Parts {
+			err := b.ReadPart(part, func(row map[string]any) error {
+				for _, tr := range transforms {
+					tr.observe(t.Name, row)
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			for _, tr := range transforms {
+				if err := tr.endChunk(tarw); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	for _, tr := range transforms {
+		if err := tr.finish(tarw); err != nil {
+			return err
+		}
+	}
+
+	info := bundle.NewInfo()
+	info.MigratedFrom = &from
+	return writeBundleInfo(tarw, info)
+}
+
+// derivedConfig returns the configuration of the transforms that wrote the
+// derived files among files: whether logs were stitched, with events, and
+// the event list format.
+func derivedConfig(files []string) *Config {
+	config := &Config{}
+	for _, f := range files {
+		switch {
+		case strings.HasSuffix(f, "/events/"+eventObjectsFile(EventObjectsJSON)):
+			config.EventObjects = EventObjectsJSON
+		case strings.HasSuffix(f, "/events/"+eventObjectsFile(EventObjectsYAML)):
+			config.EventObjects = EventObjectsYAML
+		case strings.HasPrefix(f, "namespaces/"):
+			config.StitchLogs = true
+			if strings.Contains(f, "/events/") {
+				config.StitchIncludeEvents = true
+			}
+		}
+	}
+	return config
+}
+
+func isDerived(name string) bool {
+	for _, p := range derivedPrefixes {
+		if strings.HasPrefix(name, p) {
+			return true
+		}
+	}
+	return false
+}
+
+func writeBundleInfo(tarw *utils.TarWriter, info bundle.Info) error {
+	b, _ := json.MarshalIndent(info, "", "  ")
+	return utils.WriteFileToTar(tarw, bundle.InfoPath, b)
+}
+
+// toolInfoPath records the build of the tool that wrote an archive.
+const toolInfoPath = "metadata/tool.json"
+
+func writeToolInfo(tarw *utils.TarWriter) error {
+	b, _ := json.MarshalIndent(version.Get(), "", "  ")
+	return utils.WriteFileToTar(tarw, toolInfoPath, b)
+}
@@ -0,0 +1,104 @@
+package mustgather
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"kubectl-must-gather/pkg/bundle"
+)
+
+// readArchive returns path -> content for every file in a .tar.gz.
+func readArchive(t *testing.T, name string) map[string]string {
+	t.Helper()
+	f, err := os.Open(name)
+	if err != nil {
+		t.Fatalf("open archive: %v", err)
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatalf("gzip: %v", err)
+	}
+	out := map[string]string{}
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return out
+		}
+		if err != nil {
+			t.Fatalf("read tar: %v", err)
+		}
+		if hdr.Typeflag == tar.TypeDir {
+			continue
+		}
+		b, _ := io.ReadAll(tr)
+		out[hdr.Name] = string(b)
+	}
+}
+
+func TestMigrateV0Bundle(t *testing.T) {
+	// A version 0 bundle: no bundle.json, no control plane or audit logs
+	src := filepath.Join(t.TempDir(), "old.tar.gz")
+	f, _ := os.Create(src)
+	gz := gzip.NewWriter(f)
+	tw := tar.NewWriter(gz)
+	write := func(p, c string) {
+		_ = tw.WriteHeader(&tar.Header{Name: p, Mode: 0644, Size: int64(len(c)), Typeflag: tar.TypeReg})
+		_, _ = tw.Write([]byte(c))
+	}
+	write("index.json", `{"tables":["AKSControlPlane","KubeEvents","AKSAudit"]}`)
+	write("metadata/workspace.json", `{"timespan":"PT2H"}`)
+	write("tables/AKSControlPlane/summary.json", `{"table":"AKSControlPlane","rows":1}`)
+	write("tables/AKSControlPlane/parts/0000-a.ndjson", `{"TimeGenerated":"2024-01-01T00:00:00Z","Category":"kube-apiserver","Message":"up"}`+"\n")
+	write("tables/KubeEvents/parts/0000-a.ndjson", `{"TimeGenerated":"2024-01-01T00:00:00Z","Namespace":"shop","Name":"cart-1","ObjectKind":"Pod","Reason":"Killing","Message":"stop"}`+"\n")
+	write("tables/AKSAudit/parts/0000-a.ndjson", `{"AuditId":"a1","Verb":"get","User":"{\"username\":\"admin\"}"}`+"\n")
+	write("namespaces/shop/events/events.log", "stale\n")
+	write("namespaces/shop/events/events.yaml", "stale\n")
+	tw.Close()
+	gz.Close()
+	f.Close()
+
+	dst := filepath.Join(t.TempDir(), "new.tar.gz")
+	if err := Migrate(src, dst); err != nil {
+		t.Fatalf("Migrate failed: %v", err)
+	}
+	files := readArchive(t, dst)
+
+	var info bundle.Info
+	if err := json.Unmarshal([]byte(files[bundle.InfoPath]), &info); err != nil {
+		t.Fatalf("missing or invalid %s: %v", bundle.InfoPath, err)
+	}
+	if info.BundleFormatVersion != bundle.FormatVersion || info.MigratedFrom == nil || *info.MigratedFrom != 0 {
+		t.Errorf("unexpected bundle info: %+v", info)
+	}
+	if files["metadata/workspace.json"] != `{"timespan":"PT2H"}` {
+		t.Error("expected metadata to be copied unchanged")
+	}
+	if got := files["controlplane/kube-apiserver/kube-apiserver.log"]; got != "2024-01-01T00:00:00Z [-] up\n" {
+		t.Errorf("unexpected regenerated control plane log: %q", got)
+	}
+	if got := files["namespaces/shop/events/events.log"]; strings.Contains(got, "stale") || !strings.Contains(got, "Killing") {
+		t.Errorf("expected events log to be regenerated, got %q", got)
+	}
+	if got := files["namespaces/shop/events/events.yaml"]; !strings.Contains(got, "kind: EventList") || !strings.Contains(got, "Killing") {
+		t.Errorf("expected event list to be regenerated, got %q", got)
+	}
+	if _, ok := files["namespaces/shop/pods/cart-1/events.log"]; !ok {
+		t.Error("expected pod events log to be added")
+	}
+	if got := files["audit/kube-apiserver/audit-0000.log"]; !strings.Contains(got, `"username":"admin"`) {
+		t.Errorf("expected audit log to be generated, got %q", got)
+	}
+
+	// Migrating a current bundle is refused
+	if err := Migrate(dst, filepath.Join(t.TempDir(), "again.tar.gz")); err == nil || !strings.Contains(err.Error(), "already at bundle format version") {
+		t.Errorf("expected already-current error, got %v", err)
+	}
+}
@@ -0,0 +1,339 @@
+package mustgather
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"kubectl-must-gather/pkg/utils"
+)
+
+// nodeConditionsPath is where the node condition timeline is written.
+const nodeConditionsPath = "analysis/node-conditions.md"
+
+// nodeChartWidth is the number of columns of the per-node charts.
+const nodeChartWidth = 60
+
+// nodeConditionTypes are the charted conditions, in chart order.
+var nodeConditionTypes = []string{"Ready", "MemoryPressure", "DiskPressure", "PIDPressure"}
+
+// nodeConditionEvents maps node event reasons to the condition they set.
+var nodeConditionEvents = map[string]struct {
+	condition string
+	status    bool
+}{
+	"NodeReady":                 {"Ready", true},
+	"NodeNotReady":              {"Ready", false},
+	"NodeHasInsufficientMemory": {"MemoryPressure", true},
+	"NodeHasSufficientMemory":   {"MemoryPressure", false},
+	"NodeHasDiskPressure":       {"DiskPressure", true},
+	"NodeHasNoDiskPressure":     {"DiskPressure", false},
+	"NodeHasInsufficientPID":    {"PIDPressure", true},
+	"NodeHasSufficientPID":      {"PIDPressure", false},
+}
+
+// nodeConditions writes analysis/node-conditions.md: per node, a chart of its
+// Ready and pressure conditions over the window, the transitions behind it
+// and the evictions on the node. Conditions come from the KubeNodeInventory
+// Status of every snapshot and from the kubelet's node condition events;
+// evictions are pod Evicted events reported by the node's kubelet and the
+// node's EvictionThresholdMet events.
+type nodeConditions struct {
+	nodes map[string]*nodeTimeline
+	// first and last bound all observations, for a shared chart scale.
+	first, last time.Time
+}
+
+type nodeTimeline struct {
+	observations map[string][]conditionObservation
+	evictions    []nodeEviction
+}
+
+type conditionObservation struct {
+	tm     time.Time
+	status bool
+	source string
+}
+
+type nodeEviction struct {
+	tm             time.Time
+	object, reason string
+	message        string
+}
+
+// conditionTransition is a change of a condition's status.
+type conditionTransition struct {
+	tm        time.Time
+	condition string
+	from, to  string
+	source    string
+}
+
+func newNodeConditions() *nodeConditions {
+	return &nodeConditions{nodes: map[string]*nodeTimeline{}}
+}
+
+func (n *nodeConditions) node(name string) *nodeTimeline {
+	t, ok := n.nodes[name]
+	if !ok {
+		t = &nodeTimeline{observations: map[string][]conditionObservation{}}
+		n.nodes[name] = t
+	}
+	return t
+}
+
+func (n *nodeConditions) seen(tm time.Time) {
+	if n.first.IsZero() || tm.Before(n.first) {
+		n.first = tm
+	}
+	if tm.After(n.last) {
+		n.last = tm
+	}
+}
+
+func (n *nodeConditions) observe(table string, row map[string]any) {
+	tm := utils.ParseTimeRFC3339(toStr(row["TimeGenerated"]))
+	if tm.IsZero() {
+		return
+	}
+	switch table {
+	case "KubeNodeInventory":
+		name := toStr(row["Computer"])
+		if name == "" {
+			return
+		}
+		n.seen(tm)
+		// Status lists the node's true conditions; pressure conditions that
+		// are not listed are false
+		conds := map[string]bool{}
+		for _, c := range strings.FieldsFunc(toStr(row["Status"]), func(r rune) bool { return r == ',' || r == ' ' }) {
+			conds[c] = true
+		}
+		t := n.node(name)
+		for _, c := range nodeConditionTypes {
+			status := conds[c]
+			if c == "Ready" {
+				status = conds["Ready"] && !conds["NotReady"]
+			}
+			t.observations[c] = append(t.observations[c], conditionObservation{tm: tm, status: status, source: "inventory"})
+		}
+	case "KubeEvents":
+		reason := toStr(row["Reason"])
+		if strings.EqualFold(toStr(row["ObjectKind"]), "Node") {
+			name := toStr(row["Name"])
+			if name == "" {
+				return
+			}
+			if ev, ok := nodeConditionEvents[reason]; ok {
+				n.seen(tm)
+				t := n.node(name)
+				t.observations[ev.condition] = append(t.observations[ev.condition], conditionObservation{tm: tm, status: ev.status, source: "event " + reason})
+			} else if reason == "EvictionThresholdMet" {
+				n.seen(tm)
+				t := n.node(name)
+				t.evictions = append(t.evictions, nodeEviction{tm: tm, object: "Node/" + name, reason: reason, message: toStr(row["Message"])})
+			}
+			return
+		}
+		if reason == "Evicted" {
+			name := toStr(row["SourceComputer"])
+			if name == "" {
+				return
+			}
+			n.seen(tm)
+			t := n.node(name)
+			obj := toStr(row["Namespace"]) + "/" + toStr(row["Name"])
+			t.evictions = append(t.evictions, nodeEviction{tm: tm, object: "Pod " + obj, reason: reason, message: toStr(row["Message"])})
+		}
+	}
+}
+
+func (n *nodeConditions) endChunk(tarw *utils.TarWriter) error {
+	return nil
+}
+
+func (n *nodeConditions) warnings(table string) []string {
+	return nil
+}
+
+func (n *nodeConditions) finish(tarw *utils.TarWriter) error {
+	return utils.WriteFileToTar(tarw, nodeConditionsPath, []byte(n.render()))
+}
+
+// transitions returns the condition changes of a node in time order. The
+// first observation of each condition is reported as a change from unknown.
+func (t *nodeTimeline) transitions() []conditionTransition {
+	var out []conditionTransition
+	for _, c := range nodeConditionTypes {
+		obs := t.sorted(c)
+		prev := "Unknown"
+		for _, o := range obs {
+			if s := conditionStatus(o.status); s != prev {
+				out = append(out, conditionTransition{tm: o.tm, condition: c, from: prev, to: s, source: o.source})
+				prev = s
+			}
+		}
+	}
+	sort.SliceStable(out, func(i, j int) bool { return out[i].tm.Before(out[j].tm) })
+	return out
+}
+
+// changes drops the first observations of conditions that start out
+// healthy, which are not news.
+func (t *nodeTimeline) changes() []conditionTransition {
+	var out []conditionTransition
+	for _, tr := range t.transitions() {
+		if tr.from != "Unknown" || conditionProblem(tr.condition, tr.to == "True") {
+			out = append(out, tr)
+		}
+	}
+	return out
+}
+
+// sorted returns the observations of condition in time order. When the
+// inventory never listed a pressure condition the kubelet has events for,
+// the inventory is taken not to report it, and only the events count.
+func (t *nodeTimeline) sorted(condition string) []conditionObservation {
+	obs := t.observations[condition]
+	if condition != "Ready" {
+		listed, events := false, false
+		for _, o := range obs {
+			if o.source == "inventory" {
+				listed = listed || o.status
+			} else {
+				events = true
+			}
+		}
+		if events && !listed {
+			var kept []conditionObservation
+			for _, o := range obs {
+				if o.source != "inventory" {
+					kept = append(kept, o)
+				}
+			}
+			obs = kept
+		}
+	}
+	sort.SliceStable(obs, func(i, j int) bool { return obs[i].tm.Before(obs[j].tm) })
+	return obs
+}
+
+func conditionStatus(b bool) string {
+	if b {
+		return "True"
+	}
+	return "False"
+}
+
+// conditionProblem reports whether status of condition is unhealthy.
+func conditionProblem(condition string, status bool) bool {
+	if condition == "Ready" {
+		return !status
+	}
+	return status
+}
+
+// chart renders one line per condition over [n.first, n.last]: '#' where the
+// condition is unhealthy (not Ready, or under pressure), '.' where it is
+// healthy and ' ' before it was first observed. A last line marks evictions
+// with 'E'.
+func (n *nodeConditions) chart(t *nodeTimeline) string {
+	span := n.last.Sub(n.first)
+	col := func(tm time.Time) int {
+		if span <= 0 {
+			return 0
+		}
+		c := int(tm.Sub(n.first) * time.Duration(nodeChartWidth-1) / span)
+		return min(max(c, 0), nodeChartWidth-1)
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "%-16s %s%s%s\n", "", n.first.Format("15:04"), strings.Repeat(" ", max(nodeChartWidth-10, 1)), n.last.Format("15:04"))
+	for _, c := range nodeConditionTypes {
+		cells := []byte(strings.Repeat(" ", nodeChartWidth))
+		obs := t.sorted(c)
+		for i, o := range obs {
+			end := nodeChartWidth
+			if i+1 < len(obs) {
+				end = col(obs[i+1].tm)
+			}
+			ch := byte('.')
+			if conditionProblem(c, o.status) {
+				ch = '#'
+			}
+			for x := col(o.tm); x < end; x++ {
+				cells[x] = ch
+			}
+			// A short unhealthy spell still shows up
+			if ch == '#' {
+				cells[col(o.tm)] = ch
+			}
+		}
+		fmt.Fprintf(&b, "%-16s %s\n", c, cells)
+	}
+	if len(t.evictions) > 0 {
+		cells := []byte(strings.Repeat(" ", nodeChartWidth))
+		for _, e := range t.evictions {
+			cells[col(e.tm)] = 'E'
+		}
+		fmt.Fprintf(&b, "%-16s %s\n", "Evictions", cells)
+	}
+	return b.String()
+}
+
+func (n *nodeConditions) render() string {
+	var b strings.Builder
+	b.WriteString("# Node conditions\n\n")
+	if len(n.nodes) == 0 {
+		b.WriteString("No KubeNodeInventory rows or node condition events were gathered.\n")
+		return b.String()
+	}
+	fmt.Fprintf(&b, "Window: %s to %s. In the charts `#` marks NotReady or a pressure condition, `.` healthy, blank not yet observed, and `E` evictions.\n\n",
+		n.first.Format(time.RFC3339), n.last.Format(time.RFC3339))
+
+	names := make([]string, 0, len(n.nodes))
+	for name := range n.nodes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	b.WriteString("| Node | Unhealthy conditions | Transitions | Evictions |\n|---|---|---|---|\n")
+	for _, name := range names {
+		t := n.nodes[name]
+		var bad []string
+		for _, c := range nodeConditionTypes {
+			for _, o := range t.sorted(c) {
+				if conditionProblem(c, o.status) {
+					label := c
+					if c == "Ready" {
+						label = "NotReady"
+					}
+					bad = append(bad, label)
+					break
+				}
+			}
+		}
+		fmt.Fprintf(&b, "| %s | %s | %d | %d |\n", name, orNone(strings.Join(bad, ", ")), len(t.changes()), len(t.evictions))
+	}
+
+	for _, name := range names {
+		t := n.nodes[name]
+		fmt.Fprintf(&b, "\n## %s\n\n```\n%s```\n", name, n.chart(t))
+		if changes := t.changes(); len(changes) > 0 {
+			b.WriteString("\n| Time | Condition | Change | Source |\n|---|---|---|---|\n")
+			for _, tr := range changes {
+				fmt.Fprintf(&b, "| %s | %s | %s → %s | %s |\n", tr.tm.Format(time.RFC3339), tr.condition, tr.from, tr.to, tr.source)
+			}
+		} else {
+			b.WriteString("\nNo condition changes over the window.\n")
+		}
+		if len(t.evictions) > 0 {
+			sort.SliceStable(t.evictions, func(i, j int) bool { return t.evictions[i].tm.Before(t.evictions[j].tm) })
+			b.WriteString("\n| Time | Eviction | Reason | Message |\n|---|---|---|---|\n")
+			for _, e := range t.evictions {
+				fmt.Fprintf(&b, "| %s | %s | %s | %s |\n", e.tm.Format(time.RFC3339), e.object, e.reason, strings.ReplaceAll(truncateSample(e.message), "|", "\\|"))
+			}
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,99 @@
+package mustgather
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNodeConditionsTimeline(t *testing.T) {
+	n := newNodeConditions()
+	for _, row := range []map[string]any{
+		{"TimeGenerated": "2024-01-01T00:00:00Z", "Computer": "aks-node-1", "Status": "Ready"},
+		{"TimeGenerated": "2024-01-01T00:10:00Z", "Computer": "aks-node-1", "Status": "Ready"},
+		{"TimeGenerated": "2024-01-01T00:20:00Z", "Computer": "aks-node-1", "Status": "NotReady"},
+		{"TimeGenerated": "2024-01-01T00:30:00Z", "Computer": "aks-node-1", "Status": "Ready"},
+		{"TimeGenerated": "2024-01-01T00:00:00Z", "Computer": "aks-node-2", "Status": "Ready"},
+		{"TimeGenerated": "2024-01-01T01:00:00Z", "Computer": "aks-node-2", "Status": "Ready"},
+	} {
+		n.observe("KubeNodeInventory", row)
+	}
+	for _, row := range []map[string]any{
+		{"TimeGenerated": "2024-01-01T00:15:00Z", "ObjectKind": "Node", "Name": "aks-node-1", "Reason": "NodeHasInsufficientMemory"},
+		{"TimeGenerated": "2024-01-01T00:16:00Z", "ObjectKind": "Node", "Name": "aks-node-1", "Reason": "EvictionThresholdMet", "Message": "Attempting to reclaim memory"},
+		{"TimeGenerated": "2024-01-01T00:17:00Z", "ObjectKind": "Pod", "Namespace": "shop", "Name": "cache-1", "Reason": "Evicted",
+			"SourceComputer": "aks-node-1", "Message": "The node was low on resource: memory."},
+		{"TimeGenerated": "2024-01-01T00:25:00Z", "ObjectKind": "Node", "Name": "aks-node-1", "Reason": "NodeHasSufficientMemory"},
+		// Not a node condition
+		{"TimeGenerated": "2024-01-01T00:25:00Z", "ObjectKind": "Node", "Name": "aks-node-1", "Reason": "RegisteredNode"},
+	} {
+		n.observe("KubeEvents", row)
+	}
+
+	got := readTransform(t, n)[nodeConditionsPath]
+	for _, want := range []string{
+		"Window: 2024-01-01T00:00:00Z to 2024-01-01T01:00:00Z.",
+		"| aks-node-1 | NotReady, MemoryPressure | 4 | 2 |\n",
+		"| aks-node-2 | - | 0 | 0 |\n",
+		"| 2024-01-01T00:15:00Z | MemoryPressure | Unknown → True | event NodeHasInsufficientMemory |\n",
+		"| 2024-01-01T00:20:00Z | Ready | True → False | inventory |\n",
+		"| 2024-01-01T00:30:00Z | Ready | False → True | inventory |\n",
+		"| 2024-01-01T00:17:00Z | Pod shop/cache-1 | Evicted | The node was low on resource: memory. |\n",
+		"| 2024-01-01T00:16:00Z | Node/aks-node-1 | EvictionThresholdMet |",
+		"## aks-node-2\n",
+		"No condition changes over the window.",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("node-conditions.md missing %q:\n%s", want, got)
+		}
+	}
+
+	// aks-node-1 is NotReady from 00:20 to 00:30 of the hour: columns 19-28
+	chart := n.chart(n.nodes["aks-node-1"])
+	var ready, memory, evictions string
+	for _, line := range strings.Split(chart, "\n") {
+		switch {
+		case strings.HasPrefix(line, "Ready "):
+			ready = line[17:]
+		case strings.HasPrefix(line, "MemoryPressure "):
+			memory = line[17:]
+		case strings.HasPrefix(line, "Evictions "):
+			evictions = line[17:]
+		}
+	}
+	if want := strings.Repeat(".", 19) + strings.Repeat("#", 10) + strings.Repeat(".", 31); ready != want {
+		t.Errorf("Ready chart\n got %q\nwant %q", ready, want)
+	}
+	// Known from the first event on, as the inventory does not list pressure
+	if !strings.HasPrefix(memory, strings.Repeat(" ", 14)+"#") || strings.Count(memory, "#") != 10 {
+		t.Errorf("MemoryPressure chart %q", memory)
+	}
+	if strings.Count(evictions, "E") != 2 {
+		t.Errorf("Evictions chart %q", evictions)
+	}
+}
+
+func TestNodeConditionsInventoryPressure(t *testing.T) {
+	n := newNodeConditions()
+	for _, row := range []map[string]any{
+		{"TimeGenerated": "2024-01-01T00:00:00Z", "Computer": "aks-node-1", "Status": "Ready"},
+		{"TimeGenerated": "2024-01-01T00:05:00Z", "Computer": "aks-node-1", "Status": "Ready,DiskPressure"},
+		{"TimeGenerated": "2024-01-01T00:10:00Z", "Computer": "aks-node-1", "Status": "Ready"},
+	} {
+		n.observe("KubeNodeInventory", row)
+	}
+	var changes []string
+	for _, tr := range n.nodes["aks-node-1"].transitions() {
+		changes = append(changes, tr.condition+":"+tr.from+">"+tr.to)
+	}
+	want := "Ready:Unknown>True,MemoryPressure:Unknown>False,DiskPressure:Unknown>False,PIDPressure:Unknown>False,DiskPressure:False>True,DiskPressure:True>False"
+	if got := strings.Join(changes, ","); got != want {
+		t.Errorf("transitions\n got %s\nwant %s", got, want)
+	}
+}
+
+func TestNodeConditionsEmpty(t *testing.T) {
+	got := readTransform(t, newNodeConditions())[nodeConditionsPath]
+	if !strings.Contains(got, "No KubeNodeInventory rows") {
+		t.Errorf("unexpected empty timeline:\n%s", got)
+	}
+}
@@ -0,0 +1,215 @@
+package mustgather
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"kubectl-must-gather/pkg/utils"
+)
+
+// nodeResourceMetrics maps Container Insights node metrics (Perf counters and
+// InsightsMetrics names) to the Node status field and resource they describe.
+var nodeResourceMetrics = map[string]struct{ field, resource string }{
+	"cpuCapacityNanoCores":         {"capacity", "cpu"},
+	"cpuAllocatableNanoCores":      {"allocatable", "cpu"},
+	"memoryCapacityBytes":          {"capacity", "memory"},
+	"memoryAllocatableBytes":       {"allocatable", "memory"},
+	"kube_node_status_capacity":    {"capacity", ""},
+	"kube_node_status_allocatable": {"allocatable", ""},
+}
+
+// nodeInventory writes nodes/<node>/node.json, a Node-shaped summary of the
+// latest KubeNodeInventory snapshot with capacity and allocatable resources
+// from Perf/InsightsMetrics, and nodes/<node>/conditions.log with every change
+// of the node's reported status over the window.
+type nodeInventory struct {
+	latest    map[string]map[string]any
+	statuses  map[string][]nodeStatus
+	resources map[string]map[string]map[string]string
+	// loc is the --timezone of conditions.log
+	loc *time.Location
+}
+
+type nodeStatus struct {
+	tm, status string
+}
+
+func newNodeInventory(loc *time.Location) *nodeInventory {
+	return &nodeInventory{
+		loc:       loc,
+		latest:    map[string]map[string]any{},
+		statuses:  map[string][]nodeStatus{},
+		resources: map[string]map[string]map[string]string{},
+	}
+}
+
+func (n *nodeInventory) observe(table string, row map[string]any) {
+	switch table {
+	case "KubeNodeInventory":
+		node := toStr(row["Computer"])
+		if node == "" {
+			return
+		}
+		tm := toStr(row["TimeGenerated"])
+		if prev, ok := n.latest[node]; !ok || later(tm, toStr(prev["TimeGenerated"])) {
+			n.latest[node] = row
+		}
+		n.statuses[node] = append(n.statuses[node], nodeStatus{tm: tm, status: toStr(row["Status"])})
+	case "Perf":
+		if toStr(row["ObjectName"]) == "K8SNode" {
+			n.observeResource(toStr(row["Computer"]), toStr(row["CounterName"]), row["CounterValue"], nil)
+		}
+	case "InsightsMetrics":
+		n.observeResource(toStr(row["Computer"]), toStr(row["Name"]), row["Val"], row["Tags"])
+	}
+}
+
+// observeResource records a capacity or allocatable value. Samples arrive in
+// time order, so the last one wins.
+func (n *nodeInventory) observeResource(node, metric string, value, tags any) {
+	m, ok := nodeResourceMetrics[metric]
+	if !ok || node == "" {
+		return
+	}
+	resource := m.resource
+	if resource == "" {
+		// kube-state-metrics style: the resource is a tag
+		t, _ := dynamicValue(tags).(map[string]any)
+		resource = toStr(t["resource"])
+		if resource == "" {
+			return
+		}
+	}
+	v, ok := toFloat(value)
+	if !ok {
+		return
+	}
+	var q string
+	switch {
+	case metric == "cpuCapacityNanoCores" || metric == "cpuAllocatableNanoCores":
+		q = fmt.Sprintf("%dm", int64(v/1e6))
+	case strings.HasPrefix(metric, "memory") && int64(v)%1024 == 0:
+		q = fmt.Sprintf("%dKi", int64(v)/1024)
+	default:
+		q = strconv.FormatFloat(v, 'f', -1, 64)
+	}
+	if n.resources[node] == nil {
+		n.resources[node] = map[string]map[string]string{}
+	}
+	if n.resources[node][m.field] == nil {
+		n.resources[node][m.field] = map[string]string{}
+	}
+	n.resources[node][m.field][resource] = q
+}
+
+func (n *nodeInventory) endChunk(tarw *utils.TarWriter) error {
+	return nil
+}
+
+func (n *nodeInventory) finish(tarw *utils.TarWriter) error {
+	nodes := make([]string, 0, len(n.latest))
+	for node := range n.latest {
+		nodes = append(nodes, node)
+	}
+	sort.Strings(nodes)
+	for _, node := range nodes {
+		dir := filepath.Join("nodes", utils.SafeFileName(node))
+		b, _ := json.MarshalIndent(n.nodeObject(node), "", "  ")
+		if err := utils.WriteFileToTar(tarw, filepath.Join(dir, "node.json"), b); err != nil {
+			return err
+		}
+		if log := n.conditionsLog(node); log != "" {
+			if err := utils.WriteFileToTar(tarw, filepath.Join(dir, "conditions.log"), []byte(log)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (n *nodeInventory) warnings(table string) []string {
+	return nil
+}
+
+func (n *nodeInventory) nodeObject(node string) map[string]any {
+	row := n.latest[node]
+	meta := map[string]any{"name": node}
+	setIf(meta, "creationTimestamp", toStr(row["CreationTimeStamp"]))
+	if labels := podLabels(row["Labels"]); len(labels) > 0 {
+		meta["labels"] = labels
+	}
+	spec := map[string]any{}
+	setIf(spec, "providerID", toStr(row["KubernetesProviderID"]))
+
+	info := map[string]any{}
+	setIf(info, "kubeletVersion", toStr(row["KubeletVersion"]))
+	setIf(info, "kubeProxyVersion", toStr(row["KubeProxyVersion"]))
+	setIf(info, "containerRuntimeVersion", toStr(row["DockerVersion"]))
+	setIf(info, "osImage", toStr(row["OperatingSystem"]))
+	status := map[string]any{"nodeInfo": info}
+	for field, res := range n.resources[node] {
+		status[field] = res
+	}
+	ready := map[string]any{"type": "Ready", "status": "Unknown"}
+	switch st := toStr(row["Status"]); {
+	case strings.Contains(st, "NotReady"):
+		ready["status"] = "False"
+	case strings.Contains(st, "Ready"):
+		ready["status"] = "True"
+	}
+	setIf(ready, "lastTransitionTime", toStr(row["LastTransitionTimeReady"]))
+	setIf(ready, "message", toStr(row["Status"]))
+	status["conditions"] = []any{ready}
+
+	return map[string]any{
+		"apiVersion": "v1",
+		"kind":       "Node",
+		"metadata":   meta,
+		"spec":       spec,
+		"status":     status,
+		// Not part of a real Node: when the snapshot above was taken.
+		"lastSeen": toStr(row["TimeGenerated"]),
+	}
+}
+
+// conditionsLog lists the first reported status and every change after it.
+func (n *nodeInventory) conditionsLog(node string) string {
+	sts := n.statuses[node]
+	sort.SliceStable(sts, func(i, j int) bool { return timeBefore(sts[i].tm, sts[j].tm) })
+	var b strings.Builder
+	prev := ""
+	for i, s := range sts {
+		switch {
+		case i == 0:
+			fmt.Fprintf(&b, "%s %s (first observed)\n", formatStitchTime(s.tm, n.loc), s.status)
+		case s.status != prev:
+			fmt.Fprintf(&b, "%s %s -> %s\n", formatStitchTime(s.tm, n.loc), prev, s.status)
+		}
+		prev = s.status
+	}
+	return b.String()
+}
+
+func toFloat(v any) (float64, bool) {
+	switch t := v.(type) {
+	case float64:
+		return t, true
+	case int64:
+		return float64(t), true
+	case int:
+		return float64(t), true
+	case json.Number:
+		f, err := t.Float64()
+		return f, err == nil
+	case string:
+		var f float64
+		_, err := fmt.Sscan(t, &f)
+		return f, err == nil
+	}
+	return 0, false
+}
@@ -0,0 +1,58 @@
+package mustgather
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestNodeInventory(t *testing.T) {
+	n := newNodeInventory(nil)
+	nodeRow := func(tm, status string) map[string]any {
+		return map[string]any{
+			"TimeGenerated": tm, "Computer": "aks-node-1", "Status": status, "KubeletVersion": "v1.29.2",
+			"KubeProxyVersion": "v1.29.2", "DockerVersion": "containerd://1.7.15", "OperatingSystem": "Ubuntu 22.04.4 LTS",
+			"Labels": `[{"kubernetes.io/os":"linux","agentpool":"nodepool1"}]`, "LastTransitionTimeReady": "2024-01-01T00:20:00Z",
+		}
+	}
+	// Out of order across chunks on purpose
+	n.observe("KubeNodeInventory", nodeRow("2024-01-01T00:10:00Z", "NotReady"))
+	n.observe("KubeNodeInventory", nodeRow("2024-01-01T00:00:00Z", "Ready"))
+	n.observe("KubeNodeInventory", nodeRow("2024-01-01T00:05:00Z", "Ready"))
+	n.observe("KubeNodeInventory", nodeRow("2024-01-01T00:20:00Z", "Ready"))
+	n.observe("Perf", map[string]any{"Computer": "aks-node-1", "ObjectName": "K8SNode", "CounterName": "cpuAllocatableNanoCores", "CounterValue": float64(1900000000)})
+	n.observe("Perf", map[string]any{"Computer": "aks-node-1", "ObjectName": "K8SNode", "CounterName": "memoryCapacityBytes", "CounterValue": float64(16 << 30)})
+	n.observe("InsightsMetrics", map[string]any{"Computer": "aks-node-1", "Name": "kube_node_status_capacity", "Val": float64(4), "Tags": `{"resource":"cpu"}`})
+
+	files := readTransform(t, n)
+	want := "2024-01-01T00:00:00Z Ready (first observed)\n" +
+		"2024-01-01T00:10:00Z Ready -> NotReady\n" +
+		"2024-01-01T00:20:00Z NotReady -> Ready\n"
+	if got := files["nodes/aks-node-1/conditions.log"]; got != want {
+		t.Errorf("unexpected conditions.log.\nExpected:\n%s\nGot:\n%s", want, got)
+	}
+
+	var node struct {
+		Kind     string
+		Metadata struct {
+			Labels map[string]string
+		}
+		Status struct {
+			Capacity    map[string]string
+			Allocatable map[string]string
+			NodeInfo    map[string]string
+			Conditions  []map[string]string
+		}
+	}
+	if err := json.Unmarshal([]byte(files["nodes/aks-node-1/node.json"]), &node); err != nil {
+		t.Fatalf("invalid node.json: %v", err)
+	}
+	if node.Kind != "Node" || node.Metadata.Labels["agentpool"] != "nodepool1" || node.Status.NodeInfo["kubeletVersion"] != "v1.29.2" {
+		t.Errorf("unexpected node: %+v", node)
+	}
+	if node.Status.Allocatable["cpu"] != "1900m" || node.Status.Capacity["memory"] != "16777216Ki" || node.Status.Capacity["cpu"] != "4" {
+		t.Errorf("unexpected resources: capacity %v allocatable %v", node.Status.Capacity, node.Status.Allocatable)
+	}
+	if len(node.Status.Conditions) != 1 || node.Status.Conditions[0]["status"] != "True" {
+		t.Errorf("expected latest Ready condition to be True, got %v", node.Status.Conditions)
+	}
+}
@@ -0,0 +1,250 @@
+package mustgather
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"kubectl-must-gather/pkg/utils"
+)
+
+// notifyTimeout bounds the post of a completion notification.
+const notifyTimeout = 30 * time.Second
+
+// maxNotifyItems bounds the warnings and findings a notification lists.
+const maxNotifyItems = 5
+
+// Formats of completion notifications.
+const (
+	notifySlack = "slack"
+	notifyTeams = "teams"
+)
+
+// notification summarizes a finished gather for a chat channel.
+type notification struct {
+	title string
+	ok    bool
+	// facts are name and value pairs, in order.
+	facts    [][2]string
+	warnings []string
+	findings []string
+	// moreWarnings and moreFindings count those left out of the lists.
+	moreWarnings, moreFindings int
+}
+
+// notification summarizes the gather that ended with err: its outcome,
+// archive, size and window, and the first warnings and findings.
+func (g *Gatherer) notification(err error) *notification {
+	n := &notification{ok: err == nil && !g.incomplete()}
+	target := g.config.WorkspaceID
+	if g.config.ClusterID != "" {
+		target = g.config.ClusterID
+	}
+	target = path.Base(strings.TrimSuffix(target, "/"))
+	if g.config.Anonymizer != nil {
+		target = g.config.Anonymizer.text(target)
+	}
+	switch {
+	case g.incomplete():
+		n.title = "Gather of " + target + " interrupted"
+	case err != nil:
+		n.title = "Gather of " + target + " failed"
+	default:
+		n.title = "Gather of " + target + " succeeded"
+	}
+
+	archive := g.outFile
+	if len(g.uploaded) > 0 {
+		archive = g.uploaded[0]
+	}
+	if archive != "" {
+		n.facts = append(n.facts, [2]string{"Archive", archive})
+		if fi, statErr := os.Stat(g.outFile); statErr == nil {
+			n.facts = append(n.facts, [2]string{"Size", utils.FormatByteSize(fi.Size())})
+		}
+	}
+	var rows int
+	for _, o := range g.outcomes {
+		rows += o.rows
+	}
+	n.facts = append(n.facts, [2]string{"Rows", fmt.Sprintf("%d from %d tables", rows, len(g.completed))})
+	if !g.start.IsZero() {
+		n.facts = append(n.facts, [2]string{"Window", g.start.UTC().Format(time.RFC3339) + " to " + g.end.UTC().Format(time.RFC3339)})
+	}
+	n.facts = append(n.facts, [2]string{"Duration", time.Since(g.startedAt).Round(time.Second).String()})
+	if err != nil {
+		n.facts = append(n.facts, [2]string{"Error", err.Error()})
+	}
+
+	g.warnMu.Lock()
+	warns := append([]string{}, g.warns...)
+	g.warnMu.Unlock()
+	n.warnings, n.moreWarnings = firstItems(warns)
+	if g.findings != nil {
+		var found []string
+		for _, f := range g.findings.list() {
+			obj := f.Name
+			if f.Namespace != "" {
+				obj = f.Namespace + "/" + obj
+			}
+			if f.Container != "" {
+				obj += " (" + f.Container + ")"
+			}
+			found = append(found, fmt.Sprintf("%s %s on %s, %d times", f.Severity, f.Rule, obj, f.Count))
+		}
+		n.findings, n.moreFindings = firstItems(found)
+	}
+	return n
+}
+
+func firstItems(items []string) ([]string, int) {
+	if len(items) <= maxNotifyItems {
+		return items, 0
+	}
+	return items[:maxNotifyItems], len(items) - maxNotifyItems
+}
+
+// notifyFormat picks the payload an incoming webhook URL expects: Teams
+// incoming webhooks and workflows take Adaptive Cards, the others, such as
+// Slack, Mattermost and Rocket.Chat, a Slack message.
+func notifyFormat(webhook string) string {
+	u, err := url.Parse(webhook)
+	if err != nil {
+		return notifySlack
+	}
+	host := strings.ToLower(u.Hostname())
+	for _, suffix := range []string{".webhook.office.com", ".logic.azure.com", ".powerplatform.com"} {
+		if strings.HasSuffix(host, suffix) {
+			return notifyTeams
+		}
+	}
+	return notifySlack
+}
+
+// slack is the notification as a Slack incoming webhook message.
+func (n *notification) slack() map[string]any {
+	icon := ":white_check_mark:"
+	if !n.ok {
+		icon = ":x:"
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s *%s*\n", icon, n.title)
+	for _, f := range n.facts {
+		fmt.Fprintf(&b, "• *%s:* %s\n", f[0], f[1])
+	}
+	n.list(&b, "Findings", n.findings, n.moreFindings)
+	n.list(&b, "Warnings", n.warnings, n.moreWarnings)
+	return map[string]any{"text": strings.TrimSuffix(b.String(), "\n")}
+}
+
+func (n *notification) list(b *strings.Builder, name string, items []string, more int) {
+	if len(items) == 0 {
+		return
+	}
+	fmt.Fprintf(b, "*%s:*\n", name)
+	for _, it := range items {
+		fmt.Fprintf(b, "• %s\n", it)
+	}
+	if more > 0 {
+		fmt.Fprintf(b, "• and %d more\n", more)
+	}
+}
+
+// teams is the notification as a Teams message with an Adaptive Card.
+func (n *notification) teams() map[string]any {
+	color := "Good"
+	if !n.ok {
+		color = "Attention"
+	}
+	facts := make([]map[string]string, 0, len(n.facts))
+	for _, f := range n.facts {
+		facts = append(facts, map[string]string{"title": f[0], "value": f[1]})
+	}
+	body := []map[string]any{
+		{"type": "TextBlock", "text": n.title, "weight": "Bolder", "size": "Medium", "color": color, "wrap": true},
+		{"type": "FactSet", "facts": facts},
+	}
+	section := func(name string, items []string, more int) {
+		if len(items) == 0 {
+			return
+		}
+		lines := make([]string, 0, len(items)+1)
+		for _, it := range items {
+			lines = append(lines, "- "+it)
+		}
+		if more > 0 {
+			lines = append(lines, fmt.Sprintf("- and %d more", more))
+		}
+		body = append(body,
+			map[string]any{"type": "TextBlock", "text": name, "weight": "Bolder", "spacing": "Medium"},
+			map[string]any{"type": "TextBlock", "text": strings.Join(lines, "\n"), "wrap": true})
+	}
+	section("Findings", n.findings, n.moreFindings)
+	section("Warnings", n.warnings, n.moreWarnings)
+	return map[string]any{
+		"type": "message",
+		"attachments": []map[string]any{{
+			"contentType": "application/vnd.microsoft.card.adaptive",
+			"content": map[string]any{
+				"$schema": "http://adaptivecards.io/schemas/adaptive-card.json",
+				"type":    "AdaptiveCard",
+				"version": "1.4",
+				"body":    body,
+			},
+		}},
+	}
+}
+
+// postNotification posts the summary of a gather that ended with err to
+// NotifyWebhook. Failing to post it is only a warning: the gather itself is
+// done.
+func (g *Gatherer) postNotification(err error) {
+	n := g.notification(err)
+	payload := n.slack()
+	if notifyFormat(g.config.NotifyWebhook) == notifyTeams {
+		payload = n.teams()
+	}
+	if postErr := postWebhook(g.config.NotifyWebhook, payload); postErr != nil {
+		g.warnf("", "no completion notification: %v", postErr)
+	}
+}
+
+// postWebhook posts payload as JSON. The gather may have been interrupted,
+// so it does not use the gather's context; errors leave out the URL, which
+// holds the webhook's secret.
+func postWebhook(webhook string, payload any) error {
+	b, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), notifyTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhook, bytes.NewReader(b))
+	if err != nil {
+		return errors.New("invalid webhook URL")
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		var uerr *url.Error
+		if errors.As(err, &uerr) {
+			err = uerr.Err
+		}
+		return fmt.Errorf("post: %w", err)
+	}
+	defer resp.Body.Close()
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("post: %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
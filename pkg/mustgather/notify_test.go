@@ -0,0 +1,193 @@
+package mustgather
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNotifyFormat(t *testing.T) {
+	tests := []struct {
+		url      string
+		expected string
+	}{
+		{"https://hooks.slack.com/services/T000/B000/XXXX", notifySlack},
+		{"https://contoso.webhook.office.com/webhookb2/abc/IncomingWebhook/def", notifyTeams},
+		{"https://prod-12.westus.logic.azure.com:443/workflows/abc/triggers/manual/paths/invoke?sig=x", notifyTeams},
+		{"https://default123.environment.api.powerplatform.com/powerautomate/automations/direct/workflows/abc", notifyTeams},
+		{"https://mattermost.example.com/hooks/abc", notifySlack},
+		{"::not a url", notifySlack},
+	}
+	for _, tt := range tests {
+		if got := notifyFormat(tt.url); got != tt.expected {
+			t.Errorf("notifyFormat(%s) = %s, want %s", tt.url, got, tt.expected)
+		}
+	}
+}
+
+// notifyGatherer returns a gather of an archive in dir that ended with two
+// findings and seven warnings.
+func notifyGatherer(t *testing.T, webhook string) *Gatherer {
+	t.Helper()
+	out := filepath.Join(t.TempDir(), "mg.tar.gz")
+	if err := os.WriteFile(out, make([]byte, 3<<10), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	f := newFindings(&Config{}, builtinRules)
+	f.found["a"] = &Finding{Rule: "oom-killed", Severity: severityCritical, Namespace: "shop", Name: "cart-1", Container: "app", Count: 3}
+	f.found["b"] = &Finding{Rule: "image-pull", Severity: severityWarning, Namespace: "shop", Name: "web-2", Count: 7}
+	g := &Gatherer{
+		ctx:       context.Background(),
+		config:    &Config{WorkspaceID: "/subscriptions/s/resourceGroups/rg/providers/Microsoft.OperationalInsights/workspaces/prod-logs", NotifyWebhook: webhook},
+		log:       io.Discard,
+		outFile:   out,
+		startedAt: time.Now().Add(-90 * time.Second),
+		start:     time.Date(2026, 1, 1, 10, 0, 0, 0, time.UTC),
+		end:       time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC),
+		outcomes:  []tableOutcome{{table: "KubeEvents", rows: 40}, {table: "ContainerLogV2", rows: 2}},
+		completed: []string{"KubeEvents", "ContainerLogV2"},
+		findings:  f,
+	}
+	for i := 0; i < 7; i++ {
+		g.addWarning(fmt.Sprintf("warning %d", i))
+	}
+	return g
+}
+
+func TestNotification(t *testing.T) {
+	g := notifyGatherer(t, "")
+	n := g.notification(nil)
+	if !n.ok || n.title != "Gather of prod-logs succeeded" {
+		t.Errorf("title = %q, ok = %v", n.title, n.ok)
+	}
+	facts := map[string]string{}
+	for _, f := range n.facts {
+		facts[f[0]] = f[1]
+	}
+	for name, want := range map[string]string{
+		"Archive":  g.outFile,
+		"Size":     "3.0 KiB",
+		"Rows":     "42 from 2 tables",
+		"Window":   "2026-01-01T10:00:00Z to 2026-01-01T12:00:00Z",
+		"Duration": "1m30s",
+	} {
+		if facts[name] != want {
+			t.Errorf("%s = %q, want %q", name, facts[name], want)
+		}
+	}
+	if _, ok := facts["Error"]; ok {
+		t.Error("a successful gather reports an error")
+	}
+	if len(n.findings) != 2 || n.findings[0] != "critical oom-killed on shop/cart-1 (app), 3 times" {
+		t.Errorf("findings = %q", n.findings)
+	}
+	if len(n.warnings) != maxNotifyItems || n.moreWarnings != 2 {
+		t.Errorf("warnings = %q, %d more", n.warnings, n.moreWarnings)
+	}
+
+	// The uploaded blob is the archive to fetch
+	g.uploaded = []string{"https://acct.blob.core.windows.net/diag/mg.tar.gz"}
+	n = g.notification(errors.New("query failed"))
+	if n.ok || n.title != "Gather of prod-logs failed" {
+		t.Errorf("title = %q, ok = %v", n.title, n.ok)
+	}
+	if n.facts[0][1] != g.uploaded[0] || n.facts[len(n.facts)-1] != [2]string{"Error", "query failed"} {
+		t.Errorf("facts = %q", n.facts)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	g.ctx = ctx
+	if n := g.notification(ctx.Err()); n.ok || n.title != "Gather of prod-logs interrupted" {
+		t.Errorf("title = %q, ok = %v", n.title, n.ok)
+	}
+}
+
+func TestPostNotification(t *testing.T) {
+	var got []map[string]any
+	status := http.StatusOK
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]any
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Errorf("decode: %v", err)
+		}
+		got = append(got, body)
+		w.WriteHeader(status)
+		fmt.Fprint(w, "no_text")
+	}))
+	defer srv.Close()
+
+	g := notifyGatherer(t, srv.URL+"/services/T000/B000/secret")
+	g.postNotification(nil)
+	if len(got) != 1 {
+		t.Fatalf("posts = %d", len(got))
+	}
+	text, _ := got[0]["text"].(string)
+	for _, want := range []string{
+		":white_check_mark: *Gather of prod-logs succeeded*",
+		"• *Size:* 3.0 KiB",
+		"*Findings:*\n• critical oom-killed on shop/cart-1 (app), 3 times\n• warning image-pull on shop/web-2, 7 times",
+		"• warning 4\n• and 2 more",
+	} {
+		if !strings.Contains(text, want) {
+			t.Errorf("message lacks %q:\n%s", want, text)
+		}
+	}
+	if len(g.warns) != 7 {
+		t.Errorf("warnings = %q", g.warns)
+	}
+
+	// A rejected post is only a warning, without the secret URL
+	status = http.StatusBadRequest
+	g.postNotification(nil)
+	last := g.warns[len(g.warns)-1]
+	if !strings.Contains(last, "no completion notification: post: 400 Bad Request: no_text") || strings.Contains(last, "secret") {
+		t.Errorf("warning = %q", last)
+	}
+}
+
+func TestTeamsNotification(t *testing.T) {
+	g := notifyGatherer(t, "")
+	card := g.notification(errors.New("boom")).teams()
+	b, _ := json.Marshal(card)
+	var msg struct {
+		Type        string `json:"type"`
+		Attachments []struct {
+			ContentType string `json:"contentType"`
+			Content     struct {
+				Type string `json:"type"`
+				Body []struct {
+					Type  string `json:"type"`
+					Text  string `json:"text"`
+					Color string `json:"color"`
+					Facts []struct {
+						Title string `json:"title"`
+						Value string `json:"value"`
+					} `json:"facts"`
+				} `json:"body"`
+			} `json:"content"`
+		} `json:"attachments"`
+	}
+	if err := json.Unmarshal(b, &msg); err != nil {
+		t.Fatal(err)
+	}
+	if msg.Type != "message" || len(msg.Attachments) != 1 || msg.Attachments[0].ContentType != "application/vnd.microsoft.card.adaptive" {
+		t.Fatalf("message = %s", b)
+	}
+	body := msg.Attachments[0].Content.Body
+	if len(body) != 6 || body[0].Text != "Gather of prod-logs failed" || body[0].Color != "Attention" {
+		t.Fatalf("card body = %s", b)
+	}
+	if body[1].Facts[0].Title != "Archive" || body[2].Text != "Findings" || !strings.HasSuffix(body[5].Text, "- and 2 more") {
+		t.Errorf("card body = %s", b)
+	}
+}
@@ -0,0 +1,106 @@
+package mustgather
+
+import (
+	"io"
+	"net/http"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/cloud"
+
+	"kubectl-must-gather/pkg/utils"
+)
+
+// Option customizes a gatherer made by NewGatherer, beyond its Config.
+type Option func(*gathererOptions)
+
+// gathererOptions collects the Options of NewGatherer.
+type gathererOptions struct {
+	env Environment
+	// log replaces stderr as where progress and warnings for people go: a
+	// writer of WithLogger, or the logWriter of WithStructuredLogger.
+	log io.Writer
+	// concurrency overrides Config.Parallelism when positive.
+	concurrency int
+	hooks       hookList
+	// sink replaces the output file named by the Config.
+	sink utils.Sink
+}
+
+// WithEnvironment sets the cloud, credential, HTTP client, stdout and service
+// clients of env at once; later options override its fields.
+func WithEnvironment(env Environment) Option {
+	return func(o *gathererOptions) { o.env = env }
+}
+
+// WithCredential authenticates to Azure with cred instead of
+// DefaultAzureCredential.
+func WithCredential(cred azcore.TokenCredential) Option {
+	return func(o *gathererOptions) { o.env.Credential = cred }
+}
+
+// WithCloud talks to the endpoints of c instead of the Azure public cloud.
+func WithCloud(c cloud.Configuration) Option {
+	return func(o *gathererOptions) { o.env.Cloud = c }
+}
+
+// WithHTTPClient sends the requests of the Azure SDK clients through c.
+func WithHTTPClient(c *http.Client) Option {
+	return func(o *gathererOptions) { o.env.HTTPClient = c }
+}
+
+// WithStdout writes the --progress-format json stream and the --output-json
+// result, or the questions, answers and progress of AI mode, to w instead of
+// os.Stdout.
+func WithStdout(w io.Writer) Option {
+	return func(o *gathererOptions) { o.env.Stdout = w }
+}
+
+// WithLogsClient runs data-plane queries through c.
+func WithLogsClient(c LogsQueryClient) Option {
+	return func(o *gathererOptions) { o.env.LogsClient = c }
+}
+
+// WithTablesClient lists and reads the workspace's tables through c.
+func WithTablesClient(c TablesClient) Option {
+	return func(o *gathererOptions) { o.env.TablesClient = c }
+}
+
+// WithWorkspacesClient looks up the workspace through c.
+func WithWorkspacesClient(c WorkspacesClient) Option {
+	return func(o *gathererOptions) { o.env.WorkspacesClient = c }
+}
+
+// WithLogger writes progress and warnings for people to w instead of
+// stderr. Config.Quiet still discards them.
+func WithLogger(w io.Writer) Option {
+	return func(o *gathererOptions) { o.log = w }
+}
+
+// WithStructuredLogger logs progress and warnings for people with l instead
+// of writing them to stderr, a record per line, e.g. to a *slog.Logger.
+// Config.Quiet still discards them. It replaces WithLogger and the other way
+// round.
+func WithStructuredLogger(l Logger) Option {
+	return func(o *gathererOptions) { o.log = newLogWriter(l) }
+}
+
+// WithConcurrency queries up to n chunks of a table at once, overriding
+// Config.Parallelism.
+func WithConcurrency(n int) Option {
+	return func(o *gathererOptions) { o.concurrency = n }
+}
+
+// WithHooks calls h with the progress and warnings of the gather. It may be
+// given several times; hooks are called in order. AI mode does not call them.
+func WithHooks(h ...Hooks) Option {
+	return func(o *gathererOptions) { o.hooks = append(o.hooks, h...) }
+}
+
+// WithSink writes the files of the bundle to s instead of the output named
+// by Config.OutputFile and OutputFormat, e.g. one of the sinks of
+// pkg/utils or NewBlobSink. Run closes it. Encrypting, signing, uploading
+// and pruning need an archive file and are rejected with it; AI mode does
+// not use it.
+func WithSink(s utils.Sink) Option {
+	return func(o *gathererOptions) { o.sink = s }
+}
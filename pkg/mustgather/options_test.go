@@ -0,0 +1,118 @@
+package mustgather
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	azquery "github.com/Azure/azure-sdk-for-go/sdk/monitor/azquery"
+)
+
+func TestNewGathererOptions(t *testing.T) {
+	ts := time.Now().Add(-10 * time.Minute).UTC().Format(time.RFC3339Nano)
+	logs := &fakeLogs{rows: map[string][]azquery.Row{"Heartbeat": {{ts, "node-1"}}}}
+	var log, stdout bytes.Buffer
+	config := &Config{
+		WorkspaceID:    "/subscriptions/sub/resourceGroups/rg/providers/Microsoft.OperationalInsights/workspaces/ws",
+		Timespan:       "PT1H",
+		OutputFile:     filepath.Join(t.TempDir(), "bundle.tar.gz"),
+		TableFilter:    "Heartbeat",
+		Parallelism:    2,
+		ProgressFormat: "json",
+	}
+	gi, err := NewGatherer(context.Background(), config,
+		WithEnvironment(Environment{LogsClient: &fakeLogs{}}),
+		WithCredential(noCredential{}),
+		WithLogsClient(logs),
+		WithWorkspacesClient(fakeWorkspaces{}),
+		WithTablesClient(fakeTables{names: []string{"Heartbeat"}}),
+		WithStdout(&stdout),
+		WithLogger(&log),
+		WithConcurrency(8),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g, ok := gi.(*Gatherer)
+	if !ok {
+		t.Fatalf("expected a table-export gatherer, got %T", gi)
+	}
+	if g.parallelism() != 8 || g.lcli != logs {
+		t.Errorf("options not applied: parallelism %d", g.parallelism())
+	}
+	if _, err := g.Run(); err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if log.Len() == 0 || !bytes.Contains(stdout.Bytes(), []byte(`"table":"Heartbeat"`)) {
+		t.Errorf("expected progress in the logger and the JSON stream in stdout, got %q and %q", log.String(), stdout.String())
+	}
+
+	config.AIMode = true
+	gi, err = NewGatherer(context.Background(), config, WithCredential(noCredential{}), WithStdout(&stdout), WithLogger(&log))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ag, ok := gi.(*AIGatherer); !ok || ag.cred != (noCredential{}) || ag.stdout() != &stdout || ag.log() != &log {
+		t.Errorf("expected an AI gatherer with the credential and writers, got %#v", gi)
+	}
+}
+
+func TestWithStructuredLogger(t *testing.T) {
+	ts := time.Now().Add(-10 * time.Minute).UTC().Format(time.RFC3339Nano)
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&buf, nil))
+	config := &Config{
+		WorkspaceID: "/subscriptions/sub/resourceGroups/rg/providers/Microsoft.OperationalInsights/workspaces/ws",
+		Timespan:    "PT1H",
+		OutputFile:  filepath.Join(t.TempDir(), "bundle.tar.gz"),
+		TableFilter: "Heartbeat,NoSuchTable,Syslog",
+		StitchLogs:  true,
+	}
+	g, err := NewGatherer(context.Background(), config,
+		WithCredential(noCredential{}),
+		WithLogsClient(&fakeLogs{rows: map[string][]azquery.Row{"Heartbeat": {{ts, "node-1"}}, "Syslog": {{ts, "node-1"}}}}),
+		WithWorkspacesClient(fakeWorkspaces{}),
+		WithTablesClient(fakeTables{names: []string{"Heartbeat", "Syslog"}}),
+		WithStructuredLogger(logger),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := g.Run(); err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	var infos, warns []string
+	dec := json.NewDecoder(&buf)
+	for dec.More() {
+		var rec struct{ Level, Msg string }
+		if err := dec.Decode(&rec); err != nil {
+			t.Fatal(err)
+		}
+		switch rec.Level {
+		case "INFO":
+			infos = append(infos, rec.Msg)
+		case "WARN":
+			warns = append(warns, rec.Msg)
+		}
+	}
+	if !slices.Contains(infos, "Exporting Heartbeat...") {
+		t.Errorf("expected a record per progress line, got %q", infos)
+	}
+	// Syslog rows without SyslogMessage cannot be stitched
+	if len(warns) != 2 || !strings.Contains(warns[0], "NoSuchTable") || !strings.Contains(warns[1], "SyslogMessage missing") {
+		t.Errorf("expected the skipped table and the unstitched one as warnings, got %q", warns)
+	}
+
+	// Warnings about a table carry it as an attribute
+	buf.Reset()
+	newLogWriter(logger).warn("Heartbeat", "export failed")
+	if !strings.Contains(buf.String(), `"table":"Heartbeat"`) {
+		t.Errorf("expected a table attribute, got %s", buf.String())
+	}
+}
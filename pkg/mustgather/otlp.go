@@ -0,0 +1,275 @@
+package mustgather
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"kubectl-must-gather/pkg/bundle"
+	"kubectl-must-gather/pkg/utils"
+)
+
+// DefaultOTLPEndpoint is the OTLP/HTTP receiver of a collector running with
+// its default configuration.
+const DefaultOTLPEndpoint = "http://localhost:4318"
+
+// otlpScope names the instrumentation scope of every exported record.
+const otlpScope = "aks-must-gather"
+
+// OTLP severity numbers, from the OpenTelemetry log data model.
+const (
+	otlpSeverityInfo  = 9
+	otlpSeverityWarn  = 13
+	otlpSeverityError = 17
+)
+
+// OTLPOptions configures ExportOTLP.
+type OTLPOptions struct {
+	// Endpoint is the collector's OTLP/HTTP base URL, to which /v1/logs is
+	// appended unless it already ends with it.
+	Endpoint string
+	// Headers are added to every request, e.g. for authentication.
+	Headers map[string]string
+	// BatchSize caps the log records sent per request.
+	BatchSize int
+	// MaxRetries is how often a request throttled by the collector (HTTP
+	// 429/503) is retried with backoff honoring Retry-After.
+	MaxRetries int
+	Client     *http.Client
+	// Logger receives a warning for each throttled request retried; nil
+	// drops them.
+	Logger Logger
+}
+
+// OTLPStats counts what ExportOTLP sent.
+type OTLPStats struct {
+	Records  map[string]int64
+	Requests int
+}
+
+// ExportOTLP replays the ContainerLogV2 and KubeEvents rows of the bundle at
+// src as OTLP log records, sent as JSON to the collector at opts.Endpoint.
+// Records carry the Kubernetes resource attributes of their pod or object,
+// so the backend can query them like logs collected live.
+func ExportOTLP(ctx context.Context, src string, opts OTLPOptions) (*OTLPStats, error) {
+	b, err := bundle.Open(src)
+	if err != nil {
+		return nil, fmt.Errorf("open bundle: %w", err)
+	}
+	defer b.Close()
+	tables, err := b.Tables()
+	if err != nil {
+		return nil, fmt.Errorf("list tables: %w", err)
+	}
+
+	e := newOTLPExporter(ctx, opts)
+	for _, t := range tables {
+		convert := otlpConverter(t.Name)
+		if convert == nil {
+			continue
+		}
+		for _, part := range t.Parts {
+			err := b.ReadPart(part, func(row map[string]any) error {
+				resource, rec := convert(row)
+				e.stats.Records[t.Name]++
+				return e.add(resource, rec)
+			})
+			if err != nil {
+				return e.stats, err
+			}
+		}
+	}
+	return e.stats, e.flush()
+}
+
+// otlpConverter returns the row conversion of table, or nil if the table is
+// not exported.
+func otlpConverter(table string) func(map[string]any) ([]otlpKeyValue, otlpLogRecord) {
+	switch table {
+	case "ContainerLogV2":
+		return otlpContainerLog
+	case "KubeEvents":
+		return otlpEvent
+	}
+	return nil
+}
+
+func otlpContainerLog(row map[string]any) ([]otlpKeyValue, otlpLogRecord) {
+	resource := otlpAttributes(
+		"k8s.namespace.name", toStr(row["PodNamespace"]),
+		"k8s.pod.name", toStr(row["PodName"]),
+		"k8s.container.name", toStr(row["ContainerName"]),
+		"container.id", toStr(row["ContainerId"]),
+		"k8s.node.name", toStr(row["Computer"]),
+	)
+	msg, isErr := errorLog(row)
+	rec := otlpLogRecord{Body: otlpAnyValue{StringValue: &msg}, SeverityNumber: otlpSeverityInfo, SeverityText: "INFO"}
+	if level := toStr(row["LogLevel"]); level != "" {
+		rec.SeverityText = strings.ToUpper(level)
+	}
+	if isErr {
+		rec.SeverityNumber = otlpSeverityError
+		if rec.SeverityText == "INFO" {
+			rec.SeverityText = "ERROR"
+		}
+	}
+	rec.Attributes = otlpAttributes("log.iostream", toStr(row["LogSource"]))
+	rec.setTime(row)
+	return resource, rec
+}
+
+func otlpEvent(row map[string]any) ([]otlpKeyValue, otlpLogRecord) {
+	resource := otlpAttributes("k8s.namespace.name", toStr(row["Namespace"]))
+	msg := stitchMessage(row["Message"])
+	rec := otlpLogRecord{Body: otlpAnyValue{StringValue: &msg}, SeverityNumber: otlpSeverityInfo, SeverityText: "INFO"}
+	if strings.EqualFold(toStr(row["KubeEventType"]), "Warning") {
+		rec.SeverityNumber, rec.SeverityText = otlpSeverityWarn, "WARN"
+	}
+	rec.Attributes = otlpAttributes(
+		"event.domain", "k8s",
+		"k8s.event.reason", toStr(row["Reason"]),
+		"k8s.event.type", toStr(row["KubeEventType"]),
+		"k8s.object.kind", toStr(row["ObjectKind"]),
+		"k8s.object.name", toStr(row["Name"]),
+		"k8s.node.name", toStr(row["Computer"]),
+	)
+	rec.setTime(row)
+	return resource, rec
+}
+
+// otlpAttributes builds attributes from key, value pairs, skipping empty
+// values.
+func otlpAttributes(kv ...string) []otlpKeyValue {
+	var attrs []otlpKeyValue
+	for i := 0; i+1 < len(kv); i += 2 {
+		if kv[i+1] == "" {
+			continue
+		}
+		v := kv[i+1]
+		attrs = append(attrs, otlpKeyValue{Key: kv[i], Value: otlpAnyValue{StringValue: &v}})
+	}
+	return attrs
+}
+
+// The OTLP/JSON encoding of ExportLogsServiceRequest. 64-bit integers are
+// strings, as protobuf JSON requires.
+type otlpRequest struct {
+	ResourceLogs []*otlpResourceLogs `json:"resourceLogs"`
+}
+
+type otlpResourceLogs struct {
+	Resource struct {
+		Attributes []otlpKeyValue `json:"attributes,omitempty"`
+	} `json:"resource"`
+	ScopeLogs []*otlpScopeLogs `json:"scopeLogs"`
+}
+
+type otlpScopeLogs struct {
+	Scope struct {
+		Name string `json:"name"`
+	} `json:"scope"`
+	LogRecords []otlpLogRecord `json:"logRecords"`
+}
+
+type otlpLogRecord struct {
+	TimeUnixNano         string         `json:"timeUnixNano,omitempty"`
+	ObservedTimeUnixNano string         `json:"observedTimeUnixNano,omitempty"`
+	SeverityNumber       int            `json:"severityNumber,omitempty"`
+	SeverityText         string         `json:"severityText,omitempty"`
+	Body                 otlpAnyValue   `json:"body"`
+	Attributes           []otlpKeyValue `json:"attributes,omitempty"`
+}
+
+type otlpKeyValue struct {
+	Key   string       `json:"key"`
+	Value otlpAnyValue `json:"value"`
+}
+
+type otlpAnyValue struct {
+	StringValue *string `json:"stringValue,omitempty"`
+}
+
+// setTime stamps the record with the row's TimeGenerated, when it parses.
+func (r *otlpLogRecord) setTime(row map[string]any) {
+	if t := utils.ParseTimeRFC3339(toStr(row["TimeGenerated"])); !t.IsZero() {
+		r.TimeUnixNano = strconv.FormatInt(t.UnixNano(), 10)
+		r.ObservedTimeUnixNano = r.TimeUnixNano
+	}
+}
+
+// otlpExporter batches records by resource and posts them to the collector.
+type otlpExporter struct {
+	batchSize int
+	target    *pushTarget
+	stats     *OTLPStats
+	batch     *otlpRequest
+	byKey     map[string]*otlpScopeLogs
+	buffered  int
+}
+
+func newOTLPExporter(ctx context.Context, opts OTLPOptions) *otlpExporter {
+	if opts.Endpoint == "" {
+		opts.Endpoint = DefaultOTLPEndpoint
+	}
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = 1000
+	}
+	url := strings.TrimSuffix(opts.Endpoint, "/")
+	if !strings.HasSuffix(url, "/v1/logs") {
+		url += "/v1/logs"
+	}
+	e := &otlpExporter{
+		batchSize: opts.BatchSize,
+		target:    newPushTarget(ctx, "collector", url, opts.Client, opts.Headers, opts.MaxRetries, opts.Logger),
+		stats:     &OTLPStats{Records: map[string]int64{}},
+	}
+	e.reset()
+	return e
+}
+
+func (e *otlpExporter) reset() {
+	e.batch = &otlpRequest{}
+	e.byKey = map[string]*otlpScopeLogs{}
+	e.buffered = 0
+}
+
+// add buffers rec under resource and sends the batch once it is full.
+func (e *otlpExporter) add(resource []otlpKeyValue, rec otlpLogRecord) error {
+	var key strings.Builder
+	for _, kv := range resource {
+		key.WriteString(kv.Key + "=" + *kv.Value.StringValue + "\x00")
+	}
+	sl, ok := e.byKey[key.String()]
+	if !ok {
+		rl := &otlpResourceLogs{}
+		rl.Resource.Attributes = resource
+		sl = &otlpScopeLogs{}
+		sl.Scope.Name = otlpScope
+		rl.ScopeLogs = []*otlpScopeLogs{sl}
+		e.batch.ResourceLogs = append(e.batch.ResourceLogs, rl)
+		e.byKey[key.String()] = sl
+	}
+	sl.LogRecords = append(sl.LogRecords, rec)
+	if e.buffered++; e.buffered >= e.batchSize {
+		return e.flush()
+	}
+	return nil
+}
+
+// flush sends the buffered records.
+func (e *otlpExporter) flush() error {
+	if e.buffered == 0 {
+		return nil
+	}
+	body, err := json.Marshal(e.batch)
+	if err != nil {
+		return err
+	}
+	e.reset()
+	err = e.target.post(body)
+	e.stats.Requests = e.target.requests
+	return err
+}
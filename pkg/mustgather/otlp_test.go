@@ -0,0 +1,149 @@
+package mustgather
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func writeBundleDir(t *testing.T, files map[string]string) string {
+	t.Helper()
+	src := filepath.Join(t.TempDir(), "bundle")
+	for name, content := range files {
+		p := filepath.Join(src, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return src
+}
+
+func TestExportOTLP(t *testing.T) {
+	src := writeBundleDir(t, map[string]string{
+		"index.json": `{"tables":["ContainerLogV2","KubeEvents","Heartbeat"]}`,
+		"tables/ContainerLogV2/parts/0000-a.ndjson": strings.Join([]string{
+			`{"TimeGenerated":"2024-01-01T00:00:01Z","PodNamespace":"shop","PodName":"cart-1","ContainerName":"cart","LogSource":"stdout","LogMessage":"starting","Computer":"node-1"}`,
+			`{"TimeGenerated":"2024-01-01T00:00:02Z","PodNamespace":"shop","PodName":"cart-1","ContainerName":"cart","LogSource":"stderr","LogMessage":"panic: boom","Computer":"node-1"}`,
+			`{"TimeGenerated":"2024-01-01T00:00:03Z","PodNamespace":"shop","PodName":"web-1","ContainerName":"web","LogSource":"stdout","LogMessage":{"msg":"ok"},"LogLevel":"info"}`,
+		}, "\n") + "\n",
+		"tables/KubeEvents/parts/0000-a.ndjson": `{"TimeGenerated":"2024-01-01T00:00:04Z","Namespace":"shop","Name":"cart-1","ObjectKind":"Pod","Reason":"BackOff","Message":"Back-off restarting failed container","KubeEventType":"Warning"}` + "\n",
+		"tables/Heartbeat/parts/0000-a.ndjson":  `{"TimeGenerated":"2024-01-01T00:00:05Z","Computer":"node-1"}` + "\n",
+	})
+
+	var (
+		mu       sync.Mutex
+		requests []otlpRequest
+		throttle = 1
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/logs" || r.Header.Get("Content-Type") != "application/json" || r.Header.Get("Authorization") != "Bearer t" {
+			http.Error(w, "bad request "+r.URL.Path, http.StatusBadRequest)
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		if throttle > 0 {
+			throttle--
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		var req otlpRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		requests = append(requests, req)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	var log bytes.Buffer
+	stats, err := ExportOTLP(context.Background(), src, OTLPOptions{
+		Endpoint:   srv.URL + "/",
+		Headers:    map[string]string{"Authorization": "Bearer t"},
+		BatchSize:  2,
+		MaxRetries: 1,
+		Logger:     slog.New(slog.NewTextHandler(&log, nil)),
+	})
+	if err != nil {
+		t.Fatalf("ExportOTLP failed: %v", err)
+	}
+	if got := log.String(); !strings.Contains(got, "level=WARN") || !strings.Contains(got, "collector throttled") {
+		t.Errorf("expected a warning for the throttled request, got %q", got)
+	}
+	if stats.Records["ContainerLogV2"] != 3 || stats.Records["KubeEvents"] != 1 || stats.Records["Heartbeat"] != 0 || stats.Requests != 3 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+	if len(requests) != 2 {
+		t.Fatalf("expected two batches of two records, got %d requests", len(requests))
+	}
+
+	str := func(v otlpAnyValue) string {
+		if v.StringValue == nil {
+			return ""
+		}
+		return *v.StringValue
+	}
+	attrs := func(kvs []otlpKeyValue) string {
+		var s []string
+		for _, kv := range kvs {
+			s = append(s, kv.Key+"="+str(kv.Value))
+		}
+		return strings.Join(s, ",")
+	}
+	var got []string
+	for _, req := range requests {
+		for _, rl := range req.ResourceLogs {
+			for _, sl := range rl.ScopeLogs {
+				for _, rec := range sl.LogRecords {
+					got = append(got, fmt.Sprintf("%s | %s %s %d %s | %s | %s", attrs(rl.Resource.Attributes), sl.Scope.Name,
+						rec.TimeUnixNano, rec.SeverityNumber, rec.SeverityText, str(rec.Body), attrs(rec.Attributes)))
+				}
+			}
+		}
+	}
+	sort.Strings(got)
+	want := []string{
+		"k8s.namespace.name=shop | aks-must-gather 1704067204000000000 13 WARN | Back-off restarting failed container | event.domain=k8s,k8s.event.reason=BackOff,k8s.event.type=Warning,k8s.object.kind=Pod,k8s.object.name=cart-1",
+		"k8s.namespace.name=shop,k8s.pod.name=cart-1,k8s.container.name=cart,k8s.node.name=node-1 | aks-must-gather 1704067201000000000 9 INFO | starting | log.iostream=stdout",
+		"k8s.namespace.name=shop,k8s.pod.name=cart-1,k8s.container.name=cart,k8s.node.name=node-1 | aks-must-gather 1704067202000000000 17 ERROR | panic: boom | log.iostream=stderr",
+		"k8s.namespace.name=shop,k8s.pod.name=web-1,k8s.container.name=web | aks-must-gather 1704067203000000000 9 INFO | {\"msg\":\"ok\"} | log.iostream=stdout",
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("exported records\n got %q\nwant %q", got, want)
+	}
+	// Records of the same container share one resource
+	if n := len(requests[0].ResourceLogs); n != 1 {
+		t.Errorf("expected the first batch under one resource, got %d", n)
+	}
+}
+
+func TestExportOTLPCollectorError(t *testing.T) {
+	src := writeBundleDir(t, map[string]string{
+		"index.json":                            `{"tables":["KubeEvents"]}`,
+		"tables/KubeEvents/parts/0000-a.ndjson": `{"TimeGenerated":"2024-01-01T00:00:04Z","Namespace":"shop","Message":"m"}` + "\n",
+	})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unknown tenant", http.StatusUnauthorized)
+	}))
+	defer srv.Close()
+
+	_, err := ExportOTLP(context.Background(), src, OTLPOptions{Endpoint: srv.URL + "/v1/logs"})
+	if err == nil || !strings.Contains(err.Error(), "401") || !strings.Contains(err.Error(), "unknown tenant") {
+		t.Errorf("expected the collector's error, got %v", err)
+	}
+}
@@ -0,0 +1,251 @@
+package mustgather
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/arm"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/cloud"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+	"github.com/Azure/azure-sdk-for-go/sdk/monitor/azquery"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/operationalinsights/armoperationalinsights"
+
+	"kubectl-must-gather/pkg/utils"
+)
+
+// Preflight check statuses.
+const (
+	PreflightPass = "pass"
+	PreflightFail = "fail"
+	PreflightSkip = "skip"
+)
+
+// PreflightCheck is the outcome of one access check.
+type PreflightCheck struct {
+	Name   string `json:"name"`
+	Status string `json:"status"`
+	Detail string `json:"detail,omitempty"`
+	// Required checks must pass for a gather; the others only matter to some
+	// options, named in Detail.
+	Required bool `json:"required"`
+	// Action, MissingRole and Scope describe the role assignment that would
+	// let a denied check pass.
+	Action      string `json:"action,omitempty"`
+	MissingRole string `json:"missingRole,omitempty"`
+	Scope       string `json:"scope,omitempty"`
+}
+
+// PreflightReport lists the checks of a Preflight run in order.
+type PreflightReport struct {
+	WorkspaceID string           `json:"workspaceId"`
+	ClusterID   string           `json:"clusterId,omitempty"`
+	Checks      []PreflightCheck `json:"checks"`
+}
+
+// OK reports whether every required check passed.
+func (r *PreflightReport) OK() bool {
+	for _, c := range r.Checks {
+		if c.Required && c.Status != PreflightPass {
+			return false
+		}
+	}
+	return true
+}
+
+// preflightAccess is an RBAC action a gather needs and the least-privileged
+// built-in role granting it.
+type preflightAccess struct {
+	action, role string
+}
+
+var (
+	accessWorkspace = preflightAccess{"Microsoft.OperationalInsights/workspaces/read", "Log Analytics Reader"}
+	accessTables    = preflightAccess{"Microsoft.OperationalInsights/workspaces/tables/read", "Log Analytics Reader"}
+	accessQuery     = preflightAccess{"Microsoft.OperationalInsights/workspaces/query/read", "Log Analytics Reader"}
+	accessCluster   = preflightAccess{"Microsoft.ContainerService/managedClusters/read", "Reader"}
+)
+
+// Preflight verifies, without gathering anything, that the credential of env
+// can do what a gather does: sign in, read the workspace through the
+// management plane, list its tables (needed for --all-tables), run a query
+// through the data plane and, when clusterID is set, read the AKS cluster.
+// A denied check names the role missing and the scope to assign it at.
+// Checks that depend on a failed one are skipped.
+func Preflight(ctx context.Context, env Environment, workspaceID, clusterID string) *PreflightReport {
+	r := &PreflightReport{WorkspaceID: workspaceID, ClusterID: clusterID}
+	add := func(c PreflightCheck) bool {
+		r.Checks = append(r.Checks, c)
+		return c.Status == PreflightPass
+	}
+	skipRest := func(names ...string) {
+		for _, n := range names {
+			add(PreflightCheck{Name: n, Status: PreflightSkip, Required: n != "tables", Detail: "needs the checks above"})
+		}
+	}
+
+	cred := env.Credential
+	if cred == nil {
+		c, err := azidentity.NewDefaultAzureCredential(nil)
+		if err != nil {
+			add(PreflightCheck{Name: "credential", Status: PreflightFail, Required: true, Detail: err.Error()})
+			skipRest("workspace", "tables", "query", "cluster")
+			return r
+		}
+		cred = c
+	}
+	if !add(checkCredential(ctx, env, cred)) {
+		skipRest("workspace", "tables", "query", "cluster")
+		return r
+	}
+
+	armOpts := &arm.ClientOptions{ClientOptions: azcore.ClientOptions{Cloud: env.Cloud}}
+	if env.HTTPClient != nil {
+		armOpts.Transport = env.HTTPClient
+	}
+	guid, c := checkWorkspace(ctx, cred, armOpts, workspaceID)
+	if add(c) {
+		add(checkTables(ctx, cred, armOpts, workspaceID))
+		logsOpts := &azquery.LogsClientOptions{ClientOptions: armOpts.ClientOptions}
+		add(checkQuery(ctx, cred, logsOpts, workspaceID, guid))
+	} else {
+		skipRest("tables", "query")
+	}
+
+	if clusterID == "" {
+		add(PreflightCheck{Name: "cluster", Status: PreflightSkip, Detail: "no AKS cluster given"})
+		return r
+	}
+	add(checkCluster(ctx, env, cred, clusterID, workspaceID))
+	return r
+}
+
+func checkCredential(ctx context.Context, env Environment, cred azcore.TokenCredential) PreflightCheck {
+	c := PreflightCheck{Name: "credential", Required: true}
+	audience := cloud.AzurePublic.Services[cloud.ResourceManager].Audience
+	if svc, ok := env.Cloud.Services[cloud.ResourceManager]; ok && svc.Audience != "" {
+		audience = svc.Audience
+	}
+	tctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	if _, err := cred.GetToken(tctx, policy.TokenRequestOptions{Scopes: []string{strings.TrimSuffix(audience, "/") + "/.default"}}); err != nil {
+		c.Status, c.Detail = PreflightFail, "no token for Azure Resource Manager; sign in with 'az login' or set AZURE_* credentials: "+firstLine(err.Error())
+		return c
+	}
+	c.Status, c.Detail = PreflightPass, "signed in"
+	return c
+}
+
+func checkWorkspace(ctx context.Context, cred azcore.TokenCredential, opts *arm.ClientOptions, workspaceID string) (string, PreflightCheck) {
+	c := PreflightCheck{Name: "workspace", Required: true}
+	sub, rg, ws, err := utils.ParseResourceID(workspaceID)
+	if err != nil {
+		c.Status, c.Detail = PreflightFail, err.Error()
+		return "", c
+	}
+	wcli, err := armoperationalinsights.NewWorkspacesClient(sub, cred, opts)
+	if err != nil {
+		c.Status, c.Detail = PreflightFail, err.Error()
+		return "", c
+	}
+	w, err := wcli.Get(ctx, rg, ws, nil)
+	if err != nil {
+		return "", failCheck(c, err, accessWorkspace, workspaceID)
+	}
+	if w.Properties == nil || w.Properties.CustomerID == nil {
+		c.Status, c.Detail = PreflightFail, "workspace has no customer ID to query"
+		return "", c
+	}
+	c.Status, c.Detail = PreflightPass, "read "+ws+" (management plane)"
+	return *w.Properties.CustomerID, c
+}
+
+func checkTables(ctx context.Context, cred azcore.TokenCredential, opts *arm.ClientOptions, workspaceID string) PreflightCheck {
+	c := PreflightCheck{Name: "tables"}
+	sub, rg, ws, _ := utils.ParseResourceID(workspaceID)
+	tcli, err := armoperationalinsights.NewTablesClient(sub, cred, opts)
+	if err != nil {
+		c.Status, c.Detail = PreflightFail, err.Error()
+		return c
+	}
+	page, err := tcli.NewListByWorkspacePager(rg, ws, nil).NextPage(ctx)
+	if err != nil {
+		c = failCheck(c, err, accessTables, workspaceID)
+		c.Detail += " (needed only for --all-tables)"
+		return c
+	}
+	c.Status, c.Detail = PreflightPass, fmt.Sprintf("listed %d tables", len(page.Value))
+	return c
+}
+
+func checkQuery(ctx context.Context, cred azcore.TokenCredential, opts *azquery.LogsClientOptions, workspaceID, guid string) PreflightCheck {
+	c := PreflightCheck{Name: "query", Required: true}
+	lcli, err := azquery.NewLogsClient(cred, opts)
+	if err != nil {
+		c.Status, c.Detail = PreflightFail, err.Error()
+		return c
+	}
+	end := time.Now().UTC()
+	body := azquery.Body{Query: to.Ptr("print preflight=1"), Timespan: to.Ptr(azquery.NewTimeInterval(end.Add(-time.Hour), end))}
+	if _, err := lcli.QueryWorkspace(ctx, guid, body, nil); err != nil {
+		return failCheck(c, err, accessQuery, workspaceID)
+	}
+	c.Status, c.Detail = PreflightPass, "ran a query (data plane)"
+	return c
+}
+
+func checkCluster(ctx context.Context, env Environment, cred azcore.TokenCredential, clusterID, workspaceID string) PreflightCheck {
+	c := PreflightCheck{Name: "cluster", Required: true}
+	env.Credential = cred
+	cli, err := newARMClient(env)
+	if err != nil {
+		c.Status, c.Detail = PreflightFail, err.Error()
+		return c
+	}
+	var mc managedCluster
+	if err := armGet(ctx, cli, clusterID, &mc); err != nil {
+		return failCheck(c, err, accessCluster, clusterID)
+	}
+	c.Status, c.Detail = PreflightPass, "read "+mc.Name
+	switch ws := mc.workspace(); {
+	case ws == "":
+		c.Detail += "; Container Insights is not enabled on it"
+	case !strings.EqualFold(ws, workspaceID):
+		c.Detail += "; its Container Insights workspace is " + ws
+	}
+	return c
+}
+
+// failCheck records err on c. A 403 names the role granting access at scope;
+// a 401 means the token was refused outright, e.g. from the wrong tenant.
+func failCheck(c PreflightCheck, err error, access preflightAccess, scope string) PreflightCheck {
+	c.Status = PreflightFail
+	var re *azcore.ResponseError
+	if !errors.As(err, &re) {
+		c.Detail = firstLine(err.Error())
+		return c
+	}
+	switch re.StatusCode {
+	case http.StatusForbidden:
+		c.Action, c.MissingRole, c.Scope = access.action, access.role, scope
+		c.Detail = fmt.Sprintf("denied (%s): assign %q on %s", re.ErrorCode, access.role, scope)
+	case http.StatusUnauthorized:
+		c.Detail = fmt.Sprintf("token refused (%s); sign in to the tenant of %s", re.ErrorCode, scope)
+	case http.StatusNotFound:
+		c.Detail = fmt.Sprintf("not found (%s): check %s and the subscription", re.ErrorCode, scope)
+	default:
+		c.Detail = fmt.Sprintf("HTTP %d %s", re.StatusCode, re.ErrorCode)
+	}
+	return c
+}
+
+func firstLine(s string) string {
+	s, _, _ = strings.Cut(strings.TrimSpace(s), "\n")
+	return s
+}
@@ -0,0 +1,91 @@
+package mustgather
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"kubectl-must-gather/pkg/testhelpers"
+)
+
+func TestPreflight(t *testing.T) {
+	tests := []struct {
+		name    string
+		deny    []string
+		cluster bool
+		// want is name=status of each check, in order
+		want     string
+		wantOK   bool
+		wantRole map[string]string
+	}{
+		{
+			name:   "all access",
+			want:   "credential=pass workspace=pass tables=pass query=pass cluster=skip",
+			wantOK: true,
+		},
+		{
+			name:     "no data plane access",
+			deny:     []string{testhelpers.ActionQueryRead},
+			want:     "credential=pass workspace=pass tables=pass query=fail cluster=skip",
+			wantRole: map[string]string{"query": "Log Analytics Reader"},
+		},
+		{
+			name:     "no workspace read",
+			deny:     []string{testhelpers.ActionWorkspaceRead},
+			want:     "credential=pass workspace=fail tables=skip query=skip cluster=skip",
+			wantRole: map[string]string{"workspace": "Log Analytics Reader"},
+		},
+		{
+			name:     "table listing is optional",
+			deny:     []string{testhelpers.ActionTablesRead},
+			want:     "credential=pass workspace=pass tables=fail query=pass cluster=skip",
+			wantOK:   true,
+			wantRole: map[string]string{"tables": "Log Analytics Reader"},
+		},
+		{
+			name:    "cluster",
+			cluster: true,
+			want:    "credential=pass workspace=pass tables=pass query=pass cluster=pass",
+			wantOK:  true,
+		},
+		{
+			name:     "no cluster read",
+			deny:     []string{testhelpers.ActionClusterRead},
+			cluster:  true,
+			want:     "credential=pass workspace=pass tables=pass query=pass cluster=fail",
+			wantRole: map[string]string{"cluster": "Reader"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			emu := testhelpers.NewLogAnalyticsEmulator(testhelpers.EmulatedTable{Name: "Heartbeat"})
+			defer emu.Close()
+			var clusterID string
+			if tt.cluster {
+				clusterID = emu.AddManagedCluster(testhelpers.EmulatedCluster{Name: "shop", Monitored: true})
+			}
+			for _, a := range tt.deny {
+				emu.Deny(a)
+			}
+			env := Environment{Credential: emu.Credential(), Cloud: emu.Cloud(), HTTPClient: emu.Client()}
+
+			r := Preflight(context.Background(), env, emu.WorkspaceID(), clusterID)
+			var got []string
+			for _, c := range r.Checks {
+				got = append(got, c.Name+"="+c.Status)
+				if role := tt.wantRole[c.Name]; c.MissingRole != role {
+					t.Errorf("%s: missing role %q, want %q (%s)", c.Name, c.MissingRole, role, c.Detail)
+				}
+				if c.MissingRole != "" && (c.Action == "" || c.Scope == "" || !strings.Contains(c.Detail, c.MissingRole)) {
+					t.Errorf("%s: incomplete denial %+v", c.Name, c)
+				}
+			}
+			if strings.Join(got, " ") != tt.want {
+				t.Errorf("got checks %s, want %s", strings.Join(got, " "), tt.want)
+			}
+			if r.OK() != tt.wantOK {
+				t.Errorf("OK() = %v, want %v: %+v", r.OK(), tt.wantOK, r.Checks)
+			}
+		})
+	}
+}
@@ -0,0 +1,166 @@
+package mustgather
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Profile is a named set of tables, gathered when it is selected with
+// --profiles.
+type Profile struct {
+	Name        string
+	Description string
+	Tables      []string
+	// Filters limits the rows exported from some of Tables, by table name,
+	// to those matching a KQL predicate, e.g. `Namespace != "kube-system"`.
+	Filters map[string]string
+}
+
+// ProfileMap holds the tables of profiles by name.
+type ProfileMap map[string][]string
+
+// builtinProfiles are the profiles of every gather. aks-debug is the union of
+// podLogs, inventory and metrics.
+var builtinProfiles = []Profile{
+	{
+		Name:        "podLogs",
+		Description: "Container and Kubernetes logs",
+		Tables:      []string{"ContainerLogV2", "ContainerLog", "KubeEvents", "KubeMonAgentEvents", "Syslog"},
+	},
+	{
+		Name:        "inventory",
+		Description: "Pod, node, service and PV state and image inventory",
+		Tables:      []string{"KubePodInventory", "KubeNodeInventory", "KubeServices", "KubePVInventory", "ContainerInventory", "ContainerImageInventory", "ContainerNodeInventory", "KubeHealth"},
+	},
+	{
+		Name:        "metrics",
+		Description: "Metrics and agent heartbeat",
+		Tables:      []string{"InsightsMetrics", "Perf", "Heartbeat"},
+	},
+	{
+		Name:        "audit",
+		Description: "Control plane and audit logs; needs AKS diagnostic settings",
+		Tables:      []string{"AKSControlPlane", "AKSAudit", "AKSAuditAdmin"},
+	},
+}
+
+var (
+	profilesMu sync.RWMutex
+	profiles   = func() map[string]Profile {
+		m := map[string]Profile{}
+		for _, p := range builtinProfiles {
+			m[p.Name] = p
+		}
+		var combined []string
+		for _, name := range []string{"podLogs", "inventory", "metrics"} {
+			for _, t := range m[name].Tables {
+				if !slices.Contains(combined, t) {
+					combined = append(combined, t)
+				}
+			}
+		}
+		m["aks-debug"] = Profile{
+			Name:        "aks-debug",
+			Description: "Default: podLogs, inventory and metrics",
+			Tables:      combined,
+		}
+		return m
+	}()
+)
+
+// RegisterProfile makes p selectable with --profiles in every later gather,
+// and lists it with the built-in profiles. RegisterProfile panics if p has
+// no name or tables, a filter of a table it does not have, or the name of a
+// profile already registered or built in; it is meant to be called from init
+// functions.
+func RegisterProfile(p Profile) {
+	if p.Name == "" {
+		panic("mustgather: RegisterProfile with an empty profile name")
+	}
+	if len(p.Tables) == 0 {
+		panic("mustgather: RegisterProfile profile has no tables: " + p.Name)
+	}
+	for table := range p.Filters {
+		if !slices.Contains(p.Tables, table) {
+			panic(fmt.Sprintf("mustgather: RegisterProfile filter of %s, which profile %s does not have", table, p.Name))
+		}
+	}
+	profilesMu.Lock()
+	defer profilesMu.Unlock()
+	if _, dup := profiles[p.Name]; dup {
+		panic("mustgather: RegisterProfile called twice for " + p.Name)
+	}
+	profiles[p.Name] = copyProfile(p)
+}
+
+// ListProfiles returns the built-in and registered profiles, sorted by name.
+func ListProfiles() []Profile {
+	profilesMu.RLock()
+	defer profilesMu.RUnlock()
+	list := make([]Profile, 0, len(profiles))
+	for _, p := range profiles {
+		list = append(list, copyProfile(p))
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+// GetDefaultProfiles returns the tables of the built-in and registered
+// profiles by name. The map is the caller's to change.
+func GetDefaultProfiles() ProfileMap {
+	m := ProfileMap{}
+	for _, p := range ListProfiles() {
+		m[p.Name] = p.Tables
+	}
+	return m
+}
+
+// profileFilters returns the row filters of the tables of the profiles in
+// csv, by table. A table a selected profile has without a filter is not
+// filtered; the filters of several profiles are or-ed.
+func profileFilters(csv string) map[string]string {
+	profilesMu.RLock()
+	defer profilesMu.RUnlock()
+	var (
+		filters    = map[string][]string{}
+		unfiltered = map[string]bool{}
+	)
+	seen := map[string]bool{}
+	for _, name := range strings.Split(csv, ",") {
+		p, ok := profiles[strings.TrimSpace(name)]
+		if !ok || seen[p.Name] {
+			continue
+		}
+		seen[p.Name] = true
+		for _, table := range p.Tables {
+			if f := p.Filters[table]; f != "" {
+				filters[table] = append(filters[table], f)
+			} else {
+				unfiltered[table] = true
+			}
+		}
+	}
+	out := map[string]string{}
+	for table, fs := range filters {
+		if unfiltered[table] {
+			continue
+		}
+		if len(fs) == 1 {
+			out[table] = fs[0]
+			continue
+		}
+		out[table] = "(" + strings.Join(fs, ") or (") + ")"
+	}
+	return out
+}
+
+// copyProfile returns p with its own tables and filters.
+func copyProfile(p Profile) Profile {
+	p.Tables = slices.Clone(p.Tables)
+	p.Filters = maps.Clone(p.Filters)
+	return p
+}
@@ -0,0 +1,143 @@
+package mustgather
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	azquery "github.com/Azure/azure-sdk-for-go/sdk/monitor/azquery"
+)
+
+func init() {
+	RegisterProfile(Profile{
+		Name:        "test-nodes",
+		Description: "Heartbeat of the nodes of pool a",
+		Tables:      []string{"Heartbeat", "KubeNodeInventory"},
+		Filters:     map[string]string{"Heartbeat": `Computer startswith "aks-a"`},
+	})
+	RegisterProfile(Profile{
+		Name:    "test-nodes-b",
+		Tables:  []string{"Heartbeat"},
+		Filters: map[string]string{"Heartbeat": `Computer startswith "aks-b"`},
+	})
+}
+
+func TestListProfiles(t *testing.T) {
+	list := ListProfiles()
+	var names []string
+	for _, p := range list {
+		names = append(names, p.Name)
+	}
+	if !slices.IsSorted(names) {
+		t.Errorf("profiles not sorted: %v", names)
+	}
+	for _, want := range []string{"aks-debug", "audit", "inventory", "metrics", "podLogs", "test-nodes"} {
+		if !slices.Contains(names, want) {
+			t.Errorf("profile %s not listed in %v", want, names)
+		}
+	}
+	for _, p := range list {
+		if p.Name == "test-nodes" {
+			p.Filters["Heartbeat"] = "false"
+			p.Tables[0] = "Perf"
+		}
+	}
+	if p := ListProfiles()[slices.Index(names, "test-nodes")]; p.Tables[0] != "Heartbeat" || p.Filters["Heartbeat"] == "false" {
+		t.Errorf("ListProfiles returned the registered profile itself: %+v", p)
+	}
+	if tables := GetDefaultProfiles()["test-nodes"]; !slices.Equal(tables, []string{"Heartbeat", "KubeNodeInventory"}) {
+		t.Errorf("unexpected tables of test-nodes: %v", tables)
+	}
+}
+
+func TestRegisterProfilePanics(t *testing.T) {
+	tests := []struct {
+		name string
+		p    Profile
+		want string
+	}{
+		{"no name", Profile{Tables: []string{"Perf"}}, "empty profile name"},
+		{"no tables", Profile{Name: "test-empty"}, "no tables"},
+		{"filter of another table", Profile{Name: "test-other", Tables: []string{"Perf"}, Filters: map[string]string{"Heartbeat": "true"}}, "does not have"},
+		{"built in", Profile{Name: "podLogs", Tables: []string{"Perf"}}, "called twice"},
+		{"registered", Profile{Name: "test-nodes", Tables: []string{"Perf"}}, "called twice"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer func() {
+				r := recover()
+				if msg, _ := r.(string); !strings.Contains(msg, tt.want) {
+					t.Errorf("expected a panic with %q, got %v", tt.want, r)
+				}
+			}()
+			RegisterProfile(tt.p)
+		})
+	}
+}
+
+func TestProfileFilters(t *testing.T) {
+	tests := []struct {
+		csv  string
+		want map[string]string
+	}{
+		{"aks-debug", map[string]string{}},
+		{"test-nodes", map[string]string{"Heartbeat": `Computer startswith "aks-a"`}},
+		{"test-nodes, test-nodes", map[string]string{"Heartbeat": `Computer startswith "aks-a"`}},
+		{"test-nodes,test-nodes-b", map[string]string{"Heartbeat": `(Computer startswith "aks-a") or (Computer startswith "aks-b")`}},
+		// metrics gathers all of Heartbeat
+		{"test-nodes,metrics", map[string]string{}},
+	}
+	for _, tt := range tests {
+		got := profileFilters(tt.csv)
+		if len(got) != len(tt.want) {
+			t.Errorf("profileFilters(%q) = %v, want %v", tt.csv, got, tt.want)
+			continue
+		}
+		for table, f := range tt.want {
+			if got[table] != f {
+				t.Errorf("profileFilters(%q)[%s] = %q, want %q", tt.csv, table, got[table], f)
+			}
+		}
+	}
+}
+
+func TestGatherRegisteredProfile(t *testing.T) {
+	ts := time.Now().Add(-10 * time.Minute).UTC().Format(time.RFC3339Nano)
+	logs := &fakeLogs{rows: map[string][]azquery.Row{"Heartbeat": {{ts, "aks-a-1"}}}}
+	sink := &memorySink{files: map[string]string{}}
+	config := &Config{
+		WorkspaceID: "/subscriptions/sub/resourceGroups/rg/providers/Microsoft.OperationalInsights/workspaces/ws",
+		Timespan:    "PT1H",
+		Profiles:    "test-nodes",
+		Quiet:       true,
+	}
+	g, err := NewGatherer(context.Background(), config, WithCredential(noCredential{}), WithLogsClient(logs),
+		WithWorkspacesClient(fakeWorkspaces{}), WithTablesClient(fakeTables{names: []string{"Heartbeat", "KubeNodeInventory"}}), WithSink(sink))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := g.Run()
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if len(r.Tables) != 2 {
+		t.Errorf("expected the 2 tables of test-nodes, got %+v", r.Tables)
+	}
+	var filtered, unfiltered bool
+	for _, q := range logs.queries {
+		switch {
+		case strings.HasPrefix(q, "Heartbeat"):
+			filtered = strings.Contains(q, `| where Computer startswith "aks-a"`)
+		case strings.HasPrefix(q, "KubeNodeInventory"):
+			unfiltered = !strings.Contains(q, "aks-a")
+		}
+	}
+	if !filtered || !unfiltered {
+		t.Errorf("expected only Heartbeat to be filtered, got queries %q", logs.queries)
+	}
+	if sum := sink.files["tables/Heartbeat/summary.json"]; !strings.Contains(sum, `"filter"`) {
+		t.Errorf("expected the filter in the summary, got %s", sum)
+	}
+}
@@ -0,0 +1,99 @@
+package mustgather
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// --progress-format values. Text progress goes to stderr only; json also
+// streams newline-delimited progress events to stdout.
+const (
+	ProgressText = "text"
+	ProgressJSON = "json"
+)
+
+// Progress event names of the json stream.
+const (
+	progressGatherStarted = "gather_started"
+	progressTableStarted  = "table_started"
+	progressChunkDone     = "chunk_done"
+	progressTableDone     = "table_done"
+	progressWarning       = "warning"
+	progressGatherDone    = "gather_done"
+)
+
+// progressStream writes progress events as JSON lines. A nil stream, used for
+// text progress, drops them. Chunks are queried concurrently, so writes are
+// serialized.
+type progressStream struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// newProgressStream returns the stream for format, writing to w.
+func newProgressStream(format string, w io.Writer) *progressStream {
+	if format != ProgressJSON {
+		return nil
+	}
+	return &progressStream{w: w}
+}
+
+// emit writes one event with the given fields, stamped with the current time.
+func (p *progressStream) emit(event string, fields map[string]any) {
+	if p == nil {
+		return
+	}
+	ev := map[string]any{"time": time.Now().UTC().Format(time.RFC3339Nano), "event": event}
+	for k, v := range fields {
+		ev[k] = v
+	}
+	b, err := json.Marshal(ev)
+	if err != nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	_, _ = p.w.Write(append(b, '\n'))
+}
+
+// progressDone reports the end of a gather, successful or not.
+func (g *Gatherer) progressDone(err error) {
+	if g.progress == nil {
+		return
+	}
+	var rows, bytes int64
+	for _, o := range g.outcomes {
+		rows += int64(o.rows)
+		bytes += o.bytes
+	}
+	fields := map[string]any{"rows": rows, "bytes": bytes, "incomplete": g.incomplete()}
+	if g.outFile != "" {
+		fields["output"] = g.outFile
+	}
+	if err != nil {
+		fields["error"] = err.Error()
+	}
+	g.progress.emit(progressGatherDone, fields)
+}
+
+// warnf prints a gather warning to stderr and reports it as a warning event
+// and to the hooks.
+// table may be empty for warnings not about a table.
+func (g *Gatherer) warnf(table, format string, args ...any) {
+	msg := fmt.Sprintf(format, args...)
+	if lw, ok := g.log.(*logWriter); ok {
+		lw.warn(table, msg)
+	} else {
+		fmt.Fprintf(g.log, "  warn: %s\n", msg)
+	}
+	fields := map[string]any{"message": msg}
+	if table != "" {
+		fields["table"] = table
+	}
+	g.addWarning(msg)
+	g.progress.emit(progressWarning, fields)
+	g.hooks.warning(table, msg)
+}
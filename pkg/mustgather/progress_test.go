@@ -0,0 +1,72 @@
+package mustgather
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestProgressStream(t *testing.T) {
+	tests := []struct {
+		name   string
+		format string
+		lines  int
+	}{
+		{name: "json", format: ProgressJSON, lines: 20},
+		{name: "text", format: ProgressText},
+		{name: "default", format: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			g := &Gatherer{progress: newProgressStream(tt.format, &out), log: io.Discard}
+			// Chunks report concurrently
+			var wg sync.WaitGroup
+			for i := 0; i < 10; i++ {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					g.progress.emit(progressChunkDone, map[string]any{"table": "KubeEvents", "chunk": i + 1, "rows": 0})
+					g.warnf("KubeEvents", "chunk %d slow", i+1)
+				}(i)
+			}
+			wg.Wait()
+
+			if out.Len() == 0 {
+				if tt.lines != 0 {
+					t.Fatal("expected progress events")
+				}
+				return
+			}
+			lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+			if len(lines) != tt.lines {
+				t.Fatalf("expected %d lines, got %d:\n%s", tt.lines, len(lines), out.String())
+			}
+			counts := map[string]int{}
+			for _, line := range lines {
+				var ev map[string]any
+				if err := json.Unmarshal([]byte(line), &ev); err != nil {
+					t.Fatalf("invalid event %q: %v", line, err)
+				}
+				if ev["time"] == "" || ev["table"] != "KubeEvents" {
+					t.Errorf("unexpected event %v", ev)
+				}
+				if ev["event"] == progressChunkDone {
+					if _, ok := ev["rows"]; !ok {
+						t.Errorf("zero rows dropped from %v", ev)
+					}
+				}
+				if ev["event"] == progressWarning && !strings.HasPrefix(toStr(ev["message"]), "chunk ") {
+					t.Errorf("unexpected warning %v", ev)
+				}
+				counts[toStr(ev["event"])]++
+			}
+			if counts[progressChunkDone] != 10 || counts[progressWarning] != 10 {
+				t.Errorf("unexpected event counts %v", counts)
+			}
+		})
+	}
+}
@@ -0,0 +1,184 @@
+package mustgather
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"os"
+	"os/user"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/cloud"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+
+	"kubectl-must-gather/pkg/utils"
+	"kubectl-must-gather/pkg/version"
+)
+
+// provenancePath records who gathered the archive, from where, how, and
+// exactly which queries ran and which files were written, for security
+// reviews of what data left the workspace.
+const provenancePath = "metadata/provenance.json"
+
+// provenanceIdentity is the Entra ID identity of the gather's credential,
+// read from the claims of its Azure Resource Manager token.
+type provenanceIdentity struct {
+	TenantID string `json:"tenantId,omitempty"`
+	ObjectID string `json:"objectId,omitempty"`
+	// UserPrincipalName is empty for service principals and managed
+	// identities, which have AppID instead.
+	UserPrincipalName string `json:"userPrincipalName,omitempty"`
+	Name              string `json:"name,omitempty"`
+	AppID             string `json:"appId,omitempty"`
+	Type              string `json:"type,omitempty"`
+	Error             string `json:"error,omitempty"`
+}
+
+// provenanceHost is the machine the gather ran on.
+type provenanceHost struct {
+	Hostname string `json:"hostname,omitempty"`
+	User     string `json:"user,omitempty"`
+	Platform string `json:"platform"`
+}
+
+// provenanceQuery is a KQL query of the gather over [Start, End). Cached
+// queries were answered from --cache-dir without reaching the workspace.
+type provenanceQuery struct {
+	Query  string `json:"query"`
+	Start  string `json:"start"`
+	End    string `json:"end"`
+	Cached bool   `json:"cached,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// provenanceLog collects the queries of a gather; chunks are queried
+// concurrently.
+type provenanceLog struct {
+	mu      sync.Mutex
+	queries []provenanceQuery
+}
+
+func (p *provenanceLog) record(query string, t0, t1 time.Time, cached bool, err error) {
+	q := provenanceQuery{Query: query, Start: t0.UTC().Format(time.RFC3339), End: t1.UTC().Format(time.RFC3339), Cached: cached}
+	if err != nil {
+		q.Error = firstLine(err.Error())
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.queries = append(p.queries, q)
+}
+
+// writeProvenance writes provenance.json, listing every file written to tarw
+// before it. It is the last file of the archive.
+func (g *Gatherer) writeProvenance(tarw *utils.TarWriter) error {
+	host := provenanceHost{Platform: runtime.GOOS + "/" + runtime.GOARCH}
+	host.Hostname, _ = os.Hostname()
+	if u, err := user.Current(); err == nil {
+		host.User = u.Username
+	}
+	g.provenance.mu.Lock()
+	queries := append([]provenanceQuery{}, g.provenance.queries...)
+	g.provenance.mu.Unlock()
+	if anon := g.config.Anonymizer; anon != nil {
+		// Scoped queries name namespaces
+		for i := range queries {
+			queries[i].Query = anon.text(queries[i].Query)
+		}
+	}
+
+	doc := map[string]any{
+		"generatedAt": time.Now().UTC().Format(time.RFC3339),
+		"startedAt":   g.startedAt.UTC().Format(time.RFC3339),
+		"tool":        version.Get().String(),
+		"identity":    resolveIdentity(g.ctx, g.cred, g.cloud),
+		"host":        host,
+		"workspaceId": g.config.WorkspaceID,
+		"window":      map[string]string{"start": g.start.UTC().Format(time.RFC3339), "end": g.end.UTC().Format(time.RFC3339)},
+		"flags":       g.config.Flags,
+		"queries":     queries,
+		"files":       tarw.Files(),
+	}
+	if g.config.Redaction != nil {
+		doc["redactionRules"] = g.config.Redaction.ruleNames()
+	}
+	if g.scope != nil {
+		doc["scope"] = g.scope.summary(g.config.Anonymizer)
+	}
+	if g.restricted != nil {
+		// The unredacted rows went to this archive too
+		doc["restrictedArchive"] = filepath.Base(g.restricted.path)
+	}
+	b, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return err
+	}
+	return utils.WriteFileToTar(tarw, provenancePath, b)
+}
+
+// resolveIdentity reads who the credential signs in as from the claims of an
+// Azure Resource Manager token. The token was just issued to this process by
+// Entra ID, so its claims are read without checking the signature.
+func resolveIdentity(ctx context.Context, cred azcore.TokenCredential, cfg cloud.Configuration) provenanceIdentity {
+	audience := cloud.AzurePublic.Services[cloud.ResourceManager].Audience
+	if svc, ok := cfg.Services[cloud.ResourceManager]; ok && svc.Audience != "" {
+		audience = svc.Audience
+	}
+	// Not cancelled with the gather, so interrupted archives record it too
+	tctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 30*time.Second)
+	defer cancel()
+	tok, err := cred.GetToken(tctx, policy.TokenRequestOptions{Scopes: []string{strings.TrimSuffix(audience, "/") + "/.default"}})
+	if err != nil {
+		return provenanceIdentity{Error: firstLine(err.Error())}
+	}
+	claims, err := tokenClaims(tok.Token)
+	if err != nil {
+		return provenanceIdentity{Error: err.Error()}
+	}
+	id := provenanceIdentity{
+		TenantID: claims["tid"],
+		ObjectID: claims["oid"],
+		Name:     claims["name"],
+		AppID:    claims["appid"],
+		Type:     claims["idtyp"],
+	}
+	if id.Type != "app" {
+		for _, c := range []string{"upn", "preferred_username", "unique_name"} {
+			if id.UserPrincipalName = claims[c]; id.UserPrincipalName != "" {
+				break
+			}
+		}
+	}
+	if id.AppID == "" {
+		id.AppID = claims["azp"]
+	}
+	return id
+}
+
+// tokenClaims returns the string claims of a JWT.
+func tokenClaims(token string) (map[string]string, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("access token is not a JWT")
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return nil, errors.New("access token payload is not base64url")
+	}
+	var raw map[string]any
+	if err := json.Unmarshal(payload, &raw); err != nil {
+		return nil, errors.New("access token payload is not JSON")
+	}
+	claims := map[string]string{}
+	for k, v := range raw {
+		if s, ok := v.(string); ok {
+			claims[k] = s
+		}
+	}
+	return claims, nil
+}
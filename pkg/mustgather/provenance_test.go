@@ -0,0 +1,95 @@
+package mustgather
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/cloud"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+)
+
+type tokenCredential struct {
+	token string
+	err   error
+}
+
+func (c tokenCredential) GetToken(ctx context.Context, opts policy.TokenRequestOptions) (azcore.AccessToken, error) {
+	return azcore.AccessToken{Token: c.token, ExpiresOn: time.Now().Add(time.Hour)}, c.err
+}
+
+func testJWT(claims map[string]any) string {
+	b, _ := json.Marshal(claims)
+	return "eyJhbGciOiJub25lIn0." + base64.RawURLEncoding.EncodeToString(b) + ".sig"
+}
+
+func TestResolveIdentity(t *testing.T) {
+	tests := []struct {
+		name string
+		cred tokenCredential
+		want provenanceIdentity
+	}{
+		{
+			name: "user",
+			cred: tokenCredential{token: testJWT(map[string]any{"tid": "t", "oid": "o", "upn": "alice@contoso.com", "name": "Alice", "idtyp": "user", "appid": "04b07795-8ddb-461a-bbee-02f9e1bf7b46", "iat": 1700000000})},
+			want: provenanceIdentity{TenantID: "t", ObjectID: "o", UserPrincipalName: "alice@contoso.com", Name: "Alice", AppID: "04b07795-8ddb-461a-bbee-02f9e1bf7b46", Type: "user"},
+		},
+		{
+			name: "guest user",
+			cred: tokenCredential{token: testJWT(map[string]any{"tid": "t", "oid": "o", "unique_name": "live.com#bob@example.com"})},
+			want: provenanceIdentity{TenantID: "t", ObjectID: "o", UserPrincipalName: "live.com#bob@example.com"},
+		},
+		{
+			name: "service principal",
+			cred: tokenCredential{token: testJWT(map[string]any{"tid": "t", "oid": "o", "azp": "app-1", "idtyp": "app", "unique_name": "o"})},
+			want: provenanceIdentity{TenantID: "t", ObjectID: "o", AppID: "app-1", Type: "app"},
+		},
+		{
+			name: "not a JWT",
+			cred: tokenCredential{token: "opaque"},
+			want: provenanceIdentity{Error: "access token is not a JWT"},
+		},
+		{
+			name: "bad payload",
+			cred: tokenCredential{token: "a.!!.c"},
+			want: provenanceIdentity{Error: "access token payload is not base64url"},
+		},
+		{
+			name: "no token",
+			cred: tokenCredential{err: errors.New("DefaultAzureCredential: failed to acquire a token.\nAttempted credentials: ...")},
+			want: provenanceIdentity{Error: "DefaultAzureCredential: failed to acquire a token."},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := resolveIdentity(context.Background(), tt.cred, cloud.AzurePublic); got != tt.want {
+				t.Errorf("resolveIdentity = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestProvenanceLog(t *testing.T) {
+	var p provenanceLog
+	t0 := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	p.record("Heartbeat", t0, t0.Add(time.Hour), false, nil)
+	p.record("Perf", t0, t0.Add(time.Hour), true, nil)
+	p.record("Nope", t0, t0.Add(time.Hour), false, errors.New("table not found\ndetails"))
+	want := []provenanceQuery{
+		{Query: "Heartbeat", Start: "2024-01-01T00:00:00Z", End: "2024-01-01T01:00:00Z"},
+		{Query: "Perf", Start: "2024-01-01T00:00:00Z", End: "2024-01-01T01:00:00Z", Cached: true},
+		{Query: "Nope", Start: "2024-01-01T00:00:00Z", End: "2024-01-01T01:00:00Z", Error: "table not found"},
+	}
+	if len(p.queries) != len(want) {
+		t.Fatalf("unexpected queries %+v", p.queries)
+	}
+	for i := range want {
+		if p.queries[i] != want[i] {
+			t.Errorf("query %d = %+v, want %+v", i, p.queries[i], want[i])
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package mustgather
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"time"
+)
+
+// archiveStampLayout is the time in the names of timestamped archives, e.g.
+// must-gather-20260101-120000.tar.gz, as the default --out and rotated watch
+// archives are named.
+const archiveStampLayout = "20060102-150405"
+
+// archiveStampRE splits a file name into the base name, the time of its
+// gather and the extension of the archive, encrypted archive, manifest or
+// signature.
+var archiveStampRE = regexp.MustCompile(`^(.+)-(\d{8}-\d{6})(\..*)?$`)
+
+// archiveStamp returns the base name and gather time of a timestamped file
+// name, or false for other names.
+func archiveStamp(name string) (base string, t time.Time, ok bool) {
+	m := archiveStampRE.FindStringSubmatch(name)
+	if m == nil {
+		return "", time.Time{}, false
+	}
+	t, err := time.ParseInLocation(archiveStampLayout, m[2], time.Local)
+	if err != nil {
+		return "", time.Time{}, false
+	}
+	return m[1], t, true
+}
+
+// expiredArchives returns the names of the gathers with the given base name
+// that retention removes: those beyond the keep newest, and those older than
+// maxAge. Zero keep or maxAge does not limit. The files of a gather share its
+// time, so they go together.
+func expiredArchives(names []string, base string, keep int, maxAge time.Duration, now time.Time) []string {
+	byStamp := map[time.Time][]string{}
+	for _, n := range names {
+		if b, t, ok := archiveStamp(n); ok && b == base {
+			byStamp[t] = append(byStamp[t], n)
+		}
+	}
+	stamps := make([]time.Time, 0, len(byStamp))
+	for t := range byStamp {
+		stamps = append(stamps, t)
+	}
+	sort.Slice(stamps, func(i, j int) bool { return stamps[i].After(stamps[j]) })
+	var expired []string
+	for i, t := range stamps {
+		if (keep > 0 && i >= keep) || (maxAge > 0 && now.Sub(t) > maxAge) {
+			expired = append(expired, byStamp[t]...)
+		}
+	}
+	sort.Strings(expired)
+	return expired
+}
+
+// pruneOutputs applies Keep and KeepDays to the earlier gathers named like
+// this one's archive and restricted archive, next to them and in the UploadTo
+// container. Pruning is housekeeping: failures are warnings.
+func (g *Gatherer) pruneOutputs() {
+	maxAge := time.Duration(g.config.KeepDays) * 24 * time.Hour
+	now := time.Now()
+	var up *blobUploader
+	if g.config.UploadTo != "" {
+		var err error
+		if up, err = newBlobUploader(g.ctx, g.config.UploadTo, g.cred, g.client); err != nil {
+			g.warnf("", "prune uploaded archives: %v", err)
+		}
+	}
+	for _, f := range []string{g.outFile, g.restrictedFile} {
+		if f == "" {
+			continue
+		}
+		base, _, ok := archiveStamp(filepath.Base(f))
+		if !ok {
+			g.warnf("", "--keep and --keep-days prune timestamped archives like must-gather-%s.tar.gz; %s has no time in its name", time.Now().Format(archiveStampLayout), f)
+			continue
+		}
+		dir := filepath.Dir(f)
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			g.warnf("", "prune archives: %v", err)
+			continue
+		}
+		var names []string
+		for _, e := range entries {
+			if e.Type().IsRegular() {
+				names = append(names, e.Name())
+			}
+		}
+		expired := expiredArchives(names, base, g.config.Keep, maxAge, now)
+		for _, n := range expired {
+			if err := os.Remove(filepath.Join(dir, n)); err != nil {
+				g.warnf("", "prune archives: %v", err)
+			}
+		}
+		if len(expired) > 0 {
+			fmt.Fprintf(g.log, "Removed %d file(s) of earlier gathers from %s\n", len(expired), dir)
+		}
+		if up == nil {
+			continue
+		}
+		names, err = up.list(base + "-")
+		if err != nil {
+			g.warnf("", "prune uploaded archives: %v", err)
+			continue
+		}
+		expired = expiredArchives(names, base, g.config.Keep, maxAge, now)
+		for _, n := range expired {
+			if err := up.remove(n); err != nil {
+				g.warnf("", "prune uploaded archives: %v", err)
+			}
+		}
+		if len(expired) > 0 {
+			fmt.Fprintf(g.log, "Removed %d blob(s) of earlier gathers from %s\n", len(expired), up.redacted())
+		}
+	}
+}
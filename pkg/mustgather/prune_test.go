@@ -0,0 +1,119 @@
+package mustgather
+
+import (
+	"context"
+	"io"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestArchiveStamp(t *testing.T) {
+	tests := []struct {
+		name, base string
+		ok         bool
+	}{
+		{"must-gather-20260102-030405.tar.gz", "must-gather", true},
+		{"prod-20260102-030405.tar.gz.age.manifest.json.sig", "prod", true},
+		{"prod-restricted-20260102-030405", "prod-restricted", true},
+		{"must-gather-20260102-030405-support", "", false},
+		{"must-gather.tar.gz", "", false},
+		{"must-gather-20261302-030405.tar.gz", "", false},
+	}
+	for _, tt := range tests {
+		base, ts, ok := archiveStamp(tt.name)
+		if ok != tt.ok || base != tt.base {
+			t.Errorf("archiveStamp(%q) = %q, %v", tt.name, base, ok)
+		}
+		if ok && !ts.Equal(time.Date(2026, 1, 2, 3, 4, 5, 0, time.Local)) {
+			t.Errorf("archiveStamp(%q) time = %v", tt.name, ts)
+		}
+	}
+}
+
+func TestExpiredArchives(t *testing.T) {
+	now := time.Date(2026, 1, 10, 12, 0, 0, 0, time.Local)
+	names := []string{
+		"mg-20260110-110000.tar.gz", "mg-20260110-110000.tar.gz.manifest.json",
+		"mg-20260109-110000.tar.gz",
+		"mg-20260105-110000.tar.gz", "mg-20260105-110000.tar.gz.manifest.json",
+		"other-20260101-110000.tar.gz", "mg.watch.json",
+	}
+	tests := []struct {
+		name     string
+		keep     int
+		maxAge   time.Duration
+		expected string
+	}{
+		{"unlimited", 0, 0, ""},
+		{"keep 2", 2, 0, "mg-20260105-110000.tar.gz,mg-20260105-110000.tar.gz.manifest.json"},
+		{"keep 1", 1, 0, "mg-20260105-110000.tar.gz,mg-20260105-110000.tar.gz.manifest.json,mg-20260109-110000.tar.gz"},
+		{"3 days", 0, 3 * 24 * time.Hour, "mg-20260105-110000.tar.gz,mg-20260105-110000.tar.gz.manifest.json"},
+		{"keep 5 and 1 hour", 5, time.Hour, "mg-20260105-110000.tar.gz,mg-20260105-110000.tar.gz.manifest.json,mg-20260109-110000.tar.gz"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := strings.Join(expiredArchives(names, "mg", tt.keep, tt.maxAge, now), ",")
+			if got != tt.expected {
+				t.Errorf("expired = %s, want %s", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestPruneOutputs(t *testing.T) {
+	dir := t.TempDir()
+	stamp := func(ago time.Duration) string { return time.Now().Add(-ago).Format(archiveStampLayout) }
+	var files []string
+	for _, ago := range []time.Duration{0, time.Hour, 50 * time.Hour} {
+		files = append(files, "mg-"+stamp(ago)+".tar.gz")
+	}
+	files = append(files, "unrelated.txt")
+	svc := &fakeBlobService{blocks: map[string]string{}, lists: map[string]string{}}
+	for _, f := range files {
+		if err := os.WriteFile(filepath.Join(dir, f), nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		svc.lists["/diag/"+f] = ""
+	}
+	srv := httptest.NewServer(svc)
+	defer srv.Close()
+
+	g := &Gatherer{
+		ctx:     context.Background(),
+		config:  &Config{KeepDays: 1, UploadTo: srv.URL + "/diag?sig=abc"},
+		client:  srv.Client(),
+		log:     io.Discard,
+		outFile: filepath.Join(dir, files[0]),
+	}
+	g.pruneOutputs()
+	left, _ := os.ReadDir(dir)
+	var names []string
+	for _, e := range left {
+		names = append(names, e.Name())
+	}
+	want := []string{files[1], files[0], "unrelated.txt"}
+	sort.Strings(want)
+	if strings.Join(names, ",") != strings.Join(want, ",") {
+		t.Errorf("files left %v, want %v", names, want)
+	}
+	var blobs []string
+	for p := range svc.lists {
+		blobs = append(blobs, strings.TrimPrefix(p, "/diag/"))
+	}
+	sort.Strings(blobs)
+	if strings.Join(blobs, ",") != strings.Join(want, ",") {
+		t.Errorf("blobs left %v, want %v", blobs, want)
+	}
+
+	// An archive without a time in its name is not pruned
+	g.outFile = filepath.Join(dir, "unrelated.txt")
+	g.pruneOutputs()
+	if len(g.warns) != 1 || !strings.Contains(g.warns[0], "no time in its name") {
+		t.Errorf("warnings = %v", g.warns)
+	}
+}
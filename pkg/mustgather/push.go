@@ -0,0 +1,72 @@
+package mustgather
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// pushTarget is a log backend the export commands send JSON batches to.
+type pushTarget struct {
+	ctx     context.Context
+	client  *http.Client
+	url     string
+	headers map[string]string
+	// maxRetries bounds the retries of a throttled (HTTP 429/503) request.
+	maxRetries int
+	// name is the kind of backend, for messages.
+	name     string
+	requests int
+	// log receives the retry warnings; nil drops them.
+	log Logger
+}
+
+func newPushTarget(ctx context.Context, name, url string, client *http.Client, headers map[string]string, maxRetries int, log Logger) *pushTarget {
+	if client == nil {
+		client = &http.Client{Timeout: time.Minute}
+	}
+	return &pushTarget{ctx: ctx, client: client, url: url, headers: headers, maxRetries: maxRetries, name: name, log: log}
+}
+
+// post sends body, retrying with backoff honoring Retry-After while the
+// backend throttles. Other non-2xx responses fail with the backend's message.
+func (p *pushTarget) post(body []byte) error {
+	for attempt := 0; ; attempt++ {
+		req, err := http.NewRequestWithContext(p.ctx, http.MethodPost, p.url, bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		for k, v := range p.headers {
+			req.Header.Set(k, v)
+		}
+		p.requests++
+		resp, err := p.client.Do(req)
+		if err != nil {
+			return fmt.Errorf("send logs: %w", err)
+		}
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		resp.Body.Close()
+		if resp.StatusCode/100 == 2 {
+			return nil
+		}
+		throttled := resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusServiceUnavailable
+		if !throttled || attempt >= p.maxRetries {
+			return fmt.Errorf("send logs: %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+		}
+		delay, ok := retryAfter(resp.Header.Get("Retry-After"))
+		if !ok {
+			delay = backoff(attempt)
+		}
+		if p.log != nil {
+			p.log.Warn(fmt.Sprintf("%s throttled, retrying in %s (attempt %d/%d)", p.name, delay.Round(time.Millisecond), attempt+1, p.maxRetries))
+		}
+		if err := sleepCtx(p.ctx, delay); err != nil {
+			return err
+		}
+	}
+}
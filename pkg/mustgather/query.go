@@ -0,0 +1,246 @@
+package mustgather
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+	azquery "github.com/Azure/azure-sdk-for-go/sdk/monitor/azquery"
+
+	"kubectl-must-gather/pkg/utils"
+)
+
+// QueryOptions is what Query runs besides the workspace, window and query
+// limits of its Config.
+type QueryOptions struct {
+	KQL string
+	// Chunk splits the window into the chunks a gather queries and
+	// concatenates their results, splitting truncated chunks further. Only
+	// queries returning rows, not aggregating them, give the same result
+	// chunked.
+	Chunk bool
+}
+
+// QueryResult is the result of an ad-hoc query. Rows are keyed by column
+// name, in the order of Columns.
+type QueryResult struct {
+	WorkspaceID string           `json:"workspaceID"`
+	Query       string           `json:"query"`
+	Start       string           `json:"start"`
+	End         string           `json:"end"`
+	Columns     []exportColumn   `json:"columns"`
+	Rows        []map[string]any `json:"rows"`
+	// Truncated lists the "<start>/<end>" windows whose result the service
+	// capped.
+	Truncated []string `json:"truncated,omitempty"`
+	Queries   int64    `json:"queries"`
+	Retries   int64    `json:"retries,omitempty"`
+}
+
+// Query runs opts.KQL against the workspace of config.WorkspaceID over the
+// window of config.Timespan (or config.Since), with the credential,
+// throttling retries and query rate of a gather.
+func Query(ctx context.Context, config *Config, env Environment, opts QueryOptions) (*QueryResult, error) {
+	query := strings.TrimSpace(opts.KQL)
+	if query == "" {
+		return nil, errors.New("empty query")
+	}
+	iso, err := utils.ISO8601Duration(config.Timespan)
+	if err != nil {
+		return nil, fmt.Errorf("invalid timespan: %w", err)
+	}
+	g := &Gatherer{config: config, ctx: ctx, cred: env.Credential, cloud: env.Cloud, client: env.HTTPClient, log: config.logOutput(),
+		lcli: env.LogsClient, wcli: env.WorkspacesClient}
+	if g.cred == nil {
+		cred, err := azidentity.NewDefaultAzureCredential(nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to init credential: %w", err)
+		}
+		g.cred = cred
+	}
+	g.usage = newUsageTracker(g.client)
+	defer g.usage.stop()
+	g.limiter = newRateLimiter(config.QueryRate)
+	if g.cache, err = newQueryCache(config.CacheDir); err != nil {
+		return nil, err
+	}
+
+	sub, rg, ws, err := utils.ParseResourceID(config.WorkspaceID)
+	if err != nil {
+		return nil, fmt.Errorf("parse workspace-id: %w", err)
+	}
+	wcli, err := g.workspacesClient(sub)
+	if err != nil {
+		return nil, err
+	}
+	w, err := wcli.Get(ctx, rg, ws, nil)
+	if err != nil {
+		return nil, fmt.Errorf("get workspace: %w", workspaceError(err))
+	}
+	if w.Properties == nil || w.Properties.CustomerID == nil {
+		return nil, markErr(ErrWorkspaceNotFound, errors.New("could not determine workspace GUID from workspace; check permissions or workspace-id"))
+	}
+	lcli, err := g.logsClient()
+	if err != nil {
+		return nil, err
+	}
+
+	start, end := g.timeWindow(iso)
+	r := &QueryResult{
+		WorkspaceID: config.WorkspaceID,
+		Query:       query,
+		Start:       start.UTC().Format(time.RFC3339),
+		End:         end.UTC().Format(time.RFC3339),
+		Columns:     []exportColumn{},
+		Rows:        []map[string]any{},
+	}
+	var tables []*azquery.Table
+	if opts.Chunk {
+		tables, r.Truncated, err = g.queryChunks(lcli, *w.Properties.CustomerID, query, start, end)
+	} else {
+		var tab *azquery.Table
+		var truncated bool
+		tab, _, truncated, err = g.queryChunk(g.ctx, lcli, *w.Properties.CustomerID, query, start, end, end.Sub(start))
+		if truncated {
+			r.Truncated = append(r.Truncated, r.Start+"/"+r.End)
+		}
+		if tab != nil {
+			tables = append(tables, tab)
+		}
+	}
+	usage := g.usage.stop()
+	r.Queries, r.Retries = usage.Queries, usage.Retries
+	if err != nil {
+		return nil, classify(err)
+	}
+
+	seen := map[string]bool{}
+	for _, tab := range tables {
+		cols := resultColumns(tab)
+		for _, c := range cols {
+			if !seen[c.Name] {
+				seen[c.Name] = true
+				r.Columns = append(r.Columns, c)
+			}
+		}
+		for _, row := range tab.Rows {
+			obj := make(map[string]any, len(row))
+			for i, v := range row {
+				if i < len(cols) {
+					obj[cols[i].Name] = typedValue(cols[i].Type, v)
+				}
+			}
+			r.Rows = append(r.Rows, obj)
+		}
+	}
+	return r, nil
+}
+
+// queryChunks queries [start, end) in the aligned chunks of a table export,
+// up to Parallelism at a time, and returns their results in time order.
+func (g *Gatherer) queryChunks(lcli LogsQueryClient, workspaceGUID, query string, start, end time.Time) ([]*azquery.Table, []string, error) {
+	chunk := time.Hour
+	if end.Sub(start) <= 2*time.Hour {
+		chunk = 15 * time.Minute
+	}
+	var windows [][2]time.Time
+	for t0, t1 := start, start; t0.Before(end); t0 = t1 {
+		t1 = t0.Truncate(chunk).Add(chunk)
+		if t1.After(end) {
+			t1 = end
+		}
+		windows = append(windows, [2]time.Time{t0, t1})
+	}
+
+	results := make([]rangeResult, len(windows))
+	errs := make([]error, len(windows))
+	sem := make(chan struct{}, g.parallelism())
+	var wg sync.WaitGroup
+	for i, w := range windows {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			results[i], errs[i] = g.queryRange(g.ctx, lcli, workspaceGUID, query, w[0], w[1], chunk)
+		}()
+	}
+	wg.Wait()
+
+	var tables []*azquery.Table
+	var truncated []string
+	for i, res := range results {
+		if errs[i] != nil {
+			return nil, nil, fmt.Errorf("query %s..%s: %w", windows[i][0].UTC().Format(time.RFC3339), windows[i][1].UTC().Format(time.RFC3339), errs[i])
+		}
+		tables = append(tables, res.tables...)
+		truncated = append(truncated, res.truncated...)
+	}
+	return tables, truncated, nil
+}
+
+// cellText renders a value of a row as a table or CSV cell.
+func cellText(v any) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case json.RawMessage:
+		return string(v)
+	case map[string]any, []any:
+		b, _ := json.Marshal(v)
+		return string(b)
+	}
+	return fmt.Sprint(v)
+}
+
+// Render writes the rows of r as an aligned text table.
+func (r *QueryResult) Render(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	names := make([]string, len(r.Columns))
+	for i, c := range r.Columns {
+		names[i] = c.Name
+	}
+	fmt.Fprintln(tw, strings.Join(names, "\t"))
+	for _, row := range r.Rows {
+		cells := make([]string, len(r.Columns))
+		for i, c := range r.Columns {
+			// Tabs and newlines would break the alignment
+			cells[i] = strings.NewReplacer("\t", " ", "\n", " ", "\r", "").Replace(cellText(row[c.Name]))
+		}
+		fmt.Fprintln(tw, strings.Join(cells, "\t"))
+	}
+	return tw.Flush()
+}
+
+// RenderCSV writes the rows of r as CSV with a header line.
+func (r *QueryResult) RenderCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	names := make([]string, len(r.Columns))
+	for i, c := range r.Columns {
+		names[i] = c.Name
+	}
+	if err := cw.Write(names); err != nil {
+		return err
+	}
+	for _, row := range r.Rows {
+		cells := make([]string, len(r.Columns))
+		for i, c := range r.Columns {
+			cells[i] = cellText(row[c.Name])
+		}
+		if err := cw.Write(cells); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
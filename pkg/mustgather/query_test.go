@@ -0,0 +1,93 @@
+package mustgather
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"kubectl-must-gather/pkg/testhelpers"
+)
+
+func TestQuery(t *testing.T) {
+	now := time.Now().UTC()
+	table := testhelpers.EmulatedTable{
+		Name:    "KubeEvents",
+		Columns: []testhelpers.EmulatedColumn{{Name: "TimeGenerated", Type: "datetime"}, {Name: "Reason", Type: "string"}, {Name: "Count", Type: "int"}},
+	}
+	for i := 0; i < 6; i++ {
+		table.Rows = append(table.Rows, []any{now.Add(-time.Duration(10+i*20) * time.Minute).Format(time.RFC3339), "BackOff", i})
+	}
+	// Outside the window
+	table.Rows = append(table.Rows, []any{now.Add(-3 * time.Hour).Format(time.RFC3339), "Old", 9})
+	emu := testhelpers.NewLogAnalyticsEmulator(table)
+	defer emu.Close()
+	emu.SetQueryResult("KubeEvents | summarize", testhelpers.EmulatedTable{
+		Columns: []testhelpers.EmulatedColumn{{Name: "Reason", Type: "string"}, {Name: "Objects", Type: "dynamic"}},
+		Rows:    [][]any{{"BackOff", `["pod/a","pod/b"]`}},
+	})
+	env := Environment{Credential: emu.Credential(), Cloud: emu.Cloud(), HTTPClient: emu.Client()}
+	config := func() *Config {
+		return &Config{WorkspaceID: emu.WorkspaceID(), Timespan: "PT2H", Parallelism: 2, Quiet: true}
+	}
+
+	r, err := Query(context.Background(), config(), env, QueryOptions{KQL: " KubeEvents | summarize make_set(Name) by Reason\n"})
+	if err != nil {
+		t.Fatalf("Query: %v", err)
+	}
+	if r.Query != "KubeEvents | summarize make_set(Name) by Reason" || r.Queries != 1 {
+		t.Errorf("unexpected query %q in %d requests", r.Query, r.Queries)
+	}
+	var buf bytes.Buffer
+	if err := r.RenderCSV(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if want := "Reason,Objects\nBackOff,\"[\"\"pod/a\"\",\"\"pod/b\"\"]\"\n"; buf.String() != want {
+		t.Errorf("unexpected CSV:\n%s\nwant:\n%s", buf.String(), want)
+	}
+	buf.Reset()
+	if err := r.Render(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if want := "Reason   Objects\nBackOff  [\"pod/a\",\"pod/b\"]\n"; buf.String() != want {
+		t.Errorf("unexpected table:\n%s\nwant:\n%s", buf.String(), want)
+	}
+
+	// A capped result is reported, and chunking retrieves every row
+	emu.SetRowLimit(4)
+	r, err = Query(context.Background(), config(), env, QueryOptions{KQL: "KubeEvents"})
+	if err != nil {
+		t.Fatalf("Query: %v", err)
+	}
+	if len(r.Rows) != 4 || len(r.Truncated) != 1 {
+		t.Errorf("expected 4 rows of a truncated result, got %d rows, truncated %v", len(r.Rows), r.Truncated)
+	}
+	r, err = Query(context.Background(), config(), env, QueryOptions{KQL: "KubeEvents", Chunk: true})
+	if err != nil {
+		t.Fatalf("Query: %v", err)
+	}
+	if len(r.Rows) != 6 || len(r.Truncated) != 0 {
+		t.Errorf("expected all 6 rows chunked, got %d rows, truncated %v", len(r.Rows), r.Truncated)
+	}
+	var prev string
+	for _, row := range r.Rows {
+		if ts := toStr(row["TimeGenerated"]); ts < prev {
+			t.Errorf("rows out of order: %s after %s", ts, prev)
+		} else {
+			prev = ts
+		}
+	}
+	b, _ := json.Marshal(r)
+	if !strings.Contains(string(b), `"columns":[{"name":"TimeGenerated","type":"datetime"}`) {
+		t.Errorf("unexpected JSON: %s", b)
+	}
+
+	if _, err := Query(context.Background(), config(), env, QueryOptions{KQL: "NoSuchTable"}); err == nil {
+		t.Error("expected an error for an unknown table")
+	}
+	if _, err := Query(context.Background(), config(), env, QueryOptions{KQL: "  "}); err == nil {
+		t.Error("expected an error for an empty query")
+	}
+}
@@ -0,0 +1,51 @@
+package mustgather
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+// rateLimiter is a token bucket shared by every query of a gather, so
+// parallel chunk queries across tables stay within the workspace's query rate
+// limits. A nil limiter does not limit.
+type rateLimiter struct {
+	mu     sync.Mutex
+	rate   float64 // tokens added per second
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// newRateLimiter allows rate queries per second on average, with bursts of up
+// to one second's worth. It returns nil when rate is not positive.
+func newRateLimiter(rate float64) *rateLimiter {
+	if rate <= 0 {
+		return nil
+	}
+	burst := math.Max(1, math.Ceil(rate))
+	return &rateLimiter{rate: rate, burst: burst, tokens: burst, last: time.Now()}
+}
+
+// wait blocks until a token is available or ctx is done.
+func (l *rateLimiter) wait(ctx context.Context) error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	now := time.Now()
+	l.tokens = math.Min(l.burst, l.tokens+now.Sub(l.last).Seconds()*l.rate)
+	l.last = now
+	// Reserve a token; a negative balance is the time to wait for it
+	l.tokens--
+	var delay time.Duration
+	if l.tokens < 0 {
+		delay = time.Duration(-l.tokens / l.rate * float64(time.Second))
+	}
+	l.mu.Unlock()
+	if delay <= 0 {
+		return nil
+	}
+	return sleepCtx(ctx, delay)
+}
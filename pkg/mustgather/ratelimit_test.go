@@ -0,0 +1,37 @@
+package mustgather
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestRateLimiter(t *testing.T) {
+	if newRateLimiter(0) != nil {
+		t.Error("expected no limiter for a zero rate")
+	}
+	var none *rateLimiter
+	if err := none.wait(context.Background()); err != nil {
+		t.Errorf("nil limiter should not block: %v", err)
+	}
+
+	l := newRateLimiter(100)
+	begin := time.Now()
+	// The first second's worth is a burst; the next 20 tokens take ~200ms
+	for i := 0; i < 120; i++ {
+		if err := l.wait(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if elapsed := time.Since(begin); elapsed < 150*time.Millisecond {
+		t.Errorf("expected rate limiting past the burst, took %s", elapsed)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	l = newRateLimiter(0.001)
+	_ = l.wait(ctx) // consumes the single burst token
+	if err := l.wait(ctx); err == nil {
+		t.Error("expected canceled context to abort the wait")
+	}
+}
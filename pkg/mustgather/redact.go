@@ -0,0 +1,290 @@
+package mustgather
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"gopkg.in/yaml.v3"
+)
+
+// redactionsPath counts the values each rule masked per table.
+const redactionsPath = "metadata/redactions.json"
+
+// RedactionRule masks personal data in exported rows: every match of Pattern
+// in string values, and the whole value of columns or JSON object keys named
+// in Keys. Tables limits the rule to those tables; all tables when empty.
+type RedactionRule struct {
+	Name    string   `yaml:"name"`
+	Pattern string   `yaml:"pattern"`
+	Keys    []string `yaml:"keys"`
+	Tables  []string `yaml:"tables"`
+	// Replacement is written in place of each match. When empty it is
+	// "<name:hash>", with the first 8 hex digits of the SHA-256 of the salt
+	// and the value, so a value is masked the same way everywhere.
+	Replacement string `yaml:"replacement"`
+
+	re     *regexp.Regexp
+	keyRes []*regexp.Regexp
+	keys   map[string]bool
+	tables map[string]bool
+}
+
+// builtinRedactionRules can be enabled by name under "builtin" in a rules
+// file.
+var builtinRedactionRules = map[string]RedactionRule{
+	"email": {Name: "email", Pattern: `[A-Za-z0-9._%+\-]+@[A-Za-z0-9.\-]+\.[A-Za-z]{2,}`},
+	"ip": {Name: "ip", Pattern: `\b(?:(?:25[0-5]|2[0-4]\d|1?\d?\d)\.){3}(?:25[0-5]|2[0-4]\d|1?\d?\d)\b` +
+		`|(?i)\b(?:[0-9a-f]{1,4}:){7}[0-9a-f]{1,4}\b|(?i)\b(?:[0-9a-f]{1,4}:)+:(?:[0-9a-f]{1,4}(?::[0-9a-f]{1,4})*)?`},
+	// User principal names of the callers in Kubernetes audit events
+	"upn": {Name: "upn", Keys: []string{"username", "userPrincipalName", "upn"}, Tables: []string{"AKSAudit", "AKSAuditAdmin"}},
+}
+
+// Redactor applies the rules of a --redaction-rules file to rows before they
+// are exported, so table NDJSON, stitched logs, reports and summaries all
+// show the same masked values.
+type Redactor struct {
+	Salt  string          `yaml:"salt"`
+	Names []string        `yaml:"builtin"`
+	Rules []RedactionRule `yaml:"rules"`
+}
+
+// redactionCounts is the number of values a gather masked per table and
+// rule, for metadata/redactions.json. Each gather counts its own, as
+// watches, fleets and controllers share one Redactor across gathers.
+type redactionCounts struct {
+	mu sync.Mutex
+	n  map[string]map[string]int
+}
+
+// LoadRedactionRules reads a rules file, e.g.
+//
+//	salt: change-me
+//	builtin: [email, ip, upn]
+//	rules:
+//	  - name: storage-key
+//	    pattern: 'AccountKey=[^;"]+'
+//	    replacement: 'AccountKey=<redacted>'
+//
+// An empty path returns nil.
+func LoadRedactionRules(path string) (*Redactor, error) {
+	if path == "" {
+		return nil, nil
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read redaction rules: %w", err)
+	}
+	var r Redactor
+	dec := yaml.NewDecoder(strings.NewReader(string(b)))
+	dec.KnownFields(true)
+	if err := dec.Decode(&r); err != nil {
+		return nil, fmt.Errorf("parse redaction rules %s: %w", path, err)
+	}
+	if err := r.compile(); err != nil {
+		return nil, fmt.Errorf("redaction rules %s: %w", path, err)
+	}
+	return &r, nil
+}
+
+// compile puts the enabled builtin rules ahead of the file's own and checks
+// and prepares every rule.
+func (r *Redactor) compile() error {
+	var rules []RedactionRule
+	for _, n := range r.Names {
+		b, ok := builtinRedactionRules[n]
+		if !ok {
+			known := make([]string, 0, len(builtinRedactionRules))
+			for k := range builtinRedactionRules {
+				known = append(known, k)
+			}
+			sort.Strings(known)
+			return fmt.Errorf("unknown builtin rule %q: expected one of %s", n, strings.Join(known, ", "))
+		}
+		rules = append(rules, b)
+	}
+	rules = append(rules, r.Rules...)
+	for i := range rules {
+		rule := &rules[i]
+		if rule.Name == "" {
+			return fmt.Errorf("rule %d has no name", i+1)
+		}
+		if rule.Pattern == "" && len(rule.Keys) == 0 {
+			return fmt.Errorf("rule %s needs a pattern or keys", rule.Name)
+		}
+		if rule.Pattern != "" {
+			re, err := regexp.Compile(rule.Pattern)
+			if err != nil {
+				return fmt.Errorf("rule %s: %w", rule.Name, err)
+			}
+			rule.re = re
+		}
+		rule.keys = map[string]bool{}
+		for _, k := range rule.Keys {
+			rule.keys[k] = true
+			// A string value of the key inside dynamic JSON
+			rule.keyRes = append(rule.keyRes, regexp.MustCompile(`("`+regexp.QuoteMeta(k)+`"\s*:\s*)("(?:[^"\\]|\\.)*")`))
+		}
+		if len(rule.Tables) > 0 {
+			rule.tables = map[string]bool{}
+			for _, t := range rule.Tables {
+				rule.tables[strings.ToLower(t)] = true
+			}
+		}
+	}
+	r.Rules, r.Names = rules, nil
+	return nil
+}
+
+// ruleNames lists the rules applied, for metadata/run.json.
+func (r *Redactor) ruleNames() []string {
+	names := make([]string, len(r.Rules))
+	for i, rule := range r.Rules {
+		names[i] = rule.Name
+	}
+	return names
+}
+
+// mask returns the replacement of value under rule.
+func (r *Redactor) mask(rule *RedactionRule, value string) string {
+	if rule.Replacement != "" {
+		return rule.Replacement
+	}
+	sum := sha256.Sum256([]byte(r.Salt + value))
+	return "<" + rule.Name + ":" + hex.EncodeToString(sum[:4]) + ">"
+}
+
+// redactRow masks the values of a row of table in place, counting the masked
+// values under table in counts, unless it is nil. A nil Redactor leaves it
+// unchanged.
+func (r *Redactor) redactRow(table string, row map[string]any, counts *redactionCounts) {
+	if r == nil {
+		return
+	}
+	for i := range r.Rules {
+		rule := &r.Rules[i]
+		if rule.tables != nil && !rule.tables[strings.ToLower(table)] {
+			continue
+		}
+		n := 0
+		for col, v := range row {
+			if v == nil {
+				continue
+			}
+			if rule.keys[col] {
+				row[col] = r.mask(rule, toStr(v))
+				n++
+				continue
+			}
+			switch val := v.(type) {
+			case string:
+				row[col] = r.redactString(rule, val, &n)
+			case json.RawMessage:
+				row[col] = r.redactJSON(rule, val, &n)
+			}
+		}
+		if n > 0 {
+			counts.add(table, rule.Name, n)
+		}
+	}
+}
+
+func (c *redactionCounts) add(table, rule string, n int) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.n == nil {
+		c.n = map[string]map[string]int{}
+	}
+	if c.n[table] == nil {
+		c.n[table] = map[string]int{}
+	}
+	c.n[table][rule] += n
+}
+
+// redactionSummary is the content of metadata/redactions.json: the values
+// masked per table and rule. Tables without any are left out.
+type redactionSummary struct {
+	Rules  []string                   `json:"rules"`
+	Total  int                        `json:"total"`
+	ByRule map[string]int             `json:"byRule"`
+	Tables map[string]tableRedactions `json:"tables"`
+}
+
+type tableRedactions struct {
+	Total int            `json:"total"`
+	Rules map[string]int `json:"rules"`
+}
+
+// summary returns the rules of r and the values of counts.
+func (r *Redactor) summary(counts *redactionCounts) redactionSummary {
+	counts.mu.Lock()
+	defer counts.mu.Unlock()
+	sum := redactionSummary{Rules: r.ruleNames(), ByRule: map[string]int{}, Tables: map[string]tableRedactions{}}
+	for table, rules := range counts.n {
+		t := tableRedactions{Rules: map[string]int{}}
+		for rule, n := range rules {
+			t.Rules[rule] = n
+			t.Total += n
+			sum.ByRule[rule] += n
+		}
+		sum.Tables[table] = t
+		sum.Total += t.Total
+	}
+	return sum
+}
+
+// redactString masks the matches of rule in s, adding their number to n.
+func (r *Redactor) redactString(rule *RedactionRule, s string, n *int) string {
+	if rule.re != nil {
+		s = rule.re.ReplaceAllStringFunc(s, func(m string) string {
+			*n++
+			return r.mask(rule, m)
+		})
+	}
+	// Dynamic values that are not objects or arrays stay strings, and
+	// messages often hold JSON, so keys are looked for in strings too
+	for _, kre := range rule.keyRes {
+		s = kre.ReplaceAllStringFunc(s, func(m string) string {
+			parts := kre.FindStringSubmatch(m)
+			var value string
+			if err := json.Unmarshal([]byte(parts[2]), &value); err != nil {
+				value = parts[2]
+			}
+			*n++
+			return parts[1] + jsonString(r.mask(rule, value))
+		})
+	}
+	return s
+}
+
+// redactJSON masks a dynamic value kept as raw JSON, without reordering its
+// keys. Should masking leave invalid JSON, the value is kept as a string.
+func (r *Redactor) redactJSON(rule *RedactionRule, raw json.RawMessage, n *int) any {
+	s := r.redactString(rule, string(raw), n)
+	if s == string(raw) {
+		return raw
+	}
+	if json.Valid([]byte(s)) {
+		return json.RawMessage(s)
+	}
+	return s
+}
+
+// jsonString quotes s as a JSON string, leaving the angle brackets of masks
+// unescaped.
+func jsonString(s string) string {
+	var b strings.Builder
+	enc := json.NewEncoder(&b)
+	enc.SetEscapeHTML(false)
+	_ = enc.Encode(s)
+	return strings.TrimSuffix(b.String(), "\n")
+}
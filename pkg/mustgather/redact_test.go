@@ -0,0 +1,201 @@
+package mustgather
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	azquery "github.com/Azure/azure-sdk-for-go/sdk/monitor/azquery"
+)
+
+func writeRules(t *testing.T, content string) string {
+	t.Helper()
+	p := filepath.Join(t.TempDir(), "rules.yaml")
+	if err := os.WriteFile(p, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestRedactRow(t *testing.T) {
+	r, err := LoadRedactionRules(writeRules(t, `
+salt: s3cret
+builtin: [email, ip, upn]
+rules:
+  - name: storage-key
+    pattern: 'AccountKey=[^;"]+'
+    replacement: 'AccountKey=<redacted>'
+  - name: node
+    keys: [Computer]
+    tables: [KubeNodeInventory]
+`))
+	if err != nil {
+		t.Fatalf("LoadRedactionRules failed: %v", err)
+	}
+	email := r.mask(&r.Rules[0], "alice@contoso.com")
+	if !strings.HasPrefix(email, "<email:") || len(email) != len("<email:12345678>") {
+		t.Fatalf("unexpected mask %q", email)
+	}
+
+	tests := []struct {
+		name  string
+		table string
+		row   map[string]any
+		want  map[string]any
+	}{
+		{
+			name:  "emails and addresses in log lines",
+			table: "ContainerLogV2",
+			row:   map[string]any{"LogMessage": "login alice@contoso.com from 10.0.0.12 and fe80::1ff:fe23:4567:890a at 2024-01-01T00:05:00Z", "ContainerName": "web"},
+			want: map[string]any{
+				"LogMessage":    "login " + email + " from " + r.mask(&r.Rules[1], "10.0.0.12") + " and " + r.mask(&r.Rules[1], "fe80::1ff:fe23:4567:890a") + " at 2024-01-01T00:05:00Z",
+				"ContainerName": "web",
+			},
+		},
+		{
+			name:  "custom replacement",
+			table: "ContainerLogV2",
+			row:   map[string]any{"LogMessage": "DefaultEndpointsProtocol=https;AccountKey=abc+/=;EndpointSuffix=core.windows.net"},
+			want:  map[string]any{"LogMessage": "DefaultEndpointsProtocol=https;AccountKey=<redacted>;EndpointSuffix=core.windows.net"},
+		},
+		{
+			name:  "audit user keeps key order",
+			table: "AKSAudit",
+			row:   map[string]any{"User": json.RawMessage(`{"username":"alice@contoso.com","groups":["system:authenticated"]}`), "Verb": "get"},
+			want:  map[string]any{"User": json.RawMessage(`{"username":"` + r.mask(&r.Rules[2], email) + `","groups":["system:authenticated"]}`), "Verb": "get"},
+		},
+		{
+			name:  "keys only in their tables",
+			table: "KubePodInventory",
+			row:   map[string]any{"Computer": "aks-node-1", "User": `{"username":"bob"}`},
+			want:  map[string]any{"Computer": "aks-node-1", "User": `{"username":"bob"}`},
+		},
+		{
+			name:  "whole column",
+			table: "KubeNodeInventory",
+			row:   map[string]any{"Computer": "aks-node-1", "Status": "Ready"},
+			want:  map[string]any{"Computer": r.mask(&r.Rules[4], "aks-node-1"), "Status": "Ready"},
+		},
+		{
+			name:  "other values unchanged",
+			table: "Perf",
+			row:   map[string]any{"CounterValue": 10.5, "Running": true, "Empty": nil},
+			want:  map[string]any{"CounterValue": 10.5, "Running": true, "Empty": nil},
+		},
+	}
+	counts := &redactionCounts{}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r.redactRow(tt.table, tt.row, counts)
+			got, _ := json.Marshal(tt.row)
+			want, _ := json.Marshal(tt.want)
+			if string(got) != string(want) {
+				t.Errorf("unexpected row\n got: %s\nwant: %s", got, want)
+			}
+		})
+	}
+
+	sum := r.summary(counts)
+	wantTables := map[string]map[string]int{
+		"ContainerLogV2":    {"email": 1, "ip": 2, "storage-key": 1},
+		"AKSAudit":          {"email": 1, "upn": 1},
+		"KubeNodeInventory": {"node": 1},
+	}
+	if sum.Total != 7 || sum.ByRule["ip"] != 2 || len(sum.Tables) != len(wantTables) {
+		t.Errorf("unexpected summary %+v", sum)
+	}
+	for table, rules := range wantTables {
+		got := sum.Tables[table]
+		for rule, n := range rules {
+			if got.Rules[rule] != n {
+				t.Errorf("%s: %s masked %d values, want %d", table, rule, got.Rules[rule], n)
+			}
+		}
+	}
+
+	// The same value is masked the same way, a different salt changes it
+	other := &Redactor{Salt: "other", Names: []string{"email"}}
+	if err := other.compile(); err != nil {
+		t.Fatal(err)
+	}
+	if r.mask(&r.Rules[0], "alice@contoso.com") != email || other.mask(&other.Rules[0], "alice@contoso.com") == email {
+		t.Error("expected masks to depend only on the value and the salt")
+	}
+	if got := strings.Join(r.ruleNames(), ","); got != "email,ip,upn,storage-key,node" {
+		t.Errorf("unexpected rule names %s", got)
+	}
+
+	var nilRedactor *Redactor
+	row := map[string]any{"LogMessage": "alice@contoso.com"}
+	nilRedactor.redactRow("ContainerLogV2", row, counts)
+	if row["LogMessage"] != "alice@contoso.com" {
+		t.Error("a nil Redactor should leave rows unchanged")
+	}
+}
+
+func TestLoadRedactionRulesErrors(t *testing.T) {
+	if r, err := LoadRedactionRules(""); r != nil || err != nil {
+		t.Errorf("expected no rules for an empty path, got %v, %v", r, err)
+	}
+	tests := []struct {
+		name    string
+		content string
+		want    string
+	}{
+		{"unknown builtin", "builtin: [mail]", `unknown builtin rule "mail"`},
+		{"bad pattern", "rules:\n  - name: x\n    pattern: '('", "rule x:"},
+		{"no pattern or keys", "rules:\n  - name: x", "needs a pattern or keys"},
+		{"no name", "rules:\n  - pattern: x", "rule 1 has no name"},
+		{"unknown field", "rule: []", "field rule not found"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := LoadRedactionRules(writeRules(t, tt.content))
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("expected error containing %q, got %v", tt.want, err)
+			}
+		})
+	}
+	if _, err := LoadRedactionRules(filepath.Join(t.TempDir(), "missing.yaml")); err == nil {
+		t.Error("expected a missing file to fail")
+	}
+}
+
+func TestRedactionCountsPerGather(t *testing.T) {
+	r, err := LoadRedactionRules(writeRules(t, "builtin: [ip]\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := time.Now().Add(-10 * time.Minute).UTC().Format(time.RFC3339Nano)
+	logs := &fakeLogs{rows: map[string][]azquery.Row{"Heartbeat": {{ts, "10.0.0.1"}}}}
+	// Watches and fleets share the Redactor of their Config
+	for i := range 2 {
+		sink := &memorySink{files: map[string]string{}}
+		config := &Config{
+			WorkspaceID: "/subscriptions/sub/resourceGroups/rg/providers/Microsoft.OperationalInsights/workspaces/ws",
+			Timespan:    "PT1H",
+			TableFilter: "Heartbeat",
+			Redaction:   r,
+			Quiet:       true,
+		}
+		g, err := NewGatherer(context.Background(), config, WithCredential(noCredential{}), WithLogsClient(logs),
+			WithWorkspacesClient(fakeWorkspaces{}), WithTablesClient(fakeTables{names: []string{"Heartbeat"}}), WithSink(sink))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := g.Run(); err != nil {
+			t.Fatalf("Run: %v", err)
+		}
+		var sum redactionSummary
+		if err := json.Unmarshal([]byte(sink.files[redactionsPath]), &sum); err != nil {
+			t.Fatal(err)
+		}
+		if sum.Total != 1 || sum.Tables["Heartbeat"].Rules["ip"] != 1 {
+			t.Errorf("gather %d: unexpected redaction counts %s", i+1, sink.files[redactionsPath])
+		}
+	}
+}
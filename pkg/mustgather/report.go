@@ -0,0 +1,513 @@
+package mustgather
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"kubectl-must-gather/pkg/bundle"
+	"kubectl-must-gather/pkg/utils"
+)
+
+// ReportHTML is the --report format that writes report/index.html.
+const ReportHTML = "html"
+
+// reportPath is where --report html writes the report in the archive.
+const reportPath = "report/index.html"
+
+// htmlReport writes report/index.html: per-namespace pod lists with status,
+// restart counts and links to the pods' stitched logs, events and manifests,
+// and a summary of each namespace's events. It is meant for readers who will
+// not open the NDJSON parts.
+//
+// During a gather the files the other transforms write are predicted from the
+// rows they see; a standalone report over an existing bundle links the
+// bundle's actual files instead.
+type htmlReport struct {
+	config *Config
+	// predict is set during gathers, where files is filled from the rows.
+	predict bool
+	files   map[string]bool
+	// instances holds the container IDs seen per stitched container log, to
+	// predict its .previous logs.
+	instances map[string]map[string]bool
+
+	pods   map[string]*reportPod
+	events map[string]map[string]*reportEventGroup
+}
+
+type reportPod struct {
+	namespace, name string
+	status, node    string
+	lastSeen        string
+	containers      map[string]*reportContainer
+}
+
+type reportContainer struct {
+	name          string
+	restarts      int64
+	state, reason string
+	lastSeen      string
+	// inventory is set once a KubePodInventory row was seen.
+	inventory bool
+}
+
+// reportEventGroup summarizes a namespace's events of one type and reason.
+type reportEventGroup struct {
+	Type, Reason string
+	Count        int64
+	Objects      map[string]bool
+	LastMessage  string
+	First, Last  string
+}
+
+func newHTMLReport(config *Config) *htmlReport {
+	return &htmlReport{
+		config:    config,
+		predict:   true,
+		files:     map[string]bool{},
+		instances: map[string]map[string]bool{},
+		pods:      map[string]*reportPod{},
+		events:    map[string]map[string]*reportEventGroup{},
+	}
+}
+
+func (r *htmlReport) observe(table string, row map[string]any) {
+	switch table {
+	case "KubePodInventory":
+		r.observePod(row)
+	case "KubeEvents":
+		r.observeEvent(row)
+	case "ContainerLogV2":
+		r.observeLog(row)
+	}
+}
+
+func (r *htmlReport) pod(ns, name string) *reportPod {
+	key := ns + "/" + name
+	p, ok := r.pods[key]
+	if !ok {
+		p = &reportPod{namespace: ns, name: name, containers: map[string]*reportContainer{}}
+		r.pods[key] = p
+	}
+	return p
+}
+
+func (r *htmlReport) observePod(row map[string]any) {
+	ns, name := toStr(row["Namespace"]), toStr(row["Name"])
+	if name == "" {
+		return
+	}
+	p := r.pod(ns, name)
+	tm := toStr(row["TimeGenerated"])
+	if later(tm, p.lastSeen) {
+		p.lastSeen = tm
+		p.status = toStr(row["PodStatus"])
+		p.node = toStr(row["Computer"])
+	}
+	if r.predict {
+		r.files[podFile(ns, name, "pod.yaml")] = true
+	}
+	cn := podContainerName(toStr(row["ContainerName"]))
+	if cn == "" {
+		return
+	}
+	c, ok := p.containers[cn]
+	if !ok {
+		c = &reportContainer{name: cn}
+		p.containers[cn] = c
+	}
+	if rc, ok := toFloat(row["ContainerRestartCount"]); ok && int64(rc) > c.restarts {
+		c.restarts = int64(rc)
+	}
+	if !c.inventory || later(tm, c.lastSeen) {
+		c.inventory = true
+		c.lastSeen = tm
+		c.state = toStr(row["ContainerStatus"])
+		c.reason = toStr(row["ContainerStatusReason"])
+	}
+}
+
+func (r *htmlReport) observeEvent(row map[string]any) {
+	ns := toStr(row["Namespace"])
+	if ns == "" {
+		ns = "default"
+	}
+	typ, reason := toStr(row["KubeEventType"]), toStr(row["Reason"])
+	groups, ok := r.events[ns]
+	if !ok {
+		groups = map[string]*reportEventGroup{}
+		r.events[ns] = groups
+	}
+	g, ok := groups[typ+"/"+reason]
+	if !ok {
+		g = &reportEventGroup{Type: typ, Reason: reason, Objects: map[string]bool{}}
+		groups[typ+"/"+reason] = g
+	}
+	n := int64(1)
+	if c, ok := toFloat(row["Count"]); ok && c > 1 {
+		n = int64(c)
+	}
+	g.Count += n
+	name := toStr(row["Name"])
+	g.Objects[toStr(row["ObjectKind"])+"/"+name] = true
+	tm := toStr(row["TimeGenerated"])
+	if g.First == "" || timeBefore(tm, g.First) {
+		g.First = tm
+	}
+	if later(tm, g.Last) {
+		g.Last = tm
+		g.LastMessage = toStr(row["Message"])
+	}
+
+	if r.predict && r.config.StitchLogs && r.config.StitchIncludeEvents {
+		r.files[path.Join("namespaces", utils.SafeFileName(ns), "events", "events.log")] = true
+		if strings.EqualFold(toStr(row["ObjectKind"]), "Pod") && name != "" {
+			r.files[podFile(ns, name, "events.log")] = true
+		}
+	}
+}
+
+// observeLog lists pods that only appear in container logs, and predicts
+// the stitched log files.
+func (r *htmlReport) observeLog(row map[string]any) {
+	ns, name, cn := toStr(row["PodNamespace"]), toStr(row["PodName"]), toStr(row["ContainerName"])
+	if name == "" || cn == "" {
+		return
+	}
+	p := r.pod(ns, name)
+	if _, ok := p.containers[cn]; !ok {
+		p.containers[cn] = &reportContainer{name: cn}
+	}
+	// Mirrors the stitcher: rows without a timestamp or message are not stitched
+	if r.predict && r.config.StitchLogs && hasColumns(row, "TimeGenerated") && (hasColumns(row, "LogMessage") || hasColumns(row, "LogEntry")) {
+		log := podFile(ns, name, utils.SafeFileName(cn)+".log")
+		ids, ok := r.instances[log]
+		if !ok {
+			ids = map[string]bool{}
+			r.instances[log] = ids
+		}
+		ids[toStr(row["ContainerId"])] = true
+	}
+}
+
+func (r *htmlReport) endChunk(tarw *utils.TarWriter) error {
+	return nil
+}
+
+func (r *htmlReport) warnings(table string) []string {
+	return nil
+}
+
+func (r *htmlReport) finish(tarw *utils.TarWriter) error {
+	for log, ids := range r.instances {
+		for n := 0; n < len(ids); n++ {
+			r.files[previousLogPath(log, n)] = true
+		}
+	}
+	b, err := r.render("../", "")
+	if err != nil {
+		return fmt.Errorf("render report: %w", err)
+	}
+	return utils.WriteFileToTar(tarw, reportPath, b)
+}
+
+// podFile is the slash-separated archive path of a file in a pod's directory.
+func podFile(ns, pod, name string) string {
+	return path.Join("namespaces", utils.SafeFileName(ns), "pods", utils.SafeFileName(pod), name)
+}
+
+// WriteReport renders the HTML report of an existing bundle to out. Links to
+// logs and manifests work when src is an extracted directory; for an archive
+// they are shown as paths inside it.
+func WriteReport(src, out string) error {
+	b, err := bundle.Open(src)
+	if err != nil {
+		return fmt.Errorf("open bundle: %w", err)
+	}
+	defer b.Close()
+
+	r := newHTMLReport(&Config{})
+	r.predict = false
+	files, err := b.Files()
+	if err != nil {
+		return fmt.Errorf("list bundle files: %w", err)
+	}
+	for _, f := range files {
+		r.files[f] = true
+	}
+	tables, err := b.Tables()
+	if err != nil {
+		return fmt.Errorf("list tables: %w", err)
+	}
+	for _, t := range tables {
+		for _, part := range t.Parts {
+			err := b.ReadPart(part, func(row map[string]any) error {
+				r.observe(t.Name, row)
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	base, note := "", ""
+	if fi, err := os.Stat(src); err == nil && fi.IsDir() {
+		absOut, err1 := filepath.Abs(filepath.Dir(out))
+		absSrc, err2 := filepath.Abs(src)
+		if err1 == nil && err2 == nil {
+			if rel, err := filepath.Rel(absOut, absSrc); err == nil {
+				base = filepath.ToSlash(rel) + "/"
+			}
+		}
+	} else {
+		note = fmt.Sprintf("Paths are inside %s; extract it and run the report on the directory for clickable links.", filepath.Base(src))
+	}
+	html, err := r.render(base, note)
+	if err != nil {
+		return fmt.Errorf("render report: %w", err)
+	}
+	if dir := filepath.Dir(out); dir != "" {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+	}
+	return os.WriteFile(out, html, 0644)
+}
+
+type reportData struct {
+	Generated  string
+	Note       string
+	Namespaces []reportNamespace
+	Pods       int
+	Restarts   int64
+	Warnings   int64
+}
+
+type reportNamespace struct {
+	Name       string
+	Anchor     string
+	Pods       []reportPodView
+	Events     []*reportEventGroup
+	EventsLog  reportLink
+	NotRunning int
+	Restarts   int64
+	Warnings   int64
+}
+
+type reportPodView struct {
+	Name, Status, Node string
+	Problem            bool
+	Restarts           int64
+	Manifest, Events   reportLink
+	Containers         []reportContainerView
+}
+
+type reportContainerView struct {
+	Name, State string
+	Restarts    int64
+	Logs        []reportLink
+}
+
+// reportLink is a file of the bundle; Href is empty when it cannot be linked.
+type reportLink struct {
+	Label, Path, Href string
+}
+
+// render builds the report. Archive paths are linked relative to base, or
+// shown as plain paths when base is empty.
+func (r *htmlReport) render(base, note string) ([]byte, error) {
+	link := func(label, p string) reportLink {
+		if !r.files[p] {
+			return reportLink{}
+		}
+		l := reportLink{Label: label, Path: p}
+		if base != "" {
+			l.Href = base + (&url.URL{Path: p}).EscapedPath()
+		}
+		return l
+	}
+
+	byNS := map[string]*reportNamespace{}
+	nsFor := func(name string) *reportNamespace {
+		ns, ok := byNS[name]
+		if !ok {
+			ns = &reportNamespace{Name: name, Anchor: "ns-" + utils.SafeFileName(name)}
+			ns.EventsLog = link("events.log", path.Join("namespaces", utils.SafeFileName(name), "events", "events.log"))
+			byNS[name] = ns
+		}
+		return ns
+	}
+
+	loc := r.config.location()
+	generated := time.Now().UTC()
+	if loc != nil {
+		generated = generated.In(loc)
+	}
+	data := reportData{Generated: generated.Format(time.RFC3339), Note: note}
+	for _, p := range r.pods {
+		ns := nsFor(p.namespace)
+		view := reportPodView{
+			Name:     p.name,
+			Status:   p.status,
+			Node:     p.node,
+			Manifest: link("pod.yaml", podFile(p.namespace, p.name, "pod.yaml")),
+			Events:   link("events.log", podFile(p.namespace, p.name, "events.log")),
+		}
+		names := make([]string, 0, len(p.containers))
+		for cn := range p.containers {
+			names = append(names, cn)
+		}
+		sort.Strings(names)
+		for _, cn := range names {
+			c := p.containers[cn]
+			state := c.state
+			if c.reason != "" {
+				state += " (" + c.reason + ")"
+			}
+			cv := reportContainerView{Name: cn, State: state, Restarts: c.restarts}
+			log := podFile(p.namespace, p.name, utils.SafeFileName(cn)+".log")
+			for n := 0; ; n++ {
+				label := "current"
+				if n > 0 {
+					label = strings.TrimPrefix(path.Base(previousLogPath(log, n)), utils.SafeFileName(cn)+".")
+					label = strings.TrimSuffix(label, ".log")
+				}
+				l := link(label, previousLogPath(log, n))
+				if l.Path == "" {
+					break
+				}
+				cv.Logs = append(cv.Logs, l)
+			}
+			view.Restarts += c.restarts
+			if c.reason == "CrashLoopBackOff" || c.reason == "OOMKilled" || c.reason == "Error" {
+				view.Problem = true
+			}
+			view.Containers = append(view.Containers, cv)
+		}
+		if p.status != "" && p.status != "Running" && p.status != "Succeeded" {
+			view.Problem = true
+			ns.NotRunning++
+		}
+		ns.Restarts += view.Restarts
+		ns.Pods = append(ns.Pods, view)
+	}
+	for name, groups := range r.events {
+		ns := nsFor(name)
+		for _, g := range groups {
+			if loc != nil {
+				in := *g
+				in.First, in.Last = formatStitchTime(g.First, loc), formatStitchTime(g.Last, loc)
+				g = &in
+			}
+			ns.Events = append(ns.Events, g)
+			if g.Type == "Warning" {
+				ns.Warnings += g.Count
+			}
+		}
+	}
+
+	names := make([]string, 0, len(byNS))
+	for n := range byNS {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	for _, n := range names {
+		ns := byNS[n]
+		sort.Slice(ns.Pods, func(i, j int) bool { return ns.Pods[i].Name < ns.Pods[j].Name })
+		sort.Slice(ns.Events, func(i, j int) bool {
+			a, b := ns.Events[i], ns.Events[j]
+			if (a.Type == "Warning") != (b.Type == "Warning") {
+				return a.Type == "Warning"
+			}
+			if a.Count != b.Count {
+				return a.Count > b.Count
+			}
+			return a.Reason < b.Reason
+		})
+		data.Pods += len(ns.Pods)
+		data.Restarts += ns.Restarts
+		data.Warnings += ns.Warnings
+		data.Namespaces = append(data.Namespaces, *ns)
+	}
+
+	var buf bytes.Buffer
+	if err := reportTemplate.Execute(&buf, data); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+var reportTemplate = template.Must(template.New("report").Funcs(template.FuncMap{
+	"objects": func(m map[string]bool) int { return len(m) },
+}).Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>AKS must-gather report</title>
+<style>
+body { font-family: -apple-system, "Segoe UI", Helvetica, Arial, sans-serif; margin: 2em; color: #222; }
+table { border-collapse: collapse; margin: 0.5em 0 1.5em; }
+th, td { border: 1px solid #ccc; padding: 0.3em 0.6em; text-align: left; vertical-align: top; }
+th { background: #f3f3f3; }
+tr.problem td { background: #fdecea; }
+td.num { text-align: right; }
+code { font-size: 0.9em; }
+.note { color: #666; }
+</style>
+</head>
+<body>
+<h1>AKS must-gather report</h1>
+<p class="note">Generated {{.Generated}}. {{.Pods}} pods in {{len .Namespaces}} namespaces, {{.Restarts}} container restarts, {{.Warnings}} warning events.{{if .Note}} {{.Note}}{{end}}</p>
+
+<h2>Namespaces</h2>
+<table>
+<tr><th>Namespace</th><th>Pods</th><th>Not running</th><th>Restarts</th><th>Warning events</th></tr>
+{{- range .Namespaces}}
+<tr{{if or .NotRunning .Warnings}} class="problem"{{end}}><td><a href="#{{.Anchor}}">{{.Name}}</a></td><td class="num">{{len .Pods}}</td><td class="num">{{.NotRunning}}</td><td class="num">{{.Restarts}}</td><td class="num">{{.Warnings}}</td></tr>
+{{- end}}
+</table>
+
+{{- define "link"}}{{if .Href}}<a href="{{.Href}}">{{.Label}}</a>{{else}}<code>{{.Path}}</code>{{end}}{{end}}
+
+{{- range .Namespaces}}
+<h2 id="{{.Anchor}}">{{.Name}}</h2>
+{{- if .Pods}}
+<table>
+<tr><th>Pod</th><th>Status</th><th>Node</th><th>Container</th><th>State</th><th>Restarts</th><th>Logs</th><th>Pod files</th></tr>
+{{- range .Pods}}
+{{- $pod := .}}
+{{- range $i, $c := .Containers}}
+<tr{{if $pod.Problem}} class="problem"{{end}}>
+{{- if eq $i 0}}<td rowspan="{{len $pod.Containers}}">{{$pod.Name}}</td><td rowspan="{{len $pod.Containers}}">{{$pod.Status}}</td><td rowspan="{{len $pod.Containers}}">{{$pod.Node}}</td>{{end}}
+<td>{{$c.Name}}</td><td>{{$c.State}}</td><td class="num">{{$c.Restarts}}</td>
+<td>{{range $j, $l := $c.Logs}}{{if $j}}, {{end}}{{template "link" $l}}{{end}}</td>
+{{- if eq $i 0}}<td rowspan="{{len $pod.Containers}}">{{if $pod.Manifest.Path}}{{template "link" $pod.Manifest}}{{end}}{{if and $pod.Manifest.Path $pod.Events.Path}}, {{end}}{{if $pod.Events.Path}}{{template "link" $pod.Events}}{{end}}</td>{{end}}
+</tr>
+{{- else}}
+<tr{{if $pod.Problem}} class="problem"{{end}}><td>{{$pod.Name}}</td><td>{{$pod.Status}}</td><td>{{$pod.Node}}</td><td></td><td></td><td></td><td></td><td>{{if $pod.Manifest.Path}}{{template "link" $pod.Manifest}}{{end}}{{if and $pod.Manifest.Path $pod.Events.Path}}, {{end}}{{if $pod.Events.Path}}{{template "link" $pod.Events}}{{end}}</td></tr>
+{{- end}}
+{{- end}}
+</table>
+{{- end}}
+{{- if .Events}}
+<h3>Events{{if .EventsLog.Path}} ({{template "link" .EventsLog}}){{end}}</h3>
+<table>
+<tr><th>Type</th><th>Reason</th><th>Count</th><th>Objects</th><th>First</th><th>Last</th><th>Latest message</th></tr>
+{{- range .Events}}
+<tr{{if eq .Type "Warning"}} class="problem"{{end}}><td>{{.Type}}</td><td>{{.Reason}}</td><td class="num">{{.Count}}</td><td class="num">{{objects .Objects}}</td><td>{{.First}}</td><td>{{.Last}}</td><td>{{.LastMessage}}</td></tr>
+{{- end}}
+</table>
+{{- end}}
+{{- end}}
+</body>
+</html>
+`))
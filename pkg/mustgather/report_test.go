@@ -0,0 +1,111 @@
+package mustgather
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func observeReportFixture(r *htmlReport) {
+	for _, row := range []map[string]any{
+		{"TimeGenerated": "2024-01-01T00:00:00Z", "Namespace": "shop", "Name": "cart-7d9f", "PodStatus": "Running", "Computer": "aks-node-1",
+			"ContainerName": "uid-1/cart", "ContainerStatus": "running", "ContainerRestartCount": float64(1)},
+		{"TimeGenerated": "2024-01-01T00:10:00Z", "Namespace": "shop", "Name": "cart-7d9f", "PodStatus": "Running", "Computer": "aks-node-1",
+			"ContainerName": "uid-1/cart", "ContainerStatus": "waiting", "ContainerStatusReason": "CrashLoopBackOff", "ContainerRestartCount": float64(4)},
+		{"TimeGenerated": "2024-01-01T00:10:00Z", "Namespace": "web", "Name": "front-1", "PodStatus": "Running", "Computer": "aks-node-2",
+			"ContainerName": "uid-2/nginx", "ContainerStatus": "running", "ContainerRestartCount": float64(0)},
+	} {
+		r.observe("KubePodInventory", row)
+	}
+	for _, id := range []string{"c1", "c2"} {
+		r.observe("ContainerLogV2", map[string]any{
+			"TimeGenerated": "2024-01-01T00:05:00Z", "PodNamespace": "shop", "PodName": "cart-7d9f",
+			"ContainerName": "cart", "ContainerId": id, "LogMessage": "boom",
+		})
+	}
+	r.observe("KubeEvents", map[string]any{
+		"TimeGenerated": "2024-01-01T00:06:00Z", "Namespace": "shop", "ObjectKind": "Pod", "Name": "cart-7d9f",
+		"KubeEventType": "Warning", "Reason": "BackOff", "Message": "Back-off restarting <cart>", "Count": float64(3),
+	})
+}
+
+func TestHTMLReportInArchive(t *testing.T) {
+	r := newHTMLReport(&Config{StitchLogs: true, StitchIncludeEvents: true})
+	observeReportFixture(r)
+
+	got, ok := readTransform(t, r)[reportPath]
+	if !ok {
+		t.Fatalf("%s not written", reportPath)
+	}
+	for _, want := range []string{
+		`<a href="#ns-shop">shop</a></td><td class="num">1</td><td class="num">0</td><td class="num">4</td><td class="num">3</td>`,
+		`<td>cart</td><td>waiting (CrashLoopBackOff)</td><td class="num">4</td>`,
+		`<a href="../namespaces/shop/pods/cart-7d9f/cart.log">current</a>, <a href="../namespaces/shop/pods/cart-7d9f/cart.previous.log">previous</a>`,
+		`<a href="../namespaces/shop/pods/cart-7d9f/pod.yaml">pod.yaml</a>, <a href="../namespaces/shop/pods/cart-7d9f/events.log">events.log</a>`,
+		`<a href="../namespaces/shop/events/events.log">events.log</a>`,
+		`<td>Warning</td><td>BackOff</td><td class="num">3</td>`,
+		// Messages are escaped
+		`Back-off restarting &lt;cart&gt;`,
+		`<h2 id="ns-web">web</h2>`,
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("report missing %q:\n%s", want, got)
+		}
+	}
+	if strings.Contains(got, "nginx.log") {
+		t.Errorf("containers without logs should not link them")
+	}
+}
+
+func TestHTMLReportWithoutStitching(t *testing.T) {
+	r := newHTMLReport(&Config{})
+	observeReportFixture(r)
+
+	got := readTransform(t, r)[reportPath]
+	if strings.Contains(got, "cart.log") || strings.Contains(got, "events.log") {
+		t.Errorf("logs that are not stitched should not be linked:\n%s", got)
+	}
+	if !strings.Contains(got, `<a href="../namespaces/shop/pods/cart-7d9f/pod.yaml">`) {
+		t.Errorf("pod manifest should still be linked")
+	}
+}
+
+func TestWriteReportStandalone(t *testing.T) {
+	src := filepath.Join(t.TempDir(), "bundle")
+	for name, content := range map[string]string{
+		"index.json": `{"tables":["KubePodInventory"]}`,
+		"tables/KubePodInventory/parts/0000-a.ndjson": `{"TimeGenerated":"2024-01-01T00:00:00Z","Namespace":"shop","Name":"cart-7d9f","PodStatus":"Failed","ContainerName":"uid-1/cart","ContainerRestartCount":2}` + "\n",
+		"namespaces/shop/pods/cart-7d9f/cart.log": "boom\n",
+	} {
+		p := filepath.Join(src, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	out := filepath.Join(filepath.Dir(src), "out", "report.html")
+	if err := WriteReport(src, out); err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := string(b)
+	for _, want := range []string{
+		`<a href="../bundle/namespaces/shop/pods/cart-7d9f/cart.log">current</a>`,
+		`<tr class="problem"><td rowspan="1">cart-7d9f</td><td rowspan="1">Failed</td>`,
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("report missing %q:\n%s", want, got)
+		}
+	}
+	// The bundle has no pod.yaml, so none is linked
+	if strings.Contains(got, "pod.yaml") {
+		t.Errorf("missing files should not be linked")
+	}
+}
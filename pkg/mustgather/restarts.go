@@ -0,0 +1,256 @@
+package mustgather
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"kubectl-must-gather/pkg/utils"
+)
+
+// Where the restart analysis is written.
+const (
+	restartsPath      = "analysis/restarts.json"
+	restartsTablePath = "analysis/restarts.txt"
+)
+
+// restartErrorWindow is how far from a restart the nearest error logs are
+// looked for.
+const restartErrorWindow = 5 * time.Minute
+
+// restartAnalysis writes analysis/restarts.json and analysis/restarts.txt:
+// the containers that restarted, when, and the error logs nearest to each
+// restart. Restarts are derived from the KubePodInventory snapshots gathered
+// in signals: a restart is when a snapshot first reports a higher restart
+// count than the one before, timed by the container's last termination when
+// the inventory has it.
+type restartAnalysis struct {
+	signals *clusterSignals
+}
+
+func newRestartAnalysis(signals *clusterSignals) *restartAnalysis {
+	return &restartAnalysis{signals: signals}
+}
+
+// podRestarts is a pod's entry in analysis/restarts.json.
+type podRestarts struct {
+	Namespace string `json:"namespace"`
+	Pod       string `json:"pod"`
+	// Restarts counts restarts within the window, TotalRestarts the
+	// restart counts reported at the end of it.
+	Restarts      int64                `json:"restarts"`
+	TotalRestarts int64                `json:"totalRestarts"`
+	FirstRestart  string               `json:"firstRestart,omitempty"`
+	LastRestart   string               `json:"lastRestart,omitempty"`
+	Containers    []*containerRestarts `json:"containers"`
+}
+
+type containerRestarts struct {
+	Container     string          `json:"container"`
+	Restarts      int64           `json:"restarts"`
+	TotalRestarts int64           `json:"totalRestarts"`
+	FirstRestart  string          `json:"firstRestart,omitempty"`
+	LastRestart   string          `json:"lastRestart,omitempty"`
+	State         string          `json:"state,omitempty"`
+	History       []*restartEntry `json:"history,omitempty"`
+}
+
+// restartEntry is one rise of the restart count. Count is more than one when
+// the container restarted again before the next inventory snapshot.
+type restartEntry struct {
+	Time       string `json:"time"`
+	DetectedAt string `json:"detectedAt"`
+	Count      int64  `json:"count"`
+	Reason     string `json:"reason,omitempty"`
+	ExitCode   *int64 `json:"exitCode,omitempty"`
+	// NearestError is the minute of error logs closest to the restart,
+	// within restartErrorWindow.
+	NearestError *nearestError `json:"nearestError,omitempty"`
+}
+
+type nearestError struct {
+	Minute string `json:"minute"`
+	// OffsetSeconds is the minute's start relative to the restart; negative
+	// means before it.
+	OffsetSeconds int64  `json:"offsetSeconds"`
+	Lines         int    `json:"lines"`
+	Sample        string `json:"sample"`
+}
+
+func (r *restartAnalysis) observe(table string, row map[string]any) {}
+
+func (r *restartAnalysis) endChunk(tarw *utils.TarWriter) error {
+	return nil
+}
+
+func (r *restartAnalysis) warnings(table string) []string {
+	return nil
+}
+
+func (r *restartAnalysis) finish(tarw *utils.TarWriter) error {
+	pods := r.pods()
+	b, err := json.MarshalIndent(map[string]any{"pods": pods}, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := utils.WriteFileToTar(tarw, restartsPath, b); err != nil {
+		return err
+	}
+	return utils.WriteFileToTar(tarw, restartsTablePath, restartsTable(pods))
+}
+
+// pods returns the pods with restarted containers, most restarts in the
+// window first.
+func (r *restartAnalysis) pods() []*podRestarts {
+	errs := map[string][]*errorBucket{}
+	for _, b := range r.signals.errors {
+		key := b.namespace + "/" + b.pod + "/" + b.container
+		errs[key] = append(errs[key], b)
+	}
+
+	byPod := map[string]*podRestarts{}
+	for _, c := range r.signals.containers {
+		if c.maxRestarts == 0 {
+			continue
+		}
+		cr := containerRestartsOf(c, errs[containerKey(c)])
+		key := c.namespace + "/" + c.pod
+		p, ok := byPod[key]
+		if !ok {
+			p = &podRestarts{Namespace: c.namespace, Pod: c.pod}
+			byPod[key] = p
+		}
+		p.Restarts += cr.Restarts
+		p.TotalRestarts += cr.TotalRestarts
+		if cr.FirstRestart != "" && (p.FirstRestart == "" || timeBefore(cr.FirstRestart, p.FirstRestart)) {
+			p.FirstRestart = cr.FirstRestart
+		}
+		if cr.LastRestart != "" && later(cr.LastRestart, p.LastRestart) {
+			p.LastRestart = cr.LastRestart
+		}
+		p.Containers = append(p.Containers, cr)
+	}
+
+	out := make([]*podRestarts, 0, len(byPod))
+	for _, p := range byPod {
+		sort.Slice(p.Containers, func(i, j int) bool { return p.Containers[i].Container < p.Containers[j].Container })
+		out = append(out, p)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		a, b := out[i], out[j]
+		if a.Restarts != b.Restarts {
+			return a.Restarts > b.Restarts
+		}
+		if a.TotalRestarts != b.TotalRestarts {
+			return a.TotalRestarts > b.TotalRestarts
+		}
+		return a.Namespace+"/"+a.Pod < b.Namespace+"/"+b.Pod
+	})
+	return out
+}
+
+// containerRestartsOf turns the restart counts seen for c into its restart
+// history, correlated with its error log minutes errs.
+func containerRestartsOf(c *containerHealth, errs []*errorBucket) *containerRestarts {
+	cr := &containerRestarts{
+		Container:     c.container,
+		Restarts:      c.maxRestarts - c.minRestarts,
+		TotalRestarts: c.maxRestarts,
+		State:         c.reason,
+	}
+	counts := make([]int64, 0, len(c.counts))
+	for n := range c.counts {
+		counts = append(counts, n)
+	}
+	sort.Slice(counts, func(i, j int) bool { return counts[i] < counts[j] })
+	for i := 1; i < len(counts); i++ {
+		o := c.counts[counts[i]]
+		e := &restartEntry{DetectedAt: o.firstSeen, Time: o.firstSeen, Count: counts[i] - counts[i-1], Reason: o.reason, ExitCode: o.exitCode}
+		// The termination that led to the new count is the better timestamp,
+		// unless it is stale, from before the previous count was seen
+		if o.finishedAt != "" && timeBefore(c.counts[counts[i-1]].firstSeen, o.finishedAt) {
+			e.Time = o.finishedAt
+		}
+		e.NearestError = nearestErrorTo(e.Time, errs)
+		cr.History = append(cr.History, e)
+	}
+	if n := len(cr.History); n > 0 {
+		cr.FirstRestart = cr.History[0].Time
+		cr.LastRestart = cr.History[n-1].Time
+	}
+	return cr
+}
+
+// nearestErrorTo returns the error log minute closest to tm, or nil when
+// none is within restartErrorWindow.
+func nearestErrorTo(tm string, errs []*errorBucket) *nearestError {
+	t := utils.ParseTimeRFC3339(tm)
+	if t.IsZero() {
+		return nil
+	}
+	var best *nearestError
+	var bestDist time.Duration
+	for _, b := range errs {
+		m := utils.ParseTimeRFC3339(b.minute)
+		off := m.Sub(t)
+		dist := off
+		if dist < 0 {
+			dist = -dist
+		}
+		// A minute bucket holds lines up to a minute after its start
+		if off < 0 && -off < time.Minute {
+			dist = 0
+		}
+		if dist > restartErrorWindow || (best != nil && (dist > bestDist || (dist == bestDist && b.minute > best.Minute))) {
+			continue
+		}
+		best = &nearestError{Minute: b.minute, OffsetSeconds: int64(off / time.Second), Lines: b.count, Sample: b.sample}
+		bestDist = dist
+	}
+	return best
+}
+
+// restartsTable renders the restarted containers as an aligned text table.
+func restartsTable(pods []*podRestarts) []byte {
+	var buf bytes.Buffer
+	w := tabwriter.NewWriter(&buf, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "NAMESPACE\tPOD\tCONTAINER\tRESTARTS\tTOTAL\tFIRST RESTART\tLAST RESTART\tLAST REASON\tLAST NEAREST ERROR")
+	for _, p := range pods {
+		for _, c := range p.Containers {
+			// The latest restart that has each detail
+			reason, nearest := "-", "-"
+			for i := len(c.History) - 1; i >= 0; i-- {
+				e := c.History[i]
+				if reason == "-" && e.Reason != "" {
+					reason = e.Reason
+					if e.ExitCode != nil {
+						reason = fmt.Sprintf("%s (exit %d)", reason, *e.ExitCode)
+					}
+				}
+				if nearest == "-" && e.NearestError != nil {
+					ne := e.NearestError
+					nearest = fmt.Sprintf("%+ds, %d lines: %s", ne.OffsetSeconds, ne.Lines, shorten(ne.Sample, 80))
+				}
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%d\t%s\t%s\t%s\t%s\n", p.Namespace, p.Pod, c.Container, c.Restarts, c.TotalRestarts, orNone(c.FirstRestart), orNone(c.LastRestart), reason, nearest)
+		}
+	}
+	if len(pods) == 0 {
+		fmt.Fprintln(w, "(no restarts)")
+	}
+	w.Flush()
+	return buf.Bytes()
+}
+
+// shorten cuts s to n bytes for table cells.
+func shorten(s string, n int) string {
+	s = strings.ReplaceAll(s, "\t", " ")
+	if len(s) > n {
+		return s[:n] + "..."
+	}
+	return s
+}
@@ -0,0 +1,99 @@
+package mustgather
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func observeRestartsFixture(s *clusterSignals) {
+	for _, snap := range []struct {
+		tm       string
+		restarts float64
+		last     string
+	}{
+		{"2024-01-01T00:00:00Z", 2, `{"reason":"Error","exitCode":1,"finishedAt":"2023-12-31T23:00:00Z"}`},
+		{"2024-01-01T00:10:00Z", 3, `{"reason":"Error","exitCode":1,"finishedAt":"2024-01-01T00:08:30Z"}`},
+		// Seen again later with the same count
+		{"2024-01-01T00:12:00Z", 3, `{"reason":"Error","exitCode":1,"finishedAt":"2024-01-01T00:08:30Z"}`},
+		// Two restarts between snapshots, last termination not reported
+		{"2024-01-01T00:30:00Z", 5, ``},
+	} {
+		s.observe("KubePodInventory", map[string]any{
+			"TimeGenerated": snap.tm, "Namespace": "shop", "Name": "cart-7d9f", "ContainerName": "uid-1/cart",
+			"ContainerRestartCount": snap.restarts, "ContainerStatusReason": "CrashLoopBackOff", "ContainerLastStatus": snap.last,
+		})
+	}
+	s.observe("KubePodInventory", map[string]any{
+		"TimeGenerated": "2024-01-01T00:00:00Z", "Namespace": "shop", "Name": "cart-7d9f", "ContainerName": "uid-1/sidecar",
+		"ContainerRestartCount": float64(1),
+	})
+	s.observe("KubePodInventory", map[string]any{
+		"TimeGenerated": "2024-01-01T00:00:00Z", "Namespace": "shop", "Name": "web-1", "ContainerName": "uid-2/web",
+		"ContainerRestartCount": float64(0),
+	})
+	for i := 0; i < 3; i++ {
+		s.observe("ContainerLogV2", map[string]any{
+			"TimeGenerated": fmt.Sprintf("2024-01-01T00:08:%02dZ", 10+i), "PodNamespace": "shop", "PodName": "cart-7d9f",
+			"ContainerName": "cart", "LogMessage": "ERROR db connection refused",
+		})
+	}
+	// Too far from any restart
+	s.observe("ContainerLogV2", map[string]any{
+		"TimeGenerated": "2024-01-01T00:20:00Z", "PodNamespace": "shop", "PodName": "cart-7d9f",
+		"ContainerName": "cart", "LogMessage": "ERROR unrelated",
+	})
+}
+
+func TestRestartAnalysis(t *testing.T) {
+	s := newClusterSignals()
+	observeRestartsFixture(s)
+
+	files := readTransform(t, newRestartAnalysis(s))
+	var got struct {
+		Pods []*podRestarts `json:"pods"`
+	}
+	if err := json.Unmarshal([]byte(files[restartsPath]), &got); err != nil {
+		t.Fatalf("parse %s: %v\n%s", restartsPath, err, files[restartsPath])
+	}
+	if len(got.Pods) != 1 {
+		t.Fatalf("pods = %d, want 1 (web-1 never restarted)", len(got.Pods))
+	}
+	p := got.Pods[0]
+	if p.Restarts != 3 || p.TotalRestarts != 6 || p.FirstRestart != "2024-01-01T00:08:30Z" || p.LastRestart != "2024-01-01T00:30:00Z" || len(p.Containers) != 2 {
+		t.Fatalf("pod = %+v", p)
+	}
+	cart := p.Containers[0]
+	if cart.Container != "cart" || cart.Restarts != 3 || len(cart.History) != 2 {
+		t.Fatalf("cart = %+v", cart)
+	}
+	first, second := cart.History[0], cart.History[1]
+	if first.Time != "2024-01-01T00:08:30Z" || first.DetectedAt != "2024-01-01T00:10:00Z" || first.Count != 1 || first.Reason != "Error" || first.ExitCode == nil || *first.ExitCode != 1 {
+		t.Errorf("first restart = %+v", first)
+	}
+	if e := first.NearestError; e == nil || e.Minute != "2024-01-01T00:08:00Z" || e.Lines != 3 || e.OffsetSeconds != -30 || e.Sample != "ERROR db connection refused" {
+		t.Errorf("nearest error = %+v", e)
+	}
+	if second.Time != "2024-01-01T00:30:00Z" || second.Count != 2 || second.NearestError != nil {
+		t.Errorf("second restart = %+v", second)
+	}
+	// Restarted before the window only
+	if sc := p.Containers[1]; sc.Container != "sidecar" || sc.Restarts != 0 || sc.TotalRestarts != 1 || sc.FirstRestart != "" {
+		t.Errorf("sidecar = %+v", sc)
+	}
+
+	table := files[restartsTablePath]
+	for _, want := range []string{"NAMESPACE", "shop", "cart-7d9f", "-30s, 3 lines: ERROR db connection refused"} {
+		if !strings.Contains(table, want) {
+			t.Errorf("%s missing %q:\n%s", restartsTablePath, want, table)
+		}
+	}
+}
+
+func TestRestartAnalysisEmpty(t *testing.T) {
+	files := readTransform(t, newRestartAnalysis(newClusterSignals()))
+	if !strings.Contains(files[restartsPath], `"pods": []`) || !strings.Contains(files[restartsTablePath], "(no restarts)") {
+		t.Errorf("unexpected empty analysis: %v", files)
+	}
+}
@@ -0,0 +1,87 @@
+package mustgather
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"kubectl-must-gather/pkg/utils"
+)
+
+// restrictedArchive is the second output of a two-tier gather: the table rows
+// as queried, before --redaction-rules masked them for the public archive,
+// encrypted to an internal key once written. Credentials are scrubbed from
+// both archives.
+type restrictedArchive struct {
+	path   string
+	file   *os.File
+	gz     *gzip.Writer
+	tarw   *utils.TarWriter
+	tables []string
+}
+
+// checkRestricted validates the two-tier settings and returns the tool that
+// encrypts the restricted archive, or "" without one.
+func (c *Config) checkRestricted() (string, error) {
+	if c.RestrictedOutput == "" {
+		return "", nil
+	}
+	if c.Redaction == nil {
+		return "", errors.New("a restricted archive needs --redaction-rules to sanitize the public one")
+	}
+	if len(c.RestrictedEncryptTo) == 0 {
+		return "", errors.New("a restricted archive needs --restricted-encrypt-to: it is only kept encrypted")
+	}
+	if filepath.Clean(c.RestrictedOutput) == filepath.Clean(c.GenerateDefaultOutputName()) {
+		return "", errors.New("the restricted archive must not overwrite the public one")
+	}
+	return encryptionTool(c.RestrictedEncryptTo)
+}
+
+func openRestrictedArchive(path string, modTime time.Time) (*restrictedArchive, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("create restricted archive: %w", err)
+	}
+	gz := gzip.NewWriter(f)
+	return &restrictedArchive{path: path, file: f, gz: gz, tarw: utils.NewTarWriter(gz, modTime)}, nil
+}
+
+// finishRestricted writes the index of the restricted archive, closes it and
+// encrypts it with tool. The unencrypted archive is removed either way, so
+// the unredacted rows are never left readable on disk.
+func (g *Gatherer) finishRestricted(tool string) error {
+	a := g.restricted
+	index := map[string]any{
+		"tables":        a.tables,
+		"publicArchive": filepath.Base(g.outFile),
+		"workspaceId":   g.config.WorkspaceID,
+		"start":         g.start.UTC().Format(time.RFC3339),
+		"end":           g.end.UTC().Format(time.RFC3339),
+		// The rules the public archive was redacted with
+		"redactionRules": g.config.Redaction.ruleNames(),
+	}
+	if g.incomplete() {
+		index["incomplete"] = true
+	}
+	b, _ := json.MarshalIndent(index, "", "  ")
+	err := utils.WriteFileToTar(a.tarw, "index.json", b)
+	err = errors.Join(err, a.tarw.Close(), a.gz.Close(), a.file.Close())
+	if err != nil {
+		os.Remove(a.path)
+		return fmt.Errorf("write restricted archive: %w", err)
+	}
+	enc, err := encryptFile(context.Background(), tool, a.path, g.config.RestrictedEncryptTo)
+	if err != nil {
+		os.Remove(a.path)
+		return fmt.Errorf("encrypt restricted archive, removed it: %w", err)
+	}
+	fmt.Fprintf(g.log, "Wrote restricted archive %s\n", enc)
+	g.restrictedFile = enc
+	return nil
+}
@@ -0,0 +1,42 @@
+package mustgather
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCheckRestricted(t *testing.T) {
+	fakeAge(t)
+	redactor := &Redactor{Names: []string{"email"}}
+	if err := redactor.compile(); err != nil {
+		t.Fatal(err)
+	}
+	out := filepath.Join(t.TempDir(), "public.tar.gz")
+	tests := []struct {
+		name     string
+		config   Config
+		wantTool string
+		wantErr  string
+	}{
+		{"off", Config{OutputFile: out}, "", ""},
+		{"age", Config{OutputFile: out, RestrictedOutput: "restricted.tar.gz", Redaction: redactor, RestrictedEncryptTo: []string{"age1abc"}}, EncryptAge, ""},
+		{"no redaction", Config{OutputFile: out, RestrictedOutput: "restricted.tar.gz", RestrictedEncryptTo: []string{"age1abc"}}, "", "needs --redaction-rules"},
+		{"no recipient", Config{OutputFile: out, RestrictedOutput: "restricted.tar.gz", Redaction: redactor}, "", "needs --restricted-encrypt-to"},
+		{"same file", Config{OutputFile: out, RestrictedOutput: out, Redaction: redactor, RestrictedEncryptTo: []string{"age1abc"}}, "", "must not overwrite"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tool, err := tt.config.checkRestricted()
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("expected error containing %q, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil || tool != tt.wantTool {
+				t.Errorf("checkRestricted = %q, %v; want %q", tool, err, tt.wantTool)
+			}
+		})
+	}
+}
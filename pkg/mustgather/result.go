@@ -0,0 +1,105 @@
+package mustgather
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// GatherResult describes a gather once it is over: where its outputs went,
+// what each table exported and what went wrong. Run returns it, and
+// --output-json writes it to stdout as the only output of the gather.
+type GatherResult struct {
+	// Archive is where the bundle went: an archive path, a directory or a
+	// blob container URL without its SAS token.
+	Archive           string   `json:"archive,omitempty"`
+	RestrictedArchive string   `json:"restrictedArchive,omitempty"`
+	Manifest          string   `json:"manifest,omitempty"`
+	Signature         string   `json:"signature,omitempty"`
+	Uploaded          []string `json:"uploaded,omitempty"`
+	// Status is "ok", "incomplete" when the gather was interrupted or timed
+	// out, or "failed"; Error says why.
+	Status          string        `json:"status"`
+	Error           string        `json:"error,omitempty"`
+	StartedAt       string        `json:"startedAt"`
+	Duration        string        `json:"duration"`
+	DurationSeconds float64       `json:"durationSeconds"`
+	WindowStart     string        `json:"windowStart,omitempty"`
+	WindowEnd       string        `json:"windowEnd,omitempty"`
+	Tables          []TableResult `json:"tables"`
+	Rows            int64         `json:"rows"`
+	Bytes           int64         `json:"bytes"`
+	Warnings        []string      `json:"warnings"`
+}
+
+// TableResult is what the gather did with one table. Tables skipped or
+// failed have no rows and a note or error saying why.
+type TableResult struct {
+	Name  string `json:"name"`
+	Rows  int    `json:"rows"`
+	Bytes int64  `json:"bytes"`
+	Error string `json:"error,omitempty"`
+	// Err is the error behind Error, for errors.Is with ErrThrottled and
+	// the other classes.
+	Err             error    `json:"-"`
+	DurationSeconds float64  `json:"durationSeconds,omitempty"`
+	Notes           []string `json:"notes,omitempty"`
+}
+
+// addWarning records a warning for the --output-json result.
+func (g *Gatherer) addWarning(msg string) {
+	g.warnMu.Lock()
+	defer g.warnMu.Unlock()
+	g.warns = append(g.warns, msg)
+}
+
+// result returns the GatherResult of a gather that ended with err.
+func (g *Gatherer) result(err error) *GatherResult {
+	d := time.Since(g.startedAt)
+	r := &GatherResult{
+		Archive:           g.outFile,
+		RestrictedArchive: g.restrictedFile,
+		Manifest:          g.manifestFile,
+		Signature:         g.signatureFile,
+		Uploaded:          g.uploaded,
+		Status:            "ok",
+		StartedAt:         g.startedAt.UTC().Format(time.RFC3339),
+		Duration:          d.Round(time.Millisecond).String(),
+		DurationSeconds:   d.Seconds(),
+		Tables:            []TableResult{},
+		Warnings:          []string{},
+	}
+	if !g.start.IsZero() {
+		r.WindowStart, r.WindowEnd = g.start.UTC().Format(time.RFC3339), g.end.UTC().Format(time.RFC3339)
+	}
+	switch {
+	case g.incomplete():
+		r.Status = "incomplete"
+	case err != nil:
+		r.Status = "failed"
+	}
+	if err != nil {
+		r.Error = err.Error()
+	}
+	for _, o := range g.outcomes {
+		tr := TableResult{Name: o.table, Rows: o.rows, Bytes: o.bytes, DurationSeconds: o.duration.Seconds(), Notes: o.notes}
+		if o.err != nil {
+			tr.Error, tr.Err = o.err.Error(), classify(o.err)
+		}
+		r.Tables = append(r.Tables, tr)
+		r.Rows += int64(o.rows)
+		r.Bytes += o.bytes
+	}
+	g.warnMu.Lock()
+	r.Warnings = append(r.Warnings, g.warns...)
+	g.warnMu.Unlock()
+	return r
+}
+
+// writeResult writes r to stdout for --output-json.
+func (g *Gatherer) writeResult(r *GatherResult) {
+	if !g.config.OutputJSON {
+		return
+	}
+	b, _ := json.MarshalIndent(r, "", "  ")
+	_, _ = g.stdout.Write(append(b, '\n'))
+}
@@ -0,0 +1,73 @@
+package mustgather
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/operationalinsights/armoperationalinsights"
+)
+
+// tableCoverage is the time range a table's export actually covers, recorded
+// as "coverage" in its summary.json. Rows older than the table's interactive
+// retention cannot be queried, so a window reaching further back covers less
+// than was asked for.
+type tableCoverage struct {
+	Start           string `json:"start"`
+	End             string `json:"end"`
+	RetentionInDays int32  `json:"retentionInDays,omitempty"`
+	// RequestedStart is set when the window started before the retention.
+	RequestedStart string `json:"requestedStart,omitempty"`
+	// Clamped is set when the queries were shortened to the retention
+	// rather than sent for the whole window.
+	Clamped bool `json:"clamped,omitempty"`
+
+	start time.Time
+}
+
+// tableRetention returns the interactive retention of a table, or 0 when the
+// management plane does not say.
+func tableRetention(t armoperationalinsights.Table) int32 {
+	if t.Properties == nil || t.Properties.RetentionInDays == nil {
+		return 0
+	}
+	return *t.Properties.RetentionInDays
+}
+
+// coverage works out the range the export of table can cover over the gather
+// window given its retention in days (0 if unknown), warning when the window
+// reaches beyond it. With ClampToRetention the export starts at the
+// retention boundary instead of querying chunks that can hold no rows.
+func (g *Gatherer) coverage(table string, retention int32, now time.Time) *tableCoverage {
+	cov := &tableCoverage{start: g.start, RetentionInDays: retention}
+	defer func() {
+		cov.Start, cov.End = cov.start.UTC().Format(time.RFC3339), g.end.UTC().Format(time.RFC3339)
+	}()
+	if retention <= 0 {
+		return cov
+	}
+	oldest := now.AddDate(0, 0, -int(retention))
+	if !g.start.Before(oldest) {
+		return cov
+	}
+	cov.RequestedStart = g.start.UTC().Format(time.RFC3339)
+	if !oldest.Before(g.end) {
+		oldest = g.end
+	}
+	cov.start = oldest
+	if g.config.ClampToRetention {
+		cov.Clamped = true
+		g.warnf(table, "%s keeps %d days of data; querying it from %s instead of %s", table, retention, oldest.UTC().Format(time.RFC3339), cov.RequestedStart)
+	} else {
+		g.warnf(table, "%s keeps %d days of data; rows before %s are no longer in the workspace", table, retention, oldest.UTC().Format(time.RFC3339))
+	}
+	return cov
+}
+
+// note describes the shortfall for the table outcomes, or "" when the export
+// covers the whole window.
+func (c *tableCoverage) note() string {
+	if c.RequestedStart == "" {
+		return ""
+	}
+	return fmt.Sprintf("covers %s onward only (%d-day retention)", c.Start, c.RetentionInDays)
+}
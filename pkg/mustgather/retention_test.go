@@ -0,0 +1,73 @@
+package mustgather
+
+import (
+	"io"
+	"testing"
+	"time"
+)
+
+func TestCoverage(t *testing.T) {
+	now := time.Date(2024, 3, 31, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name      string
+		start     time.Time
+		retention int32
+		clamp     bool
+		wantStart string
+		wantReq   string
+		clamped   bool
+		warned    bool
+	}{
+		{
+			name:      "unknown retention",
+			start:     now.AddDate(0, 0, -60),
+			wantStart: "2024-01-31T12:00:00Z",
+		},
+		{
+			name:      "window within retention",
+			start:     now.AddDate(0, 0, -7),
+			retention: 30,
+			clamp:     true,
+			wantStart: "2024-03-24T12:00:00Z",
+		},
+		{
+			name:      "clamped to retention",
+			start:     now.AddDate(0, 0, -60),
+			retention: 30,
+			clamp:     true,
+			wantStart: "2024-03-01T12:00:00Z",
+			wantReq:   "2024-01-31T12:00:00Z",
+			clamped:   true,
+			warned:    true,
+		},
+		{
+			name:      "beyond retention without clamping",
+			start:     now.AddDate(0, 0, -60),
+			retention: 30,
+			wantStart: "2024-03-01T12:00:00Z",
+			wantReq:   "2024-01-31T12:00:00Z",
+			warned:    true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := &Gatherer{config: &Config{ClampToRetention: tt.clamp}, log: io.Discard, start: tt.start, end: now}
+			cov := g.coverage("ContainerLogV2", tt.retention, now)
+			if cov.Start != tt.wantStart || cov.End != "2024-03-31T12:00:00Z" || cov.RequestedStart != tt.wantReq || cov.Clamped != tt.clamped {
+				t.Errorf("unexpected coverage %+v", cov)
+			}
+			if warned := len(g.warns) > 0; warned != tt.warned {
+				t.Errorf("warned = %v, want %v: %v", warned, tt.warned, g.warns)
+			}
+			if (cov.note() != "") != tt.warned {
+				t.Errorf("unexpected note %q", cov.note())
+			}
+		})
+	}
+
+	// A window entirely older than the retention covers nothing
+	g := &Gatherer{config: &Config{ClampToRetention: true}, log: io.Discard, start: now.AddDate(0, 0, -60), end: now.AddDate(0, 0, -45)}
+	if cov := g.coverage("Perf", 30, now); cov.Start != cov.End {
+		t.Errorf("expected an empty coverage, got %+v", cov)
+	}
+}
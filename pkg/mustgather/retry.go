@@ -0,0 +1,88 @@
+package mustgather
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+)
+
+// Backoff bounds for throttled queries. Variables so tests can shorten them.
+var (
+	retryBaseDelay = 2 * time.Second
+	retryMaxDelay  = time.Minute
+)
+
+// sdkRetryStatusCodes are the transient statuses left to the SDK's own retry
+// policy. 429 and 503 are retried by the gatherer instead, with longer
+// backoff and Retry-After support, so throttled chunks are not dropped.
+var sdkRetryStatusCodes = []int{
+	http.StatusRequestTimeout,
+	http.StatusInternalServerError,
+	http.StatusBadGateway,
+	http.StatusGatewayTimeout,
+}
+
+// throttleDelay reports whether err is a throttling response (429 or 503)
+// and how long to wait before retry attempt n (0-based). Retry-After is
+// honored when present; otherwise the delay grows exponentially with full
+// jitter.
+func throttleDelay(err error, attempt int) (time.Duration, bool) {
+	var respErr *azcore.ResponseError
+	if !errors.As(err, &respErr) {
+		return 0, false
+	}
+	if respErr.StatusCode != http.StatusTooManyRequests && respErr.StatusCode != http.StatusServiceUnavailable {
+		return 0, false
+	}
+	if respErr.RawResponse != nil {
+		if d, ok := retryAfter(respErr.RawResponse.Header.Get("Retry-After")); ok {
+			return d, true
+		}
+	}
+	return backoff(attempt), true
+}
+
+// retryAfter parses a Retry-After header given in seconds or as an HTTP date.
+func retryAfter(v string) (time.Duration, bool) {
+	if v == "" {
+		return 0, false
+	}
+	if secs, err := strconv.Atoi(v); err == nil && secs >= 0 {
+		return time.Duration(secs) * time.Second, true
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		if d := time.Until(t); d > 0 {
+			return d, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
+
+// backoff returns a random delay in [0, min(max, base*2^attempt)].
+func backoff(attempt int) time.Duration {
+	d := retryMaxDelay
+	if attempt < 30 {
+		if exp := retryBaseDelay << attempt; exp < d {
+			d = exp
+		}
+	}
+	return time.Duration(rand.Int63n(int64(d) + 1))
+}
+
+// sleepCtx waits for d or until ctx is done.
+func sleepCtx(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
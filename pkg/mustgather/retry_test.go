@@ -0,0 +1,113 @@
+package mustgather
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/runtime"
+	azquery "github.com/Azure/azure-sdk-for-go/sdk/monitor/azquery"
+
+	"kubectl-must-gather/pkg/testhelpers"
+)
+
+func responseError(status int, retryAfter string) error {
+	req := httptest.NewRequest(http.MethodPost, "https://api.loganalytics.io/v1/workspaces/ws/query", nil)
+	resp := &http.Response{StatusCode: status, Header: http.Header{}, Body: http.NoBody, Request: req}
+	if retryAfter != "" {
+		resp.Header.Set("Retry-After", retryAfter)
+	}
+	return runtime.NewResponseError(resp)
+}
+
+func TestThrottleDelay(t *testing.T) {
+	tests := []struct {
+		name          string
+		err           error
+		wantThrottled bool
+		wantDelay     time.Duration
+	}{
+		{name: "429 with Retry-After seconds", err: responseError(429, "7"), wantThrottled: true, wantDelay: 7 * time.Second},
+		{name: "503 with Retry-After date in the past", err: responseError(503, "Mon, 01 Jan 2024 00:00:00 GMT"), wantThrottled: true, wantDelay: 0},
+		{name: "429 without Retry-After uses backoff", err: responseError(429, ""), wantThrottled: true, wantDelay: -1},
+		{name: "400 is not retried", err: responseError(400, "")},
+		{name: "non-HTTP error is not retried", err: errors.New("boom")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d, throttled := throttleDelay(tt.err, 2)
+			if throttled != tt.wantThrottled {
+				t.Fatalf("expected throttled=%v, got %v", tt.wantThrottled, throttled)
+			}
+			if tt.wantDelay >= 0 && d != tt.wantDelay {
+				t.Errorf("expected delay %s, got %s", tt.wantDelay, d)
+			}
+			if tt.wantDelay < 0 && (d < 0 || d > retryBaseDelay<<2) {
+				t.Errorf("backoff delay %s outside [0, %s]", d, retryBaseDelay<<2)
+			}
+		})
+	}
+}
+
+func TestBackoffIsCapped(t *testing.T) {
+	for attempt := 0; attempt < 64; attempt++ {
+		if d := backoff(attempt); d < 0 || d > retryMaxDelay {
+			t.Fatalf("attempt %d: delay %s outside [0, %s]", attempt, d, retryMaxDelay)
+		}
+	}
+}
+
+func TestQueryRetriesThrottledRequests(t *testing.T) {
+	defer func(base time.Duration) { retryBaseDelay = base }(retryBaseDelay)
+	retryBaseDelay = time.Millisecond
+
+	emu := testhelpers.NewLogAnalyticsEmulator(testhelpers.EmulatedTable{
+		Name:    "Heartbeat",
+		Columns: []testhelpers.EmulatedColumn{{Name: "Computer", Type: "string"}},
+		Rows:    [][]any{{"node-1"}},
+	})
+	defer emu.Close()
+
+	newGatherer := func(maxRetries int) (*Gatherer, *azquery.LogsClient) {
+		g := &Gatherer{
+			config: &Config{MaxRetries: maxRetries},
+			ctx:    context.Background(),
+			cred:   emu.Credential(),
+			cloud:  emu.Cloud(),
+			client: emu.Client(),
+			log:    io.Discard,
+		}
+		g.usage = newUsageTracker(g.client)
+		lcli, err := azquery.NewLogsClient(g.cred, g.logsOptions())
+		if err != nil {
+			t.Fatalf("logs client: %v", err)
+		}
+		return g, lcli
+	}
+
+	g, lcli := newGatherer(3)
+	emu.Throttle(1, http.StatusTooManyRequests, "0")
+	emu.Throttle(1, http.StatusServiceUnavailable, "")
+	res, err := g.query(g.ctx, lcli, emu.WorkspaceGUID, "Heartbeat", time.Time{}, time.Now())
+	if err != nil {
+		t.Fatalf("expected query to succeed after retries, got %v", err)
+	}
+	if len(res.Tables) != 1 || len(res.Tables[0].Rows) != 1 {
+		t.Errorf("unexpected result: %+v", res.Tables)
+	}
+	if usage := g.usage.stop(); usage.Queries != 3 || usage.Retries != 2 {
+		t.Errorf("expected 3 queries and 2 retries, got %+v", usage)
+	}
+
+	g, lcli = newGatherer(1)
+	emu.Throttle(2, http.StatusTooManyRequests, "0")
+	if _, err := g.query(g.ctx, lcli, emu.WorkspaceGUID, "Heartbeat", time.Time{}, time.Now()); !errors.Is(err, ErrThrottled) || !strings.Contains(err.Error(), "429") {
+		t.Errorf("expected a throttled 429 error once retries are exhausted, got %v", err)
+	}
+	g.usage.stop()
+}
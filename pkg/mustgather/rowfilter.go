@@ -0,0 +1,425 @@
+package mustgather
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// RowFilter is a KQL-like expression over the columns of table rows, such as
+//
+//	PodNamespace == "shop" and (LogLevel =~ "error" or LogMessage contains "panic")
+//
+// Comparisons are a column, an operator and a value:
+//   - == and != compare exactly, =~ and !~ ignoring case;
+//   - <, <=, > and >= compare numbers as numbers, times (such as
+//     TimeGenerated > datetime(2024-01-01T15:04)) as times, and other
+//     values as text;
+//   - contains, startswith and endswith (and their negations !contains, ...)
+//     match text ignoring case, matches regex a regular expression;
+//   - in and !in compare against a list like ("a", "b").
+//
+// Values are quoted strings, numbers, true, false, null or datetime(...).
+// Comparisons combine with and, or, not and parentheses. A column missing
+// from a row is null: it matches == null, != and the negated operators.
+type RowFilter struct {
+	expr string
+	root filterNode
+}
+
+// filterNode is a node of a parsed RowFilter.
+type filterNode interface {
+	match(row map[string]any) bool
+}
+
+type filterAnd struct{ l, r filterNode }
+type filterOr struct{ l, r filterNode }
+type filterNot struct{ n filterNode }
+
+func (n filterAnd) match(row map[string]any) bool { return n.l.match(row) && n.r.match(row) }
+func (n filterOr) match(row map[string]any) bool  { return n.l.match(row) || n.r.match(row) }
+func (n filterNot) match(row map[string]any) bool { return !n.n.match(row) }
+
+// filterValue is a literal of a RowFilter.
+type filterValue struct {
+	text  string
+	num   float64
+	isNum bool
+	time  time.Time
+	null  bool
+}
+
+// filterCompare is a comparison of a column with literals.
+type filterCompare struct {
+	column string
+	op     string
+	// negated holds for the operators written with a leading !.
+	negated bool
+	values  []filterValue
+	re      *regexp.Regexp
+}
+
+// filterOperators are the operators of a comparison, longest first so that
+// scanning stops at the longest match.
+var filterOperators = []string{
+	"!startswith", "!endswith", "!contains", "startswith", "endswith", "contains",
+	"matches regex", "!in", "in", "==", "!=", "=~", "!~", "<=", ">=", "<", ">",
+}
+
+// ParseRowFilter parses expr; see RowFilter for its syntax.
+func ParseRowFilter(expr string) (*RowFilter, error) {
+	p := &filterParser{src: expr}
+	if p.next(); p.tok == "" {
+		return nil, fmt.Errorf("empty filter")
+	}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.err != nil {
+		return nil, p.err
+	}
+	if p.tok != "" {
+		return nil, p.errorf("unexpected %q", p.tok)
+	}
+	return &RowFilter{expr: expr, root: root}, nil
+}
+
+// String returns the expression f was parsed from.
+func (f *RowFilter) String() string {
+	return f.expr
+}
+
+// Match reports whether row satisfies f.
+func (f *RowFilter) Match(row map[string]any) bool {
+	return f.root.match(row)
+}
+
+// MatchLine reports whether the NDJSON row line satisfies f. Lines that are
+// not JSON objects do not.
+func (f *RowFilter) MatchLine(line []byte) bool {
+	var row map[string]any
+	dec := json.NewDecoder(bytes.NewReader(line))
+	dec.UseNumber()
+	if dec.Decode(&row) != nil {
+		return false
+	}
+	return f.Match(row)
+}
+
+func (c *filterCompare) match(row map[string]any) bool {
+	cell := row[c.column]
+	switch c.op {
+	case "==", "!=":
+		if c.values[0].null {
+			return (cell == nil) == (c.op == "==")
+		}
+	}
+	if cell == nil {
+		// Null matches only the negated operators
+		return c.negated
+	}
+	text := cellText(cell)
+	switch c.op {
+	case "==":
+		return filterEqual(cell, text, c.values[0])
+	case "!=":
+		return !filterEqual(cell, text, c.values[0])
+	case "=~", "!~":
+		return strings.EqualFold(text, c.values[0].text) != c.negated
+	case "contains", "!contains":
+		return strings.Contains(strings.ToLower(text), strings.ToLower(c.values[0].text)) != c.negated
+	case "startswith", "!startswith":
+		return strings.HasPrefix(strings.ToLower(text), strings.ToLower(c.values[0].text)) != c.negated
+	case "endswith", "!endswith":
+		return strings.HasSuffix(strings.ToLower(text), strings.ToLower(c.values[0].text)) != c.negated
+	case "matches regex":
+		return c.re.MatchString(text)
+	case "in", "!in":
+		for _, v := range c.values {
+			if filterEqual(cell, text, v) {
+				return !c.negated
+			}
+		}
+		return c.negated
+	}
+	cmp, ok := filterOrder(text, c.values[0])
+	if !ok {
+		return false
+	}
+	switch c.op {
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	case ">":
+		return cmp > 0
+	}
+	return cmp >= 0
+}
+
+// filterEqual reports whether the cell, as text, equals v, compared as by
+// filterOrder.
+func filterEqual(cell any, text string, v filterValue) bool {
+	if v.null {
+		return cell == nil
+	}
+	cmp, ok := filterOrder(text, v)
+	return ok && cmp == 0
+}
+
+// filterOrder compares the cell text with v, as numbers when both are, as
+// times when both are, and as text otherwise. It fails for null.
+func filterOrder(text string, v filterValue) (int, bool) {
+	if v.null {
+		return 0, false
+	}
+	if v.isNum {
+		if n, err := strconv.ParseFloat(text, 64); err == nil {
+			switch {
+			case n < v.num:
+				return -1, true
+			case n > v.num:
+				return 1, true
+			}
+			return 0, true
+		}
+	}
+	if !v.time.IsZero() {
+		t, err := parseServeTime(text)
+		if err != nil {
+			return 0, false
+		}
+		return t.Compare(v.time), true
+	}
+	if t, err := parseServeTime(v.text); err == nil {
+		if ct, err := parseServeTime(text); err == nil {
+			return ct.Compare(t), true
+		}
+	}
+	return strings.Compare(text, v.text), true
+}
+
+// filterParser is a recursive descent parser of RowFilter expressions. tok
+// is the current token, empty at the end.
+type filterParser struct {
+	src string
+	pos int
+	// tok is the current token and at its offset; str is set when it is a
+	// quoted string, holding its unquoted text.
+	tok string
+	at  int
+	str *string
+	err error
+}
+
+func (p *filterParser) errorf(format string, args ...any) error {
+	return fmt.Errorf("%s at offset %d", fmt.Sprintf(format, args...), p.at)
+}
+
+// next scans the next token.
+func (p *filterParser) next() {
+	for p.pos < len(p.src) && unicode.IsSpace(rune(p.src[p.pos])) {
+		p.pos++
+	}
+	p.at, p.str = p.pos, nil
+	if p.pos >= len(p.src) {
+		p.tok = ""
+		return
+	}
+	rest := p.src[p.pos:]
+	switch c := rest[0]; {
+	case c == '"' || c == '\'':
+		var b strings.Builder
+		end := 1
+		for ; end < len(rest) && rest[end] != c; end++ {
+			if rest[end] == '\\' && end+1 < len(rest) {
+				end++
+				switch rest[end] {
+				case 'n':
+					b.WriteByte('\n')
+				case 't':
+					b.WriteByte('\t')
+				default:
+					b.WriteByte(rest[end])
+				}
+				continue
+			}
+			b.WriteByte(rest[end])
+		}
+		if end >= len(rest) {
+			p.err = p.errorf("unterminated string")
+			p.tok, p.pos = rest, len(p.src)
+			return
+		}
+		text := b.String()
+		p.tok, p.str, p.pos = rest[:end+1], &text, p.pos+end+1
+		return
+	case c == '(' || c == ')' || c == ',':
+		p.tok, p.pos = rest[:1], p.pos+1
+		return
+	}
+	for _, op := range filterOperators {
+		if !strings.HasPrefix(rest, op) {
+			continue
+		}
+		// Word operators end at a word boundary
+		if l := len(op); unicode.IsLetter(rune(op[l-1])) && l < len(rest) && isFilterWord(rest[l]) {
+			continue
+		}
+		p.tok, p.pos = op, p.pos+len(op)
+		return
+	}
+	end := 0
+	for end < len(rest) && (isFilterWord(rest[end]) || rest[end] == '.' || end == 0 && (rest[0] == '-' || rest[0] == '+')) {
+		end++
+	}
+	if end == 0 {
+		end = 1
+	}
+	p.tok, p.pos = rest[:end], p.pos+end
+}
+
+func isFilterWord(c byte) bool {
+	return c == '_' || c < 0x80 && (unicode.IsLetter(rune(c)) || unicode.IsDigit(rune(c)))
+}
+
+func (p *filterParser) parseOr() (filterNode, error) {
+	l, err := p.parseAnd()
+	for err == nil && p.tok == "or" {
+		p.next()
+		var r filterNode
+		if r, err = p.parseAnd(); err == nil {
+			l = filterOr{l, r}
+		}
+	}
+	return l, err
+}
+
+func (p *filterParser) parseAnd() (filterNode, error) {
+	l, err := p.parseNot()
+	for err == nil && p.tok == "and" {
+		p.next()
+		var r filterNode
+		if r, err = p.parseNot(); err == nil {
+			l = filterAnd{l, r}
+		}
+	}
+	return l, err
+}
+
+func (p *filterParser) parseNot() (filterNode, error) {
+	if p.tok == "not" {
+		p.next()
+		n, err := p.parseNot()
+		return filterNot{n}, err
+	}
+	if p.tok == "(" {
+		p.next()
+		n, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.tok != ")" {
+			return nil, p.errorf("expected )")
+		}
+		p.next()
+		return n, nil
+	}
+	return p.parseCompare()
+}
+
+func (p *filterParser) parseCompare() (filterNode, error) {
+	if p.err != nil {
+		return nil, p.err
+	}
+	if p.tok == "" || p.str != nil || !isFilterWord(p.tok[0]) {
+		return nil, p.errorf("expected a column name, got %q", p.tok)
+	}
+	c := &filterCompare{column: p.tok}
+	p.next()
+	for _, op := range filterOperators {
+		if p.tok == op {
+			c.op = op
+		}
+	}
+	if c.op == "" {
+		return nil, p.errorf("expected an operator after %s, got %q", c.column, p.tok)
+	}
+	c.negated = strings.HasPrefix(c.op, "!")
+	p.next()
+	if c.op == "in" || c.op == "!in" {
+		if p.tok != "(" {
+			return nil, p.errorf("expected ( after %s", c.op)
+		}
+		for p.next(); ; p.next() {
+			v, err := p.parseValue()
+			if err != nil {
+				return nil, err
+			}
+			c.values = append(c.values, v)
+			if p.tok == ")" {
+				break
+			}
+			if p.tok != "," {
+				return nil, p.errorf("expected , or ) in the list of %s", c.op)
+			}
+		}
+		p.next()
+		return c, nil
+	}
+	v, err := p.parseValue()
+	if err != nil {
+		return nil, err
+	}
+	c.values = []filterValue{v}
+	if c.op == "matches regex" {
+		if c.re, err = regexp.Compile(v.text); err != nil {
+			return nil, fmt.Errorf("invalid regular expression %q: %w", v.text, err)
+		}
+	}
+	return c, nil
+}
+
+// parseValue parses a literal, leaving p at the token after it.
+func (p *filterParser) parseValue() (filterValue, error) {
+	if p.err != nil {
+		return filterValue{}, p.err
+	}
+	defer p.next()
+	switch {
+	case p.str != nil:
+		return filterValue{text: *p.str}, nil
+	case p.tok == "null":
+		return filterValue{null: true}, nil
+	case p.tok == "true" || p.tok == "false":
+		return filterValue{text: p.tok}, nil
+	case p.tok == "datetime":
+		p.next()
+		if p.tok != "(" {
+			return filterValue{}, p.errorf("expected ( after datetime")
+		}
+		start := p.pos
+		end := strings.IndexByte(p.src[start:], ')')
+		if end < 0 {
+			return filterValue{}, p.errorf("expected ) after datetime(")
+		}
+		arg := strings.Trim(strings.TrimSpace(p.src[start:start+end]), `"'`)
+		t, err := parseServeTime(arg)
+		if err != nil {
+			return filterValue{}, p.errorf("invalid datetime: %v", err)
+		}
+		p.pos = start + end
+		p.next()
+		return filterValue{text: t.Format(time.RFC3339Nano), time: t}, nil
+	}
+	if n, err := strconv.ParseFloat(p.tok, 64); err == nil {
+		return filterValue{text: p.tok, num: n, isNum: true}, nil
+	}
+	return filterValue{}, p.errorf("expected a value, got %q", p.tok)
+}
@@ -0,0 +1,115 @@
+package mustgather
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRowFilter(t *testing.T) {
+	row := `{"TimeGenerated":"2024-01-01T10:05:00.123Z","PodNamespace":"shop","LogLevel":"ERROR","LogMessage":"dial tcp: connection refused","Count":12,"Ready":false,"Labels":{"app":"cart"}}`
+	cases := []struct {
+		expr string
+		want bool
+	}{
+		{`PodNamespace == "shop"`, true},
+		{`PodNamespace == 'Shop'`, false},
+		{`PodNamespace =~ 'Shop' and LogLevel =~ "error"`, true},
+		{`PodNamespace != "shop" or Count > 10`, true},
+		{`Count >= 12 and Count < 12.5 and Count == 12`, true},
+		{`Count > 9`, true},
+		{`not (Count > 9)`, false},
+		{`LogMessage contains "REFUSED"`, true},
+		{`LogMessage !contains "refused"`, false},
+		{`LogMessage startswith "dial" and LogMessage endswith "refused"`, true},
+		{`LogMessage matches regex "timeout|conn.*refused"`, true},
+		{`PodNamespace in ("kube-system", "shop")`, true},
+		{`PodNamespace !in ("kube-system", "shop")`, false},
+		{`TimeGenerated > datetime(2024-01-01T10:00)`, true},
+		{`TimeGenerated < "2024-01-01T10:05:00Z"`, false},
+		{`TimeGenerated == datetime("2024-01-01T10:05:00.123Z")`, true},
+		{`Ready == false`, true},
+		{`Labels contains '"app":"cart"'`, true},
+		{`Missing == null and PodNamespace != null`, true},
+		{`Missing == "x" or Missing contains "x"`, false},
+		{`Missing != "x" and Missing !contains "x"`, true},
+		{`a == 1 or PodNamespace == "shop" and Count < 0`, false},
+	}
+	for _, c := range cases {
+		f, err := ParseRowFilter(c.expr)
+		if err != nil {
+			t.Errorf("ParseRowFilter(%s): %v", c.expr, err)
+			continue
+		}
+		if got := f.MatchLine([]byte(row)); got != c.want {
+			t.Errorf("%s: got %v, want %v", c.expr, got, c.want)
+		}
+	}
+
+	for _, expr := range []string{
+		``,
+		`PodNamespace`,
+		`PodNamespace == `,
+		`PodNamespace = "shop"`,
+		`PodNamespace == "shop`,
+		`(PodNamespace == "shop"`,
+		`PodNamespace == "shop" and`,
+		`PodNamespace in "shop"`,
+		`LogMessage matches regex "("`,
+		`TimeGenerated > datetime(yesterday)`,
+	} {
+		if _, err := ParseRowFilter(expr); err == nil {
+			t.Errorf("ParseRowFilter(%s): expected an error", expr)
+		}
+	}
+}
+
+func TestGrepAndExtractWhere(t *testing.T) {
+	src := writeBundleDir(t, map[string]string{
+		"index.json": `{"tables":["ContainerLogV2","KubeEvents"]}`,
+		"tables/ContainerLogV2/parts/0000-a.ndjson": strings.Join([]string{
+			`{"PodNamespace":"payments","LogLevel":"error","LogMessage":"boom"}`,
+			`{"PodNamespace":"payments","LogLevel":"info","LogMessage":"ok"}`,
+			`{"PodNamespace":"shop","LogLevel":"error","LogMessage":"boom"}`,
+		}, "\n") + "\n",
+		"tables/ContainerLogV2/parts/0001-b.ndjson": `{"PodNamespace":"shop","LogLevel":"info"}` + "\n",
+		"tables/KubeEvents/parts/0000-a.ndjson":     `{"Namespace":"payments","Reason":"BackOff"}` + "\n",
+	})
+
+	var out strings.Builder
+	stats, err := Grep(src, GrepOptions{Where: `PodNamespace == "payments" or Reason == "BackOff"`}, &out)
+	if err != nil {
+		t.Fatalf("Grep: %v", err)
+	}
+	want := `{"PodNamespace":"payments","LogLevel":"error","LogMessage":"boom"}
+{"PodNamespace":"payments","LogLevel":"info","LogMessage":"ok"}
+{"Namespace":"payments","Reason":"BackOff"}
+`
+	if out.String() != want || *stats != (GrepStats{Tables: 2, Parts: 3, Rows: 5, Matched: 3}) {
+		t.Errorf("unexpected rows %+v:\n%s", stats, out.String())
+	}
+	out.Reset()
+	if stats, err = Grep(src, GrepOptions{Where: `LogLevel == "error"`, Tables: []string{"Container*"}, MaxCount: 1}, &out); err != nil {
+		t.Fatalf("Grep: %v", err)
+	}
+	if stats.Matched != 1 || strings.Count(out.String(), "\n") != 1 {
+		t.Errorf("expected a single row, got %+v:\n%s", stats, out.String())
+	}
+	if _, err := Grep(src, GrepOptions{Where: `LogLevel ==`}, &out); err == nil {
+		t.Error("expected an error for an invalid expression")
+	}
+
+	dir := filepath.Join(t.TempDir(), "out")
+	es, err := Extract(src, ExtractOptions{Paths: []string{"tables"}, Where: `LogLevel == "error"`, OutDir: dir})
+	if err != nil {
+		t.Fatalf("Extract: %v", err)
+	}
+	if es.Files != 1 || es.Trimmed != 1 || es.Skipped != 2 {
+		t.Errorf("unexpected stats: %+v", es)
+	}
+	got, _ := os.ReadFile(filepath.Join(dir, "tables/ContainerLogV2/parts/0000-a.ndjson"))
+	if strings.Count(string(got), "\n") != 2 || strings.Contains(string(got), "info") {
+		t.Errorf("unexpected part:\n%s", got)
+	}
+}
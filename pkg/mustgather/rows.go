@@ -0,0 +1,101 @@
+package mustgather
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"kubectl-must-gather/pkg/utils"
+)
+
+// transform derives additional archive files from exported table rows.
+// observe is called for every exported row, endChunk after each query chunk
+// of a table has been exported, and finish once after all tables. warnings
+// returns problems met while deriving files from a table; they are recorded in
+// the table's summary.json.
+type transform interface {
+	observe(table string, row map[string]any)
+	endChunk(tarw *utils.TarWriter) error
+	finish(tarw *utils.TarWriter) error
+	warnings(table string) []string
+}
+
+// newTransforms returns the transforms applied to every gather, in the order
+// their files are written.
+// memory may be nil, and so may warn, which reports the problems met as they
+// are recorded instead of the log output of config.
+func newTransforms(config *Config, memory *memoryGovernor, warn func(table, msg string)) []transform {
+	// Terminations observe rows before the stitcher to anchor their lines
+	st := newStitcher(config, memory)
+	st.report = warn
+	pods := newPodManifests()
+	transforms := []transform{newTerminations(st), st, newAuditWriter(), pods, newResourceSnapshots(pods), newNodeInventory(config.location()), newFindings(config, builtinRules), newEventAnomalies(), newNodeConditions(), newUtilizationReport(), newErrorHeatmap()}
+	if config.EventObjects != "" {
+		transforms = append(transforms, newEventObjects(config.EventObjects))
+	}
+	if config.Report == ReportHTML {
+		transforms = append(transforms, newHTMLReport(config))
+	}
+	return transforms
+}
+
+// timeBefore orders TimeGenerated values, falling back to string order when
+// either cannot be parsed.
+func timeBefore(a, b string) bool {
+	ta, tb := utils.ParseTimeRFC3339(a), utils.ParseTimeRFC3339(b)
+	if ta.IsZero() || tb.IsZero() {
+		return a < b
+	}
+	return ta.Before(tb)
+}
+
+func hasColumns(row map[string]any, names ...string) bool {
+	for _, n := range names {
+		if _, ok := row[n]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+func toStr(v any) string {
+	if v == nil {
+		return ""
+	}
+	switch t := v.(type) {
+	case string:
+		return t
+	case json.RawMessage:
+		return string(t)
+	case map[string]any, []any:
+		// Dynamic values read back from NDJSON
+		b, _ := json.Marshal(t)
+		return string(b)
+	default:
+		return fmt.Sprint(t)
+	}
+}
+
+// dynamicValue decodes a Log Analytics dynamic column, which the query API
+// returns as a JSON-encoded string and exports keep as raw JSON. Values that
+// are not JSON objects or arrays are returned unchanged.
+func dynamicValue(v any) any {
+	var s string
+	switch t := v.(type) {
+	case string:
+		s = t
+	case json.RawMessage:
+		s = string(t)
+	default:
+		return v
+	}
+	trimmed := strings.TrimSpace(s)
+	if trimmed == "" || (trimmed[0] != '{' && trimmed[0] != '[') {
+		return v
+	}
+	var out any
+	if err := json.Unmarshal([]byte(trimmed), &out); err != nil {
+		return v
+	}
+	return out
+}
@@ -0,0 +1,196 @@
+package mustgather
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"sort"
+	"strings"
+)
+
+// namespaceColumns is the expression holding the Kubernetes namespace of a
+// row, per table. Only these tables can be exported in a scoped gather.
+var namespaceColumns = map[string]string{
+	"ContainerLogV2":   "PodNamespace",
+	"KubeEvents":       "Namespace",
+	"KubePodInventory": "Namespace",
+	"KubeServices":     "Namespace",
+	"KubePVInventory":  "PVCNamespace",
+	"AKSAudit":         "tostring(ObjectRef.namespace)",
+	"AKSAuditAdmin":    "tostring(ObjectRef.namespace)",
+}
+
+// scopeNamespacesQuery lists the namespaces that had pods in the gather
+// window, the candidates of the RBAC check.
+const scopeNamespacesQuery = `KubePodInventory
+| where isnotempty(Namespace)
+| distinct Namespace`
+
+// namespaceScope limits a gather to the rows of some namespaces.
+type namespaceScope struct {
+	namespaces []string
+	// source says how the namespaces were chosen: "allowlist", "rbac", or
+	// both joined by "+".
+	source string
+}
+
+// namespaceColumn returns the namespace expression of table, matching the
+// table name case-insensitively as Log Analytics does.
+func namespaceColumn(table string) (string, bool) {
+	for t, col := range namespaceColumns {
+		if strings.EqualFold(t, table) {
+			return col, true
+		}
+	}
+	return "", false
+}
+
+// filter is the KQL predicate keeping the rows of the scope's namespaces
+// in column.
+func (s *namespaceScope) filter(column string) string {
+	quoted := make([]string, len(s.namespaces))
+	for i, ns := range s.namespaces {
+		quoted[i] = kqlString(ns)
+	}
+	return fmt.Sprintf("%s in (%s)", column, strings.Join(quoted, ", "))
+}
+
+// tableQuery is the query exporting table: the query of its Exporter, or
+// its rows in the scope's namespaces when s is set.
+func (s *namespaceScope) tableQuery(table string) string {
+	query := exporterFor(table).Query(table)
+	if s == nil {
+		return query
+	}
+	col, _ := namespaceColumn(table)
+	return query + " | where " + s.filter(col)
+}
+
+// split separates the tables that can be scoped from those without a
+// namespace column, which a scoped gather skips.
+func (s *namespaceScope) split(tables []string) (scoped, skipped []string) {
+	for _, t := range tables {
+		if _, ok := namespaceColumn(t); ok {
+			scoped = append(scoped, t)
+		} else {
+			skipped = append(skipped, t)
+		}
+	}
+	return scoped, skipped
+}
+
+// summary is the scope section of metadata/run.json, with the namespaces
+// pseudonymized when anon is set.
+func (s *namespaceScope) summary(anon *Anonymizer) map[string]any {
+	namespaces := s.namespaces
+	if anon != nil {
+		namespaces = make([]string, len(s.namespaces))
+		for i, ns := range s.namespaces {
+			namespaces[i] = anon.pseudonym(anonNamespace, ns)
+		}
+	}
+	return map[string]any{"namespaces": namespaces, "source": s.source}
+}
+
+// kqlString quotes s as a KQL string literal.
+func kqlString(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
+
+// resolveScope returns the namespaces the gather is limited to, or nil for an
+// unscoped gather. With ScopeToRBAC the namespaces with pods in the window
+// are checked with kubectl auth can-i and only the readable ones kept, within
+// the Namespaces allowlist when both are set.
+func (g *Gatherer) resolveScope(lcli LogsQueryClient, workspaceGUID string) (*namespaceScope, error) {
+	allow := cleanNamespaces(g.config.Namespaces)
+	if !g.config.ScopeToRBAC {
+		if len(allow) == 0 {
+			return nil, nil
+		}
+		return &namespaceScope{namespaces: allow, source: "allowlist"}, nil
+	}
+
+	candidates := allow
+	if len(candidates) == 0 {
+		res, err := g.query(g.ctx, lcli, workspaceGUID, scopeNamespacesQuery, g.start, g.end)
+		if err == nil && res.Error != nil {
+			err = res.Error
+		}
+		if err != nil {
+			return nil, fmt.Errorf("list namespaces for the RBAC check: %w", err)
+		}
+		if len(res.Tables) > 0 {
+			for _, row := range tableRows(res.Tables[0]) {
+				if ns, ok := row["Namespace"].(string); ok {
+					candidates = append(candidates, ns)
+				}
+			}
+		}
+		candidates = cleanNamespaces(candidates)
+	}
+	readable, err := readableNamespaces(g.ctx, g.config.Kubeconfig, g.config.KubeContext, candidates)
+	if err != nil {
+		return nil, err
+	}
+	if len(readable) == 0 {
+		return nil, fmt.Errorf("--scope-to-rbac: the caller cannot list pods in any of the %d namespaces checked", len(candidates))
+	}
+	s := &namespaceScope{namespaces: readable, source: "rbac"}
+	if len(allow) > 0 {
+		s.source = "allowlist+rbac"
+	}
+	return s, nil
+}
+
+// cleanNamespaces trims, dedupes and sorts namespaces, dropping empty ones.
+func cleanNamespaces(namespaces []string) []string {
+	seen := map[string]bool{}
+	var out []string
+	for _, ns := range namespaces {
+		ns = strings.TrimSpace(ns)
+		if ns != "" && !seen[ns] {
+			seen[ns] = true
+			out = append(out, ns)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+// readableNamespaces returns the namespaces in which the kubeconfig context
+// may list pods, asking the API server with kubectl auth can-i, which exits 0
+// for yes and 1 for no.
+func readableNamespaces(ctx context.Context, kubeconfig, kubeContext string, namespaces []string) ([]string, error) {
+	if _, err := exec.LookPath("kubectl"); err != nil {
+		return nil, fmt.Errorf("--scope-to-rbac needs kubectl on PATH: %w", err)
+	}
+	var readable []string
+	for _, ns := range namespaces {
+		args := []string{"auth", "can-i", "list", "pods", "--namespace", ns, "--quiet"}
+		if kubeconfig != "" {
+			args = append(args, "--kubeconfig", kubeconfig)
+		}
+		if kubeContext != "" {
+			args = append(args, "--context", kubeContext)
+		}
+		var stderr bytes.Buffer
+		cmd := exec.CommandContext(ctx, "kubectl", args...)
+		cmd.Stderr = &stderr
+		err := cmd.Run()
+		var exitErr *exec.ExitError
+		switch {
+		case err == nil:
+			readable = append(readable, ns)
+		case errors.As(err, &exitErr) && exitErr.ExitCode() == 1 && strings.TrimSpace(stderr.String()) == "":
+			// Denied
+		default:
+			if msg := strings.TrimSpace(stderr.String()); msg != "" {
+				return nil, fmt.Errorf("kubectl auth can-i in %s: %w: %s", ns, err, firstLine(msg))
+			}
+			return nil, fmt.Errorf("kubectl auth can-i in %s: %w", ns, err)
+		}
+	}
+	return readable, nil
+}
@@ -0,0 +1,122 @@
+package mustgather
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// fakeKubectl puts a kubectl stand-in on PATH whose auth can-i allows the
+// namespaces in allowed, denies others, and fails for namespace "broken" as
+// kubectl does when the API server cannot be reached.
+func fakeKubectl(t *testing.T, allowed ...string) {
+	t.Helper()
+	dir := t.TempDir()
+	script := `#!/bin/sh
+ns=""
+while [ $# -gt 0 ]; do
+  case "$1" in
+    --namespace) ns="$2"; shift 2 ;;
+    *) shift ;;
+  esac
+done
+[ "$ns" = broken ] && { echo "error: You must be logged in to the server (Unauthorized)" >&2; exit 1; }
+for a in ` + strings.Join(allowed, " ") + `; do
+  [ "$a" = "$ns" ] && exit 0
+done
+exit 1
+`
+	if err := os.WriteFile(filepath.Join(dir, "kubectl"), []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+}
+
+func TestNamespaceScopeTableQuery(t *testing.T) {
+	var unscoped *namespaceScope
+	if got := unscoped.tableQuery("ContainerLogV2"); got != "ContainerLogV2" {
+		t.Errorf("unscoped query = %q", got)
+	}
+
+	s := &namespaceScope{namespaces: []string{"shop", `we"ird`}}
+	tests := []struct {
+		table string
+		want  string
+	}{
+		{"ContainerLogV2", `ContainerLogV2 | where PodNamespace in ("shop", "we\"ird")`},
+		{"kubeevents", `kubeevents | where Namespace in ("shop", "we\"ird")`},
+		{"AKSAudit", `AKSAudit | where tostring(ObjectRef.namespace) in ("shop", "we\"ird")`},
+	}
+	for _, tt := range tests {
+		if got := s.tableQuery(tt.table); got != tt.want {
+			t.Errorf("tableQuery(%s) = %q, want %q", tt.table, got, tt.want)
+		}
+	}
+
+	scoped, skipped := s.split([]string{"ContainerLogV2", "Heartbeat", "KubePodInventory", "ContainerLog"})
+	if !reflect.DeepEqual(scoped, []string{"ContainerLogV2", "KubePodInventory"}) || !reflect.DeepEqual(skipped, []string{"Heartbeat", "ContainerLog"}) {
+		t.Errorf("split = %v, %v", scoped, skipped)
+	}
+}
+
+func TestCleanNamespaces(t *testing.T) {
+	got := cleanNamespaces([]string{" shop", "payments", "", "shop"})
+	if want := []string{"payments", "shop"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("cleanNamespaces = %v, want %v", got, want)
+	}
+}
+
+func TestReadableNamespaces(t *testing.T) {
+	fakeKubectl(t, "shop", "payments")
+
+	got, err := readableNamespaces(context.Background(), "", "dev", []string{"kube-system", "payments", "shop"})
+	if err != nil {
+		t.Fatalf("readableNamespaces failed: %v", err)
+	}
+	if want := []string{"payments", "shop"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("readableNamespaces = %v, want %v", got, want)
+	}
+
+	if _, err := readableNamespaces(context.Background(), "", "", []string{"shop", "broken"}); err == nil || !strings.Contains(err.Error(), "Unauthorized") {
+		t.Errorf("expected kubectl's error, got %v", err)
+	}
+
+	t.Setenv("PATH", t.TempDir())
+	if _, err := readableNamespaces(context.Background(), "", "", []string{"shop"}); err == nil || !strings.Contains(err.Error(), "needs kubectl on PATH") {
+		t.Errorf("expected a missing kubectl to fail, got %v", err)
+	}
+}
+
+func TestResolveScopeAllowlist(t *testing.T) {
+	g := &Gatherer{config: &Config{Namespaces: []string{"shop", " payments "}}}
+	s, err := g.resolveScope(nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.source != "allowlist" || !reflect.DeepEqual(s.namespaces, []string{"payments", "shop"}) {
+		t.Errorf("resolveScope = %+v", s)
+	}
+
+	g.config.Namespaces = nil
+	if s, err := g.resolveScope(nil, ""); s != nil || err != nil {
+		t.Errorf("expected no scope without namespaces, got %+v, %v", s, err)
+	}
+
+	fakeKubectl(t, "shop")
+	g = &Gatherer{ctx: context.Background(), config: &Config{Namespaces: []string{"shop", "payments"}, ScopeToRBAC: true}}
+	s, err = g.resolveScope(nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.source != "allowlist+rbac" || !reflect.DeepEqual(s.namespaces, []string{"shop"}) {
+		t.Errorf("resolveScope = %+v", s)
+	}
+
+	g.config.Namespaces = []string{"payments"}
+	if _, err := g.resolveScope(nil, ""); err == nil || !strings.Contains(err.Error(), "cannot list pods") {
+		t.Errorf("expected no readable namespace to fail, got %v", err)
+	}
+}
@@ -0,0 +1,76 @@
+package mustgather
+
+import (
+	"strings"
+
+	azquery "github.com/Azure/azure-sdk-for-go/sdk/monitor/azquery"
+)
+
+// scrubbedTables hold Kubernetes audit events and control plane logs, which
+// routinely carry request headers, service account tokens and certificates.
+// Their rows are always scrubbed before they are written.
+var scrubbedTables = map[string]bool{
+	"aksaudit":        true,
+	"aksauditadmin":   true,
+	"akscontrolplane": true,
+}
+
+// scrubbedValue replaces every credential the audit scrubber removes.
+const scrubbedValue = "<scrubbed>"
+
+// auditScrubber removes credentials from audit rows: the values of header,
+// token and certificate keys, and bearer tokens, JWTs and PEM blocks found in
+// any string, e.g. in a TokenReview request or a logged header.
+var auditScrubber = func() *Redactor {
+	r := &Redactor{Rules: []RedactionRule{
+		{Name: "authorization-header", Replacement: scrubbedValue, Keys: []string{
+			"Authorization", "authorization", "Proxy-Authorization", "proxy-authorization",
+			"Cookie", "cookie", "Set-Cookie", "set-cookie", "X-Api-Key", "x-api-key",
+		}},
+		{Name: "authorization-text", Replacement: "Authorization: " + scrubbedValue,
+			Pattern: `(?i)\b(?:proxy-)?authorization["']?\s*[:=]\s*["']?(?:basic|bearer|digest|negotiate)\s+[^\s"',\]}]+`},
+		{Name: "token", Replacement: scrubbedValue, Keys: []string{
+			"token", "access_token", "accessToken", "refresh_token", "refreshToken",
+			"id_token", "idToken", "bearerToken", "password",
+		}},
+		{Name: "bearer-token", Replacement: "Bearer " + scrubbedValue, Pattern: `(?i)\bbearer\s+[A-Za-z0-9\-._~+/]{16,}=*`},
+		{Name: "jwt", Replacement: scrubbedValue, Pattern: `\beyJ[A-Za-z0-9_-]{8,}\.eyJ[A-Za-z0-9_-]{8,}\.[A-Za-z0-9_-]*`},
+		{Name: "certificate", Replacement: scrubbedValue, Keys: []string{
+			"client-certificate-data", "client-key-data", "tls.crt", "tls.key", "certificate", "privateKey",
+		}},
+		// PEM blocks, in JSON with escaped newlines too, and base64 encoded
+		// as in secrets and kubeconfigs
+		{Name: "pem", Replacement: scrubbedValue, Pattern: `-----BEGIN [A-Z0-9 ]+-----[\s\S]*?-----END [A-Z0-9 ]+-----|LS0tLS1CRUdJTi[A-Za-z0-9+/]+={0,2}`},
+	}}
+	if err := r.compile(); err != nil {
+		panic(err)
+	}
+	return r
+}()
+
+// scrubRow removes credentials from a row of table when it is an audit or
+// control plane table; other rows are unchanged.
+func scrubRow(table string, row map[string]any) {
+	if scrubbedTables[strings.ToLower(table)] {
+		auditScrubber.redactRow(table, row, nil)
+	}
+}
+
+// scrubTable removes credentials from every row of a free-form query result,
+// which may read audit tables under any name.
+func scrubTable(tab *azquery.Table) {
+	for _, row := range tab.Rows {
+		obj := make(map[string]any, len(row))
+		for i, c := range tab.Columns {
+			if i < len(row) && c.Name != nil {
+				obj[*c.Name] = row[i]
+			}
+		}
+		auditScrubber.redactRow("", obj, nil)
+		for i, c := range tab.Columns {
+			if i < len(row) && c.Name != nil {
+				row[i] = obj[*c.Name]
+			}
+		}
+	}
+}
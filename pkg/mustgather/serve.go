@@ -0,0 +1,571 @@
+package mustgather
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"kubectl-must-gather/pkg/bundle"
+	"kubectl-must-gather/pkg/utils"
+)
+
+// serveLimit caps the log lines, events and table rows shown on one page.
+const serveLimit = 5000
+
+// serveEventBucket is the width of the bars of the event timeline.
+const serveEventBucket = 5 * time.Minute
+
+// ArchiveServer serves a local web UI to browse a bundle: the namespace and
+// pod tree, stitched logs filtered by time, the KubeEvents timeline and the
+// rows of every exported table.
+type ArchiveServer struct {
+	b      *bundle.Bundle
+	name   string
+	files  map[string]bool
+	tree   []*serveNamespace
+	nodes  []serveNode
+	others []string
+	tables []bundle.Table
+}
+
+type serveNamespace struct {
+	Name   string
+	Events string
+	Pods   []*servePod
+}
+
+type servePod struct {
+	Name  string
+	Files []string
+}
+
+type serveNode struct {
+	Name  string
+	Files []string
+}
+
+// NewArchiveServer opens the bundle at src, a .tar.gz archive or an
+// extracted directory. Close removes what was extracted.
+func NewArchiveServer(src string) (*ArchiveServer, error) {
+	b, err := bundle.Open(src)
+	if err != nil {
+		return nil, fmt.Errorf("open bundle: %w", err)
+	}
+	s := &ArchiveServer{b: b, name: filepath.Base(src), files: map[string]bool{}}
+	files, err := b.Files()
+	if err != nil {
+		b.Close()
+		return nil, fmt.Errorf("list bundle files: %w", err)
+	}
+	if s.tables, err = b.Tables(); err != nil {
+		b.Close()
+		return nil, fmt.Errorf("list tables: %w", err)
+	}
+	s.index(files)
+	return s, nil
+}
+
+// Close releases the bundle.
+func (s *ArchiveServer) Close() error {
+	return s.b.Close()
+}
+
+// index sorts the bundle's files into the namespace/pod tree, the node logs
+// and the remaining top-level files.
+func (s *ArchiveServer) index(files []string) {
+	namespaces := map[string]*serveNamespace{}
+	pods := map[string]*servePod{}
+	nodes := map[string]int{}
+	for _, f := range files {
+		s.files[f] = true
+		parts := strings.Split(f, "/")
+		switch {
+		case parts[0] == "namespaces" && len(parts) >= 4:
+			ns, ok := namespaces[parts[1]]
+			if !ok {
+				ns = &serveNamespace{Name: parts[1]}
+				namespaces[parts[1]] = ns
+				s.tree = append(s.tree, ns)
+			}
+			if parts[2] == "events" && parts[len(parts)-1] == "events.log" {
+				ns.Events = f
+			} else if parts[2] == "pods" && len(parts) == 5 {
+				key := parts[1] + "/" + parts[3]
+				p, ok := pods[key]
+				if !ok {
+					p = &servePod{Name: parts[3]}
+					pods[key] = p
+					ns.Pods = append(ns.Pods, p)
+				}
+				p.Files = append(p.Files, f)
+			}
+		case parts[0] == "nodes" && len(parts) == 3:
+			i, ok := nodes[parts[1]]
+			if !ok {
+				i = len(s.nodes)
+				nodes[parts[1]] = i
+				s.nodes = append(s.nodes, serveNode{Name: parts[1]})
+			}
+			s.nodes[i].Files = append(s.nodes[i].Files, f)
+		case parts[0] == "tables" || parts[0] == "namespaces":
+		default:
+			s.others = append(s.others, f)
+		}
+	}
+}
+
+// Handler returns the UI's HTTP handler.
+func (s *ArchiveServer) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", s.handleIndex)
+	mux.HandleFunc("/log", s.handleLog)
+	mux.HandleFunc("/events", s.handleEvents)
+	mux.HandleFunc("/table", s.handleTable)
+	mux.HandleFunc("/raw/", s.handleRaw)
+	return mux
+}
+
+func (s *ArchiveServer) render(w http.ResponseWriter, name string, data map[string]any) {
+	data["Archive"] = s.name
+	var buf bytes.Buffer
+	if err := serveTemplates.ExecuteTemplate(&buf, name, data); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	_, _ = w.Write(buf.Bytes())
+}
+
+func (s *ArchiveServer) handleIndex(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	s.render(w, "index", map[string]any{
+		"Namespaces": s.tree,
+		"Nodes":      s.nodes,
+		"Files":      s.others,
+		"Tables":     s.tables,
+		"Incomplete": s.b.Incomplete(),
+	})
+}
+
+// file returns the bundle path named by the request, if the bundle has it.
+func (s *ArchiveServer) file(name string) (string, bool) {
+	name = strings.TrimPrefix(path.Clean("/"+name), "/")
+	return name, s.files[name]
+}
+
+func (s *ArchiveServer) handleRaw(w http.ResponseWriter, r *http.Request) {
+	name, ok := s.file(strings.TrimPrefix(r.URL.Path, "/raw/"))
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if strings.HasSuffix(name, ".html") {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	}
+	http.ServeFile(w, r, filepath.Join(s.b.Dir, filepath.FromSlash(name)))
+}
+
+// serveWindow is the time filter of a page, from its since and until
+// parameters. Either may be empty.
+type serveWindow struct {
+	Since, Until string
+	since, until time.Time
+}
+
+func parseServeWindow(r *http.Request) (serveWindow, error) {
+	w := serveWindow{Since: r.FormValue("since"), Until: r.FormValue("until")}
+	var err error
+	if w.since, err = parseServeTime(w.Since); err != nil {
+		return w, fmt.Errorf("invalid since: %w", err)
+	}
+	if w.until, err = parseServeTime(w.Until); err != nil {
+		return w, fmt.Errorf("invalid until: %w", err)
+	}
+	return w, nil
+}
+
+// parseServeTime accepts RFC 3339 or the UTC minutes of a datetime-local
+// input.
+func parseServeTime(v string) (time.Time, error) {
+	if v == "" {
+		return time.Time{}, nil
+	}
+	for _, layout := range []string{time.RFC3339Nano, "2006-01-02T15:04:05", "2006-01-02T15:04"} {
+		if t, err := time.Parse(layout, v); err == nil {
+			return t.UTC(), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("%q is not a time like 2024-01-01T15:04", v)
+}
+
+// contains reports whether t falls in the window. Unknown times match.
+func (w serveWindow) contains(t time.Time) bool {
+	if t.IsZero() {
+		return true
+	}
+	return (w.since.IsZero() || !t.Before(w.since)) && (w.until.IsZero() || t.Before(w.until))
+}
+
+func (s *ArchiveServer) handleLog(w http.ResponseWriter, r *http.Request) {
+	name, ok := s.file(r.FormValue("path"))
+	if !ok || !strings.HasSuffix(name, ".log") {
+		http.NotFound(w, r)
+		return
+	}
+	win, err := parseServeWindow(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	grep := r.FormValue("grep")
+	f, err := os.Open(filepath.Join(s.b.Dir, filepath.FromSlash(name)))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer f.Close()
+	var lines []string
+	matched := 0
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for sc.Scan() {
+		line := sc.Text()
+		raw, _, _ := strings.Cut(line, " ")
+		if !win.contains(utils.ParseTimeRFC3339(raw)) || (grep != "" && !strings.Contains(strings.ToLower(line), strings.ToLower(grep))) {
+			continue
+		}
+		if matched++; matched <= serveLimit {
+			lines = append(lines, line)
+		}
+	}
+	if err := sc.Err(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	s.render(w, "log", map[string]any{
+		"Path":    name,
+		"Window":  win,
+		"Grep":    grep,
+		"Lines":   lines,
+		"Matched": matched,
+		"Limit":   serveLimit,
+	})
+}
+
+// serveEvent is a KubeEvents row on the timeline.
+type serveEvent struct {
+	Time                          time.Time
+	Type, Reason, Object, Message string
+	Namespace                     string
+	Warning                       bool
+}
+
+// serveBar is a bucket of the event timeline, with its height in percent of
+// the busiest bucket.
+type serveBar struct {
+	Start            string
+	Events, Warnings int
+	Height, Warn     int
+}
+
+func (s *ArchiveServer) handleEvents(w http.ResponseWriter, r *http.Request) {
+	win, err := parseServeWindow(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	ns, warningsOnly := r.FormValue("ns"), r.FormValue("warnings") != ""
+	var events []serveEvent
+	namespaces := map[string]bool{}
+	for _, t := range s.tables {
+		if t.Name != "KubeEvents" {
+			continue
+		}
+		for _, part := range t.Parts {
+			err := s.b.ReadPart(part, func(row map[string]any) error {
+				e := serveEvent{
+					Time:      utils.ParseTimeRFC3339(toStr(row["TimeGenerated"])),
+					Type:      toStr(row["KubeEventType"]),
+					Reason:    toStr(row["Reason"]),
+					Object:    strings.TrimPrefix(toStr(row["ObjectKind"])+"/"+toStr(row["Name"]), "/"),
+					Message:   stitchMessage(row["Message"]),
+					Namespace: toStr(row["Namespace"]),
+				}
+				e.Warning = strings.EqualFold(e.Type, "Warning")
+				namespaces[e.Namespace] = true
+				if (ns == "" || e.Namespace == ns) && (!warningsOnly || e.Warning) && win.contains(e.Time) {
+					events = append(events, e)
+				}
+				return nil
+			})
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
+	}
+	sort.SliceStable(events, func(i, j int) bool { return events[i].Time.Before(events[j].Time) })
+
+	var bars []serveBar
+	if len(events) > 0 && !events[0].Time.IsZero() {
+		first := events[0].Time.Truncate(serveEventBucket)
+		peak := 0
+		for _, e := range events {
+			i := int(e.Time.Sub(first) / serveEventBucket)
+			for len(bars) <= i {
+				bars = append(bars, serveBar{Start: first.Add(time.Duration(len(bars)) * serveEventBucket).Format("2006-01-02T15:04")})
+			}
+			bars[i].Events++
+			if e.Warning {
+				bars[i].Warnings++
+			}
+			if bars[i].Events > peak {
+				peak = bars[i].Events
+			}
+		}
+		for i := range bars {
+			bars[i].Height = 100 * bars[i].Events / peak
+			bars[i].Warn = 100 * bars[i].Warnings / peak
+		}
+	}
+	var nsList []string
+	for n := range namespaces {
+		nsList = append(nsList, n)
+	}
+	sort.Strings(nsList)
+	shown := events
+	if len(shown) > serveLimit {
+		shown = shown[len(shown)-serveLimit:]
+	}
+	s.render(w, "events", map[string]any{
+		"Namespace":  ns,
+		"Namespaces": nsList,
+		"Warnings":   warningsOnly,
+		"Window":     win,
+		"Events":     shown,
+		"Matched":    len(events),
+		"Limit":      serveLimit,
+		"Bars":       bars,
+	})
+}
+
+func (s *ArchiveServer) handleTable(w http.ResponseWriter, r *http.Request) {
+	var table *bundle.Table
+	for i := range s.tables {
+		if s.tables[i].Name == r.FormValue("name") {
+			table = &s.tables[i]
+		}
+	}
+	if table == nil {
+		http.NotFound(w, r)
+		return
+	}
+	atoi := func(key string, def int) int {
+		if n, err := strconv.Atoi(r.FormValue(key)); err == nil && n >= 0 {
+			return n
+		}
+		return def
+	}
+	partIdx, offset, limit := atoi("part", 0), atoi("offset", 0), atoi("limit", 100)
+	if limit == 0 || limit > serveLimit {
+		limit = serveLimit
+	}
+	q := strings.ToLower(r.FormValue("q"))
+	var rows []map[string]any
+	matched := 0
+	if partIdx < len(table.Parts) {
+		err := s.b.ReadPart(table.Parts[partIdx], func(row map[string]any) error {
+			if q != "" {
+				b, _ := json.Marshal(row)
+				if !strings.Contains(strings.ToLower(string(b)), q) {
+					return nil
+				}
+			}
+			if matched >= offset && matched < offset+limit {
+				rows = append(rows, row)
+			}
+			matched++
+			return nil
+		})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	columns := serveColumns(rows)
+	cells := make([][]string, len(rows))
+	for i, row := range rows {
+		for _, c := range columns {
+			cells[i] = append(cells[i], stitchMessage(row[c]))
+		}
+	}
+	page := map[string]any{
+		"Table":   table,
+		"Part":    partIdx,
+		"Q":       r.FormValue("q"),
+		"Columns": columns,
+		"Rows":    cells,
+		"Offset":  offset,
+		"Limit":   limit,
+		"Matched": matched,
+	}
+	page["HasPrev"], page["Prev"] = offset > 0, max(offset-limit, 0)
+	page["HasNext"], page["Next"] = offset+limit < matched, offset+limit
+	s.render(w, "table", page)
+}
+
+// serveColumns returns the columns of rows, TimeGenerated first and the
+// others by name.
+func serveColumns(rows []map[string]any) []string {
+	seen := map[string]bool{}
+	var cols []string
+	for _, row := range rows {
+		for k := range row {
+			if !seen[k] {
+				seen[k] = true
+				cols = append(cols, k)
+			}
+		}
+	}
+	sort.Slice(cols, func(i, j int) bool {
+		if (cols[i] == "TimeGenerated") != (cols[j] == "TimeGenerated") {
+			return cols[i] == "TimeGenerated"
+		}
+		return cols[i] < cols[j]
+	})
+	return cols
+}
+
+var serveTemplates = template.Must(template.New("serve").Funcs(template.FuncMap{
+	"base":  path.Base,
+	"isLog": func(p string) bool { return strings.HasSuffix(p, ".log") },
+	"ts": func(t time.Time) string {
+		if t.IsZero() {
+			return "-"
+		}
+		return t.Format(time.RFC3339)
+	},
+}).Parse(`
+{{- define "head"}}<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>{{.Archive}} - AKS must-gather</title>
+<style>
+body { font-family: -apple-system, "Segoe UI", Helvetica, Arial, sans-serif; margin: 1.5em 2em; color: #222; }
+nav a { margin-right: 1em; }
+table { border-collapse: collapse; margin: 0.5em 0 1.5em; font-size: 0.9em; }
+th, td { border: 1px solid #ccc; padding: 0.2em 0.5em; text-align: left; vertical-align: top; }
+th { background: #f3f3f3; position: sticky; top: 0; }
+tr.warning td { background: #fdecea; }
+pre { background: #f8f8f8; padding: 0.8em; overflow-x: auto; font-size: 0.85em; }
+details { margin: 0.2em 0 0.2em 1em; }
+form { margin: 0.8em 0; }
+.note { color: #666; }
+.timeline { display: flex; align-items: flex-end; height: 120px; gap: 1px; border-bottom: 1px solid #999; margin: 1em 0; }
+.timeline div { flex: 1; background: #9bb7d4; position: relative; min-width: 2px; }
+.timeline div span { position: absolute; bottom: 0; left: 0; right: 0; background: #d9534f; }
+</style>
+</head>
+<body>
+<nav><a href="/">{{.Archive}}</a><a href="/events">Event timeline</a></nav>
+{{- end}}
+
+{{- define "window"}}
+<label>From <input type="datetime-local" name="since" value="{{.Since}}"></label>
+<label>to <input type="datetime-local" name="until" value="{{.Until}}"></label> <span class="note">(UTC)</span>
+{{- end}}
+
+{{- define "index"}}{{template "head" .}}
+<h1>{{.Archive}}</h1>
+{{- if .Incomplete}}<p class="note"><strong>The gather was interrupted; this bundle is incomplete.</strong></p>{{end}}
+<h2>Namespaces</h2>
+{{- range .Namespaces}}
+<details><summary>{{.Name}} ({{len .Pods}} pods)</summary>
+{{- if .Events}}<div><a href="/log?path={{.Events}}">events</a> · <a href="/events?ns={{.Name}}">timeline</a></div>{{end}}
+{{- range .Pods}}
+<details><summary>{{.Name}}</summary>
+<ul>{{range .Files}}<li>{{if isLog .}}<a href="/log?path={{.}}">{{base .}}</a>{{else}}<a href="/raw/{{.}}">{{base .}}</a>{{end}}</li>{{end}}</ul>
+</details>
+{{- end}}
+</details>
+{{- else}}<p class="note">No stitched logs in this bundle.</p>
+{{- end}}
+{{- if .Nodes}}
+<h2>Nodes</h2>
+<ul>{{range .Nodes}}<li>{{.Name}}: {{range $i, $f := .Files}}{{if $i}}, {{end}}{{if isLog $f}}<a href="/log?path={{$f}}">{{base $f}}</a>{{else}}<a href="/raw/{{$f}}">{{base $f}}</a>{{end}}{{end}}</li>{{end}}</ul>
+{{- end}}
+<h2>Tables</h2>
+<table><tr><th>Table</th><th>Parts</th></tr>
+{{- range .Tables}}
+<tr><td><a href="/table?name={{.Name}}">{{.Name}}</a></td><td>{{len .Parts}}</td></tr>
+{{- end}}
+</table>
+<h2>Files</h2>
+<ul>{{range .Files}}<li><a href="/raw/{{.}}">{{.}}</a></li>{{end}}</ul>
+</body></html>
+{{- end}}
+
+{{- define "log"}}{{template "head" .}}
+<h1>{{.Path}}</h1>
+<form method="get"><input type="hidden" name="path" value="{{.Path}}">
+{{template "window" .Window}}
+<label>containing <input type="search" name="grep" value="{{.Grep}}"></label>
+<button>Filter</button> <a href="/raw/{{.Path}}">raw</a></form>
+<p class="note">{{.Matched}} matching lines{{if gt .Matched .Limit}}, showing the first {{.Limit}}{{end}}.</p>
+<pre>{{range .Lines}}{{.}}
+{{end}}</pre>
+</body></html>
+{{- end}}
+
+{{- define "events"}}{{template "head" .}}
+<h1>Event timeline{{if .Namespace}}: {{.Namespace}}{{end}}</h1>
+<form method="get">
+<select name="ns"><option value="">all namespaces</option>{{$ns := .Namespace}}{{range .Namespaces}}<option value="{{.}}"{{if eq . $ns}} selected{{end}}>{{if .}}{{.}}{{else}}(cluster){{end}}</option>{{end}}</select>
+{{template "window" .Window}}
+<label><input type="checkbox" name="warnings" value="1"{{if .Warnings}} checked{{end}}> warnings only</label>
+<button>Filter</button></form>
+{{- if .Bars}}
+<div class="timeline">{{range .Bars}}<div style="height: {{.Height}}%" title="{{.Start}}: {{.Events}} events, {{.Warnings}} warnings"><span style="height: {{.Warn}}%"></span></div>{{end}}</div>
+<p class="note">{{(index .Bars 0).Start}} onwards, 5-minute bars; warnings in red.</p>
+{{- end}}
+<p class="note">{{.Matched}} events{{if gt .Matched .Limit}}, showing the latest {{.Limit}}{{end}}.</p>
+<table><tr><th>Time</th><th>Namespace</th><th>Type</th><th>Reason</th><th>Object</th><th>Message</th></tr>
+{{- range .Events}}
+<tr{{if .Warning}} class="warning"{{end}}><td>{{ts .Time}}</td><td>{{.Namespace}}</td><td>{{.Type}}</td><td>{{.Reason}}</td><td>{{.Object}}</td><td>{{.Message}}</td></tr>
+{{- end}}
+</table>
+</body></html>
+{{- end}}
+
+{{- define "table"}}{{template "head" .}}
+<h1>{{.Table.Name}}</h1>
+<form method="get"><input type="hidden" name="name" value="{{.Table.Name}}">
+<label>Part <select name="part">{{$p := .Part}}{{range $i, $part := .Table.Parts}}<option value="{{$i}}"{{if eq $i $p}} selected{{end}}>{{base $part}}</option>{{end}}</select></label>
+<label>containing <input type="search" name="q" value="{{.Q}}"></label>
+<button>Show</button></form>
+<p class="note">{{.Matched}} matching rows in this part{{if .Rows}}, showing {{.Offset}}+{{len .Rows}}{{end}}.
+{{- if .HasPrev}} <a href="/table?name={{.Table.Name}}&part={{.Part}}&q={{.Q}}&offset={{.Prev}}&limit={{.Limit}}">previous</a>{{end}}
+{{- if .HasNext}} <a href="/table?name={{.Table.Name}}&part={{.Part}}&q={{.Q}}&offset={{.Next}}&limit={{.Limit}}">next</a>{{end}}</p>
+<table><tr>{{range .Columns}}<th>{{.}}</th>{{end}}</tr>
+{{- range .Rows}}
+<tr>{{range .}}<td>{{.}}</td>{{end}}</tr>
+{{- end}}
+</table>
+</body></html>
+{{- end}}
+`))
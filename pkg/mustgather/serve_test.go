@@ -0,0 +1,161 @@
+package mustgather
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestArchiveServer(t *testing.T) {
+	src := writeBundleDir(t, map[string]string{
+		"index.json": `{"tables":["KubeEvents","ContainerLogV2"]}`,
+		"SUMMARY.md": "# Summary\n",
+		"tables/KubeEvents/parts/0000-a.ndjson": strings.Join([]string{
+			`{"TimeGenerated":"2024-01-01T00:01:00Z","Namespace":"shop","Name":"cart-1","ObjectKind":"Pod","Reason":"Pulled","Message":"Container image pulled","KubeEventType":"Normal"}`,
+			`{"TimeGenerated":"2024-01-01T00:12:00Z","Namespace":"shop","Name":"cart-1","ObjectKind":"Pod","Reason":"BackOff","Message":"Back-off <restarting>","KubeEventType":"Warning"}`,
+			`{"TimeGenerated":"2024-01-01T00:13:00Z","Namespace":"kube-system","Name":"coredns","ObjectKind":"Pod","Reason":"Started","Message":"Started","KubeEventType":"Normal"}`,
+		}, "\n") + "\n",
+		"tables/ContainerLogV2/parts/0000-a.ndjson": `{"TimeGenerated":"2024-01-01T00:00:01Z","PodName":"cart-1","LogMessage":"a"}` + "\n" +
+			`{"TimeGenerated":"2024-01-01T00:00:02Z","PodName":"web-1","LogMessage":"b"}` + "\n" +
+			`{"TimeGenerated":"2024-01-01T00:00:03Z","PodName":"cart-1","LogMessage":"c"}` + "\n",
+		"namespaces/shop/pods/cart-1/cart.log": "2024-01-01T00:00:01Z [stdout] starting\n" +
+			"2024-01-01T00:05:00Z [stderr] panic: boom\n" +
+			"2024-01-01T00:10:00Z [stdout] starting again\n",
+		"namespaces/shop/pods/cart-1/pod.yaml": "kind: Pod\n",
+		"namespaces/shop/events/events.log":    "2024-01-01T00:12:00Z [Warning] Pod/cart-1 BackOff\n",
+		"nodes/node-1/syslog.log":              "2024-01-01T00:00:00Z [-] kernel: hello\n",
+		"analysis/terminations.json":           "{}\n",
+	})
+	s, err := NewArchiveServer(src)
+	if err != nil {
+		t.Fatalf("NewArchiveServer failed: %v", err)
+	}
+	defer s.Close()
+	srv := httptest.NewServer(s.Handler())
+	defer srv.Close()
+
+	get := func(p string, q url.Values) (int, string) {
+		t.Helper()
+		u := srv.URL + p
+		if q != nil {
+			u += "?" + q.Encode()
+		}
+		resp, err := http.Get(u)
+		if err != nil {
+			t.Fatalf("GET %s: %v", u, err)
+		}
+		defer resp.Body.Close()
+		b, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(b)
+	}
+
+	tests := []struct {
+		name       string
+		path       string
+		query      url.Values
+		status     int
+		contains   []string
+		notContain []string
+	}{
+		{
+			name:   "tree",
+			path:   "/",
+			status: http.StatusOK,
+			contains: []string{
+				"<summary>shop (1 pods)</summary>",
+				`<a href="/log?path=namespaces%2fshop%2fpods%2fcart-1%2fcart.log">cart.log</a>`,
+				`<a href="/raw/namespaces/shop/pods/cart-1/pod.yaml">pod.yaml</a>`,
+				`<a href="/events?ns=shop">timeline</a>`,
+				`<a href="/log?path=nodes%2fnode-1%2fsyslog.log">syslog.log</a>`,
+				`<a href="/table?name=KubeEvents">KubeEvents</a>`,
+				`<a href="/raw/analysis/terminations.json">analysis/terminations.json</a>`,
+			},
+			notContain: []string{"/raw/tables/"},
+		},
+		{
+			name:       "log filtered by time",
+			path:       "/log",
+			query:      url.Values{"path": {"namespaces/shop/pods/cart-1/cart.log"}, "since": {"2024-01-01T00:01"}, "until": {"2024-01-01T00:10"}},
+			status:     http.StatusOK,
+			contains:   []string{"panic: boom", "1 matching lines"},
+			notContain: []string{"starting"},
+		},
+		{
+			name:       "log filtered by text",
+			path:       "/log",
+			query:      url.Values{"path": {"namespaces/shop/pods/cart-1/cart.log"}, "grep": {"AGAIN"}},
+			status:     http.StatusOK,
+			contains:   []string{"starting again"},
+			notContain: []string{"panic"},
+		},
+		{
+			name:   "invalid time",
+			path:   "/log",
+			query:  url.Values{"path": {"namespaces/shop/pods/cart-1/cart.log"}, "since": {"yesterday"}},
+			status: http.StatusBadRequest,
+		},
+		{
+			name:   "log outside the bundle",
+			path:   "/log",
+			query:  url.Values{"path": {"../../etc/passwd.log"}},
+			status: http.StatusNotFound,
+		},
+		{
+			name:   "event timeline of a namespace",
+			path:   "/events",
+			query:  url.Values{"ns": {"shop"}},
+			status: http.StatusOK,
+			// Bars from 00:00: one event, none, one warning
+			contains:   []string{"2 events", `class="warning"`, "Back-off &lt;restarting&gt;", `<div style="height: 0%"`, `<span style="height: 100%">`, "2024-01-01T00:00 onwards"},
+			notContain: []string{"coredns"},
+		},
+		{
+			name:       "warning events",
+			path:       "/events",
+			query:      url.Values{"warnings": {"1"}},
+			status:     http.StatusOK,
+			contains:   []string{"1 events", "BackOff"},
+			notContain: []string{"Pulled"},
+		},
+		{
+			name:     "table rows paginated",
+			path:     "/table",
+			query:    url.Values{"name": {"ContainerLogV2"}, "q": {"cart-1"}, "limit": {"1"}},
+			status:   http.StatusOK,
+			contains: []string{"2 matching rows in this part, showing 0+1", "<th>TimeGenerated</th><th>LogMessage</th><th>PodName</th>", "<td>a</td>", "offset=1&limit=1\">next</a>"},
+		},
+		{
+			name:   "unknown table",
+			path:   "/table",
+			query:  url.Values{"name": {"Nope"}},
+			status: http.StatusNotFound,
+		},
+		{
+			name:     "raw file",
+			path:     "/raw/SUMMARY.md",
+			status:   http.StatusOK,
+			contains: []string{"# Summary"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, body := get(tt.path, tt.query)
+			if status != tt.status {
+				t.Fatalf("status %d, want %d:\n%s", status, tt.status, body)
+			}
+			for _, want := range tt.contains {
+				if !strings.Contains(body, want) {
+					t.Errorf("missing %q:\n%s", want, body)
+				}
+			}
+			for _, unwanted := range tt.notContain {
+				if strings.Contains(body, unwanted) {
+					t.Errorf("unexpected %q:\n%s", unwanted, body)
+				}
+			}
+		})
+	}
+}
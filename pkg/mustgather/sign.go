@@ -0,0 +1,143 @@
+package mustgather
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"kubectl-must-gather/pkg/utils"
+	"kubectl-must-gather/pkg/version"
+)
+
+// manifestSuffix and signatureSuffix name the checksum manifest written next
+// to an archive and its signature.
+const (
+	manifestSuffix  = ".manifest.json"
+	signatureSuffix = ".sig"
+)
+
+// manifestFile is an output file of the gather in the checksum manifest.
+type manifestFile struct {
+	Name   string `json:"name"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// checksumManifest is the content of <archive>.manifest.json: the checksums
+// of the files handed over and of every entry of the archive, so recipients
+// can check both the download and the extracted files.
+type checksumManifest struct {
+	CreatedAt         string          `json:"createdAt"`
+	Tool              string          `json:"tool"`
+	Archive           manifestFile    `json:"archive"`
+	RestrictedArchive *manifestFile   `json:"restrictedArchive,omitempty"`
+	Entries           []utils.TarFile `json:"entries"`
+}
+
+// checkSigner checks that cosign, which signs the manifest with a key file or
+// a KMS key such as azurekms://<vault>.vault.azure.net/<key>, is installed.
+func checkSigner() error {
+	if _, err := exec.LookPath("cosign"); err != nil {
+		return fmt.Errorf("--sign-key needs cosign on PATH: %w", err)
+	}
+	return nil
+}
+
+// fileChecksum returns the manifest entry of the file at path.
+func fileChecksum(path string) (manifestFile, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return manifestFile{}, err
+	}
+	defer f.Close()
+	h := sha256.New()
+	n, err := io.Copy(h, f)
+	if err != nil {
+		return manifestFile{}, err
+	}
+	return manifestFile{Name: filepath.Base(path), Size: n, SHA256: hex.EncodeToString(h.Sum(nil))}, nil
+}
+
+// writeManifest writes the checksum manifest of the finished archive, and of
+// the restricted archive when there is one, next to the archive. entries are
+// the files inside the archive, checksummed before any encryption.
+func writeManifest(archive, restricted string, entries []utils.TarFile) (string, error) {
+	m := checksumManifest{
+		CreatedAt: time.Now().UTC().Format(time.RFC3339),
+		Tool:      version.Get().String(),
+		Entries:   entries,
+	}
+	var err error
+	if m.Archive, err = fileChecksum(archive); err != nil {
+		return "", fmt.Errorf("checksum archive: %w", err)
+	}
+	if restricted != "" {
+		r, err := fileChecksum(restricted)
+		if err != nil {
+			return "", fmt.Errorf("checksum restricted archive: %w", err)
+		}
+		m.RestrictedArchive = &r
+	}
+	if m.Entries == nil {
+		m.Entries = []utils.TarFile{}
+	}
+	b, _ := json.MarshalIndent(m, "", "  ")
+	path := archive + manifestSuffix
+	if err := os.WriteFile(path, append(b, '\n'), 0o644); err != nil {
+		return "", fmt.Errorf("write manifest: %w", err)
+	}
+	return path, nil
+}
+
+// signFile signs path with cosign and key, a cosign key file or KMS URI,
+// writing the base64 signature to path plus ".sig". The signature is not
+// uploaded to the public transparency log: the gather's existence is not
+// published, and recipients verify it with the public key alone.
+func signFile(ctx context.Context, key, path string) (string, error) {
+	sig := path + signatureSuffix
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "cosign", "sign-blob", "--yes", "--tlog-upload=false", "--key", key, "--output-signature", sig, path)
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		os.Remove(sig)
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", fmt.Errorf("cosign: %w: %s", err, lastLine(msg))
+		}
+		return "", fmt.Errorf("cosign: %w", err)
+	}
+	return sig, nil
+}
+
+// lastLine returns the last line of s; cosign ends its output with the error.
+func lastLine(s string) string {
+	if i := strings.LastIndexByte(s, '\n'); i >= 0 {
+		return s[i+1:]
+	}
+	return s
+}
+
+// signArchive writes the checksum manifest of the gather's archives and signs
+// it with SignKey.
+func (g *Gatherer) signArchive() error {
+	manifest, err := writeManifest(g.outFile, g.restrictedFile, g.entries)
+	if err != nil {
+		return err
+	}
+	g.manifestFile = manifest
+	sig, err := signFile(context.Background(), g.config.SignKey, manifest)
+	if err != nil {
+		return fmt.Errorf("sign manifest: %w", err)
+	}
+	g.signatureFile = sig
+	fmt.Fprintf(g.log, "Signed %s with %s\n", manifest, sig)
+	return nil
+}
@@ -0,0 +1,100 @@
+package mustgather
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"kubectl-must-gather/pkg/utils"
+)
+
+// fakeCosign puts a cosign stand-in on PATH whose sign-blob writes "signed:"
+// and the key to --output-signature, or fails for key "bad.key".
+func fakeCosign(t *testing.T) {
+	t.Helper()
+	dir := t.TempDir()
+	script := `#!/bin/sh
+key=""; out=""
+while [ $# -gt 0 ]; do
+  case "$1" in
+    --key) key="$2"; shift 2 ;;
+    --output-signature) out="$2"; shift 2 ;;
+    *) shift ;;
+  esac
+done
+[ "$key" = bad.key ] && { echo "Using payload from: x" >&2; echo "Error: signing blob: decrypt key: wrong password" >&2; printf partial > "$out"; exit 1; }
+printf 'signed:%s' "$key" > "$out"
+`
+	if err := os.WriteFile(filepath.Join(dir, "cosign"), []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+}
+
+func TestWriteManifest(t *testing.T) {
+	dir := t.TempDir()
+	archive := filepath.Join(dir, "bundle.tar.gz.age")
+	restricted := filepath.Join(dir, "restricted.tar.gz.age")
+	if err := os.WriteFile(archive, []byte("hello"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(restricted, []byte{}, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	entries := []utils.TarFile{{Path: "index.json", Size: 2, SHA256: "44136fa355b3678a1146ad16f7e8649e94fb4fc21fe77e8310c060f61caaff8a"}}
+
+	path, err := writeManifest(archive, restricted, entries)
+	if err != nil {
+		t.Fatalf("writeManifest failed: %v", err)
+	}
+	if path != archive+manifestSuffix {
+		t.Errorf("manifest written to %s", path)
+	}
+	b, _ := os.ReadFile(path)
+	var m checksumManifest
+	if err := json.Unmarshal(b, &m); err != nil {
+		t.Fatal(err)
+	}
+	want := manifestFile{Name: "bundle.tar.gz.age", Size: 5, SHA256: "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824"}
+	if m.Archive != want || len(m.Entries) != 1 || m.Entries[0] != entries[0] {
+		t.Errorf("unexpected manifest %s", b)
+	}
+	if m.RestrictedArchive == nil || m.RestrictedArchive.SHA256 != "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855" {
+		t.Errorf("expected the restricted archive's checksum, got %s", b)
+	}
+
+	if _, err := writeManifest(filepath.Join(dir, "missing.tar.gz"), "", nil); err == nil {
+		t.Error("expected a missing archive to fail")
+	}
+}
+
+func TestSignFile(t *testing.T) {
+	fakeCosign(t)
+	path := filepath.Join(t.TempDir(), "bundle.tar.gz.manifest.json")
+	if err := os.WriteFile(path, []byte("{}"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	sig, err := signFile(context.Background(), "azurekms://vault.vault.azure.net/gather", path)
+	if err != nil {
+		t.Fatalf("signFile failed: %v", err)
+	}
+	if b, _ := os.ReadFile(sig); sig != path+signatureSuffix || string(b) != "signed:azurekms://vault.vault.azure.net/gather" {
+		t.Errorf("unexpected signature %s: %q", sig, b)
+	}
+
+	if _, err := signFile(context.Background(), "bad.key", path); err == nil || !strings.HasSuffix(err.Error(), "wrong password") {
+		t.Errorf("expected cosign's error, got %v", err)
+	}
+	if _, err := os.Stat(path + signatureSuffix); !os.IsNotExist(err) {
+		t.Error("a failed signature should be removed")
+	}
+
+	t.Setenv("PATH", t.TempDir())
+	if err := checkSigner(); err == nil || !strings.Contains(err.Error(), "needs cosign on PATH") {
+		t.Errorf("expected a missing cosign to fail, got %v", err)
+	}
+}
@@ -0,0 +1,405 @@
+package mustgather
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"kubectl-must-gather/pkg/utils"
+)
+
+// errorBurstLines is how many error lines a container must log within a
+// minute to count as an error burst.
+const errorBurstLines = 10
+
+// errorLine matches log lines that look like errors when ContainerLogV2 has
+// no LogLevel column.
+var errorLine = regexp.MustCompile(`(?i)\b(error|fatal|panic|exception)\b`)
+
+// nodePressureReasons maps node event reasons to the condition they report.
+var nodePressureReasons = map[string]string{
+	"NodeHasDiskPressure":       "DiskPressure",
+	"NodeHasInsufficientMemory": "MemoryPressure",
+	"NodeHasInsufficientPID":    "PIDPressure",
+	"EvictionThresholdMet":      "EvictionThresholdMet",
+	"NodeNotReady":              "NotReady",
+}
+
+// clusterSignals collects, while rows are exported, the signals that sum up
+// a gather: containers that restart or crash loop, OOM kills, warning events,
+// error logs and nodes under pressure. It derives no files itself; the
+// summaries (SUMMARY.md, --ai-summary) and the restart analysis are rendered
+// from it.
+type clusterSignals struct {
+	containers map[string]*containerHealth
+	events     map[string]*eventGroup
+	errors     map[string]*errorBucket
+	nodes      map[string]*nodePressure
+}
+
+// containerHealth is the restart history of a container from
+// KubePodInventory.
+type containerHealth struct {
+	namespace, pod, container string
+	// minRestarts and maxRestarts bound the cumulative restart count seen
+	// over the window.
+	minRestarts, maxRestarts int64
+	reason, lastTime         string
+	oomKilled                bool
+	// counts maps each restart count seen to when it was first reported and
+	// the termination behind it.
+	counts map[int64]*restartObservation
+}
+
+// restartObservation is the first KubePodInventory snapshot that reported a
+// container's restart count, with the container's last termination then.
+type restartObservation struct {
+	firstSeen  string
+	reason     string
+	finishedAt string
+	exitCode   *int64
+}
+
+// eventGroup counts warning events of one reason on one object.
+type eventGroup struct {
+	namespace, kind, name, reason string
+	message                       string
+	count                         int64
+	first, last                   string
+}
+
+// errorBucket counts a container's error log lines within one minute.
+type errorBucket struct {
+	namespace, pod, container string
+	minute                    string
+	count                     int
+	sample                    string
+}
+
+// errorSource totals a container's error log lines over the window.
+type errorSource struct {
+	namespace, pod, container string
+	count                     int
+	sample                    string
+}
+
+// nodePressure records the pressure (or not-ready) conditions a node
+// reported, from its inventory status and node events.
+type nodePressure struct {
+	node        string
+	conditions  map[string]bool
+	first, last string
+}
+
+func newClusterSignals() *clusterSignals {
+	return &clusterSignals{
+		containers: map[string]*containerHealth{},
+		events:     map[string]*eventGroup{},
+		errors:     map[string]*errorBucket{},
+		nodes:      map[string]*nodePressure{},
+	}
+}
+
+func (s *clusterSignals) observe(table string, row map[string]any) {
+	switch table {
+	case "KubePodInventory":
+		s.observePod(row)
+	case "KubeEvents":
+		s.observeEvent(row)
+	case "ContainerLogV2":
+		s.observeLog(row)
+	case "KubeNodeInventory":
+		s.observeNode(row)
+	}
+}
+
+func (s *clusterSignals) endChunk(tarw *utils.TarWriter) error {
+	return nil
+}
+
+func (s *clusterSignals) finish(tarw *utils.TarWriter) error {
+	return nil
+}
+
+func (s *clusterSignals) warnings(table string) []string {
+	return nil
+}
+
+func (s *clusterSignals) observePod(row map[string]any) {
+	cn := podContainerName(toStr(row["ContainerName"]))
+	if cn == "" {
+		return
+	}
+	ns, pod := toStr(row["Namespace"]), toStr(row["Name"])
+	key := ns + "/" + pod + "/" + cn
+	c, ok := s.containers[key]
+	rc, _ := toFloat(row["ContainerRestartCount"])
+	restarts := int64(rc)
+	if !ok {
+		c = &containerHealth{namespace: ns, pod: pod, container: cn, minRestarts: restarts, counts: map[int64]*restartObservation{}}
+		s.containers[key] = c
+	}
+	if restarts < c.minRestarts {
+		c.minRestarts = restarts
+	}
+	if restarts > c.maxRestarts {
+		c.maxRestarts = restarts
+	}
+	tm := toStr(row["TimeGenerated"])
+	if later(tm, c.lastTime) {
+		c.lastTime = tm
+		c.reason = toStr(row["ContainerStatusReason"])
+	}
+	if strings.EqualFold(toStr(row["ContainerStatusReason"]), "OOMKilled") {
+		c.oomKilled = true
+	}
+	last, _ := dynamicValue(row["ContainerLastStatus"]).(map[string]any)
+	if strings.EqualFold(toStr(last["reason"]), "OOMKilled") {
+		c.oomKilled = true
+	}
+	if o, ok := c.counts[restarts]; !ok || timeBefore(tm, o.firstSeen) {
+		o = &restartObservation{firstSeen: tm, reason: toStr(last["reason"]), finishedAt: toStr(last["finishedAt"])}
+		if code, ok := toFloat(last["exitCode"]); ok {
+			n := int64(code)
+			o.exitCode = &n
+		}
+		c.counts[restarts] = o
+	}
+}
+
+func (s *clusterSignals) observeEvent(row map[string]any) {
+	reason := toStr(row["Reason"])
+	msg := toStr(row["Message"])
+	tm := toStr(row["TimeGenerated"])
+	if cond, ok := nodePressureReasons[reason]; ok && strings.EqualFold(toStr(row["ObjectKind"]), "Node") {
+		s.nodePressure(toStr(row["Name"]), cond, tm)
+	}
+	if toStr(row["KubeEventType"]) != "Warning" && !isOOMEvent(reason, msg) {
+		return
+	}
+	ns, kind, name := toStr(row["Namespace"]), toStr(row["ObjectKind"]), toStr(row["Name"])
+	key := strings.Join([]string{ns, kind, name, reason}, "/")
+	e, ok := s.events[key]
+	if !ok {
+		e = &eventGroup{namespace: ns, kind: kind, name: name, reason: reason}
+		s.events[key] = e
+	}
+	c, _ := toFloat(row["Count"])
+	n := int64(c)
+	if n < 1 {
+		n = 1
+	}
+	e.count += n
+	if e.first == "" || timeBefore(tm, e.first) {
+		e.first = tm
+	}
+	if later(tm, e.last) {
+		e.last = tm
+		e.message = msg
+	}
+}
+
+// errorLog reports whether a ContainerLogV2 row is an error, by its LogLevel
+// or, without one, its message, and returns the message.
+func errorLog(row map[string]any) (string, bool) {
+	msg := stitchMessage(row["LogMessage"])
+	if level, ok := row["LogLevel"]; ok {
+		switch strings.ToLower(toStr(level)) {
+		case "error", "critical", "fatal":
+			return msg, true
+		}
+		return msg, false
+	}
+	return msg, errorLine.MatchString(msg)
+}
+
+func (s *clusterSignals) observeLog(row map[string]any) {
+	msg, ok := errorLog(row)
+	if !ok {
+		return
+	}
+	ts := utils.ParseTimeRFC3339(toStr(row["TimeGenerated"]))
+	if ts.IsZero() {
+		return
+	}
+	ns, pod, cn := toStr(row["PodNamespace"]), toStr(row["PodName"]), toStr(row["ContainerName"])
+	minute := ts.UTC().Truncate(time.Minute).Format(time.RFC3339)
+	key := strings.Join([]string{ns, pod, cn, minute}, "/")
+	b, ok := s.errors[key]
+	if !ok {
+		b = &errorBucket{namespace: ns, pod: pod, container: cn, minute: minute, sample: truncateSample(msg)}
+		s.errors[key] = b
+	}
+	b.count++
+}
+
+// observeNode picks pressure and not-ready conditions out of the node's
+// Status, a comma-separated list of its conditions.
+func (s *clusterSignals) observeNode(row map[string]any) {
+	node := toStr(row["Computer"])
+	if node == "" {
+		return
+	}
+	for _, cond := range strings.FieldsFunc(toStr(row["Status"]), func(r rune) bool { return r == ',' || r == ' ' }) {
+		if strings.HasSuffix(cond, "Pressure") || cond == "NotReady" || cond == "Unknown" {
+			s.nodePressure(node, cond, toStr(row["TimeGenerated"]))
+		}
+	}
+}
+
+func (s *clusterSignals) nodePressure(node, cond, tm string) {
+	if node == "" {
+		return
+	}
+	n, ok := s.nodes[node]
+	if !ok {
+		n = &nodePressure{node: node, conditions: map[string]bool{}}
+		s.nodes[node] = n
+	}
+	n.conditions[cond] = true
+	if n.first == "" || timeBefore(tm, n.first) {
+		n.first = tm
+	}
+	if later(tm, n.last) {
+		n.last = tm
+	}
+}
+
+// restarting returns containers that restarted or are crash looping, most
+// restarts first.
+func (s *clusterSignals) restarting() []*containerHealth {
+	var out []*containerHealth
+	for _, c := range s.containers {
+		if c.maxRestarts > 0 || c.reason == "CrashLoopBackOff" {
+			out = append(out, c)
+		}
+	}
+	sortByRestarts(out)
+	return out
+}
+
+// oomKilled returns containers last terminated by the OOM killer.
+func (s *clusterSignals) oomKilled() []*containerHealth {
+	var out []*containerHealth
+	for _, c := range s.containers {
+		if c.oomKilled {
+			out = append(out, c)
+		}
+	}
+	sortByRestarts(out)
+	return out
+}
+
+func sortByRestarts(cs []*containerHealth) {
+	sort.Slice(cs, func(i, j int) bool {
+		if cs[i].maxRestarts != cs[j].maxRestarts {
+			return cs[i].maxRestarts > cs[j].maxRestarts
+		}
+		return containerKey(cs[i]) < containerKey(cs[j])
+	})
+}
+
+// eventGroups returns OOM events and the other warning events, most
+// frequent first.
+func (s *clusterSignals) eventGroups() (oom, warnings []*eventGroup) {
+	for _, e := range s.events {
+		if isOOMEvent(e.reason, e.message) {
+			oom = append(oom, e)
+		} else {
+			warnings = append(warnings, e)
+		}
+	}
+	byCount := func(es []*eventGroup) {
+		sort.Slice(es, func(i, j int) bool {
+			if es[i].count != es[j].count {
+				return es[i].count > es[j].count
+			}
+			if es[i].first != es[j].first {
+				return es[i].first < es[j].first
+			}
+			return es[i].name < es[j].name
+		})
+	}
+	byCount(oom)
+	byCount(warnings)
+	return oom, warnings
+}
+
+// errorBursts returns the minutes in which a container logged at least
+// errorBurstLines errors, largest first.
+func (s *clusterSignals) errorBursts() []*errorBucket {
+	var out []*errorBucket
+	for _, b := range s.errors {
+		if b.count >= errorBurstLines {
+			out = append(out, b)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].count != out[j].count {
+			return out[i].count > out[j].count
+		}
+		return out[i].minute < out[j].minute
+	})
+	return out
+}
+
+// errorSources totals error lines per container, most first. The sample is
+// the first error line of the container's earliest bucket.
+func (s *clusterSignals) errorSources() []*errorSource {
+	byContainer := map[string]*errorSource{}
+	first := map[string]string{}
+	for _, b := range s.errors {
+		key := b.namespace + "/" + b.pod + "/" + b.container
+		src, ok := byContainer[key]
+		if !ok {
+			src = &errorSource{namespace: b.namespace, pod: b.pod, container: b.container}
+			byContainer[key] = src
+		}
+		src.count += b.count
+		if m, ok := first[key]; !ok || b.minute < m {
+			first[key] = b.minute
+			src.sample = b.sample
+		}
+	}
+	out := make([]*errorSource, 0, len(byContainer))
+	for _, src := range byContainer {
+		out = append(out, src)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].count != out[j].count {
+			return out[i].count > out[j].count
+		}
+		return out[i].namespace+"/"+out[i].pod+"/"+out[i].container < out[j].namespace+"/"+out[j].pod+"/"+out[j].container
+	})
+	return out
+}
+
+// pressuredNodes returns nodes that reported pressure or not-ready
+// conditions, by name.
+func (s *clusterSignals) pressuredNodes() []*nodePressure {
+	out := make([]*nodePressure, 0, len(s.nodes))
+	for _, n := range s.nodes {
+		out = append(out, n)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].node < out[j].node })
+	return out
+}
+
+// conditionList renders the node's conditions sorted.
+func (n *nodePressure) conditionList() string {
+	conds := make([]string, 0, len(n.conditions))
+	for c := range n.conditions {
+		conds = append(conds, c)
+	}
+	sort.Strings(conds)
+	return strings.Join(conds, ", ")
+}
+
+func containerKey(c *containerHealth) string {
+	return c.namespace + "/" + c.pod + "/" + c.container
+}
+
+func isOOMEvent(reason, message string) bool {
+	return strings.Contains(strings.ToUpper(reason), "OOM") || strings.Contains(message, "OOMKilled")
+}
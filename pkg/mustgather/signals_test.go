@@ -0,0 +1,97 @@
+package mustgather
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func observeSignalsFixture(s *clusterSignals) {
+	for i, tm := range []string{"2024-01-01T00:00:00Z", "2024-01-01T00:10:00Z"} {
+		s.observe("KubePodInventory", map[string]any{
+			"TimeGenerated": tm, "Namespace": "shop", "Name": "cart-7d9f", "ContainerName": "uid-1/cart",
+			"ContainerRestartCount": float64(3 + 4*i), "ContainerStatusReason": "CrashLoopBackOff",
+			"ContainerLastStatus": `{"reason":"OOMKilled","exitCode":137}`,
+		})
+		s.observe("KubePodInventory", map[string]any{
+			"TimeGenerated": tm, "Namespace": "shop", "Name": "web-1", "ContainerName": "uid-2/web",
+			"ContainerRestartCount": float64(0), "ContainerStatusReason": "",
+		})
+	}
+	s.observe("KubeEvents", map[string]any{
+		"TimeGenerated": "2024-01-01T00:05:00Z", "Namespace": "shop", "ObjectKind": "Pod", "Name": "cart-7d9f",
+		"Reason": "BackOff", "KubeEventType": "Warning", "Message": "Back-off restarting failed container", "Count": float64(5),
+	})
+	s.observe("KubeEvents", map[string]any{
+		"TimeGenerated": "2024-01-01T00:06:00Z", "Namespace": "shop", "ObjectKind": "Pod", "Name": "web-1",
+		"Reason": "Scheduled", "KubeEventType": "Normal", "Message": "Successfully assigned",
+	})
+	for i := 0; i < errorBurstLines; i++ {
+		s.observe("ContainerLogV2", map[string]any{
+			"TimeGenerated": fmt.Sprintf("2024-01-01T00:07:%02dZ", i), "PodNamespace": "shop", "PodName": "cart-7d9f",
+			"ContainerName": "cart", "LogMessage": "ERROR connection refused",
+		})
+	}
+	// Below the burst threshold
+	s.observe("ContainerLogV2", map[string]any{
+		"TimeGenerated": "2024-01-01T00:08:00Z", "PodNamespace": "shop", "PodName": "web-1",
+		"ContainerName": "web", "LogMessage": "panic: nil map",
+	})
+}
+
+func TestClusterSignalsLogLevel(t *testing.T) {
+	s := newClusterSignals()
+	for i := 0; i < errorBurstLines; i++ {
+		// LogLevel, when present, decides over the message text
+		s.observe("ContainerLogV2", map[string]any{
+			"TimeGenerated": "2024-01-01T00:07:00Z", "PodNamespace": "ns", "PodName": "p", "ContainerName": "c",
+			"LogMessage": "error budget ok", "LogLevel": "info",
+		})
+	}
+	if len(s.errors) != 0 {
+		t.Errorf("info lines counted as errors: %v", s.errors)
+	}
+}
+
+func TestClusterSignalsNodePressure(t *testing.T) {
+	s := newClusterSignals()
+	for _, row := range []map[string]any{
+		{"TimeGenerated": "2024-01-01T00:00:00Z", "Computer": "aks-node-1", "Status": "Ready"},
+		{"TimeGenerated": "2024-01-01T00:05:00Z", "Computer": "aks-node-1", "Status": "Ready,DiskPressure"},
+		{"TimeGenerated": "2024-01-01T00:00:00Z", "Computer": "aks-node-2", "Status": "Ready"},
+	} {
+		s.observe("KubeNodeInventory", row)
+	}
+	s.observe("KubeEvents", map[string]any{
+		"TimeGenerated": "2024-01-01T00:09:00Z", "ObjectKind": "Node", "Name": "aks-node-1",
+		"Reason": "NodeHasInsufficientMemory", "KubeEventType": "Normal",
+	})
+	// Pod events with a node reason are not node conditions
+	s.observe("KubeEvents", map[string]any{
+		"TimeGenerated": "2024-01-01T00:09:00Z", "ObjectKind": "Pod", "Name": "aks-node-2",
+		"Reason": "NodeNotReady", "KubeEventType": "Warning",
+	})
+
+	nodes := s.pressuredNodes()
+	if len(nodes) != 1 {
+		t.Fatalf("pressured nodes = %d, want 1", len(nodes))
+	}
+	n := nodes[0]
+	if n.node != "aks-node-1" || n.conditionList() != "DiskPressure, MemoryPressure" || n.first != "2024-01-01T00:05:00Z" || n.last != "2024-01-01T00:09:00Z" {
+		t.Errorf("node = %s %q %s..%s", n.node, n.conditionList(), n.first, n.last)
+	}
+}
+
+func TestClusterSignalsErrorSources(t *testing.T) {
+	s := newClusterSignals()
+	observeSignalsFixture(s)
+
+	sources := s.errorSources()
+	if len(sources) != 2 {
+		t.Fatalf("error sources = %d, want 2", len(sources))
+	}
+	got := fmt.Sprintf("%s/%s/%s %d", sources[0].namespace, sources[0].pod, sources[0].container, sources[0].count)
+	if got != "shop/cart-7d9f/cart 10" || !strings.HasPrefix(sources[1].sample, "panic") {
+		t.Errorf("sources = %s, then %q", got, sources[1].sample)
+	}
+}
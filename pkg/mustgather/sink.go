@@ -0,0 +1,128 @@
+package mustgather
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+
+	"kubectl-must-gather/pkg/utils"
+)
+
+// Output formats of a gather, the values of Config.OutputFormat.
+const (
+	OutputTarGz = "tar.gz"
+	OutputZip   = "zip"
+	OutputDir   = "dir"
+	OutputBlob  = "blob"
+)
+
+// OutputFormats lists the output formats.
+var OutputFormats = []string{OutputTarGz, OutputZip, OutputDir, OutputBlob}
+
+// outputFormat is the format of the bundle written to out: OutputFormat when
+// set, else blob for an https URL, zip for a .zip name, dir for a name
+// ending in a path separator or naming a directory, and tar.gz otherwise.
+func (c *Config) outputFormat(out string) string {
+	switch {
+	case c.OutputFormat != "":
+		return c.OutputFormat
+	case strings.HasPrefix(out, "https://"):
+		return OutputBlob
+	case strings.HasSuffix(out, ".zip"):
+		return OutputZip
+	case strings.HasSuffix(out, "/") || strings.HasSuffix(out, string(os.PathSeparator)):
+		return OutputDir
+	}
+	if fi, err := os.Stat(out); err == nil && fi.IsDir() {
+		return OutputDir
+	}
+	return OutputTarGz
+}
+
+// ResolveOutputFormat returns the format the gather writes its bundle in:
+// OutputFormat, or the one of the output name.
+func (c *Config) ResolveOutputFormat() string {
+	return c.outputFormat(c.GenerateDefaultOutputName())
+}
+
+// checkOutput rejects an unknown OutputFormat, and the settings that work on
+// an archive file when the bundle goes to a directory, blob container or
+// the sink of WithSink instead.
+func (c *Config) checkOutput(custom bool) error {
+	format := c.ResolveOutputFormat()
+	switch format {
+	case OutputTarGz, OutputZip, OutputDir, OutputBlob:
+	default:
+		return fmt.Errorf("invalid output format %q: expected %s", format, strings.Join(OutputFormats, ", "))
+	}
+	if !custom && (format == OutputTarGz || format == OutputZip) {
+		return nil
+	}
+	var needArchive []string
+	if len(c.EncryptTo) > 0 {
+		needArchive = append(needArchive, "--encrypt-to")
+	}
+	if c.SignKey != "" {
+		needArchive = append(needArchive, "--sign-key")
+	}
+	if c.UploadTo != "" {
+		needArchive = append(needArchive, "--upload-to")
+	}
+	if c.Keep > 0 || c.KeepDays > 0 {
+		needArchive = append(needArchive, "--keep and --keep-days")
+	}
+	if len(needArchive) > 0 {
+		return fmt.Errorf("%s need a tar.gz or zip archive output", strings.Join(needArchive, ", "))
+	}
+	return nil
+}
+
+// openOutput opens the sink the bundle goes to, per the format of out. It
+// returns where the bundle is for messages: out, or the container URL of a
+// blob output without its SAS token.
+func openOutput(ctx context.Context, c *Config, out string, modTime time.Time, cred azcore.TokenCredential, client *http.Client) (utils.Sink, string, error) {
+	switch format := c.outputFormat(out); format {
+	case OutputDir:
+		s, err := utils.NewDirSink(out)
+		if err != nil {
+			return nil, "", fmt.Errorf("create out: %w", err)
+		}
+		return s, out, nil
+	case OutputBlob:
+		// The blobs of run.json, index.json and the other files written once
+		// a gather is interrupted or timed out must still be uploaded
+		s, err := NewBlobSink(context.WithoutCancel(ctx), out, cred, client)
+		if err != nil {
+			return nil, "", fmt.Errorf("invalid --out: %w", err)
+		}
+		return s, s.(*blobSink).up.redacted(), nil
+	case OutputTarGz, OutputZip:
+		f, err := os.Create(out)
+		if err != nil {
+			return nil, "", fmt.Errorf("create out: %w", err)
+		}
+		if format == OutputZip {
+			return &fileSink{Sink: utils.NewZipSink(f, modTime), f: f}, out, nil
+		}
+		return &fileSink{Sink: utils.NewTarGzSink(f, modTime), f: f}, out, nil
+	default:
+		return nil, "", fmt.Errorf("invalid output format %q: expected %s", format, strings.Join(OutputFormats, ", "))
+	}
+}
+
+// fileSink is an archive sink that closes the file it writes once the
+// archive is finished.
+type fileSink struct {
+	utils.Sink
+	f *os.File
+}
+
+func (s *fileSink) Close() error {
+	return errors.Join(s.Sink.Close(), s.f.Close())
+}
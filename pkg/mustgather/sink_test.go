@@ -0,0 +1,149 @@
+package mustgather
+
+import (
+	"archive/zip"
+	"context"
+	"errors"
+	"io"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	azquery "github.com/Azure/azure-sdk-for-go/sdk/monitor/azquery"
+
+	"kubectl-must-gather/pkg/utils"
+)
+
+// memorySink keeps the files of a bundle in memory.
+type memorySink struct {
+	files  map[string]string
+	closed bool
+}
+
+func (s *memorySink) WriteFile(path string, r io.Reader) error {
+	b, err := io.ReadAll(r)
+	s.files[path] = string(b)
+	return err
+}
+
+func (s *memorySink) Close() error {
+	s.closed = true
+	return nil
+}
+
+func TestGatherOutputs(t *testing.T) {
+	ts := time.Now().Add(-10 * time.Minute).UTC().Format(time.RFC3339Nano)
+	gather := func(t *testing.T, config *Config, opts ...Option) error {
+		t.Helper()
+		config.WorkspaceID = "/subscriptions/sub/resourceGroups/rg/providers/Microsoft.OperationalInsights/workspaces/ws"
+		config.Timespan, config.TableFilter, config.Quiet = "PT1H", "Heartbeat", true
+		logs := &fakeLogs{rows: map[string][]azquery.Row{"Heartbeat": {{ts, "node-1"}}}}
+		opts = append([]Option{WithCredential(noCredential{}), WithLogsClient(logs),
+			WithWorkspacesClient(fakeWorkspaces{}), WithTablesClient(fakeTables{names: []string{"Heartbeat"}})}, opts...)
+		g, err := NewGatherer(context.Background(), config, opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = g.Run()
+		return err
+	}
+
+	t.Run("dir", func(t *testing.T) {
+		dir := filepath.Join(t.TempDir(), "bundle") + string(os.PathSeparator)
+		if err := gather(t, &Config{OutputFile: dir}); err != nil {
+			t.Fatalf("Run: %v", err)
+		}
+		b, err := os.ReadFile(filepath.Join(dir, "tables", "Heartbeat", "summary.json"))
+		if err != nil || !strings.Contains(string(b), `"rows": 1`) {
+			t.Errorf("unexpected summary %s, %v", b, err)
+		}
+		if _, err := os.Stat(filepath.Join(dir, "index.json")); err != nil {
+			t.Error(err)
+		}
+	})
+
+	t.Run("zip", func(t *testing.T) {
+		out := filepath.Join(t.TempDir(), "bundle.zip")
+		if err := gather(t, &Config{OutputFile: out}); err != nil {
+			t.Fatalf("Run: %v", err)
+		}
+		zr, err := zip.OpenReader(out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer zr.Close()
+		var names []string
+		for _, f := range zr.File {
+			names = append(names, f.Name)
+		}
+		if all := strings.Join(names, " "); !strings.Contains(all, "tables/Heartbeat/summary.json") || !strings.Contains(all, "index.json") {
+			t.Errorf("unexpected entries %v", names)
+		}
+	})
+
+	t.Run("blob", func(t *testing.T) {
+		svc := &fakeBlobService{blocks: map[string]string{}, lists: map[string]string{}}
+		srv := httptest.NewServer(svc)
+		defer srv.Close()
+		config := &Config{OutputFile: srv.URL + "/diag/run-1?sv=2021-08-06&sig=abc", OutputFormat: OutputBlob}
+		if err := gather(t, config, WithHTTPClient(srv.Client())); err != nil {
+			t.Fatalf("Run: %v", err)
+		}
+		if _, ok := svc.lists["/diag/run-1/tables/Heartbeat/summary.json"]; !ok {
+			t.Errorf("expected a blob per file, got %v", svc.requests)
+		}
+		if _, ok := svc.lists["/diag/run-1/index.json"]; !ok {
+			t.Error("expected index.json as a blob")
+		}
+	})
+
+	t.Run("custom", func(t *testing.T) {
+		sink := &memorySink{files: map[string]string{}}
+		if err := gather(t, &Config{}, WithSink(sink)); err != nil {
+			t.Fatalf("Run: %v", err)
+		}
+		if !sink.closed || !strings.Contains(sink.files["tables/Heartbeat/summary.json"], `"rows": 1`) {
+			t.Errorf("unexpected files of the sink: %v", sink.files)
+		}
+		err := gather(t, &Config{SignKey: "key.pem"}, WithSink(&memorySink{files: map[string]string{}}))
+		if err == nil || !strings.Contains(err.Error(), "--sign-key") {
+			t.Errorf("expected --sign-key to need an archive, got %v", err)
+		}
+	})
+
+	var _ utils.Sink = (*memorySink)(nil)
+}
+
+func TestGatherBlobTimeout(t *testing.T) {
+	svc := &fakeBlobService{blocks: map[string]string{}, lists: map[string]string{}}
+	srv := httptest.NewServer(svc)
+	defer srv.Close()
+	config := &Config{
+		WorkspaceID:  "/subscriptions/sub/resourceGroups/rg/providers/Microsoft.OperationalInsights/workspaces/ws",
+		Timespan:     "PT1H",
+		TableFilter:  "Heartbeat",
+		OutputFile:   srv.URL + "/diag/run-1?sv=2021-08-06&sig=abc",
+		OutputFormat: OutputBlob,
+		Quiet:        true,
+		Timeout:      200 * time.Millisecond,
+	}
+	g, err := NewGatherer(context.Background(), config, WithCredential(noCredential{}), WithLogsClient(&hangingLogs{}),
+		WithWorkspacesClient(fakeWorkspaces{}), WithTablesClient(fakeTables{names: []string{"Heartbeat"}}), WithHTTPClient(srv.Client()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := g.Run(); !errors.Is(err, ErrPartialData) || strings.Contains(err.Error(), "close") {
+		t.Fatalf("expected only the timeout, got %v", err)
+	}
+	// The files written once the gather timed out are uploaded too
+	svc.mu.Lock()
+	defer svc.mu.Unlock()
+	for _, name := range []string{"index.json", "metadata/run.json", "SUMMARY.md"} {
+		if _, ok := svc.lists["/diag/run-1/"+name]; !ok {
+			t.Errorf("expected %s as a blob, got %v", name, svc.requests)
+		}
+	}
+}
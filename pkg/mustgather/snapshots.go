@@ -0,0 +1,259 @@
+package mustgather
+
+import (
+	"bytes"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"kubectl-must-gather/pkg/utils"
+)
+
+// clusterScopedDir holds the snapshots of cluster-scoped resources; the
+// namespaced ones go to namespaces/<ns>/core/.
+const clusterScopedDir = "cluster-scoped"
+
+const snapshotHeader = "# Reconstructed from Log Analytics (KubePodInventory, KubeServices, KubePVInventory, ContainerInventory)\n# as of the end of the window. Best effort: fields not recorded by Container Insights are missing.\n"
+
+// snapshotMaxAge is how long before the last inventory report of a table an
+// object may have last been reported and still be in the snapshot. Container
+// Insights reports inventory every minute, so this spans about two reports.
+const snapshotMaxAge = 2 * time.Minute
+
+// resourceSnapshots writes `kubectl get -o yaml`-style lists of the pods,
+// services, persistent volumes and claims present at the end of the window:
+// namespaces/<ns>/core/{pods,services,persistentvolumeclaims}.yaml and
+// cluster-scoped/{namespaces,persistentvolumes}.yaml. Pods come from the pod
+// manifests, which already track each pod's latest report.
+type resourceSnapshots struct {
+	pods     *podManifests
+	services map[string]*objectReport
+	volumes  map[string]*objectReport
+	// last is the latest report time of each inventory table.
+	last map[string]string
+}
+
+// objectReport is the latest inventory row of an object.
+type objectReport struct {
+	tm  string
+	row map[string]any
+}
+
+func newResourceSnapshots(pods *podManifests) *resourceSnapshots {
+	return &resourceSnapshots{pods: pods, services: map[string]*objectReport{}, volumes: map[string]*objectReport{}, last: map[string]string{}}
+}
+
+func (s *resourceSnapshots) observe(table string, row map[string]any) {
+	var reports map[string]*objectReport
+	var key string
+	switch table {
+	case "KubePodInventory":
+	case "KubeServices":
+		if toStr(row["ServiceName"]) == "" {
+			return
+		}
+		reports, key = s.services, toStr(row["Namespace"])+"/"+toStr(row["ServiceName"])
+	case "KubePVInventory":
+		reports, key = s.volumes, toStr(row["PVName"])
+		if key == "" {
+			return
+		}
+	default:
+		return
+	}
+	tm := toStr(row["TimeGenerated"])
+	if later(tm, s.last[table]) {
+		s.last[table] = tm
+	}
+	if reports == nil {
+		return
+	}
+	if prev, ok := reports[key]; !ok || later(tm, prev.tm) {
+		reports[key] = &objectReport{tm: tm, row: row}
+	}
+}
+
+func (s *resourceSnapshots) endChunk(tarw *utils.TarWriter) error {
+	return nil
+}
+
+func (s *resourceSnapshots) finish(tarw *utils.TarWriter) error {
+	// Lists by path, items by name
+	lists := map[string][]map[string]any{}
+	add := func(p string, obj map[string]any) {
+		lists[p] = append(lists[p], obj)
+	}
+	namespaced := func(ns, file string) string {
+		return filepath.Join("namespaces", utils.SafeFileName(ns), "core", file)
+	}
+	namespaces := map[string]bool{}
+
+	for _, p := range s.pods.pods {
+		if !s.current("KubePodInventory", p.tm) {
+			continue
+		}
+		ns := toStr(p.row["Namespace"])
+		namespaces[ns] = true
+		add(namespaced(ns, "pods.yaml"), s.pods.podObject(p))
+	}
+	for _, r := range s.services {
+		if !s.current("KubeServices", r.tm) {
+			continue
+		}
+		ns := toStr(r.row["Namespace"])
+		namespaces[ns] = true
+		add(namespaced(ns, "services.yaml"), serviceObject(r.row))
+	}
+	for _, r := range s.volumes {
+		if !s.current("KubePVInventory", r.tm) {
+			continue
+		}
+		add(filepath.Join(clusterScopedDir, "persistentvolumes.yaml"), persistentVolumeObject(r.row))
+		if ns, name := toStr(r.row["PVCNamespace"]), toStr(r.row["PVCName"]); ns != "" && name != "" {
+			namespaces[ns] = true
+			add(namespaced(ns, "persistentvolumeclaims.yaml"), persistentVolumeClaimObject(r.row))
+		}
+	}
+	for ns := range namespaces {
+		if ns != "" {
+			add(filepath.Join(clusterScopedDir, "namespaces.yaml"), map[string]any{"apiVersion": "v1", "kind": "Namespace", "metadata": map[string]any{"name": ns}})
+		}
+	}
+
+	paths := make([]string, 0, len(lists))
+	for p := range lists {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	for _, p := range paths {
+		items := lists[p]
+		sort.Slice(items, func(i, j int) bool {
+			return toStr(items[i]["metadata"].(map[string]any)["name"]) < toStr(items[j]["metadata"].(map[string]any)["name"])
+		})
+		var buf bytes.Buffer
+		buf.WriteString(snapshotHeader)
+		enc := yaml.NewEncoder(&buf)
+		enc.SetIndent(2)
+		if err := enc.Encode(map[string]any{"apiVersion": "v1", "kind": "List", "metadata": map[string]any{}, "items": items}); err != nil {
+			return err
+		}
+		if err := utils.WriteFileToTar(tarw, p, buf.Bytes()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *resourceSnapshots) warnings(table string) []string {
+	return nil
+}
+
+// current reports whether an object last reported at tm was still reported
+// at the end of the table's inventory.
+func (s *resourceSnapshots) current(table, tm string) bool {
+	end, t := utils.ParseTimeRFC3339(s.last[table]), utils.ParseTimeRFC3339(tm)
+	if end.IsZero() || t.IsZero() {
+		return true
+	}
+	return end.Sub(t) <= snapshotMaxAge
+}
+
+// serviceObject builds a v1 Service from a KubeServices row.
+func serviceObject(row map[string]any) map[string]any {
+	spec := map[string]any{}
+	setIf(spec, "type", toStr(row["ServiceType"]))
+	setIf(spec, "clusterIP", toStr(row["ClusterIp"]))
+	if selector := podLabels(row["SelectorLabels"]); len(selector) > 0 {
+		spec["selector"] = selector
+	}
+	return map[string]any{
+		"apiVersion": "v1",
+		"kind":       "Service",
+		"metadata":   map[string]any{"name": toStr(row["ServiceName"]), "namespace": toStr(row["Namespace"])},
+		"spec":       spec,
+	}
+}
+
+// persistentVolumeObject builds a v1 PersistentVolume from a KubePVInventory
+// row.
+func persistentVolumeObject(row map[string]any) map[string]any {
+	meta := map[string]any{"name": toStr(row["PVName"])}
+	setIf(meta, "creationTimestamp", toStr(row["PVCreationTimeStamp"]))
+	spec := volumeSpec(row)
+	if size := storageQuantity(row["PVCapacityBytes"]); size != "" {
+		spec["capacity"] = map[string]any{"storage": size}
+	}
+	if ns, name := toStr(row["PVCNamespace"]), toStr(row["PVCName"]); name != "" {
+		spec["claimRef"] = map[string]any{"kind": "PersistentVolumeClaim", "namespace": ns, "name": name}
+	}
+	status := map[string]any{}
+	setIf(status, "phase", toStr(row["PVStatus"]))
+	return map[string]any{
+		"apiVersion": "v1",
+		"kind":       "PersistentVolume",
+		"metadata":   meta,
+		"spec":       spec,
+		"status":     status,
+	}
+}
+
+// persistentVolumeClaimObject builds the v1 PersistentVolumeClaim bound to
+// the volume of a KubePVInventory row.
+func persistentVolumeClaimObject(row map[string]any) map[string]any {
+	spec := volumeSpec(row)
+	setIf(spec, "volumeName", toStr(row["PVName"]))
+	status := map[string]any{}
+	if size := storageQuantity(row["PVCapacityBytes"]); size != "" {
+		spec["resources"] = map[string]any{"requests": map[string]any{"storage": size}}
+		status["capacity"] = map[string]any{"storage": size}
+	}
+	if strings.EqualFold(toStr(row["PVStatus"]), "Bound") {
+		status["phase"] = "Bound"
+	}
+	return map[string]any{
+		"apiVersion": "v1",
+		"kind":       "PersistentVolumeClaim",
+		"metadata":   map[string]any{"name": toStr(row["PVCName"]), "namespace": toStr(row["PVCNamespace"])},
+		"spec":       spec,
+		"status":     status,
+	}
+}
+
+// volumeSpec holds the spec fields volumes and claims share.
+func volumeSpec(row map[string]any) map[string]any {
+	spec := map[string]any{}
+	setIf(spec, "storageClassName", toStr(row["PVStorageClassName"]))
+	var modes []any
+	for _, m := range strings.Split(toStr(row["PVAccessModes"]), ",") {
+		if m = strings.TrimSpace(m); m != "" {
+			modes = append(modes, m)
+		}
+	}
+	if len(modes) > 0 {
+		spec["accessModes"] = modes
+	}
+	return spec
+}
+
+// storageQuantity formats a byte count as a Kubernetes quantity, in the
+// largest binary unit that divides it.
+func storageQuantity(v any) string {
+	n, err := strconv.ParseFloat(toStr(v), 64)
+	if err != nil || n <= 0 {
+		return ""
+	}
+	b := int64(n)
+	for _, u := range []struct {
+		suffix string
+		size   int64
+	}{{"Ti", 1 << 40}, {"Gi", 1 << 30}, {"Mi", 1 << 20}, {"Ki", 1 << 10}} {
+		if b%u.size == 0 {
+			return strconv.FormatInt(b/u.size, 10) + u.suffix
+		}
+	}
+	return strconv.FormatInt(b, 10)
+}
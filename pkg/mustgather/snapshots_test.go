@@ -0,0 +1,137 @@
+package mustgather
+
+import (
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestResourceSnapshots(t *testing.T) {
+	pods := newPodManifests()
+	s := newResourceSnapshots(pods)
+	observe := func(table string, row map[string]any) {
+		pods.observe(table, row)
+		s.observe(table, row)
+	}
+	pod := func(tm, name string) map[string]any {
+		return map[string]any{"TimeGenerated": tm, "Namespace": "shop", "Name": name, "PodStatus": "Running", "ContainerName": "uid/" + name}
+	}
+	observe("KubePodInventory", pod("2024-01-01T00:00:00Z", "old-1"))
+	observe("KubePodInventory", pod("2024-01-01T00:09:00Z", "web-1"))
+	observe("KubePodInventory", pod("2024-01-01T00:10:00Z", "cart-1"))
+	observe("KubeServices", map[string]any{
+		"TimeGenerated": "2024-01-01T00:10:00Z", "Namespace": "shop", "ServiceName": "cart", "ServiceType": "ClusterIP",
+		"ClusterIp": "10.0.0.10", "SelectorLabels": `[{"app":"cart"}]`,
+	})
+	observe("KubeServices", map[string]any{"TimeGenerated": "2024-01-01T00:10:00Z", "Namespace": "shop", "ServiceName": ""})
+	observe("KubePVInventory", map[string]any{
+		"TimeGenerated": "2024-01-01T00:10:00Z", "PVName": "pvc-123", "PVCapacityBytes": float64(10 << 30), "PVAccessModes": "ReadWriteOnce",
+		"PVStorageClassName": "managed-csi", "PVStatus": "Bound", "PVCName": "data-db-0", "PVCNamespace": "db",
+	})
+
+	files := readTransform(t, s)
+	var names []string
+	for name := range files {
+		names = append(names, name)
+	}
+	want := []string{
+		"cluster-scoped/namespaces.yaml", "cluster-scoped/persistentvolumes.yaml", "namespaces/db/core/persistentvolumeclaims.yaml",
+		"namespaces/shop/core/pods.yaml", "namespaces/shop/core/services.yaml",
+	}
+	if len(files) != len(want) {
+		t.Fatalf("expected %v, got %v", want, names)
+	}
+
+	tests := []struct {
+		path  string
+		items []string
+		check func(t *testing.T, items []map[string]any)
+	}{
+		{
+			path: "namespaces/shop/core/pods.yaml",
+			// old-1 was gone before the end of the window
+			items: []string{"cart-1", "web-1"},
+		},
+		{
+			path:  "namespaces/shop/core/services.yaml",
+			items: []string{"cart"},
+			check: func(t *testing.T, items []map[string]any) {
+				spec := items[0]["spec"].(map[string]any)
+				if spec["clusterIP"] != "10.0.0.10" || spec["selector"].(map[string]any)["app"] != "cart" {
+					t.Errorf("unexpected service spec %v", spec)
+				}
+			},
+		},
+		{
+			path:  "cluster-scoped/persistentvolumes.yaml",
+			items: []string{"pvc-123"},
+			check: func(t *testing.T, items []map[string]any) {
+				spec := items[0]["spec"].(map[string]any)
+				if spec["capacity"].(map[string]any)["storage"] != "10Gi" || spec["claimRef"].(map[string]any)["name"] != "data-db-0" || spec["accessModes"].([]any)[0] != "ReadWriteOnce" {
+					t.Errorf("unexpected volume spec %v", spec)
+				}
+			},
+		},
+		{
+			path:  "namespaces/db/core/persistentvolumeclaims.yaml",
+			items: []string{"data-db-0"},
+			check: func(t *testing.T, items []map[string]any) {
+				if spec := items[0]["spec"].(map[string]any); spec["volumeName"] != "pvc-123" || spec["storageClassName"] != "managed-csi" {
+					t.Errorf("unexpected claim spec %v", spec)
+				}
+				if status := items[0]["status"].(map[string]any); status["phase"] != "Bound" {
+					t.Errorf("unexpected claim status %v", status)
+				}
+			},
+		},
+		{
+			path:  "cluster-scoped/namespaces.yaml",
+			items: []string{"db", "shop"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			raw := files[tt.path]
+			if !strings.HasPrefix(raw, "# Reconstructed") {
+				t.Error("expected reconstruction header")
+			}
+			var list struct {
+				Kind  string
+				Items []map[string]any
+			}
+			if err := yaml.Unmarshal([]byte(raw), &list); err != nil {
+				t.Fatalf("invalid YAML: %v\n%s", err, raw)
+			}
+			var got []string
+			for _, item := range list.Items {
+				got = append(got, toStr(item["metadata"].(map[string]any)["name"]))
+			}
+			if list.Kind != "List" || strings.Join(got, ",") != strings.Join(tt.items, ",") {
+				t.Fatalf("got %s of %v, want %v", list.Kind, got, tt.items)
+			}
+			if tt.check != nil {
+				tt.check(t, list.Items)
+			}
+		})
+	}
+}
+
+func TestStorageQuantity(t *testing.T) {
+	tests := []struct {
+		in   any
+		want string
+	}{
+		{float64(10 << 30), "10Gi"},
+		{"1572864", "1536Ki"},
+		{float64(1 << 40), "1Ti"},
+		{float64(1000), "1000"},
+		{"", ""},
+		{float64(0), ""},
+	}
+	for _, tt := range tests {
+		if got := storageQuantity(tt.in); got != tt.want {
+			t.Errorf("storageQuantity(%v) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
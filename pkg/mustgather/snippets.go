@@ -0,0 +1,181 @@
+package mustgather
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	azquery "github.com/Azure/azure-sdk-for-go/sdk/monitor/azquery"
+
+	"kubectl-must-gather/pkg/utils"
+)
+
+// Snippet is a named, curated KQL query whose result is saved in every bundle.
+type Snippet struct {
+	Name        string
+	Description string
+	Query       string
+}
+
+type SnippetMap map[string]Snippet
+
+func GetDefaultSnippets() SnippetMap {
+	snippets := []Snippet{
+		{
+			Name:        "restart-counts",
+			Description: "Containers that restarted during the window, highest restart count first",
+			Query: `KubePodInventory
+| where ContainerRestartCount > 0
+| summarize Restarts=max(ContainerRestartCount), LastSeen=max(TimeGenerated) by Namespace, Name, ContainerName, ContainerStatusReason
+| order by Restarts desc`,
+		},
+		{
+			Name:        "error-rates",
+			Description: "Error/critical log lines per container and their share of all lines",
+			Query: `ContainerLogV2
+| summarize Total=count(), Errors=countif(LogLevel in~ ("error", "critical")) by PodNamespace, PodName, ContainerName
+| where Errors > 0
+| extend ErrorPercent=round(100.0 * Errors / Total, 2)
+| order by Errors desc`,
+		},
+		{
+			Name:        "top-cpu-pods",
+			Description: "Top 20 containers by average CPU usage (millicores)",
+			Query: `Perf
+| where ObjectName == "K8SContainer" and CounterName == "cpuUsageNanoCores"
+| extend PodUid=tostring(split(InstanceName, "/")[-2]), ContainerName=tostring(split(InstanceName, "/")[-1])
+| summarize AvgMilliCores=round(avg(CounterValue) / 1000000, 1), MaxMilliCores=round(max(CounterValue) / 1000000, 1) by PodUid, ContainerName
+| join kind=leftouter (KubePodInventory | distinct PodUid, Namespace, Name) on PodUid
+| project Namespace, Name, ContainerName, AvgMilliCores, MaxMilliCores
+| top 20 by AvgMilliCores`,
+		},
+		{
+			Name:        "top-memory-pods",
+			Description: "Top 20 containers by peak working set memory (MiB)",
+			Query: `Perf
+| where ObjectName == "K8SContainer" and CounterName == "memoryWorkingSetBytes"
+| extend PodUid=tostring(split(InstanceName, "/")[-2]), ContainerName=tostring(split(InstanceName, "/")[-1])
+| summarize AvgMiB=round(avg(CounterValue) / 1048576, 1), MaxMiB=round(max(CounterValue) / 1048576, 1) by PodUid, ContainerName
+| join kind=leftouter (KubePodInventory | distinct PodUid, Namespace, Name) on PodUid
+| project Namespace, Name, ContainerName, AvgMiB, MaxMiB
+| top 20 by MaxMiB`,
+		},
+		{
+			Name:        "warning-events",
+			Description: "Warning events grouped by namespace and reason",
+			Query: `KubeEvents
+| where KubeEventType == "Warning"
+| summarize Events=count(), Objects=dcount(Name), FirstSeen=min(TimeGenerated), LastSeen=max(TimeGenerated) by Namespace, Reason
+| order by Events desc`,
+		},
+		{
+			Name:        "node-not-ready",
+			Description: "Nodes that reported a status other than Ready",
+			Query: `KubeNodeInventory
+| where Status !contains "Ready" or Status contains "NotReady"
+| summarize Reports=count(), FirstSeen=min(TimeGenerated), LastSeen=max(TimeGenerated) by Computer, Status`,
+		},
+	}
+
+	m := SnippetMap{}
+	for _, s := range snippets {
+		m[s.Name] = s
+	}
+	return m
+}
+
+// resolveSnippets turns the --snippets value into snippets to run. "all"
+// selects the whole library; unknown names are skipped and returned.
+func resolveSnippets(csv string) (out []Snippet, unknown []string) {
+	lib := GetDefaultSnippets()
+	var names []string
+	for _, p := range strings.Split(csv, ",") {
+		p = strings.TrimSpace(p)
+		switch p {
+		case "":
+		case "all":
+			for n := range lib {
+				names = append(names, n)
+			}
+		default:
+			if _, ok := lib[p]; !ok {
+				unknown = append(unknown, p)
+				continue
+			}
+			names = append(names, p)
+		}
+	}
+	sort.Strings(names)
+	seen := map[string]bool{}
+	for _, n := range names {
+		if !seen[n] {
+			seen[n] = true
+			out = append(out, lib[n])
+		}
+	}
+	return out, unknown
+}
+
+// runSnippets executes the selected snippets over the whole gather window and
+// saves each result as queries/snippets/<name>.json.
+func (g *Gatherer) runSnippets(tarw *utils.TarWriter, lcli LogsQueryClient, workspaceGUID string) {
+	snippets, unknown := resolveSnippets(g.config.Snippets)
+	for _, name := range unknown {
+		g.warnf("", "unknown snippet %q", name)
+	}
+	if g.scope != nil && len(snippets) > 0 {
+		// Snippets are free-form KQL that cannot be limited to namespaces
+		g.warnf("", "skipping %d snippets in a scoped gather", len(snippets))
+		return
+	}
+	for _, sn := range snippets {
+		if g.interrupted() {
+			return
+		}
+		fmt.Fprintf(g.log, "Running snippet %s...\n", sn.Name)
+		res, err := g.query(g.ctx, lcli, workspaceGUID, sn.Query, g.start, g.end)
+		if err != nil {
+			g.warnf("", "snippet %s failed: %v", sn.Name, err)
+			continue
+		}
+		out := map[string]any{
+			"name":        sn.Name,
+			"description": sn.Description,
+			"query":       sn.Query,
+			"rows":        []map[string]any{},
+		}
+		if res.Error != nil {
+			out["error"] = res.Error.Error()
+		}
+		if len(res.Tables) > 0 {
+			rows := tableRows(res.Tables[0])
+			for _, row := range rows {
+				// Snippets may read audit tables under any name
+				auditScrubber.redactRow("", row, nil)
+				g.config.Anonymizer.anonymizeRow("", row)
+				// Counted under the snippet; only rules for all tables apply
+				g.config.Redaction.redactRow("snippets/"+sn.Name, row, g.redactions)
+			}
+			out["rows"] = rows
+		}
+		b, _ := json.MarshalIndent(out, "", "  ")
+		_ = utils.WriteFileToTar(tarw, path.Join("queries", "snippets", sn.Name+".json"), b)
+	}
+}
+
+// tableRows converts a query result table into one map per row.
+func tableRows(tab *azquery.Table) []map[string]any {
+	rows := make([]map[string]any, 0, len(tab.Rows))
+	for _, row := range tab.Rows {
+		obj := map[string]any{}
+		for i, v := range row {
+			if i < len(tab.Columns) && tab.Columns[i].Name != nil {
+				obj[*tab.Columns[i].Name] = v
+			}
+		}
+		rows = append(rows, obj)
+	}
+	return rows
+}
@@ -0,0 +1,299 @@
+package mustgather
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"kubectl-must-gather/pkg/bundle"
+	"kubectl-must-gather/pkg/utils"
+)
+
+// statsStaleGap is how far before the end of its window a table's last row
+// may be before stats points it out.
+const statsStaleGap = 15 * time.Minute
+
+// BundleStats describes what a bundle holds: its size per top-level
+// directory, and rows, bytes and time coverage per table and namespace.
+type BundleStats struct {
+	Bundle string `json:"bundle"`
+	// ArchiveBytes is the compressed size when the bundle is an archive.
+	ArchiveBytes int64 `json:"archiveBytes,omitempty"`
+	Files        int   `json:"files"`
+	Bytes        int64 `json:"bytes"`
+	// WindowStart and WindowEnd span the windows the tables were queried
+	// over.
+	WindowStart string           `json:"windowStart,omitempty"`
+	WindowEnd   string           `json:"windowEnd,omitempty"`
+	Incomplete  bool             `json:"incomplete,omitempty"`
+	Dirs        []DirStats       `json:"dirs"`
+	Tables      []TableStats     `json:"tables"`
+	Namespaces  []NamespaceStats `json:"namespaces"`
+}
+
+// DirStats is the content of a top-level directory of a bundle, or of its
+// top-level files under ".".
+type DirStats struct {
+	Dir   string `json:"dir"`
+	Files int    `json:"files"`
+	Bytes int64  `json:"bytes"`
+}
+
+// TableStats is what a bundle holds of a table. WindowStart and WindowEnd
+// are the window queried, First and Last the times of the oldest and newest
+// rows.
+type TableStats struct {
+	Table       string   `json:"table"`
+	Rows        int64    `json:"rows"`
+	Bytes       int64    `json:"bytes"`
+	WindowStart string   `json:"windowStart,omitempty"`
+	WindowEnd   string   `json:"windowEnd,omitempty"`
+	First       string   `json:"first,omitempty"`
+	Last        string   `json:"last,omitempty"`
+	Notes       []string `json:"notes,omitempty"`
+}
+
+// NamespaceStats is what the tables with a namespace column hold of a
+// namespace.
+type NamespaceStats struct {
+	Namespace string           `json:"namespace"`
+	Rows      int64            `json:"rows"`
+	Bytes     int64            `json:"bytes"`
+	First     string           `json:"first,omitempty"`
+	Last      string           `json:"last,omitempty"`
+	Tables    map[string]int64 `json:"tables"`
+}
+
+// Stats reads the bundle at src (archive or extracted directory) and counts
+// its contents, largest tables and namespaces first.
+func Stats(src string) (*BundleStats, error) {
+	b, err := bundle.Open(src)
+	if err != nil {
+		return nil, fmt.Errorf("open bundle: %w", err)
+	}
+	defer b.Close()
+	s := &BundleStats{Bundle: src, Incomplete: b.Incomplete(), Dirs: []DirStats{}, Tables: []TableStats{}, Namespaces: []NamespaceStats{}}
+	if fi, err := os.Stat(src); err == nil && !fi.IsDir() {
+		s.ArchiveBytes = fi.Size()
+	}
+
+	files, err := b.Files()
+	if err != nil {
+		return nil, fmt.Errorf("list bundle files: %w", err)
+	}
+	dirs := map[string]*DirStats{}
+	var dirOrder []string
+	for _, f := range files {
+		fi, err := os.Stat(filepath.Join(b.Dir, filepath.FromSlash(f)))
+		if err != nil {
+			return nil, err
+		}
+		dir, _, nested := strings.Cut(f, "/")
+		if !nested {
+			dir = "."
+		}
+		d, ok := dirs[dir]
+		if !ok {
+			d = &DirStats{Dir: dir}
+			dirs[dir] = d
+			dirOrder = append(dirOrder, dir)
+		}
+		d.Files++
+		d.Bytes += fi.Size()
+		s.Files++
+		s.Bytes += fi.Size()
+	}
+	for _, dir := range dirOrder {
+		s.Dirs = append(s.Dirs, *dirs[dir])
+	}
+	sort.SliceStable(s.Dirs, func(i, j int) bool { return s.Dirs[i].Bytes > s.Dirs[j].Bytes })
+
+	tables, err := b.Tables()
+	if err != nil {
+		return nil, fmt.Errorf("list tables: %w", err)
+	}
+	namespaces := map[string]*NamespaceStats{}
+	var windowStart, windowEnd time.Time
+	for _, t := range tables {
+		ts, err := tableStats(b, t, namespaces)
+		if err != nil {
+			return nil, err
+		}
+		if start := utils.ParseTimeRFC3339(ts.WindowStart); !start.IsZero() && (windowStart.IsZero() || start.Before(windowStart)) {
+			windowStart = start
+		}
+		if end := utils.ParseTimeRFC3339(ts.WindowEnd); end.After(windowEnd) {
+			windowEnd = end
+		}
+		s.Tables = append(s.Tables, ts)
+	}
+	if !windowStart.IsZero() {
+		s.WindowStart, s.WindowEnd = windowStart.UTC().Format(time.RFC3339), windowEnd.UTC().Format(time.RFC3339)
+	}
+	sort.SliceStable(s.Tables, func(i, j int) bool { return s.Tables[i].Bytes > s.Tables[j].Bytes })
+	for _, ns := range namespaces {
+		s.Namespaces = append(s.Namespaces, *ns)
+	}
+	sort.Slice(s.Namespaces, func(i, j int) bool {
+		if s.Namespaces[i].Bytes != s.Namespaces[j].Bytes {
+			return s.Namespaces[i].Bytes > s.Namespaces[j].Bytes
+		}
+		return s.Namespaces[i].Namespace < s.Namespaces[j].Namespace
+	})
+	return s, nil
+}
+
+// tableStats counts the rows of table t, adding those with a namespace to
+// namespaces.
+func tableStats(b *bundle.Bundle, t bundle.Table, namespaces map[string]*NamespaceStats) (TableStats, error) {
+	ts := TableStats{Table: t.Name}
+	var sum struct {
+		Coverage   tableCoverage `json:"coverage"`
+		Incomplete bool          `json:"incomplete"`
+	}
+	if data, err := b.ReadFile(t.Dir + "/summary.json"); err == nil {
+		_ = json.Unmarshal(data, &sum)
+	}
+	ts.WindowStart, ts.WindowEnd = sum.Coverage.Start, sum.Coverage.End
+
+	column, namespaced := namespaceColumn(t.Name)
+	var first, last time.Time
+	for _, part := range t.Parts {
+		f, err := os.Open(filepath.Join(b.Dir, filepath.FromSlash(part)))
+		if err != nil {
+			return ts, err
+		}
+		r := bufio.NewReader(f)
+		for {
+			line, err := r.ReadBytes('\n')
+			if len(line) > 0 {
+				ts.Rows++
+				ts.Bytes += int64(len(line))
+				var row map[string]any
+				if jerr := json.Unmarshal(line, &row); jerr != nil {
+					f.Close()
+					return ts, fmt.Errorf("decode %s: %w", part, jerr)
+				}
+				tm := utils.ParseTimeRFC3339(toStr(row["TimeGenerated"]))
+				if !tm.IsZero() {
+					if first.IsZero() || tm.Before(first) {
+						first = tm
+					}
+					if tm.After(last) {
+						last = tm
+					}
+				}
+				if namespaced {
+					if name := rowNamespace(row, column); name != "" {
+						ns, ok := namespaces[name]
+						if !ok {
+							ns = &NamespaceStats{Namespace: name, Tables: map[string]int64{}}
+							namespaces[name] = ns
+						}
+						ns.Rows++
+						ns.Bytes += int64(len(line))
+						ns.Tables[t.Name]++
+						if !tm.IsZero() {
+							if ns.First == "" || tm.Before(utils.ParseTimeRFC3339(ns.First)) {
+								ns.First = tm.UTC().Format(time.RFC3339)
+							}
+							if tm.After(utils.ParseTimeRFC3339(ns.Last)) {
+								ns.Last = tm.UTC().Format(time.RFC3339)
+							}
+						}
+					}
+				}
+			}
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				f.Close()
+				return ts, err
+			}
+		}
+		f.Close()
+	}
+	if !first.IsZero() {
+		ts.First, ts.Last = first.UTC().Format(time.RFC3339), last.UTC().Format(time.RFC3339)
+	}
+
+	switch {
+	case ts.Rows == 0:
+		ts.Notes = append(ts.Notes, "no rows")
+	case !last.IsZero():
+		if end := utils.ParseTimeRFC3339(ts.WindowEnd); !end.IsZero() && end.Sub(last) > statsStaleGap {
+			ts.Notes = append(ts.Notes, "last row "+end.Sub(last).Round(time.Minute).String()+" before the window end")
+		}
+		if start := utils.ParseTimeRFC3339(ts.WindowStart); !start.IsZero() && first.Sub(start) > statsStaleGap {
+			ts.Notes = append(ts.Notes, "first row "+first.Sub(start).Round(time.Minute).String()+" after the window start")
+		}
+	}
+	if sum.Incomplete {
+		ts.Notes = append(ts.Notes, "incomplete")
+	}
+	if sum.Coverage.Clamped || sum.Coverage.RequestedStart != "" {
+		ts.Notes = append(ts.Notes, "window cut to the retention")
+	}
+	return ts, nil
+}
+
+// rowNamespace returns the namespace of a row from column, a column name or
+// a tostring(<column>.<field>) expression of namespaceColumns.
+func rowNamespace(row map[string]any, column string) string {
+	inner, ok := strings.CutPrefix(column, "tostring(")
+	if !ok {
+		return toStr(row[column])
+	}
+	col, field, _ := strings.Cut(strings.TrimSuffix(inner, ")"), ".")
+	if m, ok := dynamicValue(row[col]).(map[string]any); ok {
+		return toStr(m[field])
+	}
+	return ""
+}
+
+// Render writes s as aligned text tables.
+func (s *BundleStats) Render(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "Bundle: %s\n", s.Bundle)
+	size := utils.FormatByteSize(s.Bytes)
+	if s.ArchiveBytes > 0 {
+		size += " (" + utils.FormatByteSize(s.ArchiveBytes) + " compressed)"
+	}
+	fmt.Fprintf(tw, "Files: %d, %s\n", s.Files, size)
+	if s.WindowStart != "" {
+		fmt.Fprintf(tw, "Window: %s to %s\n", s.WindowStart, s.WindowEnd)
+	}
+	if s.Incomplete {
+		fmt.Fprintln(tw, "Incomplete: the gather was interrupted")
+	}
+
+	fmt.Fprintln(tw, "\nDIR\tFILES\tSIZE")
+	for _, d := range s.Dirs {
+		fmt.Fprintf(tw, "%s\t%d\t%s\n", d.Dir, d.Files, utils.FormatByteSize(d.Bytes))
+	}
+	if len(s.Tables) > 0 {
+		fmt.Fprintln(tw, "\nTABLE\tROWS\tSIZE\tFIRST ROW\tLAST ROW\tNOTES")
+		for _, t := range s.Tables {
+			fmt.Fprintf(tw, "%s\t%d\t%s\t%s\t%s\t%s\n", t.Table, t.Rows, utils.FormatByteSize(t.Bytes), orNone(t.First), orNone(t.Last), strings.Join(t.Notes, "; "))
+		}
+	}
+	if len(s.Namespaces) > 0 {
+		fmt.Fprintln(tw, "\nNAMESPACE\tROWS\tSIZE\tFIRST ROW\tLAST ROW\tTABLES")
+		for _, ns := range s.Namespaces {
+			var tables []string
+			for _, t := range sortedKeys(ns.Tables) {
+				tables = append(tables, fmt.Sprintf("%s %d", t, ns.Tables[t]))
+			}
+			fmt.Fprintf(tw, "%s\t%d\t%s\t%s\t%s\t%s\n", ns.Namespace, ns.Rows, utils.FormatByteSize(ns.Bytes), orNone(ns.First), orNone(ns.Last), strings.Join(tables, ", "))
+		}
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,57 @@
+package mustgather
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestStats(t *testing.T) {
+	src := writeBundleDir(t, map[string]string{
+		"index.json":                         `{"tables":["ContainerLogV2","AKSAudit","Heartbeat"]}`,
+		"tables/ContainerLogV2/summary.json": `{"table":"ContainerLogV2","rows":3,"coverage":{"start":"2024-01-01T00:00:00Z","end":"2024-01-01T02:00:00Z"}}`,
+		"tables/ContainerLogV2/parts/0000-a.ndjson": strings.Join([]string{
+			`{"TimeGenerated":"2024-01-01T00:10:00Z","PodNamespace":"shop","LogMessage":"a"}`,
+			`{"TimeGenerated":"2024-01-01T01:00:00Z","PodNamespace":"shop","LogMessage":"b"}`,
+			`{"TimeGenerated":"2024-01-01T00:30:00Z","PodNamespace":"web","LogMessage":"c"}`,
+		}, "\n") + "\n",
+		"tables/AKSAudit/summary.json":         `{"table":"AKSAudit","rows":1,"coverage":{"start":"2024-01-01T00:00:00Z","end":"2024-01-01T02:00:00Z"},"incomplete":true}`,
+		"tables/AKSAudit/parts/0000-a.ndjson":  `{"TimeGenerated":"2024-01-01T01:55:00Z","ObjectRef":{"namespace":"shop","resource":"pods"}}` + "\n",
+		"tables/Heartbeat/summary.json":        `{"table":"Heartbeat","rows":0,"coverage":{"start":"2024-01-01T00:00:00Z","end":"2024-01-01T02:00:00Z"}}`,
+		"namespaces/shop/pods/cart-1/cart.log": "2024-01-01T00:10:00Z [stdout] a\n",
+	})
+	s, err := Stats(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.WindowStart != "2024-01-01T00:00:00Z" || s.WindowEnd != "2024-01-01T02:00:00Z" {
+		t.Errorf("window = %s to %s", s.WindowStart, s.WindowEnd)
+	}
+	byTable := map[string]TableStats{}
+	for _, ts := range s.Tables {
+		byTable[ts.Table] = ts
+	}
+	logs := byTable["ContainerLogV2"]
+	if logs.Rows != 3 || logs.First != "2024-01-01T00:10:00Z" || logs.Last != "2024-01-01T01:00:00Z" || strings.Join(logs.Notes, ";") != "last row 1h0m0s before the window end" {
+		t.Errorf("ContainerLogV2 = %+v", logs)
+	}
+	if got := strings.Join(byTable["AKSAudit"].Notes, ";"); got != "first row 1h55m0s after the window start;incomplete" {
+		t.Errorf("AKSAudit notes = %s", got)
+	}
+	if got := strings.Join(byTable["Heartbeat"].Notes, ";"); got != "no rows" {
+		t.Errorf("Heartbeat notes = %s", got)
+	}
+	if len(s.Namespaces) != 2 || s.Namespaces[0].Namespace != "shop" || s.Namespaces[0].Rows != 3 || s.Namespaces[0].Tables["AKSAudit"] != 1 || s.Namespaces[0].Last != "2024-01-01T01:55:00Z" {
+		t.Errorf("namespaces = %+v", s.Namespaces)
+	}
+
+	var out bytes.Buffer
+	if err := s.Render(&out); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"Window: 2024-01-01T00:00:00Z to 2024-01-01T02:00:00Z", "namespaces  1", "AKSAudit 1, ContainerLogV2 2"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("stats lack %q:\n%s", want, out.String())
+		}
+	}
+}
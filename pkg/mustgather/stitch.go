@@ -0,0 +1,686 @@
+package mustgather
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"kubectl-must-gather/pkg/utils"
+)
+
+// stitcher turns exported table rows into time-ordered text logs:
+//   - ContainerLogV2 -> namespaces/<ns>/pods/<pod>/<container>.log, or
+//     containers/<container-id>.log when the pod columns are missing. Like
+//     kubelet, earlier instances of a restarted container (distinct
+//     ContainerId) go to <container>.previous.log, <container>.previous-2.log
+//     and so on.
+//   - KubeEvents     -> namespaces/<ns>/events/events.log, plus
+//     namespaces/<ns>/pods/<pod>/events.log for events involving a pod
+//   - AKSControlPlane -> controlplane/<component>/<component>.log
+//   - Syslog         -> nodes/<node>/syslog.log
+//
+// Rows are buffered per query chunk and sorted by TimeGenerated when the chunk
+// ends. Chunks are exported oldest first, so every file stays ordered across
+// the whole timespan. Sorted chunks are appended to spill files in a temporary
+// directory, so memory use is bounded by the largest chunk rather than the
+// whole timespan; finish copies the spill files into the archive. In
+// low-memory mode the chunk buffer itself is bounded: every spillRunLines
+// lines are sorted into run files, which endChunk merges.
+//
+// Other transforms can ask for the position of a row's line in its stitched
+// file with anchorNext before the stitcher observes the row; see stitchAnchor.
+type stitcher struct {
+	config *Config
+	// loc is the --timezone lines are written in
+	loc      *time.Location
+	memory   *memoryGovernor
+	pending  []stitchLine
+	spillDir string
+	// runs lists the sorted run files of the current chunk per stitched file,
+	// and runStart the earliest time of each file across its runs.
+	runs     map[stitchKey][]string
+	runStart map[stitchKey]string
+	nextRun  int
+	// files maps stitched files to their spill file names, and lines counts
+	// the lines written to each.
+	files map[stitchKey]string
+	lines map[stitchKey]int
+	// next is the anchor for the last line of the row observed next, and
+	// runAnchors the anchors of run file lines by line number.
+	next       *stitchAnchor
+	runAnchors map[string]map[int]*stitchAnchor
+	// instances lists the container IDs seen for each path, oldest first.
+	instances map[string][]string
+	// containerCols is resolved from the first ContainerLogV2 row.
+	containerCols *containerLogColumns
+	warns         map[string][]string
+	// report reports a warning as it is recorded; nil prints it on the
+	// log output of config.
+	report func(table, msg string)
+}
+
+type stitchLine struct {
+	path string
+	// instance is the container ID for container logs, empty otherwise.
+	instance string
+	tm       string
+	line     string
+	anchor   *stitchAnchor
+}
+
+type stitchKey struct {
+	path, instance string
+}
+
+// stitchAnchor is the position of a stitched line: its file and 1-based line
+// number. It is set when the line's chunk ends; file resolves the final name
+// of the file.
+type stitchAnchor struct {
+	key  stitchKey
+	line int
+}
+
+// spillRunLines is how many buffered lines are sorted into a run file in
+// low-memory mode. Variable so tests can lower it.
+var spillRunLines = 10000
+
+func newStitcher(config *Config, memory *memoryGovernor) *stitcher {
+	return &stitcher{
+		config:     config,
+		loc:        config.location(),
+		memory:     memory,
+		files:      map[stitchKey]string{},
+		lines:      map[stitchKey]int{},
+		runAnchors: map[string]map[int]*stitchAnchor{},
+		instances:  map[string][]string{},
+		runs:       map[stitchKey][]string{},
+		runStart:   map[stitchKey]string{},
+		warns:      map[string][]string{},
+	}
+}
+
+// anchorNext returns an anchor for the last line stitched from the next row
+// observed, or nil when logs are not stitched. The anchor stays unset when
+// the row is not stitched.
+func (s *stitcher) anchorNext() *stitchAnchor {
+	if !s.config.StitchLogs {
+		return nil
+	}
+	s.next = &stitchAnchor{}
+	return s.next
+}
+
+// file returns the archive path of the anchored line's file, or "" when the
+// line was not stitched. It must be called before finish.
+func (s *stitcher) file(a *stitchAnchor) string {
+	if a == nil || a.line == 0 {
+		return ""
+	}
+	ids := s.instances[a.key.path]
+	for i, id := range ids {
+		if id == a.key.instance {
+			return previousLogPath(a.key.path, len(ids)-1-i)
+		}
+	}
+	return ""
+}
+
+// requireColumns reports whether row has all the named columns, recording a
+// warning for the table the first time some are missing.
+func (s *stitcher) requireColumns(table string, row map[string]any, names ...string) bool {
+	var missing []string
+	for _, n := range names {
+		if _, ok := row[n]; !ok {
+			missing = append(missing, n)
+		}
+	}
+	if len(missing) == 0 {
+		return true
+	}
+	s.warn(table, fmt.Sprintf("columns %s missing; logs not stitched, rows are exported as NDJSON only", strings.Join(missing, ", ")))
+	return false
+}
+
+// warn records a stitching problem for a table once and reports it.
+func (s *stitcher) warn(table, msg string) {
+	for _, w := range s.warns[table] {
+		if w == msg {
+			return
+		}
+	}
+	s.warns[table] = append(s.warns[table], msg)
+	if s.report != nil {
+		s.report(table, msg)
+		return
+	}
+	fmt.Fprintf(s.config.logOutput(), "  warn: %s: %s\n", table, msg)
+}
+
+func (s *stitcher) warnings(table string) []string {
+	return s.warns[table]
+}
+
+// observe buffers the stitched representation of a single row, if any.
+func (s *stitcher) observe(table string, row map[string]any) {
+	if !s.config.StitchLogs {
+		return
+	}
+	n := len(s.pending)
+	switch table {
+	case "ContainerLogV2":
+		s.observeContainerLog(row)
+	case "KubeEvents":
+		if s.config.StitchIncludeEvents {
+			s.observeEvent(row)
+		}
+	case "AKSControlPlane":
+		s.observeControlPlane(row)
+	case "Syslog":
+		s.observeSyslog(row)
+	}
+	if s.next != nil && len(s.pending) > n {
+		s.pending[len(s.pending)-1].anchor = s.next
+	}
+	s.next = nil
+	if len(s.pending) >= spillRunLines && s.memory.lowMemory() {
+		if err := s.spillRun(); err != nil {
+			// Keep buffering in memory rather than losing lines
+			s.warn(table, fmt.Sprintf("spilling stitched lines failed: %v", err))
+		}
+	}
+}
+
+// containerLogColumns records which columns of ContainerLogV2 feed the stitched
+// container logs. Workspace transformations can drop or rename columns, so
+// alternates are used where they carry the same information.
+type containerLogColumns struct {
+	message, source, container string
+	// byPod is false when PodNamespace/PodName are missing; logs are then
+	// filed per container ID under containers/.
+	byPod bool
+	// ok is false when no usable layout exists and stitching is skipped.
+	ok bool
+}
+
+// resolveContainerLogColumns picks the columns to stitch from, returning a
+// warning for every fallback taken.
+func resolveContainerLogColumns(row map[string]any) (containerLogColumns, []string) {
+	var cols containerLogColumns
+	var warns []string
+	pick := func(names ...string) string {
+		for _, n := range names {
+			if _, ok := row[n]; ok {
+				return n
+			}
+		}
+		return ""
+	}
+	var missing []string
+	if !hasColumns(row, "TimeGenerated") {
+		missing = append(missing, "TimeGenerated")
+	}
+	if cols.message = pick("LogMessage", "LogEntry"); cols.message == "" {
+		missing = append(missing, "LogMessage")
+	} else if cols.message != "LogMessage" {
+		warns = append(warns, fmt.Sprintf("column LogMessage missing, stitching from %s", cols.message))
+	}
+	cols.source = pick("LogSource", "LogEntrySource")
+	if cols.source != "LogSource" {
+		if cols.source == "" {
+			warns = append(warns, "column LogSource missing, stream shown as '-'")
+		} else {
+			warns = append(warns, fmt.Sprintf("column LogSource missing, using %s", cols.source))
+		}
+	}
+	cols.container = pick("ContainerName", "ContainerId")
+	cols.byPod = hasColumns(row, "PodNamespace", "PodName")
+	switch {
+	case cols.byPod && cols.container == "":
+		missing = append(missing, "ContainerName")
+	case cols.byPod && cols.container != "ContainerName":
+		warns = append(warns, fmt.Sprintf("column ContainerName missing, naming files by %s", cols.container))
+	case !cols.byPod && hasColumns(row, "ContainerId"):
+		cols.container = "ContainerId"
+		warns = append(warns, "columns PodNamespace/PodName missing, stitching per container under containers/<ContainerId>.log")
+	case !cols.byPod:
+		missing = append(missing, "PodNamespace", "PodName", "ContainerName")
+	}
+	if len(missing) > 0 {
+		return cols, []string{fmt.Sprintf("columns %s missing; logs not stitched, rows are exported as NDJSON only", strings.Join(missing, ", "))}
+	}
+	cols.ok = true
+	return cols, warns
+}
+
+func (s *stitcher) observeContainerLog(row map[string]any) {
+	if s.containerCols == nil {
+		cols, warns := resolveContainerLogColumns(row)
+		for _, w := range warns {
+			s.warn("ContainerLogV2", w)
+		}
+		s.containerCols = &cols
+	}
+	cols := s.containerCols
+	if !cols.ok {
+		return
+	}
+	cn := toStr(row[cols.container])
+	var p string
+	if cols.byPod {
+		ns, pod := toStr(row["PodNamespace"]), toStr(row["PodName"])
+		if ns == "" && pod == "" && cn == "" {
+			return
+		}
+		p = filepath.Join("namespaces", utils.SafeFileName(ns), "pods", utils.SafeFileName(pod), utils.SafeFileName(cn)+".log")
+	} else {
+		if cn == "" {
+			return
+		}
+		p = filepath.Join("containers", utils.SafeFileName(cn)+".log")
+	}
+	src := "-"
+	if cols.source != "" {
+		src = toStr(row[cols.source])
+	}
+	// Without pod columns files are already per container ID
+	instance := ""
+	if cols.byPod {
+		instance = toStr(row["ContainerId"])
+	}
+	tm := toStr(row["TimeGenerated"])
+	s.pending = append(s.pending, stitchLine{
+		path:     p,
+		instance: instance,
+		tm:       tm,
+		line:     fmt.Sprintf("%s [%s] %s\n", formatStitchTime(tm, s.loc), src, stitchMessage(row[cols.message])),
+	})
+}
+
+func (s *stitcher) observeEvent(row map[string]any) {
+	if !s.requireColumns("KubeEvents", row, "TimeGenerated", "Namespace", "Name", "Reason", "Message") {
+		return
+	}
+	ns := toStr(row["Namespace"])
+	if ns == "" {
+		ns = "default"
+	}
+	tm := toStr(row["TimeGenerated"])
+	name := toStr(row["Name"])
+	line := fmt.Sprintf("%s %s/%s %s %s\n", formatStitchTime(tm, s.loc), ns, name, toStr(row["Reason"]), strings.ReplaceAll(toStr(row["Message"]), "\n", " "))
+	s.pending = append(s.pending, stitchLine{
+		path: filepath.Join("namespaces", utils.SafeFileName(ns), "events", "events.log"),
+		tm:   tm,
+		line: line,
+	})
+	// Also file pod events next to the pod's container logs
+	if strings.EqualFold(toStr(row["ObjectKind"]), "Pod") && name != "" {
+		s.pending = append(s.pending, stitchLine{
+			path: filepath.Join("namespaces", utils.SafeFileName(ns), "pods", utils.SafeFileName(name), "events.log"),
+			tm:   tm,
+			line: line,
+		})
+	}
+}
+
+func (s *stitcher) observeControlPlane(row map[string]any) {
+	if !s.requireColumns("AKSControlPlane", row, "TimeGenerated", "Category", "Message") {
+		return
+	}
+	component := toStr(row["Category"])
+	if component == "" {
+		return
+	}
+	safe := utils.SafeFileName(component)
+	tm := toStr(row["TimeGenerated"])
+	level := toStr(row["Level"])
+	if level == "" {
+		level = "-"
+	}
+	s.pending = append(s.pending, stitchLine{
+		path: filepath.Join("controlplane", safe, safe+".log"),
+		tm:   tm,
+		line: fmt.Sprintf("%s [%s] %s\n", formatStitchTime(tm, s.loc), level, stitchMessage(row["Message"])),
+	})
+}
+
+func (s *stitcher) observeSyslog(row map[string]any) {
+	if !s.requireColumns("Syslog", row, "TimeGenerated", "Computer", "SyslogMessage") {
+		return
+	}
+	node := toStr(row["Computer"])
+	if node == "" {
+		return
+	}
+	tm := toStr(row["TimeGenerated"])
+	level := toStr(row["SeverityLevel"])
+	if level == "" {
+		level = "-"
+	}
+	line := fmt.Sprintf("%s [%s] %s\n", formatStitchTime(tm, s.loc), level, stitchMessage(row["SyslogMessage"]))
+	if proc := toStr(row["ProcessName"]); proc != "" {
+		line = fmt.Sprintf("%s [%s] %s: %s\n", formatStitchTime(tm, s.loc), level, proc, stitchMessage(row["SyslogMessage"]))
+	}
+	s.pending = append(s.pending, stitchLine{
+		path: filepath.Join("nodes", utils.SafeFileName(node), "syslog.log"),
+		tm:   tm,
+		line: line,
+	})
+}
+
+// endChunk sorts the rows buffered for the current chunk by time and appends
+// them to their files.
+func (s *stitcher) endChunk(tarw *utils.TarWriter) error {
+	if len(s.runs) > 0 {
+		return s.mergeRuns()
+	}
+	if len(s.pending) == 0 {
+		return nil
+	}
+	sortStitchLines(s.pending)
+	if err := s.ensureSpillDir(); err != nil {
+		return err
+	}
+	// Group lines per file, keeping their sorted order, so each spill file is
+	// opened once per chunk.
+	byKey := map[stitchKey][]string{}
+	var order []stitchKey
+	for _, l := range s.pending {
+		k := stitchKey{l.path, l.instance}
+		if _, ok := byKey[k]; !ok {
+			order = append(order, k)
+		}
+		byKey[k] = append(byKey[k], l.line)
+		if l.anchor != nil {
+			l.anchor.key, l.anchor.line = k, s.lines[k]+len(byKey[k])
+		}
+	}
+	s.pending = s.pending[:0]
+	for _, k := range order {
+		if err := appendLines(s.spillFile(k), byKey[k]); err != nil {
+			return fmt.Errorf("spill stitched log %s: %w", k.path, err)
+		}
+		s.lines[k] += len(byKey[k])
+	}
+	return nil
+}
+
+func sortStitchLines(lines []stitchLine) {
+	sort.SliceStable(lines, func(i, j int) bool { return timeBefore(lines[i].tm, lines[j].tm) })
+}
+
+func (s *stitcher) ensureSpillDir() error {
+	if s.spillDir != "" {
+		return nil
+	}
+	dir, err := os.MkdirTemp("", "aks-must-gather-stitch-")
+	if err != nil {
+		return fmt.Errorf("create stitch spill dir: %w", err)
+	}
+	s.spillDir = dir
+	return nil
+}
+
+// spillFile returns the spill file of a stitched file, registering new ones.
+// Files must be registered oldest first, so container instances are ordered.
+func (s *stitcher) spillFile(k stitchKey) string {
+	name, ok := s.files[k]
+	if !ok {
+		name = filepath.Join(s.spillDir, fmt.Sprintf("%06d.log", len(s.files)))
+		s.files[k] = name
+		s.instances[k.path] = append(s.instances[k.path], k.instance)
+	}
+	return name
+}
+
+// spillRun sorts the buffered lines and writes them to one run file per
+// stitched file. Each run line is "<TimeGenerated>\t<line>".
+func (s *stitcher) spillRun() error {
+	if len(s.pending) == 0 {
+		return nil
+	}
+	sortStitchLines(s.pending)
+	if err := s.ensureSpillDir(); err != nil {
+		return err
+	}
+	byKey := map[stitchKey][]string{}
+	anchors := map[stitchKey]map[int]*stitchAnchor{}
+	var order []stitchKey
+	for _, l := range s.pending {
+		k := stitchKey{l.path, l.instance}
+		if _, ok := byKey[k]; !ok {
+			order = append(order, k)
+			if start, ok := s.runStart[k]; !ok || timeBefore(l.tm, start) {
+				s.runStart[k] = l.tm
+			}
+		}
+		byKey[k] = append(byKey[k], l.tm+"\t"+l.line)
+		if l.anchor != nil {
+			if anchors[k] == nil {
+				anchors[k] = map[int]*stitchAnchor{}
+			}
+			anchors[k][len(byKey[k])] = l.anchor
+		}
+	}
+	for _, k := range order {
+		name := filepath.Join(s.spillDir, fmt.Sprintf("run-%06d.log", s.nextRun))
+		s.nextRun++
+		if err := appendLines(name, byKey[k]); err != nil {
+			return fmt.Errorf("spill stitched run %s: %w", k.path, err)
+		}
+		s.runs[k] = append(s.runs[k], name)
+		if anchors[k] != nil {
+			s.runAnchors[name] = anchors[k]
+		}
+	}
+	s.pending = s.pending[:0]
+	return nil
+}
+
+// mergeRuns merges the current chunk's runs, plus any lines still buffered,
+// into the stitched files in time order and removes the runs.
+func (s *stitcher) mergeRuns() error {
+	if err := s.spillRun(); err != nil {
+		return err
+	}
+	keys := make([]stitchKey, 0, len(s.runs))
+	for k := range s.runs {
+		keys = append(keys, k)
+	}
+	// Register files oldest first, as endChunk does
+	sort.Slice(keys, func(i, j int) bool {
+		ti, tj := s.runStart[keys[i]], s.runStart[keys[j]]
+		if ti != tj {
+			return timeBefore(ti, tj)
+		}
+		return s.runs[keys[i]][0] < s.runs[keys[j]][0]
+	})
+	for _, k := range keys {
+		n := s.lines[k]
+		err := mergeRunFiles(s.spillFile(k), s.runs[k], func(run string, i int) {
+			n++
+			if a := s.runAnchors[run][i]; a != nil {
+				a.key, a.line = k, n
+			}
+		})
+		if err != nil {
+			return fmt.Errorf("merge stitched log %s: %w", k.path, err)
+		}
+		s.lines[k] = n
+		for _, r := range s.runs[k] {
+			_ = os.Remove(r)
+		}
+	}
+	s.runs = map[stitchKey][]string{}
+	s.runAnchors = map[string]map[int]*stitchAnchor{}
+	s.runStart = map[stitchKey]string{}
+	return nil
+}
+
+// mergeRunFiles appends the lines of sorted run files to dst in time order.
+// Equal times keep run order, matching a stable sort of the whole chunk.
+// written is called for every line appended with its run and 1-based line
+// number in the run.
+func mergeRunFiles(dst string, runs []string, written func(run string, n int)) error {
+	type head struct {
+		r        *bufio.Reader
+		tm, line string
+		n        int
+		ok       bool
+	}
+	next := func(h *head) error {
+		l, err := h.r.ReadString('\n')
+		if err != nil && l == "" {
+			h.ok = false
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		h.tm, h.line, _ = strings.Cut(l, "\t")
+		h.n++
+		h.ok = true
+		return nil
+	}
+	heads := make([]*head, len(runs))
+	for i, name := range runs {
+		f, err := os.Open(name)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		heads[i] = &head{r: bufio.NewReader(f)}
+		if err := next(heads[i]); err != nil {
+			return err
+		}
+	}
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(out)
+	for {
+		best := -1
+		for i, h := range heads {
+			if h.ok && (best < 0 || timeBefore(h.tm, heads[best].tm)) {
+				best = i
+			}
+		}
+		if best < 0 {
+			break
+		}
+		_, _ = w.WriteString(heads[best].line)
+		written(runs[best], heads[best].n)
+		if err := next(heads[best]); err != nil {
+			out.Close()
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+func appendLines(name string, lines []string) error {
+	f, err := os.OpenFile(name, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	for _, l := range lines {
+		_, _ = w.WriteString(l)
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// finish copies all stitched files into the archive and removes the spill
+// directory.
+func (s *stitcher) finish(tarw *utils.TarWriter) error {
+	defer func() {
+		if s.spillDir != "" {
+			_ = os.RemoveAll(s.spillDir)
+			s.spillDir = ""
+		}
+		s.files = map[stitchKey]string{}
+		s.lines = map[stitchKey]int{}
+		s.instances = map[string][]string{}
+	}()
+	if err := s.endChunk(tarw); err != nil {
+		return err
+	}
+	// The newest instance of a container keeps the plain name
+	out := map[string]string{}
+	for path, ids := range s.instances {
+		for i, id := range ids {
+			out[previousLogPath(path, len(ids)-1-i)] = s.files[stitchKey{path, id}]
+		}
+	}
+	paths := make([]string, 0, len(out))
+	for p := range out {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	for _, p := range paths {
+		if err := utils.WriteLocalFileToTar(tarw, p, out[p]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// previousLogPath names the log of the instance n restarts before the current
+// one: <name>.log, <name>.previous.log, <name>.previous-2.log, ...
+func previousLogPath(path string, n int) string {
+	switch n {
+	case 0:
+		return path
+	case 1:
+		return strings.TrimSuffix(path, ".log") + ".previous.log"
+	default:
+		return fmt.Sprintf("%s.previous-%d.log", strings.TrimSuffix(path, ".log"), n)
+	}
+}
+
+// formatStitchTime normalizes a TimeGenerated value to RFC 3339 in loc, with
+// its offset, keeping the raw value when it cannot be parsed. A nil loc keeps
+// the value's own zone, UTC for Log Analytics.
+func formatStitchTime(raw string, loc *time.Location) string {
+	ts := utils.ParseTimeRFC3339(raw)
+	if ts.IsZero() {
+		return raw
+	}
+	if loc != nil {
+		ts = ts.In(loc)
+	}
+	return ts.Format(time.RFC3339Nano)
+}
+
+// stitchMessage renders a (possibly dynamic) log message on a single line.
+func stitchMessage(v any) string {
+	msg := ""
+	switch m := v.(type) {
+	case string:
+		msg = m
+	case map[string]any, []any:
+		if bb, err := json.Marshal(m); err == nil {
+			msg = string(bb)
+		} else {
+			msg = fmt.Sprint(m)
+		}
+	default:
+		msg = fmt.Sprint(m)
+	}
+	msg = strings.ReplaceAll(msg, "\r", "")
+	return strings.ReplaceAll(msg, "\n", "\\n")
+}
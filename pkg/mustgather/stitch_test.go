@@ -0,0 +1,428 @@
+package mustgather
+
+import (
+	"archive/tar"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"kubectl-must-gather/pkg/utils"
+)
+
+// readTransform flushes a transform into an in-memory tar and returns path -> content.
+func readTransform(t *testing.T, st transform) map[string]string {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := utils.NewTarWriter(&buf, time.Time{})
+	if err := st.finish(tw); err != nil {
+		t.Fatalf("finish failed: %v", err)
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatalf("close tar: %v", err)
+	}
+	out := map[string]string{}
+	tr := tar.NewReader(&buf)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("read tar: %v", err)
+		}
+		if hdr.Typeflag == tar.TypeDir {
+			continue
+		}
+		b, _ := io.ReadAll(tr)
+		out[hdr.Name] = string(b)
+	}
+	return out
+}
+
+func TestStitcherContainerLogsOrderedAcrossChunks(t *testing.T) {
+	st := newStitcher(&Config{StitchLogs: true}, nil)
+
+	row := func(tm, msg string) map[string]any {
+		return map[string]any{
+			"TimeGenerated": tm, "PodNamespace": "default", "PodName": "web-1",
+			"ContainerName": "nginx", "LogSource": "stdout", "LogMessage": msg,
+		}
+	}
+	st.observe("ContainerLogV2", row("2024-01-01T00:00:02Z", "second"))
+	st.observe("ContainerLogV2", row("2024-01-01T00:00:01Z", "first"))
+	_ = st.endChunk(nil)
+	st.observe("ContainerLogV2", row("2024-01-01T00:15:00Z", "multi\nline"))
+
+	files := readTransform(t, st)
+	got := files["namespaces/default/pods/web-1/nginx.log"]
+	want := "2024-01-01T00:00:01Z [stdout] first\n" +
+		"2024-01-01T00:00:02Z [stdout] second\n" +
+		"2024-01-01T00:15:00Z [stdout] multi\\nline\n"
+	if got != want {
+		t.Errorf("unexpected stitched log.\nExpected:\n%s\nGot:\n%s", want, got)
+	}
+}
+
+func TestStitcherTimezone(t *testing.T) {
+	st := newStitcher(&Config{StitchLogs: true, Timezone: "Europe/Berlin"}, nil)
+	st.observe("ContainerLogV2", map[string]any{
+		"TimeGenerated": "2024-01-01T00:00:01Z", "PodNamespace": "default", "PodName": "web-1",
+		"ContainerName": "nginx", "LogSource": "stdout", "LogMessage": "winter",
+	})
+	st.observe("ContainerLogV2", map[string]any{
+		"TimeGenerated": "2024-07-01T00:00:01.5Z", "PodNamespace": "default", "PodName": "web-1",
+		"ContainerName": "nginx", "LogSource": "stdout", "LogMessage": "summer",
+	})
+
+	files := readTransform(t, st)
+	got := files["namespaces/default/pods/web-1/nginx.log"]
+	want := "2024-01-01T01:00:01+01:00 [stdout] winter\n" +
+		"2024-07-01T02:00:01.5+02:00 [stdout] summer\n"
+	if got != want {
+		t.Errorf("unexpected stitched log.\nExpected:\n%s\nGot:\n%s", want, got)
+	}
+}
+
+func TestFormatStitchTime(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		raw  string
+		loc  *time.Location
+		want string
+	}{
+		{"2024-01-01T00:00:00Z", nil, "2024-01-01T00:00:00Z"},
+		{"2024-01-01T00:00:00Z", berlin, "2024-01-01T01:00:00+01:00"},
+		{"2024-01-01T03:00:00+02:00", berlin, "2024-01-01T02:00:00+01:00"},
+		{"not a time", berlin, "not a time"},
+		{"", berlin, ""},
+	}
+	for _, tt := range tests {
+		if got := formatStitchTime(tt.raw, tt.loc); got != tt.want {
+			t.Errorf("formatStitchTime(%q) = %q, want %q", tt.raw, got, tt.want)
+		}
+	}
+}
+
+func TestStitcherControlPlane(t *testing.T) {
+	st := newStitcher(&Config{StitchLogs: true}, nil)
+
+	st.observe("AKSControlPlane", map[string]any{
+		"TimeGenerated": "2024-01-01T00:00:05Z", "Category": "kube-scheduler", "Level": "INFO", "Message": "scheduled",
+	})
+	st.observe("AKSControlPlane", map[string]any{
+		"TimeGenerated": "2024-01-01T00:00:01Z", "Category": "kube-apiserver", "Level": "ERROR", "Message": "timeout",
+	})
+	st.observe("AKSControlPlane", map[string]any{
+		"TimeGenerated": "2024-01-01T00:00:00Z", "Category": "kube-apiserver", "Message": "starting",
+	})
+
+	files := readTransform(t, st)
+	if got := files["controlplane/kube-apiserver/kube-apiserver.log"]; got != "2024-01-01T00:00:00Z [-] starting\n2024-01-01T00:00:01Z [ERROR] timeout\n" {
+		t.Errorf("unexpected kube-apiserver log: %q", got)
+	}
+	if got := files["controlplane/kube-scheduler/kube-scheduler.log"]; !strings.Contains(got, "[INFO] scheduled") {
+		t.Errorf("unexpected kube-scheduler log: %q", got)
+	}
+}
+
+func TestStitcherDisabled(t *testing.T) {
+	st := newStitcher(&Config{StitchLogs: false}, nil)
+	st.observe("AKSControlPlane", map[string]any{
+		"TimeGenerated": "2024-01-01T00:00:00Z", "Category": "kube-apiserver", "Message": "ignored",
+	})
+	if files := readTransform(t, st); len(files) != 0 {
+		t.Errorf("expected no stitched files when stitching is disabled, got %v", files)
+	}
+}
+
+func TestStitcherPodEvents(t *testing.T) {
+	st := newStitcher(&Config{StitchLogs: true, StitchIncludeEvents: true}, nil)
+
+	event := func(tm, kind, name, reason string) map[string]any {
+		return map[string]any{
+			"TimeGenerated": tm, "Namespace": "shop", "Name": name, "ObjectKind": kind,
+			"Reason": reason, "Message": reason + " happened",
+		}
+	}
+	st.observe("KubeEvents", event("2024-01-01T00:00:02Z", "Pod", "cart-1", "Killing"))
+	st.observe("KubeEvents", event("2024-01-01T00:00:01Z", "Pod", "cart-1", "Scheduled"))
+	st.observe("KubeEvents", event("2024-01-01T00:00:03Z", "ReplicaSet", "cart", "SuccessfulCreate"))
+
+	files := readTransform(t, st)
+	if got := files["namespaces/shop/events/events.log"]; strings.Count(got, "\n") != 3 {
+		t.Errorf("expected all 3 events in the namespace log, got %q", got)
+	}
+	want := "2024-01-01T00:00:01Z shop/cart-1 Scheduled Scheduled happened\n" +
+		"2024-01-01T00:00:02Z shop/cart-1 Killing Killing happened\n"
+	if got := files["namespaces/shop/pods/cart-1/events.log"]; got != want {
+		t.Errorf("unexpected pod events log.\nExpected:\n%s\nGot:\n%s", want, got)
+	}
+	if _, ok := files["namespaces/shop/pods/cart/events.log"]; ok {
+		t.Error("events for non-pod objects should not create pod event files")
+	}
+}
+
+func TestStitcherContainerLogColumnFallbacks(t *testing.T) {
+	tests := []struct {
+		name     string
+		row      map[string]any
+		wantPath string
+		wantLine string
+		warnings int
+	}{
+		{
+			name: "LogEntry instead of LogMessage",
+			row: map[string]any{
+				"TimeGenerated": "2024-01-01T00:00:00Z", "PodNamespace": "default", "PodName": "web-1",
+				"ContainerName": "nginx", "LogSource": "stderr", "LogEntry": "hello",
+			},
+			wantPath: "namespaces/default/pods/web-1/nginx.log",
+			wantLine: "2024-01-01T00:00:00Z [stderr] hello\n",
+			warnings: 1,
+		},
+		{
+			name: "no pod columns",
+			row: map[string]any{
+				"TimeGenerated": "2024-01-01T00:00:00Z", "ContainerId": "abc123", "LogMessage": "hello",
+			},
+			wantPath: "containers/abc123.log",
+			wantLine: "2024-01-01T00:00:00Z [-] hello\n",
+			warnings: 2,
+		},
+		{
+			name: "no message column",
+			row: map[string]any{
+				"TimeGenerated": "2024-01-01T00:00:00Z", "PodNamespace": "default", "PodName": "web-1",
+				"ContainerName": "nginx", "LogSource": "stdout",
+			},
+			warnings: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			st := newStitcher(&Config{StitchLogs: true}, nil)
+			st.observe("ContainerLogV2", tt.row)
+			st.observe("ContainerLogV2", tt.row)
+
+			if got := len(st.warnings("ContainerLogV2")); got != tt.warnings {
+				t.Errorf("expected %d warnings, got %v", tt.warnings, st.warnings("ContainerLogV2"))
+			}
+			files := readTransform(t, st)
+			if tt.wantPath == "" {
+				if len(files) != 0 {
+					t.Errorf("expected no stitched files, got %v", files)
+				}
+				return
+			}
+			if got := files[tt.wantPath]; got != tt.wantLine+tt.wantLine {
+				t.Errorf("unexpected content of %s: %q (files: %v)", tt.wantPath, got, files)
+			}
+		})
+	}
+}
+
+func TestStitcherSpillsToDisk(t *testing.T) {
+	st := newStitcher(&Config{StitchLogs: true}, nil)
+	st.observe("AKSControlPlane", map[string]any{
+		"TimeGenerated": "2024-01-01T00:00:00Z", "Category": "kube-apiserver", "Message": "starting",
+	})
+	if err := st.endChunk(nil); err != nil {
+		t.Fatalf("endChunk failed: %v", err)
+	}
+	if len(st.pending) != 0 {
+		t.Error("expected pending lines to be flushed after endChunk")
+	}
+	dir := st.spillDir
+	if _, err := os.Stat(st.files[stitchKey{path: "controlplane/kube-apiserver/kube-apiserver.log"}]); err != nil {
+		t.Fatalf("expected spill file on disk: %v", err)
+	}
+
+	files := readTransform(t, st)
+	if got := files["controlplane/kube-apiserver/kube-apiserver.log"]; got != "2024-01-01T00:00:00Z [-] starting\n" {
+		t.Errorf("unexpected stitched log: %q", got)
+	}
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Errorf("expected spill dir %s to be removed, stat err: %v", dir, err)
+	}
+}
+
+func TestStitcherSplitsContainerRestarts(t *testing.T) {
+	st := newStitcher(&Config{StitchLogs: true}, nil)
+
+	row := func(tm, id, msg string) map[string]any {
+		return map[string]any{
+			"TimeGenerated": tm, "PodNamespace": "default", "PodName": "web-1", "ContainerName": "nginx",
+			"ContainerId": id, "LogSource": "stdout", "LogMessage": msg,
+		}
+	}
+	st.observe("ContainerLogV2", row("2024-01-01T00:00:01Z", "aaa", "first run"))
+	st.observe("ContainerLogV2", row("2024-01-01T00:05:00Z", "bbb", "second run"))
+	_ = st.endChunk(nil)
+	st.observe("ContainerLogV2", row("2024-01-01T00:20:00Z", "ccc", "third run"))
+	st.observe("ContainerLogV2", row("2024-01-01T00:06:00Z", "bbb", "second run again"))
+
+	files := readTransform(t, st)
+	want := map[string]string{
+		"namespaces/default/pods/web-1/nginx.log":            "2024-01-01T00:20:00Z [stdout] third run\n",
+		"namespaces/default/pods/web-1/nginx.previous.log":   "2024-01-01T00:05:00Z [stdout] second run\n2024-01-01T00:06:00Z [stdout] second run again\n",
+		"namespaces/default/pods/web-1/nginx.previous-2.log": "2024-01-01T00:00:01Z [stdout] first run\n",
+	}
+	if len(files) != len(want) {
+		t.Errorf("expected %d files, got %v", len(want), files)
+	}
+	for p, w := range want {
+		if got := files[p]; got != w {
+			t.Errorf("unexpected content of %s: %q", p, got)
+		}
+	}
+}
+
+func TestStitcherLowMemoryMatchesInMemory(t *testing.T) {
+	defer func(n int) { spillRunLines = n }(spillRunLines)
+	spillRunLines = 3
+	low := &memoryGovernor{}
+	low.low.Store(true)
+
+	row := func(tm, pod, id, msg string) map[string]any {
+		return map[string]any{
+			"TimeGenerated": tm, "PodNamespace": "default", "PodName": pod, "ContainerName": "app",
+			"ContainerId": id, "LogSource": "stdout", "LogMessage": msg,
+		}
+	}
+	// Two chunks of unordered rows, with a restart inside the first one
+	chunks := [][]map[string]any{
+		{
+			row("2024-01-01T00:09:00Z", "web-1", "bbb", "b3"),
+			row("2024-01-01T00:01:00Z", "web-1", "aaa", "a1"),
+			row("2024-01-01T00:07:00Z", "web-1", "bbb", "b1"),
+			row("2024-01-01T00:03:00Z", "web-2", "ccc", "c1"),
+			row("2024-01-01T00:02:00Z", "web-1", "aaa", "a2"),
+			row("2024-01-01T00:08:00Z", "web-1", "bbb", "b2"),
+			row("2024-01-01T00:03:00Z", "web-2", "ccc", "c2 same time"),
+		},
+		{
+			row("2024-01-01T00:20:00Z", "web-2", "ccc", "c4"),
+			row("2024-01-01T00:16:00Z", "web-2", "ccc", "c3"),
+		},
+	}
+	stitch := func(st *stitcher) map[string]string {
+		for _, chunk := range chunks {
+			for _, r := range chunk {
+				st.observe("ContainerLogV2", r)
+				if st.memory.lowMemory() && len(st.pending) > spillRunLines {
+					t.Fatalf("expected at most %d buffered lines in low-memory mode, got %d", spillRunLines, len(st.pending))
+				}
+			}
+			if err := st.endChunk(nil); err != nil {
+				t.Fatalf("endChunk failed: %v", err)
+			}
+		}
+		return readTransform(t, st)
+	}
+
+	want := stitch(newStitcher(&Config{StitchLogs: true}, nil))
+	st := newStitcher(&Config{StitchLogs: true}, low)
+	got := stitch(st)
+	if st.nextRun == 0 {
+		t.Fatal("expected low-memory mode to sort through run files")
+	}
+	if len(got) != len(want) {
+		t.Errorf("expected files %v, got %v", want, got)
+	}
+	for p, w := range want {
+		if got[p] != w {
+			t.Errorf("%s differs in low-memory mode:\ngot  %q\nwant %q", p, got[p], w)
+		}
+	}
+	if got["namespaces/default/pods/web-1/app.previous.log"] == "" {
+		t.Errorf("expected the restart to produce a previous log, got %v", got)
+	}
+}
+
+func TestStitcherAnchors(t *testing.T) {
+	defer func(n int) { spillRunLines = n }(spillRunLines)
+	spillRunLines = 2
+	low := &memoryGovernor{}
+	low.low.Store(true)
+
+	row := func(tm, pod, id, msg string) map[string]any {
+		return map[string]any{
+			"TimeGenerated": tm, "PodNamespace": "default", "PodName": pod, "ContainerName": "app",
+			"ContainerId": id, "LogSource": "stdout", "LogMessage": msg,
+		}
+	}
+	chunks := [][]map[string]any{
+		{
+			row("2024-01-01T00:09:00Z", "web-1", "bbb", "b2"),
+			row("2024-01-01T00:02:00Z", "web-1", "aaa", "a2"),
+			row("2024-01-01T00:07:00Z", "web-1", "bbb", "b1"),
+			row("2024-01-01T00:01:00Z", "web-1", "aaa", "a1"),
+			row("2024-01-01T00:03:00Z", "web-2", "ccc", "c1"),
+		},
+		{
+			row("2024-01-01T00:20:00Z", "web-2", "ccc", "c3"),
+			row("2024-01-01T00:16:00Z", "web-2", "ccc", "c2"),
+		},
+	}
+	want := map[string]string{
+		"a2": "namespaces/default/pods/web-1/app.previous.log:2",
+		"b1": "namespaces/default/pods/web-1/app.log:1",
+		"b2": "namespaces/default/pods/web-1/app.log:2",
+		"c2": "namespaces/default/pods/web-2/app.log:2",
+		"c3": "namespaces/default/pods/web-2/app.log:3",
+	}
+	for _, memory := range []*memoryGovernor{nil, low} {
+		st := newStitcher(&Config{StitchLogs: true}, memory)
+		anchors := map[string]*stitchAnchor{}
+		for _, chunk := range chunks {
+			for _, r := range chunk {
+				msg := r["LogMessage"].(string)
+				if _, ok := want[msg]; ok {
+					anchors[msg] = st.anchorNext()
+				}
+				st.observe("ContainerLogV2", r)
+			}
+			if err := st.endChunk(nil); err != nil {
+				t.Fatalf("endChunk failed: %v", err)
+			}
+		}
+		files := map[string]string{}
+		for msg, a := range anchors {
+			files[msg] = fmt.Sprintf("%s:%d", st.file(a), a.line)
+		}
+		out := readTransform(t, st)
+		for msg, w := range want {
+			if files[msg] != w {
+				t.Errorf("low memory %v: %s anchored at %s, want %s", memory != nil, msg, files[msg], w)
+			}
+			// The anchored line holds the message
+			p, n, _ := strings.Cut(w, ":")
+			lines := strings.Split(out[p], "\n")
+			if i, _ := strconv.Atoi(n); i > len(lines) || !strings.HasSuffix(lines[i-1], msg) {
+				t.Errorf("low memory %v: line %s of %s is not %s:\n%s", memory != nil, n, p, msg, out[p])
+			}
+		}
+	}
+
+	// Rows that are not stitched leave their anchor unset
+	st := newStitcher(&Config{StitchLogs: true}, nil)
+	a := st.anchorNext()
+	st.observe("KubeEvents", map[string]any{"TimeGenerated": "2024-01-01T00:00:00Z", "Namespace": "default", "Name": "x", "Reason": "r", "Message": "m"})
+	if err := st.endChunk(nil); err != nil {
+		t.Fatalf("endChunk failed: %v", err)
+	}
+	if st.file(a) != "" {
+		t.Errorf("events are not stitched without StitchIncludeEvents, got anchor %s", st.file(a))
+	}
+	if newStitcher(&Config{}, nil).anchorNext() != nil {
+		t.Error("expected no anchors without stitching")
+	}
+}
@@ -0,0 +1,81 @@
+package mustgather
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// suggest returns the candidate closest to name, ignoring case, when it is
+// near enough to be a likely typo of it, or "" otherwise.
+func suggest(name string, candidates []string) string {
+	name = strings.ToLower(name)
+	best, bestDist := "", 0
+	for _, c := range candidates {
+		d := editDistance(name, strings.ToLower(c))
+		if best == "" || d < bestDist || (d == bestDist && c < best) {
+			best, bestDist = c, d
+		}
+	}
+	// One edit in short names, up to a third of longer ones
+	if best == "" || bestDist > max(1, len(name)/3) {
+		return ""
+	}
+	return best
+}
+
+// editDistance is the Levenshtein distance between a and b.
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+// describeUnknown lists names, each followed by the candidate it is likely a
+// typo of, e.g. `Syslg (did you mean "Syslog"?)`.
+func describeUnknown(names, candidates []string) string {
+	parts := make([]string, len(names))
+	for i, n := range names {
+		parts[i] = n
+		if s := suggest(n, candidates); s != "" {
+			parts[i] += fmt.Sprintf(" (did you mean %q?)", s)
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+// checkProfiles returns an error naming the profiles in csv that are not in
+// profiles, with the closest known names.
+func checkProfiles(csv string, profiles ProfileMap) error {
+	var unknown []string
+	for _, p := range strings.Split(csv, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			if _, ok := profiles[p]; !ok {
+				unknown = append(unknown, p)
+			}
+		}
+	}
+	if len(unknown) == 0 {
+		return nil
+	}
+	known := make([]string, 0, len(profiles))
+	for p := range profiles {
+		known = append(known, p)
+	}
+	sort.Strings(known)
+	return fmt.Errorf("unknown profile %s; known profiles: %s", describeUnknown(unknown, known), strings.Join(known, ", "))
+}
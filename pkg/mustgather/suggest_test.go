@@ -0,0 +1,51 @@
+package mustgather
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSuggest(t *testing.T) {
+	tables := []string{"ContainerLogV2", "KubeEvents", "KubePodInventory", "Syslog"}
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"KubeEvent", "KubeEvents"},
+		{"kubeevents", "KubeEvents"},
+		{"ContainerLogsV2", "ContainerLogV2"},
+		{"Syslg", "Syslog"},
+		{"KubePodInvntory", "KubePodInventory"},
+		{"Heartbeat", ""},
+		{"Sys", ""},
+	}
+	for _, tt := range tests {
+		if got := suggest(tt.name, tables); got != tt.want {
+			t.Errorf("suggest(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+	if got := suggest("KubeEvents", nil); got != "" {
+		t.Errorf("suggest with no candidates = %q", got)
+	}
+}
+
+func TestCheckProfiles(t *testing.T) {
+	profiles := GetDefaultProfiles()
+	for _, csv := range []string{"", "aks-debug", " podLogs , audit ,"} {
+		if err := checkProfiles(csv, profiles); err != nil {
+			t.Errorf("checkProfiles(%q) failed: %v", csv, err)
+		}
+	}
+	err := checkProfiles("aks-debug,podlog,nope", profiles)
+	if err == nil {
+		t.Fatal("expected unknown profiles to fail")
+	}
+	for _, want := range []string{`podlog (did you mean "podLogs"?), nope;`, "known profiles: aks-debug, "} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q does not contain %q", err, want)
+		}
+	}
+	if strings.Contains(err.Error(), "nope (did you mean") {
+		t.Errorf("no suggestion expected for nope: %v", err)
+	}
+}
@@ -0,0 +1,227 @@
+package mustgather
+
+import (
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"kubectl-must-gather/pkg/bundle"
+	"kubectl-must-gather/pkg/utils"
+)
+
+// SupportMaxPartSize is the largest file Microsoft support accepts as a case
+// attachment (2 GB).
+const SupportMaxPartSize = 2_000_000_000
+
+// SupportManifestName is the upload manifest written next to the parts.
+const SupportManifestName = "upload-manifest.json"
+
+// supportSensitive classifies the bundle paths left out of support packages,
+// with the reason recorded in the manifest.
+var supportSensitive = []struct {
+	prefix, reason string
+}{
+	{"tables/AKSAudit/", "audit log: user identities and request bodies"},
+	{"tables/AKSAuditAdmin/", "audit log: user identities and request bodies"},
+	{"audit/", "audit log: user identities and request bodies"},
+}
+
+// SupportOptions configures PackageForSupport.
+type SupportOptions struct {
+	// OutDir receives the parts and the manifest; default <bundle>-support.
+	OutDir string
+	// CaseID, if set, prefixes the file names with the support case number.
+	CaseID string
+	// MaxPartSize defaults to SupportMaxPartSize.
+	MaxPartSize int64
+}
+
+// SupportManifest is the content of upload-manifest.json.
+type SupportManifest struct {
+	CaseID      string            `json:"caseId,omitempty"`
+	Source      string            `json:"source"`
+	CreatedAt   string            `json:"createdAt"`
+	MaxPartSize int64             `json:"maxPartSize"`
+	Files       []SupportFile     `json:"files"`
+	Excluded    []SupportExcluded `json:"excluded,omitempty"`
+}
+
+// SupportFile is one archive to attach to the case.
+type SupportFile struct {
+	Name    string `json:"name"`
+	Size    int64  `json:"size"`
+	SHA256  string `json:"sha256"`
+	Entries int    `json:"entries"`
+}
+
+// SupportExcluded is a bundle file left out of the package.
+type SupportExcluded struct {
+	Path   string `json:"path"`
+	Reason string `json:"reason"`
+}
+
+// PackageForSupport repackages the bundle at src for a Microsoft support
+// case: data classified as sensitive is left out, and the rest is split on
+// file boundaries into tar.gz parts under the attachment size limit, each a
+// valid archive on its own. An upload manifest with the parts' checksums is
+// written next to them.
+func PackageForSupport(src string, opts SupportOptions) (*SupportManifest, error) {
+	if opts.MaxPartSize <= 0 {
+		opts.MaxPartSize = SupportMaxPartSize
+	}
+	base := strings.TrimSuffix(strings.TrimSuffix(filepath.Base(src), ".gz"), ".tar")
+	if opts.OutDir == "" {
+		opts.OutDir = filepath.Join(filepath.Dir(src), base+"-support")
+	}
+	prefix := base
+	if opts.CaseID != "" {
+		prefix = utils.SafeFileName(opts.CaseID) + "_" + base
+	}
+
+	b, err := bundle.Open(src)
+	if err != nil {
+		return nil, fmt.Errorf("open bundle: %w", err)
+	}
+	defer b.Close()
+	files, err := b.Files()
+	if err != nil {
+		return nil, fmt.Errorf("list bundle files: %w", err)
+	}
+	if err := os.MkdirAll(opts.OutDir, 0o755); err != nil {
+		return nil, fmt.Errorf("create %s: %w", opts.OutDir, err)
+	}
+
+	m := &SupportManifest{
+		CaseID:      opts.CaseID,
+		Source:      filepath.Base(src),
+		CreatedAt:   time.Now().UTC().Format(time.RFC3339),
+		MaxPartSize: opts.MaxPartSize,
+	}
+	var part *supportPart
+	var parts []*supportPart
+	for _, f := range files {
+		if reason := supportSensitiveReason(f); reason != "" {
+			m.Excluded = append(m.Excluded, SupportExcluded{Path: f, Reason: reason})
+			continue
+		}
+		local := filepath.Join(b.Dir, filepath.FromSlash(f))
+		fi, err := os.Stat(local)
+		if err != nil {
+			return nil, err
+		}
+		if part != nil && !part.fits(fi.Size(), opts.MaxPartSize) {
+			if err := part.close(); err != nil {
+				return nil, err
+			}
+			part = nil
+		}
+		if part == nil {
+			if part, err = newSupportPart(filepath.Join(opts.OutDir, fmt.Sprintf(".part-%d.tmp", len(parts)))); err != nil {
+				return nil, err
+			}
+			parts = append(parts, part)
+		}
+		if err := part.add(f, local); err != nil {
+			return nil, err
+		}
+	}
+	if part != nil {
+		if err := part.close(); err != nil {
+			return nil, err
+		}
+	}
+
+	for i, p := range parts {
+		name := prefix + ".tar.gz"
+		if len(parts) > 1 {
+			name = fmt.Sprintf("%s_part%02dof%02d.tar.gz", prefix, i+1, len(parts))
+		}
+		if p.size > opts.MaxPartSize {
+			return nil, fmt.Errorf("%s is %d bytes, over the %d byte attachment limit: a single bundle file is too large to split", name, p.size, opts.MaxPartSize)
+		}
+		if err := os.Rename(p.path, filepath.Join(opts.OutDir, name)); err != nil {
+			return nil, err
+		}
+		m.Files = append(m.Files, SupportFile{Name: name, Size: p.size, SHA256: hex.EncodeToString(p.sum.Sum(nil)), Entries: p.entries})
+	}
+
+	mb, _ := json.MarshalIndent(m, "", "  ")
+	if err := os.WriteFile(filepath.Join(opts.OutDir, SupportManifestName), append(mb, '\n'), 0o644); err != nil {
+		return nil, fmt.Errorf("write manifest: %w", err)
+	}
+	return m, nil
+}
+
+func supportSensitiveReason(path string) string {
+	for _, s := range supportSensitive {
+		if strings.HasPrefix(path, s.prefix) {
+			return s.reason
+		}
+	}
+	return ""
+}
+
+// supportPart is a tar.gz part being written, with its compressed size and
+// checksum tracked as it goes.
+type supportPart struct {
+	path    string
+	f       *os.File
+	gz      *gzip.Writer
+	tarw    *utils.TarWriter
+	sum     hash.Hash
+	size    int64
+	entries int
+}
+
+func newSupportPart(path string) (*supportPart, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("create part: %w", err)
+	}
+	p := &supportPart{path: path, f: f, sum: sha256.New()}
+	p.gz = gzip.NewWriter(io.MultiWriter(f, p.sum, p))
+	p.tarw = utils.NewTarWriter(p.gz, time.Now())
+	return p, nil
+}
+
+// Write counts the compressed bytes of the part.
+func (p *supportPart) Write(b []byte) (int, error) {
+	p.size += int64(len(b))
+	return len(b), nil
+}
+
+// fits reports whether a file of size bytes can still be added without the
+// part going over limit. Compressing never grows data by more than a small
+// fraction, so the file's size plus headers and slack is an upper bound.
+func (p *supportPart) fits(size, limit int64) bool {
+	return p.size+size+size/1000+64*1024 <= limit
+}
+
+func (p *supportPart) add(name, local string) error {
+	if err := utils.WriteLocalFileToTar(p.tarw, name, local); err != nil {
+		return fmt.Errorf("add %s: %w", name, err)
+	}
+	p.entries++
+	// Flush so size reflects everything written so far
+	return p.gz.Flush()
+}
+
+func (p *supportPart) close() error {
+	if err := p.tarw.Close(); err != nil {
+		p.f.Close()
+		return err
+	}
+	if err := p.gz.Close(); err != nil {
+		p.f.Close()
+		return err
+	}
+	return p.f.Close()
+}
@@ -0,0 +1,98 @@
+package mustgather
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPackageForSupport(t *testing.T) {
+	// Incompressible parts of 100 KiB, so only two fit a 300 KiB part
+	random := func() string {
+		b := make([]byte, 100*1024)
+		_, _ = rand.Read(b)
+		return string(b)
+	}
+	src := writeBundleDir(t, map[string]string{
+		"index.json": `{"tables":["ContainerLogV2","AKSAudit"]}`,
+		"tables/ContainerLogV2/parts/0000-a.ndjson": random(),
+		"tables/ContainerLogV2/parts/0001-b.ndjson": random(),
+		"tables/ContainerLogV2/parts/0002-c.ndjson": random(),
+		"tables/AKSAudit/parts/0000-a.ndjson":       `{"User":"alice@example.com"}` + "\n",
+		"audit/kube-apiserver/audit-0000.log":       `{"user":{"username":"alice@example.com"}}` + "\n",
+	})
+	out := filepath.Join(t.TempDir(), "support")
+
+	m, err := PackageForSupport(src, SupportOptions{OutDir: out, CaseID: "2401010010000123", MaxPartSize: 300 * 1024})
+	if err != nil {
+		t.Fatalf("PackageForSupport failed: %v", err)
+	}
+	if len(m.Files) != 2 || m.Files[0].Name != "2401010010000123_bundle_part01of02.tar.gz" || m.Files[1].Name != "2401010010000123_bundle_part02of02.tar.gz" {
+		t.Fatalf("unexpected parts %+v", m.Files)
+	}
+	if len(m.Excluded) != 2 || m.Excluded[0].Path != "audit/kube-apiserver/audit-0000.log" || m.Excluded[1].Path != "tables/AKSAudit/parts/0000-a.ndjson" {
+		t.Errorf("unexpected exclusions %+v", m.Excluded)
+	}
+
+	entries := map[string]string{}
+	for _, f := range m.Files {
+		p := filepath.Join(out, f.Name)
+		raw, err := os.ReadFile(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sum := sha256.Sum256(raw)
+		if int64(len(raw)) != f.Size || hex.EncodeToString(sum[:]) != f.SHA256 || f.Size > m.MaxPartSize {
+			t.Errorf("%s: manifest size %d sha256 %s, file %d bytes sha256 %x", f.Name, f.Size, f.SHA256, len(raw), sum)
+		}
+		// Every part is a valid archive on its own
+		part := readArchive(t, p)
+		if len(part) != f.Entries {
+			t.Errorf("%s: %d entries, manifest says %d", f.Name, len(part), f.Entries)
+		}
+		for name, content := range part {
+			entries[name] = content
+		}
+	}
+	if len(entries) != 4 {
+		t.Errorf("expected the 4 non-sensitive files across parts, got %d", len(entries))
+	}
+	for name, content := range entries {
+		if strings.Contains(content, "alice@example.com") {
+			t.Errorf("sensitive data packaged in %s", name)
+		}
+	}
+
+	var written SupportManifest
+	raw, err := os.ReadFile(filepath.Join(out, SupportManifestName))
+	if err != nil {
+		t.Fatalf("manifest not written: %v", err)
+	}
+	if err := json.Unmarshal(raw, &written); err != nil || written.CaseID != "2401010010000123" || len(written.Files) != 2 {
+		t.Errorf("unexpected manifest %s (%v)", raw, err)
+	}
+
+	// A file that cannot fit any part is an error
+	if _, err := PackageForSupport(src, SupportOptions{OutDir: t.TempDir(), MaxPartSize: 64 * 1024}); err == nil || !strings.Contains(err.Error(), "too large to split") {
+		t.Errorf("expected a too-large error, got %v", err)
+	}
+}
+
+func TestPackageForSupportSinglePart(t *testing.T) {
+	src := writeBundleDir(t, map[string]string{"index.json": `{"tables":[]}`, "SUMMARY.md": "# Summary\n"})
+	m, err := PackageForSupport(src, SupportOptions{})
+	if err != nil {
+		t.Fatalf("PackageForSupport failed: %v", err)
+	}
+	if len(m.Files) != 1 || m.Files[0].Name != "bundle.tar.gz" || m.Files[0].Entries != 2 {
+		t.Errorf("unexpected parts %+v", m.Files)
+	}
+	if _, err := os.Stat(filepath.Join(src+"-support", "bundle.tar.gz")); err != nil {
+		t.Errorf("expected the package next to the bundle: %v", err)
+	}
+}
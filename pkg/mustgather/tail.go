@@ -0,0 +1,263 @@
+package mustgather
+
+import (
+	"bufio"
+	"container/heap"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"kubectl-must-gather/pkg/bundle"
+)
+
+// tailColors are the ANSI colors pods and containers cycle through, as in
+// stern.
+var tailColors = []string{"\x1b[31m", "\x1b[32m", "\x1b[33m", "\x1b[34m", "\x1b[35m", "\x1b[36m", "\x1b[91m", "\x1b[92m", "\x1b[93m", "\x1b[94m", "\x1b[95m", "\x1b[96m"}
+
+const tailReset = "\x1b[0m"
+
+// tailMaxPause caps the wait between two lines when replaying, so quiet
+// stretches of the window do not stall the output.
+const tailMaxPause = 2 * time.Second
+
+// TailOptions selects and formats the lines Tail replays. Pod, Namespace
+// and Container are regular expressions; empty matches all.
+type TailOptions struct {
+	Namespace string
+	Pod       string
+	Container string
+	// Since is a time (RFC 3339, or UTC like 2024-01-01T15:04) or a
+	// duration before the last selected line, e.g. 15m. Until is a time.
+	Since string
+	Until string
+	// Grep keeps only lines whose message matches this regular expression.
+	Grep  string
+	Color bool
+	// Timestamps prefixes every line with its time.
+	Timestamps bool
+	// Replay paces the output at this multiple of the original speed; 0
+	// prints everything at once.
+	Replay float64
+}
+
+// Tail interleaves the stitched container logs of the bundle at src that
+// match opts in time order, each line prefixed with its namespace, pod and
+// container like stern, and writes them to w. It returns the number of
+// lines written.
+func Tail(src string, opts TailOptions, w io.Writer) (int, error) {
+	var ns, pod, container, grep *regexp.Regexp
+	for _, f := range []struct {
+		flag, expr string
+		re         **regexp.Regexp
+	}{
+		{"namespace", opts.Namespace, &ns},
+		{"pod", opts.Pod, &pod},
+		{"container", opts.Container, &container},
+		{"grep", opts.Grep, &grep},
+	} {
+		if f.expr == "" {
+			continue
+		}
+		re, err := regexp.Compile(f.expr)
+		if err != nil {
+			return 0, fmt.Errorf("invalid %s pattern: %w", f.flag, err)
+		}
+		*f.re = re
+	}
+
+	b, err := bundle.Open(src)
+	if err != nil {
+		return 0, fmt.Errorf("open bundle: %w", err)
+	}
+	defer b.Close()
+	files, err := b.Files()
+	if err != nil {
+		return 0, fmt.Errorf("list bundle files: %w", err)
+	}
+	var selected []*containerLogs
+	for _, c := range stitchedContainerLogs(files) {
+		if (ns == nil || ns.MatchString(c.namespace)) && (pod == nil || pod.MatchString(c.pod)) && (container == nil || container.MatchString(c.container)) {
+			selected = append(selected, c)
+		}
+	}
+	if len(selected) == 0 {
+		return 0, fmt.Errorf("no stitched container logs match in %s", src)
+	}
+
+	var sources []*tailSource
+	colors := map[string]string{}
+	for _, c := range selected {
+		for _, f := range c.files {
+			fh, err := os.Open(filepath.Join(b.Dir, filepath.FromSlash(f)))
+			if err != nil {
+				return 0, err
+			}
+			defer fh.Close()
+			src := &tailSource{c: c, sc: bufio.NewScanner(fh), order: len(sources)}
+			src.sc.Buffer(make([]byte, 64*1024), 16*1024*1024)
+			sources = append(sources, src)
+		}
+		for _, key := range []string{"pod:" + c.namespace + "/" + c.pod, "container:" + c.container} {
+			if _, ok := colors[key]; !ok {
+				colors[key] = tailColors[len(colors)%len(tailColors)]
+			}
+		}
+	}
+
+	since, until, err := tailWindow(opts.Since, opts.Until, selected, b.Dir)
+	if err != nil {
+		return 0, err
+	}
+
+	h := &tailHeap{}
+	for _, s := range sources {
+		if s.next() {
+			heap.Push(h, s)
+		}
+	}
+	bw := bufio.NewWriter(w)
+	defer bw.Flush()
+	var last time.Time
+	n := 0
+	for h.Len() > 0 {
+		s := (*h)[0]
+		ts, stream, msg := s.ts, s.stream, s.msg
+		if s.next() {
+			heap.Fix(h, 0)
+		} else {
+			heap.Pop(h)
+		}
+		if (!since.IsZero() && ts.Before(since)) || (!until.IsZero() && !ts.Before(until)) {
+			continue
+		}
+		if grep != nil && !grep.MatchString(msg) {
+			continue
+		}
+		if opts.Replay > 0 && !last.IsZero() && ts.After(last) {
+			bw.Flush()
+			time.Sleep(min(time.Duration(float64(ts.Sub(last))/opts.Replay), tailMaxPause))
+		}
+		last = ts
+		c := s.c
+		podLabel, containerLabel := c.namespace+" "+c.pod, c.container
+		if opts.Color {
+			podLabel = colors["pod:"+c.namespace+"/"+c.pod] + podLabel + tailReset
+			containerLabel = colors["container:"+c.container] + containerLabel + tailReset
+			if stream == "stderr" {
+				msg = "\x1b[2m" + msg + tailReset
+			}
+		}
+		if opts.Timestamps {
+			fmt.Fprintf(bw, "%s %s %s %s\n", podLabel, containerLabel, ts.Format(time.RFC3339Nano), msg)
+		} else {
+			fmt.Fprintf(bw, "%s %s %s\n", podLabel, containerLabel, msg)
+		}
+		n++
+	}
+	for _, s := range sources {
+		if err := s.sc.Err(); err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// tailWindow resolves the since and until bounds. A since duration counts
+// back from the last line of the selected logs.
+func tailWindow(sinceArg, untilArg string, selected []*containerLogs, dir string) (since, until time.Time, err error) {
+	if until, err = parseServeTime(untilArg); err != nil {
+		return since, until, fmt.Errorf("invalid --until: %w", err)
+	}
+	if sinceArg == "" {
+		return since, until, nil
+	}
+	d, derr := time.ParseDuration(sinceArg)
+	if derr != nil {
+		if since, err = parseServeTime(sinceArg); err != nil {
+			return since, until, fmt.Errorf("invalid --since: expected a time or a duration like 15m")
+		}
+		return since, until, nil
+	}
+	end := until
+	if end.IsZero() {
+		for _, c := range selected {
+			// The current instance's file ends last
+			if t := lastLineTime(filepath.Join(dir, filepath.FromSlash(c.files[len(c.files)-1]))); t.After(end) {
+				end = t
+			}
+		}
+	}
+	return end.Add(-d), until, nil
+}
+
+// lastLineTime returns the time of the last line of a stitched log, read
+// from its end.
+func lastLineTime(path string) time.Time {
+	f, err := os.Open(path)
+	if err != nil {
+		return time.Time{}
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return time.Time{}
+	}
+	off := max(fi.Size()-64*1024, 0)
+	buf := make([]byte, fi.Size()-off)
+	if _, err := f.ReadAt(buf, off); err != nil && err != io.EOF {
+		return time.Time{}
+	}
+	lines := strings.Split(strings.TrimRight(string(buf), "\n"), "\n")
+	for i := len(lines) - 1; i >= 0; i-- {
+		if ts, _, _, ok := parseStitchedLine(lines[i]); ok {
+			return ts
+		}
+	}
+	return time.Time{}
+}
+
+// tailSource is one stitched log file being merged, positioned at its next
+// line. Lines without a timestamp keep the time of the line before them.
+type tailSource struct {
+	c           *containerLogs
+	sc          *bufio.Scanner
+	ts          time.Time
+	stream, msg string
+	// order breaks ties between sources, so equal times print stably.
+	order int
+}
+
+func (s *tailSource) next() bool {
+	if !s.sc.Scan() {
+		return false
+	}
+	if ts, stream, msg, ok := parseStitchedLine(s.sc.Text()); ok {
+		s.ts, s.stream, s.msg = ts, stream, msg
+	} else {
+		s.msg = s.sc.Text()
+	}
+	return true
+}
+
+// tailHeap orders sources by their next line's time.
+type tailHeap []*tailSource
+
+func (h tailHeap) Len() int { return len(h) }
+func (h tailHeap) Less(i, j int) bool {
+	if !h[i].ts.Equal(h[j].ts) {
+		return h[i].ts.Before(h[j].ts)
+	}
+	return h[i].order < h[j].order
+}
+func (h tailHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+func (h *tailHeap) Push(x any)   { *h = append(*h, x.(*tailSource)) }
+func (h *tailHeap) Pop() any {
+	old := *h
+	s := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return s
+}
@@ -0,0 +1,98 @@
+package mustgather
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestTail(t *testing.T) {
+	src := writeBundleDir(t, map[string]string{
+		"index.json": `{"tables":[]}`,
+		"namespaces/shop/pods/cart-1/cart.previous.log": "2024-01-01T00:00:01Z [stdout] first run\n" +
+			"2024-01-01T00:00:04Z [stderr] panic: boom\n",
+		"namespaces/shop/pods/cart-1/cart.log": "2024-01-01T00:00:06Z [stdout] starting again\n" +
+			"2024-01-01T00:00:09Z [stdout] ready\n",
+		"namespaces/shop/pods/web-1/web.log": "2024-01-01T00:00:02Z [stdout] GET /\n" +
+			"2024-01-01T00:00:06Z [stderr] upstream cart failed\n" +
+			"  at handler.go:12\n",
+		"namespaces/kube-system/pods/coredns-1/coredns.log": "2024-01-01T00:00:03Z [stdout] dns ok\n",
+		"namespaces/shop/pods/cart-1/events.log":            "2024-01-01T00:00:05Z [Warning] BackOff: restarting\n",
+	})
+
+	tests := []struct {
+		name      string
+		opts      TailOptions
+		want      string
+		expectErr string
+	}{
+		{
+			name: "interleaved across namespaces",
+			opts: TailOptions{},
+			want: `shop cart-1 cart first run
+shop web-1 web GET /
+kube-system coredns-1 coredns dns ok
+shop cart-1 cart panic: boom
+shop cart-1 cart starting again
+shop web-1 web upstream cart failed
+shop web-1 web   at handler.go:12
+shop cart-1 cart ready
+`,
+		},
+		{
+			name: "pod and time window",
+			opts: TailOptions{Namespace: "^shop$", Pod: "cart", Since: "2024-01-01T00:00:02Z", Until: "2024-01-01T00:00:09Z", Timestamps: true},
+			want: `shop cart-1 cart 2024-01-01T00:00:04Z panic: boom
+shop cart-1 cart 2024-01-01T00:00:06Z starting again
+`,
+		},
+		{
+			name: "since a duration before the last line",
+			opts: TailOptions{Since: "3s", Grep: "ready|again"},
+			want: `shop cart-1 cart starting again
+shop cart-1 cart ready
+`,
+		},
+		{
+			name: "colored",
+			opts: TailOptions{Pod: "web", Grep: "failed", Color: true},
+			want: "\x1b[31mshop web-1\x1b[0m \x1b[32mweb\x1b[0m \x1b[2mupstream cart failed\x1b[0m\n",
+		},
+		{
+			name:      "no match",
+			opts:      TailOptions{Namespace: "payments"},
+			expectErr: "no stitched container logs match",
+		},
+		{
+			name:      "invalid pattern",
+			opts:      TailOptions{Pod: "("},
+			expectErr: "invalid pod pattern",
+		},
+		{
+			name:      "invalid since",
+			opts:      TailOptions{Since: "yesterday"},
+			expectErr: "invalid --since",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			n, err := Tail(src, tt.opts, &out)
+			if tt.expectErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.expectErr) {
+					t.Fatalf("expected error %q, got %v", tt.expectErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Tail failed: %v", err)
+			}
+			if out.String() != tt.want {
+				t.Errorf("got\n%q\nwant\n%q", out.String(), tt.want)
+			}
+			if lines := strings.Count(tt.want, "\n"); n != lines {
+				t.Errorf("reported %d lines, wrote %d", n, lines)
+			}
+		})
+	}
+}
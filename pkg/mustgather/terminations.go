@@ -0,0 +1,394 @@
+package mustgather
+
+import (
+	"encoding/json"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"kubectl-must-gather/pkg/utils"
+)
+
+// terminationsPath is where container terminations are written.
+const terminationsPath = "analysis/terminations.json"
+
+// terminationsPerContainer caps the terminations listed per container; the
+// latest are kept.
+const terminationsPerContainer = 50
+
+// Termination kinds.
+const (
+	terminationOOM      = "oom-kill"
+	terminationExit     = "exit"
+	terminationSignal   = "signal"
+	terminationKill     = "kill"
+	terminationEviction = "eviction"
+)
+
+var (
+	// The kernel OOM killer logs an oom-kill line naming the victim's cgroup,
+	// then the "Killed process" line.
+	kernelOOMKill   = regexp.MustCompile(`oom-kill:.*?task_memcg=([^,\s]+).*?task=([^,\s]+),pid=(\d+)`)
+	kernelOOMKilled = regexp.MustCompile(`(?i)out of memory: kill(?:ed)? process (\d+) \(([^)]+)\)`)
+	cgroupPodUID    = regexp.MustCompile(`pod([0-9a-f]{8}[-_][0-9a-f]{4}[-_][0-9a-f]{4}[-_][0-9a-f]{4}[-_][0-9a-f]{12})`)
+	cgroupContainer = regexp.MustCompile(`([0-9a-f]{64})`)
+
+	logOOM      = regexp.MustCompile(`(?i)OutOfMemoryError|out of memory|\bOOMKilled\b`)
+	logExitCode = regexp.MustCompile(`(?i)\bexit(?:ed)?(?: with)? (?:status|code)[ :=]*(-?\d+)`)
+	logSignal   = regexp.MustCompile(`(?i)(?:received|caught|got|terminated by|killed by) (?:signal )?(SIG[A-Z]+)|signal: (killed|terminated)`)
+
+	eventContainer = regexp.MustCompile(`(?i)\bcontainers? "?([a-z0-9][-a-z0-9.]*)"?`)
+)
+
+// exitSignals names the signals behind the common 128+n exit codes.
+var exitSignals = map[int64]string{134: "SIGABRT", 137: "SIGKILL", 139: "SIGSEGV", 143: "SIGTERM"}
+
+// terminations writes analysis/terminations.json: per container, the OOM
+// kills, exit codes and other terminations found in KubePodInventory last
+// states, kubelet events, the container's own logs and the nodes' kernel
+// logs. Each entry links to its line in the stitched logs (or, for rows that
+// are not stitched, to the table's parts). It must observe rows before the
+// stitcher, which it asks for the lines' anchors.
+//
+// Kernel OOM kills are attributed to containers by the cgroup in the oom-kill
+// line, looked up in KubePodInventory; the ones that cannot be are listed per
+// node.
+type terminations struct {
+	stitcher   *stitcher
+	containers map[string]*ContainerTerminations
+	nodes      map[string]*NodeTerminations
+	// seen dedupes the last states repeated by every inventory snapshot.
+	seen map[string]bool
+	// kernel holds Syslog OOM kills until the inventory is known.
+	kernel []*kernelOOM
+	// pods maps pod UIDs, and byID container IDs, to their containers.
+	pods map[string][2]string
+	byID map[string][3]string
+	// logCols is resolved from the first ContainerLogV2 row, as the stitcher
+	// does.
+	logCols *containerLogColumns
+}
+
+type kernelOOM struct {
+	node, podUID, containerID, pid string
+	// detail is true for oom-kill lines, which name the cgroup.
+	detail bool
+	t      *Termination
+}
+
+// Termination is one end of a container, or a sign of it.
+type Termination struct {
+	Time     string `json:"time"`
+	Kind     string `json:"kind"`
+	Source   string `json:"source"`
+	Reason   string `json:"reason,omitempty"`
+	ExitCode *int64 `json:"exitCode,omitempty"`
+	Signal   string `json:"signal,omitempty"`
+	Message  string `json:"message"`
+	// File and Line locate the row's line in a stitched log. Rows that are
+	// not stitched link to their table's parts, without a line.
+	File   string `json:"file"`
+	Line   int    `json:"line,omitempty"`
+	anchor *stitchAnchor
+}
+
+// ContainerTerminations is a container's entry in analysis/terminations.json.
+// Container is empty for pod events that do not name one.
+type ContainerTerminations struct {
+	Namespace string `json:"namespace"`
+	Pod       string `json:"pod"`
+	Container string `json:"container,omitempty"`
+	Node      string `json:"node,omitempty"`
+	OOMKills  int    `json:"oomKills"`
+	// ExitCodes counts the exit codes seen, by code.
+	ExitCodes    map[string]int `json:"exitCodes,omitempty"`
+	Terminations []*Termination `json:"terminations"`
+	Omitted      int            `json:"omitted,omitempty"`
+}
+
+// NodeTerminations lists the kernel OOM kills of a node that could not be
+// attributed to a container.
+type NodeTerminations struct {
+	Node         string         `json:"node"`
+	Terminations []*Termination `json:"terminations"`
+}
+
+func newTerminations(st *stitcher) *terminations {
+	return &terminations{
+		stitcher:   st,
+		containers: map[string]*ContainerTerminations{},
+		nodes:      map[string]*NodeTerminations{},
+		seen:       map[string]bool{},
+		pods:       map[string][2]string{},
+		byID:       map[string][3]string{},
+	}
+}
+
+func (t *terminations) container(ns, pod, container string) *ContainerTerminations {
+	key := ns + "/" + pod + "/" + container
+	c, ok := t.containers[key]
+	if !ok {
+		c = &ContainerTerminations{Namespace: ns, Pod: pod, Container: container, ExitCodes: map[string]int{}}
+		t.containers[key] = c
+	}
+	return c
+}
+
+// add records tm for a container, anchoring it to the row's stitched line.
+func (t *terminations) add(c *ContainerTerminations, tm *Termination) {
+	tm.anchor = t.stitcher.anchorNext()
+	c.Terminations = append(c.Terminations, tm)
+}
+
+func (t *terminations) observe(table string, row map[string]any) {
+	switch table {
+	case "KubePodInventory":
+		t.observePod(row)
+	case "KubeEvents":
+		t.observeEvent(row)
+	case "ContainerLogV2":
+		t.observeLog(row)
+	case "Syslog":
+		t.observeSyslog(row)
+	}
+}
+
+func (t *terminations) observePod(row map[string]any) {
+	ns, pod := toStr(row["Namespace"]), toStr(row["Name"])
+	cn := podContainerName(toStr(row["ContainerName"]))
+	if uid := toStr(row["PodUid"]); uid != "" {
+		t.pods[uid] = [2]string{ns, pod}
+	}
+	if id := containerID(toStr(row["ContainerID"])); id != "" && cn != "" {
+		t.byID[id] = [3]string{ns, pod, cn}
+	}
+	last, _ := dynamicValue(row["ContainerLastStatus"]).(map[string]any)
+	reason, finished := toStr(last["reason"]), toStr(last["finishedAt"])
+	if cn == "" || finished == "" {
+		return
+	}
+	key := ns + "/" + pod + "/" + cn + "/" + finished
+	if t.seen[key] {
+		return
+	}
+	t.seen[key] = true
+	c := t.container(ns, pod, cn)
+	c.Node = toStr(row["Computer"])
+	tm := &Termination{Time: finished, Kind: terminationExit, Source: "KubePodInventory", Reason: reason, Message: "last state terminated"}
+	if reason != "" {
+		tm.Message += ": " + reason
+	}
+	if code, ok := toFloat(last["exitCode"]); ok {
+		n := int64(code)
+		tm.ExitCode = &n
+		tm.Signal = exitSignals[n]
+		tm.Message += " (exit code " + strconv.FormatInt(n, 10) + ")"
+	}
+	if strings.EqualFold(reason, "OOMKilled") {
+		tm.Kind = terminationOOM
+	}
+	// Inventory rows are not stitched
+	c.Terminations = append(c.Terminations, tm)
+}
+
+func (t *terminations) observeEvent(row map[string]any) {
+	reason, msg := toStr(row["Reason"]), toStr(row["Message"])
+	tm := &Termination{Time: toStr(row["TimeGenerated"]), Source: "KubeEvents", Reason: reason, Message: truncateSample(msg)}
+	switch {
+	case strings.EqualFold(toStr(row["ObjectKind"]), "Node"):
+		// The kubelet reports kernel OOM kills as OOMKilling node events
+		if !strings.EqualFold(reason, "OOMKilling") {
+			return
+		}
+		tm.Kind = terminationOOM
+		node := toStr(row["Name"])
+		n, ok := t.nodes[node]
+		if !ok {
+			n = &NodeTerminations{Node: node}
+			t.nodes[node] = n
+		}
+		tm.anchor = t.stitcher.anchorNext()
+		n.Terminations = append(n.Terminations, tm)
+		return
+	case !strings.EqualFold(toStr(row["ObjectKind"]), "Pod"):
+		return
+	case isOOMEvent(reason, msg):
+		tm.Kind = terminationOOM
+	case reason == "Killing":
+		tm.Kind = terminationKill
+	case reason == "Evicted":
+		tm.Kind = terminationEviction
+	default:
+		return
+	}
+	cn := ""
+	if m := eventContainer.FindStringSubmatch(msg); m != nil {
+		cn = m[1]
+	}
+	t.add(t.container(toStr(row["Namespace"]), toStr(row["Name"]), cn), tm)
+}
+
+func (t *terminations) observeLog(row map[string]any) {
+	if t.logCols == nil {
+		cols, _ := resolveContainerLogColumns(row)
+		t.logCols = &cols
+	}
+	if !t.logCols.ok || !t.logCols.byPod {
+		return
+	}
+	msg := stitchMessage(row[t.logCols.message])
+	tm := &Termination{Time: toStr(row["TimeGenerated"]), Source: "ContainerLogV2", Message: truncateSample(msg)}
+	if m := logOOM.FindString(msg); m != "" {
+		tm.Kind, tm.Reason = terminationOOM, m
+	} else if m := logExitCode.FindStringSubmatch(msg); m != nil {
+		n, _ := strconv.ParseInt(m[1], 10, 64)
+		tm.Kind, tm.ExitCode, tm.Signal = terminationExit, &n, exitSignals[n]
+	} else if m := logSignal.FindStringSubmatch(msg); m != nil {
+		tm.Kind, tm.Signal = terminationSignal, strings.ToUpper(m[1])
+		if m[2] != "" {
+			tm.Signal = map[string]string{"killed": "SIGKILL", "terminated": "SIGTERM"}[strings.ToLower(m[2])]
+		}
+	} else {
+		return
+	}
+	ns, pod, cn := toStr(row["PodNamespace"]), toStr(row["PodName"]), toStr(row[t.logCols.container])
+	if pod == "" || cn == "" {
+		return
+	}
+	c := t.container(ns, pod, cn)
+	if node := toStr(row["Computer"]); node != "" {
+		c.Node = node
+	}
+	t.add(c, tm)
+}
+
+func (t *terminations) observeSyslog(row map[string]any) {
+	msg := toStr(row["SyslogMessage"])
+	k := &kernelOOM{node: toStr(row["Computer"])}
+	if m := kernelOOMKill.FindStringSubmatch(msg); m != nil {
+		k.detail, k.pid = true, m[3]
+		if u := cgroupPodUID.FindStringSubmatch(m[1]); u != nil {
+			k.podUID = strings.ReplaceAll(u[1], "_", "-")
+		}
+		if c := cgroupContainer.FindStringSubmatch(m[1]); c != nil {
+			k.containerID = c[1]
+		}
+		k.t = &Termination{Reason: "oom-kill " + m[2]}
+	} else if m := kernelOOMKilled.FindStringSubmatch(msg); m != nil {
+		k.pid = m[1]
+		k.t = &Termination{Reason: "killed process " + m[2]}
+	} else {
+		return
+	}
+	k.t.Time, k.t.Kind, k.t.Source, k.t.Signal, k.t.Message = toStr(row["TimeGenerated"]), terminationOOM, "Syslog", "SIGKILL", truncateSample(msg)
+	k.t.anchor = t.stitcher.anchorNext()
+	t.kernel = append(t.kernel, k)
+}
+
+// attributeKernel files the kernel OOM kills under their containers, or their
+// nodes. A "Killed process" line is dropped when an oom-kill line reported
+// the same process.
+func (t *terminations) attributeKernel() {
+	detailed := map[string]bool{}
+	for _, k := range t.kernel {
+		if k.detail {
+			detailed[k.node+"/"+k.pid] = true
+		}
+	}
+	for _, k := range t.kernel {
+		if !k.detail && detailed[k.node+"/"+k.pid] {
+			continue
+		}
+		if c, ok := t.byID[k.containerID]; ok {
+			ct := t.container(c[0], c[1], c[2])
+			ct.Node = k.node
+			ct.Terminations = append(ct.Terminations, k.t)
+			continue
+		}
+		if p, ok := t.pods[k.podUID]; ok {
+			ct := t.container(p[0], p[1], "")
+			ct.Node = k.node
+			ct.Terminations = append(ct.Terminations, k.t)
+			continue
+		}
+		n, ok := t.nodes[k.node]
+		if !ok {
+			n = &NodeTerminations{Node: k.node}
+			t.nodes[k.node] = n
+		}
+		n.Terminations = append(n.Terminations, k.t)
+	}
+	t.kernel = nil
+}
+
+func (t *terminations) endChunk(tarw *utils.TarWriter) error {
+	return nil
+}
+
+func (t *terminations) warnings(table string) []string {
+	return nil
+}
+
+// link fills in where tm's row is in the archive.
+func (t *terminations) link(tm *Termination) {
+	if f := t.stitcher.file(tm.anchor); f != "" {
+		tm.File, tm.Line = f, tm.anchor.line
+		return
+	}
+	tm.File = path.Join("tables", utils.SafeFileName(tm.Source), "parts") + "/"
+}
+
+func (t *terminations) finish(tarw *utils.TarWriter) error {
+	t.attributeKernel()
+	containers := make([]*ContainerTerminations, 0, len(t.containers))
+	for _, c := range t.containers {
+		sortTerminations(c.Terminations)
+		if n := len(c.Terminations); n > terminationsPerContainer {
+			c.Omitted = n - terminationsPerContainer
+			c.Terminations = c.Terminations[c.Omitted:]
+		}
+		for _, tm := range c.Terminations {
+			t.link(tm)
+			if tm.Kind == terminationOOM {
+				c.OOMKills++
+			}
+			if tm.ExitCode != nil {
+				c.ExitCodes[strconv.FormatInt(*tm.ExitCode, 10)]++
+			}
+		}
+		containers = append(containers, c)
+	}
+	sort.Slice(containers, func(i, j int) bool {
+		a, b := containers[i], containers[j]
+		return a.Namespace+"/"+a.Pod+"/"+a.Container < b.Namespace+"/"+b.Pod+"/"+b.Container
+	})
+	nodes := make([]*NodeTerminations, 0, len(t.nodes))
+	for _, n := range t.nodes {
+		sortTerminations(n.Terminations)
+		for _, tm := range n.Terminations {
+			t.link(tm)
+		}
+		nodes = append(nodes, n)
+	}
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].Node < nodes[j].Node })
+	b, err := json.MarshalIndent(map[string]any{"containers": containers, "nodes": nodes}, "", "  ")
+	if err != nil {
+		return err
+	}
+	return utils.WriteFileToTar(tarw, terminationsPath, b)
+}
+
+func sortTerminations(l []*Termination) {
+	sort.SliceStable(l, func(i, j int) bool { return timeBefore(l[i].Time, l[j].Time) })
+}
+
+// containerID strips the runtime prefix of a container ID, e.g.
+// containerd://<id>.
+func containerID(v string) string {
+	if i := strings.LastIndex(v, "://"); i >= 0 {
+		return v[i+3:]
+	}
+	return v
+}
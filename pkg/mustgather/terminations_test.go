@@ -0,0 +1,142 @@
+package mustgather
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestTerminations(t *testing.T) {
+	const (
+		uid = "3f2a9c1e-5b7d-4e8f-9a0b-1c2d3e4f5a6b"
+		cid = "0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+	)
+	st := newStitcher(&Config{StitchLogs: true, StitchIncludeEvents: true}, nil)
+	term := newTerminations(st)
+	feed := func(table string, rows ...map[string]any) {
+		for _, row := range rows {
+			term.observe(table, row)
+			st.observe(table, row)
+		}
+		if err := st.endChunk(nil); err != nil {
+			t.Fatalf("endChunk failed: %v", err)
+		}
+	}
+	log := func(tm, pod, cn, msg string) map[string]any {
+		return map[string]any{"TimeGenerated": tm, "PodNamespace": "shop", "PodName": pod, "ContainerName": cn,
+			"ContainerId": "c-" + pod, "LogSource": "stdout", "LogMessage": msg, "Computer": "node-1"}
+	}
+	// Syslog comes before the inventory that attributes its OOM kills
+	feed("Syslog",
+		map[string]any{"TimeGenerated": "2024-01-01T00:09:58Z", "Computer": "node-1", "ProcessName": "kernel",
+			"SyslogMessage": "oom-kill:constraint=CONSTRAINT_MEMCG,nodemask=(null),oom_memcg=/kubepods/burstable/pod" + strings.ReplaceAll(uid, "-", "_") +
+				",task_memcg=/kubepods/burstable/pod" + strings.ReplaceAll(uid, "-", "_") + "/" + cid + ",task=java,pid=4242,uid=0"},
+		map[string]any{"TimeGenerated": "2024-01-01T00:09:58Z", "Computer": "node-1", "ProcessName": "kernel",
+			"SyslogMessage": "Memory cgroup out of memory: Killed process 4242 (java) total-vm:1234kB"},
+		map[string]any{"TimeGenerated": "2024-01-01T00:20:00Z", "Computer": "node-2", "ProcessName": "kernel",
+			"SyslogMessage": "Out of memory: Killed process 77 (stress) total-vm:99kB"},
+		map[string]any{"TimeGenerated": "2024-01-01T00:20:01Z", "Computer": "node-2", "ProcessName": "kubelet", "SyslogMessage": "all good"},
+	)
+	feed("ContainerLogV2",
+		log("2024-01-01T00:09:00Z", "web-1", "web", "starting"),
+		log("2024-01-01T00:09:30Z", "web-1", "web", "java.lang.OutOfMemoryError: Java heap space"),
+		log("2024-01-01T00:09:10Z", "web-1", "web", "serving"),
+		log("2024-01-01T00:05:00Z", "cart-1", "cart", "worker exited with code 2"),
+		log("2024-01-01T00:06:00Z", "cart-1", "cart", "received SIGTERM, shutting down"),
+	)
+	feed("KubeEvents",
+		map[string]any{"TimeGenerated": "2024-01-01T00:11:00Z", "Namespace": "shop", "Name": "web-1", "ObjectKind": "Pod",
+			"Reason": "Killing", "Message": "Stopping container web"},
+		map[string]any{"TimeGenerated": "2024-01-01T00:12:00Z", "Namespace": "", "Name": "node-2", "ObjectKind": "Node",
+			"Reason": "OOMKilling", "Message": "Memory cgroup out of memory: Killed process 77 (stress)"},
+		map[string]any{"TimeGenerated": "2024-01-01T00:12:00Z", "Namespace": "shop", "Name": "web-1", "ObjectKind": "Pod",
+			"Reason": "Pulled", "Message": "Container image pulled"},
+	)
+	pod := map[string]any{"TimeGenerated": "2024-01-01T00:15:00Z", "Namespace": "shop", "Name": "web-1", "ContainerName": uid + "/web",
+		"PodUid": uid, "ContainerID": "containerd://" + cid, "Computer": "node-1",
+		"ContainerLastStatus": `{"reason":"OOMKilled","exitCode":137,"finishedAt":"2024-01-01T00:10:00Z"}`}
+	// Every snapshot repeats the last state
+	feed("KubePodInventory", pod, pod)
+
+	got := readTransform(t, term)[terminationsPath]
+	stitched := readTransform(t, st)
+	if want := "2024-01-01T00:09:58Z [-] kernel: oom-kill:"; !strings.HasPrefix(stitched["nodes/node-1/syslog.log"], want) {
+		t.Errorf("nodes/node-1/syslog.log should start with %q:\n%s", want, stitched["nodes/node-1/syslog.log"])
+	}
+	var out struct {
+		Containers []*ContainerTerminations `json:"containers"`
+		Nodes      []*NodeTerminations      `json:"nodes"`
+	}
+	if err := json.Unmarshal([]byte(got), &out); err != nil {
+		t.Fatalf("parse %s: %v\n%s", terminationsPath, err, got)
+	}
+	format := func(l []*Termination) []string {
+		var s []string
+		for _, tm := range l {
+			e := fmt.Sprintf("%s %s %s %s:%d", tm.Time, tm.Kind, tm.Source, tm.File, tm.Line)
+			if tm.ExitCode != nil {
+				e += fmt.Sprintf(" exit=%d", *tm.ExitCode)
+			}
+			if tm.Signal != "" {
+				e += " " + tm.Signal
+			}
+			s = append(s, e)
+		}
+		return s
+	}
+	byName := map[string]*ContainerTerminations{}
+	for _, c := range out.Containers {
+		byName[c.Pod+"/"+c.Container] = c
+	}
+	for _, tc := range []struct {
+		name string
+		got  []*Termination
+		want []string
+	}{
+		{"web-1/web", byName["web-1/web"].Terminations, []string{
+			"2024-01-01T00:09:30Z oom-kill ContainerLogV2 namespaces/shop/pods/web-1/web.log:3",
+			"2024-01-01T00:09:58Z oom-kill Syslog nodes/node-1/syslog.log:1 SIGKILL",
+			"2024-01-01T00:10:00Z oom-kill KubePodInventory tables/KubePodInventory/parts/:0 exit=137 SIGKILL",
+			"2024-01-01T00:11:00Z kill KubeEvents namespaces/shop/pods/web-1/events.log:1",
+		}},
+		{"cart-1/cart", byName["cart-1/cart"].Terminations, []string{
+			"2024-01-01T00:05:00Z exit ContainerLogV2 namespaces/shop/pods/cart-1/cart.log:1 exit=2",
+			"2024-01-01T00:06:00Z signal ContainerLogV2 namespaces/shop/pods/cart-1/cart.log:2 SIGTERM",
+		}},
+	} {
+		if g := format(tc.got); fmt.Sprint(g) != fmt.Sprint(tc.want) {
+			t.Errorf("%s terminations\n got %q\nwant %q", tc.name, g, tc.want)
+		}
+	}
+	if len(out.Containers) != 2 {
+		t.Errorf("expected web and cart only, got %d containers", len(out.Containers))
+	}
+	if c := byName["web-1/web"]; c.OOMKills != 3 || c.ExitCodes["137"] != 1 || c.Node != "node-1" {
+		t.Errorf("web-1/web = %d OOM kills, exit codes %v, node %q", c.OOMKills, c.ExitCodes, c.Node)
+	}
+
+	if len(out.Nodes) != 1 || out.Nodes[0].Node != "node-2" {
+		t.Fatalf("expected the unattributed OOM kills of node-2, got %+v", out.Nodes)
+	}
+	if g, want := format(out.Nodes[0].Terminations), []string{
+		"2024-01-01T00:12:00Z oom-kill KubeEvents namespaces/default/events/events.log:1",
+		"2024-01-01T00:20:00Z oom-kill Syslog nodes/node-2/syslog.log:1 SIGKILL",
+	}; fmt.Sprint(g) != fmt.Sprint(want) {
+		t.Errorf("node-2 terminations\n got %q\nwant %q", g, want)
+	}
+}
+
+func TestTerminationsWithoutStitching(t *testing.T) {
+	st := newStitcher(&Config{}, nil)
+	term := newTerminations(st)
+	row := map[string]any{"TimeGenerated": "2024-01-01T00:05:00Z", "PodNamespace": "shop", "PodName": "cart-1", "ContainerName": "cart",
+		"LogMessage": "fatal error: runtime: out of memory"}
+	term.observe("ContainerLogV2", row)
+	st.observe("ContainerLogV2", row)
+
+	got := readTransform(t, term)[terminationsPath]
+	if !strings.Contains(got, `"file": "tables/ContainerLogV2/parts/"`) || strings.Contains(got, `"line"`) {
+		t.Errorf("expected a link to the table parts without a line:\n%s", got)
+	}
+}
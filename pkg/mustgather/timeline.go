@@ -0,0 +1,245 @@
+package mustgather
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"kubectl-must-gather/pkg/bundle"
+	"kubectl-must-gather/pkg/utils"
+)
+
+// DefaultTimelineWindow is how far either side of --around the timeline
+// reaches when --window is not set.
+const DefaultTimelineWindow = 10 * time.Minute
+
+// Kinds of timeline entries.
+const (
+	TimelineEvent = "event"
+	TimelineError = "error"
+	TimelineNode  = "node"
+)
+
+// timelineColors color the kind of an entry: warnings and errors red,
+// normal events dim, node conditions yellow.
+var timelineColors = map[string]string{
+	TimelineEvent: "\x1b[2m",
+	"warning":     "\x1b[31m",
+	TimelineError: "\x1b[31m",
+	TimelineNode:  "\x1b[33m",
+}
+
+// TimelineOptions selects the entries Timeline renders.
+type TimelineOptions struct {
+	// Namespace is a regular expression of the namespaces of events and
+	// error logs; node conditions are cluster-wide and always shown.
+	Namespace string
+	// Around is a time (RFC 3339, or UTC like 2024-01-01T15:04) to center
+	// the timeline on, Window either side of it.
+	Around string
+	Window time.Duration
+	// Kinds limits the entries to TimelineEvent, TimelineError and
+	// TimelineNode; empty shows all.
+	Kinds []string
+	// WarningsOnly leaves out Normal events.
+	WarningsOnly bool
+	// Grep keeps only entries whose object or text match this regular
+	// expression.
+	Grep  string
+	Color bool
+}
+
+// timelineEntry is a line of the timeline.
+type timelineEntry struct {
+	tm        time.Time
+	kind      string
+	warning   bool
+	namespace string
+	object    string
+	text      string
+}
+
+// Timeline merges the KubeEvents, error-level ContainerLogV2 lines (counted
+// per container and minute) and node condition changes of the bundle at src
+// in time order and writes those matching opts to w, one per line. It
+// returns the number of entries written.
+func Timeline(src string, opts TimelineOptions, w io.Writer) (int, error) {
+	var ns, grep *regexp.Regexp
+	var err error
+	if opts.Namespace != "" {
+		if ns, err = regexp.Compile(opts.Namespace); err != nil {
+			return 0, fmt.Errorf("invalid namespace pattern: %w", err)
+		}
+	}
+	if opts.Grep != "" {
+		if grep, err = regexp.Compile(opts.Grep); err != nil {
+			return 0, fmt.Errorf("invalid grep pattern: %w", err)
+		}
+	}
+	kinds := map[string]bool{}
+	for _, k := range opts.Kinds {
+		switch k {
+		case TimelineEvent, TimelineError, TimelineNode:
+			kinds[k] = true
+		default:
+			return 0, fmt.Errorf("invalid kind %q: expected %s, %s or %s", k, TimelineEvent, TimelineError, TimelineNode)
+		}
+	}
+	var win serveWindow
+	if opts.Around != "" {
+		around, err := parseServeTime(opts.Around)
+		if err != nil {
+			return 0, fmt.Errorf("invalid --around: %w", err)
+		}
+		window := opts.Window
+		if window <= 0 {
+			window = DefaultTimelineWindow
+		}
+		win.since, win.until = around.Add(-window), around.Add(window)
+	} else if opts.Window > 0 {
+		return 0, errors.New("--window needs --around")
+	}
+
+	b, err := bundle.Open(src)
+	if err != nil {
+		return 0, fmt.Errorf("open bundle: %w", err)
+	}
+	defer b.Close()
+	tables, err := b.Tables()
+	if err != nil {
+		return 0, fmt.Errorf("list tables: %w", err)
+	}
+	entries, err := timelineEntries(b, tables)
+	if err != nil {
+		return 0, err
+	}
+	if len(entries) == 0 {
+		return 0, fmt.Errorf("no KubeEvents, ContainerLogV2 or KubeNodeInventory rows in %s", src)
+	}
+
+	bw := bufio.NewWriter(w)
+	defer bw.Flush()
+	n := 0
+	for _, e := range entries {
+		switch {
+		case len(kinds) > 0 && !kinds[e.kind],
+			!win.contains(e.tm),
+			ns != nil && e.kind != TimelineNode && !ns.MatchString(e.namespace),
+			opts.WarningsOnly && e.kind == TimelineEvent && !e.warning,
+			grep != nil && !grep.MatchString(e.object) && !grep.MatchString(e.text):
+			continue
+		}
+		kind := strings.ToUpper(e.kind)
+		if opts.Color {
+			color := timelineColors[e.kind]
+			if e.warning {
+				color = timelineColors["warning"]
+			}
+			kind = color + kind + tailReset
+		}
+		scope := e.object
+		if e.namespace != "" {
+			scope = e.namespace + " " + e.object
+		}
+		fmt.Fprintf(bw, "%s %s %s %s\n", e.tm.UTC().Format(time.RFC3339), kind, scope, e.text)
+		n++
+	}
+	return n, nil
+}
+
+// timelineEntries reads the entries of every kind from the tables of b, in
+// time order.
+func timelineEntries(b *bundle.Bundle, tables []bundle.Table) ([]timelineEntry, error) {
+	var entries []timelineEntry
+	type errorMinute struct {
+		entry timelineEntry
+		count int
+	}
+	errs := map[string]*errorMinute{}
+	nodes := newNodeConditions()
+	for _, t := range tables {
+		var observe func(row map[string]any)
+		switch t.Name {
+		case "KubeEvents":
+			observe = func(row map[string]any) {
+				nodes.observe(t.Name, row)
+				tm := utils.ParseTimeRFC3339(toStr(row["TimeGenerated"]))
+				if tm.IsZero() {
+					return
+				}
+				typ := toStr(row["KubeEventType"])
+				entries = append(entries, timelineEntry{
+					tm:        tm,
+					kind:      TimelineEvent,
+					warning:   strings.EqualFold(typ, "Warning"),
+					namespace: toStr(row["Namespace"]),
+					object:    strings.TrimPrefix(toStr(row["ObjectKind"])+"/"+toStr(row["Name"]), "/"),
+					text:      strings.TrimSpace(typ + " " + toStr(row["Reason"]) + ": " + truncateSample(stitchMessage(row["Message"]))),
+				})
+			}
+		case "ContainerLogV2":
+			observe = func(row map[string]any) {
+				msg, ok := errorLog(row)
+				if !ok {
+					return
+				}
+				tm := utils.ParseTimeRFC3339(toStr(row["TimeGenerated"]))
+				if tm.IsZero() {
+					return
+				}
+				ns, object := toStr(row["PodNamespace"]), toStr(row["PodName"])+"/"+toStr(row["ContainerName"])
+				key := ns + "/" + object + "/" + tm.Truncate(time.Minute).Format(time.RFC3339)
+				m, ok := errs[key]
+				if !ok {
+					m = &errorMinute{entry: timelineEntry{tm: tm, kind: TimelineError, namespace: ns, object: object, text: truncateSample(msg)}}
+					errs[key] = m
+				}
+				if tm.Before(m.entry.tm) {
+					m.entry.tm, m.entry.text = tm, truncateSample(msg)
+				}
+				m.count++
+			}
+		case "KubeNodeInventory":
+			observe = func(row map[string]any) { nodes.observe(t.Name, row) }
+		default:
+			continue
+		}
+		for _, part := range t.Parts {
+			if err := b.ReadPart(part, func(row map[string]any) error { observe(row); return nil }); err != nil {
+				return nil, fmt.Errorf("read %s: %w", part, err)
+			}
+		}
+	}
+	for _, m := range errs {
+		if m.count > 1 {
+			m.entry.text = fmt.Sprintf("%d error lines this minute, first: %s", m.count, m.entry.text)
+		}
+		entries = append(entries, m.entry)
+	}
+	for name, t := range nodes.nodes {
+		for _, tr := range t.changes() {
+			entries = append(entries, timelineEntry{
+				tm:      tr.tm,
+				kind:    TimelineNode,
+				warning: conditionProblem(tr.condition, tr.to == "True"),
+				object:  "Node/" + name,
+				text:    fmt.Sprintf("%s %s -> %s (%s)", tr.condition, tr.from, tr.to, tr.source),
+			})
+		}
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		if !entries[i].tm.Equal(entries[j].tm) {
+			return entries[i].tm.Before(entries[j].tm)
+		}
+		if entries[i].kind != entries[j].kind {
+			return entries[i].kind > entries[j].kind
+		}
+		return entries[i].object < entries[j].object
+	})
+	return entries, nil
+}
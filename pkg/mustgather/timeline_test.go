@@ -0,0 +1,74 @@
+package mustgather
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTimeline(t *testing.T) {
+	src := writeBundleDir(t, map[string]string{
+		"index.json": `{"tables":["KubeEvents","ContainerLogV2","KubeNodeInventory"]}`,
+		"tables/KubeEvents/parts/0000-a.ndjson": strings.Join([]string{
+			`{"TimeGenerated":"2024-01-01T00:01:00Z","Namespace":"shop","Name":"cart-1","ObjectKind":"Pod","Reason":"Scheduled","Message":"assigned","KubeEventType":"Normal"}`,
+			`{"TimeGenerated":"2024-01-01T00:05:00Z","Namespace":"shop","Name":"cart-1","ObjectKind":"Pod","Reason":"BackOff","Message":"Back-off restarting failed container","KubeEventType":"Warning"}`,
+			`{"TimeGenerated":"2024-01-01T00:30:00Z","Namespace":"web","Name":"web-1","ObjectKind":"Pod","Reason":"Killing","Message":"Stopping container","KubeEventType":"Normal"}`,
+		}, "\n") + "\n",
+		"tables/ContainerLogV2/parts/0000-a.ndjson": strings.Join([]string{
+			`{"TimeGenerated":"2024-01-01T00:04:10Z","PodNamespace":"shop","PodName":"cart-1","ContainerName":"cart","LogMessage":"panic: boom"}`,
+			`{"TimeGenerated":"2024-01-01T00:04:20Z","PodNamespace":"shop","PodName":"cart-1","ContainerName":"cart","LogMessage":"error: again"}`,
+			`{"TimeGenerated":"2024-01-01T00:04:30Z","PodNamespace":"shop","PodName":"cart-1","ContainerName":"cart","LogMessage":"all good"}`,
+		}, "\n") + "\n",
+		"tables/KubeNodeInventory/parts/0000-a.ndjson": strings.Join([]string{
+			`{"TimeGenerated":"2024-01-01T00:00:00Z","Computer":"aks-node-1","Status":"Ready"}`,
+			`{"TimeGenerated":"2024-01-01T00:03:00Z","Computer":"aks-node-1","Status":"MemoryPressure,Ready"}`,
+		}, "\n") + "\n",
+	})
+
+	tests := []struct {
+		name      string
+		opts      TimelineOptions
+		want      string
+		expectErr string
+	}{
+		{
+			name: "around a time in a namespace",
+			opts: TimelineOptions{Namespace: "^shop$", Around: "2024-01-01T00:04", Window: 2 * time.Minute},
+			want: `2024-01-01T00:03:00Z NODE Node/aks-node-1 MemoryPressure False -> True (inventory)
+2024-01-01T00:04:10Z ERROR shop cart-1/cart 2 error lines this minute, first: panic: boom
+2024-01-01T00:05:00Z EVENT shop Pod/cart-1 Warning BackOff: Back-off restarting failed container
+`,
+		},
+		{
+			name: "warning events only",
+			opts: TimelineOptions{Kinds: []string{TimelineEvent}, WarningsOnly: true},
+			want: "2024-01-01T00:05:00Z EVENT shop Pod/cart-1 Warning BackOff: Back-off restarting failed container\n",
+		},
+		{
+			name: "grep",
+			opts: TimelineOptions{Grep: "Stopping"},
+			want: "2024-01-01T00:30:00Z EVENT web Pod/web-1 Normal Killing: Stopping container\n",
+		},
+		{name: "unknown kind", opts: TimelineOptions{Kinds: []string{"logs"}}, expectErr: "invalid kind"},
+		{name: "window without around", opts: TimelineOptions{Window: time.Minute}, expectErr: "--around"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			_, err := Timeline(src, tt.opts, &out)
+			if tt.expectErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.expectErr) {
+					t.Fatalf("Timeline() error = %v, want %q", err, tt.expectErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if out.String() != tt.want {
+				t.Errorf("Timeline() =\n%s\nwant\n%s", out.String(), tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,315 @@
+package mustgather
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/arm"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+	azquery "github.com/Azure/azure-sdk-for-go/sdk/monitor/azquery"
+	armoperationalinsights "github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/operationalinsights/armoperationalinsights"
+	"gopkg.in/yaml.v3"
+
+	"kubectl-must-gather/pkg/utils"
+)
+
+// DefaultTriggerInterval is how often a watch with trigger rules counts
+// events when --interval is not set.
+const DefaultTriggerInterval = time.Minute
+
+// TriggerRule starts a gather of a watch when a namespace sees more than
+// Threshold Kubernetes events of Reason within Window. Namespace limits the
+// rule to one namespace; when empty, each namespace is counted on its own.
+// The gather exports the Profiles or Tables of the rule, scoped to the
+// namespaces that crossed the threshold, over Timespan.
+type TriggerRule struct {
+	Name      string   `yaml:"name"`
+	Reason    string   `yaml:"reason"`
+	Namespace string   `yaml:"namespace"`
+	Threshold int      `yaml:"threshold"`
+	Window    string   `yaml:"window"`
+	Profiles  []string `yaml:"profiles"`
+	Tables    []string `yaml:"tables"`
+	// Timespan is the window gathered, ending when the rule fired; the
+	// Timespan of the Config when empty.
+	Timespan string `yaml:"timespan"`
+	// Cooldown is how long the rule stays quiet after firing, so a
+	// continuing storm of events does not gather every interval; Window
+	// when empty.
+	Cooldown string `yaml:"cooldown"`
+
+	window, cooldown time.Duration
+}
+
+// TriggerRules are the rules of a --trigger-rules file.
+type TriggerRules struct {
+	Rules []TriggerRule `yaml:"triggers"`
+}
+
+// LoadTriggerRules reads a trigger rules file, e.g.
+//
+//	triggers:
+//	  - name: payments-backoff
+//	    reason: BackOff
+//	    namespace: payments
+//	    threshold: 5
+//	    window: 10m
+//	    profiles: [podLogs]
+//
+// An empty path returns nil.
+func LoadTriggerRules(path string) (*TriggerRules, error) {
+	if path == "" {
+		return nil, nil
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read trigger rules: %w", err)
+	}
+	var r TriggerRules
+	dec := yaml.NewDecoder(strings.NewReader(string(b)))
+	dec.KnownFields(true)
+	if err := dec.Decode(&r); err != nil {
+		return nil, fmt.Errorf("parse trigger rules %s: %w", path, err)
+	}
+	if err := r.compile(); err != nil {
+		return nil, fmt.Errorf("trigger rules %s: %w", path, err)
+	}
+	return &r, nil
+}
+
+// compile checks every rule and parses its durations.
+func (r *TriggerRules) compile() error {
+	if len(r.Rules) == 0 {
+		return errors.New("no triggers")
+	}
+	seen := map[string]bool{}
+	profiles := GetDefaultProfiles()
+	for i := range r.Rules {
+		rule := &r.Rules[i]
+		switch {
+		case rule.Name == "":
+			return fmt.Errorf("trigger %d has no name", i+1)
+		case seen[rule.Name]:
+			return fmt.Errorf("trigger %s is defined twice", rule.Name)
+		case rule.Reason == "":
+			return fmt.Errorf("trigger %s needs the reason of the events to count", rule.Name)
+		case rule.Threshold < 0:
+			return fmt.Errorf("trigger %s has a negative threshold", rule.Name)
+		case rule.Window == "":
+			return fmt.Errorf("trigger %s needs a window, e.g. 10m", rule.Name)
+		case len(rule.Profiles) > 0 && len(rule.Tables) > 0:
+			return fmt.Errorf("trigger %s names both profiles and tables", rule.Name)
+		}
+		seen[rule.Name] = true
+		var err error
+		if rule.window, err = time.ParseDuration(rule.Window); err != nil || rule.window < time.Minute {
+			return fmt.Errorf("trigger %s: invalid window %q: expected a duration of a minute or more, e.g. 10m", rule.Name, rule.Window)
+		}
+		rule.cooldown = rule.window
+		if rule.Cooldown != "" {
+			if rule.cooldown, err = time.ParseDuration(rule.Cooldown); err != nil || rule.cooldown < 0 {
+				return fmt.Errorf("trigger %s: invalid cooldown %q", rule.Name, rule.Cooldown)
+			}
+		}
+		if rule.Timespan != "" {
+			if _, err := utils.ISO8601Duration(rule.Timespan); err != nil {
+				return fmt.Errorf("trigger %s: invalid timespan: %w", rule.Name, err)
+			}
+		}
+		if err := checkProfiles(strings.Join(rule.Profiles, ","), profiles); err != nil {
+			return fmt.Errorf("trigger %s: %w", rule.Name, err)
+		}
+	}
+	return nil
+}
+
+// maxWindow is the longest window of the rules, which the event count covers.
+func (r *TriggerRules) maxWindow() time.Duration {
+	var w time.Duration
+	for _, rule := range r.Rules {
+		w = max(w, rule.window)
+	}
+	return w
+}
+
+// eventCountsQuery counts the events of the rules' reasons per namespace,
+// reason and minute.
+func (r *TriggerRules) eventCountsQuery() string {
+	seen := map[string]bool{}
+	var reasons []string
+	for _, rule := range r.Rules {
+		if !seen[strings.ToLower(rule.Reason)] {
+			seen[strings.ToLower(rule.Reason)] = true
+			reasons = append(reasons, kqlString(rule.Reason))
+		}
+	}
+	return "KubeEvents | where Reason in~ (" + strings.Join(reasons, ", ") + ")\n" +
+		"| summarize Count=count() by Namespace, Reason, Minute=bin(TimeGenerated, 1m)"
+}
+
+// eventCount is a row of eventCountsQuery.
+type eventCount struct {
+	namespace, reason string
+	minute            time.Time
+	count             int
+}
+
+// triggerFire is a rule that crossed its threshold and the namespaces that
+// made it.
+type triggerFire struct {
+	rule       *TriggerRule
+	namespaces []string
+	events     int
+}
+
+// evaluate returns the rules whose threshold the counts cross at now, leaving
+// out those fired within their cooldown as recorded in fired. A minute counts
+// toward a window when it ends inside it.
+func (r *TriggerRules) evaluate(counts []eventCount, fired map[string]string, now time.Time) []triggerFire {
+	var fires []triggerFire
+	for i := range r.Rules {
+		rule := &r.Rules[i]
+		if last, err := time.Parse(time.RFC3339, fired[rule.Name]); err == nil && now.Sub(last) < rule.cooldown {
+			continue
+		}
+		perNamespace := map[string]int{}
+		from := now.Add(-rule.window)
+		for _, c := range counts {
+			if !strings.EqualFold(c.reason, rule.Reason) || !c.minute.Add(time.Minute).After(from) {
+				continue
+			}
+			if rule.Namespace != "" && c.namespace != rule.Namespace {
+				continue
+			}
+			perNamespace[c.namespace] += c.count
+		}
+		f := triggerFire{rule: rule}
+		for ns, n := range perNamespace {
+			if n > rule.Threshold {
+				f.namespaces = append(f.namespaces, ns)
+				f.events += n
+			}
+		}
+		if len(f.namespaces) > 0 {
+			sort.Strings(f.namespaces)
+			fires = append(fires, f)
+		}
+	}
+	return fires
+}
+
+// apply sets up config for the gather of a fired rule: its tables or
+// profiles, the namespaces that crossed the threshold (only those of the
+// event column, cluster-wide events have none) and its timespan.
+func (f triggerFire) apply(config *Config) {
+	if len(f.rule.Tables) > 0 {
+		config.TableFilter, config.Profiles, config.AllTables = strings.Join(f.rule.Tables, ","), "", false
+	} else if len(f.rule.Profiles) > 0 {
+		config.TableFilter, config.Profiles, config.AllTables = "", strings.Join(f.rule.Profiles, ","), false
+	}
+	var namespaces []string
+	for _, ns := range f.namespaces {
+		if ns != "" {
+			namespaces = append(namespaces, ns)
+		}
+	}
+	if len(namespaces) > 0 {
+		config.Namespaces = namespaces
+	}
+	if f.rule.Timespan != "" {
+		config.Timespan = f.rule.Timespan
+	}
+	config.Since = time.Time{}
+}
+
+// eventProbe runs the event count of a watch with trigger rules, a single
+// small query per check instead of a gather.
+type eventProbe struct {
+	ctx        context.Context
+	lcli       *azquery.LogsClient
+	guid       string
+	maxRetries int
+}
+
+// newEventProbe resolves the workspace of config through the management
+// plane and opens a logs client to it, with the Azure endpoints of env.
+func newEventProbe(ctx context.Context, config *Config, env *Environment) (*eventProbe, error) {
+	var cred azcore.TokenCredential
+	opts := &arm.ClientOptions{}
+	if env != nil {
+		cred = env.Credential
+		opts.Cloud = env.Cloud
+		if env.HTTPClient != nil {
+			opts.Transport = env.HTTPClient
+		}
+	}
+	if cred == nil {
+		c, err := azidentity.NewDefaultAzureCredential(nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to init credential: %w", err)
+		}
+		cred = c
+	}
+	sub, rg, ws, err := utils.ParseResourceID(config.WorkspaceID)
+	if err != nil {
+		return nil, fmt.Errorf("parse workspace-id: %w", err)
+	}
+	wcli, err := armoperationalinsights.NewWorkspacesClient(sub, cred, opts)
+	if err != nil {
+		return nil, err
+	}
+	w, err := wcli.Get(ctx, rg, ws, nil)
+	if err != nil {
+		return nil, fmt.Errorf("get workspace: %w", err)
+	}
+	if w.Properties == nil || w.Properties.CustomerID == nil {
+		return nil, errors.New("could not determine workspace GUID from workspace; check permissions or workspace-id")
+	}
+	lcli, err := azquery.NewLogsClient(cred, &azquery.LogsClientOptions{ClientOptions: opts.ClientOptions})
+	if err != nil {
+		return nil, fmt.Errorf("logs client: %w", err)
+	}
+	return &eventProbe{ctx: ctx, lcli: lcli, guid: *w.Properties.CustomerID, maxRetries: config.MaxRetries}, nil
+}
+
+// count runs query over [now-window, now), retrying when throttled.
+func (p *eventProbe) count(query string, window time.Duration, now time.Time) ([]eventCount, error) {
+	body := azquery.Body{Query: &query, Timespan: to.Ptr(azquery.NewTimeInterval(now.Add(-window).UTC(), now.UTC()))}
+	var res azquery.LogsClientQueryWorkspaceResponse
+	for attempt := 0; ; attempt++ {
+		var err error
+		if res, err = p.lcli.QueryWorkspace(p.ctx, p.guid, body, nil); err == nil {
+			break
+		}
+		delay, throttled := throttleDelay(err, attempt)
+		if !throttled || attempt >= p.maxRetries {
+			return nil, err
+		}
+		if err := sleepCtx(p.ctx, delay); err != nil {
+			return nil, err
+		}
+	}
+	if res.Error != nil {
+		return nil, res.Error
+	}
+	var counts []eventCount
+	if len(res.Tables) == 0 {
+		return counts, nil
+	}
+	for _, row := range tableRows(res.Tables[0]) {
+		minute, err := time.Parse(time.RFC3339Nano, toStr(row["Minute"]))
+		if err != nil {
+			continue
+		}
+		n, _ := toFloat(row["Count"])
+		counts = append(counts, eventCount{namespace: toStr(row["Namespace"]), reason: toStr(row["Reason"]), minute: minute, count: int(n)})
+	}
+	return counts, nil
+}
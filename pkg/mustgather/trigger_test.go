@@ -0,0 +1,127 @@
+package mustgather
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeTriggerRules(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "triggers.yaml")
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadTriggerRules(t *testing.T) {
+	r, err := LoadTriggerRules(writeTriggerRules(t, `
+triggers:
+  - name: payments-backoff
+    reason: BackOff
+    namespace: payments
+    threshold: 5
+    window: 10m
+    profiles: [podLogs]
+  - name: oom
+    reason: OOMKilling
+    window: 30m
+    cooldown: 2h
+    tables: [KubeEvents, ContainerLogV2]
+    timespan: PT1H
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r.Rules) != 2 || r.Rules[0].window != 10*time.Minute || r.Rules[0].cooldown != 10*time.Minute || r.Rules[1].cooldown != 2*time.Hour {
+		t.Fatalf("rules = %+v", r.Rules)
+	}
+	if r.maxWindow() != 30*time.Minute {
+		t.Errorf("maxWindow = %s", r.maxWindow())
+	}
+	if q := r.eventCountsQuery(); !strings.Contains(q, `Reason in~ ("BackOff", "OOMKilling")`) {
+		t.Errorf("query = %s", q)
+	}
+	if r, err := LoadTriggerRules(""); r != nil || err != nil {
+		t.Errorf("empty path = %v, %v", r, err)
+	}
+
+	for _, tt := range []struct{ name, yaml, want string }{
+		{"no triggers", "triggers: []", "no triggers"},
+		{"no name", "triggers: [{reason: BackOff, window: 10m}]", "no name"},
+		{"no reason", "triggers: [{name: a, window: 10m}]", "reason"},
+		{"no window", "triggers: [{name: a, reason: BackOff}]", "window"},
+		{"short window", "triggers: [{name: a, reason: BackOff, window: 10s}]", "window"},
+		{"duplicate", "triggers: [{name: a, reason: BackOff, window: 10m}, {name: a, reason: Failed, window: 10m}]", "twice"},
+		{"both", "triggers: [{name: a, reason: BackOff, window: 10m, profiles: [podLogs], tables: [KubeEvents]}]", "both"},
+		{"unknown profile", "triggers: [{name: a, reason: BackOff, window: 10m, profiles: [podLog]}]", "podLogs"},
+		{"unknown field", "triggers: [{name: a, reason: BackOff, window: 10m, count: 3}]", "count"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := LoadTriggerRules(writeTriggerRules(t, tt.yaml))
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("LoadTriggerRules = %v, want an error about %s", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestEvaluateTriggers(t *testing.T) {
+	r := &TriggerRules{Rules: []TriggerRule{
+		{Name: "payments", Reason: "BackOff", Namespace: "payments", Threshold: 3, Window: "10m"},
+		{Name: "any", Reason: "backoff", Threshold: 4, Window: "5m"},
+	}}
+	if err := r.compile(); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2026, 1, 2, 12, 0, 30, 0, time.UTC)
+	at := func(ago time.Duration) time.Time { return now.Add(-ago).Truncate(time.Minute) }
+	counts := []eventCount{
+		{namespace: "payments", reason: "BackOff", minute: at(8 * time.Minute), count: 2},
+		{namespace: "payments", reason: "BackOff", minute: at(2 * time.Minute), count: 2},
+		{namespace: "shop", reason: "BackOff", minute: at(1 * time.Minute), count: 5},
+		{namespace: "shop", reason: "Failed", minute: at(1 * time.Minute), count: 50},
+		// Outside both windows
+		{namespace: "web", reason: "BackOff", minute: at(20 * time.Minute), count: 50},
+	}
+
+	fires := r.evaluate(counts, nil, now)
+	if len(fires) != 2 {
+		t.Fatalf("fires = %+v", fires)
+	}
+	if f := fires[0]; f.rule.Name != "payments" || strings.Join(f.namespaces, ",") != "payments" || f.events != 4 {
+		t.Errorf("payments fire = %+v", f)
+	}
+	// Only shop crosses 4 within 5m; the older payments events are outside
+	if f := fires[1]; f.rule.Name != "any" || strings.Join(f.namespaces, ",") != "shop" || f.events != 5 {
+		t.Errorf("any fire = %+v", f)
+	}
+
+	fired := map[string]string{"payments": now.Add(-5 * time.Minute).Format(time.RFC3339), "any": now.Add(-time.Hour).Format(time.RFC3339)}
+	fires = r.evaluate(counts, fired, now)
+	if len(fires) != 1 || fires[0].rule.Name != "any" {
+		t.Errorf("payments fired within its cooldown: %+v", fires)
+	}
+}
+
+func TestTriggerFireApply(t *testing.T) {
+	rule := &TriggerRule{Name: "a", Tables: []string{"KubeEvents", "ContainerLogV2"}, Timespan: "PT30M"}
+	cfg := Config{Profiles: "aks-debug", Timespan: "PT2H", Namespaces: []string{"other"}, Since: time.Now()}
+	triggerFire{rule: rule, namespaces: []string{"", "payments"}}.apply(&cfg)
+	if cfg.TableFilter != "KubeEvents,ContainerLogV2" || cfg.Profiles != "" || cfg.Timespan != "PT30M" || !cfg.Since.IsZero() {
+		t.Errorf("config = %+v", cfg)
+	}
+	if strings.Join(cfg.Namespaces, ",") != "payments" {
+		t.Errorf("namespaces = %v", cfg.Namespaces)
+	}
+
+	// Cluster events alone keep the configured scope
+	cfg = Config{Namespaces: []string{"other"}}
+	triggerFire{rule: &TriggerRule{Name: "b"}, namespaces: []string{""}}.apply(&cfg)
+	if strings.Join(cfg.Namespaces, ",") != "other" {
+		t.Errorf("namespaces = %v", cfg.Namespaces)
+	}
+}
@@ -0,0 +1,89 @@
+package mustgather
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	azquery "github.com/Azure/azure-sdk-for-go/sdk/monitor/azquery"
+)
+
+// maxQueryRows is the row cap Log Analytics applies to a single query result.
+// A result this large is assumed to be truncated. Variable so tests can lower it.
+var maxQueryRows = 500000
+
+// minBisectWindow is the smallest window a truncated chunk is split into.
+// Query timespans have second precision, so windows cannot get smaller.
+const minBisectWindow = 2 * time.Second
+
+// truncationMarkers identify the partial errors returned when a result
+// exceeds the row or size limits.
+var truncationMarkers = []string{"E_QUERY_RESULT_SET_TOO_LARGE", "ResultSetTooLarge", "truncated"}
+
+// isTruncated reports whether the service capped a query result, either with
+// a partial error or by returning exactly the row limit.
+func isTruncated(res azquery.LogsClientQueryWorkspaceResponse) bool {
+	if res.Error != nil {
+		msg := strings.ToLower(res.Error.Error())
+		for _, m := range truncationMarkers {
+			if strings.Contains(msg, strings.ToLower(m)) {
+				return true
+			}
+		}
+	}
+	return len(res.Tables) > 0 && len(res.Tables[0].Rows) >= maxQueryRows
+}
+
+// rangeResult is the outcome of querying one chunk, possibly bisected.
+type rangeResult struct {
+	// tables holds results in time order.
+	tables []*azquery.Table
+	cached int
+	// bisected counts the extra queries issued by splitting.
+	bisected int
+	// truncated lists "<start>/<end>" windows that stayed truncated at the
+	// smallest window size.
+	truncated []string
+}
+
+// queryRange queries [t0, t1) and, when the result is truncated, splits the
+// window in half and queries both halves until every row is retrieved or the
+// window cannot be split further.
+func (g *Gatherer) queryRange(ctx context.Context, lcli LogsQueryClient, workspaceGUID, query string, t0, t1 time.Time, chunk time.Duration) (rangeResult, error) {
+	var out rangeResult
+	// A scoped or filtered query starts with its table
+	table, _, _ := strings.Cut(query, " ")
+	tab, cached, truncated, err := g.queryChunk(ctx, lcli, workspaceGUID, query, t0, t1, chunk)
+	if cached {
+		out.cached++
+	}
+	if err != nil {
+		return out, err
+	}
+	mid := t0.Add(t1.Sub(t0) / 2).Truncate(time.Second)
+	if !truncated || t1.Sub(t0) < minBisectWindow || !mid.After(t0) {
+		if truncated {
+			w := fmt.Sprintf("%s/%s", t0.UTC().Format(time.RFC3339), t1.UTC().Format(time.RFC3339))
+			g.warnf(table, "%s result still truncated for %s", table, w)
+			out.truncated = append(out.truncated, w)
+		}
+		if tab != nil {
+			out.tables = append(out.tables, tab)
+		}
+		return out, nil
+	}
+
+	fmt.Fprintf(g.log, "  %s result truncated for %s..%s, splitting window\n", table, t0.UTC().Format(time.RFC3339), t1.UTC().Format(time.RFC3339))
+	for _, w := range [][2]time.Time{{t0, mid}, {mid, t1}} {
+		sub, err := g.queryRange(ctx, lcli, workspaceGUID, query, w[0], w[1], chunk)
+		out.bisected += sub.bisected + 1
+		out.cached += sub.cached
+		out.truncated = append(out.truncated, sub.truncated...)
+		out.tables = append(out.tables, sub.tables...)
+		if err != nil {
+			return out, err
+		}
+	}
+	return out, nil
+}
@@ -0,0 +1,112 @@
+package mustgather
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	azquery "github.com/Azure/azure-sdk-for-go/sdk/monitor/azquery"
+
+	"kubectl-must-gather/pkg/testhelpers"
+)
+
+func TestIsTruncated(t *testing.T) {
+	defer func(n int) { maxQueryRows = n }(maxQueryRows)
+	maxQueryRows = 3
+
+	rows := func(n int) azquery.LogsClientQueryWorkspaceResponse {
+		tab := &azquery.Table{}
+		for i := 0; i < n; i++ {
+			tab.Rows = append(tab.Rows, azquery.Row{i})
+		}
+		return azquery.LogsClientQueryWorkspaceResponse{Results: azquery.Results{Tables: []*azquery.Table{tab}}}
+	}
+	tests := []struct {
+		name string
+		res  azquery.LogsClientQueryWorkspaceResponse
+		want bool
+	}{
+		{"under limit", rows(2), false},
+		{"at limit", rows(3), true},
+		{"no tables", azquery.LogsClientQueryWorkspaceResponse{}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isTruncated(tt.res); got != tt.want {
+				t.Errorf("isTruncated() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestQueryRangeBisectsTruncatedResults(t *testing.T) {
+	base := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	table := testhelpers.EmulatedTable{
+		Name:    "Heartbeat",
+		Columns: []testhelpers.EmulatedColumn{{Name: "TimeGenerated", Type: "datetime"}, {Name: "Computer", Type: "string"}},
+	}
+	for i := 0; i < 10; i++ {
+		table.Rows = append(table.Rows, []any{base.Add(time.Duration(i) * 5 * time.Minute).Format(time.RFC3339), "node-1"})
+	}
+	// Rows sharing one second cannot be separated by bisection
+	for i := 0; i < 3; i++ {
+		table.Rows = append(table.Rows, []any{base.Add(55 * time.Minute).Format(time.RFC3339), "node-2"})
+	}
+	emu := testhelpers.NewLogAnalyticsEmulator(table)
+	defer emu.Close()
+	emu.SetRowLimit(2)
+
+	g := &Gatherer{
+		config: &Config{},
+		ctx:    context.Background(),
+		cred:   emu.Credential(),
+		cloud:  emu.Cloud(),
+		client: emu.Client(),
+		log:    io.Discard,
+	}
+	hooks := &recordingHooks{}
+	g.hooks = hookList{hooks}
+	g.usage = newUsageTracker(g.client)
+	defer g.usage.stop()
+	lcli, err := azquery.NewLogsClient(g.cred, g.logsOptions())
+	if err != nil {
+		t.Fatalf("logs client: %v", err)
+	}
+
+	rng, err := g.queryRange(g.ctx, lcli, emu.WorkspaceGUID, `Heartbeat | where Computer startswith "node"`, base, base.Add(time.Hour), time.Hour)
+	if err != nil {
+		t.Fatalf("queryRange: %v", err)
+	}
+	rows := 0
+	for _, tab := range rng.tables {
+		rows += len(tab.Rows)
+	}
+	// All 10 node-1 rows, plus the 2 node-2 rows the service still returns
+	if rows != 12 {
+		t.Errorf("expected 12 rows, got %d", rows)
+	}
+	if rng.bisected == 0 {
+		t.Error("expected truncated chunk to be bisected")
+	}
+	if len(rng.truncated) != 1 || rng.truncated[0] != "2024-05-01T10:55:00Z/2024-05-01T10:55:01Z" {
+		t.Errorf("unexpected residual truncation: %v", rng.truncated)
+	}
+	// The warning names the table, not the query
+	if len(hooks.warnings) != 1 || hooks.warnings[0].Table != "Heartbeat" || strings.Contains(hooks.warnings[0].Message, "where") {
+		t.Errorf("unexpected warnings %+v", hooks.warnings)
+	}
+
+	// Results stay in time order across bisected windows
+	var prev string
+	for _, tab := range rng.tables {
+		for _, row := range tab.Rows {
+			ts := row[0].(string)
+			if ts < prev {
+				t.Fatalf("rows out of order: %s after %s", ts, prev)
+			}
+			prev = ts
+		}
+	}
+}
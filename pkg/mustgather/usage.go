@@ -0,0 +1,114 @@
+package mustgather
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// usageTracker accounts for the resources a gather consumes, so operators can
+// size the hosts gathers run on.
+type usageTracker struct {
+	started   time.Time
+	queries   atomic.Int64
+	retries   atomic.Int64
+	failed    atomic.Int64
+	bytesRead atomic.Int64
+	peakMem   atomic.Uint64
+	done      chan struct{}
+	stopOnce  sync.Once
+	client    *http.Client
+}
+
+// runUsage is the resource usage reported in metadata/run.json.
+type runUsage struct {
+	Duration        string  `json:"duration"`
+	CPUSeconds      float64 `json:"cpuSeconds"`
+	PeakMemoryBytes uint64  `json:"peakMemoryBytes"`
+	BytesDownloaded int64   `json:"bytesDownloaded"`
+	Queries         int64   `json:"queries"`
+	Retries         int64   `json:"retries"`
+}
+
+// newUsageTracker starts tracking; requests are sent with client, or a
+// default client when nil.
+func newUsageTracker(client *http.Client) *usageTracker {
+	if client == nil {
+		client = &http.Client{}
+	}
+	u := &usageTracker{
+		started: time.Now(),
+		done:    make(chan struct{}),
+		client:  client,
+	}
+	u.sampleMemory()
+	go func() {
+		ticker := time.NewTicker(250 * time.Millisecond)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				u.sampleMemory()
+			case <-u.done:
+				return
+			}
+		}
+	}()
+	return u
+}
+
+func (u *usageTracker) sampleMemory() {
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	for {
+		peak := u.peakMem.Load()
+		if ms.Sys <= peak || u.peakMem.CompareAndSwap(peak, ms.Sys) {
+			return
+		}
+	}
+}
+
+// stop ends memory sampling and returns the final usage figures. It may be
+// called more than once, e.g. by a deferred call after the gather's own.
+func (u *usageTracker) stop() runUsage {
+	u.stopOnce.Do(func() { close(u.done) })
+	u.sampleMemory()
+	return runUsage{
+		Duration:        time.Since(u.started).Round(time.Millisecond).String(),
+		CPUSeconds:      processCPUTime().Seconds(),
+		PeakMemoryBytes: u.peakMem.Load(),
+		BytesDownloaded: u.bytesRead.Load(),
+		Queries:         u.queries.Load(),
+		Retries:         u.retries.Load(),
+	}
+}
+
+// Do implements policy.Transporter, counting response body bytes.
+func (u *usageTracker) Do(req *http.Request) (*http.Response, error) {
+	resp, err := u.client.Do(req)
+	if err != nil {
+		return resp, err
+	}
+	resp.Body = &countingReader{ReadCloser: resp.Body, n: &u.bytesRead}
+	return resp, nil
+}
+
+type countingReader struct {
+	io.ReadCloser
+	n *atomic.Int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.ReadCloser.Read(p)
+	c.n.Add(int64(n))
+	return n, err
+}
+
+func (r runUsage) String() string {
+	return fmt.Sprintf("duration %s, CPU %.1fs, peak memory %.1f MiB, downloaded %.1f MiB in %d queries (%d throttled retries)",
+		r.Duration, r.CPUSeconds, float64(r.PeakMemoryBytes)/(1<<20), float64(r.BytesDownloaded)/(1<<20), r.Queries, r.Retries)
+}
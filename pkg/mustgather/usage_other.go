@@ -0,0 +1,10 @@
+//go:build !(linux || darwin || freebsd || netbsd || openbsd)
+
+package mustgather
+
+import "time"
+
+// processCPUTime is not available on this platform.
+func processCPUTime() time.Duration {
+	return 0
+}
@@ -0,0 +1,53 @@
+package mustgather
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestUsageTrackerCountsDownloadedBytes(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, strings.Repeat("x", 1000))
+	}))
+	defer srv.Close()
+
+	u := newUsageTracker(nil)
+	for i := 0; i < 2; i++ {
+		req, _ := http.NewRequest(http.MethodGet, srv.URL, nil)
+		resp, err := u.Do(req)
+		if err != nil {
+			t.Fatalf("request failed: %v", err)
+		}
+		_, _ = io.ReadAll(resp.Body)
+		resp.Body.Close()
+	}
+	u.queries.Add(3)
+
+	usage := u.stop()
+	if usage.BytesDownloaded != 2000 {
+		t.Errorf("expected 2000 bytes downloaded, got %d", usage.BytesDownloaded)
+	}
+	if usage.Queries != 3 {
+		t.Errorf("expected 3 queries, got %d", usage.Queries)
+	}
+	if usage.PeakMemoryBytes == 0 {
+		t.Error("expected a non-zero peak memory sample")
+	}
+	if !strings.Contains(usage.String(), "in 3 queries") {
+		t.Errorf("unexpected summary: %s", usage)
+	}
+	// stop is idempotent, also when a deferred stop races another
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			u.stop()
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,17 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd
+
+package mustgather
+
+import (
+	"syscall"
+	"time"
+)
+
+// processCPUTime returns the user+system CPU time consumed by the process.
+func processCPUTime() time.Duration {
+	var ru syscall.Rusage
+	if err := syscall.Getrusage(syscall.RUSAGE_SELF, &ru); err != nil {
+		return 0
+	}
+	return time.Duration(ru.Utime.Nano() + ru.Stime.Nano())
+}
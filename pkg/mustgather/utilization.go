@@ -0,0 +1,451 @@
+package mustgather
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"kubectl-must-gather/pkg/utils"
+)
+
+// utilizationDir holds the resource utilization CSVs and report.
+const utilizationDir = "analysis/utilization"
+
+const (
+	// utilizationBucket is the interval container samples are averaged over
+	// before they are summed into namespace and node totals.
+	utilizationBucket = 5 * time.Minute
+	// nearLimit is the share of a limit or of allocatable that is reported
+	// as close to it.
+	nearLimit = 0.9
+	// utilizationTopN caps the lists of the report.
+	utilizationTopN = 10
+)
+
+// utilizationReport aggregates CPU and memory usage against requests, limits
+// and node allocatable from the Perf K8SContainer and K8SNode counters, with
+// node allocatable also from InsightsMetrics. Containers are attributed to
+// namespaces through the pod UID in their Perf InstanceName, looked up in
+// KubePodInventory. It writes namespaces.csv, nodes.csv and report.md under
+// analysis/utilization/. CPU is in millicores, memory in MiB.
+type utilizationReport struct {
+	containers map[string]*containerUsage
+	// pods maps pod UIDs to namespace and name.
+	pods  map[string][2]string
+	nodes map[string]*nodeUsage
+}
+
+// containerUsage is one container's usage and resources. Request and limit
+// values are the latest reported.
+type containerUsage struct {
+	podUID, container, node    string
+	cpu, memory                usageSeries
+	cpuRequest, cpuLimit       latestValue
+	memoryRequest, memoryLimit latestValue
+}
+
+type nodeUsage struct {
+	cpu, memory                       usageSeries
+	cpuAllocatable, memoryAllocatable latestValue
+}
+
+// usageSeries summarizes samples, and averages them per utilizationBucket.
+type usageSeries struct {
+	sum, max float64
+	n        int
+	buckets  map[int64]*bucketAvg
+}
+
+type bucketAvg struct {
+	sum float64
+	n   int
+}
+
+type latestValue struct {
+	tm    string
+	value float64
+	ok    bool
+}
+
+func (s *usageSeries) add(tm time.Time, v float64) {
+	s.sum += v
+	s.n++
+	if v > s.max {
+		s.max = v
+	}
+	if s.buckets == nil {
+		s.buckets = map[int64]*bucketAvg{}
+	}
+	k := tm.Unix() / int64(utilizationBucket/time.Second)
+	b, ok := s.buckets[k]
+	if !ok {
+		b = &bucketAvg{}
+		s.buckets[k] = b
+	}
+	b.sum += v
+	b.n++
+}
+
+func (s *usageSeries) avg() float64 {
+	if s.n == 0 {
+		return 0
+	}
+	return s.sum / float64(s.n)
+}
+
+func (l *latestValue) set(tm string, v float64) {
+	if !l.ok || later(tm, l.tm) {
+		l.tm, l.value, l.ok = tm, v, true
+	}
+}
+
+func newUtilizationReport() *utilizationReport {
+	return &utilizationReport{
+		containers: map[string]*containerUsage{},
+		pods:       map[string][2]string{},
+		nodes:      map[string]*nodeUsage{},
+	}
+}
+
+func (u *utilizationReport) node(name string) *nodeUsage {
+	n, ok := u.nodes[name]
+	if !ok {
+		n = &nodeUsage{}
+		u.nodes[name] = n
+	}
+	return n
+}
+
+func (u *utilizationReport) observe(table string, row map[string]any) {
+	switch table {
+	case "KubePodInventory":
+		if uid := toStr(row["PodUid"]); uid != "" {
+			u.pods[uid] = [2]string{toStr(row["Namespace"]), toStr(row["Name"])}
+		}
+	case "Perf":
+		u.observePerf(row)
+	case "InsightsMetrics":
+		if toStr(row["Name"]) != "kube_node_status_allocatable" {
+			return
+		}
+		node := toStr(row["Computer"])
+		v, ok := toFloat(row["Val"])
+		if node == "" || !ok {
+			return
+		}
+		tags, _ := dynamicValue(row["Tags"]).(map[string]any)
+		tm := toStr(row["TimeGenerated"])
+		switch toStr(tags["resource"]) {
+		case "cpu":
+			u.node(node).cpuAllocatable.set(tm, v*1000)
+		case "memory":
+			u.node(node).memoryAllocatable.set(tm, v/(1<<20))
+		}
+	}
+}
+
+func (u *utilizationReport) observePerf(row map[string]any) {
+	v, ok := toFloat(row["CounterValue"])
+	if !ok {
+		return
+	}
+	tmStr := toStr(row["TimeGenerated"])
+	tm := utils.ParseTimeRFC3339(tmStr)
+	if tm.IsZero() {
+		return
+	}
+	node := toStr(row["Computer"])
+	counter := toStr(row["CounterName"])
+	switch toStr(row["ObjectName"]) {
+	case "K8SNode":
+		if node == "" {
+			return
+		}
+		n := u.node(node)
+		switch counter {
+		case "cpuUsageNanoCores":
+			n.cpu.add(tm, v/1e6)
+		case "memoryWorkingSetBytes":
+			n.memory.add(tm, v/(1<<20))
+		case "cpuAllocatableNanoCores":
+			n.cpuAllocatable.set(tmStr, v/1e6)
+		case "memoryAllocatableBytes":
+			n.memoryAllocatable.set(tmStr, v/(1<<20))
+		}
+	case "K8SContainer":
+		// InstanceName is <cluster id>/<pod uid>/<container>
+		parts := strings.Split(toStr(row["InstanceName"]), "/")
+		if len(parts) < 2 {
+			return
+		}
+		uid, cn := parts[len(parts)-2], parts[len(parts)-1]
+		key := uid + "/" + cn
+		c, ok := u.containers[key]
+		if !ok {
+			c = &containerUsage{podUID: uid, container: cn}
+			u.containers[key] = c
+		}
+		if node != "" {
+			c.node = node
+		}
+		switch counter {
+		case "cpuUsageNanoCores":
+			c.cpu.add(tm, v/1e6)
+		case "memoryWorkingSetBytes":
+			c.memory.add(tm, v/(1<<20))
+		case "cpuRequestNanoCores":
+			c.cpuRequest.set(tmStr, v/1e6)
+		case "cpuLimitNanoCores":
+			c.cpuLimit.set(tmStr, v/1e6)
+		case "memoryRequestBytes":
+			c.memoryRequest.set(tmStr, v/(1<<20))
+		case "memoryLimitBytes":
+			c.memoryLimit.set(tmStr, v/(1<<20))
+		}
+	}
+}
+
+func (u *utilizationReport) endChunk(tarw *utils.TarWriter) error {
+	return nil
+}
+
+func (u *utilizationReport) warnings(table string) []string {
+	return nil
+}
+
+// namespaceUtilization is a row of namespaces.csv.
+type namespaceUtilization struct {
+	namespace                             string
+	containers                            int
+	cpuAvg, cpuPeak, cpuRequest, cpuLimit float64
+	memAvg, memPeak, memRequest, memLimit float64
+	cpuBuckets, memBuckets                map[int64]float64
+}
+
+// nodeUtilization is a row of nodes.csv.
+type nodeUtilization struct {
+	node                                         string
+	cpuAvg, cpuPeak, cpuAllocatable, cpuRequests float64
+	memAvg, memPeak, memAllocatable, memRequests float64
+}
+
+func (u *utilizationReport) namespace(c *containerUsage) string {
+	if p, ok := u.pods[c.podUID]; ok {
+		return p[0]
+	}
+	return "(unknown)"
+}
+
+// aggregate sums container usage per namespace, and requests per node.
+// Namespace peaks are the busiest bucket of the summed bucket averages.
+func (u *utilizationReport) aggregate() ([]*namespaceUtilization, []*nodeUtilization) {
+	byNS := map[string]*namespaceUtilization{}
+	nodeRequests := map[string][2]float64{}
+	for _, c := range u.containers {
+		ns := u.namespace(c)
+		a, ok := byNS[ns]
+		if !ok {
+			a = &namespaceUtilization{namespace: ns, cpuBuckets: map[int64]float64{}, memBuckets: map[int64]float64{}}
+			byNS[ns] = a
+		}
+		a.containers++
+		a.cpuAvg += c.cpu.avg()
+		a.memAvg += c.memory.avg()
+		a.cpuRequest += c.cpuRequest.value
+		a.cpuLimit += c.cpuLimit.value
+		a.memRequest += c.memoryRequest.value
+		a.memLimit += c.memoryLimit.value
+		for k, b := range c.cpu.buckets {
+			a.cpuBuckets[k] += b.sum / float64(b.n)
+		}
+		for k, b := range c.memory.buckets {
+			a.memBuckets[k] += b.sum / float64(b.n)
+		}
+		if c.node != "" {
+			r := nodeRequests[c.node]
+			nodeRequests[c.node] = [2]float64{r[0] + c.cpuRequest.value, r[1] + c.memoryRequest.value}
+		}
+	}
+	namespaces := make([]*namespaceUtilization, 0, len(byNS))
+	for _, a := range byNS {
+		for _, v := range a.cpuBuckets {
+			a.cpuPeak = max(a.cpuPeak, v)
+		}
+		for _, v := range a.memBuckets {
+			a.memPeak = max(a.memPeak, v)
+		}
+		namespaces = append(namespaces, a)
+	}
+	sort.Slice(namespaces, func(i, j int) bool {
+		if namespaces[i].memPeak != namespaces[j].memPeak {
+			return namespaces[i].memPeak > namespaces[j].memPeak
+		}
+		return namespaces[i].namespace < namespaces[j].namespace
+	})
+
+	names := map[string]bool{}
+	for n := range u.nodes {
+		names[n] = true
+	}
+	for n := range nodeRequests {
+		names[n] = true
+	}
+	nodes := make([]*nodeUtilization, 0, len(names))
+	for name := range names {
+		nu := &nodeUtilization{node: name, cpuRequests: nodeRequests[name][0], memRequests: nodeRequests[name][1]}
+		if n, ok := u.nodes[name]; ok {
+			nu.cpuAvg, nu.cpuPeak, nu.cpuAllocatable = n.cpu.avg(), n.cpu.max, n.cpuAllocatable.value
+			nu.memAvg, nu.memPeak, nu.memAllocatable = n.memory.avg(), n.memory.max, n.memoryAllocatable.value
+		}
+		nodes = append(nodes, nu)
+	}
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].node < nodes[j].node })
+	return namespaces, nodes
+}
+
+func (u *utilizationReport) finish(tarw *utils.TarWriter) error {
+	namespaces, nodes := u.aggregate()
+
+	nsRows := [][]string{{"namespace", "containers",
+		"cpu_avg_m", "cpu_peak_m", "cpu_request_m", "cpu_limit_m",
+		"memory_avg_mib", "memory_peak_mib", "memory_request_mib", "memory_limit_mib"}}
+	for _, a := range namespaces {
+		nsRows = append(nsRows, []string{a.namespace, strconv.Itoa(a.containers),
+			csvNum(a.cpuAvg), csvNum(a.cpuPeak), csvNum(a.cpuRequest), csvNum(a.cpuLimit),
+			csvNum(a.memAvg), csvNum(a.memPeak), csvNum(a.memRequest), csvNum(a.memLimit)})
+	}
+	nodeRows := [][]string{{"node",
+		"cpu_avg_m", "cpu_peak_m", "cpu_allocatable_m", "cpu_requests_m", "cpu_peak_pct", "cpu_requests_pct",
+		"memory_avg_mib", "memory_peak_mib", "memory_allocatable_mib", "memory_requests_mib", "memory_peak_pct", "memory_requests_pct"}}
+	for _, n := range nodes {
+		nodeRows = append(nodeRows, []string{n.node,
+			csvNum(n.cpuAvg), csvNum(n.cpuPeak), csvNum(n.cpuAllocatable), csvNum(n.cpuRequests), csvPct(n.cpuPeak, n.cpuAllocatable), csvPct(n.cpuRequests, n.cpuAllocatable),
+			csvNum(n.memAvg), csvNum(n.memPeak), csvNum(n.memAllocatable), csvNum(n.memRequests), csvPct(n.memPeak, n.memAllocatable), csvPct(n.memRequests, n.memAllocatable)})
+	}
+	for _, f := range []struct {
+		name string
+		rows [][]string
+	}{{"namespaces.csv", nsRows}, {"nodes.csv", nodeRows}} {
+		var buf bytes.Buffer
+		if err := csv.NewWriter(&buf).WriteAll(f.rows); err != nil {
+			return fmt.Errorf("write %s: %w", f.name, err)
+		}
+		if err := utils.WriteFileToTar(tarw, path.Join(utilizationDir, f.name), buf.Bytes()); err != nil {
+			return err
+		}
+	}
+	return utils.WriteFileToTar(tarw, path.Join(utilizationDir, "report.md"), []byte(u.render(namespaces, nodes)))
+}
+
+func (u *utilizationReport) render(namespaces []*namespaceUtilization, nodes []*nodeUtilization) string {
+	var b strings.Builder
+	b.WriteString("# Resource utilization\n\n")
+	if len(u.containers) == 0 && len(u.nodes) == 0 {
+		b.WriteString("No Perf K8SContainer or K8SNode samples were gathered; include the `metrics` profile to get them.\n")
+		return b.String()
+	}
+	b.WriteString("CPU in millicores, memory in MiB. Averages are over the window; peaks are the busiest 5 minutes (raw samples for nodes). Full numbers are in `namespaces.csv` and `nodes.csv`.\n")
+
+	b.WriteString("\n## Nodes\n\n| Node | CPU avg / peak | CPU requests | Memory avg / peak | Memory requests |\n|---|---|---|---|---|\n")
+	for _, n := range nodes {
+		fmt.Fprintf(&b, "| %s | %s / %s | %s | %s / %s | %s |\n", n.node,
+			ofAllocatable(n.cpuAvg, n.cpuAllocatable), ofAllocatable(n.cpuPeak, n.cpuAllocatable), ofAllocatable(n.cpuRequests, n.cpuAllocatable),
+			ofAllocatable(n.memAvg, n.memAllocatable), ofAllocatable(n.memPeak, n.memAllocatable), ofAllocatable(n.memRequests, n.memAllocatable))
+	}
+
+	fmt.Fprintf(&b, "\n## Namespaces by peak memory\n\n| Namespace | Containers | CPU avg / peak | CPU requests / limits | Memory avg / peak | Memory requests / limits |\n|---|---|---|---|---|---|\n")
+	for i, a := range namespaces {
+		if i == utilizationTopN {
+			fmt.Fprintf(&b, "\n%d more namespaces in `namespaces.csv`.\n", len(namespaces)-i)
+			break
+		}
+		fmt.Fprintf(&b, "| %s | %d | %s / %s | %s / %s | %s / %s | %s / %s |\n", a.namespace, a.containers,
+			fmtNum(a.cpuAvg), fmtNum(a.cpuPeak), fmtNum(a.cpuRequest), fmtNum(a.cpuLimit),
+			fmtNum(a.memAvg), fmtNum(a.memPeak), fmtNum(a.memRequest), fmtNum(a.memLimit))
+	}
+
+	// Containers close to what they may use
+	keys := make([]string, 0, len(u.containers))
+	for k := range u.containers {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var memory, cpu []nearLimitLine
+	for _, k := range keys {
+		c := u.containers[k]
+		name := u.containerName(c)
+		if lim := c.memoryLimit.value; lim > 0 && c.memory.max >= nearLimit*lim {
+			memory = append(memory, nearLimitLine{fmt.Sprintf("- %s: peak %s MiB of a %s MiB limit (%.0f%%)", name, fmtNum(c.memory.max), fmtNum(lim), 100*c.memory.max/lim), c.memory.max / lim})
+		}
+		if req := c.cpuRequest.value; req > 0 && c.cpu.avg() > req {
+			cpu = append(cpu, nearLimitLine{fmt.Sprintf("- %s: average %sm over its %sm request (%.0f%%)", name, fmtNum(c.cpu.avg()), fmtNum(req), 100*c.cpu.avg()/req), c.cpu.avg() / req})
+		}
+	}
+	fmt.Fprintf(&b, "\n## Containers near their memory limit (%.0f%%+)\n\n%s\n", 100*nearLimit, topNearLimit(memory))
+	fmt.Fprintf(&b, "\n## Containers using more CPU than requested\n\n%s\n", topNearLimit(cpu))
+	return b.String()
+}
+
+// nearLimitLine is a report line about a container, ranked by how close to
+// or over its limit or request it is.
+type nearLimitLine struct {
+	line  string
+	ratio float64
+}
+
+// topNearLimit renders the utilizationTopN highest ranked lines.
+func topNearLimit(lines []nearLimitLine) string {
+	if len(lines) == 0 {
+		return "- none"
+	}
+	sort.SliceStable(lines, func(i, j int) bool { return lines[i].ratio > lines[j].ratio })
+	var out []string
+	for i, l := range lines {
+		if i == utilizationTopN {
+			out = append(out, fmt.Sprintf("- ... %d more", len(lines)-i))
+			break
+		}
+		out = append(out, l.line)
+	}
+	return strings.Join(out, "\n")
+}
+
+func (u *utilizationReport) containerName(c *containerUsage) string {
+	if p, ok := u.pods[c.podUID]; ok {
+		return p[0] + "/" + p[1] + "/" + c.container
+	}
+	return c.podUID + "/" + c.container
+}
+
+// ofAllocatable renders v with its share of allocatable, when known.
+func ofAllocatable(v, allocatable float64) string {
+	if allocatable <= 0 {
+		return fmtNum(v)
+	}
+	s := fmt.Sprintf("%s (%.0f%%)", fmtNum(v), 100*v/allocatable)
+	if v >= nearLimit*allocatable {
+		s += " (!)"
+	}
+	return s
+}
+
+func fmtNum(v float64) string {
+	return strconv.FormatFloat(v, 'f', 0, 64)
+}
+
+func csvNum(v float64) string {
+	return strconv.FormatFloat(v, 'f', 1, 64)
+}
+
+// csvPct is v as a percentage of of, or empty when of is unknown.
+func csvPct(v, of float64) string {
+	if of <= 0 {
+		return ""
+	}
+	return strconv.FormatFloat(100*v/of, 'f', 1, 64)
+}
@@ -0,0 +1,107 @@
+package mustgather
+
+import (
+	"path"
+	"strings"
+	"testing"
+)
+
+func perfRow(tm, object, counter, instance string, v float64) map[string]any {
+	return map[string]any{"TimeGenerated": tm, "Computer": "node-1", "ObjectName": object, "CounterName": counter, "InstanceName": instance, "CounterValue": v}
+}
+
+func TestUtilizationReport(t *testing.T) {
+	const t0, t1 = "2024-01-01T00:00:00Z", "2024-01-01T00:05:00Z"
+	u := newUtilizationReport()
+	for _, row := range []map[string]any{
+		{"TimeGenerated": t0, "Namespace": "shop", "Name": "web-1", "PodUid": "uid-1"},
+		{"TimeGenerated": t0, "Namespace": "shop", "Name": "cache-1", "PodUid": "uid-2"},
+		{"TimeGenerated": t0, "Namespace": "kube-system", "Name": "coredns-1", "PodUid": "uid-3"},
+	} {
+		u.observe("KubePodInventory", row)
+	}
+	web, cache, dns := "cluster/uid-1/web", "cluster/uid-2/cache", "cluster/uid-3/coredns"
+	for _, row := range []map[string]any{
+		// web uses three times its CPU request
+		perfRow(t0, "K8SContainer", "cpuUsageNanoCores", web, 200e6),
+		perfRow(t1, "K8SContainer", "cpuUsageNanoCores", web, 400e6),
+		perfRow(t0, "K8SContainer", "cpuRequestNanoCores", web, 100e6),
+		perfRow(t0, "K8SContainer", "memoryWorkingSetBytes", web, 100<<20),
+		perfRow(t1, "K8SContainer", "memoryWorkingSetBytes", web, 100<<20),
+		perfRow(t0, "K8SContainer", "memoryRequestBytes", web, 64<<20),
+		perfRow(t0, "K8SContainer", "memoryLimitBytes", web, 512<<20),
+		// cache runs close to its memory limit
+		perfRow(t0, "K8SContainer", "cpuUsageNanoCores", cache, 50e6),
+		perfRow(t1, "K8SContainer", "cpuUsageNanoCores", cache, 50e6),
+		perfRow(t0, "K8SContainer", "cpuRequestNanoCores", cache, 100e6),
+		perfRow(t0, "K8SContainer", "memoryWorkingSetBytes", cache, 460<<20),
+		perfRow(t1, "K8SContainer", "memoryWorkingSetBytes", cache, 480<<20),
+		perfRow(t0, "K8SContainer", "memoryRequestBytes", cache, 256<<20),
+		perfRow(t0, "K8SContainer", "memoryLimitBytes", cache, 500<<20),
+		perfRow(t0, "K8SContainer", "memoryWorkingSetBytes", dns, 20<<20),
+		perfRow(t0, "K8SNode", "cpuUsageNanoCores", "cluster/node-1", 1100e6),
+		perfRow(t1, "K8SNode", "cpuUsageNanoCores", "cluster/node-1", 1900e6),
+		perfRow(t0, "K8SNode", "memoryWorkingSetBytes", "cluster/node-1", 1024<<20),
+		perfRow(t0, "K8SNode", "memoryAllocatableBytes", "cluster/node-1", 4096<<20),
+		// Not a counter the report uses
+		perfRow(t0, "K8SNode", "restartTimeEpoch", "cluster/node-1", 1),
+	} {
+		u.observe("Perf", row)
+	}
+	for _, row := range []map[string]any{
+		{"TimeGenerated": t0, "Computer": "node-1", "Name": "kube_node_status_allocatable", "Val": 2.0, "Tags": `{"resource":"cpu"}`},
+		{"TimeGenerated": t0, "Computer": "node-1", "Name": "kube_node_status_capacity", "Val": 4.0, "Tags": `{"resource":"cpu"}`},
+	} {
+		u.observe("InsightsMetrics", row)
+	}
+
+	files := readTransform(t, u)
+	for _, tc := range []struct {
+		file string
+		want []string
+	}{
+		{"namespaces.csv", []string{
+			"namespace,containers,cpu_avg_m,",
+			// Peaks are the busiest bucket of the summed containers
+			"\nshop,2,350.0,450.0,200.0,0.0,570.0,580.0,320.0,1012.0\n",
+			"\nkube-system,1,0.0,0.0,0.0,0.0,20.0,20.0,0.0,0.0\n",
+		}},
+		{"nodes.csv", []string{
+			"node,cpu_avg_m,",
+			"\nnode-1,1500.0,1900.0,2000.0,200.0,95.0,10.0,1024.0,1024.0,4096.0,320.0,25.0,7.8\n",
+		}},
+		{"report.md", []string{
+			"| node-1 | 1500 (75%) / 1900 (95%) (!) | 200 (10%) | 1024 (25%) / 1024 (25%) | 320 (8%) |\n",
+			"| shop | 2 | 350 / 450 | 200 / 0 | 570 / 580 | 320 / 1012 |\n",
+			"- shop/cache-1/cache: peak 480 MiB of a 500 MiB limit (96%)\n",
+			"- shop/web-1/web: average 300m over its 100m request (300%)\n",
+		}},
+	} {
+		got, ok := files[path.Join(utilizationDir, tc.file)]
+		if !ok {
+			t.Errorf("%s not written", tc.file)
+			continue
+		}
+		for _, want := range tc.want {
+			if !strings.Contains(got, want) {
+				t.Errorf("%s missing %q:\n%s", tc.file, want, got)
+			}
+		}
+	}
+	if i, j := strings.Index(files[path.Join(utilizationDir, "namespaces.csv")], "shop"), strings.Index(files[path.Join(utilizationDir, "namespaces.csv")], "kube-system"); i > j {
+		t.Errorf("namespaces not sorted by peak memory")
+	}
+	if strings.Contains(files[path.Join(utilizationDir, "report.md")], "web-1/web: peak") {
+		t.Errorf("web is far from its memory limit but reported near it")
+	}
+}
+
+func TestUtilizationReportNoData(t *testing.T) {
+	files := readTransform(t, newUtilizationReport())
+	if got := files[path.Join(utilizationDir, "report.md")]; !strings.Contains(got, "include the `metrics` profile") {
+		t.Errorf("report.md = %q", got)
+	}
+	if got := files[path.Join(utilizationDir, "nodes.csv")]; strings.Count(got, "\n") != 1 {
+		t.Errorf("nodes.csv should only have a header, got %q", got)
+	}
+}
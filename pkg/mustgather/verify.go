@@ -0,0 +1,388 @@
+package mustgather
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"text/tabwriter"
+
+	"kubectl-must-gather/pkg/bundle"
+	"kubectl-must-gather/pkg/utils"
+)
+
+// Verify check statuses.
+const (
+	VerifyPass = "pass"
+	VerifyWarn = "warn"
+	VerifyFail = "fail"
+	VerifySkip = "skip"
+)
+
+// verifyMaxProblems is how many problems of a check Render lists.
+const verifyMaxProblems = 10
+
+// VerifyCheck is the outcome of one integrity check of a bundle.
+type VerifyCheck struct {
+	Name     string   `json:"name"`
+	Status   string   `json:"status"`
+	Detail   string   `json:"detail,omitempty"`
+	Problems []string `json:"problems,omitempty"`
+}
+
+// VerifyReport is the health of a bundle, as checked by Verify.
+type VerifyReport struct {
+	Bundle string        `json:"bundle"`
+	Files  int           `json:"files"`
+	Bytes  int64         `json:"bytes"`
+	Tables int           `json:"tables"`
+	Parts  int           `json:"parts"`
+	Rows   int64         `json:"rows"`
+	Checks []VerifyCheck `json:"checks"`
+}
+
+// OK reports whether no check failed.
+func (r *VerifyReport) OK() bool {
+	for _, c := range r.Checks {
+		if c.Status == VerifyFail {
+			return false
+		}
+	}
+	return true
+}
+
+// VerifyOptions configures Verify.
+type VerifyOptions struct {
+	// Manifest is the checksum manifest to check the bundle against; empty
+	// looks for <bundle>.manifest.json, as written with --sign-key.
+	Manifest string
+}
+
+// verifyPart is what Verify read of a table part.
+type verifyPart struct {
+	rows int64
+	// problem is set when a line does not parse or the part ends without
+	// a newline, as a part cut short does.
+	problem string
+}
+
+// verifyScan is what Verify read of every file of a bundle.
+type verifyScan struct {
+	files map[string]utils.TarFile
+	// json holds the content of the .json files
+	json  map[string][]byte
+	parts map[string]*verifyPart
+}
+
+// Verify reads every file of the bundle at src (archive or extracted
+// directory) and checks that the archive reads to its end, that it and its
+// entries match the checksum manifest, that its JSON files and NDJSON table
+// parts parse, and that the tables hold the rows their summaries count. It
+// returns an error only when src cannot be read at all; problems found are
+// failed checks of the report.
+func Verify(src string, opts VerifyOptions) (*VerifyReport, error) {
+	fi, err := os.Stat(src)
+	if err != nil {
+		return nil, err
+	}
+	r := &VerifyReport{Bundle: src, Checks: []VerifyCheck{}}
+	scan := &verifyScan{files: map[string]utils.TarFile{}, json: map[string][]byte{}, parts: map[string]*verifyPart{}}
+
+	read := VerifyCheck{Name: "archive", Status: VerifyPass, Detail: "reads to the end"}
+	if fi.IsDir() {
+		read.Name, read.Detail = "directory", "all files read"
+	}
+	err = walkBundle(src, scan.add)
+	if err != nil {
+		read.Status, read.Detail = VerifyFail, err.Error()
+		if !fi.IsDir() {
+			read.Detail = "archive is damaged or cut short: " + err.Error()
+		}
+	}
+	r.Checks = append(r.Checks, read)
+	for _, f := range scan.files {
+		r.Files++
+		r.Bytes += f.Size
+	}
+
+	r.Checks = append(r.Checks, verifyManifest(src, fi.IsDir(), opts.Manifest, scan))
+	r.Checks = append(r.Checks, scan.checkFormat(), scan.checkJSON(), scan.checkTables(r), scan.checkGather())
+	return r, nil
+}
+
+// add reads the file name of a bundle from rd.
+func (s *verifyScan) add(name string, rd io.Reader) error {
+	h := sha256.New()
+	var size atomic.Int64
+	cr := &countingReader{ReadCloser: io.NopCloser(io.TeeReader(rd, h)), n: &size}
+	switch {
+	case isTablePart(name):
+		p := &verifyPart{}
+		s.parts[name] = p
+		br := bufio.NewReader(cr)
+		for n := 1; ; n++ {
+			line, err := br.ReadBytes('\n')
+			if len(line) > 0 && p.problem == "" {
+				switch {
+				case !json.Valid(bytes.TrimSpace(line)):
+					p.problem = fmt.Sprintf("line %d is not valid JSON", n)
+					if err == io.EOF {
+						p.problem = fmt.Sprintf("cut short in line %d", n)
+					}
+				case err == io.EOF:
+					p.problem = fmt.Sprintf("line %d ends without a newline", n)
+				default:
+					p.rows++
+				}
+			}
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return fmt.Errorf("read %s: %w", name, err)
+			}
+		}
+	case strings.HasSuffix(name, ".json"):
+		b, err := io.ReadAll(cr)
+		if err != nil {
+			return fmt.Errorf("read %s: %w", name, err)
+		}
+		s.json[name] = b
+	default:
+		if _, err := io.Copy(io.Discard, cr); err != nil {
+			return fmt.Errorf("read %s: %w", name, err)
+		}
+	}
+	s.files[name] = utils.TarFile{Path: name, Size: size.Load(), SHA256: hex.EncodeToString(h.Sum(nil))}
+	return nil
+}
+
+// verifyManifest checks the bundle at src against its checksum manifest:
+// the archive itself, and every entry, none missing, changed or added.
+func verifyManifest(src string, dir bool, manifest string, scan *verifyScan) VerifyCheck {
+	c := VerifyCheck{Name: "manifest", Status: VerifyPass}
+	explicit := manifest != ""
+	if !explicit {
+		manifest = strings.TrimSuffix(filepath.Clean(src), string(filepath.Separator)) + manifestSuffix
+	}
+	data, err := os.ReadFile(manifest)
+	if errors.Is(err, os.ErrNotExist) && !explicit {
+		c.Status, c.Detail = VerifySkip, "no checksum manifest next to the bundle (written with --sign-key)"
+		return c
+	}
+	if err != nil {
+		c.Status, c.Detail = VerifyFail, err.Error()
+		return c
+	}
+	var m checksumManifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		c.Status, c.Detail = VerifyFail, fmt.Sprintf("parse %s: %v", manifest, err)
+		return c
+	}
+
+	if !dir {
+		sum, err := fileChecksum(src)
+		switch {
+		case err != nil:
+			c.Problems = append(c.Problems, err.Error())
+		case sum.SHA256 != m.Archive.SHA256 || sum.Size != m.Archive.Size:
+			c.Problems = append(c.Problems, fmt.Sprintf("archive: %d bytes with SHA-256 %s, manifest has %d bytes with %s", sum.Size, sum.SHA256, m.Archive.Size, m.Archive.SHA256))
+		}
+	}
+	listed := map[string]bool{}
+	for _, e := range m.Entries {
+		listed[e.Path] = true
+		got, ok := scan.files[e.Path]
+		switch {
+		case !ok:
+			c.Problems = append(c.Problems, e.Path+": missing")
+		case got.SHA256 != e.SHA256 || got.Size != e.Size:
+			c.Problems = append(c.Problems, e.Path+": changed")
+		}
+	}
+	for _, name := range sortedKeys(scan.files) {
+		if !listed[name] {
+			c.Problems = append(c.Problems, name+": not in the manifest")
+		}
+	}
+	c.Detail = fmt.Sprintf("%d entries match %s", len(m.Entries), filepath.Base(manifest))
+	if len(c.Problems) > 0 {
+		c.Status, c.Detail = VerifyFail, fmt.Sprintf("%d problem(s) against %s", len(c.Problems), filepath.Base(manifest))
+	}
+	if _, err := os.Stat(manifest + signatureSuffix); err == nil {
+		c.Detail += "; check its signature " + filepath.Base(manifest+signatureSuffix) + " with cosign verify-blob"
+	}
+	return c
+}
+
+// checkFormat checks that the bundle format is one this version reads.
+func (s *verifyScan) checkFormat() VerifyCheck {
+	c := VerifyCheck{Name: "format", Status: VerifyPass}
+	data, ok := s.json[bundle.InfoPath]
+	if !ok {
+		c.Status, c.Detail = VerifyWarn, fmt.Sprintf("no %s: a bundle of format version 0, upgrade it with migrate", bundle.InfoPath)
+		return c
+	}
+	var info bundle.Info
+	if err := json.Unmarshal(data, &info); err != nil {
+		c.Status, c.Detail = VerifyFail, fmt.Sprintf("parse %s: %v", bundle.InfoPath, err)
+		return c
+	}
+	c.Detail = fmt.Sprintf("bundle format version %d", info.BundleFormatVersion)
+	switch {
+	case info.BundleFormatVersion > bundle.FormatVersion:
+		c.Status, c.Detail = VerifyFail, fmt.Sprintf("bundle format version %d is newer than the supported version %d; upgrade aks-must-gather", info.BundleFormatVersion, bundle.FormatVersion)
+	case info.BundleFormatVersion < bundle.FormatVersion:
+		c.Status, c.Detail = VerifyWarn, c.Detail+", older than the current version; upgrade it with migrate"
+	}
+	return c
+}
+
+// checkJSON checks that every JSON file parses.
+func (s *verifyScan) checkJSON() VerifyCheck {
+	c := VerifyCheck{Name: "json", Status: VerifyPass, Detail: fmt.Sprintf("%d JSON file(s) parse", len(s.json))}
+	for _, name := range sortedKeys(s.json) {
+		if !json.Valid(s.json[name]) {
+			c.Problems = append(c.Problems, name+": not valid JSON")
+		}
+	}
+	if len(c.Problems) > 0 {
+		c.Status, c.Detail = VerifyFail, fmt.Sprintf("%d of %d JSON file(s) do not parse", len(c.Problems), len(s.json))
+	}
+	return c
+}
+
+// checkTables checks that every table part parses to its end and that each
+// table holds the rows its summary.json counts, and counts them in r.
+func (s *verifyScan) checkTables(r *VerifyReport) VerifyCheck {
+	c := VerifyCheck{Name: "tables", Status: VerifyPass}
+	rows := map[string]int64{}
+	for _, name := range sortedKeys(s.parts) {
+		p := s.parts[name]
+		dir := strings.TrimSuffix(path.Dir(path.Dir(name)), "/")
+		rows[dir] += p.rows
+		r.Parts++
+		r.Rows += p.rows
+		if p.problem != "" {
+			c.Problems = append(c.Problems, name+": "+p.problem)
+		}
+	}
+	var warnings []string
+	for _, name := range sortedKeys(s.json) {
+		dir, ok := strings.CutSuffix(name, "/summary.json")
+		if !ok || path.Dir(dir) != "tables" {
+			continue
+		}
+		r.Tables++
+		var sum struct {
+			Table            string   `json:"table"`
+			Rows             *int64   `json:"rows"`
+			Incomplete       bool     `json:"incomplete"`
+			TruncatedWindows []string `json:"truncatedWindows"`
+		}
+		if json.Unmarshal(s.json[name], &sum) != nil {
+			continue
+		}
+		if sum.Table == "" {
+			sum.Table = path.Base(dir)
+		}
+		if sum.Rows != nil && *sum.Rows != rows[dir] {
+			c.Problems = append(c.Problems, fmt.Sprintf("%s: %d row(s) in its parts, summary.json counts %d", sum.Table, rows[dir], *sum.Rows))
+		}
+		if sum.Incomplete {
+			warnings = append(warnings, sum.Table+": export was interrupted")
+		}
+		if len(sum.TruncatedWindows) > 0 {
+			warnings = append(warnings, fmt.Sprintf("%s: %d window(s) still truncated by the service", sum.Table, len(sum.TruncatedWindows)))
+		}
+	}
+	c.Detail = fmt.Sprintf("%d table(s), %d part(s), %d row(s) parse", r.Tables, r.Parts, r.Rows)
+	switch {
+	case len(c.Problems) > 0:
+		c.Status, c.Detail = VerifyFail, fmt.Sprintf("%d problem(s) in %d part(s)", len(c.Problems), r.Parts)
+		c.Problems = append(c.Problems, warnings...)
+	case len(warnings) > 0:
+		c.Status, c.Problems = VerifyWarn, warnings
+		c.Detail += fmt.Sprintf(", %d with rows missing", len(warnings))
+	}
+	return c
+}
+
+// checkGather checks that the gather finished and exported every table it
+// lists in index.json.
+func (s *verifyScan) checkGather() VerifyCheck {
+	c := VerifyCheck{Name: "gather", Status: VerifyPass}
+	data, ok := s.json["index.json"]
+	if !ok {
+		c.Status, c.Detail = VerifyFail, "no index.json"
+		return c
+	}
+	var idx struct {
+		Tables          []string `json:"tables"`
+		Incomplete      bool     `json:"incomplete"`
+		CompletedTables []string `json:"completedTables"`
+	}
+	if json.Unmarshal(data, &idx) != nil {
+		c.Status, c.Detail = VerifyFail, "index.json does not parse"
+		return c
+	}
+	exported := map[string]bool{}
+	for name, data := range s.json {
+		if dir, ok := strings.CutSuffix(name, "/summary.json"); ok && path.Dir(dir) == "tables" {
+			var sum struct {
+				Table string `json:"table"`
+			}
+			_ = json.Unmarshal(data, &sum)
+			exported[path.Base(dir)] = true
+			exported[sum.Table] = true
+		}
+	}
+	var missing []string
+	for _, t := range idx.Tables {
+		if !exported[t] && !exported[utils.SafeFileName(t)] {
+			missing = append(missing, t)
+		}
+	}
+	sort.Strings(missing)
+	c.Detail = fmt.Sprintf("%d table(s) listed in index.json, all exported", len(idx.Tables))
+	switch {
+	case idx.Incomplete:
+		c.Status = VerifyWarn
+		c.Detail = fmt.Sprintf("the gather was interrupted: %d of %d table(s) completed", len(idx.CompletedTables), len(idx.Tables))
+		for _, t := range missing {
+			c.Problems = append(c.Problems, t+": not exported")
+		}
+	case len(missing) > 0:
+		c.Status, c.Detail = VerifyFail, fmt.Sprintf("%d table(s) listed in index.json without a summary.json", len(missing))
+		c.Problems = missing
+	}
+	return c
+}
+
+// Render writes r as text: a line per check, with its first problems.
+func (r *VerifyReport) Render(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "Bundle: %s\n", r.Bundle)
+	fmt.Fprintf(tw, "Files: %d, %s; %d table(s), %d part(s), %d row(s)\n\n", r.Files, utils.FormatByteSize(r.Bytes), r.Tables, r.Parts, r.Rows)
+	for _, c := range r.Checks {
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", strings.ToUpper(c.Status), c.Name, c.Detail)
+		for i, p := range c.Problems {
+			if i == verifyMaxProblems {
+				fmt.Fprintf(tw, "\t\t  ... and %d more\n", len(c.Problems)-i)
+				break
+			}
+			fmt.Fprintf(tw, "\t\t  - %s\n", p)
+		}
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,147 @@
+package mustgather
+
+import (
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"kubectl-must-gather/pkg/utils"
+)
+
+var verifyTestFiles = map[string]string{
+	"metadata/bundle.json":           `{"bundleFormatVersion":2}`,
+	"index.json":                     `{"tables":["KubeEvents","Heartbeat"]}`,
+	"tables/KubeEvents/summary.json": `{"table":"KubeEvents","rows":2}`,
+	"tables/KubeEvents/parts/0000-2024-01-01T10:00:00Z_2024-01-01T10:15:00Z.ndjson": `{"Reason":"BackOff"}` + "\n" + `{"Reason":"Killing"}` + "\n",
+	"tables/Heartbeat/summary.json": `{"table":"Heartbeat","rows":1,"truncatedWindows":["2024-01-01T10:00:00Z/2024-01-01T10:01:00Z"]}`,
+	"tables/Heartbeat/parts/0000-2024-01-01T10:00:00Z_2024-01-01T10:15:00Z.ndjson": `{"Computer":"node-1"}` + "\n",
+	"namespaces/shop/events/events.log":                                            "BackOff\nKilling\n",
+}
+
+// writeVerifyArchive writes files as a gathered archive, with its checksum
+// manifest.
+func writeVerifyArchive(t *testing.T, files map[string]string) string {
+	t.Helper()
+	name := filepath.Join(t.TempDir(), "bundle.tar.gz")
+	f, err := os.Create(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gz := gzip.NewWriter(f)
+	tarw := utils.NewTarWriter(gz, time.Now())
+	var paths []string
+	for p := range files {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	for _, p := range paths {
+		if err := utils.WriteFileToTar(tarw, p, []byte(files[p])); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tarw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	gz.Close()
+	f.Close()
+	if _, err := writeManifest(name, "", tarw.Files()); err != nil {
+		t.Fatal(err)
+	}
+	return name
+}
+
+func verifyStatuses(r *VerifyReport) string {
+	var s []string
+	for _, c := range r.Checks {
+		s = append(s, c.Name+"="+c.Status)
+	}
+	return strings.Join(s, ",")
+}
+
+func TestVerify(t *testing.T) {
+	archive := writeVerifyArchive(t, verifyTestFiles)
+	r, err := Verify(archive, VerifyOptions{})
+	if err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+	if got := verifyStatuses(r); got != "archive=pass,manifest=pass,format=pass,json=pass,tables=warn,gather=pass" {
+		t.Errorf("unexpected checks: %s", got)
+	}
+	if !r.OK() || r.Files != 7 || r.Tables != 2 || r.Parts != 2 || r.Rows != 3 {
+		t.Errorf("unexpected report: %+v", r)
+	}
+	var sb strings.Builder
+	if err := r.Render(&sb); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(sb.String(), "Heartbeat: 1 window(s) still truncated") {
+		t.Errorf("expected the truncated window in the report, got:\n%s", sb.String())
+	}
+
+	// A changed entry fails the manifest check
+	changed := map[string]string{}
+	for k, v := range verifyTestFiles {
+		changed[k] = v
+	}
+	changed["namespaces/shop/events/events.log"] = "BackOff\n"
+	other := writeVerifyArchive(t, changed)
+	if err := os.Rename(archive+manifestSuffix, other+manifestSuffix); err != nil {
+		t.Fatal(err)
+	}
+	r, _ = Verify(other, VerifyOptions{})
+	if got := verifyStatuses(r); !strings.Contains(got, "manifest=fail") || r.OK() {
+		t.Errorf("expected the manifest check to fail, got %s", got)
+	}
+	if p := r.Checks[1].Problems; len(p) != 2 || !strings.HasPrefix(p[0], "archive: ") || p[1] != "namespaces/shop/events/events.log: changed" {
+		t.Errorf("unexpected manifest problems: %v", r.Checks[1].Problems)
+	}
+
+	// An archive cut short
+	data, _ := os.ReadFile(other)
+	if err := os.WriteFile(other, data[:len(data)/2], 0o644); err != nil {
+		t.Fatal(err)
+	}
+	r, _ = Verify(other, VerifyOptions{Manifest: filepath.Join(t.TempDir(), "missing.json")})
+	if got := verifyStatuses(r); !strings.HasPrefix(got, "archive=fail,manifest=fail") {
+		t.Errorf("expected the archive and manifest checks to fail, got %s", got)
+	}
+}
+
+func TestVerifyDirectory(t *testing.T) {
+	files := map[string]string{
+		"metadata/bundle.json":                  `{"bundleFormatVersion":2}`,
+		"metadata/workspace.json":               `{"workspaceID":`,
+		"index.json":                            `{"tables":["KubeEvents","Perf","Syslog"],"incomplete":true,"completedTables":["KubeEvents"]}`,
+		"tables/KubeEvents/summary.json":        `{"table":"KubeEvents","rows":3}`,
+		"tables/KubeEvents/parts/0000-a.ndjson": `{"Reason":"BackOff"}` + "\n" + `{"Reason":"Killing"}` + "\n",
+		"tables/Perf/summary.json":              `{"table":"Perf","rows":2,"incomplete":true}`,
+		// Cut short by an interrupted gather
+		"tables/Perf/parts/0000-a.ndjson": `{"CounterValue":1}` + "\n" + `{"Counter`,
+	}
+	r, err := Verify(writeBundleDir(t, files), VerifyOptions{})
+	if err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+	if got := verifyStatuses(r); got != "directory=pass,manifest=skip,format=pass,json=fail,tables=fail,gather=warn" {
+		t.Errorf("unexpected checks: %s", got)
+	}
+	want := []string{
+		"tables/Perf/parts/0000-a.ndjson: cut short in line 2",
+		"KubeEvents: 2 row(s) in its parts, summary.json counts 3",
+		"Perf: 1 row(s) in its parts, summary.json counts 2",
+		"Perf: export was interrupted",
+	}
+	if got := r.Checks[4].Problems; strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("unexpected table problems:\n%s", strings.Join(got, "\n"))
+	}
+	if got := r.Checks[3].Problems; strings.Join(got, ",") != "metadata/workspace.json: not valid JSON" {
+		t.Errorf("unexpected JSON problems: %v", got)
+	}
+	if got := r.Checks[5]; got.Detail != "the gather was interrupted: 1 of 3 table(s) completed" || strings.Join(got.Problems, ",") != "Syslog: not exported" {
+		t.Errorf("unexpected gather check: %+v", got)
+	}
+}
@@ -0,0 +1,361 @@
+package mustgather
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// watchStateSuffix names the state file of a watch, kept next to its
+// archives, or in the output directory of a watch writing directories.
+const watchStateSuffix = ".watch.json"
+
+// defaultWatchOutput is the archive name a watch rotates when OutputFile is
+// not set.
+const defaultWatchOutput = "must-gather.tar.gz"
+
+// WatchOptions configures Watch.
+type WatchOptions struct {
+	// Interval is the time between the starts of two gathers.
+	Interval time.Duration
+	// Environment overrides the Azure endpoints of every gather, as with
+	// NewGathererWithEnvironment.
+	Environment *Environment
+	// Triggers, when set, make the watch count the events of its rules
+	// every Interval and gather only when a rule fires.
+	Triggers *TriggerRules
+
+	// now and after stand in for time.Now and time.After in tests.
+	now   func() time.Time
+	after func(time.Duration) <-chan time.Time
+}
+
+// watchState is the content of the state file: where the last successful
+// gather ended, which the next one starts from, and the files of the gathers
+// kept.
+type watchState struct {
+	WindowEnd string     `json:"windowEnd,omitempty"`
+	Runs      []watchRun `json:"runs"`
+	// Triggered is the time each trigger rule last fired, for its cooldown.
+	Triggered map[string]string `json:"triggered,omitempty"`
+}
+
+type watchRun struct {
+	StartedAt   string   `json:"startedAt"`
+	Trigger     string   `json:"trigger,omitempty"`
+	WindowStart string   `json:"windowStart,omitempty"`
+	WindowEnd   string   `json:"windowEnd,omitempty"`
+	Status      string   `json:"status"`
+	Error       string   `json:"error,omitempty"`
+	Files       []string `json:"files"`
+}
+
+// Watch runs a gather every Interval until ctx is cancelled. The first one
+// covers Timespan, and each later one the time since the previous successful
+// one, so the window of a gather that failed, stopped early or failed to
+// export a table is covered by the next. Windows overlap by the time rows
+// take to be ingested, so rows ingested late are gathered too. Archives are named
+// after OutputFile with the start time of their gather inserted, e.g.
+// must-gather-20260101-120000.tar.gz, and the restricted archive likewise;
+// a directory or blob container output gets a must-gather-<time>
+// subdirectory or prefix per gather. The state file next to the archives,
+// in the output directory or, for a blob container, in the user config
+// directory lets a restarted watch resume where the last one stopped. Each
+// gather prunes the archives of earlier ones per the Keep and KeepDays of
+// config, as a single gather does.
+//
+// With Triggers, every Interval only the events of the rules are counted, and
+// each rule that fires starts a gather of its own tables over its timespan,
+// scoped to the namespaces that crossed its threshold.
+func Watch(ctx context.Context, config *Config, opts WatchOptions) error {
+	if opts.Interval <= 0 {
+		return errors.New("--watch needs a positive --interval")
+	}
+	if config.AIMode {
+		return errors.New("--watch applies to regular gathers, not AI mode")
+	}
+	now, after := opts.now, opts.after
+	if now == nil {
+		now = time.Now
+	}
+	if after == nil {
+		after = time.After
+	}
+	log := config.logOutput()
+	output := config.OutputFile
+	if output == "" {
+		output = defaultWatchOutput
+	}
+	format := config.outputFormat(output)
+	if err := config.checkOutput(false); err != nil {
+		return err
+	}
+	statePath, err := watchStatePath(format, output)
+	if err != nil {
+		return err
+	}
+	state, err := loadWatchState(statePath)
+	if err != nil {
+		return err
+	}
+	var probe *eventProbe
+	if opts.Triggers != nil {
+		if probe, err = newEventProbe(ctx, config, opts.Environment); err != nil {
+			return err
+		}
+		fmt.Fprintf(log, "Watching events for %d trigger rules every %s\n", len(opts.Triggers.Rules), opts.Interval)
+	} else if state.WindowEnd != "" {
+		fmt.Fprintf(log, "Resuming watch from %s, where the previous gather ended\n", state.WindowEnd)
+	}
+
+	for {
+		started := now()
+		// Whether a gather of this round pruned the files of earlier ones
+		pruned := false
+		if probe == nil {
+			cfg := *config
+			if end, err := time.Parse(time.RFC3339Nano, state.WindowEnd); err == nil {
+				// Rows of the end of the last window may have been ingested since
+				cfg.Since = end.Add(-cacheSettleDelay)
+			}
+			run := watchGather(ctx, &cfg, format, output, started, opts.Environment)
+			switch run.Status {
+			case "ok":
+				state.WindowEnd = run.WindowEnd
+			case "failed", "partial":
+				fmt.Fprintf(log, "  warn: gather failed, the next one covers its window: %s\n", run.Error)
+			}
+			pruned = run.Status == "ok" || run.Status == "partial"
+			state.Runs = append(state.Runs, run)
+		} else {
+			counts, err := probe.count(opts.Triggers.eventCountsQuery(), opts.Triggers.maxWindow(), started)
+			if err != nil && ctx.Err() == nil {
+				fmt.Fprintf(log, "  warn: counting events for the trigger rules failed: %v\n", err)
+			}
+			for _, f := range opts.Triggers.evaluate(counts, state.Triggered, started) {
+				if ctx.Err() != nil {
+					break
+				}
+				fmt.Fprintf(log, "Trigger %s fired: %d %s events in %s (%s)\n", f.rule.Name, f.events, f.rule.Reason, f.rule.Window, describeNamespaces(f.namespaces))
+				cfg := *config
+				f.apply(&cfg)
+				run := watchGather(ctx, &cfg, format, output, now(), opts.Environment)
+				run.Trigger = f.rule.Name
+				if run.Status == "failed" || run.Status == "partial" {
+					fmt.Fprintf(log, "  warn: gather of trigger %s failed: %s\n", f.rule.Name, run.Error)
+				}
+				pruned = pruned || run.Status == "ok" || run.Status == "partial"
+				state.Runs = append(state.Runs, run)
+				if state.Triggered == nil {
+					state.Triggered = map[string]string{}
+				}
+				state.Triggered[f.rule.Name] = started.UTC().Format(time.RFC3339)
+			}
+		}
+		// The gathers remove the files of earlier ones per Keep and KeepDays
+		// once they succeed; the state forgets those gathers with them
+		if drop := expiredRuns(state.Runs, config.Keep, config.KeepDays, started); pruned && drop > 0 {
+			state.Runs = append([]watchRun{}, state.Runs[drop:]...)
+		}
+		if err := state.save(statePath); err != nil {
+			return err
+		}
+
+		if ctx.Err() != nil {
+			return nil
+		}
+		next := started.Add(opts.Interval)
+		if probe == nil {
+			fmt.Fprintf(log, "Next gather at %s\n", next.Format(time.RFC3339))
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-after(next.Sub(now())):
+		}
+	}
+}
+
+// watchGather runs one gather of a watch into the output of the given format
+// named after output with the time started inserted, and returns its record
+// for the state file.
+func watchGather(ctx context.Context, cfg *Config, format, output string, started time.Time, env *Environment) watchRun {
+	cfg.OutputFile = rotatedOutput(format, output, started)
+	if cfg.RestrictedOutput != "" {
+		cfg.RestrictedOutput = rotatedName(cfg.RestrictedOutput, started)
+	}
+	g, r, err := runGather(ctx, cfg, env)
+	run := watchRun{StartedAt: started.UTC().Format(time.RFC3339), Status: "ok", Files: []string{}}
+	if g != nil {
+		if !g.start.IsZero() {
+			run.WindowStart, run.WindowEnd = g.start.Format(time.RFC3339Nano), g.end.Format(time.RFC3339Nano)
+		}
+		for _, f := range []string{r.Archive, r.RestrictedArchive, r.Manifest, r.Signature} {
+			if f != "" {
+				run.Files = append(run.Files, f)
+			}
+		}
+	}
+	switch {
+	case ctx.Err() != nil:
+		run.Status = "incomplete"
+	case err != nil:
+		run.Status, run.Error = "failed", err.Error()
+	case g != nil:
+		if failed := g.failedTables(); len(failed) > 0 {
+			run.Status, run.Error = "partial", "export failed for tables "+strings.Join(failed, ", ")
+		}
+	}
+	return run
+}
+
+// failedTables lists the tables whose export failed, in full or for some
+// chunks.
+func (g *Gatherer) failedTables() []string {
+	var failed []string
+	for _, o := range g.outcomes {
+		if o.err != nil || o.failedChunks > 0 {
+			failed = append(failed, o.table)
+		}
+	}
+	return failed
+}
+
+// describeNamespaces lists the namespaces a trigger fired for, where events
+// without a namespace are the cluster's own.
+func describeNamespaces(namespaces []string) string {
+	names := make([]string, len(namespaces))
+	for i, ns := range namespaces {
+		names[i] = ns
+		if ns == "" {
+			names[i] = "cluster"
+		}
+	}
+	return strings.Join(names, ", ")
+}
+
+// expiredRuns returns how many of the oldest runs retention drops: those
+// beyond the keep newest, and those started more than keepDays before now.
+func expiredRuns(runs []watchRun, keep, keepDays int, now time.Time) int {
+	drop := 0
+	if keep > 0 && len(runs) > keep {
+		drop = len(runs) - keep
+	}
+	for keepDays > 0 && drop < len(runs)-1 {
+		t, err := time.Parse(time.RFC3339, runs[drop].StartedAt)
+		if err != nil || now.Sub(t) <= time.Duration(keepDays)*24*time.Hour {
+			break
+		}
+		drop++
+	}
+	return drop
+}
+
+// runGather runs one gather of a watch or the controller, returning its
+// result and the gatherer for the window it ended with.
+func runGather(ctx context.Context, config *Config, env *Environment) (*Gatherer, *GatherResult, error) {
+	var gi GathererInterface
+	var err error
+	if env != nil {
+		gi, err = NewGathererWithEnvironment(ctx, config, *env)
+	} else {
+		gi, err = NewGatherer(ctx, config)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	g := gi.(*Gatherer)
+	r, err := g.Run()
+	return g, r, err
+}
+
+// rotatedOutput names the output of the given format of a watch gather
+// started at t: an archive named by rotatedName, or a must-gather-<time>
+// subdirectory or prefix below a directory or blob container output.
+func rotatedOutput(format, path string, t time.Time) string {
+	sub := "must-gather-" + t.Format(archiveStampLayout)
+	switch format {
+	case OutputDir:
+		return filepath.Join(path, sub) + string(filepath.Separator)
+	case OutputBlob:
+		u, err := url.Parse(path)
+		if err != nil {
+			return path
+		}
+		u.Path = strings.TrimSuffix(u.Path, "/") + "/" + sub
+		return u.String()
+	}
+	return rotatedName(path, t)
+}
+
+// rotatedName inserts the time t into the file name of path, before its
+// .tar.gz or other extension.
+func rotatedName(path string, t time.Time) string {
+	dir, base := filepath.Split(path)
+	ext := filepath.Ext(base)
+	if strings.HasSuffix(base, ".tar.gz") {
+		ext = ".tar.gz"
+	}
+	return dir + strings.TrimSuffix(base, ext) + "-" + t.Format("20060102-150405") + ext
+}
+
+// watchStatePath is the state file of a watch rotating output of the given
+// format: next to an archive, in an output directory, and in the user config
+// directory for a blob container, named after the container URL without
+// its SAS token.
+func watchStatePath(format, output string) (string, error) {
+	switch format {
+	case OutputDir:
+		return filepath.Join(output, watchStateSuffix), nil
+	case OutputBlob:
+		u, err := url.Parse(output)
+		if err != nil {
+			return "", fmt.Errorf("invalid --out: %w", err)
+		}
+		dir, err := os.UserConfigDir()
+		if err != nil {
+			return "", fmt.Errorf("--watch to a blob container keeps its state in the user config directory: %w", err)
+		}
+		sum := sha256.Sum256([]byte(u.Host + strings.TrimSuffix(u.Path, "/")))
+		return filepath.Join(dir, "aks-must-gather", "watch-"+hex.EncodeToString(sum[:8])+".json"), nil
+	}
+	dir, base := filepath.Split(output)
+	return dir + strings.TrimSuffix(base, ".tar.gz") + watchStateSuffix, nil
+}
+
+func loadWatchState(path string) (*watchState, error) {
+	state := &watchState{}
+	b, err := os.ReadFile(path)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		return state, nil
+	case err != nil:
+		return nil, fmt.Errorf("read watch state: %w", err)
+	}
+	if err := json.Unmarshal(b, state); err != nil {
+		return nil, fmt.Errorf("parse watch state %s: %w", path, err)
+	}
+	return state, nil
+}
+
+func (s *watchState) save(path string) error {
+	b, _ := json.MarshalIndent(s, "", "  ")
+	// The output directory or config directory may not exist yet when the
+	// first gather failed before writing anything
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("write watch state: %w", err)
+	}
+	if err := os.WriteFile(path, append(b, '\n'), 0o644); err != nil {
+		return fmt.Errorf("write watch state: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,280 @@
+package mustgather
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	azquery "github.com/Azure/azure-sdk-for-go/sdk/monitor/azquery"
+)
+
+func TestRotatedName(t *testing.T) {
+	at := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	tests := []struct{ path, want string }{
+		{"must-gather.tar.gz", "must-gather-20260102-030405.tar.gz"},
+		{"/var/diag/cluster.tar.gz", "/var/diag/cluster-20260102-030405.tar.gz"},
+		{"internal.tgz", "internal-20260102-030405.tgz"},
+		{"bundle", "bundle-20260102-030405"},
+	}
+	for _, tt := range tests {
+		if got := rotatedName(tt.path, at); got != tt.want {
+			t.Errorf("rotatedName(%q) = %q, want %q", tt.path, got, tt.want)
+		}
+	}
+	outputs := []struct{ format, path, want string }{
+		{OutputTarGz, "/var/diag/cluster.tar.gz", "/var/diag/cluster-20260102-030405.tar.gz"},
+		{OutputDir, "/var/diag/", "/var/diag/must-gather-20260102-030405/"},
+		{OutputBlob, "https://acct.blob.core.windows.net/diag/prod/?sig=abc", "https://acct.blob.core.windows.net/diag/prod/must-gather-20260102-030405?sig=abc"},
+	}
+	for _, tt := range outputs {
+		if got := rotatedOutput(tt.format, tt.path, at); got != tt.want {
+			t.Errorf("rotatedOutput(%s, %q) = %q, want %q", tt.format, tt.path, got, tt.want)
+		}
+	}
+	if got, _ := watchStatePath(OutputTarGz, "/var/diag/cluster.tar.gz"); got != "/var/diag/cluster.watch.json" {
+		t.Errorf("watchStatePath = %q", got)
+	}
+	if got, _ := watchStatePath(OutputDir, "/var/diag/"); got != "/var/diag/.watch.json" {
+		t.Errorf("watchStatePath of a directory = %q", got)
+	}
+	t.Setenv("XDG_CONFIG_HOME", "/home/u/.config")
+	a, _ := watchStatePath(OutputBlob, "https://acct.blob.core.windows.net/diag/prod?sig=abc")
+	b, _ := watchStatePath(OutputBlob, "https://acct.blob.core.windows.net/diag/prod/?sig=def")
+	c, _ := watchStatePath(OutputBlob, "https://acct.blob.core.windows.net/diag/test")
+	if a != b || a == c || !strings.HasPrefix(a, "/home/u/.config/aks-must-gather/watch-") {
+		t.Errorf("watchStatePath of blob containers = %q, %q, %q", a, b, c)
+	}
+}
+
+func TestWatchState(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "must-gather.watch.json")
+	state, err := loadWatchState(path)
+	if err != nil || state.WindowEnd != "" || len(state.Runs) != 0 {
+		t.Fatalf("missing state = %+v, %v", state, err)
+	}
+	state.WindowEnd = "2026-01-02T03:04:05Z"
+	state.Runs = append(state.Runs, watchRun{StartedAt: "2026-01-02T03:00:00Z", Status: "ok", Files: []string{"a.tar.gz"}})
+	if err := state.save(path); err != nil {
+		t.Fatal(err)
+	}
+	got, err := loadWatchState(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.WindowEnd != state.WindowEnd || len(got.Runs) != 1 || got.Runs[0].Files[0] != "a.tar.gz" {
+		t.Errorf("reloaded state = %+v", got)
+	}
+
+	if err := os.WriteFile(path, []byte("{"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadWatchState(path); err == nil {
+		t.Error("loadWatchState accepted a corrupt state file")
+	}
+}
+
+func TestWatchOptionsRejected(t *testing.T) {
+	tests := []struct {
+		name   string
+		config Config
+		opts   WatchOptions
+		want   string
+	}{
+		{"no interval", Config{}, WatchOptions{}, "--interval"},
+		{"ai mode", Config{AIMode: true}, WatchOptions{Interval: time.Hour}, "AI mode"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Watch(context.Background(), &tt.config, tt.opts)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Watch = %v, want an error about %s", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestExpiredRuns(t *testing.T) {
+	now := time.Date(2026, 1, 10, 12, 0, 0, 0, time.UTC)
+	var runs []watchRun
+	for _, days := range []int{9, 5, 2, 1, 0} {
+		runs = append(runs, watchRun{StartedAt: now.AddDate(0, 0, -days).Format(time.RFC3339)})
+	}
+	tests := []struct {
+		name           string
+		keep, keepDays int
+		want           int
+	}{
+		{"unlimited", 0, 0, 0},
+		{"keep 3", 3, 0, 2},
+		{"3 days", 0, 3, 2},
+		{"keep 4 and 3 days", 4, 3, 2},
+		{"keep 2 and 6 days", 2, 6, 3},
+		{"all older", 0, 1, 3},
+	}
+	for _, tt := range tests {
+		if got := expiredRuns(runs, tt.keep, tt.keepDays, now); got != tt.want {
+			t.Errorf("%s: expiredRuns = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+	// The newest run is always kept
+	if got := expiredRuns(runs[:1], 0, 1, now.AddDate(1, 0, 0)); got != 0 {
+		t.Errorf("the only run was dropped: %d", got)
+	}
+}
+
+// flakyLogs fails the queries of Perf while fail is set.
+type flakyLogs struct {
+	fakeLogs
+	fail bool
+}
+
+func (f *flakyLogs) QueryWorkspace(ctx context.Context, workspaceID string, body azquery.Body, options *azquery.LogsClientQueryWorkspaceOptions) (azquery.LogsClientQueryWorkspaceResponse, error) {
+	if f.fail && strings.HasPrefix(*body.Query, "Perf") {
+		return azquery.LogsClientQueryWorkspaceResponse{}, errors.New("query failed")
+	}
+	return f.fakeLogs.QueryWorkspace(ctx, workspaceID, body, options)
+}
+
+func TestWatchFailedTableKeepsWindow(t *testing.T) {
+	logs := &flakyLogs{fail: true}
+	dir := t.TempDir()
+	config := &Config{
+		WorkspaceID: "/subscriptions/sub/resourceGroups/rg/providers/Microsoft.OperationalInsights/workspaces/ws",
+		Timespan:    "PT1H",
+		OutputFile:  filepath.Join(dir, "cluster.tar.gz"),
+		TableFilter: "Heartbeat,Perf",
+		Quiet:       true,
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	clock := time.Date(2026, 1, 2, 3, 0, 0, 0, time.UTC)
+	opts := WatchOptions{
+		Interval: time.Minute,
+		Environment: &Environment{Credential: noCredential{}, LogsClient: logs,
+			WorkspacesClient: fakeWorkspaces{}, TablesClient: fakeTables{names: []string{"Heartbeat", "Perf"}}},
+		now: func() time.Time { return clock },
+		after: func(time.Duration) <-chan time.Time {
+			if !logs.fail {
+				cancel()
+				return nil
+			}
+			// Perf is queried again by the next gather
+			logs.fail = false
+			clock = clock.Add(time.Minute)
+			ch := make(chan time.Time, 1)
+			ch <- clock
+			return ch
+		},
+	}
+	if err := Watch(ctx, config, opts); err != nil {
+		t.Fatalf("Watch failed: %v", err)
+	}
+	state, err := loadWatchState(filepath.Join(dir, "cluster.watch.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(state.Runs) != 2 || state.Runs[0].Status != "partial" || !strings.Contains(state.Runs[0].Error, "Perf") || state.Runs[1].Status != "ok" {
+		t.Fatalf("unexpected runs %+v", state.Runs)
+	}
+	// The second gather covers the timespan again, not the time since the first
+	first, _ := time.Parse(time.RFC3339Nano, state.Runs[0].WindowStart)
+	second, _ := time.Parse(time.RFC3339Nano, state.Runs[1].WindowStart)
+	if second.Sub(first) > 5*time.Second || state.WindowEnd != state.Runs[1].WindowEnd {
+		t.Errorf("the window of the partial gather was not gathered again: %+v", state)
+	}
+}
+
+// watchOnce runs one gather of a watch of config, returning its state.
+func watchOnce(t *testing.T, config *Config, statePath string) *watchState {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	opts := WatchOptions{
+		Interval: time.Hour,
+		Environment: &Environment{Credential: noCredential{}, LogsClient: &fakeLogs{},
+			WorkspacesClient: fakeWorkspaces{}, TablesClient: fakeTables{names: []string{"Heartbeat"}}},
+		now: func() time.Time { return time.Date(2026, 1, 2, 3, 0, 0, 0, time.Local) },
+		after: func(time.Duration) <-chan time.Time {
+			cancel()
+			return nil
+		},
+	}
+	if err := Watch(ctx, config, opts); err != nil {
+		t.Fatalf("Watch failed: %v", err)
+	}
+	state, err := loadWatchState(statePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(state.Runs) != 1 || state.Runs[0].Status != "ok" {
+		t.Fatalf("unexpected runs %+v", state.Runs)
+	}
+	return state
+}
+
+func TestWatchOutputs(t *testing.T) {
+	base := Config{
+		WorkspaceID: "/subscriptions/sub/resourceGroups/rg/providers/Microsoft.OperationalInsights/workspaces/ws",
+		Timespan:    "PT1H",
+		TableFilter: "Heartbeat",
+		Quiet:       true,
+	}
+
+	t.Run("archive", func(t *testing.T) {
+		dir := t.TempDir()
+		config := base
+		config.OutputFile = filepath.Join(dir, "cluster.tar.gz")
+		watchOnce(t, &config, filepath.Join(dir, "cluster.watch.json"))
+		if _, err := os.Stat(filepath.Join(dir, "cluster-20260102-030000.tar.gz")); err != nil {
+			t.Error(err)
+		}
+	})
+
+	t.Run("directory", func(t *testing.T) {
+		out := filepath.Join(t.TempDir(), "out") + string(filepath.Separator)
+		config := base
+		config.OutputFile = out
+		state := watchOnce(t, &config, filepath.Join(out, watchStateSuffix))
+		if _, err := os.Stat(filepath.Join(out, "must-gather-20260102-030000", "index.json")); err != nil {
+			t.Errorf("expected the bundle in a subdirectory: %v (files %v)", err, state.Runs[0].Files)
+		}
+	})
+
+	t.Run("blob container", func(t *testing.T) {
+		svc := &fakeBlobService{blocks: map[string]string{}, lists: map[string]string{}}
+		srv := httptest.NewServer(svc)
+		defer srv.Close()
+		t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+		config := base
+		config.OutputFile = srv.URL + "/diag/prod?sig=abc"
+		config.OutputFormat = OutputBlob
+		statePath, err := watchStatePath(OutputBlob, config.OutputFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		state := watchOnce(t, &config, statePath)
+		svc.mu.Lock()
+		defer svc.mu.Unlock()
+		if _, ok := svc.lists["/diag/prod/must-gather-20260102-030000/index.json"]; !ok {
+			t.Errorf("expected the bundle below a prefix, got %v", svc.requests)
+		}
+		if strings.Contains(strings.Join(state.Runs[0].Files, " "), "sig=") {
+			t.Errorf("the SAS token was recorded in the state: %v", state.Runs[0].Files)
+		}
+	})
+
+	t.Run("keep of a directory", func(t *testing.T) {
+		config := base
+		config.OutputFile = t.TempDir() + string(filepath.Separator)
+		config.Keep = 3
+		err := Watch(context.Background(), &config, WatchOptions{Interval: time.Hour})
+		if err == nil || !strings.Contains(err.Error(), "archive output") {
+			t.Errorf("Watch = %v, want an error about the output", err)
+		}
+	})
+}
@@ -0,0 +1,636 @@
+package testhelpers
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/cloud"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+)
+
+// EmulatedColumn is a column of an emulated Log Analytics table.
+type EmulatedColumn struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
+
+// EmulatedTable is a canned Log Analytics table: its schema and all its rows.
+type EmulatedTable struct {
+	Name    string
+	Columns []EmulatedColumn
+	Rows    [][]any
+}
+
+// LogAnalyticsEmulator is an httptest server implementing the subset of the
+// Azure Resource Manager and Log Analytics query APIs used by a gather:
+// workspace lookup, table listing and schemas, AKS managed cluster listing
+// and lookup, and workspace queries, sent individually or through the $batch
+// endpoint.
+//
+// Queries are not evaluated as KQL. A query returns the rows of the table it
+// starts with whose TimeGenerated falls in the request timespan; the freshness
+// "union ... summarize max(TimeGenerated)" probe and the table size probe are
+// answered from the same rows, and "print a=1, ..." with a row of its values.
+// Other query shapes can be answered with SetQueryResult.
+type LogAnalyticsEmulator struct {
+	Server         *httptest.Server
+	SubscriptionID string
+	ResourceGroup  string
+	WorkspaceName  string
+	WorkspaceGUID  string
+
+	mu       sync.Mutex
+	tables   map[string]EmulatedTable
+	canned   map[string]EmulatedTable
+	clusters []EmulatedCluster
+	queries  []string
+	throttle []throttleResponse
+	rowLimit int
+	batches  int
+	denied   map[string]bool
+}
+
+// EmulatedCluster is an AKS managed cluster served by the emulator in its
+// subscription and resource group. With Monitored, its monitoring addon
+// sends to the emulated workspace.
+type EmulatedCluster struct {
+	Name      string
+	FQDN      string
+	Monitored bool
+}
+
+type throttleResponse struct {
+	status     int
+	retryAfter string
+}
+
+// NewLogAnalyticsEmulator starts an emulator serving the given tables. Call
+// Close when done.
+func NewLogAnalyticsEmulator(tables ...EmulatedTable) *LogAnalyticsEmulator {
+	e := &LogAnalyticsEmulator{
+		SubscriptionID: "00000000-0000-0000-0000-000000000001",
+		ResourceGroup:  "rg-test",
+		WorkspaceName:  "ws-test",
+		WorkspaceGUID:  "11111111-1111-1111-1111-111111111111",
+		tables:         map[string]EmulatedTable{},
+		canned:         map[string]EmulatedTable{},
+		denied:         map[string]bool{},
+	}
+	for _, t := range tables {
+		e.tables[t.Name] = t
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/subscriptions/", e.handleARM)
+	mux.HandleFunc("/v1/workspaces/", e.handleQuery)
+	mux.HandleFunc("/v1/$batch", e.handleBatch)
+	// The SDKs refuse to send bearer tokens over plain HTTP
+	e.Server = httptest.NewTLSServer(mux)
+	return e
+}
+
+// Close shuts the emulator down.
+func (e *LogAnalyticsEmulator) Close() {
+	e.Server.Close()
+}
+
+// WorkspaceID returns the ARM resource ID of the emulated workspace.
+func (e *LogAnalyticsEmulator) WorkspaceID() string {
+	return fmt.Sprintf("/subscriptions/%s/resourceGroups/%s/providers/Microsoft.OperationalInsights/workspaces/%s", e.SubscriptionID, e.ResourceGroup, e.WorkspaceName)
+}
+
+// Cloud returns a cloud configuration pointing ARM and Log Analytics at the
+// emulator.
+func (e *LogAnalyticsEmulator) Cloud() cloud.Configuration {
+	return cloud.Configuration{
+		ActiveDirectoryAuthorityHost: e.Server.URL,
+		Services: map[cloud.ServiceName]cloud.ServiceConfiguration{
+			cloud.ResourceManager: {Endpoint: e.Server.URL, Audience: e.Server.URL},
+			"azqueryLogs":         {Endpoint: e.Server.URL + "/v1", Audience: e.Server.URL},
+		},
+	}
+}
+
+// Client returns an HTTP client that trusts the emulator's certificate.
+func (e *LogAnalyticsEmulator) Client() *http.Client {
+	return e.Server.Client()
+}
+
+// Identity of the emulator's credential, as claimed in its tokens.
+const (
+	EmulatorTenantID = "72f988bf-0000-0000-0000-000000000001"
+	EmulatorObjectID = "5d6f0c4e-0000-0000-0000-000000000002"
+	EmulatorUPN      = "gatherer@contoso.example"
+)
+
+// emulatorToken is an unsigned JWT carrying the emulator identity, which the
+// emulator accepts without checking.
+var emulatorToken = func() string {
+	enc := func(v any) string {
+		b, _ := json.Marshal(v)
+		return base64.RawURLEncoding.EncodeToString(b)
+	}
+	return enc(map[string]string{"alg": "none", "typ": "JWT"}) + "." + enc(map[string]string{
+		"tid": EmulatorTenantID, "oid": EmulatorObjectID, "upn": EmulatorUPN, "name": "Must Gather", "idtyp": "user",
+	}) + "."
+}()
+
+// Credential returns a credential issuing a static token accepted by the
+// emulator.
+func (e *LogAnalyticsEmulator) Credential() azcore.TokenCredential {
+	return staticCredential{}
+}
+
+// AddManagedCluster serves c and returns its ARM resource ID.
+func (e *LogAnalyticsEmulator) AddManagedCluster(c EmulatedCluster) string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.clusters = append(e.clusters, c)
+	return e.clusterID(c.Name)
+}
+
+func (e *LogAnalyticsEmulator) clusterID(name string) string {
+	return fmt.Sprintf("/subscriptions/%s/resourceGroups/%s/providers/Microsoft.ContainerService/managedClusters/%s", e.SubscriptionID, e.ResourceGroup, name)
+}
+
+// SetQueryResult makes queries starting with prefix return result instead of
+// table rows.
+func (e *LogAnalyticsEmulator) SetQueryResult(prefix string, result EmulatedTable) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.canned[prefix] = result
+}
+
+// Throttle makes the next n queries fail with status (e.g. 429 or 503) and
+// the given Retry-After header, if not empty.
+func (e *LogAnalyticsEmulator) Throttle(n, status int, retryAfter string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for i := 0; i < n; i++ {
+		e.throttle = append(e.throttle, throttleResponse{status: status, retryAfter: retryAfter})
+	}
+}
+
+// SetRowLimit caps table query results at n rows, answering larger results
+// with the first n rows and a partial E_QUERY_RESULT_SET_TOO_LARGE error, as
+// Log Analytics does. Zero removes the cap.
+func (e *LogAnalyticsEmulator) SetRowLimit(n int) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.rowLimit = n
+}
+
+// RBAC actions the emulator checks, for Deny.
+const (
+	ActionWorkspaceRead = "Microsoft.OperationalInsights/workspaces/read"
+	ActionTablesRead    = "Microsoft.OperationalInsights/workspaces/tables/read"
+	ActionQueryRead     = "Microsoft.OperationalInsights/workspaces/query/read"
+	ActionClusterRead   = "Microsoft.ContainerService/managedClusters/read"
+)
+
+// Deny makes requests needing the RBAC action fail with 403, as when the
+// credential has no role granting it: AuthorizationFailed from ARM,
+// InsufficientAccessError from the query API.
+func (e *LogAnalyticsEmulator) Deny(action string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.denied[action] = true
+}
+
+// authorize writes an ARM AuthorizationFailed error and returns false if
+// action is denied. e.mu must be held.
+func (e *LogAnalyticsEmulator) authorize(w http.ResponseWriter, r *http.Request, action string) bool {
+	if !e.denied[action] {
+		return true
+	}
+	writeError(w, http.StatusForbidden, "AuthorizationFailed", fmt.Sprintf(
+		"The client 'emulator' with object id '00000000-0000-0000-0000-000000000000' does not have authorization to perform action '%s' over scope '%s' or the scope is invalid.",
+		action, r.URL.Path))
+	return false
+}
+
+// Batches returns the number of $batch requests received so far.
+func (e *LogAnalyticsEmulator) Batches() int {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.batches
+}
+
+// Queries returns the queries received so far, in order.
+func (e *LogAnalyticsEmulator) Queries() []string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return append([]string(nil), e.queries...)
+}
+
+type staticCredential struct{}
+
+func (staticCredential) GetToken(ctx context.Context, opts policy.TokenRequestOptions) (azcore.AccessToken, error) {
+	return azcore.AccessToken{Token: emulatorToken, ExpiresOn: time.Now().Add(time.Hour)}, nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, code, msg string) {
+	writeJSON(w, status, map[string]any{"error": map[string]any{"code": code, "message": msg}})
+}
+
+func (e *LogAnalyticsEmulator) handleARM(w http.ResponseWriter, r *http.Request) {
+	if e.handleClusters(w, r) {
+		return
+	}
+	prefix := "/" + strings.TrimPrefix(e.WorkspaceID(), "/")
+	path := r.URL.Path
+	if !strings.EqualFold(path, prefix) && !strings.HasPrefix(strings.ToLower(path), strings.ToLower(prefix)+"/") {
+		writeError(w, http.StatusNotFound, "ResourceNotFound", "resource not found: "+path)
+		return
+	}
+	rest := strings.Trim(path[len(prefix):], "/")
+	action := ActionWorkspaceRead
+	if rest != "" {
+		action = ActionTablesRead
+	}
+	e.mu.Lock()
+	ok := e.authorize(w, r, action)
+	e.mu.Unlock()
+	if !ok {
+		return
+	}
+	switch {
+	case rest == "":
+		writeJSON(w, http.StatusOK, map[string]any{
+			"id":       e.WorkspaceID(),
+			"name":     e.WorkspaceName,
+			"location": "eastus",
+			"properties": map[string]any{
+				"customerId": e.WorkspaceGUID,
+			},
+		})
+	case rest == "tables":
+		var value []any
+		e.mu.Lock()
+		for _, t := range e.tables {
+			value = append(value, e.tableResource(t))
+		}
+		e.mu.Unlock()
+		writeJSON(w, http.StatusOK, map[string]any{"value": value})
+	case strings.HasPrefix(rest, "tables/"):
+		name := strings.TrimPrefix(rest, "tables/")
+		e.mu.Lock()
+		t, ok := e.tables[name]
+		e.mu.Unlock()
+		if !ok {
+			writeError(w, http.StatusNotFound, "ResourceNotFound", "table not found: "+name)
+			return
+		}
+		writeJSON(w, http.StatusOK, e.tableResource(t))
+	default:
+		writeError(w, http.StatusNotFound, "ResourceNotFound", "resource not found: "+path)
+	}
+}
+
+// handleClusters serves the subscription's managed cluster list and each
+// cluster, and reports whether the request was for either.
+func (e *LogAnalyticsEmulator) handleClusters(w http.ResponseWriter, r *http.Request) bool {
+	path := strings.ToLower(strings.TrimSuffix(r.URL.Path, "/"))
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if path == strings.ToLower("/subscriptions/"+e.SubscriptionID+"/providers/Microsoft.ContainerService/managedClusters") {
+		if !e.authorize(w, r, ActionClusterRead) {
+			return true
+		}
+		value := []any{}
+		for _, c := range e.clusters {
+			value = append(value, e.clusterResource(c))
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"value": value})
+		return true
+	}
+	prefix := strings.ToLower(e.clusterID(""))
+	if !strings.HasPrefix(path, prefix) {
+		return false
+	}
+	if !e.authorize(w, r, ActionClusterRead) {
+		return true
+	}
+	for _, c := range e.clusters {
+		if strings.EqualFold(path[len(prefix):], c.Name) {
+			writeJSON(w, http.StatusOK, e.clusterResource(c))
+			return true
+		}
+	}
+	writeError(w, http.StatusNotFound, "ResourceNotFound", "cluster not found: "+r.URL.Path)
+	return true
+}
+
+func (e *LogAnalyticsEmulator) clusterResource(c EmulatedCluster) map[string]any {
+	addons := map[string]any{}
+	if c.Monitored {
+		addons["omsagent"] = map[string]any{
+			"enabled": true,
+			"config":  map[string]any{"logAnalyticsWorkspaceResourceID": e.WorkspaceID()},
+		}
+	}
+	return map[string]any{
+		"id":       e.clusterID(c.Name),
+		"name":     c.Name,
+		"location": "eastus",
+		"properties": map[string]any{
+			"fqdn":          c.FQDN,
+			"addonProfiles": addons,
+		},
+	}
+}
+
+func (e *LogAnalyticsEmulator) tableResource(t EmulatedTable) map[string]any {
+	return map[string]any{
+		"id":   e.WorkspaceID() + "/tables/" + t.Name,
+		"name": t.Name,
+		"properties": map[string]any{
+			"retentionInDays": 30,
+			"schema":          map[string]any{"name": t.Name, "columns": t.Columns},
+		},
+	}
+}
+
+var (
+	leadingTable = regexp.MustCompile(`^\s*\[?'?([A-Za-z_][A-Za-z0-9_]*)`)
+	unionTable   = regexp.MustCompile(`\['([^']+)'\]`)
+	// timeAfter is the TimeGenerated filter of incremental gathers
+	timeAfter = regexp.MustCompile(`\|\s*where\s+TimeGenerated\s*>\s*datetime\(([^)]+)\)`)
+)
+
+func (e *LogAnalyticsEmulator) handleQuery(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost || !strings.HasSuffix(r.URL.Path, "/query") {
+		writeError(w, http.StatusNotFound, "PathNotFoundError", "unsupported path: "+r.URL.Path)
+		return
+	}
+	guid := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/v1/workspaces/"), "/query")
+	var body queryBody
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, "BadArgumentError", err.Error())
+		return
+	}
+	res := e.answer(guid, body)
+	for k, v := range res.header {
+		w.Header().Set(k, v)
+	}
+	writeJSON(w, res.status, res.body)
+}
+
+// handleBatch answers a $batch request by running each query in it as if it
+// had been sent on its own.
+func (e *LogAnalyticsEmulator) handleBatch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusNotFound, "PathNotFoundError", "unsupported method: "+r.Method)
+		return
+	}
+	var batch struct {
+		Requests []struct {
+			ID        string    `json:"id"`
+			Workspace string    `json:"workspace"`
+			Body      queryBody `json:"body"`
+		} `json:"requests"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&batch); err != nil {
+		writeError(w, http.StatusBadRequest, "BadArgumentError", err.Error())
+		return
+	}
+	e.mu.Lock()
+	e.batches++
+	e.mu.Unlock()
+	responses := make([]any, 0, len(batch.Requests))
+	for _, req := range batch.Requests {
+		res := e.answer(req.Workspace, req.Body)
+		responses = append(responses, map[string]any{"id": req.ID, "status": res.status, "headers": res.header, "body": res.body})
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"responses": responses})
+}
+
+type queryBody struct {
+	Query    string `json:"query"`
+	Timespan string `json:"timespan"`
+}
+
+type queryAnswer struct {
+	status int
+	header map[string]string
+	body   map[string]any
+}
+
+func errorAnswer(status int, code, msg string) queryAnswer {
+	return queryAnswer{status: status, body: map[string]any{"error": map[string]any{"code": code, "message": msg}}}
+}
+
+// answer runs a single workspace query.
+func (e *LogAnalyticsEmulator) answer(guid string, body queryBody) queryAnswer {
+	if guid != e.WorkspaceGUID {
+		return errorAnswer(http.StatusNotFound, "WorkspaceNotFoundError", "workspace not found: "+guid)
+	}
+	start, end, err := parseTimespan(body.Timespan)
+	if err != nil {
+		return errorAnswer(http.StatusBadRequest, "BadArgumentError", err.Error())
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.denied[ActionQueryRead] {
+		return errorAnswer(http.StatusForbidden, "InsufficientAccessError", "The provided credentials have insufficient access to perform the requested operation")
+	}
+	e.queries = append(e.queries, body.Query)
+
+	if len(e.throttle) > 0 {
+		th := e.throttle[0]
+		e.throttle = e.throttle[1:]
+		res := errorAnswer(th.status, "ThrottledError", "too many requests")
+		if th.retryAfter != "" {
+			res.header = map[string]string{"Retry-After": th.retryAfter}
+		}
+		return res
+	}
+
+	for prefix, res := range e.canned {
+		if strings.HasPrefix(body.Query, prefix) {
+			return queryAnswer{status: http.StatusOK, body: queryResponse(res.Columns, res.Rows)}
+		}
+	}
+	if strings.HasPrefix(strings.TrimSpace(body.Query), "print ") {
+		// One row with the printed values as columns of type string
+		var cols []EmulatedColumn
+		var row []any
+		for _, expr := range strings.Split(strings.TrimPrefix(strings.TrimSpace(body.Query), "print "), ",") {
+			name, value, _ := strings.Cut(strings.TrimSpace(expr), "=")
+			cols = append(cols, EmulatedColumn{Name: strings.TrimSpace(name), Type: "string"})
+			row = append(row, strings.Trim(strings.TrimSpace(value), `"'`))
+		}
+		return queryAnswer{status: http.StatusOK, body: queryResponse(cols, [][]any{row})}
+	}
+	if strings.HasPrefix(strings.TrimSpace(body.Query), "union") {
+		if strings.Contains(body.Query, "count()") {
+			return queryAnswer{status: http.StatusOK, body: e.tableSizes(body.Query, start, end)}
+		}
+		return queryAnswer{status: http.StatusOK, body: e.lastTimeGenerated(body.Query, start, end)}
+	}
+	m := leadingTable.FindStringSubmatch(body.Query)
+	if m == nil {
+		return errorAnswer(http.StatusBadRequest, "SyntaxError", "cannot parse query: "+body.Query)
+	}
+	t, ok := e.tables[m[1]]
+	if !ok {
+		return errorAnswer(http.StatusBadRequest, "BadArgumentError", fmt.Sprintf("'%s' could not be resolved to a table", m[1]))
+	}
+	rows := rowsInWindow(t, start, end)
+	if m := timeAfter.FindStringSubmatch(body.Query); m != nil {
+		rows = rowsAfter(t, rows, m[1])
+	}
+	if e.rowLimit > 0 && len(rows) > e.rowLimit {
+		resp := queryResponse(t.Columns, rows[:e.rowLimit])
+		resp["error"] = map[string]any{
+			"code":    "PartialError",
+			"message": "There were some errors when processing your query.",
+			"details": []any{map[string]any{
+				"code":    "E_QUERY_RESULT_SET_TOO_LARGE",
+				"message": fmt.Sprintf("Query result set has exceeded the internal limit of %d records.", e.rowLimit),
+			}},
+		}
+		return queryAnswer{status: http.StatusOK, body: resp}
+	}
+	return queryAnswer{status: http.StatusOK, body: queryResponse(t.Columns, rows)}
+}
+
+// tableSizes answers the "summarize Rows=count(), Bytes=..." size probe for
+// every table named in a union query. Bytes is the JSON size of the rows.
+func (e *LogAnalyticsEmulator) tableSizes(query string, start, end time.Time) map[string]any {
+	var rows [][]any
+	for _, m := range unionTable.FindAllStringSubmatch(query, -1) {
+		t, ok := e.tables[m[1]]
+		if !ok {
+			continue
+		}
+		in := rowsInWindow(t, start, end)
+		if len(in) == 0 {
+			continue
+		}
+		var size int
+		for _, row := range in {
+			b, _ := json.Marshal(row)
+			size += len(b)
+		}
+		rows = append(rows, []any{t.Name, len(in), size})
+	}
+	return queryResponse([]EmulatedColumn{{Name: "SourceTable", Type: "string"}, {Name: "Rows", Type: "long"}, {Name: "Bytes", Type: "long"}}, rows)
+}
+
+// lastTimeGenerated answers the freshness probe for every table named in a
+// union query.
+func (e *LogAnalyticsEmulator) lastTimeGenerated(query string, start, end time.Time) map[string]any {
+	var rows [][]any
+	for _, m := range unionTable.FindAllStringSubmatch(query, -1) {
+		t, ok := e.tables[m[1]]
+		if !ok {
+			continue
+		}
+		var last time.Time
+		idx := timeColumn(t)
+		for _, row := range rowsInWindow(t, start, end) {
+			if ts := rowTime(row, idx); ts.After(last) {
+				last = ts
+			}
+		}
+		if !last.IsZero() {
+			rows = append(rows, []any{t.Name, last.UTC().Format(time.RFC3339Nano)})
+		}
+	}
+	return queryResponse([]EmulatedColumn{{Name: "SourceTable", Type: "string"}, {Name: "LastTimeGenerated", Type: "datetime"}}, rows)
+}
+
+func queryResponse(columns []EmulatedColumn, rows [][]any) map[string]any {
+	if rows == nil {
+		rows = [][]any{}
+	}
+	return map[string]any{
+		"tables": []any{map[string]any{"name": "PrimaryResult", "columns": columns, "rows": rows}},
+	}
+}
+
+func parseTimespan(s string) (time.Time, time.Time, error) {
+	if s == "" {
+		return time.Time{}, time.Time{}, nil
+	}
+	parts := strings.SplitN(s, "/", 2)
+	if len(parts) != 2 {
+		return time.Time{}, time.Time{}, fmt.Errorf("unsupported timespan %q", s)
+	}
+	start, err := time.Parse(time.RFC3339Nano, parts[0])
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("invalid timespan start: %w", err)
+	}
+	end, err := time.Parse(time.RFC3339Nano, parts[1])
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("invalid timespan end: %w", err)
+	}
+	return start, end, nil
+}
+
+func timeColumn(t EmulatedTable) int {
+	for i, c := range t.Columns {
+		if c.Name == "TimeGenerated" {
+			return i
+		}
+	}
+	return -1
+}
+
+func rowTime(row []any, idx int) time.Time {
+	if idx < 0 || idx >= len(row) {
+		return time.Time{}
+	}
+	s, _ := row[idx].(string)
+	ts, _ := time.Parse(time.RFC3339Nano, s)
+	return ts
+}
+
+// rowsInWindow returns the rows with TimeGenerated in [start, end). Tables
+// without a TimeGenerated column, and an empty window, match every row.
+func rowsInWindow(t EmulatedTable, start, end time.Time) [][]any {
+	idx := timeColumn(t)
+	if idx < 0 || (start.IsZero() && end.IsZero()) {
+		return t.Rows
+	}
+	var out [][]any
+	for _, row := range t.Rows {
+		ts := rowTime(row, idx)
+		if !ts.Before(start) && ts.Before(end) {
+			out = append(out, row)
+		}
+	}
+	return out
+}
+
+// rowsAfter keeps the rows with TimeGenerated after the RFC 3339 time after.
+func rowsAfter(t EmulatedTable, rows [][]any, after string) [][]any {
+	idx := timeColumn(t)
+	since, err := time.Parse(time.RFC3339Nano, strings.TrimSpace(after))
+	if idx < 0 || err != nil {
+		return rows
+	}
+	var out [][]any
+	for _, row := range rows {
+		if rowTime(row, idx).After(since) {
+			out = append(out, row)
+		}
+	}
+	return out
+}
@@ -0,0 +1,163 @@
+package testhelpers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/arm"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	azquery "github.com/Azure/azure-sdk-for-go/sdk/monitor/azquery"
+	armoperationalinsights "github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/operationalinsights/armoperationalinsights"
+)
+
+func newTestEmulator() *LogAnalyticsEmulator {
+	return NewLogAnalyticsEmulator(EmulatedTable{
+		Name:    "Heartbeat",
+		Columns: []EmulatedColumn{{Name: "TimeGenerated", Type: "datetime"}, {Name: "Computer", Type: "string"}},
+		Rows: [][]any{
+			{"2024-01-01T00:10:00Z", "node-1"},
+			{"2024-01-01T00:50:00Z", "node-2"},
+			{"2024-01-01T02:00:00Z", "node-3"},
+		},
+	})
+}
+
+func TestLogAnalyticsEmulatorQueries(t *testing.T) {
+	e := newTestEmulator()
+	defer e.Close()
+
+	opts := &azquery.LogsClientOptions{ClientOptions: azcore.ClientOptions{Cloud: e.Cloud(), Transport: e.Client()}}
+	lcli, err := azquery.NewLogsClient(e.Credential(), opts)
+	if err != nil {
+		t.Fatalf("logs client: %v", err)
+	}
+	query := func(q string) (azquery.LogsClientQueryWorkspaceResponse, error) {
+		start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+		body := azquery.Body{Query: to.Ptr(q), Timespan: to.Ptr(azquery.NewTimeInterval(start, start.Add(time.Hour)))}
+		return lcli.QueryWorkspace(context.Background(), e.WorkspaceGUID, body, nil)
+	}
+
+	res, err := query("Heartbeat")
+	if err != nil {
+		t.Fatalf("query failed: %v", err)
+	}
+	if got := len(res.Tables[0].Rows); got != 2 {
+		t.Errorf("expected 2 rows in the window, got %d", got)
+	}
+
+	res, err = query("union isfuzzy=true withsource=SourceTable ['Heartbeat'], ['Missing'] | summarize LastTimeGenerated=max(TimeGenerated) by SourceTable")
+	if err != nil {
+		t.Fatalf("union query failed: %v", err)
+	}
+	if rows := res.Tables[0].Rows; len(rows) != 1 || rows[0][0] != "Heartbeat" || rows[0][1] != "2024-01-01T00:50:00Z" {
+		t.Errorf("unexpected freshness rows: %v", rows)
+	}
+
+	if _, err := query("Missing | take 1"); err == nil || !strings.Contains(err.Error(), "could not be resolved") {
+		t.Errorf("expected unknown table error, got %v", err)
+	}
+
+	e.SetQueryResult("Heartbeat | summarize", EmulatedTable{
+		Columns: []EmulatedColumn{{Name: "Count", Type: "long"}},
+		Rows:    [][]any{{float64(3)}},
+	})
+	res, err = query("Heartbeat | summarize Count=count()")
+	if err != nil || len(res.Tables[0].Rows) != 1 || res.Tables[0].Rows[0][0] != float64(3) {
+		t.Errorf("expected canned result, got %v (err %v)", res.Tables, err)
+	}
+	if got := len(e.Queries()); got != 4 {
+		t.Errorf("expected 4 recorded queries, got %d", got)
+	}
+
+	res, err = query("Heartbeat | where TimeGenerated > datetime(2024-01-01T00:10:00Z)")
+	if err != nil || len(res.Tables[0].Rows) != 1 || res.Tables[0].Rows[0][1] != "node-2" {
+		t.Errorf("expected the row after the filter time, got %v (err %v)", res.Tables, err)
+	}
+}
+
+func TestLogAnalyticsEmulatorARM(t *testing.T) {
+	e := newTestEmulator()
+	defer e.Close()
+
+	opts := &arm.ClientOptions{ClientOptions: azcore.ClientOptions{Cloud: e.Cloud(), Transport: e.Client()}}
+	wcli, err := armoperationalinsights.NewWorkspacesClient(e.SubscriptionID, e.Credential(), opts)
+	if err != nil {
+		t.Fatalf("workspaces client: %v", err)
+	}
+	w, err := wcli.Get(context.Background(), e.ResourceGroup, e.WorkspaceName, nil)
+	if err != nil {
+		t.Fatalf("get workspace: %v", err)
+	}
+	if w.Properties == nil || w.Properties.CustomerID == nil || *w.Properties.CustomerID != e.WorkspaceGUID {
+		t.Errorf("unexpected workspace: %+v", w)
+	}
+
+	tcli, err := armoperationalinsights.NewTablesClient(e.SubscriptionID, e.Credential(), opts)
+	if err != nil {
+		t.Fatalf("tables client: %v", err)
+	}
+	tab, err := tcli.Get(context.Background(), e.ResourceGroup, e.WorkspaceName, "Heartbeat", nil)
+	if err != nil {
+		t.Fatalf("get table: %v", err)
+	}
+	if tab.Name == nil || *tab.Name != "Heartbeat" || tab.Properties == nil || tab.Properties.RetentionInDays == nil {
+		t.Errorf("unexpected table: %+v", tab.Table)
+	}
+	pager := tcli.NewListByWorkspacePager(e.ResourceGroup, e.WorkspaceName, nil)
+	page, err := pager.NextPage(context.Background())
+	if err != nil || len(page.Value) != 1 {
+		t.Errorf("expected one listed table, got %v (err %v)", page.Value, err)
+	}
+
+	e.Deny(ActionTablesRead)
+	var re *azcore.ResponseError
+	if _, err := tcli.Get(context.Background(), e.ResourceGroup, e.WorkspaceName, "Heartbeat", nil); !errors.As(err, &re) || re.StatusCode != http.StatusForbidden || re.ErrorCode != "AuthorizationFailed" {
+		t.Errorf("expected a denied table read, got %v", err)
+	}
+	if _, err := wcli.Get(context.Background(), e.ResourceGroup, e.WorkspaceName, nil); err != nil {
+		t.Errorf("expected the workspace read to stay allowed, got %v", err)
+	}
+}
+
+func TestLogAnalyticsEmulatorBatch(t *testing.T) {
+	e := newTestEmulator()
+	defer e.Close()
+
+	opts := &azquery.LogsClientOptions{ClientOptions: azcore.ClientOptions{Cloud: e.Cloud(), Transport: e.Client()}}
+	lcli, err := azquery.NewLogsClient(e.Credential(), opts)
+	if err != nil {
+		t.Fatalf("logs client: %v", err)
+	}
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	span := azquery.NewTimeInterval(start, start.Add(time.Hour))
+	res, err := lcli.QueryBatch(context.Background(), azquery.BatchRequest{Requests: []*azquery.BatchQueryRequest{
+		{Body: &azquery.Body{Query: to.Ptr("Heartbeat"), Timespan: &span}, CorrelationID: to.Ptr("a"), WorkspaceID: to.Ptr(e.WorkspaceGUID)},
+		{Body: &azquery.Body{Query: to.Ptr("Missing"), Timespan: &span}, CorrelationID: to.Ptr("b"), WorkspaceID: to.Ptr(e.WorkspaceGUID)},
+	}}, nil)
+	if err != nil {
+		t.Fatalf("batch query failed: %v", err)
+	}
+	if len(res.Responses) != 2 {
+		t.Fatalf("expected 2 responses, got %d", len(res.Responses))
+	}
+	for _, r := range res.Responses {
+		switch *r.CorrelationID {
+		case "a":
+			if *r.Status != 200 || len(r.Body.Tables[0].Rows) != 2 {
+				t.Errorf("unexpected response for a: status %d", *r.Status)
+			}
+		case "b":
+			if *r.Status != 400 || r.Body.Error == nil {
+				t.Errorf("expected error response for b, got status %d", *r.Status)
+			}
+		}
+	}
+	if e.Batches() != 1 {
+		t.Errorf("expected 1 batch, got %d", e.Batches())
+	}
+}
@@ -0,0 +1,231 @@
+package utils
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"errors"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+)
+
+// Sink receives the files of a bundle in the order they are written: an
+// archive, a directory or a remote store.
+type Sink interface {
+	// WriteFile stores the content of r as the file at path, a relative
+	// slash-separated path normalized with TarPath. The readers a TarWriter
+	// passes have a Size method returning their length, for sinks that
+	// need it up front.
+	WriteFile(path string, r io.Reader) error
+	// Close finishes the output, e.g. writes the end of an archive. It does
+	// not close the writer the sink was created with.
+	Close() error
+}
+
+// sizedReader is a reader of size bytes.
+type sizedReader struct {
+	io.Reader
+	size int64
+}
+
+func (r *sizedReader) Size() int64 {
+	return r.size
+}
+
+// readerSize returns the length of r when it has a Size method, as the
+// readers of a TarWriter and bytes.Reader do.
+func readerSize(r io.Reader) (int64, bool) {
+	if s, ok := r.(interface{ Size() int64 }); ok {
+		return s.Size(), true
+	}
+	return 0, false
+}
+
+// ustarNameLen is the size of the name field of a ustar header.
+const ustarNameLen = 100
+
+// Owner of every archive entry, so archives do not depend on who gathered them.
+const (
+	tarUID   = 0
+	tarGID   = 0
+	tarOwner = "root"
+)
+
+// tarSink writes a tar stream whose entries all have the same owner and
+// modification time, each file preceded by entries for the directories it is
+// in. Archives written from the same data are byte for byte the same, and
+// strict tar implementations extract them without synthesizing directories.
+type tarSink struct {
+	tw      *tar.Writer
+	modTime time.Time
+	dirs    map[string]bool
+	// gz is the compressor of a tar.gz stream.
+	gz *gzip.Writer
+}
+
+// NewTarSink returns a Sink writing a tar stream to w whose entries carry
+// modTime, e.g. the start of the gather, or the current time when it is zero.
+func NewTarSink(w io.Writer, modTime time.Time) Sink {
+	if modTime.IsZero() {
+		modTime = time.Now()
+	}
+	return &tarSink{
+		tw: tar.NewWriter(w),
+		// Whole seconds, so PAX headers do not carry a sub-second mtime
+		modTime: modTime.UTC().Truncate(time.Second),
+		dirs:    map[string]bool{},
+	}
+}
+
+// NewTarGzSink returns a Sink writing a gzip-compressed tar stream to w, as
+// NewTarSink does.
+func NewTarGzSink(w io.Writer, modTime time.Time) Sink {
+	gz := gzip.NewWriter(w)
+	s := NewTarSink(gz, modTime).(*tarSink)
+	s.gz = gz
+	return s
+}
+
+// WriteFile writes the entry of a file at name after the entries of any of
+// its directories not written yet. Readers without a Size method are spooled
+// to a temporary file first, because tar needs the entry size up front.
+func (s *tarSink) WriteFile(name string, r io.Reader) error {
+	size, ok := readerSize(r)
+	if !ok {
+		return spoolSized(r, func(r io.Reader) error { return s.WriteFile(name, r) })
+	}
+	var missing []string
+	for dir := path.Dir(name); dir != "." && !s.dirs[dir]; dir = path.Dir(dir) {
+		missing = append(missing, dir)
+	}
+	for i := len(missing) - 1; i >= 0; i-- {
+		dir := &tar.Header{Name: missing[i] + "/", Typeflag: tar.TypeDir, Mode: 0755}
+		if err := s.writeEntry(dir); err != nil {
+			return err
+		}
+		s.dirs[missing[i]] = true
+	}
+	if err := s.writeEntry(&tar.Header{Name: name, Mode: 0644, Size: size}); err != nil {
+		return err
+	}
+	_, err := io.CopyN(s.tw, r, size)
+	return err
+}
+
+// writeEntry writes hdr with the archive's owner and modification time.
+// Names too long for a ustar header get a PAX header rather than the ustar
+// prefix split, which cannot hold every long pod and container name.
+func (s *tarSink) writeEntry(hdr *tar.Header) error {
+	hdr.Uid, hdr.Gid = tarUID, tarGID
+	hdr.Uname, hdr.Gname = tarOwner, tarOwner
+	hdr.ModTime = s.modTime
+	if len(hdr.Name) > ustarNameLen {
+		hdr.Format = tar.FormatPAX
+	}
+	return s.tw.WriteHeader(hdr)
+}
+
+// Close writes the end of the archive and flushes the compressor.
+func (s *tarSink) Close() error {
+	err := s.tw.Close()
+	if s.gz != nil {
+		err = errors.Join(err, s.gz.Close())
+	}
+	return err
+}
+
+// spoolSized copies r to a temporary file and calls fn with a reader of it
+// that has a Size method.
+func spoolSized(r io.Reader, fn func(io.Reader) error) error {
+	spool, err := NewTarSpool()
+	if err != nil {
+		return err
+	}
+	defer spool.Close()
+	if _, err := io.Copy(spool, r); err != nil {
+		return err
+	}
+	if err := spool.w.Flush(); err != nil {
+		return err
+	}
+	size, err := spool.f.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	if _, err := spool.f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	return fn(&sizedReader{Reader: spool.f, size: size})
+}
+
+// zipSink writes a zip archive whose entries all carry the same modification
+// time, so archives written from the same data are the same.
+type zipSink struct {
+	zw      *zip.Writer
+	modTime time.Time
+}
+
+// NewZipSink returns a Sink writing a zip archive to w whose entries carry
+// modTime, or the current time when it is zero.
+func NewZipSink(w io.Writer, modTime time.Time) Sink {
+	if modTime.IsZero() {
+		modTime = time.Now()
+	}
+	return &zipSink{zw: zip.NewWriter(w), modTime: modTime.UTC().Truncate(time.Second)}
+}
+
+// WriteFile writes the deflated entry of a file at name.
+func (s *zipSink) WriteFile(name string, r io.Reader) error {
+	w, err := s.zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: s.modTime})
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, r)
+	return err
+}
+
+// Close writes the central directory of the archive.
+func (s *zipSink) Close() error {
+	return s.zw.Close()
+}
+
+// dirSink writes each file below a local directory.
+type dirSink struct {
+	dir string
+}
+
+// NewDirSink returns a Sink writing each file below dir, creating it. Files
+// already in dir are overwritten.
+func NewDirSink(dir string) (Sink, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &dirSink{dir: dir}, nil
+}
+
+// WriteFile creates the file at name below the directory, and the
+// directories it is in. Names escaping the directory are rejected.
+func (s *dirSink) WriteFile(name string, r io.Reader) (err error) {
+	if name, err = TarPath(name); err != nil {
+		return err
+	}
+	p := filepath.Join(s.dir, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+		return err
+	}
+	f, err := os.Create(p)
+	if err != nil {
+		return err
+	}
+	defer func() { err = errors.Join(err, f.Close()) }()
+	_, err = io.Copy(f, r)
+	return err
+}
+
+// Close does nothing: every file is closed once written.
+func (s *dirSink) Close() error {
+	return nil
+}
@@ -0,0 +1,149 @@
+package utils
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeSample writes the same files to s through a TarWriter.
+func writeSample(t *testing.T, s Sink) []TarFile {
+	t.Helper()
+	tw := NewSinkWriter(s)
+	if err := WriteFileToTar(tw, "index.json", []byte("{}")); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteStreamToTar(tw, "tables/Heartbeat/parts/0000.ndjson", strings.NewReader("{\"a\":1}\n")); err != nil {
+		t.Fatal(err)
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return tw.Files()
+}
+
+func TestZipSink(t *testing.T) {
+	start := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	var buf bytes.Buffer
+	files := writeSample(t, NewZipSink(&buf, start))
+	if len(files) != 2 || files[1].Size != 8 || files[1].SHA256 == "" {
+		t.Errorf("unexpected files %v", files)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, f := range zr.File {
+		names = append(names, f.Name)
+		if !f.Modified.Equal(start) {
+			t.Errorf("%s: unexpected mtime %v", f.Name, f.Modified)
+		}
+	}
+	if strings.Join(names, " ") != "index.json tables/Heartbeat/parts/0000.ndjson" {
+		t.Errorf("unexpected entries %v", names)
+	}
+	rc, _ := zr.File[1].Open()
+	b, _ := io.ReadAll(rc)
+	if string(b) != "{\"a\":1}\n" {
+		t.Errorf("unexpected content %q", b)
+	}
+}
+
+func TestDirSink(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "bundle")
+	s, err := NewDirSink(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeSample(t, s)
+	b, err := os.ReadFile(filepath.Join(dir, "tables", "Heartbeat", "parts", "0000.ndjson"))
+	if err != nil || string(b) != "{\"a\":1}\n" {
+		t.Errorf("unexpected part %q, %v", b, err)
+	}
+	if err := s.WriteFile("../escape.txt", strings.NewReader("x")); err == nil {
+		t.Error("expected a path escaping the directory to be rejected")
+	}
+}
+
+func TestTarSinkSpoolsUnsizedReaders(t *testing.T) {
+	var buf bytes.Buffer
+	s := NewTarGzSink(&buf, time.Time{})
+	// A reader without a Size method is spooled to learn its length
+	if err := s.WriteFile("a/b.txt", io.MultiReader(strings.NewReader("hello "), strings.NewReader("world"))); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	files := readTarGz(t, buf.Bytes())
+	if files["a/b.txt"] != "hello world" {
+		t.Errorf("unexpected archive %v", files)
+	}
+}
+
+// readTarGz returns the files of a tar.gz by name.
+func readTarGz(t *testing.T, b []byte) map[string]string {
+	t.Helper()
+	gz, err := gzip.NewReader(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{}
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return files
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hdr.Typeflag == tar.TypeReg {
+			data, _ := io.ReadAll(tr)
+			files[hdr.Name] = string(data)
+		}
+	}
+}
+
+// failingSink fails to write the files named in fail.
+type failingSink struct {
+	fail   string
+	closed bool
+}
+
+func (s *failingSink) WriteFile(path string, r io.Reader) error {
+	if path == s.fail {
+		return io.ErrUnexpectedEOF
+	}
+	_, err := io.Copy(io.Discard, r)
+	return err
+}
+
+func (s *failingSink) Close() error {
+	s.closed = true
+	return nil
+}
+
+func TestTarWriterKeepsWriteErrors(t *testing.T) {
+	s := &failingSink{fail: "index.json"}
+	tw := NewSinkWriter(s)
+	_ = WriteFileToTar(tw, "index.json", []byte("{}"))
+	if err := WriteFileToTar(tw, "SUMMARY.md", []byte("# Summary")); err != nil {
+		t.Fatal(err)
+	}
+	if err := tw.Err(); err == nil || !strings.Contains(err.Error(), "index.json") {
+		t.Errorf("Err = %v, want the error writing index.json", err)
+	}
+	if err := tw.Close(); !errors.Is(err, io.ErrUnexpectedEOF) || !s.closed {
+		t.Errorf("Close = %v (closed %v), want the write error after closing the sink", err, s.closed)
+	}
+}
@@ -0,0 +1,58 @@
+package utils
+
+import "testing"
+
+func TestParseByteSize(t *testing.T) {
+	tests := []struct {
+		name        string
+		input       string
+		expected    int64
+		expectError bool
+	}{
+		{name: "plain bytes", input: "1048576", expected: 1 << 20},
+		{name: "decimal unit", input: "500MB", expected: 500 * 1000 * 1000},
+		{name: "binary unit", input: "2GiB", expected: 2 << 30},
+		{name: "lowercase with space", input: "1.5 kib", expected: 1536},
+		{name: "bytes suffix", input: "10B", expected: 10},
+		{name: "empty", input: "", expectError: true},
+		{name: "unknown unit", input: "5PB", expectError: true},
+		{name: "no number", input: "MB", expectError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseByteSize(tt.input)
+			if tt.expectError {
+				if err == nil {
+					t.Errorf("expected error for %q, got %d", tt.input, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tt.expected {
+				t.Errorf("ParseByteSize(%q) = %d, want %d", tt.input, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestFormatByteSize(t *testing.T) {
+	tests := []struct {
+		input    int64
+		expected string
+	}{
+		{0, "0 B"},
+		{1023, "1023 B"},
+		{1536, "1.5 KiB"},
+		{5 << 20, "5.0 MiB"},
+		{3 << 30, "3.0 GiB"},
+		{2048 << 40, "2048.0 TiB"},
+	}
+	for _, tt := range tests {
+		if got := FormatByteSize(tt.input); got != tt.expected {
+			t.Errorf("FormatByteSize(%d) = %q, want %q", tt.input, got, tt.expected)
+		}
+	}
+}
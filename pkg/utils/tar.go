@@ -1,31 +1,196 @@
 package utils
 
 import (
-	"archive/tar"
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
 	"io"
+	"os"
+	"path"
+	"strings"
 	"time"
 )
 
-func WriteFileToTar(tw *tar.Writer, path string, data []byte) error {
-	hdr := &tar.Header{
-		Name:    path,
-		Mode:    0644,
-		Size:    int64(len(data)),
-		ModTime: time.Now(),
+// TarWriter writes the files of a bundle to a Sink, by default a tar stream
+// (see NewTarSink), recording the path, size and SHA-256 of each file.
+type TarWriter struct {
+	sink  Sink
+	files []TarFile
+	// OnFile, when set, is called with each file once its content is
+	// written: when the next entry starts, or on Files or Close.
+	OnFile func(TarFile)
+	// notified counts the files passed to OnFile.
+	notified int
+	// err is the first error of the sink writing a file.
+	err error
+}
+
+// TarFile is a file written to a TarWriter, with the SHA-256 of its content.
+type TarFile struct {
+	Path   string `json:"path"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// NewTarWriter returns a TarWriter writing a tar stream to w whose entries
+// carry modTime, e.g. the start of the gather, or the current time when it
+// is zero.
+func NewTarWriter(w io.Writer, modTime time.Time) *TarWriter {
+	return NewSinkWriter(NewTarSink(w, modTime))
+}
+
+// NewSinkWriter returns a TarWriter writing to s.
+func NewSinkWriter(s Sink) *TarWriter {
+	return &TarWriter{sink: s}
+}
+
+// Files lists the files written so far, in order.
+func (tw *TarWriter) Files() []TarFile {
+	tw.notify()
+	return append([]TarFile(nil), tw.files...)
+}
+
+// notify passes the files written so far to OnFile.
+func (tw *TarWriter) notify() {
+	if tw.OnFile != nil {
+		for ; tw.notified < len(tw.files); tw.notified++ {
+			tw.OnFile(tw.files[tw.notified])
+		}
+	}
+}
+
+// Err returns the first error of the sink writing a file, if any.
+func (tw *TarWriter) Err() error {
+	return tw.err
+}
+
+// Close finishes the last file and closes the sink. It also returns the
+// first error writing a file, so a bundle missing files is not reported
+// complete by callers that did not check every write.
+func (tw *TarWriter) Close() error {
+	tw.notify()
+	return errors.Join(tw.err, tw.sink.Close())
+}
+
+// writeFile writes the size bytes of r to the sink at name, normalized with
+// TarPath, hashing them.
+func (tw *TarWriter) writeFile(name string, size int64, r io.Reader) error {
+	p, err := TarPath(name)
+	if err != nil {
+		return err
+	}
+	tw.notify()
+	sum := sha256.New()
+	if err := tw.sink.WriteFile(p, &sizedReader{Reader: io.TeeReader(io.LimitReader(r, size), sum), size: size}); err != nil {
+		if tw.err == nil {
+			tw.err = fmt.Errorf("write %s: %w", p, err)
+		}
+		return err
+	}
+	tw.files = append(tw.files, TarFile{Path: p, Size: size, SHA256: hex.EncodeToString(sum.Sum(nil))})
+	return nil
+}
+
+// TarPath normalizes name for use as an archive entry: backslashes become
+// forward slashes whatever the OS, leading slashes and drive letters are
+// dropped and the path is cleaned. Names that would still point outside the
+// archive root through ".." segments, or name nothing, are rejected.
+func TarPath(name string) (string, error) {
+	p := strings.ReplaceAll(name, `\`, "/")
+	if len(p) >= 2 && p[1] == ':' && ('a' <= p[0] && p[0] <= 'z' || 'A' <= p[0] && p[0] <= 'Z') {
+		p = p[2:]
+	}
+	p = path.Clean(strings.TrimLeft(p, "/"))
+	if p == "." || p == "" {
+		return "", fmt.Errorf("invalid archive path %q: names no file", name)
+	}
+	if p == ".." || strings.HasPrefix(p, "../") {
+		return "", fmt.Errorf("invalid archive path %q: escapes the archive root", name)
+	}
+	return p, nil
+}
+
+// WriteFileToTar adds data to tw as a file at path, normalized with TarPath.
+func WriteFileToTar(tw *TarWriter, path string, data []byte) error {
+	return tw.writeFile(path, int64(len(data)), bytes.NewReader(data))
+}
+
+// WriteStreamToTar copies r into the archive. The stream is spooled to a
+// temporary file first because tar needs the entry size up front.
+func WriteStreamToTar(tw *TarWriter, path string, r io.Reader) error {
+	spool, err := NewTarSpool()
+	if err != nil {
+		return err
 	}
-	if err := tw.WriteHeader(hdr); err != nil {
+	defer spool.Close()
+	if _, err := io.Copy(spool, r); err != nil {
 		return err
 	}
-	_, err := tw.Write(data)
-	return err
+	return spool.WriteToTar(tw, path)
 }
 
-func WriteStreamToTar(tw *tar.Writer, path string, r io.Reader) error {
-	// Stream to a temp buffer to get size? Tar needs size up-front; so we buffer in memory for now.
-	// For large outputs, consider chunk files.
-	buf, err := io.ReadAll(r)
+// TarSpool buffers a tar entry on local disk until its size is known, so large
+// entries can be produced incrementally without holding them in memory.
+type TarSpool struct {
+	f *os.File
+	w *bufio.Writer
+}
+
+// NewTarSpool creates an empty spool in the system temp directory. Close
+// removes it.
+func NewTarSpool() (*TarSpool, error) {
+	f, err := os.CreateTemp("", "aks-must-gather-spool-")
+	if err != nil {
+		return nil, err
+	}
+	return &TarSpool{f: f, w: bufio.NewWriterSize(f, 256<<10)}, nil
+}
+
+func (s *TarSpool) Write(p []byte) (int, error) {
+	return s.w.Write(p)
+}
+
+// WriteToTar adds everything written so far to tw as path.
+func (s *TarSpool) WriteToTar(tw *TarWriter, path string) error {
+	if _, err := TarPath(path); err != nil {
+		return err
+	}
+	if err := s.w.Flush(); err != nil {
+		return err
+	}
+	size, err := s.f.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	if _, err := s.f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	return tw.writeFile(path, size, s.f)
+}
+
+// Close removes the spool file. Closing a nil spool does nothing.
+func (s *TarSpool) Close() error {
+	if s == nil {
+		return nil
+	}
+	s.f.Close()
+	return os.Remove(s.f.Name())
+}
+
+// WriteLocalFileToTar copies a file from local disk into the archive without
+// loading it into memory.
+func WriteLocalFileToTar(tw *TarWriter, path, src string) error {
+	f, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
 	if err != nil {
 		return err
 	}
-	return WriteFileToTar(tw, path, buf)
+	return tw.writeFile(path, fi.Size(), f)
 }
@@ -5,6 +5,8 @@ import (
 	"bytes"
 	"compress/gzip"
 	"io"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 	"time"
@@ -46,7 +48,7 @@ func TestWriteFileToTar(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			var buf bytes.Buffer
-			tw := tar.NewWriter(&buf)
+			tw := NewTarWriter(&buf, time.Time{})
 
 			err := WriteFileToTar(tw, tt.path, tt.data)
 			if err != nil {
@@ -60,7 +62,7 @@ func TestWriteFileToTar(t *testing.T) {
 
 			// Read back the tar and verify
 			tr := tar.NewReader(&buf)
-			header, err := tr.Next()
+			header, err := nextFile(tr)
 			if err != nil {
 				t.Fatalf("Failed to read tar header: %v", err)
 			}
@@ -131,7 +133,7 @@ func TestWriteStreamToTar(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			var buf bytes.Buffer
-			tw := tar.NewWriter(&buf)
+			tw := NewTarWriter(&buf, time.Time{})
 
 			reader := strings.NewReader(tt.content)
 			err := WriteStreamToTar(tw, tt.path, reader)
@@ -146,7 +148,7 @@ func TestWriteStreamToTar(t *testing.T) {
 
 			// Read back the tar and verify
 			tr := tar.NewReader(&buf)
-			header, err := tr.Next()
+			header, err := nextFile(tr)
 			if err != nil {
 				t.Fatalf("Failed to read tar header: %v", err)
 			}
@@ -174,7 +176,7 @@ func TestWriteStreamToTar(t *testing.T) {
 
 func TestWriteStreamToTarErrorHandling(t *testing.T) {
 	var buf bytes.Buffer
-	tw := tar.NewWriter(&buf)
+	tw := NewTarWriter(&buf, time.Time{})
 
 	// Create a reader that will fail
 	errorReader := &errorReader{}
@@ -191,7 +193,7 @@ func TestWriteStreamToTarErrorHandling(t *testing.T) {
 
 func TestWriteFileToTarMultipleFiles(t *testing.T) {
 	var buf bytes.Buffer
-	tw := tar.NewWriter(&buf)
+	tw := NewTarWriter(&buf, time.Time{})
 
 	files := map[string][]byte{
 		"file1.txt":         []byte("content1"),
@@ -217,7 +219,7 @@ func TestWriteFileToTarMultipleFiles(t *testing.T) {
 	foundFiles := make(map[string]string)
 
 	for {
-		header, err := tr.Next()
+		header, err := nextFile(tr)
 		if err == io.EOF {
 			break
 		}
@@ -250,7 +252,7 @@ func TestTarGzipIntegration(t *testing.T) {
 	// Test that our tar functions work with gzip compression
 	var buf bytes.Buffer
 	gzw := gzip.NewWriter(&buf)
-	tw := tar.NewWriter(gzw)
+	tw := NewTarWriter(gzw, time.Time{})
 
 	testData := []byte("test content for gzip integration")
 	err := WriteFileToTar(tw, "test.txt", testData)
@@ -276,7 +278,7 @@ func TestTarGzipIntegration(t *testing.T) {
 	defer gzr.Close()
 
 	tr := tar.NewReader(gzr)
-	header, err := tr.Next()
+	header, err := nextFile(tr)
 	if err != nil {
 		t.Fatalf("Failed to read tar header: %v", err)
 	}
@@ -295,9 +297,296 @@ func TestTarGzipIntegration(t *testing.T) {
 	}
 }
 
+func TestWriteLocalFileToTar(t *testing.T) {
+	src := filepath.Join(t.TempDir(), "spill.log")
+	content := strings.Repeat("line\n", 10000)
+	if err := os.WriteFile(src, []byte(content), 0o600); err != nil {
+		t.Fatalf("write source: %v", err)
+	}
+
+	var buf bytes.Buffer
+	tw := NewTarWriter(&buf, time.Time{})
+	if err := WriteLocalFileToTar(tw, "logs/app.log", src); err != nil {
+		t.Fatalf("WriteLocalFileToTar failed: %v", err)
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatalf("Failed to close tar writer: %v", err)
+	}
+
+	tr := tar.NewReader(&buf)
+	header, err := nextFile(tr)
+	if err != nil {
+		t.Fatalf("Failed to read tar header: %v", err)
+	}
+	if header.Name != "logs/app.log" || header.Size != int64(len(content)) {
+		t.Errorf("unexpected header %q size %d", header.Name, header.Size)
+	}
+	got, _ := io.ReadAll(tr)
+	if string(got) != content {
+		t.Error("content mismatch")
+	}
+
+	if err := WriteLocalFileToTar(NewTarWriter(io.Discard, time.Time{}), "x", filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Error("expected error for missing source file")
+	}
+}
+
 // errorReader is a helper that always returns an error when read
 type errorReader struct{}
 
 func (r *errorReader) Read(p []byte) (n int, err error) {
 	return 0, io.ErrUnexpectedEOF
 }
+
+func TestTarSpool(t *testing.T) {
+	spool, err := NewTarSpool()
+	if err != nil {
+		t.Fatalf("NewTarSpool failed: %v", err)
+	}
+	name := spool.f.Name()
+	// Larger than the write buffer, so part of it is on disk before WriteToTar
+	line := strings.Repeat("x", 1023) + "\n"
+	for i := 0; i < 512; i++ {
+		if _, err := spool.Write([]byte(line)); err != nil {
+			t.Fatalf("Write failed: %v", err)
+		}
+	}
+
+	var buf bytes.Buffer
+	tw := NewTarWriter(&buf, time.Time{})
+	if err := spool.WriteToTar(tw, "big.ndjson"); err != nil {
+		t.Fatalf("WriteToTar failed: %v", err)
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatalf("Failed to close tar writer: %v", err)
+	}
+	if err := spool.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	if _, err := os.Stat(name); !os.IsNotExist(err) {
+		t.Errorf("expected spool file to be removed, stat err = %v", err)
+	}
+
+	tr := tar.NewReader(&buf)
+	hdr, err := nextFile(tr)
+	if err != nil {
+		t.Fatalf("Failed to read tar header: %v", err)
+	}
+	if hdr.Name != "big.ndjson" || hdr.Size != 512*1024 {
+		t.Errorf("unexpected header %s size %d", hdr.Name, hdr.Size)
+	}
+	got, _ := io.ReadAll(tr)
+	if string(got) != strings.Repeat(line, 512) {
+		t.Error("spooled content mismatch")
+	}
+}
+
+func TestTarPath(t *testing.T) {
+	tests := []struct {
+		name    string
+		want    string
+		wantErr bool
+	}{
+		{name: "tables/Heartbeat/part-000001.ndjson", want: "tables/Heartbeat/part-000001.ndjson"},
+		{name: "/metadata/run.json", want: "metadata/run.json"},
+		{name: "//abs//path/", want: "abs/path"},
+		{name: `namespaces\default\pods\web-1\nginx.log`, want: "namespaces/default/pods/web-1/nginx.log"},
+		{name: `C:\bundle\index.json`, want: "bundle/index.json"},
+		{name: "./a/./b", want: "a/b"},
+		{name: "a/../b", want: "b"},
+		{name: "/../etc/passwd", wantErr: true},
+		{name: "a/../../etc/passwd", wantErr: true},
+		{name: `..\evil`, wantErr: true},
+		{name: "..", wantErr: true},
+		{name: "", wantErr: true},
+		{name: "/", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := TarPath(tt.name)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("TarPath(%q) = %q, want an error", tt.name, got)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("TarPath(%q) = %q, %v; want %q", tt.name, got, err, tt.want)
+		}
+	}
+}
+
+func TestWriteFileToTarNormalizesPaths(t *testing.T) {
+	var buf bytes.Buffer
+	tw := NewTarWriter(&buf, time.Time{})
+	if err := WriteFileToTar(tw, `\namespaces\default\events.log`, []byte("x")); err != nil {
+		t.Fatalf("WriteFileToTar failed: %v", err)
+	}
+	if err := WriteFileToTar(tw, "../outside.txt", []byte("x")); err == nil {
+		t.Error("expected a path escaping the archive to be rejected")
+	}
+	spool, err := NewTarSpool()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer spool.Close()
+	if err := spool.WriteToTar(tw, "/../outside.txt"); err == nil {
+		t.Error("expected the spool to reject a path escaping the archive")
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	tr := tar.NewReader(&buf)
+	hdr, err := nextFile(tr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hdr.Name != "namespaces/default/events.log" {
+		t.Errorf("unexpected entry name %q", hdr.Name)
+	}
+	if _, err := nextFile(tr); err != io.EOF {
+		t.Errorf("expected only one entry, got %v", err)
+	}
+}
+
+func TestWriteFileToTarLongNames(t *testing.T) {
+	// Pod and container names are up to 253 characters; no ustar prefix
+	// split can hold a single path segment this long
+	long := "namespaces/default/pods/" + strings.Repeat("p", 253) + "/" + strings.Repeat("c", 63) + ".log"
+
+	var buf bytes.Buffer
+	tw := NewTarWriter(&buf, time.Time{})
+	if err := WriteFileToTar(tw, long, []byte("line\n")); err != nil {
+		t.Fatalf("WriteFileToTar failed: %v", err)
+	}
+	if err := WriteFileToTar(tw, "short.txt", []byte("x")); err != nil {
+		t.Fatalf("WriteFileToTar failed: %v", err)
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	tr := tar.NewReader(&buf)
+	hdr, err := nextFile(tr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hdr.Name != long || hdr.Format != tar.FormatPAX {
+		t.Errorf("expected a PAX entry for the long name, got %q (%v)", hdr.Name, hdr.Format)
+	}
+	if data, _ := io.ReadAll(tr); string(data) != "line\n" {
+		t.Errorf("unexpected content %q", data)
+	}
+	if hdr, err = nextFile(tr); err != nil || hdr.Format != tar.FormatUSTAR {
+		t.Errorf("expected a ustar entry for the short name, got %v, %v", hdr, err)
+	}
+}
+
+// nextFile returns the next entry of tr that is not a directory.
+func nextFile(tr *tar.Reader) (*tar.Header, error) {
+	for {
+		hdr, err := tr.Next()
+		if err != nil || hdr.Typeflag != tar.TypeDir {
+			return hdr, err
+		}
+	}
+}
+
+func TestTarWriterDirectoriesAndMetadata(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 500, time.FixedZone("CET", 3600))
+	write := func() []byte {
+		var buf bytes.Buffer
+		tw := NewTarWriter(&buf, start)
+		for _, p := range []string{"index.json", "tables/Heartbeat/summary.json", "tables/Heartbeat/parts/part-000001.ndjson", "tables/Perf/summary.json"} {
+			if err := WriteFileToTar(tw, p, []byte("{}")); err != nil {
+				t.Fatalf("WriteFileToTar failed: %v", err)
+			}
+		}
+		if err := tw.Close(); err != nil {
+			t.Fatal(err)
+		}
+		return buf.Bytes()
+	}
+	first := write()
+	if !bytes.Equal(first, write()) {
+		t.Error("expected identical archives from identical input")
+	}
+
+	var names []string
+	tr := tar.NewReader(bytes.NewReader(first))
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, hdr.Name)
+		if !hdr.ModTime.Equal(start.Truncate(time.Second)) || hdr.Uid != 0 || hdr.Gid != 0 || hdr.Uname != "root" || hdr.Gname != "root" {
+			t.Errorf("%s: unexpected metadata mtime %v uid %d gid %d owner %s:%s", hdr.Name, hdr.ModTime, hdr.Uid, hdr.Gid, hdr.Uname, hdr.Gname)
+		}
+		if wantDir := strings.HasSuffix(hdr.Name, "/"); wantDir != (hdr.Typeflag == tar.TypeDir) {
+			t.Errorf("%s: unexpected type %c", hdr.Name, hdr.Typeflag)
+		} else if wantDir && hdr.Mode != 0755 {
+			t.Errorf("%s: expected mode 0755, got %o", hdr.Name, hdr.Mode)
+		}
+	}
+	want := []string{
+		"index.json",
+		"tables/", "tables/Heartbeat/", "tables/Heartbeat/summary.json",
+		"tables/Heartbeat/parts/", "tables/Heartbeat/parts/part-000001.ndjson",
+		"tables/Perf/", "tables/Perf/summary.json",
+	}
+	if strings.Join(names, " ") != strings.Join(want, " ") {
+		t.Errorf("unexpected entries\n got: %v\nwant: %v", names, want)
+	}
+
+	var buf bytes.Buffer
+	tw := NewTarWriter(&buf, start)
+	_ = WriteFileToTar(tw, "a/b.json", []byte("{}"))
+	_ = WriteFileToTar(tw, "c.txt", []byte("hello"))
+	// SHA-256 of "{}" and "hello"
+	want0 := TarFile{"a/b.json", 2, "44136fa355b3678a1146ad16f7e8649e94fb4fc21fe77e8310c060f61caaff8a"}
+	want1 := TarFile{"c.txt", 5, "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824"}
+	if got := tw.Files(); len(got) != 2 || got[0] != want0 || got[1] != want1 {
+		t.Errorf("unexpected files %v", got)
+	}
+	spool, err := NewTarSpool()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer spool.Close()
+	_, _ = spool.Write([]byte("hello"))
+	if err := spool.WriteToTar(tw, "d.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if got := tw.Files(); len(got) != 3 || got[2].SHA256 != want1.SHA256 {
+		t.Errorf("expected spooled files to be hashed too, got %v", got)
+	}
+}
+
+func TestTarWriterOnFile(t *testing.T) {
+	var buf bytes.Buffer
+	tw := NewTarWriter(&buf, time.Time{})
+	var got []TarFile
+	tw.OnFile = func(f TarFile) { got = append(got, f) }
+	_ = WriteFileToTar(tw, "a/b.json", []byte("{}"))
+	if len(got) != 0 {
+		t.Errorf("expected no file before it is complete, got %v", got)
+	}
+	_ = WriteFileToTar(tw, "c.txt", []byte("hello"))
+	if len(got) != 1 || got[0].Path != "a/b.json" || got[0].SHA256 == "" {
+		t.Errorf("expected the first file once the second starts, got %v", got)
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || got[1] != (TarFile{"c.txt", 5, "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824"}) {
+		t.Errorf("expected the last file on Close, got %v", got)
+	}
+	tw.Files()
+	if len(got) != 2 {
+		t.Errorf("expected each file once, got %v", got)
+	}
+}
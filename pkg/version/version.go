@@ -0,0 +1,115 @@
+// Package version reports the build metadata of aks-must-gather: its
+// version, git commit and build date, stamped by the Makefile through
+//
+//	-ldflags "-X kubectl-must-gather/pkg/version.Version=... -X ...Commit=... -X ...BuildDate=..."
+//
+// and otherwise taken from the Go build info, plus the versions of the Azure
+// SDK modules it was built with.
+package version
+
+import (
+	"runtime"
+	"runtime/debug"
+	"sort"
+	"strings"
+)
+
+// Set at build time with -ldflags -X.
+var (
+	Version   = "dev"
+	Commit    = ""
+	BuildDate = ""
+)
+
+// azureSDKPrefix selects the dependencies reported in Info.AzureSDK.
+const azureSDKPrefix = "github.com/Azure/azure-sdk-for-go/"
+
+// Info is the build metadata, also written to metadata/tool.json in archives.
+type Info struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit,omitempty"`
+	BuildDate string `json:"buildDate,omitempty"`
+	// Modified is set when the binary was built from a tree with local changes.
+	Modified  bool   `json:"modified,omitempty"`
+	GoVersion string `json:"goVersion"`
+	Platform  string `json:"platform"`
+	// AzureSDK maps Azure SDK module paths to their versions.
+	AzureSDK map[string]string `json:"azureSdk,omitempty"`
+}
+
+// Get returns the metadata of the running binary.
+func Get() Info {
+	bi, _ := debug.ReadBuildInfo()
+	return fromBuildInfo(bi)
+}
+
+func fromBuildInfo(bi *debug.BuildInfo) Info {
+	info := Info{
+		Version:   Version,
+		Commit:    Commit,
+		BuildDate: BuildDate,
+		GoVersion: runtime.Version(),
+		Platform:  runtime.GOOS + "/" + runtime.GOARCH,
+	}
+	if bi == nil {
+		return info
+	}
+	// go install of a tagged release records the module version
+	if info.Version == "dev" && bi.Main.Version != "" && bi.Main.Version != "(devel)" {
+		info.Version = bi.Main.Version
+	}
+	for _, s := range bi.Settings {
+		switch {
+		case s.Key == "vcs.revision" && info.Commit == "":
+			info.Commit = s.Value
+		case s.Key == "vcs.time" && info.BuildDate == "":
+			info.BuildDate = s.Value
+		case s.Key == "vcs.modified":
+			info.Modified = s.Value == "true"
+		}
+	}
+	for _, dep := range bi.Deps {
+		if d := dep; strings.HasPrefix(d.Path, azureSDKPrefix) {
+			if d.Replace != nil {
+				d = d.Replace
+			}
+			if info.AzureSDK == nil {
+				info.AzureSDK = map[string]string{}
+			}
+			info.AzureSDK[dep.Path] = d.Version
+		}
+	}
+	return info
+}
+
+// String is the one-line form, e.g. "v1.2.0 (commit abc1234, built 2024-01-01T00:00:00Z)".
+func (i Info) String() string {
+	var details []string
+	if i.Commit != "" {
+		c := i.Commit
+		if len(c) > 12 {
+			c = c[:12]
+		}
+		if i.Modified {
+			c += "-dirty"
+		}
+		details = append(details, "commit "+c)
+	}
+	if i.BuildDate != "" {
+		details = append(details, "built "+i.BuildDate)
+	}
+	if len(details) == 0 {
+		return i.Version
+	}
+	return i.Version + " (" + strings.Join(details, ", ") + ")"
+}
+
+// AzureSDKModules returns the Azure SDK module paths in order.
+func (i Info) AzureSDKModules() []string {
+	mods := make([]string, 0, len(i.AzureSDK))
+	for m := range i.AzureSDK {
+		mods = append(mods, m)
+	}
+	sort.Strings(mods)
+	return mods
+}
@@ -0,0 +1,47 @@
+package version
+
+import (
+	"runtime/debug"
+	"testing"
+)
+
+func TestFromBuildInfo(t *testing.T) {
+	bi := &debug.BuildInfo{
+		Main: debug.Module{Path: "kubectl-must-gather", Version: "(devel)"},
+		Deps: []*debug.Module{
+			{Path: "github.com/Azure/azure-sdk-for-go/sdk/azcore", Version: "v1.13.0"},
+			{Path: "github.com/Azure/azure-sdk-for-go/sdk/monitor/azquery", Version: "v1.1.0", Replace: &debug.Module{Path: "../azquery", Version: "v1.1.1-fork"}},
+			{Path: "github.com/spf13/cobra", Version: "v1.8.0"},
+		},
+		Settings: []debug.BuildSetting{
+			{Key: "vcs.revision", Value: "0123456789abcdef0123"},
+			{Key: "vcs.time", Value: "2024-01-01T00:00:00Z"},
+			{Key: "vcs.modified", Value: "true"},
+		},
+	}
+	info := fromBuildInfo(bi)
+	if info.Version != "dev" || info.Commit != "0123456789abcdef0123" || info.BuildDate != "2024-01-01T00:00:00Z" || !info.Modified {
+		t.Errorf("unexpected info %+v", info)
+	}
+	if len(info.AzureSDK) != 2 || info.AzureSDK["github.com/Azure/azure-sdk-for-go/sdk/monitor/azquery"] != "v1.1.1-fork" {
+		t.Errorf("unexpected Azure SDK modules %v", info.AzureSDK)
+	}
+	if got, want := info.String(), "dev (commit 0123456789ab-dirty, built 2024-01-01T00:00:00Z)"; got != want {
+		t.Errorf("String() = %q, want %q", got, want)
+	}
+
+	// Values stamped with -ldflags win over the build info
+	defer func(v, c string) { Version, Commit = v, c }(Version, Commit)
+	Version, Commit = "v1.2.0", "feedface"
+	bi.Main.Version = "v1.1.0"
+	if info := fromBuildInfo(bi); info.Version != "v1.2.0" || info.Commit != "feedface" {
+		t.Errorf("expected stamped values, got %+v", info)
+	}
+	Version = "dev"
+	if info := fromBuildInfo(bi); info.Version != "v1.1.0" {
+		t.Errorf("expected the module version of a go install, got %q", info.Version)
+	}
+	if info := fromBuildInfo(nil); info.Version != "dev" || info.GoVersion == "" {
+		t.Errorf("unexpected info without build info %+v", info)
+	}
+}